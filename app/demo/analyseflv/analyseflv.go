@@ -13,6 +13,8 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -49,12 +51,14 @@ import (
 //     - 打印每个tag中有多少个帧：SPS PPS SEI IDR SLICE...
 //     - 打印每个SLICE的类型：I、P、B...
 // 解析metadata信息，并打印
+// - 时间线报告（见timeline.go）：
+//     - 输入为flv或ts文件时，输出json格式的报告后退出
+//     - 输入为HTTP-FLV流并且指定了`-o`时，在拉流结束时输出报告
 
 // TODO
 // - 检查时间戳正向大的跳跃
 // - 打印GOP中帧数量？
 // - slice_num?
-// - 输入源可以是httpflv，也可以是flv文件
 
 var (
 	timestampCheckFlag   = true
@@ -73,13 +77,37 @@ var (
 )
 
 func main() {
+	url, reportFilename := parseFlag()
+	format := fileFormatOf(url)
+
 	_ = nazalog.Init(func(option *nazalog.Option) {
 		option.AssertBehavior = nazalog.AssertFatal
+		// 分析文件时报告默认输出到标准输出，减少日志的干扰
+		if format != "" {
+			option.Level = nazalog.LevelWarn
+		}
 	})
 	defer nazalog.Sync()
 	base.LogoutStartInfo()
 
-	url := parseFlag()
+	if format != "" {
+		if reportFilename == "" {
+			reportFilename = "-"
+		}
+		analyse := analyseFlvFile
+		if format == "ts" {
+			analyse = analyseTsFile
+		}
+		r, err := analyse(url)
+		nazalog.Assert(nil, err)
+		nazalog.Assert(nil, writeReport(r, reportFilename))
+		return
+	}
+
+	var timeline *timelineBuilder
+	if reportFilename != "" {
+		timeline = newTimelineBuilder("flv")
+	}
 	session := httpflv.NewPullSession()
 
 	brTotal := bitrate.New(func(option *bitrate.Option) {
@@ -110,6 +138,9 @@ func main() {
 		}
 
 		brTotal.Add(len(tag.Raw))
+		if timeline != nil {
+			timeline.addFlvTag(tag)
+		}
 
 		switch tag.Header.Type {
 		case httpflv.TagTypeMetadata:
@@ -175,6 +206,10 @@ func main() {
 
 	err = <-session.WaitChan()
 	nazalog.Errorf("< session.WaitChan. err=%+v", err)
+
+	if timeline != nil {
+		nazalog.Assert(nil, writeReport(timeline.report(url), reportFilename))
+	}
 }
 
 const (
@@ -263,12 +298,35 @@ func SeiDelayMs(seiNalu []byte) int {
 	return int(d.Nanoseconds() / 1e6)
 }
 
-func parseFlag() string {
-	url := flag.String("i", "", "specify http-flv url")
+// fileFormatOf 输入为本地的flv或ts文件时返回对应的格式，否则返回空字符串
+//
+func fileFormatOf(input string) string {
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		return ""
+	}
+	switch strings.ToLower(filepath.Ext(input)) {
+	case ".flv":
+		return "flv"
+	case ".ts":
+		return "ts"
+	}
+	return ""
+}
+
+func parseFlag() (string, string) {
+	url := flag.String("i", "", "specify http-flv url, or flv/ts file")
+	o := flag.String("o", "", "specify timeline report json file, `-` means stdout. default stdout when input is file")
+	gapMs := flag.Int64("gap_ms", gapThresholdMs, "dts increase greater than this value is reported as a gap")
 	flag.Parse()
 	if *url == "" {
 		flag.Usage()
+		_, _ = fmt.Fprintf(os.Stderr, `Example:
+  %s -i http://127.0.0.1:8080/live/test110.flv
+  %s -i ./lal_record/flv/test110.flv -o report.json
+  %s -i ./lal_record/mpegts/test110.ts
+`, os.Args[0], os.Args[0], os.Args[0])
 		base.OsExitAndWaitPressIfWindows(1)
 	}
-	return *url
+	gapThresholdMs = *gapMs
+	return *url, *o
 }
@@ -0,0 +1,353 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// 时间线报告
+//
+// 对flv、ts文件（或者httpflv流）中的每一路音视频，统计DTS、PTS的连续性、跳变（gap）、每秒的码率以及关键帧间隔的分布，
+// 以json格式输出，用于根据录制文件诊断推流端的问题
+//
+// 注意，ts按PES统计帧数，一个音频PES中可能包含多个aac帧
+//
+
+var (
+	gapThresholdMs             = int64(1000) // 相邻两帧DTS的差值超过该值、或者出现回退时，记录为一个gap
+	keyIntervalHistogramBucket = int64(100)  // 关键帧间隔直方图的精度，单位毫秒
+	maxGapsInReport            = 1000        // 报告中最多记录的gap数量，超过时只计数
+)
+
+type TimelineReport struct {
+	Input      string        `json:"input"`
+	Format     string        `json:"format"` // flv或者ts
+	DurationMs int64         `json:"duration_ms"`
+	Tracks     []TrackReport `json:"tracks"`
+}
+
+type TrackReport struct {
+	Track string `json:"track"` // video或者audio，ts时后面带上pid
+	Codec string `json:"codec"`
+
+	FrameCount int   `json:"frame_count"`
+	TotalBytes int   `json:"total_bytes"`
+	FirstDts   int64 `json:"first_dts"`
+	LastDts    int64 `json:"last_dts"`
+	DurationMs int64 `json:"duration_ms"`
+
+	DtsRollbackCount   int       `json:"dts_rollback_count"`
+	DtsJumpCount       int       `json:"dts_jump_count"`
+	PtsBeforeDtsCount  int       `json:"pts_before_dts_count"`
+	MaxCtsMs           int64     `json:"max_cts_ms"`
+	Gaps               []GapInfo `json:"gaps"`
+	BitrateKbitsPerSec []int     `json:"bitrate_kbits_per_sec"` // 下标为距离第一帧的秒数
+
+	KeyFrameCount             int            `json:"key_frame_count,omitempty"`
+	MinKeyFrameIntervalMs     int64          `json:"min_key_frame_interval_ms,omitempty"`
+	MaxKeyFrameIntervalMs     int64          `json:"max_key_frame_interval_ms,omitempty"`
+	KeyFrameIntervalHistogram map[string]int `json:"key_frame_interval_histogram_ms,omitempty"` // key为按 keyIntervalHistogramBucket 取整后的间隔
+}
+
+type GapInfo struct {
+	FrameIndex int   `json:"frame_index"`
+	PrevDts    int64 `json:"prev_dts"`
+	Dts        int64 `json:"dts"`
+	DiffMs     int64 `json:"diff_ms"`
+}
+
+type trackTimeline struct {
+	r         TrackReport
+	isVideo   bool
+	prevDts   int64
+	prevKeyTs int64
+}
+
+func newTrackTimeline(track string, codec string, isVideo bool) *trackTimeline {
+	return &trackTimeline{
+		r: TrackReport{
+			Track: track,
+			Codec: codec,
+			Gaps:  []GapInfo{},
+		},
+		isVideo:   isVideo,
+		prevKeyTs: -1,
+	}
+}
+
+// add 输入一帧，时间戳单位为毫秒
+//
+func (tt *trackTimeline) add(dts int64, pts int64, size int, key bool) {
+	r := &tt.r
+	if r.FrameCount == 0 {
+		r.FirstDts = dts
+	} else {
+		diff := dts - tt.prevDts
+		if diff < 0 {
+			r.DtsRollbackCount++
+		} else if diff > gapThresholdMs {
+			r.DtsJumpCount++
+		}
+		if diff < 0 || diff > gapThresholdMs {
+			if len(r.Gaps) < maxGapsInReport {
+				r.Gaps = append(r.Gaps, GapInfo{
+					FrameIndex: r.FrameCount,
+					PrevDts:    tt.prevDts,
+					Dts:        dts,
+					DiffMs:     diff,
+				})
+			}
+		}
+	}
+	tt.prevDts = dts
+	r.LastDts = dts
+	r.FrameCount++
+	r.TotalBytes += size
+
+	if cts := pts - dts; cts < 0 {
+		r.PtsBeforeDtsCount++
+	} else if cts > r.MaxCtsMs {
+		r.MaxCtsMs = cts
+	}
+
+	sec := 0
+	if dts > r.FirstDts {
+		sec = int((dts - r.FirstDts) / 1000)
+	}
+	for len(r.BitrateKbitsPerSec) <= sec {
+		r.BitrateKbitsPerSec = append(r.BitrateKbitsPerSec, 0)
+	}
+	// 先按字节累加，输出时再转换
+	r.BitrateKbitsPerSec[sec] += size
+
+	if tt.isVideo && key {
+		r.KeyFrameCount++
+		if tt.prevKeyTs != -1 {
+			interval := dts - tt.prevKeyTs
+			if r.MinKeyFrameIntervalMs == 0 || interval < r.MinKeyFrameIntervalMs {
+				r.MinKeyFrameIntervalMs = interval
+			}
+			if interval > r.MaxKeyFrameIntervalMs {
+				r.MaxKeyFrameIntervalMs = interval
+			}
+			if r.KeyFrameIntervalHistogram == nil {
+				r.KeyFrameIntervalHistogram = make(map[string]int)
+			}
+			bucket := (interval + keyIntervalHistogramBucket/2) / keyIntervalHistogramBucket * keyIntervalHistogramBucket
+			r.KeyFrameIntervalHistogram[strconv.FormatInt(bucket, 10)]++
+		}
+		tt.prevKeyTs = dts
+	}
+}
+
+func (tt *trackTimeline) report() TrackReport {
+	r := tt.r
+	r.DurationMs = r.LastDts - r.FirstDts
+	r.BitrateKbitsPerSec = make([]int, len(tt.r.BitrateKbitsPerSec))
+	for i, bytes := range tt.r.BitrateKbitsPerSec {
+		r.BitrateKbitsPerSec[i] = bytes * 8 / 1000
+	}
+	return r
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type timelineBuilder struct {
+	format string
+	tracks map[string]*trackTimeline
+}
+
+func newTimelineBuilder(format string) *timelineBuilder {
+	return &timelineBuilder{
+		format: format,
+		tracks: make(map[string]*trackTimeline),
+	}
+}
+
+func (b *timelineBuilder) track(name string, codec string, isVideo bool) *trackTimeline {
+	tt, ok := b.tracks[name]
+	if !ok {
+		tt = newTrackTimeline(name, codec, isVideo)
+		b.tracks[name] = tt
+	}
+	return tt
+}
+
+// addFlvTag 忽略metadata和seq header
+//
+func (b *timelineBuilder) addFlvTag(tag httpflv.Tag) {
+	payload := tag.Payload()
+	switch tag.Header.Type {
+	case httpflv.TagTypeVideo:
+		if len(payload) < 5 || tag.IsVideoKeySeqHeader() {
+			return
+		}
+		codec := "unknown"
+		if tag.IsAvc() {
+			codec = base.AvPacketPtAvc.ReadableString()
+		} else if tag.IsHevc() {
+			codec = base.AvPacketPtHevc.ReadableString()
+		}
+		dts := int64(tag.Header.Timestamp)
+		cts := int64(bele.BeUint24(payload[2:]))
+		b.track("video", codec, true).add(dts, dts+cts, int(tag.Header.DataSize), tag.IsVideoKeyNalu())
+	case httpflv.TagTypeAudio:
+		if len(payload) < 2 || tag.IsAacSeqHeader() {
+			return
+		}
+		codec := "unknown"
+		if payload[0]>>4 == base.RtmpSoundFormatAac {
+			codec = base.AvPacketPtAac.ReadableString()
+		}
+		dts := int64(tag.Header.Timestamp)
+		b.track("audio", codec, false).add(dts, dts, int(tag.Header.DataSize), false)
+	}
+}
+
+func (b *timelineBuilder) addTsFrame(pt base.AvPacketPt, frame *mpegts.Frame, u *tsTimestampUnwrapper) {
+	dts := u.unwrap(frame.Pid, frame.Dts) / 90
+	pts := dts + (int64(frame.Pts)-int64(frame.Dts))/90
+	switch pt {
+	case base.AvPacketPtAvc, base.AvPacketPtHevc:
+		key := false
+		_ = avc.IterateNaluAnnexb(frame.Raw, func(nal []byte) {
+			if len(nal) == 0 {
+				return
+			}
+			if pt == base.AvPacketPtAvc {
+				key = key || avc.ParseNaluType(nal[0]) == avc.NaluTypeIdrSlice
+			} else {
+				key = key || hevc.IsIrapNalu(hevc.ParseNaluType(nal[0]))
+			}
+		})
+		name := "video pid=" + strconv.Itoa(int(frame.Pid))
+		b.track(name, pt.ReadableString(), true).add(dts, pts, len(frame.Raw), key)
+	case base.AvPacketPtAac:
+		name := "audio pid=" + strconv.Itoa(int(frame.Pid))
+		b.track(name, pt.ReadableString(), false).add(dts, pts, len(frame.Raw), false)
+	}
+}
+
+func (b *timelineBuilder) report(input string) *TimelineReport {
+	r := &TimelineReport{
+		Input:  input,
+		Format: b.format,
+	}
+	first, last := int64(-1), int64(-1)
+	for _, tt := range b.tracks {
+		tr := tt.report()
+		r.Tracks = append(r.Tracks, tr)
+		if first == -1 || tr.FirstDts < first {
+			first = tr.FirstDts
+		}
+		if tr.LastDts > last {
+			last = tr.LastDts
+		}
+	}
+	sort.Slice(r.Tracks, func(i, j int) bool {
+		return r.Tracks[i].Track > r.Tracks[j].Track
+	})
+	if first != -1 {
+		r.DurationMs = last - first
+	}
+	return r
+}
+
+// tsTimestampUnwrapper ts的时间戳为33位，回绕时加上2^33，使得时间戳连续
+//
+type tsTimestampUnwrapper struct {
+	prev   map[uint16]int64
+	offset map[uint16]int64
+}
+
+const tsTimestampWrap = int64(1) << 33
+
+func newTsTimestampUnwrapper() *tsTimestampUnwrapper {
+	return &tsTimestampUnwrapper{
+		prev:   make(map[uint16]int64),
+		offset: make(map[uint16]int64),
+	}
+}
+
+func (u *tsTimestampUnwrapper) unwrap(pid uint16, ts uint64) int64 {
+	v := int64(ts)
+	if prev, ok := u.prev[pid]; ok && prev-v > tsTimestampWrap/2 {
+		u.offset[pid] += tsTimestampWrap
+	}
+	u.prev[pid] = v
+	return v + u.offset[pid]
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func analyseFlvFile(filename string) (*TimelineReport, error) {
+	var r httpflv.FlvFileReader
+	if err := r.Open(filename); err != nil {
+		return nil, err
+	}
+	defer r.Dispose()
+
+	b := newTimelineBuilder("flv")
+	for {
+		tag, err := r.ReadTag()
+		// 录制异常中断时，文件尾部的tag可能不完整
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.addFlvTag(tag)
+	}
+	return b.report(filename), nil
+}
+
+func analyseTsFile(filename string) (*TimelineReport, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newTimelineBuilder("ts")
+	u := newTsTimestampUnwrapper()
+	d := mpegts.NewDemuxer(func(pt base.AvPacketPt, frame *mpegts.Frame) {
+		b.addTsFrame(pt, frame, u)
+	})
+	d.Feed(content)
+	d.Flush()
+	return b.report(filename), nil
+}
+
+// writeReport `filename`为`-`时输出到标准输出
+//
+func writeReport(r *TimelineReport, filename string) error {
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	if filename == "-" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(filename, out, 0644)
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"github.com/q191201771/lal/pkg/aac"
@@ -16,6 +17,7 @@ import (
 	"github.com/q191201771/naza/pkg/nazalog"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -34,10 +36,13 @@ func main() {
 	confFilename := parseFlag()
 	lals := logic.NewLalServer(func(option *logic.Option) {
 		option.ConfFilename = confFilename
+		// 演示如何在进程内实现鉴权，不设置时使用配置文件中的simple_auth
+		option.Authentication = &demoAuthentication{}
 	})
 
-	// 比常规lalserver多加了这一行
+	// 比常规lalserver多加了这两行
 	go showHowToCustomizePub(lals)
+	go showHowToCustomizeSub(lals)
 
 	err := lals.RunLoop()
 	nazalog.Infof("server manager done. err=%+v", err)
@@ -57,6 +62,28 @@ func parseFlag() string {
 	return *cf
 }
 
+// demoAuthentication 拒绝流名称以`forbidden_`开头的推流和拉流
+type demoAuthentication struct{}
+
+func (a *demoAuthentication) OnPubStart(info base.PubStartInfo) error {
+	return a.check(info.StreamName)
+}
+
+func (a *demoAuthentication) OnSubStart(info base.SubStartInfo) error {
+	return a.check(info.StreamName)
+}
+
+func (a *demoAuthentication) OnHls(streamName string, urlParam string) error {
+	return a.check(streamName)
+}
+
+func (a *demoAuthentication) check(streamName string) error {
+	if strings.HasPrefix(streamName, "forbidden_") {
+		return fmt.Errorf("stream forbidden. stream name=%s", streamName)
+	}
+	return nil
+}
+
 func showHowToCustomizePub(lals logic.ILalServer) {
 	const (
 		h264filename = "/tmp/test.h264"
@@ -103,6 +130,34 @@ func showHowToCustomizePub(lals logic.ILalServer) {
 	lals.DelCustomizePubSession(session)
 }
 
+// demoSubHandler 打印进程内拉流收到的音视频数据
+type demoSubHandler struct {
+	count int
+}
+
+func (h *demoSubHandler) OnAudioSpecificConfig(asc []byte) {
+	nazalog.Infof("customize sub. asc=%s", hex.EncodeToString(asc))
+}
+
+func (h *demoSubHandler) OnAvPacket(packet base.AvPacket) {
+	h.count++
+	if h.count%100 == 0 {
+		nazalog.Infof("customize sub. count=%d, last packet: type=%s, timestamp=%d, len=%d",
+			h.count, packet.PayloadType.ReadableString(), packet.Timestamp, len(packet.Payload))
+	}
+}
+
+func showHowToCustomizeSub(lals logic.ILalServer) {
+	time.Sleep(100 * time.Millisecond)
+
+	// 从lalserver中拉取自定义pub session推入的流，也可以是其他任意协议推入的流
+	session, err := lals.AddCustomizeSubSession("c110", &demoSubHandler{})
+	nazalog.Assert(nil, err)
+
+	time.Sleep(30 * time.Second)
+	lals.DelCustomizeSubSession(session)
+}
+
 // readAudioPacketsFromFile 从aac es流文件读取所有音频包
 //
 func readAudioPacketsFromFile(filename string) (audioContent []byte, audioPackets []base.AvPacket) {
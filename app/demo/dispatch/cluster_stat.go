@@ -0,0 +1,208 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// ClusterStat 定时从各节点拉取/api/stat/all_group，合并成集群维度的流统计，见 ClusterStatHandler
+//
+// 拉取失败的节点不计入统计，直到下一次拉取成功
+//
+type ClusterStat struct {
+	mutex sync.Mutex
+	nodes map[string]*clusterNode
+}
+
+type clusterNode struct {
+	groups     []base.StatGroup
+	updateTime string // 最近一次拉取成功的时间
+	err        error  // 最近一次拉取的错误
+}
+
+// ClusterStreamStat 一路流在集群中的汇总
+type ClusterStreamStat struct {
+	StreamName     string   `json:"stream_name"`
+	PubServerId    string   `json:"pub_server_id"`   // 推流所在节点，没有推流时为空
+	ServerIds      []string `json:"server_ids"`      // 有这路流（推流或者级联拉流）的节点
+	ViewerCount    int      `json:"viewer_count"`    // 所有节点观众数之和，不包含节点之间的级联拉流
+	IngressBitrate int      `json:"ingress_bitrate"` // 推流的码率，单位kbit/s
+	EgressBitrate  int      `json:"egress_bitrate"`  // 所有节点sub的码率之和，包含节点之间的级联拉流，单位kbit/s
+}
+
+type ClusterNodeStat struct {
+	ServerId    string `json:"server_id"`
+	Ok          bool   `json:"ok"`
+	UpdateTime  string `json:"update_time"`
+	Err         string `json:"err,omitempty"`
+	StreamCount int    `json:"stream_count"`
+}
+
+type ApiClusterStat struct {
+	base.HttpResponseBasic
+	Data struct {
+		ViewerCount   int                 `json:"viewer_count"`
+		EgressBitrate int                 `json:"egress_bitrate"`
+		Streams       []ClusterStreamStat `json:"streams"`
+		Nodes         []ClusterNodeStat   `json:"nodes"`
+	} `json:"data"`
+}
+
+func NewClusterStat() *ClusterStat {
+	return &ClusterStat{
+		nodes: make(map[string]*clusterNode),
+	}
+}
+
+// RunLoop 每隔`interval`拉取一次所有节点，阻塞
+//
+func (cs *ClusterStat) RunLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		cs.fetchAll()
+		<-t.C
+	}
+}
+
+func (cs *ClusterStat) Stat() (ret ApiClusterStat) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	streams := make(map[string]*ClusterStreamStat)
+	for serverId, node := range cs.nodes {
+		ret.Data.Nodes = append(ret.Data.Nodes, ClusterNodeStat{
+			ServerId:    serverId,
+			Ok:          node.err == nil,
+			UpdateTime:  node.updateTime,
+			Err:         errString(node.err),
+			StreamCount: len(node.groups),
+		})
+		if node.err != nil {
+			continue
+		}
+		for _, g := range node.groups {
+			s, exist := streams[g.StreamName]
+			if !exist {
+				s = &ClusterStreamStat{StreamName: g.StreamName}
+				streams[g.StreamName] = s
+			}
+			s.ViewerCount += g.ViewerCount
+			// 没有输入流时，节点上只有在等待的sub
+			if g.StatPub.SessionId != "" || g.StatPull.SessionId != "" {
+				s.ServerIds = append(s.ServerIds, serverId)
+			}
+			if g.StatPub.SessionId != "" {
+				s.PubServerId = serverId
+				s.IngressBitrate += g.StatPub.ReadBitrate
+			}
+			// 级联拉流在源节点上是一个rtmp sub，不算观众
+			if g.StatPull.SessionId != "" {
+				s.ViewerCount--
+			}
+			for _, sub := range g.StatSubs {
+				s.EgressBitrate += sub.WriteBitrate
+			}
+		}
+	}
+
+	for _, s := range streams {
+		if s.ViewerCount < 0 {
+			// 各节点拉取的时间点不同，可能短暂不一致
+			s.ViewerCount = 0
+		}
+		sort.Strings(s.ServerIds)
+		ret.Data.ViewerCount += s.ViewerCount
+		ret.Data.EgressBitrate += s.EgressBitrate
+		ret.Data.Streams = append(ret.Data.Streams, *s)
+	}
+	sort.Slice(ret.Data.Streams, func(i, j int) bool {
+		return ret.Data.Streams[i].StreamName < ret.Data.Streams[j].StreamName
+	})
+	sort.Slice(ret.Data.Nodes, func(i, j int) bool {
+		return ret.Data.Nodes[i].ServerId < ret.Data.Nodes[j].ServerId
+	})
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (cs *ClusterStat) fetchAll() {
+	all := servers.All()
+
+	// 节点被移除后（比如k8s中pod被删除），不再计入统计
+	cs.mutex.Lock()
+	for serverId := range cs.nodes {
+		if _, exist := all[serverId]; !exist {
+			delete(cs.nodes, serverId)
+		}
+	}
+	cs.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for serverId, server := range all {
+		wg.Add(1)
+		go func(serverId string, server Server) {
+			defer wg.Done()
+			groups, err := fetchAllGroup(server)
+			if err != nil {
+				nazalog.Warnf("fetch all group failed. serverId=%s, err=%+v", serverId, err)
+			}
+
+			cs.mutex.Lock()
+			defer cs.mutex.Unlock()
+			node, exist := cs.nodes[serverId]
+			if !exist {
+				node = &clusterNode{}
+				cs.nodes[serverId] = node
+			}
+			node.err = err
+			if err == nil {
+				node.groups = groups
+				node.updateTime = base.ReadableNowTime()
+			}
+		}(serverId, server)
+	}
+	wg.Wait()
+}
+
+func fetchAllGroup(server Server) ([]base.StatGroup, error) {
+	url := fmt.Sprintf("http://%s/api/stat/all_group", server.ApiAddr)
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var v base.ApiStatAllGroup
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	if v.ErrorCode != base.ErrorCodeSucc {
+		return nil, fmt.Errorf("error code=%d, desp=%s", v.ErrorCode, v.Desp)
+	}
+	return v.Data.Groups, nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
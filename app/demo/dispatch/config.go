@@ -13,18 +13,85 @@ type Config struct {
 	// 本服务HTTP监听端口，用于接收各lal节点的HTTP Notify
 	ListenAddr string
 
-	// 配置向本服务汇报的节点信息
+	// 配置向本服务汇报的节点信息，开启`K8sDiscovery`时不使用
 	ServerId2Server map[string]Server
 
+	// 运行在k8s中时，通过k8s API发现lal节点，见 K8sDiscovery
+	K8sDiscovery K8sDiscoveryConfig
+
 	// 级联拉流时，携带该Url参数，使得我们可以区分是级联拉流还是用户拉流
 	PullSecretParam string
 
 	// 检测lal节点update报活的超时时间
 	ServerTimeoutSec int
+
+	// 同一路流同时推到了多个节点时的处理策略
+	DupPubPolicy DupPubPolicy
+
+	// 向同一个节点发送同一路流的start_pull的最小间隔，节点确认拉流后则不再发送
+	PullDebounceMs int
+
+	// 发送start_pull时，同步等待节点拉流收到数据的最长时间，为0则不等待，只通过on_relay_pull_start等事件确认结果
+	StartPullWaitMs int
+
+	// 按请求方ip限制本服务对外HTTP接口（/api、/play）的请求频率，每秒的平均请求数，为0则不限制。各节点的notify（/on_*）不限制
+	RateLimitPerSec float64
+
+	// 按请求方ip限制请求频率时，允许的突发请求数
+	RateLimitBurst int
+
+	// 播放地址重定向（/play/{stream}.flv）时，重定向到的httpflv地址中使用的app名称
+	PlayAppName string
+
+	// 节点上最后一个观众离开后，等待该时长再向节点发送stop_pull停止级联拉流，期间有观众进入则取消，单位毫秒
+	StopPullGraceMs int
+
+	// 定时从各节点拉取流统计的间隔，用于/api/cluster/stat，为0则不拉取，单位秒
+	ClusterStatIntervalSec int
+
+	// 热门流，推流开始时就主动让其他所有节点级联拉流，而不是等每个节点上的第一个观众进入时才拉，消除首个观众的等待时间。
+	// 热门流没有观众时也不停止级联拉流，推流结束时才停止
+	HotStreams []string
+
+	// 持久化本服务发送过的start_pull的文件，本服务重启后恢复，为空则只记录在内存中，见 PullRecordStore
+	PullRecordFilename string
+
+	// 定时对比期望的级联拉流和节点上实际的级联拉流并修正的间隔，为0则不修正，只依赖事件通知，单位秒，见 Reconciler
+	ReconcileIntervalSec int
+
+	// 节点因为出口带宽不足拒绝拉流（on_sub_reject）后，在该时长内播放地址重定向时不再选择该节点，单位秒，见 FullServerSet
+	FullCooldownSec int
+}
+
+type DupPubPolicy int
+
+const (
+	DupPubPolicyNone         DupPubPolicy = iota // 不处理，多个节点都接受推流
+	DupPubPolicyRejectSecond                     // 踢掉后推的pub session，以先推的为准
+	DupPubPolicyKickFirst                        // 踢掉先推的pub session，以后推的为准
+)
+
+type K8sDiscoveryConfig struct {
+	Enable bool
+
+	// lalserver pod所在的namespace，为空则使用本服务所在的namespace
+	Namespace string
+
+	// 筛选lalserver pod的label selector，比如`app=lalserver`
+	LabelSelector string
+
+	// lalserver在pod上的端口，分别对应 Server 中的三个地址。HttpflvPort为0则不支持播放地址重定向
+	RtmpPort    int
+	ApiPort     int
+	HttpflvPort int
+
+	// 拉取pod列表的间隔，单位秒，不大于0时使用默认值5秒
+	IntervalSec int
 }
 
 // lal节点静态配置信息
 type Server struct {
-	RtmpAddr string // 可用于级联拉流的RTMP地址
-	ApiAddr  string // HTTP API接口地址
+	RtmpAddr    string `json:"rtmp_addr"`    // 可用于级联拉流的RTMP地址
+	ApiAddr     string `json:"api_addr"`     // HTTP API接口地址
+	HttpflvAddr string `json:"httpflv_addr"` // 对外提供httpflv播放的地址，用于播放地址重定向
 }
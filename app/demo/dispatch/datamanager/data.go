@@ -20,6 +20,7 @@ type DataManagerMemory struct {
 	mutex               sync.Mutex
 	serverId2pubStreams map[string]map[string]struct{}
 	serverId2AliveTs    map[string]int64
+	pinned              map[string]string // streamName -> serverId
 }
 
 func NewDataManagerMemory(serverTimeoutSec int) *DataManagerMemory {
@@ -27,6 +28,7 @@ func NewDataManagerMemory(serverTimeoutSec int) *DataManagerMemory {
 		serverTimeoutSec:    serverTimeoutSec,
 		serverId2pubStreams: make(map[string]map[string]struct{}),
 		serverId2AliveTs:    make(map[string]int64),
+		pinned:              make(map[string]string),
 	}
 
 	// TODO chef: release goroutine
@@ -64,6 +66,7 @@ func (d *DataManagerMemory) AddPub(streamName, serverId string) {
 	pss, _ := d.serverId2pubStreams[serverId]
 	if pss == nil {
 		pss = make(map[string]struct{})
+		d.serverId2pubStreams[serverId] = pss
 	}
 	pss[streamName] = struct{}{}
 }
@@ -71,10 +74,7 @@ func (d *DataManagerMemory) AddPub(streamName, serverId string) {
 func (d *DataManagerMemory) DelPub(streamName, serverId string) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
-	actualServerId, _ := d.queryPub(streamName)
-	if actualServerId != serverId {
-		return
-	}
+	// 注意，只删除该节点上的记录，同名流可能同时存在于其他节点上
 	delete(d.serverId2pubStreams[serverId], streamName)
 }
 
@@ -113,7 +113,56 @@ func (d *DataManagerMemory) UpdatePub(serverId string, streamNameList []string)
 	}
 }
 
+func (d *DataManagerMemory) MarkAlive(serverId string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.markAlive(serverId)
+}
+
+func (d *DataManagerMemory) DelServer(serverId string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	nazalog.Infof("del server. serverId=%s, streams=%+v", serverId, d.serverId2pubStreams[serverId])
+	delete(d.serverId2pubStreams, serverId)
+	delete(d.serverId2AliveTs, serverId)
+}
+
+func (d *DataManagerMemory) PinPub(streamName, serverId string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	nazalog.Infof("pin pub. streamName=%s, serverId=%s", streamName, serverId)
+	d.pinned[streamName] = serverId
+}
+
+func (d *DataManagerMemory) PurgePub(streamName string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	nazalog.Infof("purge pub. streamName=%s", streamName)
+	delete(d.pinned, streamName)
+	for _, pss := range d.serverId2pubStreams {
+		delete(pss, streamName)
+	}
+}
+
+func (d *DataManagerMemory) ListPub() map[string]string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ret := make(map[string]string)
+	for serverId, pss := range d.serverId2pubStreams {
+		for s := range pss {
+			ret[s] = serverId
+		}
+	}
+	for s, serverId := range d.pinned {
+		ret[s] = serverId
+	}
+	return ret
+}
+
 func (d *DataManagerMemory) queryPub(streamName string) (string, bool) {
+	if serverId, exist := d.pinned[streamName]; exist {
+		return serverId, true
+	}
 	for serverId, pss := range d.serverId2pubStreams {
 		if _, exist := pss[streamName]; exist {
 			return serverId, true
@@ -19,6 +19,20 @@ type DataManger interface {
 	// 1. 全量校正。比如自身服务重启了，lal节点重启了，或其他原因Add、Del消息丢失了
 	// 2. 心跳保活
 	UpdatePub(serverId string, streamNameList []string)
+
+	// MarkAlive 心跳保活，不改变流的信息
+	// DelServer 节点启动或者退出时，清空该节点上的所有流
+	MarkAlive(serverId string)
+	DelServer(serverId string)
+
+	// 手动校正，用于运维介入
+	//
+	// PinPub 把流固定到指定节点，固定后QueryPub优先返回该节点，不受UpdatePub以及节点超时的影响，直到PurgePub
+	// PurgePub 清除流在所有节点上的记录，包括固定的记录
+	// ListPub 返回所有流以及所在节点
+	PinPub(streamName, serverId string)
+	PurgePub(streamName string)
+	ListPub() map[string]string
 }
 
 type DataManagerType int
@@ -9,11 +9,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/q191201771/lal/app/demo/dispatch/datamanager"
 	"github.com/q191201771/lal/pkg/base"
@@ -31,24 +35,64 @@ import (
 // 同一路流，推流和拉流可以在不同的节点。
 //
 
+// requestIdHeader 和lalserver的`trace.request_id_header`保持一致
+const requestIdHeader = "X-Request-ID"
+
 var config = Config{
 	ListenAddr: ":10101",
 	ServerId2Server: map[string]Server{
 		"1": {
-			RtmpAddr: "127.0.0.1:19350",
-			ApiAddr:  "127.0.0.1:8083",
+			RtmpAddr:    "127.0.0.1:19350",
+			ApiAddr:     "127.0.0.1:8083",
+			HttpflvAddr: "127.0.0.1:8080",
 		},
 		"2": {
-			RtmpAddr: "127.0.0.1:19550",
-			ApiAddr:  "127.0.0.1:8283",
+			RtmpAddr:    "127.0.0.1:19550",
+			ApiAddr:     "127.0.0.1:8283",
+			HttpflvAddr: "127.0.0.1:8280",
 		},
 	},
-	PullSecretParam:  "lal_cluster_inner_pull=1",
-	ServerTimeoutSec: 30,
+	PullSecretParam:        "lal_cluster_inner_pull=1",
+	ServerTimeoutSec:       30,
+	DupPubPolicy:           DupPubPolicyRejectSecond,
+	PullDebounceMs:         5000,
+	StartPullWaitMs:        3000,
+	RateLimitPerSec:        100,
+	RateLimitBurst:         200,
+	PlayAppName:            "live",
+	StopPullGraceMs:        10000,
+	ClusterStatIntervalSec: 5,
+	HotStreams:             []string{"test110"},
+	PullRecordFilename:     "dispatch_pull_record.json",
+	ReconcileIntervalSec:   10,
+	FullCooldownSec:        10,
+	K8sDiscovery: K8sDiscoveryConfig{
+		Enable:        false,
+		LabelSelector: "app=lalserver",
+		RtmpPort:      1935,
+		ApiPort:       8083,
+		HttpflvPort:   8080,
+		IntervalSec:   5,
+	},
 }
 
 var dataManager datamanager.DataManger
 
+var servers *ServerRegistry
+
+var pullManager *PullManager
+
+var pullRecords *PullRecordStore
+
+var rateLimiter *base.IpRateLimiter
+
+var clusterStat *ClusterStat
+
+var fullServers = NewFullServerSet()
+
+// 播放地址重定向时，在正在级联拉流的多个节点之间轮询
+var playRoundRobin uint32
+
 func OnPubStartHandler(w http.ResponseWriter, r *http.Request) {
 	id := unique.GenUniqueKey("ReqID")
 
@@ -79,13 +123,34 @@ func OnPubStartHandler(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
-	if _, exist := config.ServerId2Server[info.ServerId]; !exist {
+	reqServer, exist := servers.Get(info.ServerId)
+	if !exist {
 		nazalog.Errorf("server id has not config. serverId=%s", info.ServerId)
 		return
 	}
 
+	// 同一路流已经推到了其他节点
+	if pubServerId, exist := dataManager.QueryPub(info.StreamName); exist && pubServerId != info.ServerId {
+		nazalog.Warnf("[%s] dup pub. streamName=%s, exist serverId=%s, new serverId=%s, policy=%d",
+			id, info.StreamName, pubServerId, info.ServerId, config.DupPubPolicy)
+		switch config.DupPubPolicy {
+		case DupPubPolicyRejectSecond:
+			kickOutSession(id, reqServer, info.StreamName, info.SessionId)
+			return
+		case DupPubPolicyKickFirst:
+			if pubServer, ok := servers.Get(pubServerId); ok {
+				if sessionId := queryPubSessionId(id, pubServer, info.StreamName); sessionId != "" {
+					kickOutSession(id, pubServer, info.StreamName, sessionId)
+				}
+			}
+			dataManager.DelPub(info.StreamName, pubServerId)
+		}
+	}
+
 	nazalog.Infof("add pub. streamName=%s, serverId=%s", info.StreamName, info.ServerId)
 	dataManager.AddPub(info.StreamName, info.ServerId)
+
+	warmUpHotStream(id, info.ServerId, info.AppName, info.StreamName, nil)
 }
 
 func OnPubStopHandler(w http.ResponseWriter, r *http.Request) {
@@ -98,13 +163,37 @@ func OnPubStopHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	nazalog.Infof("[%s] on_pub_stop. info=%+v", id, info)
 
-	if _, exist := config.ServerId2Server[info.ServerId]; !exist {
+	if _, exist := servers.Get(info.ServerId); !exist {
 		nazalog.Errorf("server id has not config. serverId=%s", info.ServerId)
 		return
 	}
 
 	nazalog.Infof("del pub. streamName=%s, serverId=%s", info.StreamName, info.ServerId)
 	dataManager.DelPub(info.StreamName, info.ServerId)
+
+	// 热门流的级联拉流没有观众时也不停止，推流结束时由本服务停止。
+	// 注意，DupPubPolicyKickFirst时流可能已经推到了其他节点，此时不停止
+	if _, exist := dataManager.QueryPub(info.StreamName); !exist && isHotStream(info.StreamName) {
+		stopHotStreamPulls(id, info.StreamName)
+	}
+}
+
+// stopHotStreamPulls 停止所有节点上热门流`streamName`的级联拉流
+//
+func stopHotStreamPulls(id string, streamName string) {
+	for _, r := range pullRecords.List("") {
+		if r.StreamName != streamName {
+			continue
+		}
+		pullManager.OnRelayPull(r.ServerId, r.StreamName, false)
+		server, exist := servers.Get(r.ServerId)
+		if !exist {
+			pullRecords.Del(r.ServerId, r.StreamName)
+			continue
+		}
+		nazalog.Infof("[%s] hot stream pub stop, stop pull. streamName=%s, serverId=%s", id, r.StreamName, r.ServerId)
+		go stopRelay(id, r.ServerId, server, r.AppName, r.StreamName)
+	}
 }
 
 func OnSubStartHandler(w http.ResponseWriter, r *http.Request) {
@@ -123,6 +212,8 @@ func OnSubStartHandler(w http.ResponseWriter, r *http.Request) {
 		nazalog.Infof("[%s] sub is pull by other node, ignore.", id)
 		return
 	}
+	pullManager.OnSubStart(info.ServerId, info.StreamName)
+
 	// 2. 汇报的节点已经存在输入流，不需要触发
 	if info.HasInSession {
 		nazalog.Infof("[%s] in not empty, ignore.", id)
@@ -130,7 +221,7 @@ func OnSubStartHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 3. 非法节点，本服务没有配置汇报的节点
-	reqServer, exist := config.ServerId2Server[info.ServerId]
+	reqServer, exist := servers.Get(info.ServerId)
 	if !exist {
 		nazalog.Errorf("[%s] req server id invalid.", id)
 		return
@@ -143,22 +234,104 @@ func OnSubStartHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pubServer, exist := config.ServerId2Server[pubServerId]
-	nazalog.Assert(true, exist)
+	// 开启k8s服务发现时，推流所在的pod可能已经不再ready
+	pubServer, exist := servers.Get(pubServerId)
+	if !exist {
+		nazalog.Warnf("[%s] pub server not available, ignore. serverId=%s", id, pubServerId)
+		return
+	}
 
-	// 向汇报节点，发送pull级联拉流的命令，其中包含pub所在节点信息
-	url := fmt.Sprintf("http://%s/api/ctrl/start_pull", reqServer.ApiAddr)
+	// 5. 已经向汇报节点发送过start_pull，或者汇报节点已经在拉流，不需要重复触发
+	if !issuePull(id, info.ServerId, reqServer, pubServer, info.AppName, info.StreamName, isHotStream(info.StreamName)) {
+		nazalog.Infof("[%s] pull already issued, ignore.", id)
+	}
+}
+
+// issuePull 向节点`serverId`发送pull级联拉流的命令，其中包含pub所在节点信息
+//
+// @param keepWithoutSub 节点上没有观众时是否也保持拉流，见 base.ApiCtrlStartPullReq.KeepWithoutSub
+//
+// @return 已经向该节点发送过start_pull，或者该节点已经在拉流时，不重复发送，返回false
+//
+func issuePull(id string, serverId string, server Server, pubServer Server, appName string, streamName string, keepWithoutSub bool) bool {
+	if !pullManager.ShouldIssue(serverId, streamName, time.Now()) {
+		return false
+	}
+	pullRecords.Add(serverId, appName, streamName)
+
+	url := fmt.Sprintf("http://%s/api/ctrl/start_pull", server.ApiAddr)
 	var b base.ApiCtrlStartPullReq
 	b.Protocol = base.ProtocolRtmp
 	b.Addr = pubServer.RtmpAddr
-	b.AppName = info.AppName
-	b.StreamName = info.StreamName
+	b.AppName = appName
+	b.StreamName = streamName
 	b.UrlParam = config.PullSecretParam
+	b.WaitTimeoutMs = config.StartPullWaitMs
+	b.KeepWithoutSub = keepWithoutSub
+	b.RequestId = id
+
+	nazalog.Infof("[%s] ctrl pull. send to %s with %+v, pull node num=%d",
+		id, server.ApiAddr, b, pullManager.CountByStream(streamName))
+	// 同步等待拉流结果可能耗时较长，不阻塞节点的notify
+	go startPull(id, url, serverId, b)
+	return true
+}
+
+// warmUpHotStream 热门流，让推流节点之外的所有节点级联拉流
+//
+// @param serverIds 为nil时表示所有节点
+//
+func warmUpHotStream(id string, pubServerId string, appName string, streamName string, serverIds []string) {
+	if !isHotStream(streamName) {
+		return
+	}
+	pubServer, exist := servers.Get(pubServerId)
+	if !exist {
+		return
+	}
+	if serverIds == nil {
+		serverIds = servers.ServerIds()
+	}
+	for _, serverId := range serverIds {
+		server, exist := servers.Get(serverId)
+		if serverId == pubServerId || !exist {
+			continue
+		}
+		// 预热时节点上还没有观众，需要keep_without_sub，否则节点因为没有sub session会立即停止拉流
+		if issuePull(id, serverId, server, pubServer, appName, streamName, true) {
+			nazalog.Infof("[%s] warm up hot stream. streamName=%s, serverId=%s", id, streamName, serverId)
+		}
+	}
+}
+
+func isHotStream(streamName string) bool {
+	for _, s := range config.HotStreams {
+		if s == streamName {
+			return true
+		}
+	}
+	return false
+}
 
-	nazalog.Infof("[%s] ctrl pull. send to %s with %+v", id, reqServer.ApiAddr, b)
-	if _, err := nazahttp.PostJson(url, b, nil); err != nil {
+func startPull(id string, url string, serverId string, b base.ApiCtrlStartPullReq) {
+	resp, err := postCtrl(id, url, b)
+	if err != nil {
 		nazalog.Errorf("[%s] post json error. err=%+v", id, err)
+		pullManager.OnRelayPull(serverId, b.StreamName, false)
+		return
+	}
+	defer resp.Body.Close()
+	if b.WaitTimeoutMs <= 0 {
+		return
+	}
+
+	var ret base.ApiCtrlStartPullResp
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		nazalog.Errorf("[%s] decode start pull resp error. err=%+v", id, err)
+		return
 	}
+	nazalog.Infof("[%s] start pull result. resp=%+v", id, ret)
+	pullManager.OnRelayPull(serverId, b.StreamName, ret.ErrorCode == base.ErrorCodeSucc)
 }
 
 func OnSubStopHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,6 +343,50 @@ func OnSubStopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	nazalog.Infof("[%s] on_sub_stop. info=%+v", id, info)
+
+	// 节点上最后一个外部观众离开后，等待一段时间再停止级联拉流，避免观众刷新页面等场景下反复拉流。
+	// 注意，不判断节点当前是否在拉流，因为级联拉流断开后节点会持续重试，也需要停止
+	if strings.Contains(info.UrlParam, config.PullSecretParam) {
+		return
+	}
+	if !pullManager.OnSubStop(info.ServerId, info.StreamName) || isHotStream(info.StreamName) {
+		return
+	}
+	server, exist := servers.Get(info.ServerId)
+	if !exist {
+		nazalog.Errorf("[%s] req server id invalid.", id)
+		return
+	}
+	// 推流所在的节点不需要级联拉流
+	if pubServerId, exist := dataManager.QueryPub(info.StreamName); exist && pubServerId == info.ServerId {
+		return
+	}
+	nazalog.Infof("[%s] last viewer leave, stop pull after %dms. streamName=%s, serverId=%s",
+		id, config.StopPullGraceMs, info.StreamName, info.ServerId)
+	pullManager.ScheduleStop(info.ServerId, info.StreamName, time.Duration(config.StopPullGraceMs)*time.Millisecond, func() {
+		stopRelay(id, info.ServerId, server, info.AppName, info.StreamName)
+	})
+}
+
+// stopRelay 停止节点的级联拉流，并删除记录，后续不再由 Reconciler 恢复
+//
+func stopRelay(id string, serverId string, server Server, appName string, streamName string) {
+	pullRecords.Del(serverId, streamName)
+	stopPull(id, server, appName, streamName)
+}
+
+func stopPull(id string, server Server, appName string, streamName string) {
+	url := fmt.Sprintf("http://%s/api/ctrl/stop_pull", server.ApiAddr)
+	var b base.ApiCtrlStopPull
+	b.AppName = appName
+	b.StreamName = streamName
+
+	nazalog.Infof("[%s] ctrl stop pull. send to %s with %+v", id, server.ApiAddr, b)
+	if resp, err := postCtrl(id, url, b); err != nil {
+		nazalog.Errorf("[%s] post json error. err=%+v", id, err)
+	} else {
+		_ = resp.Body.Close()
+	}
 }
 
 func OnUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -183,13 +400,325 @@ func OnUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	nazalog.Infof("[%s] on_update. info=%+v", id, info)
 
 	var streamNameList []string
+	var pullStreamNameList []string
 	for _, g := range info.Groups {
 		// pub exist
 		if g.StatPub.SessionId != "" {
 			streamNameList = append(streamNameList, g.StreamName)
 		}
+		if g.StatPull.SessionId != "" {
+			pullStreamNameList = append(pullStreamNameList, g.StreamName)
+		}
 	}
 	dataManager.UpdatePub(info.ServerId, streamNameList)
+	pullManager.Update(info.ServerId, pullStreamNameList, time.Now())
+
+	// 节点重启、或者级联拉流断开后，补上热门流的级联拉流
+	for _, streamName := range config.HotStreams {
+		if pubServerId, exist := dataManager.QueryPub(streamName); exist {
+			warmUpHotStream(id, pubServerId, config.PlayAppName, streamName, []string{info.ServerId})
+		}
+	}
+}
+
+// OnServerStartHandler 节点（重新）启动，之前记录的该节点上的流都已经不存在了
+//
+func OnServerStartHandler(w http.ResponseWriter, r *http.Request) {
+	var info base.LalInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	nazalog.Infof("on_server_start. info=%+v", info)
+	dataManager.DelServer(info.ServerId)
+	dataManager.MarkAlive(info.ServerId)
+}
+
+func OnServerStopHandler(w http.ResponseWriter, r *http.Request) {
+	var info base.ServerStopInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	nazalog.Infof("on_server_stop. info=%+v", info)
+	dataManager.DelServer(info.ServerId)
+}
+
+// OnHeartbeatHandler 节点心跳，注意，只保活，流的全量校正依然依赖on_update
+//
+func OnHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	var info base.ServerHeartbeatInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	nazalog.Debugf("on_heartbeat. info=%+v", info)
+	dataManager.MarkAlive(info.ServerId)
+}
+
+// OnSubRejectHandler 节点出口带宽不足拒绝了拉流，冷却时间内播放地址重定向时避开该节点
+//
+func OnSubRejectHandler(w http.ResponseWriter, r *http.Request) {
+	var info base.SubRejectInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	nazalog.Warnf("on_sub_reject. info=%+v", info)
+	fullServers.Mark(info.ServerId, time.Now().Add(time.Duration(config.FullCooldownSec)*time.Second))
+}
+
+// OnRelayPullStartHandler 节点回源拉流的结果，用于确认之前发送的start_pull是否真正生效
+//
+func OnRelayPullStartHandler(w http.ResponseWriter, r *http.Request) {
+	id := unique.GenUniqueKey("ReqID")
+
+	var info base.RelayPullStartInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	// 由本服务的start_pull触发的回源，沿用当时的request id
+	if info.RequestId != "" {
+		id = info.RequestId
+	}
+	nazalog.Infof("[%s] on_relay_pull_start. info=%+v", id, info)
+	pullManager.OnRelayPull(info.ServerId, info.StreamName, info.Success)
+}
+
+func OnRelayPullStopHandler(w http.ResponseWriter, r *http.Request) {
+	id := unique.GenUniqueKey("ReqID")
+
+	var info base.RelayPullStopInfo
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &info); err != nil {
+		nazalog.Error(err)
+		return
+	}
+	// 由本服务的start_pull触发的回源，沿用当时的request id
+	if info.RequestId != "" {
+		id = info.RequestId
+	}
+	nazalog.Infof("[%s] on_relay_pull_stop. info=%+v", id, info)
+	pullManager.OnRelayPull(info.ServerId, info.StreamName, false)
+}
+
+// OverrideReq POST /api/cluster/override 的请求体
+type OverrideReq struct {
+	Action     string `json:"action"` // "pin" 把流固定到`server_id`节点；"purge" 清除流的所有记录
+	StreamName string `json:"stream_name"`
+	ServerId   string `json:"server_id"`
+}
+
+func ClusterOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	var v base.HttpResponseBasic
+	var req OverrideReq
+	if err := nazahttp.UnmarshalRequestJsonBody(r, &req, "action", "stream_name"); err != nil {
+		nazalog.Warnf("cluster override invalid. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	nazalog.Infof("cluster override. req=%+v", req)
+
+	switch req.Action {
+	case "pin":
+		if _, exist := servers.Get(req.ServerId); !exist {
+			v.ErrorCode = base.ErrorCodeParamMissing
+			v.Desp = fmt.Sprintf("server id has not config. serverId=%s", req.ServerId)
+			feedback(v, w)
+			return
+		}
+		dataManager.PinPub(req.StreamName, req.ServerId)
+	case "purge":
+		dataManager.PurgePub(req.StreamName)
+	default:
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = fmt.Sprintf("invalid action. action=%s", req.Action)
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+// ClusterStreamsHandler GET /api/cluster/streams 返回所有流以及所在节点
+func ClusterStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	var v struct {
+		base.HttpResponseBasic
+		Data map[string]string `json:"data"`
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = dataManager.ListPub()
+	feedback(v, w)
+}
+
+// PlayHandler GET /play/{stream}.flv 将播放请求302重定向到已经有这路流的节点上，播放端不需要知道节点的地址
+//
+// 优先选择正在级联拉流的边缘节点（多个时轮询），没有时选择推流所在的节点，都因为出口带宽不足拒绝过拉流时，选择其他空闲的节点，
+// 观众进入后由on_sub_start触发该节点级联拉流。流不存在时返回404。
+// 请求中的url参数（比如鉴权参数）会原样带到重定向地址中
+//
+func PlayHandler(w http.ResponseWriter, r *http.Request) {
+	streamName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/play/"), ".flv")
+	if streamName == "" || strings.Contains(streamName, "/") || !strings.HasSuffix(r.URL.Path, ".flv") {
+		http.NotFound(w, r)
+		return
+	}
+
+	serverId, exist := selectPlayServer(streamName)
+	if !exist {
+		nazalog.Infof("play redirect, stream not found. streamName=%s, remote=%s", streamName, r.RemoteAddr)
+		http.NotFound(w, r)
+		return
+	}
+	server, _ := servers.Get(serverId)
+	url := fmt.Sprintf("http://%s/%s/%s.flv", server.HttpflvAddr, config.PlayAppName, streamName)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	nazalog.Infof("play redirect. streamName=%s, serverId=%s, remote=%s, url=%s", streamName, serverId, r.RemoteAddr, url)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func selectPlayServer(streamName string) (serverId string, exist bool) {
+	now := time.Now()
+	var candidates []string
+	for _, id := range pullManager.ActiveServersByStream(streamName) {
+		if s, ok := servers.Get(id); ok && s.HttpflvAddr != "" && !fullServers.IsFull(id, now) {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) != 0 {
+		n := atomic.AddUint32(&playRoundRobin, 1)
+		return candidates[int(n)%len(candidates)], true
+	}
+
+	serverId, exist = dataManager.QueryPub(streamName)
+	if !exist {
+		return "", false
+	}
+	if s, ok := servers.Get(serverId); !ok || s.HttpflvAddr == "" {
+		return "", false
+	}
+	if !fullServers.IsFull(serverId, now) {
+		return serverId, true
+	}
+
+	for _, id := range servers.ServerIds() {
+		if s, _ := servers.Get(id); s.HttpflvAddr != "" && !fullServers.IsFull(id, now) {
+			return id, true
+		}
+	}
+	// 所有节点都满了，依然返回推流所在的节点，由节点拒绝
+	return serverId, true
+}
+
+// postCtrl 向节点发送http api控制命令，通过header携带本服务的request id，
+// 节点会将其传递给命令创建的session以及之后的事件通知，便于在两边的日志中关联同一次调度
+//
+func postCtrl(id string, url string, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIdHeader, id)
+	return http.DefaultClient.Do(req)
+}
+
+func feedback(v interface{}, w http.ResponseWriter) {
+	resp, _ := json.Marshal(v)
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(resp)
+}
+
+func kickOutSession(id string, server Server, streamName string, sessionId string) {
+	url := fmt.Sprintf("http://%s/api/ctrl/kick_out_session", server.ApiAddr)
+	var b base.ApiCtrlKickOutSession
+	b.StreamName = streamName
+	b.SessionId = sessionId
+
+	nazalog.Infof("[%s] ctrl kick out session. send to %s with %+v", id, server.ApiAddr, b)
+	if resp, err := postCtrl(id, url, b); err != nil {
+		nazalog.Errorf("[%s] post json error. err=%+v", id, err)
+	} else {
+		_ = resp.Body.Close()
+	}
+}
+
+// 查询节点上流的pub session id，查询失败或不存在时返回空字符串
+func queryPubSessionId(id string, server Server, streamName string) string {
+	url := fmt.Sprintf("http://%s/api/stat/group?stream_name=%s", server.ApiAddr, streamName)
+	resp, err := http.Get(url)
+	if err != nil {
+		nazalog.Errorf("[%s] http get error. url=%s, err=%+v", id, url, err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var v base.ApiStatGroup
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil || v.Data == nil {
+		nazalog.Errorf("[%s] decode stat group failed. url=%s, err=%+v", id, url, err)
+		return ""
+	}
+	return v.Data.StatPub.SessionId
+}
+
+// ClusterPullsHandler GET /api/cluster/pulls 返回本服务记录的期望的级联拉流，见 PullRecordStore
+func ClusterPullsHandler(w http.ResponseWriter, r *http.Request) {
+	var v struct {
+		base.HttpResponseBasic
+		Data []PullRecord `json:"data"`
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = pullRecords.List(r.URL.Query().Get("server_id"))
+	feedback(v, w)
+}
+
+// ClusterServersHandler GET /api/cluster/servers 返回当前可用的节点，开启k8s服务发现时为已经ready的pod
+func ClusterServersHandler(w http.ResponseWriter, r *http.Request) {
+	var v struct {
+		base.HttpResponseBasic
+		Data map[string]Server `json:"data"`
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	v.Data = servers.All()
+	feedback(v, w)
+}
+
+// ClusterStatHandler GET /api/cluster/stat 返回集群维度合并后的流统计，比如所有节点的观众总数、总带宽
+func ClusterStatHandler(w http.ResponseWriter, r *http.Request) {
+	if clusterStat == nil {
+		var v base.HttpResponseBasic
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	feedback(clusterStat.Stat(), w)
+}
+
+func RateLimitStatHandler(w http.ResponseWriter, r *http.Request) {
+	var v base.ApiStatRateLimit
+	if rateLimiter == nil {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.Data = rateLimiter.GetStat()
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
 }
 
 func logHandler(w http.ResponseWriter, r *http.Request) {
@@ -204,11 +733,42 @@ func main() {
 	defer nazalog.Sync()
 	base.LogoutStartInfo()
 
+	if config.K8sDiscovery.Enable {
+		// 不使用静态配置的节点，只使用发现的pod
+		servers = NewServerRegistry(nil)
+		d, err := NewK8sDiscovery(config.K8sDiscovery, servers)
+		nazalog.Assert(nil, err)
+		go d.RunLoop()
+	} else {
+		servers = NewServerRegistry(config.ServerId2Server)
+	}
+
 	dataManager = datamanager.NewDataManager(datamanager.DmtMemory, config.ServerTimeoutSec)
+	pullManager = NewPullManager(time.Duration(config.PullDebounceMs) * time.Millisecond)
+	pullRecords = NewPullRecordStore(config.PullRecordFilename)
+	if config.ReconcileIntervalSec > 0 {
+		go NewReconciler().RunLoop(time.Duration(config.ReconcileIntervalSec) * time.Second)
+	}
+	if config.ClusterStatIntervalSec > 0 {
+		clusterStat = NewClusterStat()
+		go clusterStat.RunLoop(time.Duration(config.ClusterStatIntervalSec) * time.Second)
+	}
 
 	l, err := net.Listen("tcp", config.ListenAddr)
 	nazalog.Assert(nil, err)
 
+	// 只限制对外的接口，各节点的notify请求频率和节点上的流、观众数量相关，限流会导致事件丢失
+	if config.RateLimitPerSec > 0 {
+		rateLimiter = base.NewIpRateLimiter(config.RateLimitPerSec, config.RateLimitBurst)
+	}
+	handlePublic := func(m *http.ServeMux, pattern string, handler http.HandlerFunc) {
+		if rateLimiter == nil {
+			m.HandleFunc(pattern, handler)
+			return
+		}
+		m.Handle(pattern, rateLimiter.Wrap(handler))
+	}
+
 	m := http.NewServeMux()
 	m.HandleFunc("/on_pub_start", OnPubStartHandler)
 	m.HandleFunc("/on_pub_stop", OnPubStopHandler)
@@ -216,7 +776,25 @@ func main() {
 	m.HandleFunc("/on_sub_stop", OnSubStopHandler)
 	m.HandleFunc("/on_update", OnUpdateHandler)
 	m.HandleFunc("/on_rtmp_connect", logHandler)
-	m.HandleFunc("/on_server_start", logHandler)
+	m.HandleFunc("/on_relay_pull_start", OnRelayPullStartHandler)
+	m.HandleFunc("/on_relay_pull_stop", OnRelayPullStopHandler)
+	m.HandleFunc("/on_relay_push_start", logHandler)
+	m.HandleFunc("/on_relay_push_stop", logHandler)
+	m.HandleFunc("/on_bitstream_error", logHandler)
+	m.HandleFunc("/on_backup_switch", logHandler)
+	m.HandleFunc("/on_viewer_change", logHandler)
+	m.HandleFunc("/on_pub_violation", logHandler)
+	m.HandleFunc("/on_sub_reject", OnSubRejectHandler)
+	m.HandleFunc("/on_server_start", OnServerStartHandler)
+	m.HandleFunc("/on_server_stop", OnServerStopHandler)
+	m.HandleFunc("/on_heartbeat", OnHeartbeatHandler)
+	handlePublic(m, "/api/cluster/override", ClusterOverrideHandler)
+	handlePublic(m, "/api/cluster/streams", ClusterStreamsHandler)
+	handlePublic(m, "/api/cluster/stat", ClusterStatHandler)
+	handlePublic(m, "/api/cluster/servers", ClusterServersHandler)
+	handlePublic(m, "/api/cluster/pulls", ClusterPullsHandler)
+	handlePublic(m, "/api/stat/rate_limit", RateLimitStatHandler)
+	handlePublic(m, "/play/", PlayHandler)
 
 	srv := http.Server{
 		Handler: m,
@@ -0,0 +1,50 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// FullServerSet 最近因为出口带宽不足拒绝过拉流的节点
+//
+// 注意，只根据on_sub_reject事件标记，冷却时间到了之后自动恢复，不关心节点实际的带宽是否已经释放
+//
+type FullServerSet struct {
+	mutex sync.Mutex
+	until map[string]time.Time // key: server id
+}
+
+func NewFullServerSet() *FullServerSet {
+	return &FullServerSet{
+		until: make(map[string]time.Time),
+	}
+}
+
+// Mark 标记节点在`until`之前是满的
+func (s *FullServerSet) Mark(serverId string, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.until[serverId] = until
+}
+
+func (s *FullServerSet) IsFull(serverId string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	until, ok := s.until[serverId]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(s.until, serverId)
+		return false
+	}
+	return true
+}
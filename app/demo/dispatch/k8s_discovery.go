@@ -0,0 +1,203 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// K8sDiscovery 本服务运行在k8s集群中时，通过k8s API按label selector发现lalserver的pod，替代静态配置的节点列表
+//
+// 只有处于Ready状态的pod才会加入 ServerRegistry ，pod不再Ready或者被删除时移除。
+// 节点id为pod名，所以lalserver的`server_id`需要配置为pod名，比如配置为`${env:POD_NAME}`，
+// 并通过downward api将`metadata.name`设置到环境变量POD_NAME中。
+//
+// 使用pod内的service account访问k8s API，需要有对应namespace下pods的list权限
+//
+type K8sDiscovery struct {
+	config   K8sDiscoveryConfig
+	registry *ServerRegistry
+
+	apiServer string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	k8sRequestTimeout    = 5 * time.Second
+
+	defaultK8sDiscoveryIntervalSec = 5
+)
+
+var errK8sNotInCluster = errors.New("k8s discovery: not running in cluster, KUBERNETES_SERVICE_HOST or KUBERNETES_SERVICE_PORT not set")
+
+// NewK8sDiscovery 读取pod内的service account信息，`IntervalSec`不大于0时使用默认值5秒
+//
+func NewK8sDiscovery(config K8sDiscoveryConfig, registry *ServerRegistry) (*K8sDiscovery, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errK8sNotInCluster
+	}
+	token, err := ioutil.ReadFile(k8sServiceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(k8sServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("k8s discovery: invalid ca.crt")
+	}
+
+	namespace := config.Namespace
+	if namespace == "" {
+		b, err := ioutil.ReadFile(k8sServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	if config.IntervalSec <= 0 {
+		config.IntervalSec = defaultK8sDiscoveryIntervalSec
+	}
+
+	return &K8sDiscovery{
+		config:    config,
+		registry:  registry,
+		apiServer: "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		token:     strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout: k8sRequestTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+// RunLoop 每隔`IntervalSec`秒拉取一次pod列表，阻塞
+//
+// 拉取失败时保持上一次的结果不变，避免k8s API短暂不可用时清空所有节点
+//
+func (d *K8sDiscovery) RunLoop() {
+	t := time.NewTicker(time.Duration(d.config.IntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		if err := d.refresh(); err != nil {
+			nazalog.Warnf("k8s discovery refresh failed. err=%+v", err)
+		}
+		<-t.C
+	}
+}
+
+func (d *K8sDiscovery) refresh() error {
+	pods, err := d.listPods()
+	if err != nil {
+		return err
+	}
+	d.registry.Reset(d.pods2Servers(pods))
+	return nil
+}
+
+// k8sPodList k8s API中PodList只用到的字段
+type k8sPodList struct {
+	Items []k8sPod `json:"items"`
+}
+
+type k8sPod struct {
+	Metadata struct {
+		Name              string  `json:"name"`
+		DeletionTimestamp *string `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string `json:"phase"`
+		PodIp      string `json:"podIP"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// IsReady pod正在运行，通过了readiness探针，并且没有在删除中
+func (p *k8sPod) IsReady() bool {
+	if p.Metadata.DeletionTimestamp != nil || p.Status.Phase != "Running" || p.Status.PodIp == "" {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+func (d *K8sDiscovery) listPods() ([]k8sPod, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?labelSelector=%s",
+		d.apiServer, url.PathEscape(d.namespace), url.QueryEscape(d.config.LabelSelector))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("k8s discovery: list pods failed. status=%d, body=%s", resp.StatusCode, b)
+	}
+
+	var v k8sPodList
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.Items, nil
+}
+
+func (d *K8sDiscovery) pods2Servers(pods []k8sPod) map[string]Server {
+	ret := make(map[string]Server)
+	for i := range pods {
+		p := &pods[i]
+		if !p.IsReady() {
+			continue
+		}
+		s := Server{
+			RtmpAddr: net.JoinHostPort(p.Status.PodIp, strconv.Itoa(d.config.RtmpPort)),
+			ApiAddr:  net.JoinHostPort(p.Status.PodIp, strconv.Itoa(d.config.ApiPort)),
+		}
+		if d.config.HttpflvPort != 0 {
+			s.HttpflvAddr = net.JoinHostPort(p.Status.PodIp, strconv.Itoa(d.config.HttpflvPort))
+		}
+		ret[p.Metadata.Name] = s
+	}
+	return ret
+}
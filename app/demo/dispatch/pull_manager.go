@@ -0,0 +1,216 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PullManager 记录各节点已经在级联拉取的流，避免对同一个节点的同一路流重复发送start_pull
+//
+// 短时间内的多个on_sub_start（比如大量观众同时进入），只会触发一次start_pull
+//
+// 另外记录各节点上每路流的外部观众数，最后一个观众离开后，由本服务决定何时停止节点的级联拉流
+//
+type PullManager struct {
+	debounce time.Duration
+
+	mutex   sync.Mutex
+	pulls   map[pullKey]*pullItem
+	viewers map[pullKey]int
+	stops   map[pullKey]*time.Timer // 等待中的stop_pull
+}
+
+type pullKey struct {
+	serverId   string
+	streamName string
+}
+
+type pullItem struct {
+	issuedTime time.Time // 最近一次发送start_pull的时间
+	active     bool      // 节点通过on_update确认了正在拉流
+}
+
+func NewPullManager(debounce time.Duration) *PullManager {
+	return &PullManager{
+		debounce: debounce,
+		pulls:    make(map[pullKey]*pullItem),
+		viewers:  make(map[pullKey]int),
+		stops:    make(map[pullKey]*time.Timer),
+	}
+}
+
+// ShouldIssue 是否需要向节点`serverId`发送拉取`streamName`的start_pull
+//
+// 返回true时，内部记录本次发送
+//
+func (pm *PullManager) ShouldIssue(serverId, streamName string, now time.Time) bool {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if item, exist := pm.pulls[k]; exist {
+		if item.active || now.Sub(item.issuedTime) < pm.debounce {
+			return false
+		}
+		item.issuedTime = now
+		return true
+	}
+	pm.pulls[k] = &pullItem{issuedTime: now}
+	return true
+}
+
+// Update 节点on_update时调用，全量校正节点上正在拉的流
+//
+func (pm *PullManager) Update(serverId string, pullStreamNameList []string, now time.Time) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pulling := make(map[string]struct{})
+	for _, s := range pullStreamNameList {
+		pulling[s] = struct{}{}
+	}
+	for k, item := range pm.pulls {
+		if k.serverId != serverId {
+			continue
+		}
+		if _, ok := pulling[k.streamName]; ok {
+			item.active = true
+			delete(pulling, k.streamName)
+		} else if now.Sub(item.issuedTime) >= pm.debounce {
+			// 已经停止拉流，或者start_pull失败了
+			delete(pm.pulls, k)
+		}
+	}
+	for s := range pulling {
+		pm.pulls[pullKey{serverId: serverId, streamName: s}] = &pullItem{issuedTime: now, active: true}
+	}
+}
+
+// OnRelayPull 节点on_relay_pull_start、on_relay_pull_stop时调用
+//
+// 拉流成功时标记为正在拉流，失败或者断开时删除记录，使得下一次on_sub_start可以立即重新发送start_pull，而不用等待debounce
+//
+func (pm *PullManager) OnRelayPull(serverId, streamName string, ok bool) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if !ok {
+		delete(pm.pulls, k)
+		return
+	}
+	if item, exist := pm.pulls[k]; exist {
+		item.active = true
+	} else {
+		pm.pulls[k] = &pullItem{issuedTime: time.Now(), active: true}
+	}
+}
+
+// CountByStream 正在拉取（或者已发送start_pull）`streamName`的节点数量
+//
+func (pm *PullManager) CountByStream(streamName string) int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	n := 0
+	for k := range pm.pulls {
+		if k.streamName == streamName {
+			n++
+		}
+	}
+	return n
+}
+
+// ActiveServersByStream 确认正在拉取`streamName`的节点，按server id排序
+//
+func (pm *PullManager) ActiveServersByStream(streamName string) (serverIds []string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for k, item := range pm.pulls {
+		if k.streamName == streamName && item.active {
+			serverIds = append(serverIds, k.serverId)
+		}
+	}
+	sort.Strings(serverIds)
+	return
+}
+
+// OnSubStart 节点上有外部观众进入，如果在等待stop_pull，则取消
+//
+func (pm *PullManager) OnSubStart(serverId, streamName string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	pm.viewers[k]++
+	if t, exist := pm.stops[k]; exist {
+		t.Stop()
+		delete(pm.stops, k)
+	}
+}
+
+// OnSubStop 节点上外部观众离开
+//
+// @return 是否是节点上最后一个观众
+//
+func (pm *PullManager) OnSubStop(serverId, streamName string) bool {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if pm.viewers[k]--; pm.viewers[k] > 0 {
+		return false
+	}
+	// 本服务重启等原因导致计数不准时，可能小于0
+	delete(pm.viewers, k)
+	return true
+}
+
+// SyncViewers 使用从节点拉取的观众数校正计数，有观众时取消等待中的stop_pull，见 Reconciler
+//
+// 本服务重启、或者on_sub_start、on_sub_stop丢失时，计数可能不准
+//
+func (pm *PullManager) SyncViewers(serverId, streamName string, n int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if n <= 0 {
+		delete(pm.viewers, k)
+		return
+	}
+	pm.viewers[k] = n
+	if t, exist := pm.stops[k]; exist {
+		t.Stop()
+		delete(pm.stops, k)
+	}
+}
+
+// ScheduleStop 等待`grace`后调用`onStop`，并删除拉流记录。等待期间有新的观众进入（见 OnSubStart ），则取消
+//
+// 注意，`onStop`在定时器的协程中调用
+//
+func (pm *PullManager) ScheduleStop(serverId, streamName string, grace time.Duration, onStop func()) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if _, exist := pm.stops[k]; exist {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(grace, func() {
+		pm.mutex.Lock()
+		// 已经被取消，或者被新的定时器替换
+		if pm.stops[k] != t {
+			pm.mutex.Unlock()
+			return
+		}
+		delete(pm.stops, k)
+		delete(pm.pulls, k)
+		pm.mutex.Unlock()
+		onStop()
+	})
+	pm.stops[k] = t
+}
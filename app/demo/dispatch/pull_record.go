@@ -0,0 +1,158 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// PullRecordStore 记录本服务发送过start_pull、并且还没有停止的级联拉流，即期望的级联拉流，见 Reconciler
+//
+// 配置了文件名时，每次变化都写入文件，本服务重启后从文件恢复，不会因为重启而丢失或者泄漏节点上的级联拉流
+//
+type PullRecordStore struct {
+	filename string
+
+	mutex   sync.Mutex
+	records map[pullKey]PullRecord
+}
+
+type PullRecord struct {
+	ServerId   string `json:"server_id"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	IssuedTime string `json:"issued_time"` // 第一次发送start_pull的时间
+}
+
+// NewPullRecordStore
+//
+// @param filename 为空则只记录在内存中
+//
+func NewPullRecordStore(filename string) *PullRecordStore {
+	s := &PullRecordStore{
+		filename: filename,
+		records:  make(map[pullKey]PullRecord),
+	}
+	s.load()
+	return s
+}
+
+// Add 已经存在时不覆盖
+//
+func (s *PullRecordStore) Add(serverId, appName, streamName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if _, exist := s.records[k]; exist {
+		return
+	}
+	s.records[k] = PullRecord{
+		ServerId:   serverId,
+		AppName:    appName,
+		StreamName: streamName,
+		IssuedTime: base.ReadableNowTime(),
+	}
+	s.save()
+}
+
+func (s *PullRecordStore) Del(serverId, streamName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := pullKey{serverId: serverId, streamName: streamName}
+	if _, exist := s.records[k]; !exist {
+		return
+	}
+	delete(s.records, k)
+	s.save()
+}
+
+func (s *PullRecordStore) Exist(serverId, streamName string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exist := s.records[pullKey{serverId: serverId, streamName: streamName}]
+	return exist
+}
+
+// List 按server id、stream name排序
+//
+// @param serverId 为空则返回所有节点的记录
+//
+func (s *PullRecordStore) List(serverId string) []PullRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make([]PullRecord, 0, len(s.records))
+	for k, r := range s.records {
+		if serverId == "" || k.serverId == serverId {
+			ret = append(ret, r)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ServerId != ret[j].ServerId {
+			return ret[i].ServerId < ret[j].ServerId
+		}
+		return ret[i].StreamName < ret[j].StreamName
+	})
+	return ret
+}
+
+func (s *PullRecordStore) load() {
+	if s.filename == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(s.filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			nazalog.Errorf("read pull record file failed. filename=%s, err=%+v", s.filename, err)
+		}
+		return
+	}
+	var records []PullRecord
+	if err = json.Unmarshal(b, &records); err != nil {
+		nazalog.Errorf("unmarshal pull record file failed. filename=%s, err=%+v", s.filename, err)
+		return
+	}
+	for _, r := range records {
+		s.records[pullKey{serverId: r.ServerId, streamName: r.StreamName}] = r
+	}
+	nazalog.Infof("load pull records. filename=%s, num=%d", s.filename, len(records))
+}
+
+// save 先写临时文件再rename，避免写到一半时退出导致文件损坏
+//
+// 注意，调用方持有锁
+//
+func (s *PullRecordStore) save() {
+	if s.filename == "" {
+		return
+	}
+	records := make([]PullRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	b, err := json.Marshal(records)
+	if err != nil {
+		nazalog.Errorf("marshal pull records failed. err=%+v", err)
+		return
+	}
+	tmp := s.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		nazalog.Errorf("write pull record file failed. filename=%s, err=%+v", tmp, err)
+		return
+	}
+	if err = os.Rename(tmp, s.filename); err != nil {
+		nazalog.Errorf("rename pull record file failed. filename=%s, err=%+v", s.filename, err)
+	}
+}
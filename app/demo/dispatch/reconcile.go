@@ -0,0 +1,135 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+	"github.com/q191201771/naza/pkg/unique"
+)
+
+// Reconciler 定时从各节点拉取/api/stat/all_group，对比期望的级联拉流（见 PullRecordStore ）和节点上实际的级联拉流，并进行修正：
+//
+// 1. 期望拉流但是节点上没有拉流（比如start_pull失败、节点重启、notify丢失），重新发送start_pull
+// 2. 期望拉流但是节点上已经没有外部观众（比如on_sub_stop丢失，或者本服务重启导致定时器丢失），等待`StopPullGraceMs`后发送stop_pull
+// 3. 节点上在拉流但是没有记录：有外部观众时补上记录，没有时直接发送stop_pull
+// 4. 热门流的推流已经结束，节点上没有在拉流，删除记录并发送stop_pull，使节点不再重试
+//
+// 使得调度不再只依赖事件通知，事件丢失或者本服务重启时状态最终依然会收敛
+//
+// 注意，节点上的观众数使用 base.StatGroup.ViewerCount ，其中包含了其他节点从该节点级联拉流的sub
+//
+type Reconciler struct {
+}
+
+func NewReconciler() *Reconciler {
+	return &Reconciler{}
+}
+
+// RunLoop 每隔`interval`对所有节点做一次修正，阻塞
+//
+func (rc *Reconciler) RunLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		<-t.C
+		rc.reconcileAll()
+	}
+}
+
+func (rc *Reconciler) reconcileAll() {
+	for serverId, server := range servers.All() {
+		groups, err := fetchAllGroup(server)
+		if err != nil {
+			// 拉取失败时不修正，避免节点短暂不可用时误停止拉流
+			nazalog.Warnf("reconcile fetch all group failed. serverId=%s, err=%+v", serverId, err)
+			continue
+		}
+		rc.reconcile(serverId, server, groups)
+	}
+}
+
+func (rc *Reconciler) reconcile(serverId string, server Server, groups []base.StatGroup) {
+	id := unique.GenUniqueKey("Reconcile")
+
+	stream2Group := make(map[string]base.StatGroup)
+	var pullStreamNameList []string
+	for _, g := range groups {
+		stream2Group[g.StreamName] = g
+		if g.StatPull.SessionId != "" {
+			pullStreamNameList = append(pullStreamNameList, g.StreamName)
+		}
+	}
+	pullManager.Update(serverId, pullStreamNameList, time.Now())
+
+	for _, r := range pullRecords.List(serverId) {
+		g, exist := stream2Group[r.StreamName]
+		pulling := exist && g.StatPull.SessionId != ""
+		pubServerId, pubExist := dataManager.QueryPub(r.StreamName)
+
+		// 推流已经转移到该节点上，不再需要级联拉流
+		if pubExist && pubServerId == serverId {
+			nazalog.Infof("[%s] pub on this server, forget pull. record=%+v", id, r)
+			pullRecords.Del(serverId, r.StreamName)
+			continue
+		}
+
+		// 热门流的推流已经结束，节点还在不停重试拉流（推流结束的on_pub_stop丢失等），见 OnPubStopHandler
+		if isHotStream(r.StreamName) && !pubExist && !pulling {
+			nazalog.Infof("[%s] hot stream pub not exist, stop pull. record=%+v", id, r)
+			stopRelay(id, serverId, server, r.AppName, r.StreamName)
+			continue
+		}
+
+		if !isHotStream(r.StreamName) {
+			pullManager.SyncViewers(serverId, r.StreamName, g.ViewerCount)
+			if g.ViewerCount == 0 {
+				r := r
+				pullManager.ScheduleStop(serverId, r.StreamName, time.Duration(config.StopPullGraceMs)*time.Millisecond, func() {
+					stopRelay(id, serverId, server, r.AppName, r.StreamName)
+				})
+				continue
+			}
+		}
+
+		if pulling || !pubExist {
+			continue
+		}
+		pubServer, ok := servers.Get(pubServerId)
+		if !ok {
+			continue
+		}
+		if issuePull(id, serverId, server, pubServer, r.AppName, r.StreamName, isHotStream(r.StreamName)) {
+			nazalog.Infof("[%s] reconcile, re-issue pull. record=%+v, pubServerId=%s", id, r, pubServerId)
+		}
+	}
+
+	for _, streamName := range pullStreamNameList {
+		if pullRecords.Exist(serverId, streamName) {
+			continue
+		}
+		g := stream2Group[streamName]
+		if g.ViewerCount > 0 || isHotStream(streamName) {
+			nazalog.Infof("[%s] reconcile, adopt pull. serverId=%s, streamName=%s, viewer=%d",
+				id, serverId, streamName, g.ViewerCount)
+			pullRecords.Add(serverId, config.PlayAppName, streamName)
+			continue
+		}
+		nazalog.Infof("[%s] reconcile, tear down orphan pull. serverId=%s, streamName=%s", id, serverId, streamName)
+		stopPull(id, server, config.PlayAppName, streamName)
+	}
+
+	for _, streamName := range config.HotStreams {
+		if pubServerId, exist := dataManager.QueryPub(streamName); exist {
+			warmUpHotStream(id, pubServerId, config.PlayAppName, streamName, []string{serverId})
+		}
+	}
+}
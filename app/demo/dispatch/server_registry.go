@@ -0,0 +1,89 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// ServerRegistry 集群中可用的lal节点
+//
+// 没有开启k8s服务发现时，内容为静态配置的`ServerId2Server`；
+// 开启时由 K8sDiscovery 根据pod的变化动态更新，只包含已经ready的pod
+//
+type ServerRegistry struct {
+	mutex           sync.Mutex
+	serverId2Server map[string]Server
+}
+
+func NewServerRegistry(serverId2Server map[string]Server) *ServerRegistry {
+	r := &ServerRegistry{
+		serverId2Server: make(map[string]Server),
+	}
+	for k, v := range serverId2Server {
+		r.serverId2Server[k] = v
+	}
+	return r
+}
+
+func (r *ServerRegistry) Get(serverId string) (Server, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, ok := r.serverId2Server[serverId]
+	return s, ok
+}
+
+// ServerIds 所有节点id，按字典序排列
+func (r *ServerRegistry) ServerIds() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ret := make([]string, 0, len(r.serverId2Server))
+	for k := range r.serverId2Server {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (r *ServerRegistry) All() map[string]Server {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ret := make(map[string]Server, len(r.serverId2Server))
+	for k, v := range r.serverId2Server {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Reset 用`serverId2Server`整体替换所有节点，并打印增加、删除、地址变化的节点
+//
+func (r *ServerRegistry) Reset(serverId2Server map[string]Server) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for k, v := range serverId2Server {
+		prev, exist := r.serverId2Server[k]
+		if !exist {
+			nazalog.Infof("server added. serverId=%s, server=%+v", k, v)
+		} else if prev != v {
+			nazalog.Infof("server changed. serverId=%s, prev=%+v, server=%+v", k, prev, v)
+		}
+	}
+	for k, v := range r.serverId2Server {
+		if _, exist := serverId2Server[k]; !exist {
+			nazalog.Infof("server removed. serverId=%s, server=%+v", k, v)
+		}
+	}
+	r.serverId2Server = make(map[string]Server, len(serverId2Server))
+	for k, v := range serverId2Server {
+		r.serverId2Server[k] = v
+	}
+}
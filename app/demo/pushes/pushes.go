@@ -0,0 +1,114 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/esingest"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 读取本地FLV文件（h264+aac），按实际时间间隔使用ES ingest协议推送给lalserver
+//
+// 用于演示 esingest.PushSession 的用法，嵌入式设备上通常直接推送编码器输出的裸流
+
+func main() {
+	_ = nazalog.Init(func(option *nazalog.Option) {
+		option.AssertBehavior = nazalog.AssertFatal
+	})
+	defer nazalog.Sync()
+	base.LogoutStartInfo()
+
+	filename, addr, streamName := parseFlag()
+
+	var ffr httpflv.FlvFileReader
+	err := ffr.Open(filename)
+	nazalog.Assert(nil, err)
+	defer ffr.Dispose()
+
+	pushSession := esingest.NewPushSession(func(option *esingest.PushSessionOption) {
+		option.WriteTimeoutMs = 5000
+	})
+	err = pushSession.Push(addr, streamName)
+	nazalog.Assert(nil, err)
+	defer pushSession.Dispose()
+
+	var spsPps []byte
+	startTime := time.Now()
+	for {
+		tag, err := ffr.ReadTag()
+		if err == io.EOF {
+			nazalog.Infof("EOF.")
+			break
+		}
+		nazalog.Assert(nil, err)
+
+		// 按照时间戳控制发送速度
+		if d := time.Duration(tag.Header.Timestamp)*time.Millisecond - time.Since(startTime); d > 0 {
+			time.Sleep(d)
+		}
+
+		payload := tag.Payload()
+		switch tag.Header.Type {
+		case httpflv.TagTypeAudio:
+			if len(payload) < 3 || payload[0]>>4 != base.RtmpSoundFormatAac {
+				continue
+			}
+			if payload[1] == base.RtmpAacPacketTypeSeqHeader {
+				err = pushSession.WriteAudioSpecificConfig(payload[2:])
+			} else {
+				err = pushSession.WriteAac(tag.Header.Timestamp, payload[2:])
+			}
+		case httpflv.TagTypeVideo:
+			if len(payload) < 6 || payload[0]&0xF != base.RtmpCodecIdAvc {
+				continue
+			}
+			if payload[1] == base.RtmpAvcPacketTypeSeqHeader {
+				spsPps, err = avc.SpsPpsSeqHeader2Annexb(payload)
+				nazalog.Assert(nil, err)
+				continue
+			}
+			frame, err := avc.Avcc2Annexb(payload[5:])
+			if err != nil {
+				nazalog.Warnf("invalid avcc. err=%+v", err)
+				continue
+			}
+			// 关键帧前面带上sps、pps
+			if payload[0]>>4 == base.RtmpFrameTypeKey {
+				frame = append(append([]byte{}, spsPps...), frame...)
+			}
+			// 注意，ES ingest协议中只有一个时间戳，这里使用dts，不支持B帧
+			err = pushSession.WriteAvc(tag.Header.Timestamp, frame)
+		}
+		nazalog.Assert(nil, err)
+	}
+}
+
+func parseFlag() (filename, addr, streamName string) {
+	i := flag.String("i", "", "specify flv file")
+	a := flag.String("a", "", "specify es ingest server address")
+	s := flag.String("s", "", "specify stream name, could carry url param")
+	flag.Parse()
+	if *i == "" || *a == "" || *s == "" {
+		flag.Usage()
+		_, _ = fmt.Fprintf(os.Stderr, `Example:
+  %s -i /tmp/test.flv -a 127.0.0.1:8089 -s test110
+`, os.Args[0])
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	return *i, *a, *s
+}
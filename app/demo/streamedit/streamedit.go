@@ -0,0 +1,71 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/streamedit"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 离线编辑flv、ts录制文件：按时间范围裁剪、修复时间戳、去掉音频或视频、拼接多个文件
+//
+// 输入输出必须为同一种格式，按文件后缀判断
+//
+// Usage:
+// ./bin/streamedit -i /tmp/in.flv -o /tmp/out.flv -start_ms 10000 -end_ms 20000
+// ./bin/streamedit -i /tmp/a.ts,/tmp/b.ts -o /tmp/out.ts -fix_ts
+// ./bin/streamedit -i /tmp/in.flv -o /tmp/out.flv -strip_audio
+
+func main() {
+	_ = nazalog.Init(func(option *nazalog.Option) {
+		option.AssertBehavior = nazalog.AssertFatal
+	})
+	defer nazalog.Sync()
+	base.LogoutStartInfo()
+
+	inFilenames, outFilename, option := parseFlag()
+
+	stat, err := streamedit.EditFile(inFilenames, outFilename, option)
+	nazalog.Assert(nil, err)
+	nazalog.Infof("edit succ. stat=%+v", stat)
+}
+
+func parseFlag() ([]string, string, streamedit.Option) {
+	i := flag.String("i", "", "specify input flv or ts files, separated by comma for concatenation")
+	o := flag.String("o", "", "specify output file, same format as input")
+	startMs := flag.Int64("start_ms", 0, "trim start position in milliseconds, aligned to the next video key frame")
+	endMs := flag.Int64("end_ms", 0, "trim end position in milliseconds, 0 means to the end")
+	stripVideo := flag.Bool("strip_video", false, "remove video track")
+	stripAudio := flag.Bool("strip_audio", false, "remove audio track")
+	fixTs := flag.Bool("fix_ts", false, "fix timestamp rollback and jump")
+	gapMs := flag.Int64("gap_ms", streamedit.DefaultGapThresholdMs, "timestamp diff larger than this is treated as a jump when -fix_ts")
+	flag.Parse()
+	if *i == "" || *o == "" || (*stripVideo && *stripAudio) {
+		flag.Usage()
+		_, _ = fmt.Fprintf(os.Stderr, `Example:
+  %s -i /tmp/in.flv -o /tmp/out.flv -start_ms 10000 -end_ms 20000
+  %s -i /tmp/a.ts,/tmp/b.ts -o /tmp/out.ts -fix_ts
+`, os.Args[0], os.Args[0])
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	return strings.Split(*i, ","), *o, streamedit.Option{
+		StartMs:        *startMs,
+		EndMs:          *endMs,
+		StripVideo:     *stripVideo,
+		StripAudio:     *stripAudio,
+		FixTimestamp:   *fixTs,
+		GapThresholdMs: *gapMs,
+	}
+}
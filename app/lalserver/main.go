@@ -12,6 +12,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/q191201771/naza/pkg/nazalog"
+	"io/ioutil"
 	"os"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -23,17 +24,40 @@ import (
 func main() {
 	defer nazalog.Sync()
 
-	confFilename := parseFlag()
+	confFilename, testConf, pidFilename, workerIndex := parseFlag()
+	if testConf {
+		os.Exit(logic.TestConf(confFilename))
+	}
 	lals := logic.NewLalServer(func(option *logic.Option) {
 		option.ConfFilename = confFilename
+		option.WorkerIndex = workerIndex
 	})
-	err := lals.RunLoop()
+
+	if pidFilename != "" {
+		if err := base.WritePidFile(pidFilename); err != nil {
+			nazalog.Errorf("write pid file failed. err=%+v", err)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+		defer func() {
+			_ = base.RemovePidFile(pidFilename)
+		}()
+	}
+
+	// 由Windows服务控制管理器启动时，以服务的方式运行，否则直接运行
+	isService, err := base.RunWindowsService("lalserver", lals.RunLoop, lals.Dispose)
+	if !isService && err == nil {
+		err = lals.RunLoop()
+	}
 	nazalog.Infof("server manager done. err=%+v", err)
 }
 
-func parseFlag() string {
+func parseFlag() (string, bool, string, int) {
 	binInfoFlag := flag.Bool("v", false, "show bin info")
 	cf := flag.String("c", "", "specify conf file")
+	tf := flag.Bool("t", false, "test conf file and exit, exit code is non-zero if conf is invalid")
+	pf := flag.String("p", "", "specify pid file, which is removed on exit")
+	wi := flag.Int("worker_index", -1, "specify worker index when reuse_port is enabled, override the value in conf file")
+	es := flag.String("encrypt_secrets", "", "encrypt the plain json secrets file and print to stdout, key is read from env "+logic.DefaultSecretsKeyEnv)
 	flag.Parse()
 
 	if *binInfoFlag {
@@ -42,5 +66,18 @@ func parseFlag() string {
 		os.Exit(0)
 	}
 
-	return *cf
+	if *es != "" {
+		plain, err := ioutil.ReadFile(*es)
+		if err == nil {
+			var out []byte
+			if out, err = base.EncryptSecrets(plain, os.Getenv(logic.DefaultSecretsKeyEnv)); err == nil {
+				_, _ = os.Stdout.Write(out)
+				os.Exit(0)
+			}
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "encrypt secrets failed. err=%+v\n", err)
+		os.Exit(1)
+	}
+
+	return *cf, *tf, *pf, *wi
 }
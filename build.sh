@@ -18,6 +18,8 @@ GitCommitLog=${GitCommitLog//\'/\"}
 GitStatus=`git status -s`
 BuildTime=`date +'%Y.%m.%d.%H%M%S'`
 BuildGoVersion=`go version`
+# 额外的编译tag，比如`BUILD_TAGS=chaos ./build.sh`编译带故障注入的版本
+BuildTags=${BUILD_TAGS}
 
 LDFlags=" \
     -X 'github.com/q191201771/naza/pkg/bininfo.GitTag=${GitTag}' \
@@ -28,14 +30,14 @@ LDFlags=" \
 "
 
 echo "build" ${ROOT_DIR}/app/lalserver "..."
-cd ${ROOT_DIR}/app/lalserver && go build -ldflags "$LDFlags" -o ${ROOT_DIR}/${OUT_DIR}/lalserver
+cd ${ROOT_DIR}/app/lalserver && go build -tags "$BuildTags" -ldflags "$LDFlags" -o ${ROOT_DIR}/${OUT_DIR}/lalserver
 #cd ${ROOT_DIR}/app/lalserver && go build -race -ldflags "$LDFlags" -o ${ROOT_DIR}/${OUT_DIR}/lalserver.debug
 
 for file in `ls ${ROOT_DIR}/app/demo`
 do
   if [ -d ${ROOT_DIR}/app/demo/${file} ]; then
     echo "build" ${ROOT_DIR}/app/demo/${file} "..."
-    cd ${ROOT_DIR}/app/demo/${file} && go build -ldflags "$LDFlags" -o ${ROOT_DIR}/${OUT_DIR}/${file}
+    cd ${ROOT_DIR}/app/demo/${file} && go build -tags "$BuildTags" -ldflags "$LDFlags" -o ${ROOT_DIR}/${OUT_DIR}/${file}
   fi
 done
 
@@ -34,12 +34,39 @@ const (
 	AscSamplingFrequencyIndex48000 = 3
 	AscSamplingFrequencyIndex44100 = 4
 	AscSamplingFrequencyIndex22050 = 7
+
+	// AscSamplingFrequencyIndexExplicit 不使用索引，而是在后面用24位显式携带采样率
+	AscSamplingFrequencyIndexExplicit = 0xF
+)
+
+// Audio Object Type
+//
+// <1.5.1.1 Audio Object type definition>
+//
+const (
+	AotAacMain  uint8 = 1
+	AotAacLc    uint8 = 2
+	AotAacSsr   uint8 = 3
+	AotAacLtp   uint8 = 4
+	AotSbr      uint8 = 5  // HE-AAC
+	AotErAacLd  uint8 = 23 // AAC-LD
+	AotPs       uint8 = 29 // HE-AACv2
+	AotEscape   uint8 = 31
+	AotErAacEld uint8 = 39 // AAC-ELD
 )
 
 const (
 	minAscLength = 2
+
+	syncExtensionTypeSbr uint16 = 0x2b7
+	syncExtensionTypePs  uint16 = 0x548
 )
 
+// <1.6.3.3 samplingFrequencyIndex>
+var samplingFrequencyTable = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
 // AscContext
 //
 // <ISO_IEC_14496-3.pdf>
@@ -48,14 +75,30 @@ const (
 // <1.6.3.3 samplingFrequencyIndex>, <page 35/110>
 // <1.6.3.4 channelConfiguration>
 // --------------------------------------------------------
-// audio object type      [5b] 1=AAC MAIN  2=AAC LC
-// samplingFrequencyIndex [4b] 3=48000, 4=44100, 5=32000, 6=24000, 7=22050, 11=11025
+// audio object type      [5b] 1=AAC MAIN  2=AAC LC  5=SBR(HE-AAC)  29=PS(HE-AACv2)  31=escape(6b + 32)  39=ELD
+// samplingFrequencyIndex [4b] 3=48000, 4=44100, 5=32000, 6=24000, 7=22050, 11=11025, 15=escape(24b)
 // channelConfiguration   [4b] 1=center front speaker  2=left, right front speakers
 //
+// HE-AAC有两种信令方式：
+// 1. 显式，audio object type为5或29，后面跟着SBR的采样率，以及core的audio object type（一般是2）
+// 2. 隐式（向后兼容），asc为普通的AAC LC，在GASpecificConfig之后通过sync extension携带SBR、PS信息
+//
+// 两种方式解析后，都使用 CoreAudioObjectType 和 SamplingFrequencyIndex 表示core的编码参数（ADTS使用这部分），
+// 使用 GetOutputSamplingFrequency 、 GetOutputChannels 获取解码输出的参数（sdp、rtp使用这部分）
+//
 type AscContext struct {
-	AudioObjectType        uint8 // [5b]
+	AudioObjectType        uint8 // [5b] 注意，是asc中第一个audio object type，HE-AAC显式信令时为5或29
 	SamplingFrequencyIndex uint8 // [4b]
 	ChannelConfiguration   uint8 // [4b]
+
+	SamplingFrequency int // SamplingFrequencyIndex为 AscSamplingFrequencyIndexExplicit 时显式携带的采样率
+
+	// 以下字段由Unpack解析，没有使用SBR、PS时为零值
+	CoreAudioObjectType             uint8 // 实际编码的audio object type，为0时表示与AudioObjectType相同
+	SbrPresentFlag                  bool
+	PsPresentFlag                   bool
+	ExtensionSamplingFrequencyIndex uint8
+	ExtensionSamplingFrequency      int // 不为0时为SBR的采样率，比如扩展采样率显式携带，或者ELD使用LD-SBR
 }
 
 func NewAscContext(asc []byte) (*AscContext, error) {
@@ -68,7 +111,7 @@ func NewAscContext(asc []byte) (*AscContext, error) {
 
 // Unpack
 //
-// @param asc: 2字节的AAC Audio Specifc Config
+// @param asc: AAC Audio Specifc Config，AAC LC一般为2字节，HE-AAC、ELD等会更长
 //             注意，如果是rtmp/flv的message/tag，应去除Seq Header头部的2个字节
 //             函数调用结束后，内部不持有该内存块
 //
@@ -77,23 +120,60 @@ func (ascCtx *AscContext) Unpack(asc []byte) error {
 		return nazaerrors.Wrap(base.ErrShortBuffer)
 	}
 
+	*ascCtx = AscContext{}
 	br := nazabits.NewBitReader(asc)
-	ascCtx.AudioObjectType, _ = br.ReadBits8(5)
-	ascCtx.SamplingFrequencyIndex, _ = br.ReadBits8(4)
+	ascCtx.AudioObjectType = readAudioObjectType(&br)
+	ascCtx.SamplingFrequencyIndex, ascCtx.SamplingFrequency = readSamplingFrequency(&br)
 	ascCtx.ChannelConfiguration, _ = br.ReadBits8(4)
+
+	ascCtx.CoreAudioObjectType = ascCtx.AudioObjectType
+	if ascCtx.AudioObjectType == AotSbr || ascCtx.AudioObjectType == AotPs {
+		ascCtx.SbrPresentFlag = true
+		ascCtx.PsPresentFlag = ascCtx.AudioObjectType == AotPs
+		ascCtx.ExtensionSamplingFrequencyIndex, ascCtx.ExtensionSamplingFrequency = readSamplingFrequency(&br)
+		ascCtx.CoreAudioObjectType = readAudioObjectType(&br)
+	}
+	if br.Err() != nil {
+		return nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+
+	// 后面的字段只用于检测HE-AAC的隐式信令以及ELD的LD-SBR，解析失败时忽略
+	switch {
+	case isGaObjectType(ascCtx.CoreAudioObjectType):
+		if ascCtx.skipGaSpecificConfig(&br) && !ascCtx.SbrPresentFlag {
+			ascCtx.readSyncExtension(&br)
+		}
+	case ascCtx.CoreAudioObjectType == AotErAacEld:
+		ascCtx.readEldSpecificConfig(&br)
+	}
 	return nil
 }
 
 // Pack
 //
+// 注意，只写入audio object type、采样率、声道数，以及HE-AAC显式信令的部分，
+// ELD的ELDSpecificConfig、HE-AAC隐式信令的sync extension等不会写入，需要完整的asc时应使用原始数据
+//
 // @return asc: 内存块为独立新申请；函数调用结束后，内部不持有该内存块
 //
 func (ascCtx *AscContext) Pack() (asc []byte) {
-	asc = make([]byte, minAscLength)
-	bw := nazabits.NewBitWriter(asc)
-	bw.WriteBits8(5, ascCtx.AudioObjectType)
-	bw.WriteBits8(4, ascCtx.SamplingFrequencyIndex)
+	buf := make([]byte, 16)
+	bw := nazabits.NewBitWriter(buf)
+	nbits := writeAudioObjectType(&bw, ascCtx.AudioObjectType)
+	nbits += writeSamplingFrequency(&bw, ascCtx.SamplingFrequencyIndex, ascCtx.SamplingFrequency)
 	bw.WriteBits8(4, ascCtx.ChannelConfiguration)
+	nbits += 4
+	if ascCtx.AudioObjectType == AotSbr || ascCtx.AudioObjectType == AotPs {
+		nbits += writeSamplingFrequency(&bw, ascCtx.ExtensionSamplingFrequencyIndex, ascCtx.ExtensionSamplingFrequency)
+		nbits += writeAudioObjectType(&bw, ascCtx.coreAudioObjectType())
+	}
+
+	n := (nbits + 7) / 8
+	if n < minAscLength {
+		n = minAscLength
+	}
+	asc = make([]byte, n)
+	copy(asc, buf)
 	return
 }
 
@@ -114,12 +194,18 @@ func (ascCtx *AscContext) PackAdtsHeader(frameLength int) (out []byte) {
 
 // PackToAdtsHeader
 //
+// 注意，ADTS只能携带AAC Main、LC、SSR、LTP，HE-AAC使用core的参数（解码器通过隐式信令的方式识别SBR、PS），
+// ELD等其他类型返回错误
+//
 // @param out: 函数调用结束后，内部不持有该内存块
 //
 func (ascCtx *AscContext) PackToAdtsHeader(out []byte, frameLength int) error {
 	if len(out) < AdtsHeaderLength {
 		return nazaerrors.Wrap(base.ErrShortBuffer)
 	}
+	if err := ascCtx.CheckAdtsSupported(); err != nil {
+		return err
+	}
 
 	// <ISO_IEC_14496-3.pdf>
 	// <1.A.2.2.1 Fixed Header of ADTS>, <page 75/110>
@@ -150,7 +236,7 @@ func (ascCtx *AscContext) PackToAdtsHeader(out []byte, frameLength int) error {
 	// ID, Layer, protection_absent 1(4)
 	bw.WriteBits8(4, 0x1)
 	// 2(2)
-	bw.WriteBits8(2, ascCtx.AudioObjectType-1)
+	bw.WriteBits8(2, ascCtx.coreAudioObjectType()-1)
 	// 2(4)
 	bw.WriteBits8(4, ascCtx.SamplingFrequencyIndex)
 	// private_bit 2(1)
@@ -168,16 +254,51 @@ func (ascCtx *AscContext) PackToAdtsHeader(out []byte, frameLength int) error {
 	return nil
 }
 
+// CheckAdtsSupported 是否可以打包成ADTS格式
+//
+func (ascCtx *AscContext) CheckAdtsSupported() error {
+	aot := ascCtx.coreAudioObjectType()
+	if aot < AotAacMain || aot > AotAacLtp || ascCtx.SamplingFrequencyIndex >= AscSamplingFrequencyIndexExplicit {
+		return fmt.Errorf("%w. asCtx=%+v", base.ErrAacAdtsUnsupported, ascCtx)
+	}
+	return nil
+}
+
+// GetSamplingFrequency 获取core的采样率，HE-AAC时一般为解码输出采样率的一半
+//
 func (ascCtx *AscContext) GetSamplingFrequency() (int, error) {
-	switch ascCtx.SamplingFrequencyIndex {
-	case AscSamplingFrequencyIndex48000:
-		return 48000, nil
-	case AscSamplingFrequencyIndex44100:
-		return 44100, nil
-	case AscSamplingFrequencyIndex22050:
-		return 22050, nil
+	return samplingFrequencyOf(ascCtx.SamplingFrequencyIndex, ascCtx.SamplingFrequency, ascCtx)
+}
+
+// GetOutputSamplingFrequency 获取解码输出的采样率，使用SBR时为SBR的采样率，其他情况与 GetSamplingFrequency 相同
+//
+func (ascCtx *AscContext) GetOutputSamplingFrequency() (int, error) {
+	if !ascCtx.SbrPresentFlag {
+		return ascCtx.GetSamplingFrequency()
 	}
-	return -1, fmt.Errorf("%w. asCtx=%+v", base.ErrSamplingFrequencyIndex, ascCtx)
+	if ascCtx.ExtensionSamplingFrequency != 0 {
+		return ascCtx.ExtensionSamplingFrequency, nil
+	}
+	return samplingFrequencyOf(ascCtx.ExtensionSamplingFrequencyIndex, 0, ascCtx)
+}
+
+// GetOutputChannels 获取解码输出的声道数，HE-AACv2（PS）编码的是单声道，输出为双声道
+//
+// @return: 0表示声道信息在program_config_element中，没有解析
+//
+func (ascCtx *AscContext) GetOutputChannels() int {
+	if ascCtx.PsPresentFlag && ascCtx.ChannelConfiguration == 1 {
+		return 2
+	}
+	switch ascCtx.ChannelConfiguration {
+	case 1, 2, 3, 4, 5, 6:
+		return int(ascCtx.ChannelConfiguration)
+	case 7, 12, 14:
+		return 8
+	case 11:
+		return 7
+	}
+	return 0
 }
 
 type AdtsHeaderContext struct {
@@ -228,3 +349,153 @@ func MakeAscWithAdtsHeader(adtsHeader []byte) (asc []byte, err error) {
 	}
 	return ctx.AscCtx.Pack(), nil
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (ascCtx *AscContext) coreAudioObjectType() uint8 {
+	if ascCtx.CoreAudioObjectType != 0 {
+		return ascCtx.CoreAudioObjectType
+	}
+	return ascCtx.AudioObjectType
+}
+
+// skipGaSpecificConfig <1.6.2.1 GASpecificConfig>
+//
+// @return: 是否成功跳过，channelConfiguration为0（携带program_config_element）时不支持
+//
+func (ascCtx *AscContext) skipGaSpecificConfig(br *nazabits.BitReader) bool {
+	if ascCtx.ChannelConfiguration == 0 {
+		return false
+	}
+	aot := ascCtx.CoreAudioObjectType
+	_ = br.SkipBits(1) // frameLengthFlag
+	if dependsOnCoreCoder, _ := br.ReadBits8(1); dependsOnCoreCoder == 1 {
+		_ = br.SkipBits(14) // coreCoderDelay
+	}
+	extensionFlag, _ := br.ReadBits8(1)
+	if aot == 6 || aot == 20 {
+		_ = br.SkipBits(3) // layerNr
+	}
+	if extensionFlag == 1 {
+		switch aot {
+		case 22:
+			_ = br.SkipBits(16) // numOfSubFrame, layer_length
+		case 17, 19, 20, 23:
+			_ = br.SkipBits(3) // aacSectionDataResilienceFlag, aacScalefactorDataResilienceFlag, aacSpectralDataResilienceFlag
+		}
+		_ = br.SkipBits(1) // extensionFlag3
+	}
+	if aot >= 17 && aot <= 27 {
+		_ = br.SkipBits(2) // epConfig
+	}
+	return br.Err() == nil
+}
+
+// readSyncExtension HE-AAC的隐式信令，见 <1.6.2.1 AudioSpecificConfig> 中syncExtensionType的部分
+//
+func (ascCtx *AscContext) readSyncExtension(br *nazabits.BitReader) {
+	if avail, _ := br.AvailBits(); avail < 16 {
+		return
+	}
+	if t, _ := br.ReadBits16(11); t != syncExtensionTypeSbr {
+		return
+	}
+	if aot := readAudioObjectType(br); aot != AotSbr {
+		return
+	}
+	sbrPresentFlag, _ := br.ReadBits8(1)
+	if sbrPresentFlag == 0 {
+		return
+	}
+	index, freq := readSamplingFrequency(br)
+	if br.Err() != nil {
+		return
+	}
+	ascCtx.SbrPresentFlag = true
+	ascCtx.ExtensionSamplingFrequencyIndex, ascCtx.ExtensionSamplingFrequency = index, freq
+
+	if avail, _ := br.AvailBits(); avail < 12 {
+		return
+	}
+	if t, _ := br.ReadBits16(11); t == syncExtensionTypePs {
+		psPresentFlag, _ := br.ReadBits8(1)
+		ascCtx.PsPresentFlag = psPresentFlag == 1 && br.Err() == nil
+	}
+}
+
+// readEldSpecificConfig 只解析到LD-SBR相关的字段，见 <ISO_IEC_14496-3> <4.6.20.2 ELDSpecificConfig>
+//
+func (ascCtx *AscContext) readEldSpecificConfig(br *nazabits.BitReader) {
+	_ = br.SkipBits(4) // frameLengthFlag, aacSectionDataResilienceFlag, aacScalefactorDataResilienceFlag, aacSpectralDataResilienceFlag
+	ldSbrPresentFlag, _ := br.ReadBits8(1)
+	ldSbrSamplingRate, _ := br.ReadBits8(1)
+	if br.Err() != nil || ldSbrPresentFlag == 0 {
+		return
+	}
+	freq, err := ascCtx.GetSamplingFrequency()
+	if err != nil {
+		return
+	}
+	ascCtx.SbrPresentFlag = true
+	// ldSbrSamplingRate为1时，SBR为双速率
+	if ldSbrSamplingRate == 1 {
+		freq *= 2
+	}
+	ascCtx.ExtensionSamplingFrequency = freq
+}
+
+func isGaObjectType(aot uint8) bool {
+	switch aot {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		return true
+	}
+	return false
+}
+
+func readAudioObjectType(br *nazabits.BitReader) uint8 {
+	aot, _ := br.ReadBits8(5)
+	if aot == AotEscape {
+		ext, _ := br.ReadBits8(6)
+		aot = 32 + ext
+	}
+	return aot
+}
+
+func writeAudioObjectType(bw *nazabits.BitWriter, aot uint8) int {
+	if aot >= AotEscape {
+		bw.WriteBits8(5, AotEscape)
+		bw.WriteBits8(6, aot-32)
+		return 11
+	}
+	bw.WriteBits8(5, aot)
+	return 5
+}
+
+func readSamplingFrequency(br *nazabits.BitReader) (index uint8, freq int) {
+	index, _ = br.ReadBits8(4)
+	if index == AscSamplingFrequencyIndexExplicit {
+		v, _ := br.ReadBits32(24)
+		freq = int(v)
+	}
+	return
+}
+
+func writeSamplingFrequency(bw *nazabits.BitWriter, index uint8, freq int) int {
+	bw.WriteBits8(4, index)
+	if index == AscSamplingFrequencyIndexExplicit {
+		bw.WriteBits8(8, uint8(freq>>16))
+		bw.WriteBits16(16, uint16(freq))
+		return 28
+	}
+	return 4
+}
+
+func samplingFrequencyOf(index uint8, explicitFreq int, ascCtx *AscContext) (int, error) {
+	if index == AscSamplingFrequencyIndexExplicit && explicitFreq > 0 {
+		return explicitFreq, nil
+	}
+	if int(index) < len(samplingFrequencyTable) {
+		return samplingFrequencyTable[index], nil
+	}
+	return -1, fmt.Errorf("%w. asCtx=%+v", base.ErrSamplingFrequencyIndex, ascCtx)
+}
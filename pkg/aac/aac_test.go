@@ -94,3 +94,49 @@ func TestSequenceHeaderContext(t *testing.T) {
 	shCtx.Unpack(goldenSh)
 	aac.Log.Debugf("%+v", shCtx)
 }
+
+func TestAscContext_HeAacEld(t *testing.T) {
+	// HE-AAC隐式信令，core为22050，SBR为44100
+	ascCtx, err := aac.NewAscContext([]byte{0x13, 0x90, 0x56, 0xe5, 0xa0})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, aac.AotAacLc, ascCtx.AudioObjectType)
+	assert.Equal(t, true, ascCtx.SbrPresentFlag)
+	assert.Equal(t, false, ascCtx.PsPresentFlag)
+	freq, _ := ascCtx.GetSamplingFrequency()
+	assert.Equal(t, 22050, freq)
+	freq, _ = ascCtx.GetOutputSamplingFrequency()
+	assert.Equal(t, 44100, freq)
+
+	// HE-AAC显式信令，core为AAC LC 24000，SBR为48000
+	asc := []byte{0x2b, 0x11, 0x88}
+	ascCtx, err = aac.NewAscContext(asc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, aac.AotSbr, ascCtx.AudioObjectType)
+	assert.Equal(t, aac.AotAacLc, ascCtx.CoreAudioObjectType)
+	assert.Equal(t, true, ascCtx.SbrPresentFlag)
+	freq, _ = ascCtx.GetOutputSamplingFrequency()
+	assert.Equal(t, 48000, freq)
+	assert.Equal(t, asc, ascCtx.Pack())
+	// ADTS中使用core的参数
+	h := ascCtx.PackAdtsHeader(100)
+	adtsCtx, err := aac.NewAdtsHeaderContext(h)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, aac.AotAacLc, adtsCtx.AscCtx.AudioObjectType)
+	assert.Equal(t, uint8(6), adtsCtx.AscCtx.SamplingFrequencyIndex)
+
+	// HE-AACv2，单声道编码，输出双声道
+	ascCtx, err = aac.NewAscContext([]byte{0xeb, 0x09, 0x88})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ascCtx.PsPresentFlag)
+	assert.Equal(t, 2, ascCtx.GetOutputChannels())
+
+	// AAC-ELD，使用双速率的LD-SBR
+	ascCtx, err = aac.NewAscContext([]byte{0xf8, 0xe6, 0x21, 0x80})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, aac.AotErAacEld, ascCtx.AudioObjectType)
+	assert.Equal(t, true, ascCtx.SbrPresentFlag)
+	freq, _ = ascCtx.GetOutputSamplingFrequency()
+	assert.Equal(t, 96000, freq)
+	assert.Equal(t, 1, ascCtx.GetOutputChannels())
+	assert.Equal(t, true, errors.Is(ascCtx.CheckAdtsSupported(), base.ErrAacAdtsUnsupported))
+}
@@ -354,3 +354,17 @@ func TestTryParseSeqHeader(t *testing.T) {
 	err = avc.TryParseSeqHeader(payload)
 	assert.Equal(t, nil, err)
 }
+
+func TestIsCaptionSei(t *testing.T) {
+	// SEI(payload type 5, user_data_unregistered) + SEI(payload type 4, GA94 cc_data)
+	caption := []byte{0x06,
+		0x05, 0x02, 0x01, 0x02,
+		0x04, 0x0B, 0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0xC1, 0xFF, 0x00,
+		0x80}
+	assert.Equal(t, true, avc.IsCaptionSei(caption))
+
+	assert.Equal(t, false, avc.IsCaptionSei([]byte{0x06, 0x05, 0x02, 0x01, 0x02, 0x80}))
+	assert.Equal(t, false, avc.IsCaptionSei([]byte{0x06, 0x04, 0x20, 0xB5}))
+	assert.Equal(t, false, avc.IsCaptionSei([]byte{0x65, 0x88}))
+	assert.Equal(t, false, avc.IsCaptionSei(nil))
+}
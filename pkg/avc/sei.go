@@ -0,0 +1,65 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package avc
+
+// SEI payload type: user_data_registered_itu_t_t35
+const seiPayloadTypeUserDataRegistered = 4
+
+// IsCaptionSei 是否是携带CEA-608/708字幕的SEI nalu
+//
+// 字幕数据格式见 ATSC A/72 以及 CEA-708：
+// itu_t_t35_country_code(0xB5) itu_t_t35_provider_code(0x0031) user_identifier("GA94") user_data_type_code(0x03)
+//
+// 注意，没有去除防竞争字节，只用于检测字幕是否存在
+//
+// @param nalu: 不包含start code的nalu
+//
+func IsCaptionSei(nalu []byte) bool {
+	if len(nalu) < 2 || ParseNaluType(nalu[0]) != NaluTypeSei {
+		return false
+	}
+
+	i := 1
+	// 一个SEI nalu中可能包含多个SEI message，最后是rbsp trailing bits（0x80）
+	for i < len(nalu) && nalu[i] != 0x80 {
+		payloadType := 0
+		for i < len(nalu) && nalu[i] == 0xFF {
+			payloadType += 255
+			i++
+		}
+		if i >= len(nalu) {
+			return false
+		}
+		payloadType += int(nalu[i])
+		i++
+
+		payloadSize := 0
+		for i < len(nalu) && nalu[i] == 0xFF {
+			payloadSize += 255
+			i++
+		}
+		if i >= len(nalu) {
+			return false
+		}
+		payloadSize += int(nalu[i])
+		i++
+
+		if i+payloadSize > len(nalu) {
+			return false
+		}
+		payload := nalu[i : i+payloadSize]
+		if payloadType == seiPayloadTypeUserDataRegistered && len(payload) >= 8 &&
+			payload[0] == 0xB5 && payload[1] == 0x00 && payload[2] == 0x31 &&
+			string(payload[3:7]) == "GA94" && payload[7] == 0x03 {
+			return true
+		}
+		i += payloadSize
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 故障注入（chaos），用于在测试、预发布环境中验证回源、转推以及dispatch调度的重试和切换逻辑
+//
+// 只有使用`-tags chaos`编译时才生效（比如`BUILD_TAGS=chaos ./build.sh`），默认编译时相关函数为空实现，没有任何额外开销。
+//
+// 作用于所有通过 DialTcp 建立的连接，也即rtmp、rtsp、httpflv的客户端session（relay pull、relay push等）。
+// 规则通过环境变量`LAL_CHAOS`设置，测试中也可以调用 SetChaosRules 。多条规则用`;`分隔，使用第一条匹配的规则，每条规则的格式为：
+//   addr=127.0.0.1:19350,drop=10,delay_ms=200,kill_after_ms=30000
+//
+//   addr          为空或不填表示所有连接，否则为连接地址中包含该字符串时生效
+//   drop          按百分比随机丢弃数据。写时丢弃本次写入的数据（返回写入成功），读时丢弃本次读到的数据，
+//                 注意，对于tcp来说，丢弃数据会使对端解析出错，适合用于模拟异常断开后的重连
+//   delay_ms      每次写入前延迟的时间，单位毫秒
+//   kill_after_ms 连接建立后经过该时间强制关闭连接，单位毫秒
+//
+
+const ChaosEnvName = "LAL_CHAOS"
+
+type ChaosRule struct {
+	Addr        string
+	DropPercent int
+	DelayMs     int
+	KillAfterMs int
+}
+
+// ParseChaosRules 解析规则字符串，格式见文件头部说明
+//
+func ParseChaosRules(s string) ([]ChaosRule, error) {
+	var rules []ChaosRule
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var rule ChaosRule
+		for _, kv := range strings.Split(item, ",") {
+			kv = strings.TrimSpace(kv)
+			if kv == "" {
+				continue
+			}
+			ss := strings.SplitN(kv, "=", 2)
+			if len(ss) != 2 {
+				return nil, fmt.Errorf("%w. invalid item. item=%s", ErrChaosRule, kv)
+			}
+			k, v := strings.TrimSpace(ss[0]), strings.TrimSpace(ss[1])
+			if k == "addr" {
+				rule.Addr = v
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w. invalid value. item=%s", ErrChaosRule, kv)
+			}
+			switch k {
+			case "drop":
+				if n > 100 {
+					return nil, fmt.Errorf("%w. drop should be in [0, 100]. item=%s", ErrChaosRule, kv)
+				}
+				rule.DropPercent = n
+			case "delay_ms":
+				rule.DelayMs = n
+			case "kill_after_ms":
+				rule.KillAfterMs = n
+			default:
+				return nil, fmt.Errorf("%w. unknown key. item=%s", ErrChaosRule, kv)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func matchChaosRule(rules []ChaosRule, addr string) (ChaosRule, bool) {
+	for _, r := range rules {
+		if r.Addr == "" || strings.Contains(addr, r.Addr) {
+			return r, true
+		}
+	}
+	return ChaosRule{}, false
+}
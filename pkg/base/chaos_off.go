@@ -0,0 +1,25 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build !chaos
+
+package base
+
+import "net"
+
+// ChaosEnabled 是否使用`-tags chaos`编译
+const ChaosEnabled = false
+
+// SetChaosRules 没有使用`-tags chaos`编译时什么也不做
+//
+func SetChaosRules(rules []ChaosRule) {
+}
+
+func wrapChaosConn(conn net.Conn, addr string) net.Conn {
+	return conn
+}
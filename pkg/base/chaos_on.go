@@ -0,0 +1,113 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build chaos
+
+package base
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ChaosEnabled 是否使用`-tags chaos`编译
+const ChaosEnabled = true
+
+var (
+	chaosMutex sync.Mutex
+	chaosRules []ChaosRule
+	chaosRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func init() {
+	s := os.Getenv(ChaosEnvName)
+	if s == "" {
+		return
+	}
+	rules, err := ParseChaosRules(s)
+	if err != nil {
+		Log.Errorf("parse chaos rules from env failed, ignore. %s=%s, err=%+v", ChaosEnvName, s, err)
+		return
+	}
+	Log.Warnf("chaos enabled. rules=%+v", rules)
+	chaosRules = rules
+}
+
+// SetChaosRules 替换当前的规则，只对之后建立的连接生效，`rules`为空表示关闭故障注入
+//
+func SetChaosRules(rules []ChaosRule) {
+	chaosMutex.Lock()
+	defer chaosMutex.Unlock()
+	chaosRules = rules
+}
+
+func wrapChaosConn(conn net.Conn, addr string) net.Conn {
+	chaosMutex.Lock()
+	rule, ok := matchChaosRule(chaosRules, addr)
+	chaosMutex.Unlock()
+	if !ok {
+		return conn
+	}
+	Log.Warnf("chaos conn. addr=%s, rule=%+v", addr, rule)
+
+	c := &chaosConn{
+		Conn: conn,
+		rule: rule,
+	}
+	if rule.KillAfterMs > 0 {
+		c.killTimer = time.AfterFunc(time.Duration(rule.KillAfterMs)*time.Millisecond, func() {
+			Log.Warnf("chaos kill conn. addr=%s", addr)
+			_ = conn.Close()
+		})
+	}
+	return c
+}
+
+type chaosConn struct {
+	net.Conn
+	rule      ChaosRule
+	killTimer *time.Timer
+}
+
+func (c *chaosConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil || !chaosHit(c.rule.DropPercent) {
+			return n, err
+		}
+	}
+}
+
+func (c *chaosConn) Write(b []byte) (int, error) {
+	if c.rule.DelayMs > 0 {
+		time.Sleep(time.Duration(c.rule.DelayMs) * time.Millisecond)
+	}
+	if chaosHit(c.rule.DropPercent) {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *chaosConn) Close() error {
+	if c.killTimer != nil {
+		c.killTimer.Stop()
+	}
+	return c.Conn.Close()
+}
+
+func chaosHit(percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	chaosMutex.Lock()
+	defer chaosMutex.Unlock()
+	return chaosRand.Intn(100) < percent
+}
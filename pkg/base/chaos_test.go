@@ -0,0 +1,87 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestParseChaosRules(t *testing.T) {
+	rules, err := ParseChaosRules("addr=127.0.0.1:19350,drop=10,delay_ms=200 ; kill_after_ms=3000;")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []ChaosRule{
+		{Addr: "127.0.0.1:19350", DropPercent: 10, DelayMs: 200},
+		{KillAfterMs: 3000},
+	}, rules)
+
+	r, ok := matchChaosRule(rules, "127.0.0.1:19350")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 10, r.DropPercent)
+	r, ok = matchChaosRule(rules, "127.0.0.1:1935")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 3000, r.KillAfterMs)
+	_, ok = matchChaosRule(rules[:1], "127.0.0.1:1935")
+	assert.Equal(t, false, ok)
+
+	for _, s := range []string{"drop", "drop=101", "delay_ms=-1", "unknown=1"} {
+		_, err = ParseChaosRules(s)
+		assert.Equal(t, true, errors.Is(err, ErrChaosRule), s)
+	}
+}
+
+func TestChaosConn(t *testing.T) {
+	if !ChaosEnabled {
+		return
+	}
+	defer SetChaosRules(nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// 全部丢弃
+	SetChaosRules([]ChaosRule{{DropPercent: 100}})
+	conn, err := DialTcp(ln.Addr().String(), "")
+	assert.Equal(t, nil, err)
+	n, err := conn.Write([]byte("hello"))
+	assert.Equal(t, 5, n)
+	assert.Equal(t, nil, err)
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 5))
+	assert.IsNotNil(t, err)
+	_ = conn.Close()
+
+	// 到时间后强制关闭
+	SetChaosRules([]ChaosRule{{Addr: ln.Addr().String(), KillAfterMs: 50}})
+	conn, err = DialTcp(ln.Addr().String(), "")
+	assert.Equal(t, nil, err)
+	_, err = conn.Write([]byte("hello"))
+	assert.Equal(t, nil, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	assert.Equal(t, nil, err)
+	_, err = conn.Read(make([]byte, 5))
+	assert.IsNotNil(t, err)
+}
@@ -0,0 +1,272 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnGuard 防连接风暴，对 net.Listener 做封装，在Accept时：
+//
+// - 限制接入速率（令牌桶），超出速率的连接直接关闭
+// - 限制单个ip的并发连接数
+// - 连接建立后，如果在超时时间内没有完成握手（见 MarkHandshakeDone ），关闭连接
+//
+// 多个listener可以共用一个 ConnGuard ，此时速率、单ip连接数是所有listener的总和
+//
+// 注意，ip取的是tcp连接的对端地址，如果部署在四层负载均衡后面，应该关闭单ip连接数限制
+//
+type ConnGuard struct {
+	option ConnGuardOption
+
+	mutex      sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	ip2Conns   map[string]int
+	stat       StatConnGuard
+
+	lastWarnTime time.Time
+	warnStat     StatConnGuard // 上一次打印日志时的统计，用于计算差值
+}
+
+type ConnGuardOption struct {
+	AcceptRate         int // 每秒允许接入的连接数，0表示不限制
+	AcceptBurst        int // 允许的突发连接数，小于`AcceptRate`时使用`AcceptRate`
+	MaxConnsPerIp      int // 单个ip的并发连接数，0表示不限制
+	HandshakeTimeoutMs int // 握手超时时间，0表示不限制
+}
+
+// 超出限制时，日志最多每秒打印一次
+const connGuardWarnIntervalMs = 1000
+
+func NewConnGuard(option ConnGuardOption) *ConnGuard {
+	if option.AcceptBurst < option.AcceptRate {
+		option.AcceptBurst = option.AcceptRate
+	}
+	return &ConnGuard{
+		option:     option,
+		tokens:     float64(option.AcceptBurst),
+		lastRefill: time.Now(),
+		ip2Conns:   make(map[string]int),
+	}
+}
+
+// WrapListener
+//
+// @param handshake: 是否开启握手超时检查。
+//                   开启时，上层协议在握手完成后需要调用 MarkHandshakeDone ，否则连接会在超时后被关闭。
+//                   http服务器可以不开启，使用 http.Server 的ReadHeaderTimeout
+//
+func (g *ConnGuard) WrapListener(ln net.Listener, handshake bool) net.Listener {
+	return &connGuardListener{
+		Listener:  ln,
+		guard:     g,
+		handshake: handshake,
+	}
+}
+
+func (g *ConnGuard) HandshakeTimeout() time.Duration {
+	return time.Duration(g.option.HandshakeTimeoutMs) * time.Millisecond
+}
+
+func (g *ConnGuard) GetStat() StatConnGuard {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	stat := g.stat
+	stat.ActiveIps = len(g.ip2Conns)
+	return stat
+}
+
+// @return ip: 用于连接关闭时释放计数
+//
+func (g *ConnGuard) acquire(conn net.Conn, now time.Time) (ip string, ok bool) {
+	if addr, isTcp := conn.RemoteAddr().(*net.TCPAddr); isTcp {
+		ip = addr.IP.String()
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.option.AcceptRate > 0 {
+		g.tokens += now.Sub(g.lastRefill).Seconds() * float64(g.option.AcceptRate)
+		if g.tokens > float64(g.option.AcceptBurst) {
+			g.tokens = float64(g.option.AcceptBurst)
+		}
+		g.lastRefill = now
+		if g.tokens < 1 {
+			g.stat.RejectedRate++
+			g.warnIfNeeded(now)
+			return "", false
+		}
+		g.tokens--
+	}
+
+	if ip != "" && g.option.MaxConnsPerIp > 0 && g.ip2Conns[ip] >= g.option.MaxConnsPerIp {
+		g.stat.RejectedPerIp++
+		g.warnIfNeeded(now)
+		return "", false
+	}
+
+	if ip != "" {
+		g.ip2Conns[ip]++
+	}
+	g.stat.Accepted++
+	g.stat.ActiveConns++
+	return ip, true
+}
+
+func (g *ConnGuard) release(ip string, handshakeTimeout bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if ip != "" {
+		if g.ip2Conns[ip]--; g.ip2Conns[ip] <= 0 {
+			delete(g.ip2Conns, ip)
+		}
+	}
+	g.stat.ActiveConns--
+	if handshakeTimeout {
+		g.stat.HandshakeTimeout++
+		g.warnIfNeeded(time.Now())
+	}
+}
+
+// warnIfNeeded 调用时需持有锁
+func (g *ConnGuard) warnIfNeeded(now time.Time) {
+	if now.Sub(g.lastWarnTime) < connGuardWarnIntervalMs*time.Millisecond {
+		return
+	}
+	Log.Warnf("conn guard reject. rate=%d, per ip=%d, handshake timeout=%d, active conns=%d",
+		g.stat.RejectedRate-g.warnStat.RejectedRate, g.stat.RejectedPerIp-g.warnStat.RejectedPerIp,
+		g.stat.HandshakeTimeout-g.warnStat.HandshakeTimeout, g.stat.ActiveConns)
+	g.lastWarnTime = now
+	g.warnStat = g.stat
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type connGuardListener struct {
+	net.Listener
+	guard     *ConnGuard
+	handshake bool
+}
+
+func (l *connGuardListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		ip, ok := l.guard.acquire(conn, now)
+		if !ok {
+			_ = conn.Close()
+			continue
+		}
+
+		c := &connGuardConn{
+			Conn:  conn,
+			guard: l.guard,
+			ip:    ip,
+		}
+		if l.handshake && l.guard.option.HandshakeTimeoutMs > 0 {
+			c.handshakeDeadline = now.Add(l.guard.HandshakeTimeout())
+			_ = conn.SetDeadline(c.handshakeDeadline)
+		} else {
+			c.handshakeDone = true
+		}
+		return c, nil
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// connGuardConn 握手完成前，上层设置的deadline不会晚于握手超时时间
+type connGuardConn struct {
+	net.Conn
+	guard *ConnGuard
+	ip    string
+
+	mutex             sync.Mutex
+	handshakeDone     bool
+	handshakeDeadline time.Time
+	readDeadline      time.Time // 上层设置的deadline，握手完成后恢复
+	writeDeadline     time.Time
+
+	closeOnce sync.Once
+}
+
+// MarkHandshakeDone 通知 ConnGuard 该连接已完成握手，取消握手超时
+//
+// `conn`不是 ConnGuard 返回的连接（或者对其的封装，比如 ProxyProtocolConn ）时，什么也不做
+//
+func MarkHandshakeDone(conn net.Conn) {
+	for {
+		switch c := conn.(type) {
+		case *connGuardConn:
+			c.markHandshakeDone()
+			return
+		case *ProxyProtocolConn:
+			conn = c.Conn
+		default:
+			return
+		}
+	}
+}
+
+func (c *connGuardConn) markHandshakeDone() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.handshakeDone {
+		return
+	}
+	c.handshakeDone = true
+	_ = c.Conn.SetReadDeadline(c.readDeadline)
+	_ = c.Conn.SetWriteDeadline(c.writeDeadline)
+}
+
+func (c *connGuardConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.Conn.SetDeadline(c.limit(t))
+}
+
+func (c *connGuardConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(c.limit(t))
+}
+
+func (c *connGuardConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(c.limit(t))
+}
+
+func (c *connGuardConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		timeout := !c.handshakeDone && !time.Now().Before(c.handshakeDeadline)
+		c.mutex.Unlock()
+		c.guard.release(c.ip, timeout)
+	})
+	return c.Conn.Close()
+}
+
+// limit 调用时需持有锁
+func (c *connGuardConn) limit(t time.Time) time.Time {
+	if c.handshakeDone || (!t.IsZero() && t.Before(c.handshakeDeadline)) {
+		return t
+	}
+	return c.handshakeDeadline
+}
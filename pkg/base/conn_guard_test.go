@@ -0,0 +1,96 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestConnGuard_AcceptRate(t *testing.T) {
+	g := NewConnGuard(ConnGuardOption{AcceptRate: 2})
+	c, _ := net.Pipe()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		_, ok := g.acquire(c, now)
+		assert.Equal(t, true, ok)
+	}
+	_, ok := g.acquire(c, now)
+	assert.Equal(t, false, ok)
+	_, ok = g.acquire(c, now.Add(500*time.Millisecond))
+	assert.Equal(t, true, ok)
+	_, ok = g.acquire(c, now.Add(500*time.Millisecond))
+	assert.Equal(t, false, ok)
+
+	stat := g.GetStat()
+	assert.Equal(t, uint64(3), stat.Accepted)
+	assert.Equal(t, uint64(2), stat.RejectedRate)
+}
+
+func TestConnGuard_Listener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	g := NewConnGuard(ConnGuardOption{MaxConnsPerIp: 1, HandshakeTimeoutMs: 100})
+	nets, err := ParseCidrs([]string{"127.0.0.0/8"})
+	assert.Equal(t, nil, err)
+	ln := NewProxyProtocolListener(g.WrapListener(raw, true), nets)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			sc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- sc
+		}
+	}()
+
+	// 握手超时
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	assert.Equal(t, nil, err)
+	defer c1.Close()
+	sc1 := <-accepted
+	assert.Equal(t, 1, g.GetStat().ActiveIps)
+
+	// 超出单ip连接数，被直接关闭
+	c2, err := net.Dial("tcp", ln.Addr().String())
+	assert.Equal(t, nil, err)
+	defer c2.Close()
+	_, err = c2.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, uint64(1), g.GetStat().RejectedPerIp)
+
+	_, err = sc1.Read(make([]byte, 1))
+	assert.Equal(t, true, err != nil)
+	_ = sc1.Close()
+	stat := g.GetStat()
+	assert.Equal(t, uint64(1), stat.HandshakeTimeout)
+	assert.Equal(t, 0, stat.ActiveConns)
+
+	// 握手完成后不再超时
+	c3, err := net.Dial("tcp", ln.Addr().String())
+	assert.Equal(t, nil, err)
+	defer c3.Close()
+	sc3 := <-accepted
+	defer sc3.Close()
+	MarkHandshakeDone(sc3)
+	time.Sleep(200 * time.Millisecond)
+	_, err = c3.Write([]byte("a"))
+	assert.Equal(t, nil, err)
+	b := make([]byte, 1)
+	_, err = io.ReadFull(sc3, b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a", string(b))
+}
@@ -0,0 +1,15 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build !linux,!darwin,!freebsd,!windows
+
+package base
+
+func GetDiskFreeBytes(path string) (int64, error) {
+	return -1, ErrDiskStatNotSupported
+}
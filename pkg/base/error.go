@@ -23,6 +23,7 @@ var (
 // ----- pkg/aac -------------------------------------------------------------------------------------------------------
 
 var ErrSamplingFrequencyIndex = errors.New("lal.aac: invalid sampling frequency index")
+var ErrAacAdtsUnsupported = errors.New("lal.aac: audio object type or sampling frequency not supported by adts")
 
 // ----- pkg/aac -------------------------------------------------------------------------------------------------------
 
@@ -37,8 +38,42 @@ var (
 	ErrSessionNotStarted = errors.New("lal.base: session has not been started yet")
 
 	ErrInvalidUrl = errors.New("lal.base: invalid url")
+
+	ErrInvalidProxyUrl = errors.New("lal.base: invalid proxy url")
+	ErrProxyHandshake  = errors.New("lal.base: proxy handshake failed")
+	ErrProxyProtocol   = errors.New("lal.base: invalid proxy protocol header")
+
+	ErrProxyProtocolUntrusted = errors.New("lal.base: proxy protocol header from untrusted peer")
+
+	ErrDiskStatNotSupported = errors.New("lal.base: disk stat not supported on this platform")
+
+	ErrNtpResponse = errors.New("lal.base: invalid ntp response")
+
+	ErrTlsOption = errors.New("lal.base: invalid tls option")
+	ErrTlsOcsp   = errors.New("lal.base: invalid ocsp response")
+
+	ErrSecrets     = errors.New("lal.base: invalid secrets")
+	ErrFileEncrypt = errors.New("lal.base: invalid encrypted file")
+
+	ErrPidFile               = errors.New("lal.base: pid file")
+	ErrReusePortNotSupported = errors.New("lal.base: SO_REUSEPORT not supported on this platform")
+
+	ErrConnTimeout = errors.New("lal.base: conn read or write timeout")
+
+	ErrChaosRule = errors.New("lal.base: invalid chaos rule")
+)
+
+// ----- pkg/esingest --------------------------------------------------------------------------------------------------
+
+var (
+	ErrEsIngest         = errors.New("lal.esingest: fxxk")
+	ErrEsIngestRejected = errors.New("lal.esingest: rejected by server")
 )
 
+// ----- pkg/geoip -----------------------------------------------------------------------------------------------------
+
+var ErrGeoIp = errors.New("lal.geoip: fxxk")
+
 // ----- pkg/hevc ------------------------------------------------------------------------------------------------------
 
 var ErrHevc = errors.New("lal.hevc: fxxk")
@@ -47,6 +82,17 @@ var ErrHevc = errors.New("lal.hevc: fxxk")
 
 var ErrHls = errors.New("lal.hls: fxxk")
 
+// ----- pkg/mp4 -------------------------------------------------------------------------------------------------------
+
+var ErrMp4 = errors.New("lal.mp4: fxxk")
+
+// ----- pkg/onvif -----------------------------------------------------------------------------------------------------
+
+var (
+	ErrOnvif      = errors.New("lal.onvif: fxxk")
+	ErrOnvifFault = errors.New("lal.onvif: soap fault")
+)
+
 // ----- pkg/rtmp ------------------------------------------------------------------------------------------------------
 
 var (
@@ -73,6 +119,10 @@ var (
 
 var ErrSdp = errors.New("lal.sdp: fxxk")
 
+// ----- pkg/streamedit ------------------------------------------------------------------------------------------------
+
+var ErrStreamEditFormat = errors.New("lal.streamedit: unsupported or mismatched file format")
+
 // ----- pkg/logic -------------------------------------------------------------------------------------------------------
 
 var (
@@ -80,6 +130,32 @@ var (
 
 	ErrSimpleAuthParamNotFound = errors.New("lal.logic: simple auth failed since url param lal_secret not found")
 	ErrSimpleAuthFailed        = errors.New("lal.logic: simple auth failed since url param lal_secret invalid")
+
+	ErrSidecarAuthDenied = errors.New("lal.logic: sidecar plugin auth denied")
+
+	ErrScriptHookDenied = errors.New("lal.logic: script hook denied")
+	ErrScriptHookSyntax = errors.New("lal.logic: script hook syntax error")
+
+	ErrFilePublishFileType = errors.New("lal.logic: file publish file type not support")
+	ErrFilePublishNoAvData = errors.New("lal.logic: file publish no av data after seek position")
+	ErrFilePublishStopped  = errors.New("lal.logic: file publish stopped")
+
+	ErrGeoAccessDenied = errors.New("lal.logic: geo access policy denied")
+
+	ErrStreamKeyUnknown  = errors.New("lal.logic: stream key unknown")
+	ErrStreamKeyDenied   = errors.New("lal.logic: stream key resolve denied")
+	ErrStreamKeyConflict = errors.New("lal.logic: stream key conflict")
+
+	ErrPublishTokenNotFound = errors.New("lal.logic: publish token not found in url param")
+	ErrPublishTokenInvalid  = errors.New("lal.logic: publish token invalid")
+
+	ErrAdmissionRejected = errors.New("lal.logic: admission rejected since egress bandwidth budget exceeded")
+
+	ErrOutboundQueueFull   = errors.New("lal.logic: outbound queue full")
+	ErrOutboundBreakerOpen = errors.New("lal.logic: outbound circuit breaker open")
+	ErrOutboundStatusCode  = errors.New("lal.logic: outbound unexpected http status code")
+	ErrOutboundDisposed    = errors.New("lal.logic: outbound pool disposed")
+	ErrOutboundTimeout     = errors.New("lal.logic: outbound sync request timeout")
 )
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -0,0 +1,447 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// file_encrypt.go
+//
+// 录制文件、hls切片的落盘加密
+//
+// 每个流使用一个随机生成的数据密钥，数据密钥使用主密钥加密（key wrapping）后存放在文件头中，所以解密时只需要主密钥。
+// 主密钥为口令时，使用salt派生（见 newSecretsAead ），salt也存放在文件头中
+//
+// 文件格式：
+//   magic(8) | salt(16) | wrapped key len(2) | wrapped key | nonce prefix(8) | chunk0 | chunk1 | ...
+//
+// 明文按 EncryptedFileChunkSize 分块，每块使用AES-256-GCM加密，nonce为nonce prefix + 块序号(4字节大端)，
+// 附加数据（AD）为1字节的结束标志，最后一块为1，其他为0。Close时总是写入结束块（明文可能为空），
+// 所以文件在块边界被截断时，因为没有结束块可以被发现
+// 支持按偏移随机读取，所以解密后可以直接用于 http.ServeContent 响应Range请求
+//
+// 注意，写入时最后一个不满的块在Close时才写入文件，正在写入的文件只能读取到已经写入的完整块。
+// 没有结束块的文件（正在写入、被截断、写入时进程异常退出），读取到已经写入的完整块的末尾时返回错误，而不是io.EOF
+//
+
+const EncryptedFileChunkSize = 64 * 1024
+
+var encryptedFileMagic = []byte("LALENC02")
+
+// FileKeyring 落盘加密的密钥管理，为nil时表示不加密，此时 Create 创建普通文件，OpenFile 打开普通文件
+//
+type FileKeyring struct {
+	masterKey string
+	salt      []byte      // 写入文件时使用
+	master    cipher.AEAD // `salt`派生的主密钥
+
+	mutex      sync.Mutex
+	streamKeys map[string]fileDataKey
+	masters    map[string]cipher.AEAD // key: salt，读取其他salt的文件时派生的主密钥
+}
+
+type fileDataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+}
+
+// NewFileKeyring
+//
+// @param masterKey: 64个字符的hex时直接作为AES-256的密钥，否则做为口令，使用scrypt和随机的salt派生密钥
+//
+func NewFileKeyring(masterKey string) (*FileKeyring, error) {
+	salt := make([]byte, secretsSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	master, err := newSecretsAead(masterKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &FileKeyring{
+		masterKey:  masterKey,
+		salt:       salt,
+		master:     master,
+		streamKeys: make(map[string]fileDataKey),
+		masters:    map[string]cipher.AEAD{string(salt): master},
+	}, nil
+}
+
+// Create 创建文件，`streamName`相同的文件使用相同的数据密钥
+//
+func (k *FileKeyring) Create(filename string, streamName string) (io.WriteCloser, error) {
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return fp, nil
+	}
+	w, err := k.NewWriter(fp, streamName)
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriter 加密后写入`w`，Close时同时关闭`w`
+//
+func (k *FileKeyring) NewWriter(w io.WriteCloser, streamName string) (io.WriteCloser, error) {
+	if k == nil {
+		return w, nil
+	}
+	dk, err := k.streamKey(streamName)
+	if err != nil {
+		return nil, err
+	}
+	ew := &encryptedWriter{
+		w:    w,
+		aead: dk.aead,
+		buf:  make([]byte, 0, EncryptedFileChunkSize),
+	}
+	if _, err = rand.Read(ew.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(encryptedFileMagic)+len(k.salt)+2+len(dk.wrapped)+8)
+	header = append(header, encryptedFileMagic...)
+	header = append(header, k.salt...)
+	header = append(header, byte(len(dk.wrapped)>>8), byte(len(dk.wrapped)))
+	header = append(header, dk.wrapped...)
+	header = append(header, ew.noncePrefix[:]...)
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+// OpenFile 打开文件用于读取，加密的文件读取到的是解密后的内容，非加密的文件直接读取
+//
+func (k *FileKeyring) OpenFile(filename string) (ReadSeekCloser, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(encryptedFileMagic))
+	n, _ := io.ReadFull(fp, magic)
+	if n != len(magic) || !bytes.Equal(magic, encryptedFileMagic) {
+		if _, err = fp.Seek(0, io.SeekStart); err != nil {
+			_ = fp.Close()
+			return nil, err
+		}
+		return fp, nil
+	}
+
+	fi, err := fp.Stat()
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	r, err := k.newReader(fp, fi.Size())
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return &readSeekCloser{encryptedReader: r, c: fp}, nil
+}
+
+// DecryptBytes 解密整个文件的内容，非加密的内容原样返回
+//
+func (k *FileKeyring) DecryptBytes(b []byte) ([]byte, error) {
+	if !IsEncryptedFile(b) {
+		return b, nil
+	}
+	r, err := k.newReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// IsEncryptedFile `b`为文件开头的内容
+//
+func IsEncryptedFile(b []byte) bool {
+	return bytes.HasPrefix(b, encryptedFileMagic)
+}
+
+// ReadSeekCloser 同go1.16的 io.ReadSeekCloser
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// ReleaseStream 流的录制结束时调用，释放流的数据密钥。之后创建的文件使用新的数据密钥
+//
+func (k *FileKeyring) ReleaseStream(streamName string) {
+	if k == nil {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	delete(k.streamKeys, streamName)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (k *FileKeyring) streamKey(streamName string) (fileDataKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if dk, ok := k.streamKeys[streamName]; ok {
+		return dk, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fileDataKey{}, err
+	}
+	aead, err := newAesGcm(key)
+	if err != nil {
+		return fileDataKey{}, err
+	}
+	nonce := make([]byte, k.master.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return fileDataKey{}, err
+	}
+	dk := fileDataKey{
+		aead:    aead,
+		wrapped: k.master.Seal(nonce, nonce, key, nil),
+	}
+	k.streamKeys[streamName] = dk
+	return dk, nil
+}
+
+// masterOf 文件头中`salt`对应的主密钥。口令派生的开销较大，缓存派生结果
+func (k *FileKeyring) masterOf(salt []byte) (cipher.AEAD, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if master, ok := k.masters[string(salt)]; ok {
+		return master, nil
+	}
+	master, err := newSecretsAead(k.masterKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	k.masters[string(salt)] = master
+	return master, nil
+}
+
+func (k *FileKeyring) newReader(r io.ReaderAt, fileSize int64) (*encryptedReader, error) {
+	if k == nil {
+		return nil, fmt.Errorf("%w. file is encrypted but no key configured", ErrFileEncrypt)
+	}
+
+	// 解析文件头
+	fixed := make([]byte, len(encryptedFileMagic)+secretsSaltSize+2)
+	if _, err := r.ReadAt(fixed, 0); err != nil {
+		return nil, fmt.Errorf("%w. read header failed. err=%+v", ErrFileEncrypt, err)
+	}
+	master, err := k.masterOf(fixed[len(encryptedFileMagic) : len(encryptedFileMagic)+secretsSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	wrappedLen := int(fixed[len(fixed)-2])<<8 | int(fixed[len(fixed)-1])
+	rest := make([]byte, wrappedLen+8)
+	if _, err := r.ReadAt(rest, int64(len(fixed))); err != nil {
+		return nil, fmt.Errorf("%w. read header failed. err=%+v", ErrFileEncrypt, err)
+	}
+	wrapped := rest[:wrappedLen]
+	ns := master.NonceSize()
+	if len(wrapped) < ns {
+		return nil, fmt.Errorf("%w. invalid wrapped key", ErrFileEncrypt)
+	}
+	key, err := master.Open(nil, wrapped[:ns], wrapped[ns:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w. unwrap key failed, master key may be wrong", ErrFileEncrypt)
+	}
+	aead, err := newAesGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	er := &encryptedReader{
+		r:          r,
+		aead:       aead,
+		bodyOffset: int64(len(fixed) + len(rest)),
+		chunkIndex: -1,
+	}
+	copy(er.noncePrefix[:], rest[wrappedLen:])
+
+	// 计算明文长度。结束块解密失败时视为没有结束块，只读取前面完整的块
+	sealedChunkSize := int64(EncryptedFileChunkSize + aead.Overhead())
+	bodySize := fileSize - er.bodyOffset
+	fullNum := bodySize / sealedChunkSize
+	er.size = fullNum * EncryptedFileChunkSize
+	if tail := bodySize % sealedChunkSize; tail >= int64(aead.Overhead()) {
+		sealed := make([]byte, tail)
+		if _, err := r.ReadAt(sealed, er.bodyOffset+fullNum*sealedChunkSize); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if last, err := aead.Open(sealed[:0], chunkNonce(er.noncePrefix, uint32(fullNum)), sealed, encryptedChunkAd(true)); err == nil {
+			er.complete = true
+			er.lastChunk = last
+			er.size += int64(len(last))
+		}
+	}
+	return er, nil
+}
+
+type encryptedWriter struct {
+	w           io.WriteCloser
+	aead        cipher.AEAD
+	noncePrefix [8]byte
+	chunkIndex  uint32
+	buf         []byte
+}
+
+func (ew *encryptedWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		l := EncryptedFileChunkSize - len(ew.buf)
+		if l > len(b) {
+			l = len(b)
+		}
+		ew.buf = append(ew.buf, b[:l]...)
+		b = b[l:]
+		if len(ew.buf) == EncryptedFileChunkSize {
+			if err := ew.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (ew *encryptedWriter) Close() error {
+	// 没有剩余数据时也写入空的结束块
+	err := ew.flush(true)
+	if err2 := ew.w.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Name 底层为 *os.File 时，返回文件名
+func (ew *encryptedWriter) Name() string {
+	if n, ok := ew.w.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+func (ew *encryptedWriter) flush(final bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.noncePrefix, ew.chunkIndex), ew.buf, encryptedChunkAd(final))
+	ew.chunkIndex++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(sealed)
+	return err
+}
+
+type encryptedReader struct {
+	r           io.ReaderAt
+	aead        cipher.AEAD
+	noncePrefix [8]byte
+	bodyOffset  int64
+	size        int64  // 明文长度
+	complete    bool   // 是否有结束块
+	lastChunk   []byte // 解密后的结束块
+
+	pos        int64
+	chunkIndex int64 // 缓存的解密后的块
+	chunk      []byte
+}
+
+func (er *encryptedReader) Read(b []byte) (int, error) {
+	if er.pos >= er.size {
+		if !er.complete {
+			return 0, fmt.Errorf("%w. file incomplete, truncated or still being written", ErrFileEncrypt)
+		}
+		return 0, io.EOF
+	}
+	index := er.pos / EncryptedFileChunkSize
+	if index != er.chunkIndex {
+		if err := er.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, er.chunk[er.pos-index*EncryptedFileChunkSize:])
+	er.pos += int64(n)
+	return n, nil
+}
+
+func (er *encryptedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += er.pos
+	case io.SeekEnd:
+		offset += er.size
+	default:
+		return 0, fmt.Errorf("%w. invalid whence. whence=%d", ErrFileEncrypt, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%w. negative position", ErrFileEncrypt)
+	}
+	er.pos = offset
+	return offset, nil
+}
+
+func (er *encryptedReader) loadChunk(index int64) error {
+	if er.complete && index == er.size/EncryptedFileChunkSize {
+		// 结束块在newReader中已经解密
+		er.chunkIndex = index
+		er.chunk = er.lastChunk
+		return nil
+	}
+	sealed := make([]byte, EncryptedFileChunkSize+er.aead.Overhead())
+	off := er.bodyOffset + index*int64(len(sealed))
+	if _, err := er.r.ReadAt(sealed, off); err != nil {
+		return err
+	}
+	chunk, err := er.aead.Open(sealed[:0], chunkNonce(er.noncePrefix, uint32(index)), sealed, encryptedChunkAd(false))
+	if err != nil {
+		return fmt.Errorf("%w. decrypt chunk failed. index=%d", ErrFileEncrypt, index)
+	}
+	er.chunkIndex = index
+	er.chunk = chunk
+	return nil
+}
+
+type readSeekCloser struct {
+	*encryptedReader
+	c io.Closer
+}
+
+func (rsc *readSeekCloser) Close() error {
+	return rsc.c.Close()
+}
+
+func encryptedChunkAd(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func chunkNonce(prefix [8]byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix[:])
+	binary.BigEndian.PutUint32(nonce[8:], index)
+	return nonce
+}
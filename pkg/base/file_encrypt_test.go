@@ -0,0 +1,151 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestFileKeyring(t *testing.T) {
+	dir := t.TempDir()
+	keyring, err := NewFileKeyring("master passphrase")
+	assert.Equal(t, nil, err)
+
+	plain := make([]byte, EncryptedFileChunkSize*2+1000)
+	rand.Read(plain)
+
+	filename := filepath.Join(dir, "test110-1.flv")
+	w, err := keyring.Create(filename, "test110")
+	assert.Equal(t, nil, err)
+	// 分多次写入，跨越块的边界
+	for i := 0; i < len(plain); i += 3000 {
+		end := i + 3000
+		if end > len(plain) {
+			end = len(plain)
+		}
+		_, err = w.Write(plain[i:end])
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, w.Close())
+
+	content, _ := ioutil.ReadFile(filename)
+	assert.Equal(t, true, IsEncryptedFile(content))
+	assert.Equal(t, false, bytes.Contains(content, plain[:64]))
+
+	// 顺序读取
+	r, err := keyring.OpenFile(filename)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, plain, b)
+
+	// 随机读取
+	size, err := r.Seek(0, io.SeekEnd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(plain)), size)
+	for _, off := range []int64{0, 100, EncryptedFileChunkSize - 10, EncryptedFileChunkSize * 2, int64(len(plain)) - 1} {
+		_, err = r.Seek(off, io.SeekStart)
+		assert.Equal(t, nil, err)
+		buf := make([]byte, 20)
+		n, err := io.ReadFull(r, buf)
+		if off+20 > int64(len(plain)) {
+			assert.Equal(t, io.ErrUnexpectedEOF, err)
+		} else {
+			assert.Equal(t, nil, err)
+		}
+		assert.Equal(t, plain[off:off+int64(n)], buf[:n])
+	}
+	_ = r.Close()
+
+	d, err := keyring.DecryptBytes(content)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, plain, d)
+
+	// 同一个流使用相同的数据密钥，另一个主密钥相同的keyring也可以解密
+	keyring2, _ := NewFileKeyring("master passphrase")
+	d, err = keyring2.DecryptBytes(content)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, plain, d)
+
+	// 不完整的尾部，比如正在写入的文件，可以按长度读取已经写入的完整块，读取到末尾时返回错误
+	truncated := content[:len(content)-500]
+	er, err := keyring.newReader(bytes.NewReader(truncated), int64(len(truncated)))
+	assert.Equal(t, nil, err)
+	size, _ = er.Seek(0, io.SeekEnd)
+	assert.Equal(t, int64(EncryptedFileChunkSize*2), size)
+	_, _ = er.Seek(0, io.SeekStart)
+	d, err = ioutil.ReadAll(io.LimitReader(er, size))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, plain[:EncryptedFileChunkSize*2], d)
+	_, err = keyring.DecryptBytes(truncated)
+	assert.Equal(t, true, errors.Is(err, ErrFileEncrypt))
+	// 在块的边界截断
+	truncated = content[:len(content)-(1000+16)]
+	_, err = keyring.DecryptBytes(truncated)
+	assert.Equal(t, true, errors.Is(err, ErrFileEncrypt))
+
+	// 明文长度是块大小的整数倍时，结束块为空
+	var buf bytes.Buffer
+	w2, err := keyring.NewWriter(nopWriteCloser{&buf}, "test110")
+	assert.Equal(t, nil, err)
+	_, _ = w2.Write(plain[:EncryptedFileChunkSize*2])
+	_ = w2.Close()
+	d, err = keyring.DecryptBytes(buf.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, plain[:EncryptedFileChunkSize*2], d)
+
+	// 录制结束后释放数据密钥，之后的文件使用新的数据密钥
+	dk1, _ := keyring.streamKey("test110")
+	keyring.ReleaseStream("test110")
+	dk2, _ := keyring.streamKey("test110")
+	assert.Equal(t, false, bytes.Equal(dk1.wrapped, dk2.wrapped))
+
+	// 主密钥错误，或者没有配置密钥
+	keyring3, _ := NewFileKeyring("wrong")
+	_, err = keyring3.DecryptBytes(content)
+	assert.Equal(t, true, errors.Is(err, ErrFileEncrypt))
+	var nilKeyring *FileKeyring
+	_, err = nilKeyring.OpenFile(filename)
+	assert.Equal(t, true, errors.Is(err, ErrFileEncrypt))
+
+	// 篡改
+	tampered := append([]byte{}, content...)
+	tampered[len(tampered)-EncryptedFileChunkSize] ^= 1
+	_, err = keyring.DecryptBytes(tampered)
+	assert.Equal(t, true, errors.Is(err, ErrFileEncrypt))
+
+	// 非加密的文件
+	plainFilename := filepath.Join(dir, "plain.flv")
+	w, err = nilKeyring.Create(plainFilename, "test110")
+	assert.Equal(t, nil, err)
+	_, _ = w.Write([]byte("FLV"))
+	_ = w.Close()
+	r, err = keyring.OpenFile(plainFilename)
+	assert.Equal(t, nil, err)
+	b, _ = ioutil.ReadAll(r)
+	_ = r.Close()
+	assert.Equal(t, []byte("FLV"), b)
+	d, err = nilKeyring.DecryptBytes([]byte("FLV"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("FLV"), d)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
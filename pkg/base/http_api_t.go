@@ -10,7 +10,7 @@ package base
 
 // 文档见： https://pengrl.com/p/20100/
 
-const HttpApiVersion = "v0.1.4"
+const HttpApiVersion = "v0.1.6"
 
 const (
 	ErrorCodeSucc            = 0
@@ -21,6 +21,14 @@ const (
 	DespParamMissing         = "param missing"
 	ErrorCodeSessionNotFound = 1003
 	DespSessionNotFound      = "session not found"
+	ErrorCodeNotEnabled      = 1004
+	DespNotEnabled           = "not enabled"
+	ErrorCodePullTimeout     = 1005
+	DespPullTimeout          = "pull timeout"
+	ErrorCodeParamInvalid    = 1006
+	DespParamInvalid         = "param invalid"
+	ErrorCodeInStreamExist   = 1007
+	DespInStreamExist        = "in stream already exist"
 )
 
 type HttpResponseBasic struct {
@@ -54,15 +62,285 @@ type ApiStatGroup struct {
 	Data *StatGroup `json:"data"`
 }
 
+type ApiStatRecordRetention struct {
+	HttpResponseBasic
+	Data StatRecordRetention `json:"data"`
+}
+
+type ApiStatHistory struct {
+	HttpResponseBasic
+	Data struct {
+		Streams []StatStreamHistory `json:"streams"`
+	} `json:"data"`
+}
+
+type ApiStatStreamEvents struct {
+	HttpResponseBasic
+	Data struct {
+		StreamName string        `json:"stream_name"`
+		Events     []StreamEvent `json:"events"` // 按时间从旧到新
+	} `json:"data"`
+}
+
+// StreamEvent 流的时间线上的一个事件，见 logic.StreamEventLog
+type StreamEvent struct {
+	Time       string `json:"time"`
+	Type       string `json:"type"` // 见 logic.StreamEventTypePubStart 等
+	SessionId  string `json:"session_id,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Detail     string `json:"detail,omitempty"` // 可读的描述，比如失败的原因
+	RequestId  string `json:"request_id,omitempty"`
+}
+
+// ApiStatRecordFlvKeyframes 录制的flv文件的关键帧索引，`Times`和`FilePositions`一一对应，含义同onMetaData中的keyframes
+type ApiStatRecordFlvKeyframes struct {
+	HttpResponseBasic
+	Data struct {
+		Filename      string    `json:"filename"`
+		DurationMs    uint32    `json:"duration_ms"`
+		Times         []float64 `json:"times"`         // 关键帧的时间戳，单位秒
+		FilePositions []int64   `json:"filepositions"` // 关键帧tag在文件中的字节偏移
+	} `json:"data"`
+}
+
+const (
+	HealthStatusOk   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// ApiHealth /healthz、/readyz的响应，和stat api不同，失败时http状态码为503
+type ApiHealth struct {
+	Status string           `json:"status"` // HealthStatusOk 或 HealthStatusFail
+	Checks []ApiHealthCheck `json:"checks"`
+}
+
+type ApiHealthCheck struct {
+	Name string `json:"name"`
+	Ok   bool   `json:"ok"`
+	Msg  string `json:"msg,omitempty"`
+}
+
+type ApiStatRateLimit struct {
+	HttpResponseBasic
+	Data StatRateLimit `json:"data"`
+}
+
+type ApiStatConnGuard struct {
+	HttpResponseBasic
+	Data StatConnGuard `json:"data"`
+}
+
 type ApiCtrlStartPullReq struct {
 	Protocol   string `json:"protocol"`
 	Addr       string `json:"addr"`
 	AppName    string `json:"app_name"`
 	StreamName string `json:"stream_name"`
 	UrlParam   string `json:"url_param"`
+
+	// WaitTimeoutMs 大于0时，同步等待回源拉流收到音视频数据、并且音视频编码信息完整后再返回，最多等待该时长，单位毫秒
+	WaitTimeoutMs int `json:"wait_timeout_ms"`
+
+	// KeepWithoutSub 为true时，group不存在则创建，没有sub session也保持拉流，由调用方通过stop_pull停止。
+	// 用于调度服务提前预热热门流，以及自己控制回源拉流的生命周期
+	KeepWithoutSub bool `json:"keep_without_sub"`
+
+	IdempotencyKey string `json:"idempotency_key"` // 幂等key，也可以通过`Idempotency-Key` header携带
+
+	// RequestId 调用方的request id，也可以通过`X-Request-ID` header（见配置`trace.request_id_header`）携带，都没有时由lalserver生成。
+	// 会出现在回源拉流的事件通知、stat中session的标签以及日志中，用于关联调用方的请求和回源拉流
+	RequestId string `json:"request_id"`
+}
+
+// ApiCtrlStartPullResp 只有 ApiCtrlStartPullReq.WaitTimeoutMs 大于0并且拉流成功时，`Data`中才有内容
+//
+type ApiCtrlStartPullResp struct {
+	HttpResponseBasic
+	Data struct {
+		SessionId   string `json:"session_id"`
+		AudioCodec  string `json:"audio_codec"`
+		VideoCodec  string `json:"video_codec"`
+		VideoWidth  int    `json:"video_width"`
+		VideoHeight int    `json:"video_height"`
+	} `json:"data"`
+}
+
+// ApiCtrlBatchStartPullReq 批量start_pull，每一项的处理和单个start_pull相同。设置了wait_timeout_ms的项并发等待
+//
+type ApiCtrlBatchStartPullReq struct {
+	Items          []ApiCtrlStartPullReq `json:"items"`
+	IdempotencyKey string                `json:"idempotency_key"`
+}
+
+// ApiCtrlBatchStartPullResp `Results`和请求中的`Items`一一对应
+//
+type ApiCtrlBatchStartPullResp struct {
+	HttpResponseBasic
+	Data struct {
+		Results []ApiCtrlStartPullResp `json:"results"`
+	} `json:"data"`
+}
+
+// ApiCtrlStopPull 停止start_pull触发的回源拉流
+//
+type ApiCtrlStopPull struct {
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
 }
 
 type ApiCtrlKickOutSession struct {
+	StreamName     string `json:"stream_name"`
+	SessionId      string `json:"session_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type ApiCtrlBatchKickOutSessionReq struct {
+	Items          []ApiCtrlKickOutSession `json:"items"`
+	IdempotencyKey string                  `json:"idempotency_key"`
+}
+
+type ApiCtrlBatchKickOutSessionResp struct {
+	HttpResponseBasic
+	Data struct {
+		Results []HttpResponseBasic `json:"results"`
+	} `json:"data"`
+}
+
+type ApiCtrlTagSession struct {
+	StreamName string            `json:"stream_name"`
+	SessionId  string            `json:"session_id"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// ApiCtrlSetSubtitle 给流的hls挂载WebVTT字幕，`Tracks`为空表示取消挂载
+type ApiCtrlSetSubtitle struct {
+	StreamName string             `json:"stream_name"`
+	Tracks     []ApiSubtitleTrack `json:"tracks"`
+}
+
+// ApiCtrlSetMetadata 覆盖流的onMetaData中的字段，`Fields`中值为null表示删除该字段的覆盖，值只支持数字、字符串、布尔
+type ApiCtrlSetMetadata struct {
+	StreamName string                 `json:"stream_name"`
+	Fields     map[string]interface{} `json:"fields"`
+	Reset      bool                   `json:"reset"` // 为true时，先清空之前所有的覆盖
+}
+
+// ApiCtrlInjectData 往流中注入一个data message，比如onCuePoint、onTextData，`Data`中的值只支持数字、字符串、布尔以及嵌套的对象
+type ApiCtrlInjectData struct {
+	StreamName string                 `json:"stream_name"`
+	Name       string                 `json:"name"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// ApiCtrlStartFilePublishReq 将`root_dir`下的本地文件作为直播流输入到`StreamName`对应的group中
+//
+type ApiCtrlStartFilePublishReq struct {
+	StreamName string `json:"stream_name"`
+	Filename   string `json:"filename"` // 相对配置`file_publish.root_dir`的路径，支持flv、mp4
+	Loop       bool   `json:"loop"`     // 为true时，播放结束后从头开始循环播放，直到调用stop_file_publish
+	SeekMs     int64  `json:"seek_ms"`  // 从该位置之后的第一个关键帧开始播放，单位毫秒
+}
+
+type ApiCtrlStartFilePublishResp struct {
+	HttpResponseBasic
+	Data struct {
+		SessionId string `json:"session_id"`
+	} `json:"data"`
+}
+
+type ApiCtrlStopFilePublish struct {
+	StreamName string `json:"stream_name"`
+}
+
+// ApiCtrlSwitchSource 将`SourceStreamName`对应流的数据作为`StreamName`的输出，在下一个关键帧处切换，sub不需要断开重连
+//
+// `SourceStreamName`为空或者和`StreamName`相同时，切换回`StreamName`自身的输入
+//
+type ApiCtrlSwitchSource struct {
+	StreamName       string `json:"stream_name"`
+	SourceStreamName string `json:"source_stream_name"`
+}
+
+// ApiCtrlRotateStreamKeyReq 更换`StreamName`的推流密钥，旧的密钥立即失效
+//
+// `StreamKey`为空时随机生成
+//
+type ApiCtrlRotateStreamKeyReq struct {
+	StreamName string `json:"stream_name"`
+	StreamKey  string `json:"stream_key"`
+	KickOutPub bool   `json:"kick_out_pub"` // 是否同时关闭正在使用旧密钥的推流
+}
+
+type ApiCtrlRotateStreamKeyResp struct {
+	HttpResponseBasic
+	Data struct {
+		StreamName string `json:"stream_name"`
+		StreamKey  string `json:"stream_key"`
+	} `json:"data"`
+}
+
+// ApiCtrlCreatePublishTokenReq 生成一次性推流token，见 `publish_token` 配置
+type ApiCtrlCreatePublishTokenReq struct {
+	AppName    string `json:"app_name"` // 为空则不校验app name
 	StreamName string `json:"stream_name"`
-	SessionId  string `json:"session_id"`
+	TtlSec     int    `json:"ttl_sec"` // token的有效期，为0则使用配置的默认值
+}
+
+type ApiCtrlCreatePublishTokenResp struct {
+	HttpResponseBasic
+	Data struct {
+		Token      string `json:"token"`
+		Url        string `json:"url"`         // 携带token的rtmp推流地址
+		ExpireTime string `json:"expire_time"` // 过期时间，在此之前开始推流
+	} `json:"data"`
+}
+
+type ApiSubtitleTrack struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Uri      string `json:"uri"` // WebVTT字幕m3u8的地址
+	Default  bool   `json:"default"`
+}
+
+type ApiStatSessionsByIp struct {
+	HttpResponseBasic
+	Data struct {
+		Sessions []StatIpSession `json:"sessions"`
+	} `json:"data"`
+}
+
+type ApiCtrlKickByIp struct {
+	Ip             string `json:"ip"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type ApiCtrlKickByIpResp struct {
+	HttpResponseBasic
+	Data struct {
+		KickedCount int `json:"kicked_count"`
+	} `json:"data"`
+}
+
+type ApiStatAudit struct {
+	HttpResponseBasic
+	Data struct {
+		Entries []AuditEntry `json:"entries"`
+	} `json:"data"`
+}
+
+// AuditEntry 一次ctrl类型http api调用的审计记录
+type AuditEntry struct {
+	Time       string `json:"time"`
+	Actor      string `json:"actor"`       // 调用方标识，来自配置的`http_api.audit.actor_header`，没有时为空
+	RemoteAddr string `json:"remote_addr"` // 调用方地址
+	Api        string `json:"api"`
+	Request    string `json:"request"` // 请求参数，请求body为空时为url中的query
+	ErrorCode  int    `json:"error_code"`
+	Desp       string `json:"desp"`
+	RequestId  string `json:"request_id,omitempty"` // 见 ApiCtrlStartPullReq.RequestId
+}
+
+type ApiDebugGroup struct {
+	HttpResponseBasic
+	Data *DebugGroupState `json:"data"`
 }
@@ -0,0 +1,92 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HttpHeaderOption HTTP响应中CORS、Cache-Control以及自定义header的配置
+//
+type HttpHeaderOption struct {
+	CorsAllowOrigins     []string          `json:"cors_allow_origins"`     // 允许跨域访问的Origin列表，包含"*"表示允许所有Origin，为空则不返回CORS相关的header
+	CorsAllowCredentials bool              `json:"cors_allow_credentials"` // 是否返回`Access-Control-Allow-Credentials: true`
+	CacheControl         string            `json:"cache_control"`          // 为空则不返回Cache-Control
+	ExtraHeaders         map[string]string `json:"extra_headers"`          // 额外的自定义header
+}
+
+var DefaultHttpHeaderOption = HttpHeaderOption{
+	CorsAllowOrigins:     []string{"*"},
+	CorsAllowCredentials: true,
+	CacheControl:         "no-cache",
+	ExtraHeaders:         nil,
+}
+
+// SetTo 将配置的header设置到`h`中
+//
+// @param reqOrigin 请求中的Origin header，可以为空
+//
+// 注意，浏览器不接受`Access-Control-Allow-Origin: *`和`Access-Control-Allow-Credentials: true`同时出现，
+// 所以允许所有Origin并且开启了credentials时，返回请求中的Origin
+//
+func (o *HttpHeaderOption) SetTo(h http.Header, reqOrigin string) {
+	if allowOrigin := o.matchOrigin(reqOrigin); allowOrigin != "" {
+		h.Set("Access-Control-Allow-Origin", allowOrigin)
+		if allowOrigin != "*" {
+			h.Add("Vary", "Origin")
+		}
+		if o.CorsAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if o.CacheControl != "" {
+		h.Set("Cache-Control", o.CacheControl)
+	}
+	for k, v := range o.ExtraHeaders {
+		h.Set(k, v)
+	}
+}
+
+// PackHttpHeaderLines 将`h`打包成HTTP header行的格式，每行以`\r\n`结尾，key按字典序排列
+//
+func PackHttpHeaderLines(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		for _, v := range h[k] {
+			sb.WriteString(k)
+			sb.WriteString(": ")
+			sb.WriteString(v)
+			sb.WriteString("\r\n")
+		}
+	}
+	return sb.String()
+}
+
+func (o *HttpHeaderOption) matchOrigin(reqOrigin string) string {
+	for _, origin := range o.CorsAllowOrigins {
+		if origin == "*" {
+			if o.CorsAllowCredentials && reqOrigin != "" {
+				return reqOrigin
+			}
+			return "*"
+		}
+		if reqOrigin != "" && strings.EqualFold(origin, reqOrigin) {
+			return reqOrigin
+		}
+	}
+	return ""
+}
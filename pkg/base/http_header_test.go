@@ -0,0 +1,45 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestHttpHeaderOption(t *testing.T) {
+	h := make(http.Header)
+	DefaultHttpHeaderOption.SetTo(h, "")
+	assert.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", h.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "no-cache", h.Get("Cache-Control"))
+	assert.Equal(t, "Access-Control-Allow-Credentials: true\r\nAccess-Control-Allow-Origin: *\r\nCache-Control: no-cache\r\n", PackHttpHeaderLines(h))
+
+	h = make(http.Header)
+	DefaultHttpHeaderOption.SetTo(h, "https://a.com")
+	assert.Equal(t, "https://a.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", h.Get("Vary"))
+
+	option := HttpHeaderOption{
+		CorsAllowOrigins: []string{"https://a.com"},
+		ExtraHeaders:     map[string]string{"X-Server-Name": "lal"},
+	}
+	h = make(http.Header)
+	option.SetTo(h, "https://b.com")
+	assert.Equal(t, "", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", h.Get("Cache-Control"))
+	assert.Equal(t, "lal", h.Get("X-Server-Name"))
+
+	h = make(http.Header)
+	option.SetTo(h, "https://A.com")
+	assert.Equal(t, "https://A.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", h.Get("Access-Control-Allow-Credentials"))
+}
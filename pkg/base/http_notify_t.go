@@ -10,7 +10,7 @@ package base
 
 // 文档见： https://pengrl.com/p/20101/
 
-const HttpNotifyVersion = "v0.1.0"
+const HttpNotifyVersion = "v0.1.1"
 
 type SessionEventCommonInfo struct {
 	Protocol      string `json:"protocol"`
@@ -23,6 +23,9 @@ type SessionEventCommonInfo struct {
 	UrlParam      string `json:"url_param"`
 	HasInSession  bool   `json:"has_in_session"`
 	HasOutSession bool   `json:"has_out_session"`
+
+	Tags map[string]string `json:"tags,omitempty"` // 通过http api设置的session标签，只在stop事件中存在
+	Geo  *StatGeo          `json:"geo,omitempty"`  // 开启了geoip时，RemoteAddr对应的地理位置
 }
 
 type UpdateInfo struct {
@@ -30,6 +33,28 @@ type UpdateInfo struct {
 	Groups   []StatGroup `json:"groups"`
 }
 
+// ServerStopInfo lalserver正常退出时的事件，进程被强制杀死时没有该事件，需要通过 ServerHeartbeatInfo 超时判断
+//
+type ServerStopInfo struct {
+	LalInfo
+	StopTime string `json:"stop_time"`
+}
+
+// ServerHeartbeatInfo 按`http_notify.heartbeat_interval_sec`定时发送的节点心跳，包含负载的摘要
+//
+// 调度服务可以用`start_time`的变化判断节点是否重启过
+//
+type ServerHeartbeatInfo struct {
+	ServerId     string `json:"server_id"`
+	StartTime    string `json:"start_time"`
+	GroupNum     int    `json:"group_num"`
+	PubNum       int    `json:"pub_num"`       // rtmp、rtsp推流的数量
+	PullNum      int    `json:"pull_num"`      // 回源拉流的数量
+	ViewerNum    int    `json:"viewer_num"`    // 所有流的观看人数之和，见 StatGroup.ViewerCount
+	ReadBitrate  int    `json:"read_bitrate"`  // 所有推流、回源拉流的接收码率之和，单位kbit/s
+	WriteBitrate int    `json:"write_bitrate"` // 所有sub的发送码率之和，单位kbit/s
+}
+
 type PubStartInfo struct {
 	SessionEventCommonInfo
 }
@@ -54,3 +79,132 @@ type RtmpConnectInfo struct {
 	FlashVer   string `json:"flashVer"`
 	TcUrl      string `json:"tcUrl"`
 }
+
+// HlsAuthInfo 拉hls流时鉴权的信息，用于sidecar插件
+type HlsAuthInfo struct {
+	StreamName string `json:"stream_name"`
+	UrlParam   string `json:"url_param"`
+}
+
+// StreamKeyResolveInfo 推流密钥在静态配置中找不到时，请求`stream_key.resolve_url`解析的信息
+type StreamKeyResolveInfo struct {
+	ServerId   string `json:"server_id"`
+	Protocol   string `json:"protocol"`
+	SessionId  string `json:"session_id"`
+	RemoteAddr string `json:"remote_addr"`
+	AppName    string `json:"app_name"`
+	StreamKey  string `json:"stream_key"`
+	UrlParam   string `json:"url_param"`
+}
+
+// StreamKeyResolveResp `error_code`不为0表示拒绝推流
+type StreamKeyResolveResp struct {
+	HttpResponseBasic
+	Data struct {
+		StreamName string `json:"stream_name"` // 公开的流名称，播放时使用
+	} `json:"data"`
+}
+
+// RelayEventCommonInfo relay pull、relay push的事件信息
+//
+type RelayEventCommonInfo struct {
+	ServerId   string `json:"server_id"`
+	SessionId  string `json:"session_id"`
+	Url        string `json:"url"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	RetryCount int    `json:"retry_count"` // 本次连接之前连续失败的次数，0表示第一次尝试或者之前的连接成功过
+
+	RequestId string `json:"request_id,omitempty"` // 由http api触发的回源拉流，触发请求的request id，见 ApiCtrlStartPullReq.RequestId
+}
+
+// RelayPullStartInfo 回源拉流连接结束握手时的事件，不论成功还是失败
+//
+type RelayPullStartInfo struct {
+	RelayEventCommonInfo
+	Success bool   `json:"success"`
+	Reason  string `json:"reason"` // 失败的原因
+}
+
+// RelayPullStopInfo 回源拉流连接成功后，连接断开时的事件
+//
+type RelayPullStopInfo struct {
+	RelayEventCommonInfo
+	Reason string `json:"reason"` // 断开的原因
+}
+
+type RelayPushStartInfo struct {
+	RelayEventCommonInfo
+	Success bool   `json:"success"`
+	Reason  string `json:"reason"`
+}
+
+type RelayPushStopInfo struct {
+	RelayEventCommonInfo
+	Reason string `json:"reason"`
+}
+
+// BackupSwitchInfo 主备推流切换事件，见 logic.BackupPublisher
+//
+// 注意，实际的切换发生在新输入的下一个关键帧处
+//
+type BackupSwitchInfo struct {
+	ServerId         string `json:"server_id"`
+	AppName          string `json:"app_name"`
+	StreamName       string `json:"stream_name"`
+	BackupStreamName string `json:"backup_stream_name"`
+	From             string `json:"from"`   // primary或backup
+	To               string `json:"to"`     // primary或backup
+	Reason           string `json:"reason"` // 见 logic.BackupSwitchReasonStall 等
+}
+
+// ViewerChangeInfo 流的观看人数跨越了配置的阈值，见 logic.ViewerNotifier
+//
+type ViewerChangeInfo struct {
+	ServerId        string `json:"server_id"`
+	AppName         string `json:"app_name"`
+	StreamName      string `json:"stream_name"`
+	ViewerCount     int    `json:"viewer_count"`      // 当前观看人数
+	PrevViewerCount int    `json:"prev_viewer_count"` // 上一次通知时的观看人数
+	Threshold       int    `json:"threshold"`         // 跨越的阈值，没有配置阈值（任意变化都通知）时为0
+	Direction       string `json:"direction"`         // up或down
+}
+
+// BitstreamErrorInfo 输入流的视频数据不合法，被丢弃或者修复时的事件，同一路流按`bitstream_check.notify_interval_sec`的间隔合并通知
+//
+type BitstreamErrorInfo struct {
+	ServerId    string `json:"server_id"`
+	SessionId   string `json:"session_id"` // 输入流的session
+	AppName     string `json:"app_name"`
+	StreamName  string `json:"stream_name"`
+	Reason      string `json:"reason"`       // 最近一次错误的原因，见 logic.BitstreamErrorReasonShortPayload 等
+	DropCount   int    `json:"drop_count"`   // 距离上次通知，丢弃的视频帧数量
+	RepairCount int    `json:"repair_count"` // 距离上次通知，截断修复的视频帧数量
+	Quarantine  bool   `json:"quarantine"`   // 当前是否处于隔离期，即丢弃所有视频帧直到下一个合法的关键帧
+}
+
+// PubViolationInfo 输入流违反推流限制（见配置`publish_constraint`）时的事件，同一个推流session每种原因只通知一次
+//
+type PubViolationInfo struct {
+	ServerId   string `json:"server_id"`
+	SessionId  string `json:"session_id"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	Url        string `json:"url"`
+	Reason     string `json:"reason"`     // 见 logic.PubViolationReasonResolution 等
+	Detail     string `json:"detail"`     // 可读的描述，rtmp推流被关闭时也作为onStatus的description发送给推流端
+	Disconnect bool   `json:"disconnect"` // 是否关闭了推流
+}
+
+// SubRejectInfo 节点出口带宽不足（见配置`admission`）拒绝拉流时的事件，同一路流按1秒的间隔合并通知，
+// 调度服务可以据此将观众调度到其他节点
+//
+type SubRejectInfo struct {
+	SessionEventCommonInfo
+
+	Reason      string `json:"reason"`       // 见 logic.SubRejectReasonEgressBudget
+	EgressKbits int    `json:"egress_kbits"` // 拒绝时预估的出口码率，包含已接入但码率还没有统计到的拉流，单位kbit/s
+	StreamKbits int    `json:"stream_kbits"` // 该路流预估的单个拉流码率，单位kbit/s
+	BudgetKbits int    `json:"budget_kbits"` // 出口带宽上限，单位kbit/s
+	RejectCount int    `json:"reject_count"` // 距离该路流上次通知，拒绝的拉流数量，包含本次
+}
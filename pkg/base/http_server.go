@@ -12,6 +12,7 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"os"
 	"reflect"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
@@ -22,7 +23,8 @@ import (
 // - 考虑增加一个pattern全部未命中的mux回调
 
 const (
-	NetworkTcp = "tcp"
+	NetworkTcp  = "tcp"
+	NetworkUnix = "unix" // unix domain socket，此时`Addr`为socket文件路径
 )
 
 type LocalAddrCtx struct {
@@ -31,7 +33,16 @@ type LocalAddrCtx struct {
 	CertFile string
 	KeyFile  string
 
+	TlsConfig *tls.Config // 不为nil时使用该配置，忽略`CertFile`和`KeyFile`，见 NewTlsConfig
+
 	Network string
+
+	ProxyProtocolEnable bool // 是否解析PROXY protocol头，见 ProxyProtocolListener
+	ReusePort           bool // tcp监听时是否设置SO_REUSEPORT，见 ListenTcp
+
+	ConnGuard *ConnGuard // 防连接风暴，为nil时不开启。握手超时使用 http.Server 的ReadHeaderTimeout
+
+	ProxyProtocolTrustedNets []*net.IPNet // 只解析来自这些网段的PROXY protocol头，见 NewProxyProtocolListener
 }
 
 type HttpServerManager struct {
@@ -63,7 +74,13 @@ type Handler func(http.ResponseWriter, *http.Request)
 //                         注意，多次调用，允许使用相同的地址绑定不同的`pattern`
 //                CertFile
 //                KeyFile
-//                Network  如果为空默认为NetworkTcp="tcp"
+//                TlsConfig 不为nil时使用该配置，忽略CertFile和KeyFile
+//                Network  如果为空默认为NetworkTcp="tcp"，为NetworkUnix="unix"时，`Addr`为socket文件路径
+//                ProxyProtocolEnable 是否解析PROXY protocol头
+//                ProxyProtocolTrustedNets 只解析来自这些网段的PROXY protocol头
+//                ReusePort 是否设置SO_REUSEPORT
+//                ConnGuard 防连接风暴
+//                         注意，相同的监听地址，以第一次调用时的配置为准
 //
 // @param pattern 必须以`/`开始，并以`/`结束
 //                注意，如果是`/`，则在其他所有pattern都匹配失败后，做为兜底匹配成功
@@ -97,6 +114,9 @@ func (s *HttpServerManager) AddListen(addrCtx LocalAddrCtx, pattern string, hand
 			mux:             mux,
 			pattern2Handler: make(map[string]Handler),
 		}
+		if addrCtx.ConnGuard != nil {
+			ctx.httpServer.ReadHeaderTimeout = addrCtx.ConnGuard.HandshakeTimeout()
+		}
 		s.addr2ServerCtx[addrCtx.Addr] = ctx
 	}
 
@@ -147,14 +167,51 @@ func listen(ctx LocalAddrCtx) (net.Listener, error) {
 		ctx.Network = NetworkTcp
 	}
 
-	if !ctx.IsHttps {
-		return net.Listen(ctx.Network, ctx.Addr)
+	tlsConfig := ctx.TlsConfig
+	if ctx.IsHttps && tlsConfig == nil {
+		var err error
+		if tlsConfig, err = NewTlsConfig(TlsOption{Certs: []TlsCertOption{{CertFile: ctx.CertFile, KeyFile: ctx.KeyFile}}}); err != nil {
+			return nil, err
+		}
 	}
 
-	cert, err := tls.LoadX509KeyPair(ctx.CertFile, ctx.KeyFile)
+	var (
+		ln  net.Listener
+		err error
+	)
+	if ctx.Network == NetworkUnix {
+		ln, err = ListenUnix(ctx.Addr)
+	} else {
+		ln, err = ListenTcp(ctx.Addr, ctx.ReusePort)
+	}
 	if err != nil {
 		return nil, err
 	}
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
-	return tls.Listen(ctx.Network, ctx.Addr, tlsConfig)
+
+	if ctx.ConnGuard != nil {
+		ln = ctx.ConnGuard.WrapListener(ln, false)
+	}
+
+	// 注意，PROXY protocol头在tls握手之前，所以要在tls之前解析
+	if ctx.ProxyProtocolEnable {
+		ln = NewProxyProtocolListener(ln, ctx.ProxyProtocolTrustedNets)
+	}
+
+	if !ctx.IsHttps {
+		return ln, nil
+	}
+	return tls.NewListener(ln, tlsConfig), nil
+}
+
+// ListenUnix 在`path`上建立unix domain socket监听，listener关闭时socket文件会被删除
+//
+// 进程异常退出时socket文件会残留，导致再次监听失败，所以如果`path`已经存在并且是socket文件，先删除
+//
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(NetworkUnix, path)
 }
@@ -9,7 +9,14 @@
 package base
 
 import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestHttpServerManager(t *testing.T) {
@@ -42,3 +49,41 @@ func TestHttpServerManager(t *testing.T) {
 	//err = sm.RunLoop()
 	//assert.Equal(t, nil, err)
 }
+
+func TestHttpServerManager_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_base_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "httpflv.sock")
+
+	// 模拟进程异常退出后残留的socket文件
+	ln, err := net.Listen(NetworkUnix, path)
+	assert.Equal(t, nil, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+	_, err = os.Stat(path)
+	assert.Equal(t, nil, err)
+
+	sm := NewHttpServerManager()
+	err = sm.AddListen(LocalAddrCtx{Network: NetworkUnix, Addr: path}, "/live/", func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("ok"))
+	})
+	assert.Equal(t, nil, err)
+	go sm.RunLoop()
+
+	client := http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial(NetworkUnix, path)
+			},
+		},
+	}
+	resp, err := client.Get("http://unix/live/test110.flv")
+	assert.Equal(t, nil, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ok", string(body))
+
+	_ = sm.Dispose()
+}
@@ -33,9 +33,18 @@ type HttpSubSessionOption struct {
 	UrlCtx        UrlContext
 	IsWebSocket   bool
 	WebSocketKey  string
+
+	// WriteTimeoutMs 发送超时，由 DefaultTimerWheel 检查，见 TimeoutConn 。为0时不检查
+	//
+	// 注意，和 ConnModOption 中的WriteTimeoutMs不同，不需要每次发送都修改deadline，拉流session数量很多时开销更小
+	//
+	WriteTimeoutMs int
 }
 
 func NewHttpSubSession(option HttpSubSessionOption) *HttpSubSession {
+	if option.WriteTimeoutMs > 0 {
+		option.Conn = NewTimeoutConn(option.Conn, nil, 0, option.WriteTimeoutMs)
+	}
 	s := &HttpSubSession{
 		HttpSubSessionOption: option,
 		conn:                 connection.New(option.Conn, option.ConnModOption),
@@ -110,16 +119,24 @@ func (session *HttpSubSession) AppName() string {
 }
 
 func (session *HttpSubSession) StreamName() string {
-	var suffix string
+	return strings.TrimSuffix(session.UrlCtx.LastItemOfPath, session.fileSuffix())
+}
+
+// SetStreamName 修改流名称，用于业务方重写拉流的流名称
+//
+func (session *HttpSubSession) SetStreamName(streamName string) {
+	session.UrlCtx.LastItemOfPath = streamName + session.fileSuffix()
+}
+
+func (session *HttpSubSession) fileSuffix() string {
 	switch session.Protocol {
 	case ProtocolHttpflv:
-		suffix = ".flv"
+		return ".flv"
 	case ProtocolHttpts:
-		suffix = ".ts"
-	default:
-		Log.Warnf("[%s] acquire stream name but protocol unknown.", session.Uk)
+		return ".ts"
 	}
-	return strings.TrimSuffix(session.UrlCtx.LastItemOfPath, suffix)
+	Log.Warnf("[%s] acquire stream name but protocol unknown.", session.Uk)
+	return ""
 }
 
 func (session *HttpSubSession) RawQuery() string {
@@ -0,0 +1,74 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// 1900-01-01到1970-01-01的秒数
+const ntpEpochOffset = 2208988800
+
+// QueryNtpOffset 使用SNTP（RFC 4330）查询本机时钟相对于ntp服务器的偏差
+//
+// @param server: ntp服务器地址，比如`pool.ntp.org`，没有端口时使用123
+//
+// @return offset: 本机时间加上offset，即为校正后的时间
+//
+func QueryNtpOffset(server string, timeout time.Duration) (offset time.Duration, err error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI=0, VN=3, Mode=3(client)
+	t1 := time.Now()
+	if _, err = conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	t4 := time.Now()
+	if n < 48 || resp[0]&0x07 != 4 { // Mode=4(server)
+		return 0, ErrNtpResponse
+	}
+
+	t2 := parseNtpTimestamp(resp[32:])
+	t3 := parseNtpTimestamp(resp[40:])
+	return calcNtpOffset(t1, t2, t3, t4), nil
+}
+
+// ((t2 - t1) + (t3 - t4)) / 2
+func calcNtpOffset(t1, t2, t3, t4 time.Time) time.Duration {
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2
+}
+
+func parseNtpTimestamp(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b)) - ntpEpochOffset
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(sec, frac*1e9>>32)
+}
+
+func makeNtpTimestamp(t time.Time, b []byte) {
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
@@ -0,0 +1,48 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestQueryNtpOffset(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	// 模拟一个比本机快10秒的ntp服务器
+	go func() {
+		b := make([]byte, 48)
+		_, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		now := time.Now().Add(10 * time.Second)
+		resp := make([]byte, 48)
+		resp[0] = 0x1C
+		makeNtpTimestamp(now, resp[32:])
+		makeNtpTimestamp(now, resp[40:])
+		_, _ = conn.WriteTo(resp, addr)
+	}()
+
+	offset, err := QueryNtpOffset(conn.LocalAddr().String(), time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, offset > 9*time.Second && offset < 11*time.Second)
+
+	now := time.Unix(1650000000, 500000000)
+	b := make([]byte, 8)
+	makeNtpTimestamp(now, b)
+	diff := parseNtpTimestamp(b).Sub(now)
+	assert.Equal(t, true, diff > -time.Microsecond && diff < time.Microsecond)
+}
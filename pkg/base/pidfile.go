@@ -0,0 +1,59 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WritePidFile 将当前进程的pid写入`filename`
+//
+// 如果文件中已经有pid，并且对应的进程依然存在，返回错误，防止重复启动。进程异常退出时残留的文件会被覆盖
+//
+func WritePidFile(filename string) error {
+	if pid, err := ReadPidFile(filename); err == nil && pid != os.Getpid() && isProcessExist(pid) {
+		return fmt.Errorf("%w. process already running. file=%s, pid=%d", ErrPidFile, filename, pid)
+	}
+	if dir := filepath.Dir(filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filename, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+func ReadPidFile(filename string) (int, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%w. invalid content. file=%s", ErrPidFile, filename)
+	}
+	return pid, nil
+}
+
+// RemovePidFile 进程退出时删除pid文件，文件中不是当前进程的pid时（比如已经被新启动的进程覆盖）不删除
+//
+func RemovePidFile(filename string) error {
+	pid, err := ReadPidFile(filename)
+	if err != nil {
+		return err
+	}
+	if pid != os.Getpid() {
+		return nil
+	}
+	return os.Remove(filename)
+}
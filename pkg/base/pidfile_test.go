@@ -0,0 +1,48 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_pidfile")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "run", "lalserver.pid")
+
+	assert.Equal(t, nil, WritePidFile(filename))
+	pid, err := ReadPidFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.Getpid(), pid)
+	// 同一个进程重复写入
+	assert.Equal(t, nil, WritePidFile(filename))
+
+	// 其他正在运行的进程
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("1\n"), 0644))
+	err = WritePidFile(filename)
+	assert.Equal(t, true, errors.Is(err, ErrPidFile))
+	assert.Equal(t, nil, RemovePidFile(filename))
+	_, err = os.Stat(filename)
+	assert.Equal(t, nil, err)
+
+	// 残留的无效内容被覆盖
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("abc"), 0644))
+	assert.Equal(t, nil, WritePidFile(filename))
+	assert.Equal(t, nil, RemovePidFile(filename))
+	_, err = os.Stat(filename)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
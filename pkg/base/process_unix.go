@@ -0,0 +1,19 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package base
+
+import "syscall"
+
+func isProcessExist(pid int) bool {
+	// 信号0只做检查，EPERM表示进程存在但属于其他用户
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,255 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 出口代理，用于client类型的session（relay pull/push等）通过代理服务器连接对端
+//
+// 支持的代理url格式：
+//   socks5://[user:password@]host:port
+//   http://[user:password@]host:port  使用HTTP CONNECT建立隧道
+//
+// proxyUrl为空时，直接连接对端
+
+const (
+	ProxySchemeSocks5 = "socks5"
+	ProxySchemeHttp   = "http"
+)
+
+var (
+	// DialTcpTimeoutMs DialTcp 的超时时间，包括建立tcp连接，以及使用代理时和代理服务器的握手，为0则不超时
+	DialTcpTimeoutMs = 10000
+)
+
+const (
+	socks5Version           = 0x05
+	socks5AuthNone          = 0x00
+	socks5AuthPassword      = 0x02
+	socks5AuthNoAcceptable  = 0xFF
+	socks5AuthPasswordVer   = 0x01
+	socks5CmdConnect        = 0x01
+	socks5AddrTypeIpv4      = 0x01
+	socks5AddrTypeDomain    = 0x03
+	socks5AddrTypeIpv6      = 0x04
+	socks5ReplySucceeded    = 0x00
+	socks5MaxDomainNameSize = 255
+)
+
+// DialTcp 建立tcp连接，如果`proxyUrl`不为空，则通过代理服务器建立连接
+//
+// @param addr     对端地址，格式为host:port
+// @param proxyUrl 代理地址，格式见文件头部说明，为空时不使用代理
+//
+// 注意，使用`-tags chaos`编译时，会按 ChaosRule 对建立的连接注入故障
+//
+func DialTcp(addr string, proxyUrl string) (net.Conn, error) {
+	conn, err := dialTcp(addr, proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	return wrapChaosConn(conn, addr), nil
+}
+
+func dialTcp(addr string, proxyUrl string) (net.Conn, error) {
+	var deadline time.Time
+	if DialTcpTimeoutMs > 0 {
+		deadline = time.Now().Add(time.Duration(DialTcpTimeoutMs) * time.Millisecond)
+	}
+	if proxyUrl == "" {
+		return dialTcpWithDeadline(addr, deadline)
+	}
+
+	u, err := ParseProxyUrl(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialTcpWithDeadline(u.Host, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// 代理服务器可能一直不响应，握手期间设置超时，握手完成后清除
+	if !deadline.IsZero() {
+		_ = conn.SetDeadline(deadline)
+	}
+	if u.Scheme == ProxySchemeSocks5 {
+		err = socks5Handshake(conn, u.User, addr)
+	} else {
+		err = httpConnectHandshake(conn, u, addr)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+func dialTcpWithDeadline(addr string, deadline time.Time) (net.Conn, error) {
+	if deadline.IsZero() {
+		return net.Dial("tcp", addr)
+	}
+	return net.DialTimeout("tcp", addr, time.Until(deadline))
+}
+
+// ParseProxyUrl 解析并检查代理地址，格式见文件头部说明
+//
+func ParseProxyUrl(proxyUrl string) (*url.URL, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w. url=%s, err=%v", ErrInvalidProxyUrl, proxyUrl, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w. url=%s", ErrInvalidProxyUrl, proxyUrl)
+	}
+	if u.Scheme != ProxySchemeSocks5 && u.Scheme != ProxySchemeHttp {
+		return nil, fmt.Errorf("%w. url=%s", ErrInvalidProxyUrl, proxyUrl)
+	}
+	return u, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func socks5Handshake(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	// 协商认证方式
+	method := byte(socks5AuthNone)
+	if user != nil {
+		method = socks5AuthPassword
+	}
+	if _, err = conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != socks5Version || resp[1] == socks5AuthNoAcceptable || resp[1] != method {
+		return fmt.Errorf("%w. socks5 auth method not accepted. resp=%v", ErrProxyHandshake, resp)
+	}
+
+	// 用户名密码认证，见RFC1929
+	if method == socks5AuthPassword {
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("%w. socks5 username or password too long", ErrProxyHandshake)
+		}
+		b := []byte{socks5AuthPasswordVer, byte(len(username))}
+		b = append(b, username...)
+		b = append(b, byte(len(password)))
+		b = append(b, password...)
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, resp); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return fmt.Errorf("%w. socks5 auth failed. status=%d", ErrProxyHandshake, resp[1])
+		}
+	}
+
+	// 发送CONNECT请求
+	b := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrTypeIpv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrTypeIpv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > socks5MaxDomainNameSize {
+			return fmt.Errorf("%w. socks5 host too long. host=%s", ErrProxyHandshake, host)
+		}
+		b = append(b, socks5AddrTypeDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	if _, err = conn.Write(b); err != nil {
+		return err
+	}
+
+	// 读取CONNECT结果 VER REP RSV ATYP BND.ADDR BND.PORT
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version || header[1] != socks5ReplySucceeded {
+		return fmt.Errorf("%w. socks5 connect failed. reply=%d", ErrProxyHandshake, header[1])
+	}
+	var bndAddrLen int
+	switch header[3] {
+	case socks5AddrTypeIpv4:
+		bndAddrLen = net.IPv4len
+	case socks5AddrTypeIpv6:
+		bndAddrLen = net.IPv6len
+	case socks5AddrTypeDomain:
+		l := make([]byte, 1)
+		if _, err = io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		bndAddrLen = int(l[0])
+	default:
+		return fmt.Errorf("%w. socks5 invalid address type. atyp=%d", ErrProxyHandshake, header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, bndAddrLen+2))
+	return err
+}
+
+func httpConnectHandshake(conn net.Conn, u *url.URL, addr string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req += fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", auth)
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	// 注意，代理服务器在返回200之前不会发送隧道内的数据，所以这里使用bufio读取response不会多读
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w. http connect failed. status=%s", ErrProxyHandshake, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return fmt.Errorf("%w. http connect unexpected data after response", ErrProxyHandshake)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// PROXY protocol，用于lalserver部署在HAProxy、NLB等四层负载均衡后面时，获取客户端的真实地址
+//
+// 文档见： https://www.haproxy.org/download/2.4/doc/proxy-protocol.txt
+//
+// 支持v1（文本格式）和v2（二进制格式）
+// 注意，开启后，如果连接上没有PROXY protocol头，则依然使用tcp连接的对端地址
+// 只有tcp连接的对端地址在信任的网段中（比如负载均衡的地址），才解析PROXY protocol头，避免客户端伪造地址
+//
+
+var (
+	// ProxyProtocolHeaderTimeoutMs 读取PROXY protocol头的超时时间
+	ProxyProtocolHeaderTimeoutMs = 5000
+)
+
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyProtocolV1MaxLen    = 107
+	proxyProtocolV2HeaderLen = 16
+
+	proxyProtocolV2CmdLocal = 0x0
+	proxyProtocolV2CmdProxy = 0x1
+
+	proxyProtocolV2FamTcp4 = 0x11
+	proxyProtocolV2FamTcp6 = 0x21
+)
+
+// ProxyProtocolListener 对 net.Listener 做封装，Accept返回的连接中，RemoteAddr为PROXY protocol中携带的客户端地址
+type ProxyProtocolListener struct {
+	net.Listener
+
+	trustedNets []*net.IPNet
+}
+
+// NewProxyProtocolListener
+//
+// @param trustedNets: 只解析对端地址在这些网段中的连接的PROXY protocol头，为空时不信任任何对端，见 ParseCidrs
+//
+// 注意，对端地址不在信任的网段中的连接，如果携带了PROXY protocol头，则Read返回 ErrProxyProtocolUntrusted
+//
+func NewProxyProtocolListener(ln net.Listener, trustedNets []*net.IPNet) *ProxyProtocolListener {
+	return &ProxyProtocolListener{
+		Listener:    ln,
+		trustedNets: trustedNets,
+	}
+}
+
+// Accept
+//
+// 注意，为了不阻塞Accept，PROXY protocol头在第一次调用连接的Read或RemoteAddr时才读取解析
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyProtocolConn{
+		Conn:    conn,
+		br:      bufio.NewReader(conn),
+		trusted: isAddrInNets(conn.RemoteAddr(), l.trustedNets),
+	}, nil
+}
+
+// ParseCidrs 解析网段列表，元素可以是`10.0.0.0/8`这种CIDR格式，也可以是单个IP
+//
+func ParseCidrs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range cidrs {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip. ip=%s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isAddrInNets(addr net.Addr, nets []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type ProxyProtocolConn struct {
+	net.Conn
+
+	br         *bufio.Reader
+	trusted    bool
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *ProxyProtocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *ProxyProtocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *ProxyProtocolConn) readHeader() {
+	if ProxyProtocolHeaderTimeoutMs > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(time.Duration(ProxyProtocolHeaderTimeoutMs) * time.Millisecond))
+		defer func() {
+			_ = c.Conn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	if !c.trusted {
+		// 不是来自信任的网段，不解析，携带了PROXY protocol头的连接直接报错
+		if v, _ := peekProxyProtocolVersion(c.br); v != 0 {
+			c.err = fmt.Errorf("%w. remote=%s", ErrProxyProtocolUntrusted, c.Conn.RemoteAddr().String())
+			Log.Warnf("read proxy protocol header failed. remote=%s, err=%+v", c.Conn.RemoteAddr().String(), c.err)
+		}
+		return
+	}
+
+	c.remoteAddr, c.err = ReadProxyProtocolHeader(c.br)
+	if c.err != nil {
+		Log.Warnf("read proxy protocol header failed. remote=%s, err=%+v", c.Conn.RemoteAddr().String(), c.err)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// ReadProxyProtocolHeader 从`br`中读取并解析PROXY protocol头
+//
+// @return addr 客户端地址。如果没有PROXY protocol头，或者头中不包含地址信息（比如v1的UNKNOWN，v2的LOCAL），则返回nil
+func ReadProxyProtocolHeader(br *bufio.Reader) (addr net.Addr, err error) {
+	v, err := peekProxyProtocolVersion(br)
+	if err != nil {
+		return nil, err
+	}
+	switch v {
+	case 1:
+		return readProxyProtocolV1(br)
+	case 2:
+		return readProxyProtocolV2(br)
+	}
+	return nil, nil
+}
+
+// peekProxyProtocolVersion 不消费数据，判断`br`中是否以PROXY protocol头开始
+//
+// @return v 1或2表示PROXY protocol头的版本，0表示没有PROXY protocol头
+func peekProxyProtocolVersion(br *bufio.Reader) (v int, err error) {
+	b, err := br.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	switch b[0] {
+	case 'P':
+		if b, err = br.Peek(6); err == nil && string(b) == "PROXY " {
+			return 1, nil
+		}
+	case proxyProtocolV2Signature[0]:
+		if b, err = br.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(b, proxyProtocolV2Signature) {
+			return 2, nil
+		}
+	}
+	return 0, nil
+}
+
+// PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n
+func readProxyProtocolV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= proxyProtocolV1MaxLen {
+			return nil, fmt.Errorf("%w. v1 header too long", ErrProxyProtocol)
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w. v1 header invalid. header=%s", ErrProxyProtocol, line)
+	}
+
+	items := strings.Split(string(line[:len(line)-2]), " ")
+	if len(items) >= 2 && items[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(items) != 6 || (items[1] != "TCP4" && items[1] != "TCP6") {
+		return nil, fmt.Errorf("%w. v1 header invalid. header=%s", ErrProxyProtocol, line)
+	}
+	ip := net.ParseIP(items[2])
+	if ip == nil {
+		return nil, fmt.Errorf("%w. v1 src address invalid. header=%s", ErrProxyProtocol, line)
+	}
+	port, err := strconv.Atoi(items[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("%w. v1 src port invalid. header=%s", ErrProxyProtocol, line)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyProtocolV2(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyProtocolV2HeaderLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	verCmd := header[12]
+	fam := header[13]
+	length := int(bele.BeUint16(header[14:]))
+
+	if verCmd>>4 != 0x2 {
+		return nil, fmt.Errorf("%w. v2 version invalid. ver_cmd=%d", ErrProxyProtocol, verCmd)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xF {
+	case proxyProtocolV2CmdLocal:
+		return nil, nil
+	case proxyProtocolV2CmdProxy:
+		// noop
+	default:
+		return nil, fmt.Errorf("%w. v2 command invalid. ver_cmd=%d", ErrProxyProtocol, verCmd)
+	}
+
+	switch fam {
+	case proxyProtocolV2FamTcp4:
+		if length < 12 {
+			return nil, fmt.Errorf("%w. v2 tcp4 address too short. len=%d", ErrProxyProtocol, length)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(bele.BeUint16(payload[8:]))}, nil
+	case proxyProtocolV2FamTcp6:
+		if length < 36 {
+			return nil, fmt.Errorf("%w. v2 tcp6 address too short. len=%d", ErrProxyProtocol, length)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(bele.BeUint16(payload[32:]))}, nil
+	}
+
+	// 其他类型（比如UNSPEC，unix socket等）忽略地址信息
+	return nil, nil
+}
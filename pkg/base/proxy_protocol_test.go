@@ -0,0 +1,149 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	// v1
+	br := bufio.NewReader(bytes.NewReader([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 1935\r\nhello")))
+	addr, err := ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "192.168.0.1:56324", addr.String())
+	b, _ := ioutil.ReadAll(br)
+	assert.Equal(t, "hello", string(b))
+
+	br = bufio.NewReader(bytes.NewReader([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 1935\r\n")))
+	addr, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "[2001:db8::1]:56324", addr.String())
+
+	br = bufio.NewReader(bytes.NewReader([]byte("PROXY UNKNOWN\r\n")))
+	addr, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, addr)
+
+	br = bufio.NewReader(bytes.NewReader([]byte("PROXY TCP4 x 192.168.0.11 56324 1935\r\n")))
+	_, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, true, errors.Is(err, ErrProxyProtocol))
+
+	// v2
+	v2 := append([]byte{}, proxyProtocolV2Signature...)
+	v2 = append(v2, 0x21, proxyProtocolV2FamTcp4, 0, 12)
+	v2 = append(v2, 10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x07, 0x8F)
+	v2 = append(v2, []byte("hello")...)
+	br = bufio.NewReader(bytes.NewReader(v2))
+	addr, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "10.0.0.1:8080", addr.String())
+	b, _ = ioutil.ReadAll(br)
+	assert.Equal(t, "hello", string(b))
+
+	local := append([]byte{}, proxyProtocolV2Signature...)
+	local = append(local, 0x20, 0, 0, 0)
+	br = bufio.NewReader(bytes.NewReader(local))
+	addr, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, addr)
+
+	// 没有PROXY protocol头
+	br = bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")))
+	addr, err = ReadProxyProtocolHeader(br)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, addr)
+	b, _ = ioutil.ReadAll(br)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(b))
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	nets, err := ParseCidrs([]string{"127.0.0.1"})
+	assert.Equal(t, nil, err)
+	ln := NewProxyProtocolListener(raw, nets)
+	defer ln.Close()
+
+	check := func(header string, expectedRemote func(c net.Conn) string) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Equal(t, nil, err)
+		defer c.Close()
+		_, err = c.Write([]byte(header + "hello"))
+		assert.Equal(t, nil, err)
+
+		sc, err := ln.Accept()
+		assert.Equal(t, nil, err)
+		defer sc.Close()
+		assert.Equal(t, expectedRemote(c), sc.RemoteAddr().String())
+		b := make([]byte, 5)
+		_, err = io.ReadFull(sc, b)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	check("PROXY TCP4 1.2.3.4 5.6.7.8 1111 1935\r\n", func(c net.Conn) string {
+		return "1.2.3.4:1111"
+	})
+	check("", func(c net.Conn) string {
+		return c.LocalAddr().String()
+	})
+}
+
+func TestProxyProtocolListener_Untrusted(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	nets, err := ParseCidrs([]string{"10.0.0.0/8", "::1"})
+	assert.Equal(t, nil, err)
+	ln := NewProxyProtocolListener(raw, nets)
+	defer ln.Close()
+
+	dial := func(data string) net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Equal(t, nil, err)
+		_, err = c.Write([]byte(data))
+		assert.Equal(t, nil, err)
+		return c
+	}
+
+	// 对端不在信任的网段中，携带了PROXY protocol头的连接报错
+	c := dial("PROXY TCP4 1.2.3.4 5.6.7.8 1111 1935\r\nhello")
+	defer c.Close()
+	sc, err := ln.Accept()
+	assert.Equal(t, nil, err)
+	defer sc.Close()
+	assert.Equal(t, c.LocalAddr().String(), sc.RemoteAddr().String())
+	_, err = sc.Read(make([]byte, 5))
+	assert.Equal(t, true, errors.Is(err, ErrProxyProtocolUntrusted))
+
+	// 没有PROXY protocol头的连接正常使用
+	c2 := dial("hello")
+	defer c2.Close()
+	sc2, err := ln.Accept()
+	assert.Equal(t, nil, err)
+	defer sc2.Close()
+	assert.Equal(t, c2.LocalAddr().String(), sc2.RemoteAddr().String())
+	b := make([]byte, 5)
+	_, err = io.ReadFull(sc2, b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = ParseCidrs([]string{"10.0.0.0/33"})
+	assert.IsNotNil(t, err)
+	_, err = ParseCidrs([]string{"abc"})
+	assert.IsNotNil(t, err)
+}
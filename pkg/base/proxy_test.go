@@ -0,0 +1,225 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// 启动一个echo服务，作为被代理的对端
+func startEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+	}()
+	_, _ = io.Copy(b, a)
+}
+
+// 一个极简的socks5服务，只支持CONNECT命令以及ipv4地址
+func startSocks5Server(t *testing.T, username, password string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 3)
+				if _, err := io.ReadFull(c, b); err != nil {
+					return
+				}
+				if username != "" {
+					_, _ = c.Write([]byte{socks5Version, socks5AuthPassword})
+					h := make([]byte, 2)
+					_, _ = io.ReadFull(c, h)
+					u := make([]byte, h[1])
+					_, _ = io.ReadFull(c, u)
+					_, _ = io.ReadFull(c, h[:1])
+					p := make([]byte, h[0])
+					_, _ = io.ReadFull(c, p)
+					if string(u) != username || string(p) != password {
+						_, _ = c.Write([]byte{socks5AuthPasswordVer, 1})
+						return
+					}
+					_, _ = c.Write([]byte{socks5AuthPasswordVer, 0})
+				} else {
+					_, _ = c.Write([]byte{socks5Version, socks5AuthNone})
+				}
+				req := make([]byte, 10)
+				if _, err := io.ReadFull(c, req); err != nil {
+					return
+				}
+				addr := net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(req[8])<<8|int(req[9])))
+				remote, err := net.Dial("tcp", addr)
+				if err != nil {
+					_, _ = c.Write([]byte{socks5Version, 0x05, 0, socks5AddrTypeIpv4, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer remote.Close()
+				_, _ = c.Write([]byte{socks5Version, socks5ReplySucceeded, 0, socks5AddrTypeIpv4, 0, 0, 0, 0, 0, 0})
+				pipe(c, remote)
+			}()
+		}
+	}()
+	return ln
+}
+
+func startHttpConnectServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = c.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer remote.Close()
+				_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				pipe(c, remote)
+			}()
+		}
+	}()
+	return ln
+}
+
+func checkEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("hello"))
+	assert.Equal(t, nil, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestDialTcp(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	// 不使用代理
+	conn, err := DialTcp(echo.Addr().String(), "")
+	assert.Equal(t, nil, err)
+	checkEcho(t, conn)
+	_ = conn.Close()
+
+	// socks5
+	s5 := startSocks5Server(t, "", "")
+	defer s5.Close()
+	conn, err = DialTcp(echo.Addr().String(), "socks5://"+s5.Addr().String())
+	assert.Equal(t, nil, err)
+	checkEcho(t, conn)
+	_ = conn.Close()
+
+	// socks5 用户名密码认证
+	s5a := startSocks5Server(t, "user", "pass")
+	defer s5a.Close()
+	conn, err = DialTcp(echo.Addr().String(), "socks5://user:pass@"+s5a.Addr().String())
+	assert.Equal(t, nil, err)
+	checkEcho(t, conn)
+	_ = conn.Close()
+
+	_, err = DialTcp(echo.Addr().String(), "socks5://user:wrong@"+s5a.Addr().String())
+	assert.Equal(t, true, errors.Is(err, ErrProxyHandshake))
+
+	// http connect
+	hc := startHttpConnectServer(t)
+	defer hc.Close()
+	conn, err = DialTcp(echo.Addr().String(), "http://"+hc.Addr().String())
+	assert.Equal(t, nil, err)
+	checkEcho(t, conn)
+	_ = conn.Close()
+
+	// 非法代理地址
+	_, err = DialTcp(echo.Addr().String(), "ftp://127.0.0.1:21")
+	assert.Equal(t, true, errors.Is(err, ErrInvalidProxyUrl))
+}
+
+func TestDialTcp_Timeout(t *testing.T) {
+	origin := DialTcpTimeoutMs
+	DialTcpTimeoutMs = 200
+	defer func() {
+		DialTcpTimeoutMs = origin
+	}()
+
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	// 代理服务器接受连接但一直不响应
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(ioutil.Discard, c)
+			}()
+		}
+	}()
+
+	for _, scheme := range []string{"socks5", "http"} {
+		b := time.Now()
+		_, err = DialTcp(echo.Addr().String(), scheme+"://"+silent.Addr().String())
+		assert.Equal(t, true, err != nil)
+		var ne net.Error
+		assert.Equal(t, true, errors.As(err, &ne) && ne.Timeout())
+		assert.Equal(t, true, time.Since(b) < 2*time.Second)
+	}
+
+	// 握手完成后清除超时，连接空闲超过超时时间后依然可用
+	s5 := startSocks5Server(t, "", "")
+	defer s5.Close()
+	conn, err := DialTcp(echo.Addr().String(), "socks5://"+s5.Addr().String())
+	assert.Equal(t, nil, err)
+	time.Sleep(300 * time.Millisecond)
+	checkEcho(t, conn)
+	_ = conn.Close()
+}
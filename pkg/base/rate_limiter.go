@@ -0,0 +1,136 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 超过该时间没有请求的ip，删除其令牌桶
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// IpRateLimiter 按客户端ip限制http请求频率的令牌桶
+//
+// 每个ip一个令牌桶，桶容量为`burst`，每秒补充`ratePerSec`个令牌，每个请求消耗一个令牌，没有令牌时拒绝请求
+//
+type IpRateLimiter struct {
+	ratePerSec float64
+	burst      float64
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	allowed   uint64
+	rejected  uint64
+}
+
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool // 是否处于被限流的状态，用于只在开始被限流时打印一次日志
+}
+
+func NewIpRateLimiter(ratePerSec float64, burst int) *IpRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &IpRateLimiter{
+		ratePerSec: ratePerSec,
+		burst:      float64(burst),
+		buckets:    make(map[string]*tokenBucket),
+		lastSweep:  time.Now(),
+	}
+}
+
+// Allow 判断来自`ip`的请求是否放行
+//
+func (l *IpRateLimiter) Allow(ip string) bool {
+	return l.allowAt(ip, time.Now())
+}
+
+// Wrap 包装http handler，被限流的请求返回429
+//
+// 每个ip只在开始被限流时打印一次日志，避免被限流的客户端刷屏，被拒绝的请求数见 GetStat
+//
+func (l *IpRateLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if ok, firstReject := l.takeAt(ip, time.Now()); !ok {
+			if firstReject {
+				Log.Warnf("http request rate limited. remote addr=%s, uri=%s", req.RemoteAddr, req.RequestURI)
+			}
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (l *IpRateLimiter) GetStat() StatRateLimit {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return StatRateLimit{
+		Allowed:  l.allowed,
+		Rejected: l.rejected,
+		IpNum:    len(l.buckets),
+	}
+}
+
+func (l *IpRateLimiter) allowAt(ip string, now time.Time) bool {
+	ok, _ := l.takeAt(ip, now)
+	return ok
+}
+
+// takeAt 消耗`ip`的一个令牌
+//
+// @return firstReject: 被拒绝，并且是该ip从放行变为被限流后的第一次拒绝
+//
+func (l *IpRateLimiter) takeAt(ip string, now time.Time) (ok bool, firstReject bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdleTimeout {
+		l.lastSweep = now
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateLimitIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.ratePerSec
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		l.rejected++
+		firstReject = !b.limited
+		b.limited = true
+		return false, firstReject
+	}
+	b.tokens--
+	b.limited = false
+	l.allowed++
+	return true, false
+}
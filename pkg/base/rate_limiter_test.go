@@ -0,0 +1,55 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestIpRateLimiter(t *testing.T) {
+	l := NewIpRateLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, true, l.allowAt("10.1.1.1", now))
+	}
+	assert.Equal(t, false, l.allowAt("10.1.1.1", now))
+	// 不同ip互不影响
+	assert.Equal(t, true, l.allowAt("10.1.1.2", now))
+	// 0.5秒补充1个令牌
+	assert.Equal(t, true, l.allowAt("10.1.1.1", now.Add(500*time.Millisecond)))
+	assert.Equal(t, false, l.allowAt("10.1.1.1", now.Add(500*time.Millisecond)))
+
+	stat := l.GetStat()
+	assert.Equal(t, uint64(5), stat.Allowed)
+	assert.Equal(t, uint64(2), stat.Rejected)
+	assert.Equal(t, 2, stat.IpNum)
+
+	// 只有开始被限流时的第一次拒绝需要打印日志，放行后重新计算
+	_, firstReject := l.takeAt("10.1.1.2", now)
+	assert.Equal(t, false, firstReject)
+	_, _ = l.takeAt("10.1.1.2", now)
+	ok, firstReject := l.takeAt("10.1.1.2", now)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, true, firstReject)
+	ok, firstReject = l.takeAt("10.1.1.2", now)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, false, firstReject)
+	ok, _ = l.takeAt("10.1.1.2", now.Add(500*time.Millisecond))
+	assert.Equal(t, true, ok)
+	ok, firstReject = l.takeAt("10.1.1.2", now.Add(500*time.Millisecond))
+	assert.Equal(t, false, ok)
+	assert.Equal(t, true, firstReject)
+
+	// 长时间空闲的ip被清理
+	assert.Equal(t, true, l.allowAt("10.1.1.3", now.Add(rateLimitIdleTimeout+time.Second)))
+	assert.Equal(t, 1, l.GetStat().IpNum)
+}
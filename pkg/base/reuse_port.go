@@ -0,0 +1,41 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// ListenTcp 建立tcp监听
+//
+// @param reusePort 是否设置SO_REUSEPORT，多个进程（或者同一个进程内的多个监听）可以监听同一个地址，由内核将新连接分配给其中一个。
+//                  注意，只支持linux、darwin和bsd系列。不支持的平台返回 ErrReusePortNotSupported
+//
+func ListenTcp(addr string, reusePort bool) (net.Listener, error) {
+	if !reusePort {
+		return net.Listen("tcp", addr)
+	}
+	if soReusePort == 0 {
+		return nil, ErrReusePortNotSupported
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				serr = setReusePort(fd)
+			}); err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
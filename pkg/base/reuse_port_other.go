@@ -0,0 +1,17 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package base
+
+var soReusePort = 0
+
+func setReusePort(fd uintptr) error {
+	return ErrReusePortNotSupported
+}
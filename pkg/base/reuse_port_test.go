@@ -0,0 +1,32 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build linux darwin
+
+package base
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestListenTcp(t *testing.T) {
+	ln1, err := ListenTcp("127.0.0.1:0", true)
+	assert.Equal(t, nil, err)
+	defer ln1.Close()
+	addr := ln1.Addr().String()
+
+	ln2, err := ListenTcp(addr, true)
+	assert.Equal(t, nil, err)
+	defer ln2.Close()
+
+	// 没有设置SO_REUSEPORT时监听失败
+	_, err = ListenTcp(addr, false)
+	assert.IsNotNil(t, err)
+}
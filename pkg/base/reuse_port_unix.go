@@ -0,0 +1,28 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package base
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// linux下标准库中没有定义SO_REUSEPORT
+var soReusePort = func() int {
+	if runtime.GOOS == "linux" {
+		return 0xf
+	}
+	return 0x200
+}()
+
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
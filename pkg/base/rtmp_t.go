@@ -21,6 +21,7 @@ const (
 	RtmpTypeIdUserControl        uint8 = 4
 	RtmpTypeIdWinAckSize         uint8 = 5
 	RtmpTypeIdBandwidth          uint8 = 6
+	RtmpTypeIdDataMessageAmf3    uint8 = 15
 	RtmpTypeIdCommandMessageAmf3 uint8 = 17
 	RtmpTypeIdCommandMessageAmf0 uint8 = 20
 	RtmpTypeIdAggregateMessage   uint8 = 22
@@ -75,6 +76,7 @@ const (
 	//   AACAUDIODATA
 	//     AACPacketType UI8
 	//     Data          UI8[n]
+	RtmpSoundFormatMp3         uint8 = 2
 	RtmpSoundFormatAac         uint8 = 10 // 注意，视频的CodecId是后4位，音频是前4位
 	RtmpAacPacketTypeSeqHeader       = 0
 	RtmpAacPacketTypeRaw             = 1
@@ -121,6 +123,39 @@ func (msg RtmpMsg) IsAacSeqHeader() bool {
 	return msg.Header.MsgTypeId == RtmpTypeIdAudio && (msg.Payload[0]>>4) == RtmpSoundFormatAac && msg.Payload[1] == RtmpAacPacketTypeSeqHeader
 }
 
+func (msg RtmpMsg) IsMp3() bool {
+	return msg.Header.MsgTypeId == RtmpTypeIdAudio && len(msg.Payload) > 0 && (msg.Payload[0]>>4) == RtmpSoundFormatMp3
+}
+
+// IsMetadata 是否为onMetaData（包括带@setDataFrame前缀的），onTextData、onCuePoint等其他data message返回false
+//
+// 注意，解析不出名称的data message，和之前的逻辑保持一致，依然当成metadata
+//
+func (msg RtmpMsg) IsMetadata() bool {
+	if msg.Header.MsgTypeId != RtmpTypeIdMetadata {
+		return false
+	}
+	switch msg.DataMessageName() {
+	case "", "onMetaData", "@setDataFrame":
+		return true
+	}
+	return false
+}
+
+// DataMessageName data message中第一个amf0 string的值，比如onMetaData、onTextData、onCuePoint，解析失败时返回空字符串
+func (msg RtmpMsg) DataMessageName() string {
+	p := msg.Payload
+	// amf0 string: marker(0x02) + 2字节长度 + 内容
+	if msg.Header.MsgTypeId != RtmpTypeIdMetadata || len(p) < 3 || p[0] != 0x02 {
+		return ""
+	}
+	l := int(p[1])<<8 | int(p[2])
+	if len(p) < 3+l {
+		return ""
+	}
+	return string(p[3 : 3+l])
+}
+
 func (msg RtmpMsg) Clone() (ret RtmpMsg) {
 	ret.Header = msg.Header
 	ret.Payload = make([]byte, len(msg.Payload))
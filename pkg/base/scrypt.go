@@ -0,0 +1,139 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// scrypt.go
+//
+// scrypt口令派生密钥（rfc7914），用于从口令生成secrets文件、落盘加密的密钥，见 newSecretsAead
+//
+// 为了不引入golang.org/x/crypto依赖，这里按rfc7914实现
+//
+
+// scryptKey
+//
+// @param n: CPU、内存开销参数，必须是大于1的2的幂
+//
+func scryptKey(password, salt []byte, n, r, p, keyLen int) ([]byte, error) {
+	if n <= 1 || n&(n-1) != 0 {
+		return nil, fmt.Errorf("%w. scrypt n must be > 1 and a power of 2. n=%d", ErrSecrets, n)
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || r > (1<<31-1)/128/p || r > (1<<31-1)/256 || n > (1<<31-1)/128/r {
+		return nil, fmt.Errorf("%w. scrypt parameters too large. n=%d, r=%d, p=%d", ErrSecrets, n, r, p)
+	}
+
+	xy := make([]uint32, 64*r)
+	v := make([]uint32, 32*n*r)
+	b := pbkdf2Sha256(password, salt, p*128*r)
+	for i := 0; i < p; i++ {
+		scryptSmix(b[i*128*r:], r, n, v, xy)
+	}
+	return pbkdf2Sha256(password, b, keyLen), nil
+}
+
+// pbkdf2Sha256 迭代次数为1的PBKDF2-HMAC-SHA256，scrypt只需要这种情况
+func pbkdf2Sha256(password, salt []byte, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var index [4]byte
+	dk := make([]byte, 0, keyLen+prf.Size())
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], block)
+		prf.Write(index[:])
+		dk = prf.Sum(dk)
+	}
+	return dk[:keyLen]
+}
+
+func scryptSmix(b []byte, r, n int, v, xy []uint32) {
+	var tmp [16]uint32
+	blockLen := 32 * r
+	x := xy
+	y := xy[blockLen:]
+
+	for i := 0; i < blockLen; i++ {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < n; i += 2 {
+		copy(v[i*blockLen:], x[:blockLen])
+		scryptBlockMix(&tmp, x, y, r)
+		copy(v[(i+1)*blockLen:], y[:blockLen])
+		scryptBlockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < n; i += 2 {
+		j := int(scryptInteger(x, r) & uint64(n-1))
+		scryptBlockXor(x, v[j*blockLen:], blockLen)
+		scryptBlockMix(&tmp, x, y, r)
+		j = int(scryptInteger(y, r) & uint64(n-1))
+		scryptBlockXor(y, v[j*blockLen:], blockLen)
+		scryptBlockMix(&tmp, y, x, r)
+	}
+	for i, w := range x[:blockLen] {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+func scryptBlockMix(tmp *[16]uint32, in, out []uint32, r int) {
+	copy(tmp[:], in[(2*r-1)*16:])
+	for i := 0; i < 2*r; i += 2 {
+		salsa208Xor(tmp, in[i*16:], out[i*8:])
+		salsa208Xor(tmp, in[i*16+16:], out[i*8+r*16:])
+	}
+}
+
+func scryptBlockXor(dst, src []uint32, n int) {
+	for i, w := range src[:n] {
+		dst[i] ^= w
+	}
+}
+
+func scryptInteger(b []uint32, r int) uint64 {
+	j := (2*r - 1) * 16
+	return uint64(b[j]) | uint64(b[j+1])<<32
+}
+
+// salsa208Xor tmp = salsa20/8(tmp ^ in)，并写入out
+func salsa208Xor(tmp *[16]uint32, in, out []uint32) {
+	var w [16]uint32
+	for i := range w {
+		w[i] = tmp[i] ^ in[i]
+	}
+	x := w
+	for i := 0; i < 8; i += 2 {
+		salsaQuarterRound(&x, 0, 4, 8, 12)
+		salsaQuarterRound(&x, 5, 9, 13, 1)
+		salsaQuarterRound(&x, 10, 14, 2, 6)
+		salsaQuarterRound(&x, 15, 3, 7, 11)
+
+		salsaQuarterRound(&x, 0, 1, 2, 3)
+		salsaQuarterRound(&x, 5, 6, 7, 4)
+		salsaQuarterRound(&x, 10, 11, 8, 9)
+		salsaQuarterRound(&x, 15, 12, 13, 14)
+	}
+	for i := range x {
+		x[i] += w[i]
+		out[i] = x[i]
+		tmp[i] = x[i]
+	}
+}
+
+func salsaQuarterRound(x *[16]uint32, a, b, c, d int) {
+	x[b] ^= bits.RotateLeft32(x[a]+x[d], 7)
+	x[c] ^= bits.RotateLeft32(x[b]+x[a], 9)
+	x[d] ^= bits.RotateLeft32(x[c]+x[b], 13)
+	x[a] ^= bits.RotateLeft32(x[d]+x[c], 18)
+}
@@ -0,0 +1,37 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestScryptKey(t *testing.T) {
+	// rfc7914 12. Test Vectors
+	golden := []struct {
+		password string
+		salt     string
+		n, r, p  int
+		out      string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, g := range golden {
+		k, err := scryptKey([]byte(g.password), []byte(g.salt), g.n, g.r, g.p, 64)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, g.out, hex.EncodeToString(k))
+	}
+
+	_, err := scryptKey([]byte("password"), nil, 1000, 8, 1, 32)
+	assert.IsNotNil(t, err)
+}
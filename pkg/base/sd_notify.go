@@ -0,0 +1,60 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd的sd_notify协议，用于`Type=notify`的service，见 https://www.freedesktop.org/software/systemd/man/sd_notify.html
+//
+// systemd通过环境变量NOTIFY_SOCKET传入unix datagram socket的地址，进程向该地址发送状态文本：
+//   READY=1    启动完成，systemd在收到后才认为服务启动成功，再启动依赖它的服务
+//   STOPPING=1 开始退出
+//   WATCHDOG=1 报活，配置了`WatchdogSec`时，超过该时间没有收到则认为进程卡死，由systemd按`Restart`配置重启
+
+const (
+	SdNotifyReady    = "READY=1"
+	SdNotifyStopping = "STOPPING=1"
+	SdNotifyWatchdog = "WATCHDOG=1"
+)
+
+// SdNotify 向systemd发送状态，不是由systemd以`Type=notify`启动（NOTIFY_SOCKET为空）时什么也不做
+//
+func SdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// 注意，`@`开头的抽象命名空间地址，net包内部会处理
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// SdWatchdogInterval systemd为本进程开启了watchdog时，返回发送 SdNotifyWatchdog 的建议间隔（超时时间的一半），否则返回0
+//
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID不为空时，只对该pid生效，避免子进程继承环境变量后误发
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,57 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build linux darwin
+
+package base
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	_ = os.Unsetenv("NOTIFY_SOCKET")
+	assert.Equal(t, nil, SdNotify(SdNotifyReady))
+
+	dir, err := ioutil.TempDir("", "lal_sdnotify")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	_ = os.Setenv("NOTIFY_SOCKET", name)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	assert.Equal(t, nil, SdNotify(SdNotifyReady))
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, SdNotifyReady, string(buf[:n]))
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	_ = os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(t, time.Duration(0), SdWatchdogInterval())
+	_ = os.Setenv("WATCHDOG_USEC", "10000000")
+	assert.Equal(t, 5*time.Second, SdWatchdogInterval())
+	_ = os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	assert.Equal(t, time.Duration(0), SdWatchdogInterval())
+}
@@ -0,0 +1,116 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// secrets.go
+//
+// 加密的secrets文件，用于在配置文件中引用密钥、密码等敏感信息，而不是明文写在配置文件中
+//
+// 明文为json对象，key为secret名称，value为secret的值，比如 {"auth_key": "xxx", "onvif_password": "yyy"}
+// 文件内容为base64(salt + nonce + AES-256-GCM密文)
+//
+// 密钥`key`为64个字符的hex时直接作为AES-256的密钥，否则做为口令，使用scrypt和随机的salt派生密钥
+//
+
+const secretsSaltSize = 16
+
+// scrypt参数，派生一次约需要32MB内存
+const (
+	secretsScryptN = 1 << 15
+	secretsScryptR = 8
+	secretsScryptP = 1
+)
+
+// EncryptSecrets 加密`plain`，`plain`必须是值为字符串的json对象
+//
+func EncryptSecrets(plain []byte, key string) ([]byte, error) {
+	var m map[string]string
+	if err := json.Unmarshal(plain, &m); err != nil {
+		return nil, fmt.Errorf("%w. plain is not a json object with string values. err=%+v", ErrSecrets, err)
+	}
+
+	salt := make([]byte, secretsSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newSecretsAead(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(append(salt, nonce...), nonce, plain, nil)
+	return []byte(base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptSecrets 解密 EncryptSecrets 生成的内容
+//
+func DecryptSecrets(content []byte, key string) (map[string]string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("%w. invalid base64. err=%+v", ErrSecrets, err)
+	}
+	if len(sealed) < secretsSaltSize {
+		return nil, fmt.Errorf("%w. content too short", ErrSecrets)
+	}
+	aead, err := newSecretsAead(key, sealed[:secretsSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[secretsSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w. content too short", ErrSecrets)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		// 通常是密钥错误
+		return nil, fmt.Errorf("%w. decrypt failed, key may be wrong", ErrSecrets)
+	}
+	var m map[string]string
+	if err = json.Unmarshal(plain, &m); err != nil {
+		return nil, fmt.Errorf("%w. plain is not a json object with string values. err=%+v", ErrSecrets, err)
+	}
+	return m, nil
+}
+
+// newSecretsAead `key`为64个字符的hex时直接作为密钥，忽略`salt`，否则做为口令，使用scrypt派生密钥
+//
+func newSecretsAead(key string, salt []byte) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%w. key empty", ErrSecrets)
+	}
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		if k, err = scryptKey([]byte(key), salt, secretsScryptN, secretsScryptR, secretsScryptP, 32); err != nil {
+			return nil, err
+		}
+	}
+	return newAesGcm(k)
+}
+
+func newAesGcm(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSecrets(t *testing.T) {
+	for _, key := range []string{"passphrase", strings.Repeat("0f", 32)} {
+		enc, err := EncryptSecrets([]byte(`{"a": "1", "b": "2"}`), key)
+		assert.Equal(t, nil, err)
+		m, err := DecryptSecrets(enc, key)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+
+		_, err = DecryptSecrets(enc, key+"x")
+		assert.Equal(t, true, errors.Is(err, ErrSecrets))
+	}
+
+	// 每次加密使用随机的salt，相同口令的密文不同
+	enc1, _ := EncryptSecrets([]byte(`{"a": "1"}`), "passphrase")
+	enc2, _ := EncryptSecrets([]byte(`{"a": "1"}`), "passphrase")
+	b1, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(string(enc1)))
+	b2, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(string(enc2)))
+	assert.Equal(t, false, bytes.Equal(b1[:secretsSaltSize], b2[:secretsSaltSize]))
+
+	_, err := EncryptSecrets([]byte(`{"a": 1}`), "k")
+	assert.Equal(t, true, errors.Is(err, ErrSecrets))
+	_, err = EncryptSecrets([]byte(`{"a": "1"}`), "")
+	assert.Equal(t, true, errors.Is(err, ErrSecrets))
+	_, err = DecryptSecrets([]byte("!!!"), "k")
+	assert.Equal(t, true, errors.Is(err, ErrSecrets))
+	_, err = DecryptSecrets([]byte("AAAA"), "k")
+	assert.Equal(t, true, errors.Is(err, ErrSecrets))
+}
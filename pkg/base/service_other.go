@@ -0,0 +1,17 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build !windows
+
+package base
+
+// RunWindowsService 非Windows平台，总是返回false，见service_windows.go
+//
+func RunWindowsService(name string, run func() error, stop func()) (isService bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// +build windows
+
+package base
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// 直接调用advapi32.dll中的服务相关接口，避免引入golang.org/x/sys依赖
+
+var (
+	modAdvapi32                       = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = modAdvapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = modAdvapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = modAdvapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorServiceSpecificError           = 1066
+	errorFailedServiceControllerConnect = syscall.Errno(1063)
+
+	serviceStopWaitHintMs = 30000
+)
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+type windowsService struct {
+	name *uint16
+	run  func() error
+	stop func()
+	err  error // `run`的返回值
+
+	mutex    sync.Mutex
+	handle   uintptr
+	status   serviceStatus
+	stopOnce sync.Once
+}
+
+// 回调函数创建后无法释放，所以只创建一次，通过全局变量找到当前的服务
+var (
+	theService          *windowsService
+	serviceMainCallback = syscall.NewCallback(serviceMain)
+	ctrlHandlerCallback = syscall.NewCallback(serviceCtrlHandler)
+)
+
+// RunWindowsService 如果当前进程是由Windows服务控制管理器（SCM）启动的，则作为服务运行，阻塞直到服务停止
+//
+// 注意，服务进程的工作目录为系统目录，配置文件等需要使用绝对路径
+//
+// @param run  服务的主逻辑，返回时服务停止，返回的错误会作为服务的退出码上报
+// @param stop 收到SCM的停止、关机控制时调用，应该使`run`返回
+//
+// @return isService 为false表示不是以服务方式启动的，没有执行`run`，调用方按普通进程运行
+//
+func RunWindowsService(name string, run func() error, stop func()) (isService bool, err error) {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return false, err
+	}
+	s := &windowsService{
+		name: namePtr,
+		run:  run,
+		stop: stop,
+	}
+	theService = s
+
+	table := []serviceTableEntry{{ServiceName: namePtr, ServiceProc: serviceMainCallback}, {}}
+	r, _, e := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if errors.Is(e, errorFailedServiceControllerConnect) {
+			return false, nil
+		}
+		return false, e
+	}
+	return true, s.err
+}
+
+func serviceMain(argc uintptr, argv uintptr) uintptr {
+	s := theService
+	h, _, e := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(s.name)), ctrlHandlerCallback, 0)
+	if h == 0 {
+		Log.Errorf("register service ctrl handler failed. err=%+v", e)
+		return 0
+	}
+	s.handle = h
+
+	s.setStatus(serviceRunning, 0)
+	if s.err = s.run(); s.err != nil {
+		Log.Errorf("service run failed. err=%+v", s.err)
+		s.setStatus(serviceStopped, errorServiceSpecificError)
+		return 0
+	}
+	s.setStatus(serviceStopped, 0)
+	return 0
+}
+
+func serviceCtrlHandler(ctrl uintptr, eventType uintptr, eventData uintptr, context uintptr) uintptr {
+	s := theService
+	switch ctrl {
+	case serviceControlStop, serviceControlShutdown:
+		Log.Infof("recv service ctrl. ctrl=%d", ctrl)
+		s.setStatus(serviceStopPending, 0)
+		s.stopOnce.Do(func() {
+			go s.stop()
+		})
+		return 0
+	case serviceControlInterrogate:
+		s.mutex.Lock()
+		s.report()
+		s.mutex.Unlock()
+		return 0
+	}
+	return errorCallNotImplemented
+}
+
+func (s *windowsService) setStatus(state uint32, exitCode uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.ServiceType = serviceWin32OwnProcess
+	s.status.CurrentState = state
+	s.status.Win32ExitCode = exitCode
+	if exitCode == errorServiceSpecificError {
+		s.status.ServiceSpecificExitCode = 1
+	}
+	s.status.ControlsAccepted = 0
+	s.status.WaitHint = 0
+	switch state {
+	case serviceRunning:
+		s.status.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStopPending:
+		s.status.CheckPoint++
+		s.status.WaitHint = serviceStopWaitHintMs
+	}
+	s.report()
+}
+
+func (s *windowsService) report() {
+	if r, _, e := procSetServiceStatus.Call(s.handle, uintptr(unsafe.Pointer(&s.status))); r == 0 {
+		Log.Warnf("set service status failed. err=%+v", e)
+	}
+}
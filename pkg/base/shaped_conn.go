@@ -0,0 +1,113 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	shapedConnBurstDuration = 100 * time.Millisecond
+	shapedConnMinBurst      = 4096
+)
+
+// ShapedConn 限制发送码率的net.Conn，用于转推等不面向观众的流量，避免在上行带宽受限时挤占观众的出口带宽
+//
+// 按令牌桶匀速发送，没有令牌时Write阻塞等待。桶容量为100毫秒的数据量（不小于4KB），大于桶容量的写入拆分成多次发送。
+// 通过SetWriteDeadline、SetDeadline设置的写超时不包含等待令牌的时间。
+//
+// 注意：
+//   - Write会阻塞，需要在单独的协程中写，比如使用 connection.Option.WriteChanSize 异步发送
+//   - 假定同一时间最多只有一个写，和 connection.Connection 的使用方式一致
+type ShapedConn struct {
+	net.Conn
+
+	bytesPerSec float64
+	burst       int
+
+	tokens        float64
+	last          time.Time
+	writeDeadline time.Time
+}
+
+// NewShapedConn
+//
+// @param bitrateKbits 发送码率上限，单位kbit/s
+//
+func NewShapedConn(conn net.Conn, bitrateKbits int) *ShapedConn {
+	bytesPerSec := float64(bitrateKbits) * 1024 / 8
+	burst := int(bytesPerSec * shapedConnBurstDuration.Seconds())
+	if burst < shapedConnMinBurst {
+		burst = shapedConnMinBurst
+	}
+	return &ShapedConn{
+		Conn:        conn,
+		bytesPerSec: bytesPerSec,
+		burst:       burst,
+		tokens:      float64(burst),
+		last:        time.Now(),
+	}
+}
+
+func (c *ShapedConn) Write(b []byte) (n int, err error) {
+	var waited time.Duration
+	for len(b) > 0 {
+		chunk := len(b)
+		if chunk > c.burst {
+			chunk = c.burst
+		}
+		if wait := c.reserve(chunk, time.Now()); wait > 0 {
+			time.Sleep(wait)
+			waited += wait
+			if !c.writeDeadline.IsZero() {
+				if err = c.Conn.SetWriteDeadline(c.writeDeadline.Add(waited)); err != nil {
+					return
+				}
+			}
+		}
+		var m int
+		m, err = c.Conn.Write(b[:chunk])
+		n += m
+		if err != nil {
+			return
+		}
+		b = b[chunk:]
+	}
+	return
+}
+
+func (c *ShapedConn) SetDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *ShapedConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// reserve 消耗`n`个字节的令牌，令牌不足时允许透支
+//
+// @return 需要等待的时间，等待后透支的令牌补充完毕
+//
+func (c *ShapedConn) reserve(n int, now time.Time) time.Duration {
+	if now.After(c.last) {
+		c.tokens += now.Sub(c.last).Seconds() * c.bytesPerSec
+		if c.tokens > float64(c.burst) {
+			c.tokens = float64(c.burst)
+		}
+		c.last = now
+	}
+	c.tokens -= float64(n)
+	if c.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-c.tokens / c.bytesPerSec * float64(time.Second))
+}
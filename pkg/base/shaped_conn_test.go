@@ -0,0 +1,53 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestShapedConn(t *testing.T) {
+	// 8000kbit/s，即1024000字节每秒，桶容量为102400字节
+	conn := NewShapedConn(nil, 8000)
+	assert.Equal(t, 102400, conn.burst)
+
+	now := conn.last
+	assert.Equal(t, time.Duration(0), conn.reserve(102400, now))
+	// 令牌用完，透支10240字节需要等待10毫秒
+	assert.Equal(t, 10*time.Millisecond, conn.reserve(10240, now))
+	// 等待后补充完毕
+	now = now.Add(10 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), conn.reserve(0, now))
+	// 桶容量的上限
+	now = now.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), conn.reserve(102400, now))
+	assert.Equal(t, true, conn.reserve(1, now) > 0)
+
+	// 实际发送，除去桶中初始的令牌，300KB需要大约200毫秒
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, c2)
+	}()
+	conn = NewShapedConn(c1, 8000)
+	assert.Equal(t, nil, conn.SetWriteDeadline(time.Now().Add(50*time.Millisecond)))
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 300*1024))
+	elapsed := time.Since(start)
+	// 写超时不包含等待令牌的时间
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 300*1024, n)
+	assert.Equal(t, true, elapsed >= 180*time.Millisecond && elapsed < time.Second, elapsed.String())
+}
@@ -16,13 +16,15 @@ import (
 	"syscall"
 )
 
-// RunSignalHandler 监听SIGUSR1和SIGUSR2信号并回调
+// RunSignalHandler 监听SIGUSR1、SIGUSR2以及SIGTERM信号并回调
+//
+// 注意，systemd等init系统停止服务时发送SIGTERM，收到后和SIGUSR1一样正常退出
 //
 // TODO(chef): refactor 函数名应与SIGUSR1挂钩
 //
 func RunSignalHandler(cb func()) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM)
 	s := <-c
 	Log.Infof("recv signal. s=%+v", s)
 	cb()
@@ -11,6 +11,7 @@ package base
 const (
 	// AudioCodecAac StatGroup.AudioCodec
 	AudioCodecAac = "AAC"
+	AudioCodecMp3 = "MP3"
 
 	// VideoCodecAvc StatGroup.VideoCodec
 	VideoCodecAvc  = "H264"
@@ -21,6 +22,9 @@ const (
 	ProtocolRtsp    = "RTSP"
 	ProtocolHttpflv = "HTTP-FLV"
 	ProtocolHttpts  = "HTTP-TS"
+	ProtocolHls     = "HLS"
+	ProtocolRist    = "RIST"
+	ProtocolEs      = "ES"
 )
 
 type StatGroup struct {
@@ -32,6 +36,12 @@ type StatGroup struct {
 	StatPub     StatPub   `json:"pub"`
 	StatSubs    []StatSub `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
 	StatPull    StatPull  `json:"pull"`
+	ViewerCount int       `json:"viewer_count"` // 观看人数，rtmp、rtsp、httpflv、httpts sub的数量之和，不包含转推和customize sub
+
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`          // 输入流的onMetaData
+	MetadataOverride map[string]interface{} `json:"metadata_override,omitempty"` // 通过http api设置的覆盖onMetaData的字段
+
+	VideoStat *StatVideo `json:"video_stat,omitempty"` // 输入流视频的编码特征，只有h264、h265视频才有
 }
 
 type StatPub struct {
@@ -57,6 +67,96 @@ type StatSession struct {
 	Bitrate       int    `json:"bitrate"`
 	ReadBitrate   int    `json:"read_bitrate"`
 	WriteBitrate  int    `json:"write_bitrate"`
+
+	Rtcp         *StatRtcp         `json:"rtcp,omitempty"`          // 只有rtsp等基于rtp的session才有
+	JitterBuffer *StatJitterBuffer `json:"jitter_buffer,omitempty"` // 只有开启了抖动缓冲的rtp over udp输入才有
+	Geo          *StatGeo          `json:"geo,omitempty"`           // 开启了geoip时，RemoteAddr对应的地理位置
+
+	Tags map[string]string `json:"tags,omitempty"` // 业务方通过http api设置的标签
+}
+
+// StatVideo 输入流视频的gop、B帧、profile/level统计，用于业务方检查推流端的编码参数是否符合要求
+//
+// 关键帧间隔使用相邻两个关键帧的dts差值；B帧通过pts回退判断，即pts小于同一gop中之前的帧的最大pts
+//
+type StatVideo struct {
+	Profile string `json:"profile"` // 比如h264的Baseline、Main、High，h265的Main、Main 10
+	Level   string `json:"level"`   // 比如3.1、4.0
+
+	FrameCount             int  `json:"frame_count"`
+	KeyFrameCount          int  `json:"key_frame_count"`
+	LastKeyFrameIntervalMs int  `json:"last_key_frame_interval_ms"` // 最近一个完整gop的时长，0表示还没有完整的gop
+	AvgKeyFrameIntervalMs  int  `json:"avg_key_frame_interval_ms"`
+	MinKeyFrameIntervalMs  int  `json:"min_key_frame_interval_ms"`
+	MaxKeyFrameIntervalMs  int  `json:"max_key_frame_interval_ms"`
+	BFrameCount            int  `json:"b_frame_count"`
+	HasBFrame              bool `json:"has_b_frame"`
+}
+
+// StatIpSession 按客户端ip查询时，返回的session，包含所属流名称
+//
+type StatIpSession struct {
+	StreamName string `json:"stream_name"`
+	StatSession
+}
+
+// StatRtcp 通过rtcp sr/rr统计的网络质量
+//
+type StatRtcp struct {
+	Jitter      int     `json:"jitter"`       // 抖动，单位毫秒
+	Rtt         int     `json:"rtt"`          // 往返时延，单位毫秒，-1表示未知
+	LossRate    float64 `json:"loss_rate"`    // 最近一个rtcp周期内的丢包率，取值范围[0, 1]
+	LostPackets uint32  `json:"lost_packets"` // 累计丢包数
+}
+
+// StatJitterBuffer rtp输入抖动缓冲的统计，音频和视频的包合计
+//
+type StatJitterBuffer struct {
+	DelayMs   int    `json:"delay_ms"`  // 缓冲时长，单位毫秒
+	Received  uint64 `json:"received"`  // 收到的包数量
+	Duplicate uint64 `json:"duplicate"` // 重复的包
+	Late      uint64 `json:"late"`      // 迟到的包，到达时已经超过等待时长，丢弃
+	Lost      uint64 `json:"lost"`      // 等待超时仍然没有收到，跳过的包
+}
+
+// StatGeo ip的地理位置以及所属的自治系统，见 geoip.Reader
+//
+type StatGeo struct {
+	Country string `json:"country,omitempty"` // 国家的ISO 3166-1代码，比如CN、US
+	Region  string `json:"region,omitempty"`  // 省、州
+	City    string `json:"city,omitempty"`
+	Asn     uint32 `json:"asn,omitempty"`     // 自治系统号
+	AsnOrg  string `json:"asn_org,omitempty"` // 自治系统所属的组织
+}
+
+// StatRecordRetention 录制清理的统计
+//
+type StatRecordRetention struct {
+	LastCheckTime  string `json:"last_check_time"`
+	TotalBytes     int64  `json:"total_bytes"`     // 上次检查后，录制目录中文件的总大小
+	FreeDiskBytes  int64  `json:"free_disk_bytes"` // 磁盘剩余空间，-1表示未知
+	RecordPaused   bool   `json:"record_paused"`   // 是否由于磁盘空间不足暂停了录制
+	ReclaimedFiles int64  `json:"reclaimed_files"` // 累计删除的文件数
+	ReclaimedBytes int64  `json:"reclaimed_bytes"` // 累计回收的空间
+}
+
+// StatRateLimit 限流的统计
+//
+type StatRateLimit struct {
+	Allowed  uint64 `json:"allowed"`  // 累计放行的请求数
+	Rejected uint64 `json:"rejected"` // 累计拒绝的请求数
+	IpNum    int    `json:"ip_num"`   // 当前跟踪的ip数量
+}
+
+// StatConnGuard 防连接风暴的统计，见 ConnGuard
+//
+type StatConnGuard struct {
+	Accepted         uint64 `json:"accepted"`          // 累计接入的连接数
+	RejectedRate     uint64 `json:"rejected_rate"`     // 累计因超出接入速率被关闭的连接数
+	RejectedPerIp    uint64 `json:"rejected_per_ip"`   // 累计因超出单ip并发连接数被关闭的连接数
+	HandshakeTimeout uint64 `json:"handshake_timeout"` // 累计因握手超时被关闭的连接数
+	ActiveConns      int    `json:"active_conns"`      // 当前的连接数
+	ActiveIps        int    `json:"active_ips"`        // 当前有连接的ip数
 }
 
 func StatSession2Pub(ss StatSession) (ret StatPub) {
@@ -69,6 +169,9 @@ func StatSession2Pub(ss StatSession) (ret StatPub) {
 	ret.ReadBitrate = ss.ReadBitrate
 	ret.WriteBitrate = ss.WriteBitrate
 	ret.Bitrate = ss.Bitrate
+	ret.Rtcp = ss.Rtcp
+	ret.JitterBuffer = ss.JitterBuffer
+	ret.Geo = ss.Geo
 	return
 }
 
@@ -82,6 +185,9 @@ func StatSession2Sub(ss StatSession) (ret StatSub) {
 	ret.ReadBitrate = ss.ReadBitrate
 	ret.WriteBitrate = ss.WriteBitrate
 	ret.Bitrate = ss.Bitrate
+	ret.Rtcp = ss.Rtcp
+	ret.JitterBuffer = ss.JitterBuffer
+	ret.Geo = ss.Geo
 	return
 }
 
@@ -95,5 +201,71 @@ func StatSession2Pull(ss StatSession) (ret StatPull) {
 	ret.ReadBitrate = ss.ReadBitrate
 	ret.WriteBitrate = ss.WriteBitrate
 	ret.Bitrate = ss.Bitrate
+	ret.Rtcp = ss.Rtcp
+	ret.JitterBuffer = ss.JitterBuffer
+	ret.Geo = ss.Geo
 	return
 }
+
+// StatHistorySample 流的一个历史采样点
+//
+type StatHistorySample struct {
+	Time        int64 `json:"time"`          // 采样时间，unix秒
+	InBitrate   int   `json:"in_bitrate"`    // 输入流（pub或pull）的码率，单位kbit/s
+	OutBitrate  int   `json:"out_bitrate"`   // 所有sub的发送码率之和，单位kbit/s
+	SubCount    int   `json:"sub_count"`     // sub session的数量
+	HasInStream bool  `json:"has_in_stream"` // 是否有输入流
+}
+
+type StatStreamHistory struct {
+	StreamName string              `json:"stream_name"`
+	Samples    []StatHistorySample `json:"samples"` // 按时间从旧到新
+}
+
+// DebugGroupState 单路流内部处理流程的状态，用于排查问题
+//
+type DebugGroupState struct {
+	UniqueKey  string `json:"unique_key"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+
+	InSession   string `json:"in_session"` // 输入流类型：rtmp_pub、rtsp_pub、customize_pub、rtmp_pull，为空表示没有输入流
+	InSessionId string `json:"in_session_id"`
+
+	Rtsp2RtmpRemuxer   bool `json:"rtsp2rtmp_remuxer"`
+	Rtmp2RtspRemuxer   bool `json:"rtmp2rtsp_remuxer"`
+	Rtmp2MpegtsRemuxer bool `json:"rtmp2mpegts_remuxer"`
+	HasSdp             bool `json:"has_sdp"`
+	HasPatPmt          bool `json:"has_patpmt"`
+
+	RtmpGopCount    int `json:"rtmp_gop_count"`
+	HttpflvGopCount int `json:"httpflv_gop_count"`
+	HttptsGopCount  int `json:"httpts_gop_count"`
+
+	RtmpSubCount      int `json:"rtmp_sub_count"`
+	HttpflvSubCount   int `json:"httpflv_sub_count"`
+	HttptsSubCount    int `json:"httpts_sub_count"`
+	RtspSubCount      int `json:"rtsp_sub_count"`
+	CustomizeSubCount int `json:"customize_sub_count"`
+	SubWaitPubCount   int `json:"sub_wait_pub_count"`
+
+	PullEnable     bool   `json:"pull_enable"`
+	PullUrl        string `json:"pull_url"`
+	IsPulling      bool   `json:"is_pulling"`
+	PullRetryCount int    `json:"pull_retry_count"`
+
+	Pushes []DebugPushState `json:"pushes"`
+
+	HlsOutPath          string `json:"hls_out_path"` // 为空表示没有生成hls
+	RecordFlv           bool   `json:"record_flv"`
+	RecordMpegts        bool   `json:"record_mpegts"`
+	RecordPaused        bool   `json:"record_paused"`
+	WaitingResumeRecord bool   `json:"waiting_resume_record"`
+}
+
+type DebugPushState struct {
+	Url        string `json:"url"`
+	IsPushing  bool   `json:"is_pushing"`
+	SessionId  string `json:"session_id"`
+	RetryCount int    `json:"retry_count"`
+}
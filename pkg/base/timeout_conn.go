@@ -0,0 +1,119 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutConn 使用 TimerWheel 检查读写超时的net.Conn
+//
+// 和每次读写前调用SetReadDeadline、SetWriteDeadline不同，只在读写开始时记录时间，由时间轮检查当前的读（写）是否已经阻塞超过超时时间，
+// 超时则关闭连接，阻塞的读写返回 ErrConnTimeout 。
+// 只有存在阻塞的读写时才有定时任务，并且每个超时时间内最多重新添加一次，用于大量长连接的场景，比如httpflv拉流。
+//
+// 注意：
+//   - 超时的精度为时间轮的`tick`
+//   - 假定同一时间最多只有一个读和一个写，和 connection.Connection 的使用方式一致
+type TimeoutConn struct {
+	net.Conn
+
+	tw      *TimerWheel
+	read    timeoutConnOp
+	write   timeoutConnOp
+	timeout int32 // 发生了超时，原子操作
+}
+
+type timeoutConnOp struct {
+	timeoutNs int64
+	startNs   int64 // 当前阻塞的读（写）开始的时间，0表示没有正在进行的读（写），原子操作
+	armed     int32 // 是否已经添加了检查的定时任务，原子操作
+}
+
+// NewTimeoutConn
+//
+// @param tw             为nil时使用 DefaultTimerWheel
+// @param readTimeoutMs  为0时不检查读超时
+// @param writeTimeoutMs 为0时不检查写超时
+func NewTimeoutConn(conn net.Conn, tw *TimerWheel, readTimeoutMs int, writeTimeoutMs int) *TimeoutConn {
+	if tw == nil {
+		tw = DefaultTimerWheel()
+	}
+	c := &TimeoutConn{
+		Conn: conn,
+		tw:   tw,
+	}
+	c.read.timeoutNs = int64(readTimeoutMs) * int64(time.Millisecond)
+	c.write.timeoutNs = int64(writeTimeoutMs) * int64(time.Millisecond)
+	return c
+}
+
+func (c *TimeoutConn) Read(b []byte) (n int, err error) {
+	if c.read.timeoutNs == 0 {
+		return c.Conn.Read(b)
+	}
+	c.begin(&c.read)
+	n, err = c.Conn.Read(b)
+	atomic.StoreInt64(&c.read.startNs, 0)
+	return n, c.wrapErr(err, "read")
+}
+
+func (c *TimeoutConn) Write(b []byte) (n int, err error) {
+	if c.write.timeoutNs == 0 {
+		return c.Conn.Write(b)
+	}
+	c.begin(&c.write)
+	n, err = c.Conn.Write(b)
+	atomic.StoreInt64(&c.write.startNs, 0)
+	return n, c.wrapErr(err, "write")
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *TimeoutConn) begin(op *timeoutConnOp) {
+	atomic.StoreInt64(&op.startNs, time.Now().UnixNano())
+	if atomic.CompareAndSwapInt32(&op.armed, 0, 1) {
+		c.tw.AfterFunc(time.Duration(op.timeoutNs), func() {
+			c.check(op)
+		})
+	}
+}
+
+// check 在时间轮的协程中执行
+func (c *TimeoutConn) check(op *timeoutConnOp) {
+	// 注意，先清除armed再读取startNs，和 begin 中的顺序相反，保证新开始的读写一定会被检查
+	atomic.StoreInt32(&op.armed, 0)
+	start := atomic.LoadInt64(&op.startNs)
+	if start == 0 {
+		return
+	}
+	elapsed := time.Now().UnixNano() - start
+	if elapsed >= op.timeoutNs {
+		if atomic.CompareAndSwapInt32(&c.timeout, 0, 1) {
+			// 关闭tls连接时可能需要发送数据，不在时间轮的协程中执行
+			go c.Conn.Close()
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&op.armed, 0, 1) {
+		c.tw.AfterFunc(time.Duration(op.timeoutNs-elapsed), func() {
+			c.check(op)
+		})
+	}
+}
+
+func (c *TimeoutConn) wrapErr(err error, op string) error {
+	if err != nil && atomic.LoadInt32(&c.timeout) == 1 {
+		return fmt.Errorf("%w. op=%s, err=%v", ErrConnTimeout, op, err)
+	}
+	return err
+}
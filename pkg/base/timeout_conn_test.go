@@ -0,0 +1,46 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestTimeoutConn(t *testing.T) {
+	tw := NewTimerWheel(10*time.Millisecond, 16, 2)
+	defer tw.Dispose()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := NewTimeoutConn(c1, tw, 0, 100)
+
+	// 对端在超时时间内读取，不超时
+	go func() {
+		b := make([]byte, 4)
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = c2.Read(b)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write([]byte("test"))
+		assert.Equal(t, nil, err)
+	}
+
+	// 对端不再读取，写阻塞超时后关闭连接
+	begin := time.Now()
+	_, err := conn.Write([]byte("test"))
+	assert.Equal(t, true, errors.Is(err, ErrConnTimeout))
+	assert.Equal(t, true, time.Since(begin) >= 100*time.Millisecond)
+}
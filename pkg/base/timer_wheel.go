@@ -0,0 +1,252 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+// TimerWheel 分层时间轮，用于大量session的保活、超时检查等对精度要求不高的定时任务
+//
+// 所有定时任务共用一个协程和一个ticker，添加、取消定时任务的时间复杂度为O(1)，
+// 避免每个session各自使用time.Timer、time.Ticker带来的协程和runtime定时器的开销。
+//
+// 第0层每个槽位为一个`tick`，第n层每个槽位为`tick * slotNum^n`，时间到达高层槽位时，将其中的定时任务重新放入低层（cascade）。
+// 超过最高层范围的定时任务放在最高层的最后一个槽位，cascade时重新计算。
+//
+// 注意：
+//   - 定时任务最多延迟一个`tick`执行
+//   - 回调函数在时间轮的协程中串行执行，不能阻塞，耗时操作应自行开启协程
+//
+type TimerWheel struct {
+	tick    time.Duration
+	slotNum uint64
+	spans   []uint64 // 每层一个槽位对应的tick数
+
+	mutex   sync.Mutex
+	levels  [][]map[*WheelTimer]struct{}
+	current uint64 // 已经处理到的tick
+
+	exitChan    chan struct{}
+	disposeOnce sync.Once
+}
+
+type WheelTimer struct {
+	tw     *TimerWheel
+	fn     func()
+	period uint64 // 大于0时表示周期性的定时任务，单位tick
+	expire uint64 // 到期的tick
+	level  int    // 所在的层和槽位，level为-1表示不在时间轮中
+	slot   uint64
+}
+
+var (
+	defaultTimerWheel     *TimerWheel
+	defaultTimerWheelOnce sync.Once
+)
+
+// DefaultTimerWheel 进程内共用的时间轮，精度100毫秒，第一次调用时启动
+//
+func DefaultTimerWheel() *TimerWheel {
+	defaultTimerWheelOnce.Do(func() {
+		defaultTimerWheel = NewTimerWheel(100*time.Millisecond, 64, 4)
+	})
+	return defaultTimerWheel
+}
+
+// NewTimerWheel 创建并启动时间轮
+//
+// @param tick     时间精度
+// @param slotNum  每层的槽位数量
+// @param levelNum 层数，能表示的最大时间为`tick * slotNum^levelNum`，超过时也能正确执行，只是需要多次cascade
+//
+func NewTimerWheel(tick time.Duration, slotNum int, levelNum int) *TimerWheel {
+	tw := newTimerWheel(tick, slotNum, levelNum)
+	go tw.runLoop()
+	return tw
+}
+
+// AfterFunc 在`d`之后执行一次`fn`
+//
+func (tw *TimerWheel) AfterFunc(d time.Duration, fn func()) *WheelTimer {
+	return tw.add(d, 0, fn)
+}
+
+// Every 每隔`d`执行一次`fn`，直到调用 WheelTimer.Stop
+//
+func (tw *TimerWheel) Every(d time.Duration, fn func()) *WheelTimer {
+	return tw.add(d, tw.ticksOf(d), fn)
+}
+
+func (tw *TimerWheel) Dispose() {
+	tw.disposeOnce.Do(func() {
+		close(tw.exitChan)
+	})
+}
+
+// Stop 取消定时任务
+//
+// @return 定时任务还没有执行时返回true。注意，对于周期性的定时任务，只要之前没有调用过Stop都返回true
+//
+func (t *WheelTimer) Stop() bool {
+	t.tw.mutex.Lock()
+	defer t.tw.mutex.Unlock()
+	if t.level < 0 {
+		return false
+	}
+	t.tw.remove(t)
+	t.period = 0
+	return true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newTimerWheel(tick time.Duration, slotNum int, levelNum int) *TimerWheel {
+	if slotNum < 2 {
+		slotNum = 2
+	}
+	if levelNum < 1 {
+		levelNum = 1
+	}
+	tw := &TimerWheel{
+		tick:     tick,
+		slotNum:  uint64(slotNum),
+		spans:    make([]uint64, levelNum),
+		levels:   make([][]map[*WheelTimer]struct{}, levelNum),
+		exitChan: make(chan struct{}),
+	}
+	span := uint64(1)
+	for i := range tw.levels {
+		tw.spans[i] = span
+		span *= tw.slotNum
+		tw.levels[i] = make([]map[*WheelTimer]struct{}, slotNum)
+		for j := range tw.levels[i] {
+			tw.levels[i][j] = make(map[*WheelTimer]struct{})
+		}
+	}
+	return tw
+}
+
+func (tw *TimerWheel) runLoop() {
+	startTime := time.Now()
+	t := time.NewTicker(tw.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-tw.exitChan:
+			return
+		case now := <-t.C:
+			tw.advanceTo(uint64(now.Sub(startTime) / tw.tick))
+		}
+	}
+}
+
+func (tw *TimerWheel) ticksOf(d time.Duration) uint64 {
+	n := uint64((d + tw.tick - 1) / tw.tick)
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+func (tw *TimerWheel) add(d time.Duration, period uint64, fn func()) *WheelTimer {
+	t := &WheelTimer{
+		tw:     tw,
+		fn:     fn,
+		period: period,
+		level:  -1,
+	}
+	tw.mutex.Lock()
+	t.expire = tw.current + tw.ticksOf(d)
+	tw.insert(t)
+	tw.mutex.Unlock()
+	return t
+}
+
+// advanceTo 推进到第`target`个tick，执行所有到期的定时任务
+//
+func (tw *TimerWheel) advanceTo(target uint64) {
+	for {
+		tw.mutex.Lock()
+		if tw.current >= target {
+			tw.mutex.Unlock()
+			return
+		}
+		tw.current++
+		tw.cascade()
+
+		slot := tw.levels[0][tw.current%tw.slotNum]
+		var fns []func()
+		for t := range slot {
+			if t.expire > tw.current {
+				continue
+			}
+			tw.remove(t)
+			fns = append(fns, t.fn)
+			if t.period > 0 {
+				t.expire = tw.current + t.period
+				tw.insert(t)
+			}
+		}
+		tw.mutex.Unlock()
+
+		// 不持有锁执行回调，回调中可以添加、取消定时任务
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
+
+// cascade 当前tick到达高层槽位的起点时，将该槽位中的定时任务重新放入低层，注意，调用方需要持有锁
+//
+func (tw *TimerWheel) cascade() {
+	for level := 1; level < len(tw.levels); level++ {
+		if tw.current%tw.spans[level] != 0 {
+			return
+		}
+		slot := tw.levels[level][(tw.current/tw.spans[level])%tw.slotNum]
+		for t := range slot {
+			tw.remove(t)
+			tw.insert(t)
+		}
+	}
+}
+
+// insert 注意，调用方需要持有锁
+//
+// cascade时到期的定时任务（expire等于current）放入第0层当前的槽位，在本次tick中执行
+//
+func (tw *TimerWheel) insert(t *WheelTimer) {
+	if t.expire < tw.current {
+		t.expire = tw.current
+	}
+	delta := t.expire - tw.current
+	last := len(tw.levels) - 1
+	level := 0
+	for ; level < last; level++ {
+		if delta < tw.spans[level]*tw.slotNum {
+			break
+		}
+	}
+	expire := t.expire
+	if maxDelta := tw.spans[last]*tw.slotNum - 1; delta > maxDelta {
+		expire = tw.current + maxDelta
+	}
+	t.level = level
+	t.slot = (expire / tw.spans[level]) % tw.slotNum
+	tw.levels[t.level][t.slot][t] = struct{}{}
+}
+
+// remove 注意，调用方需要持有锁
+//
+func (tw *TimerWheel) remove(t *WheelTimer) {
+	delete(tw.levels[t.level][t.slot], t)
+	t.level = -1
+}
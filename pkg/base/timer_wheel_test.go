@@ -0,0 +1,57 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestTimerWheel(t *testing.T) {
+	// 不启动协程，手动推进时间。4*4*2=32个tick以内不需要特殊处理，超过时放在最高层
+	tw := newTimerWheel(time.Millisecond, 4, 2)
+	fired := make(map[int][]uint64)
+	after := func(id int, ticks int) *WheelTimer {
+		return tw.AfterFunc(time.Duration(ticks)*time.Millisecond, func() {
+			fired[id] = append(fired[id], tw.current)
+		})
+	}
+	for _, ticks := range []int{1, 3, 4, 5, 15, 16, 17, 40, 100} {
+		after(ticks, ticks)
+	}
+	stopped := after(1000, 10)
+	periodic := tw.Every(6*time.Millisecond, func() {
+		fired[-1] = append(fired[-1], tw.current)
+	})
+
+	tw.advanceTo(9)
+	assert.Equal(t, true, stopped.Stop())
+	assert.Equal(t, false, stopped.Stop())
+	tw.advanceTo(30)
+	assert.Equal(t, true, periodic.Stop())
+	tw.advanceTo(200)
+
+	for _, ticks := range []int{1, 3, 4, 5, 15, 16, 17, 40, 100} {
+		assert.Equal(t, []uint64{uint64(ticks)}, fired[ticks])
+	}
+	assert.Equal(t, 0, len(fired[1000]))
+	assert.Equal(t, []uint64{6, 12, 18, 24, 30}, fired[-1])
+
+	// 回调中添加定时任务
+	var nested uint64
+	tw.AfterFunc(time.Millisecond, func() {
+		tw.AfterFunc(2*time.Millisecond, func() {
+			nested = tw.current
+		})
+	})
+	tw.advanceTo(210)
+	assert.Equal(t, uint64(203), nested)
+}
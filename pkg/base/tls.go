@@ -0,0 +1,263 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TlsOption 服务端tls的配置，见 NewTlsConfig
+//
+type TlsOption struct {
+	Certs            []TlsCertOption `json:"certs"`             // 证书，多个时根据客户端的SNI选择，都不匹配时使用第一个
+	MinVersion       string          `json:"min_version"`       // "1.0"、"1.1"、"1.2"、"1.3"，为空默认"1.2"
+	CipherSuites     []string        `json:"cipher_suites"`     // 名称见 tls.CipherSuites ，比如"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"，为空使用 DefaultTlsCipherSuites 。tls1.3不可配置
+	CurvePreferences []string        `json:"curve_preferences"` // "X25519"、"P256"、"P384"、"P521"，为空使用 DefaultTlsCurvePreferences
+	Alpn             []string        `json:"alpn"`              // 比如"http/1.1"，为空则不协商
+
+	// session ticket，用于断线重连时恢复会话，省去完整握手的RTT
+	SessionTicketsDisable  bool   `json:"session_tickets_disable"`   // 为true时关闭session ticket
+	SessionTicketKeyFile   string `json:"session_ticket_key_file"`   // ticket key文件，每行一个hex编码的32字节key，第一个用于加密，所有的都可用于解密。集群的所有节点使用相同的文件时，可以在任意节点上恢复会话。为空时使用随机生成的key
+	SessionTicketRotateSec int    `json:"session_ticket_rotate_sec"` // 大于0时，每隔该时间更新ticket key。配置了key文件时重新读取文件，否则生成新的随机key，上一个key继续用于解密
+
+	OcspStaplingEnable bool `json:"ocsp_stapling_enable"` // 是否开启OCSP stapling，后台从证书中的OCSP地址获取响应，在握手时发送给客户端。证书文件中需要包含签发者的证书
+
+	CheckOnly bool `json:"-"` // 为true时只检查配置、加载证书，不启动OCSP等后台更新，比如lalserver -t
+}
+
+// TlsCertOption 也可以直接填写PEM格式的内容，而不是文件路径，方便通过环境变量或者secrets文件引用私钥
+type TlsCertOption struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// DefaultTlsCipherSuites tls1.2时默认只使用支持前向安全的AEAD套件
+var DefaultTlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+var DefaultTlsCurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+)
+
+// NewTlsConfig 根据`option`构造服务端使用的 tls.Config ，会加载证书文件
+//
+// 配置项不合法、证书加载失败时返回error，调用方可以在启动阶段调用，提前发现配置错误
+//
+func NewTlsConfig(option TlsOption) (*tls.Config, error) {
+	if len(option.Certs) == 0 {
+		return nil, fmt.Errorf("%w. certs empty", ErrTlsOption)
+	}
+
+	config := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     DefaultTlsCipherSuites,
+		CurvePreferences: DefaultTlsCurvePreferences,
+		NextProtos:       option.Alpn,
+	}
+
+	for _, c := range option.Certs {
+		cert, err := loadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if option.OcspStaplingEnable {
+		stapler := newTlsOcspStapler(config.Certificates)
+		if !option.CheckOnly {
+			stapler.maybeUpdate(time.Now())
+		}
+		// crypto/tls在Certificates不为空并且客户端没有带SNI时不会调用GetCertificate，
+		// 所以证书交给stapler后需要清空，否则通过IP连接的客户端拿不到staple，也不会触发更新
+		config.Certificates = nil
+		config.GetCertificate = stapler.GetCertificate
+	}
+
+	if option.MinVersion != "" {
+		v, ok := tlsVersions[option.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w. invalid min_version. min_version=%s", ErrTlsOption, option.MinVersion)
+		}
+		config.MinVersion = v
+	}
+
+	if len(option.CipherSuites) != 0 {
+		name2Id := make(map[string]uint16)
+		for _, cs := range tls.CipherSuites() {
+			name2Id[cs.Name] = cs.ID
+		}
+		for _, cs := range tls.InsecureCipherSuites() {
+			name2Id[cs.Name] = cs.ID
+		}
+		config.CipherSuites = nil
+		for _, name := range option.CipherSuites {
+			id, ok := name2Id[name]
+			if !ok {
+				return nil, fmt.Errorf("%w. invalid cipher suite. name=%s", ErrTlsOption, name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	if len(option.CurvePreferences) != 0 {
+		config.CurvePreferences = nil
+		for _, name := range option.CurvePreferences {
+			id, ok := tlsCurves[strings.ToUpper(name)]
+			if !ok {
+				return nil, fmt.Errorf("%w. invalid curve. name=%s", ErrTlsOption, name)
+			}
+			config.CurvePreferences = append(config.CurvePreferences, id)
+		}
+	}
+
+	if option.SessionTicketsDisable {
+		config.SessionTicketsDisabled = true
+		return config, nil
+	}
+	if option.SessionTicketKeyFile != "" || option.SessionTicketRotateSec > 0 {
+		r := &tlsTicketKeyRotator{
+			config: config,
+			option: option,
+		}
+		if err := r.rotate(time.Now()); err != nil {
+			return nil, err
+		}
+		if option.SessionTicketRotateSec > 0 {
+			// 在握手时检查是否需要更新，返回nil表示继续使用`config`
+			config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				r.maybeRotate()
+				return nil, nil
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// ReadTlsTicketKeyFile 读取ticket key文件，每行一个hex编码的32字节key，忽略空行和`#`开头的行
+//
+func ReadTlsTicketKeyFile(filename string) ([][32]byte, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][32]byte
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%w. invalid session ticket key. file=%s", ErrTlsOption, filename)
+		}
+		var key [32]byte
+		copy(key[:], b)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w. session ticket key file empty. file=%s", ErrTlsOption, filename)
+	}
+	return keys, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type tlsTicketKeyRotator struct {
+	config *tls.Config
+	option TlsOption
+
+	mutex      sync.Mutex
+	keys       [][32]byte
+	nextRotate time.Time
+}
+
+func (r *tlsTicketKeyRotator) maybeRotate() {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now.Before(r.nextRotate) {
+		return
+	}
+	if err := r.rotate(now); err != nil {
+		// 保持原有的key，下次握手时再重试
+		Log.Warnf("rotate tls session ticket key failed. err=%+v", err)
+	}
+}
+
+func (r *tlsTicketKeyRotator) rotate(now time.Time) error {
+	r.nextRotate = now.Add(time.Duration(r.option.SessionTicketRotateSec) * time.Second)
+
+	var keys [][32]byte
+	if r.option.SessionTicketKeyFile != "" {
+		var err error
+		if keys, err = ReadTlsTicketKeyFile(r.option.SessionTicketKeyFile); err != nil {
+			return err
+		}
+	} else {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		keys = [][32]byte{key}
+		if len(r.keys) != 0 {
+			keys = append(keys, r.keys[0])
+		}
+	}
+	r.keys = keys
+	r.config.SetSessionTicketKeys(keys)
+	return nil
+}
+
+func loadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	var (
+		certPem, keyPem []byte
+		err             error
+	)
+	if certPem, err = readFileOrPem(certFile); err != nil {
+		return tls.Certificate{}, err
+	}
+	if keyPem, err = readFileOrPem(keyFile); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPem, keyPem)
+}
+
+func readFileOrPem(s string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN") {
+		return []byte(s), nil
+	}
+	return ioutil.ReadFile(s)
+}
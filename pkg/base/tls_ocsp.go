@@ -0,0 +1,404 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tls_ocsp.go
+//
+// OCSP stapling，见 TlsOption.OcspStaplingEnable
+//
+// 只实现了stapling需要的最小子集（rfc6960）：构造请求，校验响应的签名，解析响应中证书的状态和有效期。
+// 响应由签发者直接签名，或者由签发者授权的OCSP responder证书签名
+//
+
+var (
+	ocspRetryInterval   = 5 * time.Minute
+	ocspDefaultInterval = time.Hour
+	ocspMinInterval     = time.Minute
+	ocspHttpTimeout     = 10 * time.Second
+
+	oidSha1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOcspBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	ocspSignatureAlgorithms = []struct {
+		oid  asn1.ObjectIdentifier
+		algo x509.SignatureAlgorithm
+	}{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+		{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+	}
+)
+
+// OcspStatus 证书的OCSP状态
+type OcspStatus int
+
+const (
+	OcspStatusGood OcspStatus = iota
+	OcspStatusRevoked
+	OcspStatusUnknown
+)
+
+// OcspResponse ParseOcspResponse 的解析结果
+type OcspResponse struct {
+	Status     OcspStatus
+	ThisUpdate time.Time
+	NextUpdate time.Time // 可能为零值，表示响应方没有给出有效期
+}
+
+// NewOcspRequest 构造`cert`的OCSP请求，`issuer`为签发`cert`的证书
+func NewOcspRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newOcspCertId(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{TbsRequest: ocspTbsRequest{RequestList: []ocspSingleRequest{{Cert: id}}}})
+}
+
+// ParseOcspResponse 校验OCSP响应的签名，并解析响应中`cert`的状态，`issuer`为签发`cert`的证书
+func ParseOcspResponse(b []byte, cert, issuer *x509.Certificate) (OcspResponse, error) {
+	var ret OcspResponse
+
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(b, &resp); err != nil {
+		return ret, err
+	} else if len(rest) != 0 {
+		return ret, fmt.Errorf("%w. trailing data after ocsp response", ErrTlsOcsp)
+	}
+	if resp.Status != 0 {
+		return ret, fmt.Errorf("%w. ocsp response status=%d", ErrTlsOcsp, resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOcspBasicResponse) {
+		return ret, fmt.Errorf("%w. unsupported ocsp response type=%s", ErrTlsOcsp, resp.Response.ResponseType)
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return ret, err
+	}
+	if err := checkOcspSignature(&basic, issuer); err != nil {
+		return ret, err
+	}
+	for _, r := range basic.TbsResponseData.Responses {
+		if r.CertId.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		switch {
+		case bool(r.Good):
+			ret.Status = OcspStatusGood
+		case bool(r.Unknown):
+			ret.Status = OcspStatusUnknown
+		default:
+			ret.Status = OcspStatusRevoked
+		}
+		ret.ThisUpdate = r.ThisUpdate
+		ret.NextUpdate = r.NextUpdate
+		return ret, nil
+	}
+	return ret, fmt.Errorf("%w. no response for cert. serial=%s", ErrTlsOcsp, cert.SerialNumber)
+}
+
+// checkOcspSignature 响应中携带了证书时，该证书需要是`issuer`本身，或者由`issuer`签发并且用途包含OCSP签名
+func checkOcspSignature(basic *ocspBasicResponse, issuer *x509.Certificate) error {
+	algo := x509.UnknownSignatureAlgorithm
+	for _, item := range ocspSignatureAlgorithms {
+		if item.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = item.algo
+			break
+		}
+	}
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("%w. unsupported signature algorithm=%s", ErrTlsOcsp, basic.SignatureAlgorithm.Algorithm)
+	}
+
+	signer := issuer
+	if len(basic.Certificates) != 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err = responder.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("%w. responder cert not signed by issuer. err=%+v", ErrTlsOcsp, err)
+			}
+			var ocspSigning bool
+			for _, u := range responder.ExtKeyUsage {
+				if u == x509.ExtKeyUsageOCSPSigning {
+					ocspSigning = true
+					break
+				}
+			}
+			if !ocspSigning {
+				return fmt.Errorf("%w. responder cert without ocsp signing usage", ErrTlsOcsp)
+			}
+			signer = responder
+		}
+	}
+	if err := signer.CheckSignature(algo, basic.TbsResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("%w. bad signature. err=%+v", ErrTlsOcsp, err)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// tlsOcspStapler 在握手时通过 tls.Config.GetCertificate 返回带staple的证书
+//
+// 需要更新时在握手时触发后台更新，更新期间继续使用原来的staple，直到超过响应的有效期（NextUpdate）
+type tlsOcspStapler struct {
+	mutex      sync.Mutex
+	certs      []*tls.Certificate
+	nextTime   []time.Time
+	expireTime []time.Time // staple的有效期，零值表示没有staple或者响应方没有给出有效期
+	updating   []bool
+}
+
+func newTlsOcspStapler(certs []tls.Certificate) *tlsOcspStapler {
+	s := &tlsOcspStapler{
+		nextTime:   make([]time.Time, len(certs)),
+		expireTime: make([]time.Time, len(certs)),
+		updating:   make([]bool, len(certs)),
+	}
+	for i := range certs {
+		c := certs[i]
+		s.certs = append(s.certs, &c)
+	}
+	return s
+}
+
+// GetCertificate 和crypto/tls一样，根据客户端的SNI选择证书，都不匹配时使用第一个
+func (s *tlsOcspStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.maybeUpdate(time.Now())
+
+	s.mutex.Lock()
+	certs := s.certs
+	s.mutex.Unlock()
+	for _, c := range certs {
+		if hello.SupportsCertificate(c) == nil {
+			return c, nil
+		}
+	}
+	return certs[0], nil
+}
+
+func (s *tlsOcspStapler) maybeUpdate(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.certs {
+		if !s.expireTime[i].IsZero() && !now.Before(s.expireTime[i]) {
+			// 过期的staple会被客户端拒绝，不如不发送
+			cert := *s.certs[i]
+			Log.Warnf("ocsp staple expired. subject=%s, expire time=%s", cert.Leaf.Subject, s.expireTime[i].Format(time.RFC3339))
+			cert.OCSPStaple = nil
+			s.setCert(i, cert, time.Time{})
+		}
+		if s.updating[i] || now.Before(s.nextTime[i]) {
+			continue
+		}
+		s.updating[i] = true
+		go s.update(i)
+	}
+}
+
+func (s *tlsOcspStapler) update(i int) {
+	s.mutex.Lock()
+	cert := *s.certs[i]
+	s.mutex.Unlock()
+
+	staple, r, err := fetchOcspStaple(&cert)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updating[i] = false
+	if err != nil {
+		Log.Warnf("fetch ocsp staple failed. err=%+v", err)
+		s.nextTime[i] = now.Add(ocspRetryInterval)
+		return
+	}
+	if staple == nil {
+		// 证书没有OCSP地址，不再尝试
+		s.nextTime[i] = now.Add(100 * 365 * 24 * time.Hour)
+		return
+	}
+	cert.OCSPStaple = staple
+	s.setCert(i, cert, r.NextUpdate)
+	s.nextTime[i] = ocspNextRefreshTime(r, now)
+	Log.Infof("update ocsp staple succ. subject=%s, next refresh=%s", cert.Leaf.Subject, s.nextTime[i].Format(time.RFC3339))
+}
+
+// setCert 替换第`i`个证书。注意，需要持有锁
+func (s *tlsOcspStapler) setCert(i int, cert tls.Certificate, expireTime time.Time) {
+	// 不修改原来的对象，因为握手中可能正在使用
+	certs := make([]*tls.Certificate, len(s.certs))
+	copy(certs, s.certs)
+	certs[i] = &cert
+	s.certs = certs
+	s.expireTime[i] = expireTime
+}
+
+// ocspNextRefreshTime 下次更新的时间，响应剩余有效期的一半，最少间隔 ocspMinInterval
+func ocspNextRefreshTime(r OcspResponse, now time.Time) time.Time {
+	if r.NextUpdate.IsZero() {
+		return now.Add(ocspDefaultInterval)
+	}
+	interval := r.NextUpdate.Sub(now) / 2
+	if interval < ocspMinInterval {
+		interval = ocspMinInterval
+	}
+	return now.Add(interval)
+}
+
+// fetchOcspStaple 证书链中没有签发者或者证书没有OCSP地址时，返回nil staple和nil error
+//
+// 已经过期（NextUpdate早于当前时间）的响应返回错误
+func fetchOcspStaple(cert *tls.Certificate) (staple []byte, r OcspResponse, err error) {
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	if len(cert.Leaf.OCSPServer) == 0 || len(cert.Certificate) < 2 {
+		return
+	}
+	var issuer *x509.Certificate
+	if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+		return
+	}
+
+	var req []byte
+	if req, err = NewOcspRequest(cert.Leaf, issuer); err != nil {
+		return
+	}
+	client := &http.Client{Timeout: ocspHttpTimeout}
+	resp, err := client.Post(cert.Leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%w. http status=%d", ErrTlsOcsp, resp.StatusCode)
+		return
+	}
+	var body []byte
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return
+	}
+
+	if r, err = ParseOcspResponse(body, cert.Leaf, issuer); err != nil {
+		return
+	}
+	if r.Status != OcspStatusGood {
+		err = fmt.Errorf("%w. cert status not good. status=%d", ErrTlsOcsp, r.Status)
+		return
+	}
+	if !r.NextUpdate.IsZero() && !time.Now().Before(r.NextUpdate) {
+		err = fmt.Errorf("%w. response expired. next update=%s", ErrTlsOcsp, r.NextUpdate.Format(time.RFC3339))
+		return
+	}
+	return body, r, nil
+}
+
+func newOcspCertId(cert, issuer *x509.Certificate) (ocspCertId, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertId{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertId{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSha1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+type ocspCertId struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertId
+}
+
+type ocspTbsRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspRequest struct {
+	TbsRequest ocspTbsRequest
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TbsResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw         asn1.RawContent
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderId asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertId     ocspCertId
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
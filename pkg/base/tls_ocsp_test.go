@@ -0,0 +1,179 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestTlsOcspStapler(t *testing.T) {
+	now := time.Now()
+	nextUpdate := now.Add(2 * time.Hour).UTC().Truncate(time.Second)
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var (
+		leaf    *x509.Certificate
+		issuer  *x509.Certificate
+		status  = OcspStatusGood
+		signKey = caKey
+	)
+	ocspServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		expected, _ := NewOcspRequest(leaf, issuer)
+		assert.Equal(t, expected, body)
+
+		id, _ := newOcspCertId(leaf, issuer)
+		single := ocspSingleResponse{CertId: id, ThisUpdate: now.UTC().Truncate(time.Second), NextUpdate: nextUpdate}
+		switch status {
+		case OcspStatusGood:
+			single.Good = true
+		case OcspStatusRevoked:
+			single.Revoked = ocspRevokedInfo{RevocationTime: now.UTC().Truncate(time.Second)}
+		}
+		keyHash, _ := asn1.Marshal(id.IssuerKeyHash)
+		tbs, _ := asn1.Marshal(ocspResponseData{
+			ResponderId: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+			ProducedAt:  now.UTC().Truncate(time.Second),
+			Responses:   []ocspSingleResponse{single},
+		})
+		digest := sha256.Sum256(tbs)
+		sig, _ := ecdsa.SignASN1(rand.Reader, signKey, digest[:])
+		basic, _ := asn1.Marshal(ocspBasicResponse{
+			TbsResponseData:    ocspResponseData{Raw: tbs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+		})
+		resp, _ := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{ResponseType: oidOcspBasicResponse, Response: basic}})
+		_, _ = w.Write(resp)
+	}))
+	defer ocspServer.Close()
+
+	// 生成ca和证书
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lal test ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	issuer, _ = x509.ParseCertificate(caDer)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "lal.test"},
+		DNSNames:     []string{"lal.test"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		OCSPServer:   []string{ocspServer.URL},
+	}
+	leafDer, _ := x509.CreateCertificate(rand.Reader, leafTmpl, issuer, &leafKey.PublicKey, caKey)
+	leaf, _ = x509.ParseCertificate(leafDer)
+	cert := tls.Certificate{Certificate: [][]byte{leafDer, caDer}, PrivateKey: leafKey}
+
+	staple, r, err := fetchOcspStaple(&cert)
+	assert.Equal(t, nil, err)
+	assert.IsNotNil(t, staple)
+	assert.Equal(t, OcspStatusGood, r.Status)
+	assert.Equal(t, nextUpdate, r.NextUpdate)
+	r, err = ParseOcspResponse(staple, leaf, issuer)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nextUpdate, r.NextUpdate)
+	next := ocspNextRefreshTime(r, now)
+	assert.Equal(t, true, next.After(now.Add(59*time.Minute)) && next.Before(nextUpdate))
+	// 剩余有效期很短时，更新间隔不小于 ocspMinInterval
+	assert.Equal(t, now.Add(ocspMinInterval), ocspNextRefreshTime(OcspResponse{NextUpdate: now.Add(-time.Hour)}, now))
+
+	s := newTlsOcspStapler([]tls.Certificate{cert})
+	s.update(0)
+	c, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "lal.test"})
+	assert.Equal(t, nil, err)
+	r, err = ParseOcspResponse(c.OCSPStaple, leaf, issuer)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nextUpdate, r.NextUpdate)
+
+	// 超过有效期后不再发送staple
+	s.mutex.Lock()
+	s.updating[0] = true
+	s.mutex.Unlock()
+	s.maybeUpdate(nextUpdate)
+	c, _ = s.GetCertificate(&tls.ClientHelloInfo{ServerName: "lal.test"})
+	assert.Equal(t, true, c.OCSPStaple == nil)
+
+	// 不是签发者签名的响应
+	signKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, _, err = fetchOcspStaple(&cert)
+	assert.IsNotNil(t, err)
+	signKey = caKey
+
+	// 已经过期的响应
+	validNextUpdate := nextUpdate
+	nextUpdate = now.Add(-time.Minute).UTC().Truncate(time.Second)
+	_, _, err = fetchOcspStaple(&cert)
+	assert.IsNotNil(t, err)
+	nextUpdate = validNextUpdate
+
+	// 吊销的证书不staple
+	status = OcspStatusRevoked
+	_, _, err = fetchOcspStaple(&cert)
+	assert.IsNotNil(t, err)
+	status = OcspStatusGood
+
+	// 客户端不带SNI（比如通过IP连接）时也发送staple
+	keyDer, _ := x509.MarshalECPrivateKey(leafKey)
+	config, err := NewTlsConfig(TlsOption{
+		Certs: []TlsCertOption{{
+			CertFile: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDer})) +
+				string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer})),
+			KeyFile: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		}},
+		OcspStaplingEnable: true,
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(config.Certificates))
+	for i := 0; i < 100; i++ {
+		if c, _ = config.GetCertificate(&tls.ClientHelloInfo{}); c.OCSPStaple != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		_ = tls.Server(serverConn, config).Handshake()
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	assert.Equal(t, nil, client.Handshake())
+	assert.Equal(t, true, len(client.ConnectionState().OCSPResponse) != 0)
+	_ = serverConn.Close()
+	_ = client.Close()
+
+	// 没有签发者的证书
+	staple, _, err = fetchOcspStaple(&tls.Certificate{Certificate: [][]byte{leafDer}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, staple == nil)
+}
@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestNewTlsConfig(t *testing.T) {
+	certs := []TlsCertOption{{CertFile: "../../conf/cert.pem", KeyFile: "../../conf/key.pem"}}
+
+	// 默认值
+	c, err := NewTlsConfig(TlsOption{Certs: certs})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(c.Certificates))
+	assert.Equal(t, uint16(tls.VersionTLS12), c.MinVersion)
+	assert.Equal(t, DefaultTlsCipherSuites, c.CipherSuites)
+	assert.Equal(t, DefaultTlsCurvePreferences, c.CurvePreferences)
+
+	c, err = NewTlsConfig(TlsOption{
+		Certs:            certs,
+		MinVersion:       "1.3",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		CurvePreferences: []string{"x25519", "P521"},
+		Alpn:             []string{"http/1.1"},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, c.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP521}, c.CurvePreferences)
+	assert.Equal(t, []string{"http/1.1"}, c.NextProtos)
+
+	for _, option := range []TlsOption{
+		{},
+		{Certs: certs, MinVersion: "1.4"},
+		{Certs: certs, CipherSuites: []string{"TLS_NOT_EXIST"}},
+		{Certs: certs, CurvePreferences: []string{"P128"}},
+	} {
+		_, err = NewTlsConfig(option)
+		assert.Equal(t, true, errors.Is(err, ErrTlsOption))
+	}
+
+	_, err = NewTlsConfig(TlsOption{Certs: []TlsCertOption{{CertFile: "not_exist.pem", KeyFile: "not_exist.pem"}}})
+	assert.IsNotNil(t, err)
+}
+
+func TestTlsSessionTicketKey(t *testing.T) {
+	certs := []TlsCertOption{{CertFile: "../../conf/cert.pem", KeyFile: "../../conf/key.pem"}}
+
+	c, err := NewTlsConfig(TlsOption{Certs: certs, SessionTicketsDisable: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, c.SessionTicketsDisabled)
+
+	key1 := strings.Repeat("01", 32)
+	key2 := strings.Repeat("ab", 32)
+	filename := filepath.Join(t.TempDir(), "ticket.key")
+	_ = ioutil.WriteFile(filename, []byte("# comment\n"+key1+"\n\n"+key2+"\n"), 0644)
+	keys, err := ReadTlsTicketKeyFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, byte(0x01), keys[0][31])
+	assert.Equal(t, byte(0xab), keys[1][0])
+	_, err = NewTlsConfig(TlsOption{Certs: certs, SessionTicketKeyFile: filename, SessionTicketRotateSec: 60})
+	assert.Equal(t, nil, err)
+
+	_ = ioutil.WriteFile(filename, []byte("0102"), 0644)
+	_, err = NewTlsConfig(TlsOption{Certs: certs, SessionTicketKeyFile: filename})
+	assert.Equal(t, true, errors.Is(err, ErrTlsOption))
+
+	// 随机生成的key，更新后保留上一个key用于解密
+	r := &tlsTicketKeyRotator{config: &tls.Config{}, option: TlsOption{SessionTicketRotateSec: 60}}
+	assert.Equal(t, nil, r.rotate(time.Now()))
+	assert.Equal(t, 1, len(r.keys))
+	prev := r.keys[0]
+	r.maybeRotate()
+	assert.Equal(t, 1, len(r.keys))
+	r.nextRotate = time.Now().Add(-time.Second)
+	r.maybeRotate()
+	assert.Equal(t, 2, len(r.keys))
+	assert.Equal(t, prev, r.keys[1])
+}
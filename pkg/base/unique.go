@@ -12,6 +12,7 @@ import "github.com/q191201771/naza/pkg/unique"
 
 const (
 	UkPreCustomizePubSessionContext = "CUSTOMIZEPUB"
+	UkPreCustomizeSubSessionContext = "CUSTOMIZESUB"
 	UkPreRtmpServerSession          = "RTMPPUBSUB"
 	UkPreRtmpPushSession            = "RTMPPUSH"
 	UkPreRtmpPullSession            = "RTMPPULL"
@@ -23,78 +24,123 @@ const (
 	UkPreFlvSubSession              = "FLVSUB"
 	UkPreTsSubSession               = "TSSUB"
 	UkPreFlvPullSession             = "FLVPULL"
+	UkPreRistPubSession             = "RISTPUB"
+	UkPreEsPubSession               = "ESPUB"
+	UkPreEsPushSession              = "ESPUSH"
 
 	UkPreGroup              = "GROUP"
 	UkPreHlsMuxer           = "HLSMUXER"
 	UkPreRtmp2MpegtsRemuxer = "RTMP2MPEGTS"
+
+	UkPreHttpApiRequest = "APIREQ"
 )
 
+// SetUniqueKeyPrefix 所有unique key（session id等）前面加上`prefix`，比如server id和本次启动的随机串，
+// 使得多个节点、节点重启之后生成的session id也不重复
+//
+// 注意，需要在创建任何session之前调用
+//
+func SetUniqueKeyPrefix(prefix string) {
+	ukPrefix = prefix
+}
+
+// UniqueKeyPrefix 见 SetUniqueKeyPrefix ，没有设置时为空
+//
+func UniqueKeyPrefix() string {
+	return ukPrefix
+}
+
 //func GenUk(prefix string) string {
 //	return unique.GenUniqueKey(prefix)
 //}
 
 func GenUkCustomizePubSession() string {
-	return siUkCustomizePubSession.GenUniqueKey()
+	return ukPrefix + siUkCustomizePubSession.GenUniqueKey()
+}
+
+func GenUkCustomizeSubSession() string {
+	return ukPrefix + siUkCustomizeSubSession.GenUniqueKey()
 }
 
 func GenUkRtmpServerSession() string {
-	return siUkRtmpServerSession.GenUniqueKey()
+	return ukPrefix + siUkRtmpServerSession.GenUniqueKey()
 }
 
 func GenUkRtmpPushSession() string {
-	return siUkRtmpPushSession.GenUniqueKey()
+	return ukPrefix + siUkRtmpPushSession.GenUniqueKey()
 }
 
 func GenUkRtmpPullSession() string {
-	return siUkRtmpPullSession.GenUniqueKey()
+	return ukPrefix + siUkRtmpPullSession.GenUniqueKey()
 }
 
 func GenUkRtspServerCommandSession() string {
-	return siUkRtspServerCommandSession.GenUniqueKey()
+	return ukPrefix + siUkRtspServerCommandSession.GenUniqueKey()
 }
 
 func GenUkRtspPubSession() string {
-	return siUkRtspPubSession.GenUniqueKey()
+	return ukPrefix + siUkRtspPubSession.GenUniqueKey()
 }
 
 func GenUkRtspSubSession() string {
-	return siUkRtspSubSession.GenUniqueKey()
+	return ukPrefix + siUkRtspSubSession.GenUniqueKey()
 }
 
 func GenUkRtspPushSession() string {
-	return siUkRtspPushSession.GenUniqueKey()
+	return ukPrefix + siUkRtspPushSession.GenUniqueKey()
 }
 
 func GenUkRtspPullSession() string {
-	return siUkRtspPullSession.GenUniqueKey()
+	return ukPrefix + siUkRtspPullSession.GenUniqueKey()
 }
 
 func GenUkFlvSubSession() string {
-	return siUkFlvSubSession.GenUniqueKey()
+	return ukPrefix + siUkFlvSubSession.GenUniqueKey()
 }
 
 func GenUkTsSubSession() string {
-	return siUkTsSubSession.GenUniqueKey()
+	return ukPrefix + siUkTsSubSession.GenUniqueKey()
 }
 
 func GenUkFlvPullSession() string {
-	return siUkFlvPullSession.GenUniqueKey()
+	return ukPrefix + siUkFlvPullSession.GenUniqueKey()
+}
+
+func GenUkRistPubSession() string {
+	return ukPrefix + siUkRistPubSession.GenUniqueKey()
+}
+
+func GenUkEsPubSession() string {
+	return ukPrefix + siUkEsPubSession.GenUniqueKey()
+}
+
+func GenUkEsPushSession() string {
+	return ukPrefix + siUkEsPushSession.GenUniqueKey()
 }
 
 func GenUkGroup() string {
-	return siUkGroup.GenUniqueKey()
+	return ukPrefix + siUkGroup.GenUniqueKey()
 }
 
 func GenUkHlsMuxer() string {
-	return siUkHlsMuxer.GenUniqueKey()
+	return ukPrefix + siUkHlsMuxer.GenUniqueKey()
 }
 
 func GenUkRtmp2MpegtsRemuxer() string {
-	return siUkRtmp2MpegtsRemuxer.GenUniqueKey()
+	return ukPrefix + siUkRtmp2MpegtsRemuxer.GenUniqueKey()
 }
 
+// GenUkHttpApiRequest 调用方没有通过header携带request id时，为http api请求生成的request id
+//
+func GenUkHttpApiRequest() string {
+	return ukPrefix + siUkHttpApiRequest.GenUniqueKey()
+}
+
+var ukPrefix string
+
 var (
 	siUkCustomizePubSession      *unique.SingleGenerator
+	siUkCustomizeSubSession      *unique.SingleGenerator
 	siUkRtmpServerSession        *unique.SingleGenerator
 	siUkRtmpPushSession          *unique.SingleGenerator
 	siUkRtmpPullSession          *unique.SingleGenerator
@@ -106,14 +152,20 @@ var (
 	siUkFlvSubSession            *unique.SingleGenerator
 	siUkTsSubSession             *unique.SingleGenerator
 	siUkFlvPullSession           *unique.SingleGenerator
+	siUkRistPubSession           *unique.SingleGenerator
+	siUkEsPubSession             *unique.SingleGenerator
+	siUkEsPushSession            *unique.SingleGenerator
 
 	siUkGroup              *unique.SingleGenerator
 	siUkHlsMuxer           *unique.SingleGenerator
 	siUkRtmp2MpegtsRemuxer *unique.SingleGenerator
+
+	siUkHttpApiRequest *unique.SingleGenerator
 )
 
 func init() {
 	siUkCustomizePubSession = unique.NewSingleGenerator(UkPreCustomizePubSessionContext)
+	siUkCustomizeSubSession = unique.NewSingleGenerator(UkPreCustomizeSubSessionContext)
 	siUkRtmpServerSession = unique.NewSingleGenerator(UkPreRtmpServerSession)
 	siUkRtmpPushSession = unique.NewSingleGenerator(UkPreRtmpPushSession)
 	siUkRtmpPullSession = unique.NewSingleGenerator(UkPreRtmpPullSession)
@@ -125,8 +177,13 @@ func init() {
 	siUkFlvSubSession = unique.NewSingleGenerator(UkPreFlvSubSession)
 	siUkTsSubSession = unique.NewSingleGenerator(UkPreTsSubSession)
 	siUkFlvPullSession = unique.NewSingleGenerator(UkPreFlvPullSession)
+	siUkRistPubSession = unique.NewSingleGenerator(UkPreRistPubSession)
+	siUkEsPubSession = unique.NewSingleGenerator(UkPreEsPubSession)
+	siUkEsPushSession = unique.NewSingleGenerator(UkPreEsPushSession)
 
 	siUkGroup = unique.NewSingleGenerator(UkPreGroup)
 	siUkHlsMuxer = unique.NewSingleGenerator(UkPreHlsMuxer)
 	siUkRtmp2MpegtsRemuxer = unique.NewSingleGenerator(UkPreRtmp2MpegtsRemuxer)
+
+	siUkHttpApiRequest = unique.NewSingleGenerator(UkPreHttpApiRequest)
 }
@@ -121,11 +121,12 @@ func ParseUrl(rawUrl string, defaultPort int) (ctx UrlContext, err error) {
 	if err != nil {
 		// url中端口不存在
 
-		ctx.Host = stdUrl.Host
+		// 注意，IPv6地址在url中带有中括号，比如`[::1]`，Host字段中不保留中括号，HostWithPort由JoinHostPort重新添加
+		ctx.Host = stdUrl.Hostname()
 		if defaultPort == -1 {
 			ctx.HostWithPort = stdUrl.Host
 		} else {
-			ctx.HostWithPort = net.JoinHostPort(stdUrl.Host, fmt.Sprintf("%d", defaultPort))
+			ctx.HostWithPort = net.JoinHostPort(ctx.Host, fmt.Sprintf("%d", defaultPort))
 			ctx.Port = defaultPort
 		}
 	} else {
@@ -208,6 +209,40 @@ func ParseHttpRequest(req *http.Request) string {
 	return fmt.Sprintf("%s://%s%s", scheme, req.Host, req.RequestURI)
 }
 
+// SplitRawQuery 将`test110?a=1&b=2`切分为`test110`和`a=1&b=2`
+//
+// 注意，只按第一个`?`切分，raw query中可以包含`?`
+//
+func SplitRawQuery(s string) (withoutRawQuery string, rawQuery string) {
+	pos := strings.Index(s, "?")
+	if pos == -1 {
+		return s, ""
+	}
+	return s[:pos], s[pos+1:]
+}
+
+// JoinRawQuery 使用`&`合并多个raw query，为空的忽略
+//
+func JoinRawQuery(rawQueries ...string) string {
+	var items []string
+	for _, q := range rawQueries {
+		if q != "" {
+			items = append(items, q)
+		}
+	}
+	return strings.Join(items, "&")
+}
+
+// AppendRawQuery 给`rawUrl`追加raw query。`rawUrl`中已经有raw query时，使用`&`连接
+//
+func AppendRawQuery(rawUrl string, rawQuery string) string {
+	if rawQuery == "" {
+		return rawUrl
+	}
+	withoutRawQuery, q := SplitRawQuery(rawUrl)
+	return withoutRawQuery + "?" + JoinRawQuery(q, rawQuery)
+}
+
 // ----- private -------------------------------------------------------------------------------------------------------
 
 func parseUrlPath(stdUrl *url.URL) (ctx UrlPathContext, err error) {
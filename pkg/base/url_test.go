@@ -20,8 +20,6 @@ type in struct {
 	defaultPort int
 }
 
-// TODO chef: 测试IPv6的case
-
 func TestParseUrl(t *testing.T) {
 	// 非法url
 	_, err := base.ParseUrl("invalidurl", -1)
@@ -178,6 +176,36 @@ func TestParseUrl(t *testing.T) {
 			RawQuery:              "",
 			RawUrlWithoutUserInfo: "rtmp://127.0.0.1:19350/live/",
 		},
+		// IPv6，url中无端口
+		{rawUrl: "rtmp://[::1]/live/test110", defaultPort: 1935}: {
+			Url:                   "rtmp://[::1]/live/test110",
+			Scheme:                "rtmp",
+			StdHost:               "[::1]",
+			HostWithPort:          "[::1]:1935",
+			Host:                  "::1",
+			Port:                  1935,
+			PathWithRawQuery:      "/live/test110",
+			Path:                  "/live/test110",
+			PathWithoutLastItem:   "live",
+			LastItemOfPath:        "test110",
+			RawQuery:              "",
+			RawUrlWithoutUserInfo: "rtmp://[::1]/live/test110",
+		},
+		// IPv6，url中有端口
+		{rawUrl: "rtsp://[fe80::1]:5544/live/test110?a=1", defaultPort: 554}: {
+			Url:                   "rtsp://[fe80::1]:5544/live/test110?a=1",
+			Scheme:                "rtsp",
+			StdHost:               "[fe80::1]:5544",
+			HostWithPort:          "[fe80::1]:5544",
+			Host:                  "fe80::1",
+			Port:                  5544,
+			PathWithRawQuery:      "/live/test110?a=1",
+			Path:                  "/live/test110",
+			PathWithoutLastItem:   "live",
+			LastItemOfPath:        "test110",
+			RawQuery:              "a=1",
+			RawUrlWithoutUserInfo: "rtsp://[fe80::1]:5544/live/test110?a=1",
+		},
 	}
 
 	for k, v := range golden {
@@ -238,3 +266,18 @@ func TestParseRtspUrl(t *testing.T) {
 		assert.Equal(t, v, ctx, k)
 	}
 }
+
+func TestRawQuery(t *testing.T) {
+	withoutRawQuery, rawQuery := base.SplitRawQuery("test110?a=1&b=2?c")
+	assert.Equal(t, "test110", withoutRawQuery)
+	assert.Equal(t, "a=1&b=2?c", rawQuery)
+	withoutRawQuery, rawQuery = base.SplitRawQuery("test110")
+	assert.Equal(t, "test110", withoutRawQuery)
+	assert.Equal(t, "", rawQuery)
+
+	assert.Equal(t, "a=1&b=2", base.JoinRawQuery("", "a=1", "", "b=2"))
+
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110", base.AppendRawQuery("rtmp://127.0.0.1/live/test110", ""))
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110?a=1", base.AppendRawQuery("rtmp://127.0.0.1/live/test110", "a=1"))
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110?a=1&b=2", base.AppendRawQuery("rtmp://127.0.0.1/live/test110?a=1", "b=2"))
+}
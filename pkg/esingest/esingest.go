@@ -0,0 +1,91 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package esingest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// ES ingest，基于tcp的极简音视频裸流推流协议，用于运行rtmp、rtsp协议栈比较困难的嵌入式设备
+//
+// 连接上的数据由连续的消息组成，每个消息包含12字节的消息头以及payload：
+//
+//   type      1字节，见 MsgTypeXxx
+//   flags     1字节，保留，填0
+//   reserved  2字节，保留，填0
+//   timestamp 4字节，大端，单位毫秒，音频和视频使用相同的时间基准
+//   length    4字节，大端，payload的长度
+//
+// 交互流程：
+// 1. 客户端连接成功后，首先发送 MsgTypeHello ，payload为1字节的协议版本号 Version ，以及流名称。
+//    流名称后可以携带`?`以及url参数，比如鉴权参数
+// 2. 服务端回复 MsgTypeHelloAck ，payload第一个字节为0表示成功，其他值表示失败，之后为失败原因。失败时服务端关闭连接
+// 3. 客户端持续发送音视频消息，服务端不再回复
+//
+// 音视频消息：
+// - MsgTypeAudioSpecificConfig 发送aac音频数据前发送一次
+// - MsgTypeAac  一帧aac，不包含adts头
+// - MsgTypeAvc  一帧h264，Annexb格式，关键帧需要携带sps、pps
+// - MsgTypeHevc 一帧h265，Annexb格式，关键帧需要携带vps、sps、pps
+//
+
+const (
+	MsgTypeHello               uint8 = 0x01
+	MsgTypeHelloAck            uint8 = 0x02
+	MsgTypeAudioSpecificConfig uint8 = 0x10
+	MsgTypeAac                 uint8 = 0x11
+	MsgTypeAvc                 uint8 = 0x20
+	MsgTypeHevc                uint8 = 0x21
+)
+
+const (
+	Version uint8 = 1
+
+	MsgHeaderSize = 12
+
+	// 单个消息payload的最大长度，超过时认为数据错误，关闭连接
+	MaxPayloadSize = 16 * 1024 * 1024
+)
+
+type Message struct {
+	Type      uint8
+	Timestamp uint32
+	Payload   []byte
+}
+
+// Pack 生成消息头以及payload
+func (msg *Message) Pack() []byte {
+	out := make([]byte, MsgHeaderSize+len(msg.Payload))
+	out[0] = msg.Type
+	bele.BePutUint32(out[4:], msg.Timestamp)
+	bele.BePutUint32(out[8:], uint32(len(msg.Payload)))
+	copy(out[MsgHeaderSize:], msg.Payload)
+	return out
+}
+
+// ReadMessage 读取一个完整的消息，返回的payload为新申请的内存块
+func ReadMessage(r io.Reader) (msg Message, err error) {
+	header := make([]byte, MsgHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	length := bele.BeUint32(header[8:])
+	if length > MaxPayloadSize {
+		return msg, fmt.Errorf("%w. payload too large. type=%d, length=%d", base.ErrEsIngest, header[0], length)
+	}
+	msg.Type = header[0]
+	msg.Timestamp = bele.BeUint32(header[4:])
+	msg.Payload = make([]byte, length)
+	_, err = io.ReadFull(r, msg.Payload)
+	return
+}
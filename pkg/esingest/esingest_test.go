@@ -0,0 +1,113 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package esingest
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testStream struct {
+	mutex   sync.Mutex
+	option  base.AvPacketStreamOption
+	asc     []byte
+	packets []base.AvPacket
+}
+
+func (t *testStream) WithOption(modOption func(option *base.AvPacketStreamOption)) {
+	modOption(&t.option)
+}
+
+func (t *testStream) FeedAudioSpecificConfig(asc []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.asc = asc
+}
+
+func (t *testStream) FeedAvPacket(packet base.AvPacket) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.packets = append(t.packets, packet)
+}
+
+type testObserver struct {
+	stream  *testStream
+	delChan chan *PubSession
+}
+
+func (o *testObserver) OnNewEsPubSession(session *PubSession) error {
+	if session.RawQuery() != "secret=123" {
+		return errors.New("auth failed")
+	}
+	session.WithAvPacketStream(o.stream)
+	return nil
+}
+
+func (o *testObserver) OnDelEsPubSession(session *PubSession) {
+	o.delChan <- session
+}
+
+func TestMessage(t *testing.T) {
+	msg := Message{Type: MsgTypeAvc, Timestamp: 0x01020304, Payload: []byte{0, 0, 0, 1, 0x65}}
+	b := msg.Pack()
+	assert.Equal(t, []byte{0x20, 0, 0, 0, 1, 2, 3, 4, 0, 0, 0, 5, 0, 0, 0, 1, 0x65}, b)
+	out, err := ReadMessage(bytes.NewReader(b))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msg, out)
+
+	_, err = ReadMessage(bytes.NewReader([]byte{0x20, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0, 0, 0}))
+	assert.Equal(t, true, errors.Is(err, base.ErrEsIngest))
+}
+
+func TestPushSession(t *testing.T) {
+	observer := &testObserver{stream: &testStream{}, delChan: make(chan *PubSession, 1)}
+	server := NewServer("127.0.0.1:19460", observer)
+	assert.Equal(t, nil, server.Listen())
+	go server.RunLoop()
+	defer server.Dispose()
+
+	// 鉴权失败
+	ps := NewPushSession()
+	err := ps.Push("127.0.0.1:19460", "test110")
+	assert.Equal(t, true, errors.Is(err, base.ErrEsIngestRejected))
+	assert.Equal(t, base.ErrSessionNotStarted, ps.WriteAvc(0, []byte{0, 0, 0, 1, 0x65}))
+
+	ps = NewPushSession()
+	assert.Equal(t, nil, ps.Push("127.0.0.1:19460", "test110?secret=123"))
+	assert.Equal(t, nil, ps.WriteAudioSpecificConfig([]byte{0x12, 0x10}))
+	assert.Equal(t, nil, ps.WriteAac(23, []byte{0x21, 0x0A}))
+	assert.Equal(t, nil, ps.WriteAvc(40, []byte{0, 0, 0, 1, 0x65, 0x88}))
+	assert.Equal(t, nil, ps.Dispose())
+
+	var session *PubSession
+	select {
+	case session = <-observer.delChan:
+	case <-time.After(time.Second):
+		t.Fatal("wait del session timeout")
+	}
+	assert.Equal(t, "test110", session.StreamName())
+	assert.Equal(t, base.ProtocolEs, session.GetStat().Protocol)
+
+	s := observer.stream
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	assert.Equal(t, base.AvPacketStreamVideoFormatAnnexb, s.option.VideoFormat)
+	assert.Equal(t, []byte{0x12, 0x10}, s.asc)
+	assert.Equal(t, 2, len(s.packets))
+	assert.Equal(t, base.AvPacketPtAac, s.packets[0].PayloadType)
+	assert.Equal(t, int64(23), s.packets[0].Timestamp)
+	assert.Equal(t, base.AvPacketPtAvc, s.packets[1].PayloadType)
+	assert.Equal(t, []byte{0, 0, 0, 1, 0x65, 0x88}, s.packets[1].Payload)
+}
@@ -0,0 +1,178 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package esingest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 客户端连接后，需要在该时长内发送hello
+const helloTimeout = 10 * time.Second
+
+// PubSession 服务端的一路ES输入流
+type PubSession struct {
+	uniqueKey  string
+	conn       net.Conn
+	startTime  string
+	streamName string
+	rawQuery   string
+
+	stream base.IAvPacketStream
+
+	readBytes   uint64 // 注意，原子操作
+	disposeOnce sync.Once
+}
+
+func NewPubSession(conn net.Conn) *PubSession {
+	s := &PubSession{
+		uniqueKey: base.GenUkEsPubSession(),
+		conn:      conn,
+		startTime: base.ReadableNowTime(),
+	}
+	Log.Infof("[%s] lifecycle new es ingest PubSession. session=%p, remote addr=%s", s.uniqueKey, s, conn.RemoteAddr().String())
+	return s
+}
+
+// WithAvPacketStream 设置音视频数据的接收方，需要在 IServerObserver.OnNewEsPubSession 回调中调用
+//
+func (s *PubSession) WithAvPacketStream(stream base.IAvPacketStream) {
+	s.stream = stream
+	stream.WithOption(func(option *base.AvPacketStreamOption) {
+		option.VideoFormat = base.AvPacketStreamVideoFormatAnnexb
+	})
+}
+
+// RunLoop 读取音视频消息，直到连接断开或出错
+//
+func (s *PubSession) RunLoop() error {
+	r := bufio.NewReader(countReader{s})
+	for {
+		msg, err := ReadMessage(r)
+		if err != nil {
+			Log.Infof("[%s] es ingest read done. err=%+v", s.uniqueKey, err)
+			return err
+		}
+		s.onMessage(msg)
+	}
+}
+
+func (s *PubSession) Dispose() {
+	s.disposeOnce.Do(func() {
+		Log.Infof("[%s] lifecycle dispose es ingest PubSession.", s.uniqueKey)
+		_ = s.conn.Close()
+	})
+}
+
+func (s *PubSession) UniqueKey() string {
+	return s.uniqueKey
+}
+
+func (s *PubSession) StreamName() string {
+	return s.streamName
+}
+
+func (s *PubSession) RawQuery() string {
+	return s.rawQuery
+}
+
+func (s *PubSession) SetStreamName(streamName string) {
+	s.streamName = streamName
+}
+
+func (s *PubSession) GetStat() base.StatSession {
+	return base.StatSession{
+		Protocol:     base.ProtocolEs,
+		SessionId:    s.uniqueKey,
+		RemoteAddr:   s.conn.RemoteAddr().String(),
+		StartTime:    s.startTime,
+		ReadBytesSum: atomic.LoadUint64(&s.readBytes),
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (s *PubSession) handshake(observer IServerObserver) error {
+	_ = s.conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	msg, err := ReadMessage(s.conn)
+	if err != nil {
+		return err
+	}
+	_ = s.conn.SetReadDeadline(time.Time{})
+	if msg.Type != MsgTypeHello || len(msg.Payload) < 2 {
+		return fmt.Errorf("%w. invalid hello. type=%d, len=%d", base.ErrEsIngest, msg.Type, len(msg.Payload))
+	}
+	if msg.Payload[0] != Version {
+		err = fmt.Errorf("%w. version not support. version=%d", base.ErrEsIngest, msg.Payload[0])
+		s.writeHelloAck(err)
+		return err
+	}
+	s.streamName, s.rawQuery = base.SplitRawQuery(string(msg.Payload[1:]))
+	Log.Infof("[%s] es ingest hello. stream=%s, query=%s", s.uniqueKey, s.streamName, s.rawQuery)
+
+	err = observer.OnNewEsPubSession(s)
+	if err == nil && s.stream == nil {
+		err = fmt.Errorf("%w. av packet stream not set", base.ErrEsIngest)
+	}
+	s.writeHelloAck(err)
+	return err
+}
+
+func (s *PubSession) writeHelloAck(err error) {
+	payload := []byte{0}
+	if err != nil {
+		payload = append([]byte{1}, err.Error()...)
+	}
+	msg := Message{Type: MsgTypeHelloAck, Payload: payload}
+	if _, werr := s.conn.Write(msg.Pack()); werr != nil {
+		Log.Warnf("[%s] write hello ack failed. err=%+v", s.uniqueKey, werr)
+	}
+}
+
+func (s *PubSession) onMessage(msg Message) {
+	switch msg.Type {
+	case MsgTypeAudioSpecificConfig:
+		s.stream.FeedAudioSpecificConfig(msg.Payload)
+	case MsgTypeAac:
+		s.feed(base.AvPacketPtAac, msg)
+	case MsgTypeAvc:
+		s.feed(base.AvPacketPtAvc, msg)
+	case MsgTypeHevc:
+		s.feed(base.AvPacketPtHevc, msg)
+	default:
+		Log.Warnf("[%s] unknown es ingest msg type. type=%d", s.uniqueKey, msg.Type)
+	}
+}
+
+func (s *PubSession) feed(pt base.AvPacketPt, msg Message) {
+	if len(msg.Payload) == 0 {
+		return
+	}
+	s.stream.FeedAvPacket(base.AvPacket{
+		PayloadType: pt,
+		Timestamp:   int64(msg.Timestamp),
+		Payload:     msg.Payload,
+	})
+}
+
+type countReader struct {
+	s *PubSession
+}
+
+func (r countReader) Read(p []byte) (int, error) {
+	n, err := r.s.conn.Read(p)
+	atomic.AddUint64(&r.s.readBytes, uint64(n))
+	return n, err
+}
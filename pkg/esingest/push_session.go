@@ -0,0 +1,153 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package esingest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+type PushSessionOption struct {
+	// 从调用Push函数，到收到服务端hello ack的超时时间，如果为0，则没有超时时间
+	PushTimeoutMs int
+
+	// 写音视频消息的超时时间，如果为0，则没有超时时间
+	WriteTimeoutMs int
+}
+
+var defaultPushSessionOption = PushSessionOption{
+	PushTimeoutMs:  10000,
+	WriteTimeoutMs: 0,
+}
+
+type ModPushSessionOption func(option *PushSessionOption)
+
+// PushSession ES ingest协议的客户端，向服务端推送音视频裸流
+//
+// 所有Write函数都可以在多个协程中调用
+//
+type PushSession struct {
+	uniqueKey string
+	option    PushSessionOption
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
+	option := defaultPushSessionOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+	s := &PushSession{
+		uniqueKey: base.GenUkEsPushSession(),
+		option:    option,
+	}
+	Log.Infof("[%s] lifecycle new es ingest PushSession. session=%p", s.uniqueKey, s)
+	return s
+}
+
+// Push 连接服务端并完成握手，阻塞直到收到服务端的hello ack
+//
+// @param streamName: 流名称，可以携带`?`以及url参数，比如`test110?lal_secret=xxx`
+//
+func (s *PushSession) Push(addr string, streamName string) (err error) {
+	timeout := time.Duration(s.option.PushTimeoutMs) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	hello := Message{Type: MsgTypeHello, Payload: append([]byte{Version}, streamName...)}
+	if _, err = conn.Write(hello.Pack()); err != nil {
+		return err
+	}
+	ack, err := ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	if ack.Type != MsgTypeHelloAck || len(ack.Payload) == 0 {
+		return fmt.Errorf("%w. invalid hello ack. type=%d", base.ErrEsIngest, ack.Type)
+	}
+	if ack.Payload[0] != 0 {
+		return fmt.Errorf("%w. reason=%s", base.ErrEsIngestRejected, string(ack.Payload[1:]))
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	s.mutex.Lock()
+	s.conn = conn
+	s.mutex.Unlock()
+	Log.Infof("[%s] es ingest push succ. addr=%s, stream=%s", s.uniqueKey, addr, streamName)
+	return nil
+}
+
+func (s *PushSession) WriteAudioSpecificConfig(asc []byte) error {
+	return s.write(Message{Type: MsgTypeAudioSpecificConfig, Payload: asc})
+}
+
+// WriteAac @param frame: 一帧aac，不包含adts头
+//
+func (s *PushSession) WriteAac(timestamp uint32, frame []byte) error {
+	return s.write(Message{Type: MsgTypeAac, Timestamp: timestamp, Payload: frame})
+}
+
+// WriteAvc @param frame: 一帧h264，Annexb格式
+//
+func (s *PushSession) WriteAvc(timestamp uint32, frame []byte) error {
+	return s.write(Message{Type: MsgTypeAvc, Timestamp: timestamp, Payload: frame})
+}
+
+// WriteHevc @param frame: 一帧h265，Annexb格式
+//
+func (s *PushSession) WriteHevc(timestamp uint32, frame []byte) error {
+	return s.write(Message{Type: MsgTypeHevc, Timestamp: timestamp, Payload: frame})
+}
+
+func (s *PushSession) Dispose() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	Log.Infof("[%s] lifecycle dispose es ingest PushSession.", s.uniqueKey)
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *PushSession) UniqueKey() string {
+	return s.uniqueKey
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (s *PushSession) write(msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return base.ErrSessionNotStarted
+	}
+	if s.option.WriteTimeoutMs > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.option.WriteTimeoutMs) * time.Millisecond))
+	}
+	_, err := s.conn.Write(msg.Pack())
+	return err
+}
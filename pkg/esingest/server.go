@@ -0,0 +1,81 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package esingest
+
+import (
+	"net"
+)
+
+type IServerObserver interface {
+	// OnNewEsPubSession
+	//
+	// 上层代码应该在这个事件回调中调用 PubSession.WithAvPacketStream 注册音视频数据的接收方
+	//
+	// @return 上层如果想关闭这个session，则回调中返回不为nil的error值，error信息会通过hello ack返回给客户端
+	//
+	OnNewEsPubSession(session *PubSession) error
+
+	// OnDelEsPubSession
+	//
+	// 注意，如果session是上层通过 OnNewEsPubSession 回调的返回值关闭的，则该session不再触发这个逻辑
+	//
+	OnDelEsPubSession(session *PubSession)
+}
+
+type Server struct {
+	addr     string
+	observer IServerObserver
+	ln       net.Listener
+}
+
+func NewServer(addr string, observer IServerObserver) *Server {
+	return &Server{
+		addr:     addr,
+		observer: observer,
+	}
+}
+
+func (server *Server) Listen() (err error) {
+	if server.ln, err = net.Listen("tcp", server.addr); err != nil {
+		return
+	}
+	Log.Infof("start es ingest server listen. addr=%s", server.addr)
+	return
+}
+
+func (server *Server) RunLoop() error {
+	for {
+		conn, err := server.ln.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handleTcpConnect(conn)
+	}
+}
+
+func (server *Server) Dispose() {
+	if server.ln == nil {
+		return
+	}
+	if err := server.ln.Close(); err != nil {
+		Log.Error(err)
+	}
+}
+
+func (server *Server) handleTcpConnect(conn net.Conn) {
+	Log.Infof("accept a es ingest connection. remoteAddr=%s", conn.RemoteAddr().String())
+	session := NewPubSession(conn)
+	if err := session.handshake(server.observer); err != nil {
+		Log.Warnf("[%s] es ingest handshake failed. err=%+v", session.UniqueKey(), err)
+		session.Dispose()
+		return
+	}
+	_ = session.RunLoop()
+	server.observer.OnDelEsPubSession(session)
+}
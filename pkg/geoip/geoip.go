@@ -0,0 +1,54 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package geoip
+
+import (
+	"net"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// LookupGeo 查询ip的地理位置以及所属的自治系统
+//
+// 支持GeoLite2-Country、GeoLite2-City、GeoLite2-ASN以及同格式的商业版数据库，数据库中不存在的字段为空
+//
+// @return found: 数据库中是否有该ip的记录
+//
+func (r *Reader) LookupGeo(ip net.IP) (geo base.StatGeo, found bool, err error) {
+	m, err := r.Lookup(ip)
+	if err != nil || m == nil {
+		return geo, false, err
+	}
+
+	if country, ok := m["country"].(map[string]interface{}); ok {
+		geo.Country, _ = country["iso_code"].(string)
+	}
+	if subdivisions, ok := m["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if region, ok := subdivisions[0].(map[string]interface{}); ok {
+			geo.Region = name(region)
+		}
+	}
+	if city, ok := m["city"].(map[string]interface{}); ok {
+		geo.City = name(city)
+	}
+	geo.Asn = uint32(toUint64(m["autonomous_system_number"]))
+	geo.AsnOrg, _ = m["autonomous_system_organization"].(string)
+	return geo, true, nil
+}
+
+// name 英文名称，没有时使用iso_code
+func name(m map[string]interface{}) string {
+	if names, ok := m["names"].(map[string]interface{}); ok {
+		if en, ok := names["en"].(string); ok {
+			return en
+		}
+	}
+	code, _ := m["iso_code"].(string)
+	return code
+}
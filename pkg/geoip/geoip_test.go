@@ -0,0 +1,194 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package geoip
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// ----- 测试用的mmdb编码 ------------------------------------------------------------------------------------------------
+
+type testUint16 uint16
+type testPointer int
+
+// testCtrl 注意，只支持小于285的大小
+func testCtrl(typ int, size int) (ret []byte) {
+	var extra []byte
+	if size >= 29 {
+		extra = []byte{byte(size - 29)}
+		size = 29
+	}
+	if typ > 7 {
+		ret = []byte{byte(size), byte(typ - 7)}
+	} else {
+		ret = []byte{byte(typ<<5 | size)}
+	}
+	return append(ret, extra...)
+}
+
+func testEncode(v interface{}) (ret []byte) {
+	switch val := v.(type) {
+	case string:
+		return append(testCtrl(typeString, len(val)), val...)
+	case testUint16:
+		return append(testCtrl(typeUint16, 2), byte(val>>8), byte(val))
+	case uint32:
+		return append(testCtrl(typeUint32, 4), byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+	case testPointer:
+		return []byte{byte(typePointer<<5 | (int(val)>>8)&0x7), byte(val)}
+	case []interface{}:
+		ret = testCtrl(typeArray, len(val))
+		for _, item := range val {
+			ret = append(ret, testEncode(item)...)
+		}
+		return ret
+	case map[string]interface{}:
+		var keys []string
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		ret = testCtrl(typeMap, len(val))
+		for _, k := range keys {
+			ret = append(ret, testEncode(k)...)
+			ret = append(ret, testEncode(val[k])...)
+		}
+		return ret
+	}
+	panic(v)
+}
+
+type testNode struct {
+	child [2]*testNode
+	data  [2]int // 数据段中的位置，-1表示没有数据
+}
+
+func newTestNode() *testNode {
+	return &testNode{data: [2]int{-1, -1}}
+}
+
+// testBuildDb 构造ipv4、record size为24的数据库
+func testBuildDb(networks map[string]interface{}) []byte {
+	str := "GOOGLE"
+	data := testEncode(str) // 用于测试指针
+
+	root := newTestNode()
+	for cidr, v := range networks {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ones, _ := ipNet.Mask.Size()
+		ip := ipNet.IP.To4()
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				node.data[bit] = len(data)
+				break
+			}
+			if node.child[bit] == nil {
+				node.child[bit] = newTestNode()
+			}
+			node = node.child[bit]
+		}
+		data = append(data, testEncode(v)...)
+	}
+
+	var nodes []*testNode
+	index := make(map[*testNode]int)
+	queue := []*testNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		index[node] = len(nodes)
+		nodes = append(nodes, node)
+		for _, c := range node.child {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	var buf []byte
+	for _, node := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount
+			if node.child[bit] != nil {
+				record = index[node.child[bit]]
+			} else if node.data[bit] != -1 {
+				record = nodeCount + dataSectionSeparatorSize + node.data[bit]
+			}
+			buf = append(buf, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparatorSize)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataStartMarker...)
+	buf = append(buf, testEncode(map[string]interface{}{
+		"database_type": "Test",
+		"ip_version":    testUint16(4),
+		"node_count":    uint32(nodeCount),
+		"record_size":   testUint16(24),
+	})...)
+	return buf
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func TestReader(t *testing.T) {
+	b := testBuildDb(map[string]interface{}{
+		"1.2.3.0/24": map[string]interface{}{
+			"country":      map[string]interface{}{"iso_code": "CN"},
+			"subdivisions": []interface{}{map[string]interface{}{"iso_code": "ZJ", "names": map[string]interface{}{"en": "Zhejiang"}}},
+			"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Hangzhou"}},
+		},
+		"8.8.0.0/16": map[string]interface{}{
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": testPointer(0),
+		},
+	})
+	r, err := NewReader(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Test", r.Metadata.DatabaseType)
+	assert.Equal(t, uint(4), r.Metadata.IpVersion)
+
+	geo, found, err := r.LookupGeo(net.ParseIP("1.2.3.4"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, base.StatGeo{Country: "CN", Region: "Zhejiang", City: "Hangzhou"}, geo)
+
+	geo, found, err = r.LookupGeo(net.ParseIP("8.8.8.8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, found)
+	assert.Equal(t, base.StatGeo{Asn: 15169, AsnOrg: "GOOGLE"}, geo)
+
+	_, found, err = r.LookupGeo(net.ParseIP("1.2.4.1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, found)
+	_, found, err = r.LookupGeo(net.ParseIP("::1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, found)
+
+	_, err = NewReader([]byte("not a mmdb file"))
+	assert.Equal(t, true, errors.Is(err, base.ErrGeoIp))
+
+	// 记录指向数据区之前的分隔符
+	nodeCount := int(r.Metadata.NodeCount)
+	record := nodeCount + 1
+	b[0], b[1], b[2] = byte(record>>16), byte(record>>8), byte(record)
+	r, err = NewReader(b)
+	assert.Equal(t, nil, err)
+	_, _, err = r.LookupGeo(net.ParseIP("1.2.3.4"))
+	assert.Equal(t, true, errors.Is(err, base.ErrGeoIp))
+}
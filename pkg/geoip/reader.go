@@ -0,0 +1,362 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// Reader 读取MaxMind DB（.mmdb）格式的数据库，比如GeoLite2-Country、GeoLite2-City、GeoLite2-ASN
+//
+// 格式见 https://maxmind.github.io/MaxMind-DB/
+//
+// 打开时将整个文件读入内存，之后的查询并发安全
+//
+type Reader struct {
+	buf  []byte
+	data []byte // 数据段
+
+	Metadata Metadata
+
+	nodeByteSize int
+	ipv4Start    uint // ipv6数据库中，ipv4地址（::/96）对应的节点
+}
+
+type Metadata struct {
+	DatabaseType string
+	IpVersion    uint
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// 数据段和搜索树之间有16字节的0
+const dataSectionSeparatorSize = 16
+
+// 数据段中的类型
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+func Open(filename string) (*Reader, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(b)
+}
+
+func NewReader(b []byte) (*Reader, error) {
+	pos := bytes.LastIndex(b, metadataStartMarker)
+	if pos == -1 {
+		return nil, fmt.Errorf("%w. metadata marker not found", base.ErrGeoIp)
+	}
+	metaBuf := b[pos+len(metadataStartMarker):]
+	v, _, err := (&decoder{buf: metaBuf}).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w. metadata invalid", base.ErrGeoIp)
+	}
+
+	r := &Reader{buf: b}
+	r.Metadata.DatabaseType, _ = m["database_type"].(string)
+	r.Metadata.IpVersion = uint(toUint64(m["ip_version"]))
+	r.Metadata.NodeCount = uint(toUint64(m["node_count"]))
+	r.Metadata.RecordSize = uint(toUint64(m["record_size"]))
+	r.Metadata.BuildEpoch = toUint64(m["build_epoch"])
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w. record size not support. size=%d", base.ErrGeoIp, r.Metadata.RecordSize)
+	}
+	r.nodeByteSize = int(r.Metadata.RecordSize) / 4
+	treeSize := int(r.Metadata.NodeCount) * r.nodeByteSize
+	if treeSize+dataSectionSeparatorSize > pos {
+		return nil, fmt.Errorf("%w. search tree size invalid. node count=%d", base.ErrGeoIp, r.Metadata.NodeCount)
+	}
+	r.data = b[treeSize+dataSectionSeparatorSize : pos]
+
+	if r.Metadata.IpVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup 返回ip对应的数据，没有找到时返回nil
+//
+func (r *Reader) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node, bitCount := uint(0), 0
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if r.Metadata.IpVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if ip = ip.To16(); ip == nil {
+		return nil, fmt.Errorf("%w. ip invalid", base.ErrGeoIp)
+	} else if r.Metadata.IpVersion == 4 {
+		return nil, nil
+	}
+	bitCount = len(ip) * 8
+
+	nodeCount := r.Metadata.NodeCount
+	for i := 0; i < bitCount && node < nodeCount; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == nodeCount {
+		return nil, nil
+	}
+	if node < nodeCount+dataSectionSeparatorSize {
+		// 指向search tree或者数据区之前的分隔符，说明数据库损坏
+		return nil, fmt.Errorf("%w. search tree invalid. node=%d", base.ErrGeoIp, node)
+	}
+
+	offset := node - nodeCount - dataSectionSeparatorSize
+	v, _, err := (&decoder{buf: r.data}).decode(int(offset), 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w. record type invalid. type=%T", base.ErrGeoIp, v)
+	}
+	return m, nil
+}
+
+func (r *Reader) readNode(node uint, bit byte) uint {
+	b := r.buf[int(node)*r.nodeByteSize:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		if bit == 0 {
+			return uint(binary.BigEndian.Uint32(b))
+		}
+		return uint(binary.BigEndian.Uint32(b[4:]))
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// map嵌套的最大深度，避免恶意构造的数据库导致栈溢出
+const maxDecodeDepth = 32
+
+type decoder struct {
+	buf []byte
+}
+
+// decode 解码`offset`位置的字段
+//
+// @return next: 字段之后的位置
+//
+func (d *decoder) decode(offset int, depth int) (v interface{}, next int, err error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w. data nested too deep", base.ErrGeoIp)
+	}
+	typ, size, offset, err := d.decodeCtrl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err = d.decode(pointer, depth+1)
+		return v, next, err
+	}
+
+	if typ != typeMap && typ != typeArray && offset+size > len(d.buf) {
+		return nil, 0, fmt.Errorf("%w. data too short", base.ErrGeoIp)
+	}
+	b := d.buf[offset:]
+	switch typ {
+	case typeString:
+		return string(b[:size]), offset + size, nil
+	case typeBytes:
+		return append([]byte(nil), b[:size]...), offset + size, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w. double size invalid. size=%d", base.ErrGeoIp, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset + size, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w. float size invalid. size=%d", base.ErrGeoIp, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset + size, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w. uint size invalid. size=%d", base.ErrGeoIp, size)
+		}
+		var u uint64
+		for i := 0; i < size; i++ {
+			u = u<<8 | uint64(b[i])
+		}
+		if typ == typeInt32 {
+			return int32(u), offset + size, nil
+		}
+		return u, offset + size, nil
+	case typeUint128:
+		// 忽略高位，业务上用不到
+		var u uint64
+		for i := 0; i < size; i++ {
+			u = u<<8 | uint64(b[i])
+		}
+		return u, offset + size, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			var k, val interface{}
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w. map key type invalid. type=%T", base.ErrGeoIp, k)
+			}
+			if val, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = val
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			var val interface{}
+			if val, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, val)
+		}
+		return a, offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w. data type not support. type=%d", base.ErrGeoIp, typ)
+}
+
+// decodeCtrl 解析控制字节，返回类型、大小以及数据的起始位置
+//
+func (d *decoder) decodeCtrl(offset int) (typ int, size int, next int, err error) {
+	if offset >= len(d.buf) {
+		return 0, 0, 0, fmt.Errorf("%w. data offset invalid. offset=%d", base.ErrGeoIp, offset)
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ = int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, int(ctrl & 0x1F), offset, nil
+	}
+	if typ == typeExtended {
+		if offset >= len(d.buf) {
+			return 0, 0, 0, fmt.Errorf("%w. data too short", base.ErrGeoIp)
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size = int(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return 0, 0, 0, fmt.Errorf("%w. data too short", base.ErrGeoIp)
+		}
+		var v int
+		for i := 0; i < n; i++ {
+			v = v<<8 | int(d.buf[offset+i])
+		}
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+// decodePointer
+//
+// @param ctrl: 控制字节的低5位
+//
+func (d *decoder) decodePointer(ctrl int, offset int) (pointer int, next int, err error) {
+	n := (ctrl>>3)&0x3 + 1
+	if offset+n > len(d.buf) {
+		return 0, 0, fmt.Errorf("%w. data too short", base.ErrGeoIp)
+	}
+	var v int
+	if n != 4 {
+		v = ctrl & 0x7
+	}
+	for i := 0; i < n; i++ {
+		v = v<<8 | int(d.buf[offset+i])
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func toUint64(v interface{}) uint64 {
+	switch u := v.(type) {
+	case uint64:
+		return u
+	case int32:
+		return uint64(u)
+	}
+	return 0
+}
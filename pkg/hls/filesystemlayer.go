@@ -11,12 +11,15 @@ package hls
 import (
 	"sync"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/filesystemlayer"
 )
 
 var (
 	fslCtx  filesystemlayer.IFileSystemLayer
 	setOnce sync.Once
+
+	fileKeyring *base.FileKeyring
 )
 
 func SetUseMemoryAsDiskFlag(flag bool) {
@@ -33,8 +36,22 @@ func SetUseMemoryAsDiskFlag(flag bool) {
 	})
 }
 
+// SetFileKeyring 设置后，ts切片加密写入，ReadFile 读取时解密。m3u8不加密
+//
+// 注意，需要在创建 Muxer 之前调用
+//
+func SetFileKeyring(keyring *base.FileKeyring) {
+	fileKeyring = keyring
+}
+
+// ReadFile 读取m3u8或ts文件，加密的ts切片返回解密后的内容
+//
 func ReadFile(filename string) ([]byte, error) {
-	return fslCtx.ReadFile(filename)
+	content, err := fslCtx.ReadFile(filename)
+	if err != nil || !base.IsEncryptedFile(content) {
+		return content, err
+	}
+	return fileKeyring.DecryptBytes(content)
 }
 
 func RemoveAll(path string) error {
@@ -17,10 +17,22 @@ type Fragment struct {
 }
 
 func (f *Fragment) OpenFile(filename string) (err error) {
+	return f.OpenFileOfStream(filename, "")
+}
+
+// OpenFileOfStream 设置了 SetFileKeyring 时，使用流`streamName`的密钥加密写入
+//
+func (f *Fragment) OpenFileOfStream(filename string, streamName string) (err error) {
 	f.fp, err = fslCtx.Create(filename)
 	if err != nil {
 		return
 	}
+	if fileKeyring != nil {
+		fp := f.fp
+		if f.fp, err = fileKeyring.NewWriter(fp, streamName); err != nil {
+			_ = fp.Close()
+		}
+	}
 	return
 }
 
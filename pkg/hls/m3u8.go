@@ -11,7 +11,10 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/nazaerrors"
@@ -59,6 +62,54 @@ func updateTargetDurationInM3u8(content []byte, currDuration int) ([]byte, error
 	return content, nil
 }
 
+// parseLiveM3u8 解析由 Muxer 生成的直播m3u8文件，用于恢复切片状态
+//
+// @return mediaSeq `#EXT-X-MEDIA-SEQUENCE`的值
+// @return frags    列表中的ts信息，id从mediaSeq开始递增
+//
+func parseLiveM3u8(content []byte) (mediaSeq int, frags []fragmentInfo, err error) {
+	var (
+		hasSeq bool
+		hasInf bool
+		curr   fragmentInfo
+	)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if mediaSeq, err = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")); err != nil {
+				return
+			}
+			hasSeq = true
+		case line == "#EXT-X-DISCONTINUITY":
+			curr.discont = true
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			curr.programDateTime, _ = time.Parse(programDateTimeLayout, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := strings.TrimSuffix(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if curr.duration, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return
+			}
+			hasInf = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if !hasInf {
+				return mediaSeq, nil, nazaerrors.Wrap(base.ErrHls)
+			}
+			curr.filename = line
+			curr.id = mediaSeq + len(frags)
+			frags = append(frags, curr)
+			curr = fragmentInfo{}
+			hasInf = false
+		}
+	}
+	if !hasSeq || len(frags) == 0 {
+		return mediaSeq, nil, nazaerrors.Wrap(base.ErrHls)
+	}
+	return
+}
+
 // CalcM3u8Duration @param content 传入m3u8文件内容
 //
 // @return durationSec m3u8中所有ts的时间总和。注意，使用的是m3u8文件中描述的ts时间，而不是读取ts文件中实际音视频数据的时间。
@@ -79,3 +130,156 @@ func CalcM3u8Duration(content []byte) (durationSec float64, err error) {
 	}
 	return
 }
+
+// VariantFilter 过滤master m3u8中的variant，字段为0表示不限制
+//
+type VariantFilter struct {
+	MaxWidth     int
+	MaxHeight    int
+	MaxBandwidth int
+}
+
+// ParseVariantFilter 从url参数中解析过滤条件，比如`?max_height=480&max_bandwidth=1000000`
+//
+// @return exist 是否携带了过滤条件
+//
+func ParseVariantFilter(rawQuery string) (filter VariantFilter, exist bool) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return
+	}
+	parse := func(key string, dst *int) {
+		if v, err := strconv.Atoi(values.Get(key)); err == nil && v > 0 {
+			*dst = v
+			exist = true
+		}
+	}
+	parse("max_width", &filter.MaxWidth)
+	parse("max_height", &filter.MaxHeight)
+	parse("max_bandwidth", &filter.MaxBandwidth)
+	return
+}
+
+// IsMasterM3u8 是否是包含多个variant的master m3u8
+//
+func IsMasterM3u8(content []byte) bool {
+	return bytes.Contains(content, []byte("#EXT-X-STREAM-INF:"))
+}
+
+// FilterMasterM3u8 按`filter`过滤master m3u8中的variant（`#EXT-X-STREAM-INF`以及紧跟的uri行）
+//
+// 注意，如果所有variant都不满足条件，则保留带宽最小的那个，保证客户端依然可以播放
+//
+// @return 处理后的m3u8文件内容
+//
+func FilterMasterM3u8(content []byte, filter VariantFilter) []byte {
+	lines := bytes.Split(content, []byte{'\n'})
+
+	type variant struct {
+		infIdx    int // #EXT-X-STREAM-INF所在行
+		uriIdx    int // uri所在行
+		bandwidth int
+		match     bool
+	}
+	var variants []variant
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if !bytes.HasPrefix(line, []byte("#EXT-X-STREAM-INF:")) {
+			continue
+		}
+		v := variant{infIdx: i, uriIdx: -1}
+		attrs := parseM3u8Attributes(string(bytes.TrimPrefix(line, []byte("#EXT-X-STREAM-INF:"))))
+		v.bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+		var width, height int
+		if wh := strings.Split(attrs["RESOLUTION"], "x"); len(wh) == 2 {
+			width, _ = strconv.Atoi(wh[0])
+			height, _ = strconv.Atoi(wh[1])
+		}
+		v.match = (filter.MaxWidth == 0 || width == 0 || width <= filter.MaxWidth) &&
+			(filter.MaxHeight == 0 || height == 0 || height <= filter.MaxHeight) &&
+			(filter.MaxBandwidth == 0 || v.bandwidth == 0 || v.bandwidth <= filter.MaxBandwidth)
+
+		// uri是下一个非空且不以#开头的行
+		for j := i + 1; j < len(lines); j++ {
+			l := bytes.TrimSpace(lines[j])
+			if len(l) != 0 && l[0] != '#' {
+				v.uriIdx = j
+				i = j
+				break
+			}
+		}
+		variants = append(variants, v)
+	}
+
+	anyMatch := false
+	lowest := -1
+	for i := range variants {
+		if variants[i].match {
+			anyMatch = true
+		}
+		if lowest == -1 || variants[i].bandwidth < variants[lowest].bandwidth {
+			lowest = i
+		}
+	}
+	if !anyMatch && lowest != -1 {
+		variants[lowest].match = true
+	}
+
+	drop := make(map[int]bool)
+	for _, v := range variants {
+		if v.match {
+			continue
+		}
+		drop[v.infIdx] = true
+		if v.uriIdx != -1 {
+			drop[v.uriIdx] = true
+		}
+	}
+	if len(drop) == 0 {
+		return content
+	}
+
+	out := make([][]byte, 0, len(lines))
+	for i, line := range lines {
+		if !drop[i] {
+			out = append(out, line)
+		}
+	}
+	return bytes.Join(out, []byte{'\n'})
+}
+
+// 解析m3u8 tag的属性列表，比如`BANDWIDTH=1280000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2"`
+//
+// 注意，属性值如果有引号，返回的值中会去掉引号
+//
+func parseM3u8Attributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			comma := strings.IndexByte(s, ',')
+			if comma == -1 {
+				value, s = s, ""
+			} else {
+				value, s = s[:comma], s[comma:]
+			}
+		}
+		attrs[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
@@ -9,9 +9,15 @@
 package hls_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
 	"github.com/q191201771/naza/pkg/assert"
 )
 
@@ -49,3 +55,142 @@ func TestCalcM3u8Duration(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, float64(39.2), duration)
 }
+
+func TestFilterMasterM3u8(t *testing.T) {
+	golden := []byte(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+test110_360/playlist.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480,CODECS="avc1.4d401f,mp4a.40.2"
+test110_480/playlist.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2"
+test110_720/playlist.m3u8
+`)
+	assert.Equal(t, true, hls.IsMasterM3u8(golden))
+
+	filter, exist := hls.ParseVariantFilter("max_height=480&token=abc")
+	assert.Equal(t, true, exist)
+	assert.Equal(t, hls.VariantFilter{MaxHeight: 480}, filter)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+test110_360/playlist.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480,CODECS="avc1.4d401f,mp4a.40.2"
+test110_480/playlist.m3u8
+`, string(hls.FilterMasterM3u8(golden, filter)))
+
+	// 都不满足时保留带宽最小的
+	assert.Equal(t, `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+test110_360/playlist.m3u8
+`, string(hls.FilterMasterM3u8(golden, hls.VariantFilter{MaxBandwidth: 100})))
+
+	_, exist = hls.ParseVariantFilter("token=abc")
+	assert.Equal(t, false, exist)
+}
+
+func TestSubtitleTracks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_subtitle")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	m := hls.NewMuxer("test110", &hls.MuxerConfig{OutPath: dir, FragmentDurationMs: 3000, FragmentNum: 6}, nil)
+	m.Start()
+	m.SetSubtitleTracks([]hls.SubtitleTrack{
+		{Name: "English", Language: "en", Uri: "http://127.0.0.1/sub/en.m3u8", Default: true},
+	})
+	content, err := hls.ReadFile(filepath.Join(dir, "test110", "master.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,URI="http://127.0.0.1/sub/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,SUBTITLES="subs"
+playlist.m3u8
+`, string(content))
+	assert.Equal(t, true, hls.IsMasterM3u8(content))
+	assert.Equal(t, false, m.HasCaption())
+
+	m.SetSubtitleTracks(nil)
+	_, err = hls.ReadFile(filepath.Join(dir, "test110", "master.m3u8"))
+	assert.IsNotNil(t, err)
+
+	assert.Equal(t, nil, hls.SubtitleTrack{Name: "简体中文", Language: "zh-Hans", Uri: "zh.m3u8"}.Check())
+	assert.Equal(t, nil, hls.SubtitleTrack{Name: "English", Uri: "en.m3u8"}.Check())
+	assert.IsNotNil(t, hls.SubtitleTrack{Name: "a\"\n#EXT-X-ENDLIST", Language: "en", Uri: "en.m3u8"}.Check())
+	assert.IsNotNil(t, hls.SubtitleTrack{Name: "English", Language: "en", Uri: "en.m3u8\r\n#EXT-X-ENDLIST"}.Check())
+	assert.IsNotNil(t, hls.SubtitleTrack{Name: "English", Language: "en\",X=\"", Uri: "en.m3u8"}.Check())
+}
+
+func TestClockOffset(t *testing.T) {
+	hls.SetClockOffset(10 * time.Second)
+	defer hls.SetClockOffset(0)
+	diff := hls.Now().Sub(hls.Clock.Now())
+	assert.Equal(t, true, diff > 9*time.Second && diff <= 10*time.Second)
+}
+
+type testMuxerObserver struct{}
+
+func (o *testMuxerObserver) OnFragmentOpen() {}
+
+func TestMuxerResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_resume")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	old := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-ALLOW-CACHE:NO
+#EXT-X-TARGETDURATION:3
+#EXT-X-MEDIA-SEQUENCE:10
+
+#EXT-X-DISCONTINUITY
+#EXTINF:3.000,
+test110-1000-10.ts
+#EXTINF:3.000,
+test110-1003-11.ts
+#EXT-X-ENDLIST
+`
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "test110"), 0777))
+	playlist := filepath.Join(dir, "test110", "playlist.m3u8")
+	assert.Equal(t, nil, ioutil.WriteFile(playlist, []byte(old), 0666))
+
+	m := hls.NewMuxer("test110", &hls.MuxerConfig{OutPath: dir, FragmentDurationMs: 3000, FragmentNum: 2, ResumeEnable: true}, &testMuxerObserver{})
+	m.Start()
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 0, Key: true}, true)
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 3000 * 90, Key: true}, true)
+
+	content, err := hls.ReadFile(playlist)
+	assert.Equal(t, nil, err)
+	// 列表长度为2，所以最老的ts被移出，序号接着之前的继续递增
+	lines := strings.Split(string(content), "\n")
+	assert.Equal(t, "#EXT-X-MEDIA-SEQUENCE:11", lines[4])
+	assert.Equal(t, "test110-1003-11.ts", lines[7])
+	assert.Equal(t, "#EXT-X-DISCONTINUITY", lines[8])
+	assert.Equal(t, true, strings.HasSuffix(lines[10], "-12.ts"))
+	assert.Equal(t, false, strings.Contains(string(content), "#EXT-X-ENDLIST"))
+	m.Dispose()
+}
+
+func TestMuxerDiscontinue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_discont")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	m := hls.NewMuxer("test110", &hls.MuxerConfig{OutPath: dir, FragmentDurationMs: 3000, FragmentNum: 5}, &testMuxerObserver{})
+	m.Start()
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 0, Key: true}, true)
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 3000 * 90, Key: true}, true)
+	// 没有达到切片时长，但是流发生了变化，在关键帧处强制切片
+	m.Discontinue()
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 3500 * 90}, false)
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 4000 * 90, Key: true}, true)
+	m.FeedMpegts(nil, &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: 7000 * 90, Key: true}, true)
+
+	content, err := hls.ReadFile(filepath.Join(dir, "test110", "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	lines := strings.Split(string(content), "\n")
+	assert.Equal(t, "#EXT-X-DISCONTINUITY", lines[6])
+	assert.Equal(t, "#EXTINF:3.000,", lines[7])
+	assert.Equal(t, "#EXTINF:1.000,", lines[9])
+	assert.Equal(t, "#EXT-X-DISCONTINUITY", lines[11])
+	assert.Equal(t, "#EXTINF:3.000,", lines[12])
+	m.Dispose()
+}
@@ -11,6 +11,8 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -37,6 +39,9 @@ type MuxerConfig struct {
 	FragmentNum        int    `json:"fragment_num"`
 	DeleteThreshold    int    `json:"delete_threshold"`
 	CleanupMode        int    `json:"cleanup_mode"` // TODO chef: lalserver的模式1的逻辑是在上层做的，应该重构到hls模块中
+
+	ProgramDateTimeEnable bool `json:"program_date_time_enable"` // 是否在每个ts前写入`#EXT-X-PROGRAM-DATE-TIME`
+	ResumeEnable          bool `json:"resume_enable"`            // 启动时是否接着已存在的直播m3u8继续切片
 }
 
 const (
@@ -58,6 +63,8 @@ type Muxer struct {
 	playlistFilenameBak       string // const after init
 	recordPlayListFilename    string // const after init
 	recordPlayListFilenameBak string // const after init
+	masterPlaylistFilename    string // const after init
+	masterPlaylistFilenameBak string // const after init
 
 	config   *MuxerConfig
 	observer IMuxerObserver
@@ -79,6 +86,13 @@ type Muxer struct {
 	frags  []fragmentInfo // frags TS文件的固定大小环形队列，记录TS的信息
 
 	patpmt []byte
+
+	subtitleTracks []SubtitleTrack
+	hasCaption     bool
+	fragBytes      int // 当前fragment已写入的字节数
+	bandwidth      int // 最近一个fragment的码率，单位bit/s
+
+	discontPending bool // 见 Discontinue
 }
 
 // 记录fragment的一些信息，注意，写m3u8文件时可能还需要用到历史fragment的信息
@@ -87,6 +101,8 @@ type fragmentInfo struct {
 	duration float64 // 当前fragment中数据的时长，单位秒
 	discont  bool    // #EXT-X-DISCONTINUITY
 	filename string
+
+	programDateTime time.Time // fragment开始时的墙上时间，见 Now
 }
 
 // NewMuxer
@@ -100,6 +116,7 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 	recordPlaylistFilename := PathStrategy.GetRecordM3u8FileName(op, streamName)
 	playlistFilenameBak := fmt.Sprintf("%s.bak", playlistFilename)
 	recordPlaylistFilenameBak := fmt.Sprintf("%s.bak", recordPlaylistFilename)
+	masterPlaylistFilename := filepath.Join(op, masterM3u8FileName)
 	m := &Muxer{
 		UniqueKey:                 uk,
 		streamName:                streamName,
@@ -108,6 +125,8 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 		playlistFilenameBak:       playlistFilenameBak,
 		recordPlayListFilename:    recordPlaylistFilename,
 		recordPlayListFilenameBak: recordPlaylistFilenameBak,
+		masterPlaylistFilename:    masterPlaylistFilename,
+		masterPlaylistFilenameBak: fmt.Sprintf("%s.bak", masterPlaylistFilename),
 		config:                    config,
 		observer:                  observer,
 	}
@@ -119,6 +138,9 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 func (m *Muxer) Start() {
 	Log.Infof("[%s] start hls muxer.", m.UniqueKey)
 	m.ensureDir()
+	if m.config.ResumeEnable {
+		m.resume()
+	}
 }
 
 func (m *Muxer) Dispose() {
@@ -172,16 +194,25 @@ func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool)
 			return
 		}
 		//Log.Debugf("[%s] WriteFrame V. dts=%d, len=%d", m.UniqueKey, frame.Dts, len(frame.Raw))
+		m.detectCaption(frame.Raw)
 	}
 
 	if err := m.fragment.WriteFile(tsPackets); err != nil {
 		Log.Errorf("[%s] fragment write error. err=%+v", m.UniqueKey, err)
 		return
 	}
+	m.fragBytes += len(tsPackets)
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// Discontinue 流中途发生了变化（比如视频分辨率切换），在下一个边界处（比如关键帧）强制开启新的ts切片，
+// 并在m3u8中该切片前标记`#EXT-X-DISCONTINUITY`，使得播放器重新初始化解码器
+//
+func (m *Muxer) Discontinue() {
+	m.discontPending = true
+}
+
 func (m *Muxer) OutPath() string {
 	return m.outPath
 }
@@ -238,7 +269,7 @@ func (m *Muxer) updateFragment(ts uint64, boundary bool) error {
 		discont = false
 
 		// 已经有TS切片，切片时长没有达到设置的阈值，则不开启新的切片
-		if f.duration < float64(m.config.FragmentDurationMs)/1000 {
+		if f.duration < float64(m.config.FragmentDurationMs)/1000 && !(boundary && m.discontPending) {
 			return nil
 		}
 	}
@@ -251,9 +282,10 @@ func (m *Muxer) updateFragment(ts uint64, boundary bool) error {
 		if err := m.closeFragment(false); err != nil {
 			return err
 		}
-		if err := m.openFragment(ts, discont); err != nil {
+		if err := m.openFragment(ts, discont || m.discontPending); err != nil {
 			return err
 		}
+		m.discontPending = false
 	}
 
 	return nil
@@ -275,7 +307,7 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	filename := PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
 	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, filename)
 
-	if err := m.fragment.OpenFile(filenameWithPath); err != nil {
+	if err := m.fragment.OpenFileOfStream(filenameWithPath, m.streamName); err != nil {
 		return err
 	}
 
@@ -290,8 +322,10 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	frag.id = id
 	frag.filename = filename
 	frag.duration = 0
+	frag.programDateTime = Now()
 
 	m.fragTs = ts
+	m.fragBytes = 0
 
 	// nrm said: start fragment with audio to make iPhone happy
 	m.observer.OnFragmentOpen()
@@ -315,12 +349,20 @@ func (m *Muxer) closeFragment(isLast bool) error {
 
 	m.opened = false
 
+	if f := m.getCurrFrag(); f.duration > 0 {
+		m.bandwidth = int(float64(m.fragBytes*8) / f.duration)
+	}
+
 	// 更新序号，为下个分片做准备
 	// 注意，后面使用序号的逻辑，都依赖该处
 	m.incrFrag()
 
 	m.writePlaylist(isLast)
 
+	if len(m.subtitleTracks) != 0 {
+		m.writeMasterPlaylist()
+	}
+
 	if m.config.CleanupMode == CleanupModeNever || m.config.CleanupMode == CleanupModeInTheEnd {
 		m.writeRecordPlaylist()
 	}
@@ -345,7 +387,7 @@ func (m *Muxer) writeRecordPlaylist() {
 		m.recordMaxFragDuration = currFrag.duration + 0.5
 	}
 
-	fragLines := fmt.Sprintf("#EXTINF:%.3f,\n%s\n", currFrag.duration, currFrag.filename)
+	fragLines := m.makeFragLines(currFrag)
 
 	content, err := fslCtx.ReadFile(m.recordPlayListFilename)
 	if err == nil {
@@ -409,7 +451,7 @@ func (m *Muxer) writePlaylist(isLast bool) {
 			buf.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
 
-		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+		buf.WriteString(m.makeFragLines(frag))
 	})
 
 	if isLast {
@@ -421,6 +463,44 @@ func (m *Muxer) writePlaylist(isLast bool) {
 	}
 }
 
+func (m *Muxer) makeFragLines(frag *fragmentInfo) string {
+	if m.config.ProgramDateTimeEnable && !frag.programDateTime.IsZero() {
+		return fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:%.3f,\n%s\n",
+			frag.programDateTime.Format(programDateTimeLayout), frag.duration, frag.filename)
+	}
+	return fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename)
+}
+
+// resume 读取已存在的直播m3u8文件，恢复序号以及ts列表
+//
+// 直播m3u8文件本身就记录了`#EXT-X-MEDIA-SEQUENCE`以及列表中ts的信息，并且每次都是先写临时文件再rename，
+// 所以即使进程崩溃，文件内容也是完整的，可以直接作为持久化的状态使用
+//
+// 恢复后，新的ts序号接着原列表往后递增，并且第一个新的ts前会写入`#EXT-X-DISCONTINUITY`
+//
+func (m *Muxer) resume() {
+	content, err := fslCtx.ReadFile(m.playlistFilename)
+	if err != nil {
+		return
+	}
+	seq, frags, err := parseLiveM3u8(content)
+	if err != nil {
+		Log.Warnf("[%s] parse live m3u8 failed, ignore resume. filename=%s, err=%+v", m.UniqueKey, m.playlistFilename, err)
+		return
+	}
+	if len(frags) > m.config.FragmentNum {
+		seq += len(frags) - m.config.FragmentNum
+		frags = frags[len(frags)-m.config.FragmentNum:]
+	}
+
+	m.frag = seq
+	m.nfrags = len(frags)
+	for i := range frags {
+		*m.getFrag(i) = frags[i]
+	}
+	Log.Infof("[%s] resume hls muxer. mediaSeq=%d, nfrags=%d", m.UniqueKey, m.frag, m.nfrags)
+}
+
 func (m *Muxer) ensureDir() {
 	//err := fslCtx.RemoveAll(m.outPath)
 	//Log.Assert(nil, err)
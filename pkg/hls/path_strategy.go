@@ -75,6 +75,7 @@ type IPathWriteStrategy interface {
 const (
 	playlistM3u8FileName = "playlist.m3u8"
 	recordM3u8FileName   = "record.m3u8"
+	masterM3u8FileName   = "master.m3u8"
 )
 
 // DefaultPathStrategy 默认的路由，落盘策略
@@ -83,6 +84,7 @@ const (
 //
 // - playlist.m3u8              实时的HLS文件，定期刷新，写入当前最新的TS文件列表，淘汰过期的TS文件列表
 // - record.m3u8                录制回放的HLS文件，包含了从流开始至今的所有TS文件
+// - master.m3u8                挂载了字幕时才生成，包含字幕轨以及playlist.m3u8
 // - test110-1620540712084-0.ts TS分片文件，命名格式为{liveid}-{timestamp}-{index}.ts
 // - test110-1620540716095-1.ts
 // - ...                        一系列的TS文件
@@ -95,6 +97,7 @@ const (
 // 则
 // http://127.0.0.1:8080/hls/test110/playlist.m3u8              -> /tmp/lal/hls/test110/playlist.m3u8
 // http://127.0.0.1:8080/hls/test110/record.m3u8                -> /tmp/lal/hls/test110/record.m3u8
+// http://127.0.0.1:8080/hls/test110/master.m3u8                -> /tmp/lal/hls/test110/master.m3u8
 // http://127.0.0.1:8080/hls/test110/test110-1620540712084-0.ts -> /tmp/lal/hls/test110/test110-1620540712084-0.ts
 //
 // http://127.0.0.1:8080/hls/test110.m3u8                       -> /tmp/lal/hls/test110/playlist.m3u8
@@ -120,7 +123,7 @@ func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPa
 	fileNameWithoutType := urlCtx.GetFilenameWithoutType()
 
 	if filetype == "m3u8" {
-		if filename == playlistM3u8FileName || filename == recordM3u8FileName {
+		if filename == playlistM3u8FileName || filename == recordM3u8FileName || filename == masterM3u8FileName {
 			uriItems := strings.Split(urlCtx.Path, "/")
 			ri.StreamName = uriItems[len(uriItems)-2]
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
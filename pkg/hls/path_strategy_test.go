@@ -34,6 +34,10 @@ func TestDefaultPathStrategy_GetRequestInfo(t *testing.T) {
 			StreamName:       "test110",
 			FileNameWithPath: "/tmp/lal/hls/test110/record.m3u8",
 		},
+		"http://127.0.0.1:8080/hls/test110/master.m3u8": {
+			StreamName:       "test110",
+			FileNameWithPath: "/tmp/lal/hls/test110/master.m3u8",
+		},
 		"http://127.0.0.1:8080/hls/test110/test110-1620540712084-0.ts": {
 			StreamName:       "test110",
 			FileNameWithPath: "/tmp/lal/hls/test110/test110-1620540712084-0.ts",
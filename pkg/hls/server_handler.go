@@ -16,11 +16,27 @@ import (
 
 type ServerHandler struct {
 	outPath string
+	option  ServerHandlerOption
 }
 
-func NewServerHandler(outPath string) *ServerHandler {
+type ServerHandlerOption struct {
+	HttpHeader base.HttpHeaderOption // HTTP响应中CORS、Cache-Control以及自定义的header
+}
+
+var defaultServerHandlerOption = ServerHandlerOption{
+	HttpHeader: base.DefaultHttpHeaderOption,
+}
+
+type ModServerHandlerOption func(option *ServerHandlerOption)
+
+func NewServerHandler(outPath string, modOptions ...ModServerHandlerOption) *ServerHandler {
+	option := defaultServerHandlerOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
 	return &ServerHandler{
 		outPath: outPath,
+		option:  option,
 	}
 }
 
@@ -30,10 +46,14 @@ func (s *ServerHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		Log.Errorf("parse url. err=%+v", err)
 		return
 	}
-	s.ServeHTTPWithUrlCtx(resp, urlCtx)
+	s.serve(resp, urlCtx, req.Header.Get("Origin"))
 }
 
 func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx base.UrlContext) {
+	s.serve(resp, urlCtx, "")
+}
+
+func (s *ServerHandler) serve(resp http.ResponseWriter, urlCtx base.UrlContext, reqOrigin string) {
 	//Log.Debugf("%+v", req)
 
 	// TODO chef:
@@ -58,6 +78,13 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx bas
 		return
 	}
 
+	// master m3u8时，按url参数过滤客户端可见的variant，比如嵌入式设备播放时，过滤掉无法解码的高分辨率
+	if filetype == "m3u8" && IsMasterM3u8(content) {
+		if filter, exist := ParseVariantFilter(urlCtx.RawQuery); exist {
+			content = FilterMasterM3u8(content, filter)
+		}
+	}
+
 	switch filetype {
 	case "m3u8":
 		resp.Header().Add("Content-Type", "application/x-mpegurl")
@@ -66,8 +93,7 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx bas
 		resp.Header().Add("Content-Type", "video/mp2t")
 		resp.Header().Add("Server", base.LalHlsTsServer)
 	}
-	resp.Header().Add("Cache-Control", "no-cache")
-	resp.Header().Add("Access-Control-Allow-Origin", "*")
+	s.option.HttpHeader.SetTo(resp.Header(), reqOrigin)
 
 	_, _ = resp.Write(content)
 	return
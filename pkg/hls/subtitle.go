@@ -0,0 +1,145 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// SubtitleTrack 字幕轨，`Uri`指向一个WebVTT字幕的m3u8
+//
+type SubtitleTrack struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Uri      string `json:"uri"`
+	Default  bool   `json:"default"`
+}
+
+// Check 检查是否可以写入master.m3u8
+//
+// `Name`、`Uri`写在引号中，不能包含引号和换行，否则可以注入任意的m3u8标签；`Language`需要是语言标签（比如`en`、`zh-Hans`），可以为空
+//
+func (t SubtitleTrack) Check() error {
+	for _, v := range []string{t.Name, t.Uri} {
+		if strings.ContainsAny(v, "\"\r\n") {
+			return fmt.Errorf("%w. invalid char in subtitle track. name=%q, uri=%q", base.ErrHls, t.Name, t.Uri)
+		}
+	}
+	if t.Language != "" && !languageTagRegexp.MatchString(t.Language) {
+		return fmt.Errorf("%w. invalid subtitle track language. language=%q", base.ErrHls, t.Language)
+	}
+	return nil
+}
+
+// SetSubtitleTracks 给流挂载WebVTT字幕
+//
+// 挂载后，会额外生成master.m3u8，包含字幕轨以及实时的playlist.m3u8，播放端播放master.m3u8即可渲染字幕
+// 如果视频中检测到了CEA-608/708字幕，master.m3u8中也会声明CLOSED-CAPTIONS
+//
+// @param tracks: 为空时删除master.m3u8
+//
+func (m *Muxer) SetSubtitleTracks(tracks []SubtitleTrack) {
+	m.subtitleTracks = tracks
+	if len(tracks) == 0 {
+		if err := fslCtx.Remove(m.masterPlaylistFilename); err != nil {
+			Log.Debugf("[%s] remove master m3u8 file. err=%+v", m.UniqueKey, err)
+		}
+		return
+	}
+	m.writeMasterPlaylist()
+}
+
+// HasCaption 视频中是否检测到了CEA-608/708字幕
+//
+func (m *Muxer) HasCaption() bool {
+	return m.hasCaption
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 视频SEI中的字幕数据随ts透传，这里只做检测，用于在master.m3u8中声明
+func (m *Muxer) detectCaption(annexb []byte) {
+	if m.hasCaption {
+		return
+	}
+	_ = avc.IterateNaluAnnexb(annexb, func(nal []byte) {
+		if !m.hasCaption && avc.IsCaptionSei(nal) {
+			m.hasCaption = true
+			Log.Infof("[%s] caption detected.", m.UniqueKey)
+		}
+	})
+	if m.hasCaption && len(m.subtitleTracks) != 0 {
+		m.writeMasterPlaylist()
+	}
+}
+
+func (m *Muxer) writeMasterPlaylist() {
+	content := makeMasterM3u8(m.subtitleTracks, m.hasCaption, m.bandwidth, filepath.Base(m.playlistFilename))
+	if err := writeM3u8File(content, m.masterPlaylistFilename, m.masterPlaylistFilenameBak); err != nil {
+		Log.Errorf("[%s] write master m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// @param bandwidth: 单位bit/s，为0时使用 defaultMasterBandwidth
+//
+func makeMasterM3u8(tracks []SubtitleTrack, hasCaption bool, bandwidth int, playlistUri string) []byte {
+	if bandwidth <= 0 {
+		bandwidth = defaultMasterBandwidth
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:4\n")
+	for _, t := range tracks {
+		// 调用方应该已经检查过，这里再跳过一次，避免写出被注入的m3u8
+		if err := t.Check(); err != nil {
+			Log.Warnf("skip invalid subtitle track. err=%+v", err)
+			continue
+		}
+		yesOrNo := "NO"
+		if t.Default {
+			yesOrNo = "YES"
+		}
+		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n",
+			subtitleGroupId, t.Name, t.Language, yesOrNo, t.Uri))
+	}
+	if hasCaption {
+		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"%s\",NAME=\"CC1\",INSTREAM-ID=\"CC1\",DEFAULT=YES,AUTOSELECT=YES\n",
+			captionGroupId))
+	}
+
+	buf.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth))
+	if len(tracks) != 0 {
+		buf.WriteString(fmt.Sprintf(",SUBTITLES=\"%s\"", subtitleGroupId))
+	}
+	if hasCaption {
+		buf.WriteString(fmt.Sprintf(",CLOSED-CAPTIONS=\"%s\"", captionGroupId))
+	}
+	buf.WriteString("\n")
+	buf.WriteString(playlistUri)
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+const (
+	subtitleGroupId = "subs"
+	captionGroupId  = "cc"
+
+	defaultMasterBandwidth = 2000000
+)
+
+// BCP 47语言标签的简化形式
+var languageTagRegexp = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
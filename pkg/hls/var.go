@@ -9,6 +9,9 @@
 package hls
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/q191201771/naza/pkg/mock"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
@@ -20,3 +23,20 @@ var (
 
 	Log = nazalog.GetGlobalLogger()
 )
+
+// ISO 8601，精确到毫秒
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+var clockOffsetNs int64
+
+// SetClockOffset 设置墙上时间的校正值，比如通过ntp得到的本机时钟偏差，用于`#EXT-X-PROGRAM-DATE-TIME`
+//
+func SetClockOffset(offset time.Duration) {
+	atomic.StoreInt64(&clockOffsetNs, int64(offset))
+}
+
+// Now 校正后的墙上时间
+//
+func Now() time.Time {
+	return Clock.Now().Add(time.Duration(atomic.LoadInt64(&clockOffsetNs)))
+}
@@ -30,11 +30,14 @@ type PullSessionOption struct {
 	PullTimeoutMs int
 
 	ReadTimeoutMs int // 接收数据超时，单位毫秒，如果为0，则不设置超时
+
+	ProxyUrl string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
 }
 
 var defaultPullSessionOption = PullSessionOption{
 	PullTimeoutMs: 10000,
 	ReadTimeoutMs: 0,
+	ProxyUrl:      "",
 }
 
 type PullSession struct {
@@ -245,17 +248,20 @@ func (session *PullSession) connect(rawUrl string) (err error) {
 	Log.Debugf("[%s] > tcp connect. %s", session.uniqueKey, session.urlCtx.HostWithPort)
 
 	var conn net.Conn
+	conn, err = base.DialTcp(session.urlCtx.HostWithPort, session.option.ProxyUrl)
+	if err != nil {
+		return err
+	}
 	if session.urlCtx.Scheme == "https" {
 		conf := &tls.Config{
 			InsecureSkipVerify: true,
 		}
-		conn, err = tls.Dial("tcp", session.urlCtx.HostWithPort, conf)
-	} else {
-		conn, err = net.Dial("tcp", session.urlCtx.HostWithPort)
-	}
-
-	if err != nil {
-		return err
+		tlsConn := tls.Client(conn, conf)
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		conn = tlsConn
 	}
 
 	Log.Debugf("[%s] tcp connect succ. remote=%s", session.uniqueKey, conn.RemoteAddr().String())
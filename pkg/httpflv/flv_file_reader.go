@@ -9,6 +9,8 @@
 package httpflv
 
 import (
+	"bufio"
+	"io"
 	"os"
 )
 
@@ -44,3 +46,50 @@ func (ffr *FlvFileReader) Dispose() {
 		_ = ffr.fp.Close()
 	}
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// KeyframeIndexItem 视频关键帧在flv文件中的位置，web播放器可以配合http Range请求实现seek
+type KeyframeIndexItem struct {
+	Timestamp uint32 // 单位毫秒
+	Offset    int64  // tag在文件中的字节偏移
+}
+
+// ReadKeyframeIndex 遍历flv文件，读取所有视频关键帧的位置
+//
+// 文件末尾不完整的tag（比如正在录制的文件）会被忽略
+//
+// @return durationMs: 最后一个tag的时间戳
+//
+func ReadKeyframeIndex(filename string) (items []KeyframeIndexItem, durationMs uint32, err error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fp.Close()
+	return ReadKeyframeIndexFrom(fp)
+}
+
+// ReadKeyframeIndexFrom 同 ReadKeyframeIndex ，从`r`中读取flv文件的内容，比如解密后的录制文件
+//
+func ReadKeyframeIndexFrom(r io.Reader) (items []KeyframeIndexItem, durationMs uint32, err error) {
+	rd := bufio.NewReader(r)
+	if _, err = io.ReadFull(rd, make([]byte, flvHeaderSize)); err != nil {
+		return nil, 0, err
+	}
+	offset := int64(flvHeaderSize)
+	for {
+		tag, err := readTag(rd)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return items, durationMs, nil
+			}
+			return nil, 0, err
+		}
+		if tag.IsVideoKeyNalu() {
+			items = append(items, KeyframeIndexItem{Timestamp: tag.Header.Timestamp, Offset: offset})
+		}
+		durationMs = tag.Header.Timestamp
+		offset += int64(len(tag.Raw))
+	}
+}
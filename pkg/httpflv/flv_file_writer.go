@@ -9,7 +9,7 @@
 package httpflv
 
 import (
-	"os"
+	"io"
 
 	"github.com/q191201771/lal/pkg/base"
 )
@@ -17,11 +17,21 @@ import (
 // TODO chef: 结构体重命名为FileWriter，文件名重命名为file_writer.go。所有写流文件的（flv,hls,ts）统一重构
 
 type FlvFileWriter struct {
-	fp *os.File
+	fp   io.WriteCloser
+	name string
 }
 
 func (ffw *FlvFileWriter) Open(filename string) (err error) {
-	ffw.fp, err = os.Create(filename)
+	return ffw.OpenWithKeyring(filename, nil, "")
+}
+
+// OpenWithKeyring 加密写入，见 base.FileKeyring 。`keyring`为nil时不加密
+//
+func (ffw *FlvFileWriter) OpenWithKeyring(filename string, keyring *base.FileKeyring, streamName string) (err error) {
+	if ffw.fp, err = keyring.Create(filename, streamName); err != nil {
+		return
+	}
+	ffw.name = filename
 	return
 }
 
@@ -60,5 +70,5 @@ func (ffw *FlvFileWriter) Name() string {
 	if ffw.fp == nil {
 		return ""
 	}
-	return ffw.fp.Name()
+	return ffw.name
 }
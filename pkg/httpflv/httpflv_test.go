@@ -11,6 +11,7 @@ package httpflv_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/q191201771/lal/pkg/httpflv"
@@ -57,3 +58,37 @@ func TestFlvFilePump(t *testing.T) {
 	assert.Equal(t, "ab7f75d2491711cc9a8d0ccd5d56280b", nazamd5.Md5(allRaw))
 	assert.Equal(t, "2a1cd1bd99f725c19bbd45d81d436e59", nazamd5.Md5(allHeader))
 }
+
+func TestReadKeyframeIndex(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.flv")
+	var ffw httpflv.FlvFileWriter
+	assert.Equal(t, nil, ffw.Open(filename))
+	assert.Equal(t, nil, ffw.WriteFlvHeader())
+
+	keyFrame := []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeNalu, 0, 0, 0, 0x65}
+	interFrame := []byte{0x27, httpflv.AvcPacketTypeNalu, 0, 0, 0, 0x41}
+	tags := [][]byte{
+		httpflv.PackHttpflvTag(httpflv.TagTypeMetadata, 0, []byte{2, 0, 0}),
+		httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 0, keyFrame),
+		httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 40, interFrame),
+		httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 2000, keyFrame),
+		httpflv.PackHttpflvTag(httpflv.TagTypeAudio, 2010, []byte{0xaf, 1, 0}),
+	}
+	for _, tag := range tags {
+		assert.Equal(t, nil, ffw.WriteRaw(tag))
+	}
+	// 末尾不完整的tag
+	assert.Equal(t, nil, ffw.WriteRaw(tags[2][:8]))
+	assert.Equal(t, nil, ffw.Dispose())
+
+	items, durationMs, err := httpflv.ReadKeyframeIndex(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(2010), durationMs)
+	assert.Equal(t, 2, len(items))
+	headerSize := int64(len(httpflv.FlvHeader))
+	assert.Equal(t, httpflv.KeyframeIndexItem{Timestamp: 0, Offset: headerSize + int64(len(tags[0]))}, items[0])
+	assert.Equal(t, httpflv.KeyframeIndexItem{Timestamp: 2000, Offset: headerSize + int64(len(tags[0])+len(tags[1])+len(tags[2]))}, items[1])
+
+	_, _, err = httpflv.ReadKeyframeIndex(filepath.Join(t.TempDir(), "not_exist.flv"))
+	assert.IsNotNil(t, err)
+}
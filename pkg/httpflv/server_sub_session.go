@@ -10,18 +10,25 @@ package httpflv
 
 import (
 	"net"
+	"net/http"
 
 	"github.com/q191201771/lal/pkg/base"
 
 	"github.com/q191201771/naza/pkg/connection"
 )
 
-var flvHttpResponseHeader []byte
+// 不包含CORS、Cache-Control等可配置的header，以及最后的空行
+var flvHttpResponseHeaderPrefix string
 
 type SubSession struct {
 	core                    *base.HttpSubSession
 	IsFresh                 bool
 	ShouldWaitVideoKeyFrame bool
+
+	httpHeader http.Header
+
+	hasWriteAv bool
+	lastAvTs   uint32 // 最近一次发送的音视频tag的时间戳，用于断点续播
 }
 
 func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, websocketKey string) *SubSession {
@@ -31,17 +38,19 @@ func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, webs
 			Conn: conn,
 			ConnModOption: func(option *connection.Option) {
 				option.WriteChanSize = SubSessionWriteChanSize
-				option.WriteTimeoutMs = SubSessionWriteTimeoutMs
 			},
-			Uk:           uk,
-			Protocol:     base.ProtocolHttpflv,
-			UrlCtx:       urlCtx,
-			IsWebSocket:  isWebSocket,
-			WebSocketKey: websocketKey,
+			WriteTimeoutMs: SubSessionWriteTimeoutMs,
+			Uk:             uk,
+			Protocol:       base.ProtocolHttpflv,
+			UrlCtx:         urlCtx,
+			IsWebSocket:    isWebSocket,
+			WebSocketKey:   websocketKey,
 		}),
 		IsFresh:                 true,
 		ShouldWaitVideoKeyFrame: true,
+		httpHeader:              make(http.Header),
 	}
+	base.DefaultHttpHeaderOption.SetTo(s.httpHeader, "")
 	Log.Infof("[%s] lifecycle new httpflv SubSession. session=%p, remote addr=%s", uk, s, conn.RemoteAddr().String())
 	return s
 }
@@ -63,7 +72,20 @@ func (session *SubSession) Dispose() error {
 
 func (session *SubSession) WriteHttpResponseHeader() {
 	Log.Debugf("[%s] > W http response header.", session.core.UniqueKey())
-	session.core.WriteHttpResponseHeader(flvHttpResponseHeader)
+	// 断点续播时，客户端使用该id重连
+	session.httpHeader.Set("X-Lal-Session-Id", session.core.UniqueKey())
+	session.core.WriteHttpResponseHeader([]byte(flvHttpResponseHeaderPrefix + base.PackHttpHeaderLines(session.httpHeader) + "\r\n"))
+}
+
+// SetHttpHeaderOption 设置HTTP响应中CORS、Cache-Control以及自定义的header，不调用则使用 base.DefaultHttpHeaderOption
+//
+// 注意，需在 WriteHttpResponseHeader 之前调用
+//
+// @param reqOrigin 请求中的Origin header
+//
+func (session *SubSession) SetHttpHeaderOption(option base.HttpHeaderOption, reqOrigin string) {
+	session.httpHeader = make(http.Header)
+	option.SetTo(session.httpHeader, reqOrigin)
 }
 
 func (session *SubSession) WriteFlvHeader() {
@@ -72,13 +94,26 @@ func (session *SubSession) WriteFlvHeader() {
 }
 
 func (session *SubSession) WriteTag(tag *Tag) {
-	session.core.Write(tag.Raw)
+	session.Write(tag.Raw)
 }
 
+// Write @param b: 一个完整的flv tag
 func (session *SubSession) Write(b []byte) {
+	if len(b) >= TagHeaderSize && (b[0] == TagTypeAudio || b[0] == TagTypeVideo) {
+		session.hasWriteAv = true
+		session.lastAvTs = parseTagHeader(b).Timestamp
+	}
 	session.core.Write(b)
 }
 
+// LastAvTimestamp 最近一次发送的音视频tag的时间戳
+//
+// @return ok: 还没有发送过音视频tag时为false
+//
+func (session *SubSession) LastAvTimestamp() (ts uint32, ok bool) {
+	return session.lastAvTs, session.hasWriteAv
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 // IObject interface
 // ---------------------------------------------------------------------------------------------------------------------
@@ -103,6 +138,10 @@ func (session *SubSession) StreamName() string {
 	return session.core.StreamName()
 }
 
+func (session *SubSession) SetStreamName(streamName string) {
+	session.core.SetStreamName(streamName)
+}
+
 func (session *SubSession) RawQuery() string {
 	return session.core.RawQuery()
 }
@@ -126,16 +165,10 @@ func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func init() {
-	flvHttpResponseHeaderStr := "HTTP/1.1 200 OK\r\n" +
+	flvHttpResponseHeaderPrefix = "HTTP/1.1 200 OK\r\n" +
 		"Server: " + base.LalHttpflvSubSessionServer + "\r\n" +
-		"Cache-Control: no-cache\r\n" +
 		"Content-Type: video/x-flv\r\n" +
 		"Connection: close\r\n" +
 		"Expires: -1\r\n" +
-		"Pragma: no-cache\r\n" +
-		"Access-Control-Allow-Credentials: true\r\n" +
-		"Access-Control-Allow-Origin: *\r\n" +
-		"\r\n"
-
-	flvHttpResponseHeader = []byte(flvHttpResponseHeaderStr)
+		"Pragma: no-cache\r\n"
 }
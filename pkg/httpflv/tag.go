@@ -110,6 +110,12 @@ func parseTagHeader(rawHeader []byte) TagHeader {
 	return h
 }
 
+// ReadTag 从`rd`中读取一个完整的tag，`rd`的读取位置需要在tag的开始处（也即已经读取了flv header）
+//
+func ReadTag(rd io.Reader) (Tag, error) {
+	return readTag(rd)
+}
+
 func readTag(rd io.Reader) (tag Tag, err error) {
 	rawHeader := make([]byte, TagHeaderSize)
 	if _, err = io.ReadAtLeast(rd, rawHeader, TagHeaderSize); err != nil {
@@ -10,17 +10,21 @@ package httpts
 
 import (
 	"net"
+	"net/http"
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/connection"
 )
 
-var tsHttpResponseHeader []byte
+// 不包含CORS、Cache-Control等可配置的header，以及最后的空行
+var tsHttpResponseHeaderPrefix string
 
 type SubSession struct {
 	core               *base.HttpSubSession
 	IsFresh            bool
 	ShouldWaitBoundary bool
+
+	httpHeader http.Header
 }
 
 func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, websocketKey string) *SubSession {
@@ -30,17 +34,19 @@ func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, webs
 			Conn: conn,
 			ConnModOption: func(option *connection.Option) {
 				option.WriteChanSize = SubSessionWriteChanSize
-				option.WriteTimeoutMs = SubSessionWriteTimeoutMs
 			},
-			Uk:           uk,
-			Protocol:     base.ProtocolHttpts,
-			UrlCtx:       urlCtx,
-			IsWebSocket:  isWebSocket,
-			WebSocketKey: websocketKey,
+			WriteTimeoutMs: SubSessionWriteTimeoutMs,
+			Uk:             uk,
+			Protocol:       base.ProtocolHttpts,
+			UrlCtx:         urlCtx,
+			IsWebSocket:    isWebSocket,
+			WebSocketKey:   websocketKey,
 		}),
 		IsFresh:            true,
 		ShouldWaitBoundary: true,
+		httpHeader:         make(http.Header),
 	}
+	base.DefaultHttpHeaderOption.SetTo(s.httpHeader, "")
 	Log.Infof("[%s] lifecycle new httpts SubSession. session=%p, remote addr=%s", uk, s, conn.RemoteAddr().String())
 	return s
 }
@@ -62,7 +68,18 @@ func (session *SubSession) Dispose() error {
 
 func (session *SubSession) WriteHttpResponseHeader() {
 	Log.Debugf("[%s] > W http response header.", session.core.UniqueKey())
-	session.core.WriteHttpResponseHeader(tsHttpResponseHeader)
+	session.core.WriteHttpResponseHeader([]byte(tsHttpResponseHeaderPrefix + base.PackHttpHeaderLines(session.httpHeader) + "\r\n"))
+}
+
+// SetHttpHeaderOption 设置HTTP响应中CORS、Cache-Control以及自定义的header，不调用则使用 base.DefaultHttpHeaderOption
+//
+// 注意，需在 WriteHttpResponseHeader 之前调用
+//
+// @param reqOrigin 请求中的Origin header
+//
+func (session *SubSession) SetHttpHeaderOption(option base.HttpHeaderOption, reqOrigin string) {
+	session.httpHeader = make(http.Header)
+	option.SetTo(session.httpHeader, reqOrigin)
 }
 
 func (session *SubSession) Write(b []byte) {
@@ -93,6 +110,10 @@ func (session *SubSession) StreamName() string {
 	return session.core.StreamName()
 }
 
+func (session *SubSession) SetStreamName(streamName string) {
+	session.core.SetStreamName(streamName)
+}
+
 func (session *SubSession) RawQuery() string {
 	return session.core.RawQuery()
 }
@@ -114,16 +135,10 @@ func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
 }
 
 func init() {
-	tsHttpResponseHeaderStr := "HTTP/1.1 200 OK\r\n" +
+	tsHttpResponseHeaderPrefix = "HTTP/1.1 200 OK\r\n" +
 		"Server: " + base.LalHttptsSubSessionServer + "\r\n" +
-		"Cache-Control: no-cache\r\n" +
 		"Content-Type: video/mp2t\r\n" +
 		"Connection: close\r\n" +
 		"Expires: -1\r\n" +
-		"Pragma: no-cache\r\n" +
-		"Access-Control-Allow-Credentials: true\r\n" +
-		"Access-Control-Allow-Origin: *\r\n" +
-		"\r\n"
-
-	tsHttpResponseHeader = []byte(tsHttpResponseHeaderStr)
+		"Pragma: no-cache\r\n"
 }
@@ -138,6 +138,7 @@ var (
 
 var _ logic.ICustomizePubSessionContext = &logic.CustomizePubSessionContext{}
 var _ base.IAvPacketStream = &logic.CustomizePubSessionContext{}
+var _ logic.ICustomizeSubSessionContext = &logic.CustomizeSubSessionContext{}
 
 // ---------------------------------------------------------------------------------------------------------------------
 
@@ -149,6 +150,7 @@ var _ logic.IGroupCreator = &logic.ServerManager{}
 var _ logic.IGroupObserver = &logic.ServerManager{}
 
 var _ logic.INotifyHandler = &logic.HttpNotify{}
+var _ logic.IAuthentication = &logic.SimpleAuthCtx{}
 var _ logic.IGroupManager = &logic.SimpleGroupManager{}
 var _ logic.IGroupManager = &logic.ComplexGroupManager{}
 
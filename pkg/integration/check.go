@@ -0,0 +1,155 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+)
+
+// TagStat flv tag序列的统计
+//
+// 注意，Video、Audio不包含seq header
+//
+type TagStat struct {
+	Metadata        int
+	VideoSeqHeader  int
+	AudioSeqHeader  int
+	Video           int
+	VideoKey        int
+	Audio           int
+	FirstVideoTs    uint32
+	LastVideoTs     uint32
+	FirstAudioTs    uint32
+	LastAudioTs     uint32
+	VideoBytes      int
+	AudioBytes      int
+	UnknownTypeTags int
+}
+
+func StatTags(tags []httpflv.Tag) (stat TagStat) {
+	for i := range tags {
+		tag := &tags[i]
+		ts := tag.Header.Timestamp
+		switch tag.Header.Type {
+		case httpflv.TagTypeMetadata:
+			stat.Metadata++
+		case httpflv.TagTypeVideo:
+			if tag.IsVideoKeySeqHeader() {
+				stat.VideoSeqHeader++
+				continue
+			}
+			if stat.Video == 0 {
+				stat.FirstVideoTs = ts
+			}
+			stat.LastVideoTs = ts
+			stat.Video++
+			stat.VideoBytes += int(tag.Header.DataSize)
+			if tag.IsVideoKeyNalu() {
+				stat.VideoKey++
+			}
+		case httpflv.TagTypeAudio:
+			if tag.IsAacSeqHeader() {
+				stat.AudioSeqHeader++
+				continue
+			}
+			if stat.Audio == 0 {
+				stat.FirstAudioTs = ts
+			}
+			stat.LastAudioTs = ts
+			stat.Audio++
+			stat.AudioBytes += int(tag.Header.DataSize)
+		default:
+			stat.UnknownTypeTags++
+		}
+	}
+	return
+}
+
+// CheckTimestampMonotonic 检查音频、视频各自的时间戳是否单调不减
+//
+func CheckTimestampMonotonic(tags []httpflv.Tag) error {
+	last := make(map[uint8]uint32)
+	for i, tag := range tags {
+		t := tag.Header.Type
+		if t != httpflv.TagTypeVideo && t != httpflv.TagTypeAudio {
+			continue
+		}
+		if prev, ok := last[t]; ok && tag.Header.Timestamp < prev {
+			return fmt.Errorf("timestamp rollback. index=%d, type=%d, prev=%d, curr=%d", i, t, prev, tag.Header.Timestamp)
+		}
+		last[t] = tag.Header.Timestamp
+	}
+	return nil
+}
+
+// CheckSameTags 检查`actual`和`expected`的类型、时间戳、内容是否完全一致
+//
+func CheckSameTags(expected, actual []httpflv.Tag) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("tag count mismatch. expected=%d, actual=%d", len(expected), len(actual))
+	}
+	for i := range expected {
+		e, a := &expected[i], &actual[i]
+		if e.Header.Type != a.Header.Type || e.Header.Timestamp != a.Header.Timestamp {
+			return fmt.Errorf("tag header mismatch. index=%d, expected=%+v, actual=%+v", i, e.Header, a.Header)
+		}
+		if string(e.Payload()) != string(a.Payload()) {
+			return fmt.Errorf("tag payload mismatch. index=%d, header=%+v", i, e.Header)
+		}
+	}
+	return nil
+}
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// CheckTsSegment 检查ts切片是否由完整的188字节的包组成，并且都以同步字节开始
+//
+func CheckTsSegment(b []byte) error {
+	if len(b) == 0 || len(b)%tsPacketSize != 0 {
+		return fmt.Errorf("ts size invalid. size=%d", len(b))
+	}
+	for i := 0; i < len(b); i += tsPacketSize {
+		if b[i] != tsSyncByte {
+			return fmt.Errorf("ts sync byte invalid. offset=%d", i)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// AssertFrameCount 断言音视频帧（不包含seq header）的数量
+//
+func AssertFrameCount(t testing.TB, tags []httpflv.Tag, video int, audio int) {
+	t.Helper()
+	stat := StatTags(tags)
+	if stat.Video != video || stat.Audio != audio {
+		t.Errorf("frame count mismatch. expected video=%d audio=%d, actual video=%d audio=%d", video, audio, stat.Video, stat.Audio)
+	}
+}
+
+func AssertTimestampMonotonic(t testing.TB, tags []httpflv.Tag) {
+	t.Helper()
+	if err := CheckTimestampMonotonic(tags); err != nil {
+		t.Error(err)
+	}
+}
+
+func AssertSameTags(t testing.TB, expected, actual []httpflv.Tag) {
+	t.Helper()
+	if err := CheckSameTags(expected, actual); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+var clientTimeoutMs = 5000
+
+// PushRtmp 使用rtmp推送`tags`，全部发送完成后关闭推流
+//
+// @param realtime 为true时按tag的时间戳控制发送速度，否则一次性全部发送
+//
+func PushRtmp(rawUrl string, tags []httpflv.Tag, realtime bool) error {
+	session := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMs = clientTimeoutMs
+	})
+	if err := session.Push(rawUrl); err != nil {
+		return err
+	}
+	defer session.Dispose()
+
+	start := time.Now()
+	for _, tag := range tags {
+		if realtime {
+			if d := time.Duration(tag.Header.Timestamp)*time.Millisecond - time.Since(start); d > 0 {
+				_ = session.Flush()
+				time.Sleep(d)
+			}
+		}
+		if err := session.Write(remux.FlvTag2RtmpChunks(tag)); err != nil {
+			return err
+		}
+	}
+	return session.Flush()
+}
+
+// Puller 拉流客户端的公共部分，收集拉取到的tag
+//
+// rtmp拉流收到的消息也转换为flv tag，使得不同协议拉流的结果可以直接比较
+//
+type Puller struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	tags  []httpflv.Tag
+	err   error
+	done  bool
+
+	dispose func() error
+}
+
+func newPuller() *Puller {
+	p := &Puller{}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+// PullHttpflv 开始httpflv拉流，返回时已经收到http响应
+//
+func PullHttpflv(rawUrl string) (*Puller, error) {
+	p := newPuller()
+	session := httpflv.NewPullSession(func(option *httpflv.PullSessionOption) {
+		option.PullTimeoutMs = clientTimeoutMs
+	})
+	if err := session.Pull(rawUrl, func(tag httpflv.Tag) {
+		p.onTag(tag)
+	}); err != nil {
+		return nil, err
+	}
+	p.dispose = session.Dispose
+	go p.wait(session.WaitChan())
+	return p, nil
+}
+
+// PullRtmp 开始rtmp拉流，返回时已经收到play的结果
+//
+func PullRtmp(rawUrl string) (*Puller, error) {
+	p := newPuller()
+	session := rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
+		option.PullTimeoutMs = clientTimeoutMs
+	})
+	if err := session.Pull(rawUrl, func(msg base.RtmpMsg) {
+		p.onTag(*remux.RtmpMsg2FlvTag(msg))
+	}); err != nil {
+		return nil, err
+	}
+	p.dispose = session.Dispose
+	go p.wait(session.WaitChan())
+	return p, nil
+}
+
+// Tags 到目前为止收到的tag
+//
+func (p *Puller) Tags() []httpflv.Tag {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	out := make([]httpflv.Tag, len(p.tags))
+	copy(out, p.tags)
+	return out
+}
+
+// WaitTagCount 等待收到的tag数量达到`n`
+//
+// 拉流结束或者超时时返回错误
+//
+func (p *Puller) WaitTagCount(n int, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		p.mutex.Lock()
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for len(p.tags) < n {
+		if p.done {
+			return fmt.Errorf("pull done before enough tags. expected=%d, actual=%d, err=%v", n, len(p.tags), p.err)
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("wait tags timeout. expected=%d, actual=%d", n, len(p.tags))
+		}
+		p.cond.Wait()
+	}
+	return nil
+}
+
+func (p *Puller) Close() error {
+	return p.dispose()
+}
+
+func (p *Puller) onTag(tag httpflv.Tag) {
+	// 回调结束后session会复用tag的内存
+	raw := make([]byte, len(tag.Raw))
+	copy(raw, tag.Raw)
+	tag.Raw = raw
+
+	p.mutex.Lock()
+	p.tags = append(p.tags, tag)
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+func (p *Puller) wait(ch <-chan error) {
+	err := <-ch
+	p.mutex.Lock()
+	p.done = true
+	p.err = err
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type HlsPlaylist struct {
+	Content  string
+	Segments []string // ts的完整url，按m3u8中的顺序
+}
+
+// FetchHlsPlaylist 下载m3u8，并将其中的ts地址转换为完整的url
+//
+func FetchHlsPlaylist(m3u8Url string) (*HlsPlaylist, error) {
+	b, err := httpGet(m3u8Url)
+	if err != nil {
+		return nil, err
+	}
+	baseUrl, err := url.Parse(m3u8Url)
+	if err != nil {
+		return nil, err
+	}
+	pl := &HlsPlaylist{Content: string(b)}
+	for _, line := range strings.Split(pl.Content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := baseUrl.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		pl.Segments = append(pl.Segments, u.String())
+	}
+	return pl, nil
+}
+
+// WaitHlsSegments 轮询m3u8，直到其中至少有`n`个ts
+//
+func WaitHlsSegments(m3u8Url string, n int, timeout time.Duration) (*HlsPlaylist, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		pl, err := FetchHlsPlaylist(m3u8Url)
+		if err == nil && len(pl.Segments) >= n {
+			return pl, nil
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("wait hls segments timeout. expected=%d, actual=%d", n, len(pl.Segments))
+			}
+			return pl, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// FetchHlsSegment 下载ts
+//
+func FetchHlsSegment(tsUrl string) ([]byte, error) {
+	return httpGet(tsUrl)
+}
+
+func httpGet(rawUrl string) ([]byte, error) {
+	client := http.Client{Timeout: time.Duration(clientTimeoutMs) * time.Millisecond}
+	resp, err := client.Get(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return b, fmt.Errorf("http status code invalid. url=%s, code=%d", rawUrl, resp.StatusCode)
+	}
+	return b, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package integration
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGenerateTags(t *testing.T) {
+	tags := GenerateTags(DefaultStreamOption)
+	stat := StatTags(tags)
+	assert.Equal(t, 1, stat.Metadata)
+	assert.Equal(t, 1, stat.VideoSeqHeader)
+	assert.Equal(t, 1, stat.AudioSeqHeader)
+	assert.Equal(t, 75, stat.Video)
+	assert.Equal(t, 3, stat.VideoKey)
+	assert.Equal(t, 130, stat.Audio)
+	assert.Equal(t, uint32(2960), stat.LastVideoTs)
+	assert.Equal(t, nil, CheckTimestampMonotonic(tags))
+
+	tags[len(tags)-1].Header.Timestamp = 0
+	assert.IsNotNil(t, CheckTimestampMonotonic(tags))
+}
+
+func TestPushPull(t *testing.T) {
+	s := StartServer(t)
+	defer s.Close()
+
+	tags := GenerateTags(DefaultStreamOption)
+	stat := StatTags(tags)
+
+	flvPuller, err := PullHttpflv(s.HttpflvUrl("test110"))
+	assert.Equal(t, nil, err)
+	defer flvPuller.Close()
+	rtmpPuller, err := PullRtmp(s.RtmpUrl("test110"))
+	assert.Equal(t, nil, err)
+	defer rtmpPuller.Close()
+
+	assert.Equal(t, nil, PushRtmp(s.RtmpUrl("test110"), tags, false))
+
+	// 拉流收到的tag和推流的完全一致
+	assert.Equal(t, nil, flvPuller.WaitTagCount(len(tags), 5*time.Second))
+	AssertSameTags(t, tags, flvPuller.Tags())
+
+	// rtmp拉流转换为flv tag后，帧数和时间戳与推流一致
+	assert.Equal(t, nil, rtmpPuller.WaitTagCount(len(tags), 5*time.Second))
+	AssertFrameCount(t, rtmpPuller.Tags(), stat.Video, stat.Audio)
+	AssertTimestampMonotonic(t, rtmpPuller.Tags())
+}
+
+func TestHls(t *testing.T) {
+	s := StartServer(t)
+	defer s.Close()
+
+	option := DefaultStreamOption
+	option.DurationMs = 4000
+	go func() {
+		_ = PushRtmp(s.RtmpUrl("test110"), GenerateTags(option), true)
+	}()
+
+	pl, err := WaitHlsSegments(s.HlsUrl("test110"), 2, 10*time.Second)
+	assert.Equal(t, nil, err)
+	b, err := FetchHlsSegment(pl.Segments[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, CheckTsSegment(b))
+
+	b, err = httpGet(s.HttpApiUrl("/api/stat/group?stream_name=test110"))
+	assert.Equal(t, nil, err)
+	var resp base.ApiStatGroup
+	assert.Equal(t, nil, json.Unmarshal(b, &resp))
+	assert.Equal(t, "test110", resp.Data.StreamName)
+}
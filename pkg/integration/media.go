@@ -0,0 +1,153 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package integration
+
+import (
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// 生成测试用的flv tag，不依赖外部的测试文件
+//
+// 视频为h264，sps、pps是真实可解析的（1280x720），nalu内容为填充数据；音频为aac（44100Hz、双声道），每帧1024个采样。
+// 对于lalserver来说只做转发和封装格式转换，不做解码，所以足以覆盖推拉流、hls切片等流程
+
+type StreamOption struct {
+	DurationMs      int  // 音视频帧的总时长
+	VideoFps        int  // 为0表示没有视频
+	GopMs           int  // 关键帧间隔
+	AudioEnable     bool // 是否有音频
+	MetadataEnable  bool // 是否在最前面加上metadata
+	VideoFrameBytes int  // 每个视频帧nalu的大小
+}
+
+var DefaultStreamOption = StreamOption{
+	DurationMs:      3000,
+	VideoFps:        25,
+	GopMs:           1000,
+	AudioEnable:     true,
+	MetadataEnable:  true,
+	VideoFrameBytes: 1024,
+}
+
+var (
+	testSps = []byte{0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96}
+	testPps = []byte{0x68, 0xEB, 0xEC, 0xB2, 0x2C}
+	testAsc = []byte{0x12, 0x10}
+
+	audioFrameDurationMs = float64(1024) * 1000 / 44100
+)
+
+// GenerateTags 按`option`生成flv tag序列：metadata、视频seq header、音频seq header，以及按时间戳顺序交错的音视频帧
+//
+// 第一个视频帧为关键帧，之后每`GopMs`一个关键帧
+//
+func GenerateTags(option StreamOption) []httpflv.Tag {
+	var tags []httpflv.Tag
+	hasVideo := option.VideoFps > 0
+
+	if option.MetadataEnable {
+		videoCodecId := -1
+		if hasVideo {
+			videoCodecId = int(base.RtmpCodecIdAvc)
+		}
+		audioCodecId := -1
+		if option.AudioEnable {
+			audioCodecId = int(base.RtmpSoundFormatAac)
+		}
+		b, err := rtmp.BuildMetadata(1280, 720, audioCodecId, videoCodecId)
+		if err == nil {
+			tags = append(tags, newTag(httpflv.TagTypeMetadata, 0, b))
+		}
+	}
+	if hasVideo {
+		b, _ := avc.BuildSeqHeaderFromSpsPps(testSps, testPps)
+		tags = append(tags, newTag(httpflv.TagTypeVideo, 0, b))
+	}
+	if option.AudioEnable {
+		b, _ := aac.MakeAudioDataSeqHeaderWithAsc(testAsc)
+		tags = append(tags, newTag(httpflv.TagTypeAudio, 0, b))
+	}
+
+	var videoIndex, audioIndex int
+	lastKeyTs := -option.GopMs
+	for {
+		videoTs, audioTs := -1, -1
+		if hasVideo {
+			if ts := videoIndex * 1000 / option.VideoFps; ts < option.DurationMs {
+				videoTs = ts
+			}
+		}
+		if option.AudioEnable {
+			if ts := int(float64(audioIndex) * audioFrameDurationMs); ts < option.DurationMs {
+				audioTs = ts
+			}
+		}
+		if videoTs < 0 && audioTs < 0 {
+			break
+		}
+
+		if videoTs >= 0 && (audioTs < 0 || videoTs <= audioTs) {
+			key := videoIndex == 0 || (option.GopMs > 0 && videoTs-lastKeyTs >= option.GopMs)
+			if key {
+				lastKeyTs = videoTs
+			}
+			tags = append(tags, newTag(httpflv.TagTypeVideo, uint32(videoTs), videoFrame(key, option.VideoFrameBytes)))
+			videoIndex++
+		} else {
+			tags = append(tags, newTag(httpflv.TagTypeAudio, uint32(audioTs), audioFrame()))
+			audioIndex++
+		}
+	}
+	return tags
+}
+
+func newTag(t uint8, timestamp uint32, payload []byte) httpflv.Tag {
+	return httpflv.Tag{
+		Header: httpflv.TagHeader{
+			Type:      t,
+			DataSize:  uint32(len(payload)),
+			Timestamp: timestamp,
+		},
+		Raw: httpflv.PackHttpflvTag(t, timestamp, payload),
+	}
+}
+
+func videoFrame(key bool, naluBytes int) []byte {
+	if naluBytes < 2 {
+		naluBytes = 2
+	}
+	b := make([]byte, 5+4+naluBytes)
+	if key {
+		b[0] = httpflv.AvcKeyFrame
+		b[9] = avc.NaluTypeIdrSlice
+	} else {
+		b[0] = httpflv.AvcInterFrame
+		b[9] = avc.NaluTypeSlice
+	}
+	b[1] = httpflv.AvcPacketTypeNalu
+	bele.BePutUint32(b[5:], uint32(naluBytes))
+	// nal_ref_idc为3
+	b[9] |= 0x60
+	for i := 10; i < len(b); i++ {
+		b[i] = 0xAA
+	}
+	return b
+}
+
+func audioFrame() []byte {
+	b := make([]byte, 2+64)
+	b[0] = 0xaf
+	b[1] = httpflv.AacPacketTypeRaw
+	return b
+}
@@ -0,0 +1,262 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/logic"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// integration 端到端集成测试的辅助包
+//
+// 在测试进程内启动一个lalserver（所有监听使用随机端口），并提供推拉流的客户端、hls的下载，以及帧数、时间戳的断言，
+// 使得需要完整链路的功能（比如dispatch的回源、转推流程）可以被自动化测试覆盖。
+//
+// 基本用法：
+//   s := integration.StartServer(t)
+//   defer s.Close()
+//   puller, _ := integration.PullHttpflv(s.HttpflvUrl("test110"))
+//   _ = integration.PushRtmp(s.RtmpUrl("test110"), integration.GenerateTags(integration.DefaultStreamOption), false)
+//   _ = puller.WaitTagCount(n, 5*time.Second)
+//   integration.AssertTimestampMonotonic(t, puller.Tags())
+//
+
+// 注意，lalserver使用全局的日志对象，只在第一次 StartServer 时使用其配置中的`log`初始化一次，
+// 之后的lalserver不再重新初始化（见 logic.Option.SkipLogInit ），避免和前一个lalserver残留的session打印日志竞争
+//
+
+// 等待lalserver监听成功的最长时间
+var serverReadyTimeout = 5 * time.Second
+
+var initLogOnce sync.Once
+
+type Server struct {
+	t      testing.TB
+	sm     *logic.ServerManager
+	outDir string
+
+	RtmpAddr    string
+	HttpAddr    string // httpflv、httpts、hls共用
+	HttpApiAddr string
+
+	doneChan chan error
+}
+
+// DefaultConfig 集成测试使用的默认配置
+//
+// 开启rtmp、httpflv、httpts、hls、http_api，其他功能都关闭，监听地址由 StartServer 分配。
+// hls切片时长较短，使得测试能尽快拿到切片
+//
+func DefaultConfig() *logic.Config {
+	c := &logic.Config{}
+	c.ConfVersion = logic.ConfVersion
+	c.ServerId = "integration"
+
+	c.RtmpConfig.Enable = true
+
+	c.HttpflvConfig.Enable = true
+	c.HttpflvConfig.UrlPattern = "/"
+	c.HttpflvConfig.HttpHeader = base.DefaultHttpHeaderOption
+
+	c.HttptsConfig.Enable = true
+	c.HttptsConfig.UrlPattern = "/"
+	c.HttptsConfig.HttpHeader = base.DefaultHttpHeaderOption
+
+	c.HlsConfig.Enable = true
+	c.HlsConfig.UrlPattern = "/hls/"
+	c.HlsConfig.HttpHeader = base.DefaultHttpHeaderOption
+	c.HlsConfig.FragmentDurationMs = 1000
+	c.HlsConfig.FragmentNum = 6
+	c.HlsConfig.DeleteThreshold = 6
+	c.HlsConfig.CleanupMode = 0
+
+	c.HttpApiConfig.Enable = true
+
+	c.LogConfig = nazalog.Option{
+		Level:               nazalog.LevelWarn,
+		IsToStdout:          true,
+		ShortFileFlag:       true,
+		TimestampFlag:       true,
+		TimestampWithMsFlag: true,
+		LevelFlag:           true,
+		AssertBehavior:      nazalog.AssertError,
+	}
+	return c
+}
+
+// StartServer 在当前进程内启动lalserver，返回时各监听已经可以连接
+//
+// @param modConfig 在 DefaultConfig 的基础上修改配置。注意，监听地址、输出目录在调用之前已经填好，一般不需要修改
+//
+func StartServer(t testing.TB, modConfig ...func(config *logic.Config)) *Server {
+	t.Helper()
+
+	outDir, err := ioutil.TempDir("", "lal_integration")
+	if err != nil {
+		t.Fatalf("create temp dir failed. err=%+v", err)
+	}
+	s := &Server{
+		t:        t,
+		outDir:   outDir,
+		doneChan: make(chan error, 1),
+	}
+	for _, p := range []*string{&s.RtmpAddr, &s.HttpAddr, &s.HttpApiAddr} {
+		if *p, err = FreeAddr(); err != nil {
+			t.Fatalf("alloc free addr failed. err=%+v", err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.RtmpConfig.Addr = s.RtmpAddr
+	config.DefaultHttpConfig.HttpListenAddr = s.HttpAddr
+	config.HttpApiConfig.Addr = s.HttpApiAddr
+	config.HlsConfig.OutPath = filepath.Join(outDir, "hls") + string(filepath.Separator)
+	config.RecordConfig.FlvOutPath = filepath.Join(outDir, "flv")
+	config.RecordConfig.MpegtsOutPath = filepath.Join(outDir, "mpegts")
+	for _, fn := range modConfig {
+		fn(config)
+	}
+
+	rawContent, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("marshal config failed. err=%+v", err)
+	}
+	initLogOnce.Do(func() {
+		if err := logic.Log.Init(func(option *nazalog.Option) {
+			*option = config.LogConfig
+		}); err != nil {
+			t.Fatalf("init log failed. err=%+v", err)
+		}
+	})
+	s.sm = logic.NewServerManager(func(option *logic.Option) {
+		option.ConfRawContent = rawContent
+		option.SkipLogInit = true
+	})
+	go func() {
+		s.doneChan <- s.sm.RunLoop()
+	}()
+
+	final := s.sm.Config()
+	var addrs []string
+	if final.RtmpConfig.Enable {
+		addrs = append(addrs, final.RtmpConfig.Addr)
+	}
+	if final.HttpflvConfig.Enable || final.HttptsConfig.Enable || final.HlsConfig.Enable {
+		addrs = append(addrs, s.HttpAddr)
+	}
+	if final.HttpApiConfig.Enable {
+		addrs = append(addrs, final.HttpApiConfig.Addr)
+	}
+	for _, addr := range addrs {
+		if err := s.waitListen(addr); err != nil {
+			s.Close()
+			t.Fatalf("wait server listen failed. addr=%s, err=%+v", addr, err)
+		}
+	}
+	return s
+}
+
+func (s *Server) ServerManager() *logic.ServerManager {
+	return s.sm
+}
+
+func (s *Server) Config() *logic.Config {
+	return s.sm.Config()
+}
+
+// OutDir hls、录制文件的输出根目录，Close时删除
+//
+func (s *Server) OutDir() string {
+	return s.outDir
+}
+
+func (s *Server) RtmpUrl(streamName string) string {
+	return fmt.Sprintf("rtmp://%s/live/%s", s.RtmpAddr, streamName)
+}
+
+func (s *Server) HttpflvUrl(streamName string) string {
+	return fmt.Sprintf("http://%s/live/%s.flv", s.HttpAddr, streamName)
+}
+
+func (s *Server) HttptsUrl(streamName string) string {
+	return fmt.Sprintf("http://%s/live/%s.ts", s.HttpAddr, streamName)
+}
+
+func (s *Server) HlsUrl(streamName string) string {
+	return fmt.Sprintf("http://%s/hls/%s/playlist.m3u8", s.HttpAddr, streamName)
+}
+
+// HttpApiUrl
+//
+// @param path 比如`/api/stat/all_group`
+//
+func (s *Server) HttpApiUrl(path string) string {
+	return fmt.Sprintf("http://%s%s", s.HttpApiAddr, path)
+}
+
+// Close 关闭lalserver并删除输出目录，可以多次调用
+//
+func (s *Server) Close() {
+	if s.sm == nil {
+		return
+	}
+	s.sm.Dispose()
+	select {
+	case <-s.doneChan:
+	case <-time.After(serverReadyTimeout):
+		s.t.Errorf("wait server exit timeout")
+	}
+	s.sm = nil
+	_ = os.RemoveAll(s.outDir)
+}
+
+func (s *Server) waitListen(addr string) error {
+	deadline := time.Now().Add(serverReadyTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		select {
+		case err := <-s.doneChan:
+			s.doneChan <- err
+			return fmt.Errorf("server exit. err=%+v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// FreeAddr 分配一个本机空闲的tcp端口，返回`127.0.0.1:{port}`
+//
+// 注意，端口在返回前已经释放，理论上有被其他程序抢占的可能，测试场景下可以忽略
+//
+func FreeAddr() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	SubRejectReasonEgressBudget = "egress_budget"
+
+	defaultAdmissionSubBitrateKbits = 2000
+	defaultAdmissionWarmupSec       = 5
+	admissionNotifyInterval         = time.Second
+)
+
+// AdmissionController 按节点出口带宽做拉流准入控制，预估的出口码率超过`egress_budget_kbits`时拒绝新的拉流，
+// 并发送on_sub_reject事件，调度服务可以据此将观众调度到其他节点
+//
+// 预估的出口码率 = 最近一次统计的所有拉流的码率之和 + 还在预热期的拉流的预估码率 + 新拉流的预估码率。
+// 新拉流的预估码率使用该路流的输入码率，还没有统计到时使用`default_sub_bitrate_kbits`。
+// 新接入的拉流的码率需要一段时间才能统计到，所以在`warmup_sec`内按预估码率计入，预热期内可能重复计算，结果偏保守。
+//
+// 注意，只对rtmp、rtsp、httpflv、httpts拉流生效，不包含hls。hls是无状态的短连接，播放器会周期性地重复请求m3u8，
+// 无法区分新的观众和已有观众的刷新，并且hls的出口码率也不在group的统计中，所以hls观众不受出口带宽上限的限制
+//
+// 方法可以在nil上调用，此时表示没有开启，全部允许
+//
+// 并发安全
+//
+type AdmissionController struct {
+	config AdmissionConfig
+
+	mutex        sync.Mutex
+	egressKbits  int                         // 最近一次 Update 统计的出口码率
+	streamKbits  map[string]int              // key: 流名称，value: 输入流码率
+	reservations []admissionReservation      // 还在预热期的拉流
+	rejects      map[string]*admissionReject // key: 流名称
+}
+
+type admissionReservation struct {
+	kbits      int
+	expireTime time.Time
+}
+
+// admissionReject 同一路流按 admissionNotifyInterval 的间隔合并通知
+//
+type admissionReject struct {
+	info           base.SubRejectInfo // 最近一次拒绝的信息
+	count          int                // 还没有通知的拒绝次数
+	lastNotifyTime time.Time
+}
+
+func NewAdmissionController(config AdmissionConfig) *AdmissionController {
+	if !config.Enable {
+		return nil
+	}
+	if config.DefaultSubBitrateKbits <= 0 {
+		config.DefaultSubBitrateKbits = defaultAdmissionSubBitrateKbits
+	}
+	if config.WarmupSec <= 0 {
+		config.WarmupSec = defaultAdmissionWarmupSec
+	}
+	return &AdmissionController{
+		config:      config,
+		streamKbits: make(map[string]int),
+		rejects:     make(map[string]*admissionReject),
+	}
+}
+
+// Check 判断是否允许新的拉流接入，允许时为其预留码率
+//
+// @return notify 不为nil时，需要发送on_sub_reject事件
+//
+func (a *AdmissionController) Check(info base.SessionEventCommonInfo, now time.Time) (notify *base.SubRejectInfo, err error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.expireReservations(now)
+	kbits, ok := a.streamKbits[info.StreamName]
+	if !ok || kbits <= 0 {
+		kbits = a.config.DefaultSubBitrateKbits
+	}
+	projected := a.egressKbits
+	for _, r := range a.reservations {
+		projected += r.kbits
+	}
+	if projected+kbits <= a.config.EgressBudgetKbits {
+		a.reservations = append(a.reservations, admissionReservation{
+			kbits:      kbits,
+			expireTime: now.Add(time.Duration(a.config.WarmupSec) * time.Second),
+		})
+		return nil, nil
+	}
+
+	err = fmt.Errorf("%w. egress=%dkbit/s, stream=%dkbit/s, budget=%dkbit/s",
+		base.ErrAdmissionRejected, projected, kbits, a.config.EgressBudgetKbits)
+
+	r, ok := a.rejects[info.StreamName]
+	if !ok {
+		r = &admissionReject{}
+		a.rejects[info.StreamName] = r
+	}
+	r.count++
+	r.info = base.SubRejectInfo{
+		SessionEventCommonInfo: info,
+		Reason:                 SubRejectReasonEgressBudget,
+		EgressKbits:            projected,
+		StreamKbits:            kbits,
+		BudgetKbits:            a.config.EgressBudgetKbits,
+	}
+	if now.Sub(r.lastNotifyTime) >= admissionNotifyInterval {
+		notify = r.flush(now)
+	}
+	return
+}
+
+// Update 使用最新的统计信息更新出口码率和各路流的码率，需要定时调用
+//
+// @return 合并后到期需要发送的on_sub_reject事件
+//
+func (a *AdmissionController) Update(groups []base.StatGroup, now time.Time) (notifies []base.SubRejectInfo) {
+	if a == nil {
+		return nil
+	}
+
+	egress := summarizeServerLoad(groups).WriteBitrate
+	streamKbits := make(map[string]int, len(groups))
+	for i := range groups {
+		g := &groups[i]
+		if g.StatPub.SessionId != "" {
+			streamKbits[g.StreamName] = g.StatPub.ReadBitrate
+		} else if g.StatPull.SessionId != "" {
+			streamKbits[g.StreamName] = g.StatPull.ReadBitrate
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.egressKbits = egress
+	a.streamKbits = streamKbits
+	a.expireReservations(now)
+	for k, r := range a.rejects {
+		if now.Sub(r.lastNotifyTime) < admissionNotifyInterval {
+			continue
+		}
+		if r.count == 0 {
+			delete(a.rejects, k)
+			continue
+		}
+		notifies = append(notifies, *r.flush(now))
+	}
+	return
+}
+
+func (a *AdmissionController) expireReservations(now time.Time) {
+	i := 0
+	for _, r := range a.reservations {
+		if now.Before(r.expireTime) {
+			a.reservations[i] = r
+			i++
+		}
+	}
+	a.reservations = a.reservations[:i]
+}
+
+func (r *admissionReject) flush(now time.Time) *base.SubRejectInfo {
+	info := r.info
+	info.RejectCount = r.count
+	r.count = 0
+	r.lastNotifyTime = now
+	return &info
+}
@@ -0,0 +1,87 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAdmissionController(t *testing.T) {
+	// 没有开启
+	var nilAc *AdmissionController
+	assert.Equal(t, true, NewAdmissionController(AdmissionConfig{}) == nil)
+	notify, err := nilAc.Check(base.SessionEventCommonInfo{StreamName: "test110"}, time.Now())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, notify == nil)
+	assert.Equal(t, 0, len(nilAc.Update(nil, time.Now())))
+
+	ac := NewAdmissionController(AdmissionConfig{
+		Enable:                 true,
+		EgressBudgetKbits:      10000,
+		DefaultSubBitrateKbits: 3000,
+		WarmupSec:              5,
+	})
+	now := time.Now()
+	ac.Update([]base.StatGroup{
+		{
+			StreamName: "test110",
+			StatPub:    base.StatPub{StatSession: base.StatSession{SessionId: "RTMPPUBSUB1", ReadBitrate: 2000}},
+			StatSubs:   []base.StatSub{{StatSession: base.StatSession{SessionId: "FLVSUB1", WriteBitrate: 2000}}},
+		},
+	}, now)
+
+	// 2000 + 2000 + 2000 + 2000 = 8000
+	for i := 0; i < 3; i++ {
+		notify, err = ac.Check(base.SessionEventCommonInfo{StreamName: "test110"}, now)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, notify == nil)
+	}
+	// 码率还没有统计到的流，使用默认码率：8000 + 3000 > 10000
+	info := base.SessionEventCommonInfo{ServerId: "1", SessionId: "FLVSUB2", StreamName: "test111"}
+	notify, err = ac.Check(info, now)
+	assert.Equal(t, true, errors.Is(err, base.ErrAdmissionRejected))
+	assert.Equal(t, "FLVSUB2", notify.SessionId)
+	assert.Equal(t, SubRejectReasonEgressBudget, notify.Reason)
+	assert.Equal(t, 8000, notify.EgressKbits)
+	assert.Equal(t, 3000, notify.StreamKbits)
+	assert.Equal(t, 10000, notify.BudgetKbits)
+	assert.Equal(t, 1, notify.RejectCount)
+
+	// 同一路流1秒内合并通知
+	for i := 0; i < 2; i++ {
+		notify, err = ac.Check(info, now.Add(100*time.Millisecond))
+		assert.Equal(t, true, errors.Is(err, base.ErrAdmissionRejected))
+		assert.Equal(t, true, notify == nil)
+	}
+	assert.Equal(t, 0, len(ac.Update(nil, now.Add(500*time.Millisecond))))
+
+	// 预热期结束，只使用统计到的码率
+	notifies := ac.Update([]base.StatGroup{
+		{
+			StreamName: "test110",
+			StatPub:    base.StatPub{StatSession: base.StatSession{SessionId: "RTMPPUBSUB1", ReadBitrate: 2000}},
+			StatSubs:   []base.StatSub{{StatSession: base.StatSession{SessionId: "FLVSUB1", WriteBitrate: 8000}}},
+		},
+	}, now.Add(6*time.Second))
+	assert.Equal(t, 1, len(notifies))
+	assert.Equal(t, 2, notifies[0].RejectCount)
+	_, err = ac.Check(base.SessionEventCommonInfo{StreamName: "test110"}, now.Add(6*time.Second))
+	assert.Equal(t, nil, err)
+	_, err = ac.Check(base.SessionEventCommonInfo{StreamName: "test110"}, now.Add(6*time.Second))
+	assert.Equal(t, true, errors.Is(err, base.ErrAdmissionRejected))
+
+	// 没有待通知的拒绝时清理
+	assert.Equal(t, 0, len(ac.Update(nil, now.Add(8*time.Second))))
+	assert.Equal(t, 0, len(ac.rejects))
+}
@@ -0,0 +1,212 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	defaultAuditMaxEntries  = 1000
+	defaultAuditActorHeader = "X-Lal-Operator"
+
+	auditRedacted = "***"
+)
+
+// auditSecretFields 请求中这些字段的值不记录到审计记录中，比如`/api/ctrl/rotate_stream_key`中的推流密钥
+var auditSecretFields = map[string]struct{}{
+	"stream_key": {},
+	"token":      {},
+	"password":   {},
+	"secret":     {},
+}
+
+// AuditLog 记录http api中ctrl类型接口的调用，内存中保留最近的`max_entries`条，可选地追加写入文件
+//
+type AuditLog struct {
+	config AuditConfig
+
+	mutex   sync.Mutex
+	entries []base.AuditEntry // 按时间从旧到新
+	fp      *os.File
+}
+
+func NewAuditLog(config AuditConfig) *AuditLog {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultAuditMaxEntries
+	}
+	if config.ActorHeader == "" {
+		config.ActorHeader = defaultAuditActorHeader
+	}
+	a := &AuditLog{
+		config: config,
+	}
+	if config.File != "" {
+		fp, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			Log.Errorf("open audit file failed. file=%s, err=%+v", config.File, err)
+		} else {
+			a.fp = fp
+		}
+	}
+	return a
+}
+
+// Wrap 包装ctrl类型接口的handler，在handler处理完成后记录一条审计记录
+//
+func (a *AuditLog) Wrap(api string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		entry := base.AuditEntry{
+			Time:       time.Now().Format("2006-01-02 15:04:05.000"),
+			Actor:      req.Header.Get(a.config.ActorHeader),
+			RemoteAddr: req.RemoteAddr,
+			Api:        api,
+			RequestId:  requestIdFromContext(req.Context()),
+		}
+		if req.Body != nil {
+			body, _ := ioutil.ReadAll(req.Body)
+			_ = req.Body.Close()
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			entry.Request = redactAuditJson(body)
+		}
+		if entry.Request == "" {
+			entry.Request = redactAuditQuery(req.URL.RawQuery)
+		}
+
+		aw := &auditResponseWriter{ResponseWriter: w}
+		next(aw, req)
+
+		var resp base.HttpResponseBasic
+		if err := json.Unmarshal(aw.body.Bytes(), &resp); err == nil {
+			entry.ErrorCode = resp.ErrorCode
+			entry.Desp = resp.Desp
+		}
+		a.Add(entry)
+	}
+}
+
+func (a *AuditLog) Add(entry base.AuditEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.entries) >= a.config.MaxEntries {
+		a.entries = append(a.entries[:0], a.entries[len(a.entries)-a.config.MaxEntries+1:]...)
+	}
+	a.entries = append(a.entries, entry)
+
+	if a.fp != nil {
+		b, _ := json.Marshal(entry)
+		if _, err := a.fp.Write(append(b, '\n')); err != nil {
+			Log.Errorf("write audit file failed. err=%+v", err)
+		}
+	}
+}
+
+// Query 查询审计记录，按时间从新到旧排列
+//
+// @param actor: 不为空时，只返回该调用方的记录
+// @param limit: 最多返回的条数，小于等于0时不限制
+//
+func (a *AuditLog) Query(actor string, limit int) (ret []base.AuditEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+		if actor != "" && a.entries[i].Actor != actor {
+			continue
+		}
+		ret = append(ret, a.entries[i])
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// redactAuditJson 将json请求中 auditSecretFields 字段的值替换为`***`，没有这些字段时原样返回
+//
+func redactAuditJson(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	if !redactAuditValue(v) {
+		return string(body)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return auditRedacted
+	}
+	return string(b)
+}
+
+func redactAuditValue(v interface{}) (redacted bool) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, item := range vv {
+			if _, ok := auditSecretFields[k]; ok {
+				vv[k] = auditRedacted
+				redacted = true
+				continue
+			}
+			if redactAuditValue(item) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, item := range vv {
+			if redactAuditValue(item) {
+				redacted = true
+			}
+		}
+	}
+	return
+}
+
+// redactAuditQuery 将query中 auditSecretFields 参数的值替换为`***`，没有这些参数时原样返回
+//
+func redactAuditQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// 解析失败时无法判断是否包含敏感参数，不记录
+		return auditRedacted
+	}
+	var redacted bool
+	for k := range values {
+		if _, ok := auditSecretFields[k]; ok {
+			values[k] = []string{auditRedacted}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+// auditResponseWriter 保存handler写入的响应内容，用于解析出调用结果
+type auditResponseWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,76 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	a := NewAuditLog(AuditConfig{MaxEntries: 2})
+	handler := a.Wrap("/api/ctrl/kick_out_session", func(w http.ResponseWriter, req *http.Request) {
+		// handler中依然能读取到完整的body
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, `{"stream_name":"test110"}`, string(body))
+		feedback(base.HttpResponseBasic{ErrorCode: base.ErrorCodeSessionNotFound, Desp: base.DespSessionNotFound}, w)
+	})
+
+	for _, actor := range []string{"alice", "bob", "alice"} {
+		req := httptest.NewRequest("POST", "/api/ctrl/kick_out_session", strings.NewReader(`{"stream_name":"test110"}`))
+		req.Header.Set("X-Lal-Operator", actor)
+		handler(httptest.NewRecorder(), req)
+	}
+
+	entries := a.Query("", 0)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "alice", entries[0].Actor)
+	assert.Equal(t, "bob", entries[1].Actor)
+	assert.Equal(t, "/api/ctrl/kick_out_session", entries[0].Api)
+	assert.Equal(t, `{"stream_name":"test110"}`, entries[0].Request)
+	assert.Equal(t, base.ErrorCodeSessionNotFound, entries[0].ErrorCode)
+
+	assert.Equal(t, 1, len(a.Query("bob", 0)))
+	assert.Equal(t, 1, len(a.Query("", 1)))
+}
+
+func TestAuditLog_Redact(t *testing.T) {
+	a := NewAuditLog(AuditConfig{})
+	handler := a.Wrap("/api/ctrl/rotate_stream_key", func(w http.ResponseWriter, req *http.Request) {
+		// handler中依然能读取到原始的密钥
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, true, strings.Contains(string(body)+req.URL.RawQuery, "mysecretkey"))
+		feedback(base.HttpResponseBasic{ErrorCode: base.ErrorCodeSucc, Desp: base.DespSucc}, w)
+	})
+
+	req := httptest.NewRequest("POST", "/api/ctrl/rotate_stream_key", strings.NewReader(`{"stream_name":"test110","stream_key":"mysecretkey"}`))
+	handler(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("POST", "/api/ctrl/rotate_stream_key", strings.NewReader(`{"items":[{"stream_name":"test110","stream_key":"mysecretkey"}]}`))
+	handler(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("GET", "/api/ctrl/rotate_stream_key?stream_name=test110&stream_key=mysecretkey", nil)
+	handler(httptest.NewRecorder(), req)
+
+	entries := a.Query("", 0)
+	assert.Equal(t, 3, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, false, strings.Contains(e.Request, "mysecretkey"))
+		assert.Equal(t, true, strings.Contains(e.Request, "test110"))
+	}
+	assert.Equal(t, `{"stream_key":"***","stream_name":"test110"}`, entries[2].Request)
+
+	// 不包含敏感字段时原样记录
+	assert.Equal(t, `{"stream_name":"test110"}`, redactAuditJson([]byte(`{"stream_name":"test110"}`)))
+	assert.Equal(t, "stream_name=test110", redactAuditQuery("stream_name=test110"))
+}
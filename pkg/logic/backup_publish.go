@@ -0,0 +1,195 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	// BackupRolePrimary BackupRoleBackup 推流url参数`role`的取值，不携带时为主推流
+	BackupRolePrimary = "primary"
+	BackupRoleBackup  = "backup"
+
+	BackupSwitchReasonStall     = "stall"      // 主推流断开，或者超过`stall_timeout_ms`没有数据
+	BackupSwitchReasonRecover   = "recover"    // 主推流恢复
+	BackupSwitchReasonBackupEnd = "backup_end" // 备推流断开，切换回主推流
+
+	defaultBackupStallTimeoutMs = 3000
+	defaultBackupStreamSuffix   = "__backup"
+
+	backupCheckInterval = 200 * time.Millisecond
+)
+
+// BackupPublisher 主备推流（热备）
+//
+// 备推流在推流url中携带`role=backup`参数，输入到名称为`<stream_name><backup_stream_suffix>`的group中。
+// 主推流卡顿或断开时，主推流的group切换到备推流的数据（见 CtrlSwitchSource ），主推流恢复后切换回来，sub不需要断开重连。
+// 每次切换发送http_notify.on_backup_switch事件。
+//
+type BackupPublisher struct {
+	sm     *ServerManager
+	config BackupPublishConfig
+
+	states map[string]*backupState // key: 主推流的流名称，只在RunLoop协程中访问
+
+	disposeOnce sync.Once
+	exitChan    chan struct{}
+}
+
+type backupState struct {
+	appName     string
+	inputCount  uint64
+	lastActive  time.Time // 主推流最近一次有数据的时间
+	usingBackup bool
+}
+
+func NewBackupPublisher(sm *ServerManager, config BackupPublishConfig) *BackupPublisher {
+	if config.StallTimeoutMs <= 0 {
+		config.StallTimeoutMs = defaultBackupStallTimeoutMs
+	}
+	if config.BackupStreamSuffix == "" {
+		config.BackupStreamSuffix = defaultBackupStreamSuffix
+	}
+	return &BackupPublisher{
+		sm:       sm,
+		config:   config,
+		states:   make(map[string]*backupState),
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (b *BackupPublisher) RunLoop() {
+	t := time.NewTicker(backupCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.exitChan:
+			return
+		case now := <-t.C:
+			b.check(now)
+		}
+	}
+}
+
+func (b *BackupPublisher) Dispose() {
+	b.disposeOnce.Do(func() {
+		close(b.exitChan)
+	})
+}
+
+// ApplyRole 推流url参数中携带`role=backup`时，将流名称修改为备推流的流名称
+//
+func (b *BackupPublisher) ApplyRole(info *base.SessionEventCommonInfo, session interface{ SetStreamName(string) }) {
+	values, err := url.ParseQuery(info.UrlParam)
+	if err != nil || values.Get("role") != BackupRoleBackup {
+		return
+	}
+	streamName := info.StreamName + b.config.BackupStreamSuffix
+	Log.Infof("[%s] backup publish. %s -> %s", info.SessionId, info.StreamName, streamName)
+	session.SetStreamName(streamName)
+	info.StreamName = streamName
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type backupPair struct {
+	name    string
+	primary *Group
+}
+
+func (b *BackupPublisher) check(now time.Time) {
+	// 找到所有有输入的备推流，以及对应的主推流group
+	var pairs []backupPair
+	var backups []*Group
+	b.sm.mutex.Lock()
+	b.sm.groupManager.Iterate(func(group *Group) bool {
+		if group.streamName != strings.TrimSuffix(group.streamName, b.config.BackupStreamSuffix) && group.HasInSession() {
+			backups = append(backups, group)
+		}
+		return true
+	})
+	// 注意，遍历时持有groupManager分片的锁，所以在遍历结束后再查找主推流group
+	for _, group := range backups {
+		name := strings.TrimSuffix(group.streamName, b.config.BackupStreamSuffix)
+		if primary := b.sm.getGroup(group.appName, name); primary != nil {
+			pairs = append(pairs, backupPair{name: name, primary: primary})
+		}
+	}
+	b.sm.mutex.Unlock()
+
+	seen := make(map[string]struct{}, len(pairs))
+	for _, p := range pairs {
+		seen[p.name] = struct{}{}
+		st, ok := b.states[p.name]
+		if !ok {
+			st = &backupState{appName: p.primary.appName, lastActive: now}
+			b.states[p.name] = st
+		}
+
+		hasIn, count := p.primary.OwnInputStat()
+		if hasIn && count != st.inputCount {
+			st.inputCount = count
+			st.lastActive = now
+		}
+		stalled := !hasIn || now.Sub(st.lastActive) >= time.Duration(b.config.StallTimeoutMs)*time.Millisecond
+
+		if !st.usingBackup && stalled {
+			b.switchSource(p.name, st, true, BackupSwitchReasonStall)
+		} else if st.usingBackup && !stalled && b.config.SwitchBack {
+			b.switchSource(p.name, st, false, BackupSwitchReasonRecover)
+		}
+	}
+
+	// 备推流断开时，主推流的group自动切换回自身的输入，见 Group.OnSourceEnd
+	for name, st := range b.states {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		if st.usingBackup {
+			b.notify(name, st, false, BackupSwitchReasonBackupEnd)
+		}
+		delete(b.states, name)
+	}
+}
+
+func (b *BackupPublisher) switchSource(name string, st *backupState, toBackup bool, reason string) {
+	info := base.ApiCtrlSwitchSource{StreamName: name}
+	if toBackup {
+		info.SourceStreamName = name + b.config.BackupStreamSuffix
+	}
+	if resp := b.sm.CtrlSwitchSource(info); resp.ErrorCode != base.ErrorCodeSucc {
+		Log.Warnf("backup publish switch source failed. stream=%s, to backup=%v, resp=%+v", name, toBackup, resp)
+		return
+	}
+	st.usingBackup = toBackup
+	b.notify(name, st, toBackup, reason)
+}
+
+func (b *BackupPublisher) notify(name string, st *backupState, toBackup bool, reason string) {
+	info := base.BackupSwitchInfo{
+		ServerId:         b.sm.config.ServerId,
+		AppName:          st.appName,
+		StreamName:       name,
+		BackupStreamName: name + b.config.BackupStreamSuffix,
+		From:             BackupRoleBackup,
+		To:               BackupRolePrimary,
+		Reason:           reason,
+	}
+	if toBackup {
+		info.From, info.To = info.To, info.From
+	}
+	Log.Infof("backup publish switch. info=%+v", info)
+	b.sm.option.NotifyHandler.OnBackupSwitch(info)
+}
@@ -0,0 +1,82 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testStreamNameSetter struct {
+	streamName string
+}
+
+func (s *testStreamNameSetter) SetStreamName(streamName string) {
+	s.streamName = streamName
+}
+
+func TestBackupPublisher(t *testing.T) {
+	sm := NewServerManager(func(option *Option) {
+		option.ConfRawContent = []byte(`{
+  "backup_publish": {"enable": true, "stall_timeout_ms": 1000, "switch_back": true},
+  "log": {"level": 2, "filename": "", "is_to_stdout": true}
+}`)
+	})
+	bp := sm.backupPublisher
+	assert.IsNotNil(t, bp)
+
+	// 携带role=backup参数时修改流名称
+	var setter testStreamNameSetter
+	info := base.SessionEventCommonInfo{StreamName: "test110", UrlParam: "token=abc&role=backup"}
+	bp.ApplyRole(&info, &setter)
+	assert.Equal(t, "test110__backup", info.StreamName)
+	assert.Equal(t, "test110__backup", setter.streamName)
+	info = base.SessionEventCommonInfo{StreamName: "test110", UrlParam: "role=primary"}
+	bp.ApplyRole(&info, &setter)
+	assert.Equal(t, "test110", info.StreamName)
+
+	primary, err := sm.AddCustomizePubSession("test110")
+	assert.Equal(t, nil, err)
+	backup, err := sm.AddCustomizePubSession("test110__backup")
+	assert.Equal(t, nil, err)
+	feed := func(ctx ICustomizePubSessionContext) {
+		ctx.FeedRtmpMsg(base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: 6},
+			Payload: []byte{0x27, 0x01, 0, 0, 0, 0x01},
+		})
+	}
+	group := sm.GetGroup("", "test110")
+	state := func() *backupState {
+		return bp.states["test110"]
+	}
+
+	now := time.Now()
+	feed(primary)
+	feed(backup)
+	bp.check(now)
+	assert.Equal(t, false, state().usingBackup)
+
+	// 主推流卡顿，切换到备推流
+	bp.check(now.Add(1500 * time.Millisecond))
+	assert.Equal(t, true, state().usingBackup)
+	assert.Equal(t, []*Group{sm.GetGroup("", "test110__backup")}, group.SwitchSourceGroups())
+
+	// 主推流恢复，切换回来
+	feed(primary)
+	bp.check(now.Add(1700 * time.Millisecond))
+	assert.Equal(t, false, state().usingBackup)
+
+	// 备推流断开
+	sm.DelCustomizePubSession(backup)
+	bp.check(now.Add(1900 * time.Millisecond))
+	assert.Equal(t, (*backupState)(nil), state())
+}
@@ -9,14 +9,18 @@
 package logic
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
 	"github.com/q191201771/naza/pkg/nazajson"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
@@ -28,6 +32,8 @@ const (
 	defaultHttpflvUrlPattern = "/live/"
 	defaultHttptsUrlPattern  = "/live/"
 	defaultHlsUrlPattern     = "/hls/"
+
+	defaultRecordRetentionCheckIntervalSec = 60
 )
 
 type Config struct {
@@ -49,6 +55,39 @@ type Config struct {
 	PprofConfig      PprofConfig      `json:"pprof"`
 	LogConfig        nazalog.Option   `json:"log"`
 	DebugConfig      DebugConfig      `json:"debug"`
+
+	RecordRetentionConfig RecordRetentionConfig  `json:"record_retention"`
+	StreamOverrides       []StreamOverrideConfig `json:"stream_overrides"`
+	TranscodeConfig       TranscodeConfig        `json:"transcode"`
+	SubWaitPubConfig      SubWaitPubConfig       `json:"sub_wait_pub"`
+	PluginConfig          PluginConfig           `json:"plugin"`
+	ScriptHookConfig      ScriptHookConfig       `json:"script_hook"`
+	StatHistoryConfig     StatHistoryConfig      `json:"stat_history"`
+	MpegtsConfig          MpegtsConfig           `json:"mpegts"`
+	BitstreamCheckConfig  BitstreamCheckConfig   `json:"bitstream_check"`
+	RistConfig            RistConfig             `json:"rist"`
+	OnvifConfig           OnvifConfig            `json:"onvif"`
+	EsIngestConfig        EsIngestConfig         `json:"es_ingest"`
+	FilePublishConfig     FilePublishConfig      `json:"file_publish"`
+	BackupPublishConfig   BackupPublishConfig    `json:"backup_publish"`
+	FastStartConfig       FastStartConfig        `json:"fast_start"`
+	ViewerNotifyConfig    ViewerNotifyConfig     `json:"viewer_notify"`
+	GeoIpConfig           GeoIpConfig            `json:"geoip"`
+	ConnGuardConfig       ConnGuardConfig        `json:"conn_guard"`
+	TlsConfig             TlsConfig              `json:"tls"`
+	SecretsConfig         SecretsConfig          `json:"secrets"`
+	RecordEncryptConfig   RecordEncryptConfig    `json:"record_encrypt"`
+	HealthConfig          HealthConfig           `json:"health"`
+	ReusePortConfig       ReusePortConfig        `json:"reuse_port"`
+	OutboundConfig        OutboundConfig         `json:"outbound"`
+
+	PublishConstraintConfig PublishConstraintConfig `json:"publish_constraint"`
+	StreamKeyConfig         StreamKeyConfig         `json:"stream_key"`
+	PublishTokenConfig      PublishTokenConfig      `json:"publish_token"`
+	AdmissionConfig         AdmissionConfig         `json:"admission"`
+	TraceConfig             TraceConfig             `json:"trace"`
+	StreamEventConfig       StreamEventConfig       `json:"stream_event"`
+	ProxyProtocolConfig     ProxyProtocolConfig     `json:"proxy_protocol"`
 }
 
 type RtmpConfig struct {
@@ -58,6 +97,14 @@ type RtmpConfig struct {
 	MergeWriteSize           int    `json:"merge_write_size"`
 	AddDummyAudioEnable      bool   `json:"add_dummy_audio_enable"`
 	AddDummyAudioWaitAudioMs int    `json:"add_dummy_audio_wait_audio_ms"`
+	ProxyProtocolEnable      bool   `json:"proxy_protocol_enable"`
+
+	// 本端发送的chunk size、window ack size、peer bandwidth，为0则使用默认值，见 rtmp.LocalChunkSize
+	ChunkSize     int `json:"chunk_size"`
+	WindowAckSize int `json:"window_ack_size"`
+	PeerBandwidth int `json:"peer_bandwidth"`
+
+	ExtraListeners []ListenerConfig `json:"extra_listeners"`
 }
 
 type DefaultHttpConfig struct {
@@ -68,6 +115,9 @@ type HttpflvConfig struct {
 	CommonHttpServerConfig
 
 	GopNum int `json:"gop_num"`
+
+	ResumeGraceSec   int    `json:"resume_grace_sec"`   // sub断开后保留断点的时间，在此时间内带上`session_id`参数重连时从断点续播，0表示不开启
+	RecordUrlPattern string `json:"record_url_pattern"` // 在httpflv的监听地址上提供`record.flv_out_path`下录制文件的点播，为空表示不开启
 }
 
 type HttptsConfig struct {
@@ -81,46 +131,369 @@ type HlsConfig struct {
 
 	UseMemoryAsDiskFlag bool `json:"use_memory_as_disk_flag"`
 	hls.MuxerConfig
+
+	NtpServer string `json:"ntp_server"` // 不为空时，定时通过ntp校正`#EXT-X-PROGRAM-DATE-TIME`使用的墙上时间
 }
 
 type RtspConfig struct {
 	Enable              bool   `json:"enable"`
 	Addr                string `json:"addr"`
 	OutWaitKeyFrameFlag bool   `json:"out_wait_key_frame_flag"`
+	ProxyProtocolEnable bool   `json:"proxy_protocol_enable"`
+	UdpMinPort          uint16 `json:"udp_min_port"`
+	UdpMaxPort          uint16 `json:"udp_max_port"`
+	JitterBufferMs      int    `json:"jitter_buffer_ms"` // rtp over udp输入的抖动缓冲时长，单位毫秒，为0表示不开启
+
+	ExtraListeners []ListenerConfig `json:"extra_listeners"`
+}
+
+// RistConfig RIST（simple profile）输入，每个地址接收一路流
+type RistConfig struct {
+	Enable        bool              `json:"enable"`
+	BufferMs      int               `json:"buffer_ms"`      // 缺失的包等待重传的最长时间，单位毫秒
+	ReorderWindow int               `json:"reorder_window"` // 重排序缓冲区最多缓存的包数量
+	Inputs        []RistInputConfig `json:"inputs"`
+}
+
+type RistInputConfig struct {
+	Addr       string `json:"addr"` // 接收rtp的地址，rtcp使用端口+1
+	StreamName string `json:"stream_name"`
+}
+
+// OnvifConfig 自动发现局域网中的Onvif摄像头，并创建rtsp拉流
+type OnvifConfig struct {
+	Enable             bool   `json:"enable"`
+	ProbeIntervalSec   int    `json:"probe_interval_sec"`
+	ProbeTimeoutMs     int    `json:"probe_timeout_ms"`
+	Username           string `json:"username"` // 访问设备onvif服务以及rtsp拉流使用的用户名、密码
+	Password           string `json:"password"`
+	AllProfiles        bool   `json:"all_profiles"`         // 为false时只拉取第一个profile（通常为主码流）
+	StreamNameTemplate string `json:"stream_name_template"` // 见 makeOnvifStreamName
+	PullOverTcp        bool   `json:"pull_over_tcp"`
+}
+
+// EsIngestConfig 音视频裸流over tcp的推流服务，协议见 esingest 包
+type EsIngestConfig struct {
+	Enable bool   `json:"enable"`
+	Addr   string `json:"addr"`
+}
+
+// FilePublishConfig 将本地文件作为直播流输入，见 CtrlStartFilePublish
+type FilePublishConfig struct {
+	Enable   bool                       `json:"enable"`
+	RootDir  string                     `json:"root_dir"`
+	Channels []FilePublishChannelConfig `json:"channels"`
+}
+
+// FilePublishChannelConfig 启动时自动开始播放的频道，依次播放`Files`中的文件，作为一路连续的直播流
+type FilePublishChannelConfig struct {
+	StreamName string   `json:"stream_name"`
+	Files      []string `json:"files"` // 相对`root_dir`的路径
+	Loop       bool     `json:"loop"`
+}
+
+// BackupPublishConfig 主备推流，见 BackupPublisher
+type BackupPublishConfig struct {
+	Enable             bool   `json:"enable"`
+	StallTimeoutMs     int    `json:"stall_timeout_ms"`
+	BackupStreamSuffix string `json:"backup_stream_suffix"`
+	SwitchBack         bool   `json:"switch_back"` // 主推流恢复后是否切换回主推流
+}
+
+// FastStartConfig 快速起播，见 Group.AddRtmpSubSession
+type FastStartConfig struct {
+	Enable bool `json:"enable"`
+}
+
+// ViewerNotifyConfig 观看人数跨越阈值时通知，见 ViewerNotifier
+type ViewerNotifyConfig struct {
+	Enable     bool  `json:"enable"`
+	Thresholds []int `json:"thresholds"`
+	DebounceMs int   `json:"debounce_ms"`
+}
+
+// GeoIpConfig 根据MaxMind的数据库（.mmdb）查询session远端地址的地理位置，见 GeoIp
+type GeoIpConfig struct {
+	Enable    bool   `json:"enable"`
+	DbFile    string `json:"db_file"`     // 位置数据库，比如GeoLite2-City.mmdb、GeoLite2-Country.mmdb
+	AsnDbFile string `json:"asn_db_file"` // ASN数据库，比如GeoLite2-ASN.mmdb，可以为空
+
+	AccessPolicies []GeoAccessPolicyConfig `json:"access_policies"`
+}
+
+// GeoAccessPolicyConfig 按国家、ASN限制推拉流，见 GeoAccessPolicy
+type GeoAccessPolicyConfig struct {
+	Vhost string              `json:"vhost"` // 匹配推拉流url中的域名，为空表示默认策略
+	Pub   GeoAccessRuleConfig `json:"pub"`
+	Sub   GeoAccessRuleConfig `json:"sub"`
+}
+
+type GeoAccessRuleConfig struct {
+	AllowCountries []string `json:"allow_countries"`
+	DenyCountries  []string `json:"deny_countries"`
+	AllowAsns      []uint32 `json:"allow_asns"`
+	DenyAsns       []uint32 `json:"deny_asns"`
+	AllowUnknown   bool     `json:"allow_unknown"` // 查询不到地理位置时，是否跳过allow列表的检查
+}
+
+// ConnGuardConfig 防连接风暴，对rtmp、rtsp、httpflv、httpts、hls的监听地址（包括额外监听地址）生效，见 base.ConnGuard
+type ConnGuardConfig struct {
+	Enable             bool `json:"enable"`
+	AcceptRate         int  `json:"accept_rate"`
+	AcceptBurst        int  `json:"accept_burst"`
+	MaxConnsPerIp      int  `json:"max_conns_per_ip"`
+	HandshakeTimeoutMs int  `json:"handshake_timeout_ms"`
+}
+
+// TlsConfig 集中的tls配置，https监听地址通过`https_tls_profile`按名称引用其中的profile
+type TlsConfig struct {
+	Profiles []TlsProfileConfig `json:"profiles"`
+
+	name2TlsConfig map[string]*tls.Config
+}
+
+type TlsProfileConfig struct {
+	Name string `json:"name"`
+	base.TlsOption
+}
+
+// SecretsConfig 加密的secrets文件，配置项中可以通过`${secret:NAME}`引用其中的值，见 base.EncryptSecrets
+//
+// 可以使用`lalserver -encrypt_secrets <明文json文件>`生成
+//
+type SecretsConfig struct {
+	File   string `json:"file"`    // 为空则不使用secrets文件，此时依然可以通过`${env:NAME}`引用环境变量
+	KeyEnv string `json:"key_env"` // 解密密钥所在的环境变量，为空默认为LAL_SECRETS_KEY
+}
+
+// HealthConfig 在http api的监听地址上提供/healthz（liveness）和/readyz（readiness）接口，见 HealthChecker
+type HealthConfig struct {
+	Enable             bool   `json:"enable"`
+	CheckIntervalSec   int    `json:"check_interval_sec"`   // readiness检查的间隔
+	LivenessTimeoutSec int    `json:"liveness_timeout_sec"` // 主循环超过该时间没有运行，liveness失败
+	ListenersCheck     bool   `json:"listeners_check"`      // 检查所有监听地址是否可以连接
+	MinFreeDiskBytes   int64  `json:"min_free_disk_bytes"`  // 大于0时，检查录制、hls目录所在磁盘的剩余空间
+	NotifyCheck        bool   `json:"notify_check"`         // 检查http notify的地址是否可以连接
+	NtpServer          string `json:"ntp_server"`           // 不为空时，检查和该ntp服务器之间的时钟偏差
+	MaxClockSkewMs     int    `json:"max_clock_skew_ms"`
+}
+
+// ReusePortConfig 多个lalserver worker进程通过SO_REUSEPORT共享rtmp、rtsp、httpflv、httpts、hls的监听地址，见 reuse_port.go
+type ReusePortConfig struct {
+	Enable        bool   `json:"enable"`
+	WorkerNum     int    `json:"worker_num"`
+	WorkerIndex   int    `json:"worker_index"`    // 当前worker的序号，从0开始，可以被命令行参数`-worker_index`覆盖
+	InnerRtmpAddr string `json:"inner_rtmp_addr"` // worker之间转发流的rtmp监听地址，序号为i的worker使用端口+i
+}
+
+// OutboundConfig http notify、sidecar插件等对外http请求使用的协程池，见 OutboundPool
+type OutboundConfig struct {
+	WorkerNum               int `json:"worker_num"`
+	QueueSize               int `json:"queue_size"`
+	TimeoutMs               int `json:"timeout_ms"`
+	MaxIdleConnsPerHost     int `json:"max_idle_conns_per_host"`
+	BreakerFailureThreshold int `json:"breaker_failure_threshold"` // 同一个host连续失败的次数达到该值时熔断，0表示不熔断
+	BreakerOpenSec          int `json:"breaker_open_sec"`          // 熔断的时长，之后放行一个请求试探，成功则恢复
+}
+
+// PublishConstraintConfig 推流的编码参数限制，见 Group.checkPublishConstraint
+type PublishConstraintConfig struct {
+	Enable   bool                  `json:"enable"`
+	Policies []PublishPolicyConfig `json:"policies"`
+}
+
+// PublishPolicyConfig 各项限制为0或者为空时表示不限制
+type PublishPolicyConfig struct {
+	Vhost                 string   `json:"vhost"` // 匹配推流url中的域名，为空表示默认策略
+	MaxWidth              int      `json:"max_width"`
+	MaxHeight             int      `json:"max_height"`
+	MaxBitrateKbits       int      `json:"max_bitrate_kbits"`
+	AllowedVideoCodecs    []string `json:"allowed_video_codecs"` // 比如H264、H265
+	AllowedAudioCodecs    []string `json:"allowed_audio_codecs"` // 比如AAC、MP3
+	MaxKeyFrameIntervalMs int      `json:"max_key_frame_interval_ms"`
+	Disconnect            bool     `json:"disconnect"` // 违反限制时，除了发送事件通知，是否关闭推流
+}
+
+// StreamKeyConfig 推流url中使用密钥，播放使用公开的流名称，见 StreamKeyResolver
+type StreamKeyConfig struct {
+	Enable        bool            `json:"enable"`
+	Keys          []StreamKeyItem `json:"keys"`
+	ResolveUrl    string          `json:"resolve_url"`    // 静态配置中找不到密钥时，请求该地址解析，为空则不请求
+	TimeoutMs     int             `json:"timeout_ms"`     // 请求`resolve_url`的超时时间
+	RejectUnknown bool            `json:"reject_unknown"` // 解析不到密钥时是否拒绝推流，false则直接使用推流url中的名称作为流名称
+
+	CacheTtlSec int `json:"cache_ttl_sec"` // 缓存`resolve_url`的解析结果（包括拒绝）的时长，请求失败不缓存。0表示不缓存
+}
+
+type StreamKeyItem struct {
+	StreamKey  string `json:"stream_key"`
+	StreamName string `json:"stream_name"`
+}
+
+// PublishTokenConfig 一次性推流token，见 PublishTokenAuth
+type PublishTokenConfig struct {
+	Enable        bool   `json:"enable"`
+	TokenParam    string `json:"token_param"`     // token所在的url参数名，为空时使用lal_publish_token
+	DefaultTtlSec int    `json:"default_ttl_sec"` // token的默认有效期
+	MaxTtlSec     int    `json:"max_ttl_sec"`     // 请求中指定的有效期的上限，0表示不限制
+	RequireToken  bool   `json:"require_token"`   // 是否所有推流都必须携带token
+	UrlPrefix     string `json:"url_prefix"`      // 返回的推流地址的前缀，比如rtmp://live.example.com:1935，为空则使用rtmp的监听地址
+}
+
+// AdmissionConfig 按节点出口带宽做拉流准入控制，不包含hls，见 AdmissionController
+type AdmissionConfig struct {
+	Enable                 bool `json:"enable"`
+	EgressBudgetKbits      int  `json:"egress_budget_kbits"`       // 出口带宽上限，单位kbit/s
+	DefaultSubBitrateKbits int  `json:"default_sub_bitrate_kbits"` // 流的码率还没有统计到时（比如还没有输入流），预估的单个拉流码率
+	WarmupSec              int  `json:"warmup_sec"`                // 新接入的拉流在该时长内按预估码率计入出口码率，之后使用实际统计的码率
+}
+
+// TraceConfig 关联多个节点、外部系统之间的session和请求
+type TraceConfig struct {
+	GlobalSessionId bool   `json:"global_session_id"` // session id前面加上server_id和本次启动的随机串，多个节点、节点重启之后也不重复
+	RequestIdHeader string `json:"request_id_header"` // http api读取调用方request id的header，为空时使用X-Request-ID
+}
+
+// StreamEventConfig 每路流的事件时间线，见 StreamEventLog
+type StreamEventConfig struct {
+	Enable             bool `json:"enable"`
+	MaxEventsPerStream int  `json:"max_events_per_stream"` // 每路流最多保留的事件数，超过时丢弃最旧的
+	KeepMinutes        int  `json:"keep_minutes"`          // 流最后一个事件之后保留的时长，之后删除该流的所有事件
+
+	MaxStreams int `json:"max_streams"` // 最多记录多少路流的事件，超过时删除最后一个事件最旧的流
+}
+
+// ProxyProtocolConfig 各监听地址开启`proxy_protocol_enable`时共用的配置，见 base.ProxyProtocolListener
+type ProxyProtocolConfig struct {
+	TrustedCidrs []string `json:"trusted_cidrs"` // 只解析来自这些网段（比如负载均衡所在的网段）的连接的PROXY protocol头
+
+	trustedNets []*net.IPNet
+}
+
+// ListenerConfig 除`addr`之外，额外监听的地址，比如对外的1935和只对内网开放的19350使用不同的鉴权策略
+type ListenerConfig struct {
+	Addr                string `json:"addr"`
+	Tag                 string `json:"tag"`          // 从该地址接入的session会打上`listener`标签，出现在stat以及事件通知中。为空时不打标签
+	AuthDisable         bool   `json:"auth_disable"` // 为true时，从该地址接入的推拉流不做鉴权
+	ProxyProtocolEnable bool   `json:"proxy_protocol_enable"`
 }
 
 type RecordConfig struct {
-	EnableFlv     bool   `json:"enable_flv"`
-	FlvOutPath    string `json:"flv_out_path"`
-	EnableMpegts  bool   `json:"enable_mpegts"`
-	MpegtsOutPath string `json:"mpegts_out_path"`
+	EnableFlv      bool   `json:"enable_flv"`
+	FlvOutPath     string `json:"flv_out_path"`
+	EnableMpegts   bool   `json:"enable_mpegts"`
+	MpegtsOutPath  string `json:"mpegts_out_path"`
+	ResumeGraceSec int    `json:"resume_grace_sec"`
+}
+
+// RecordEncryptConfig flv、mpegts录制文件以及hls ts切片的落盘加密，见 base.FileKeyring
+//
+// 配置了`master_key`时，录制文件的点播（见 HttpflvConfig.RecordUrlPattern ）以及hls拉流会透明解密，
+// 所以关闭加密后，之前加密的文件依然可以访问
+//
+type RecordEncryptConfig struct {
+	Enable    bool   `json:"enable"`     // 是否加密flv、mpegts录制文件
+	HlsEnable bool   `json:"hls_enable"` // 是否加密hls的ts切片
+	MasterKey string `json:"master_key"` // 主密钥，建议通过`${env:NAME}`或`${secret:NAME}`引用，见 SecretsConfig
+
+	keyring *base.FileKeyring
+}
+
+type RecordRetentionConfig struct {
+	Enable            bool  `json:"enable"`
+	CheckIntervalSec  int   `json:"check_interval_sec"`
+	MaxAgeSec         int   `json:"max_age_sec"`
+	MaxTotalBytes     int64 `json:"max_total_bytes"`
+	MaxBytesPerStream int64 `json:"max_bytes_per_stream"`
+	MinFreeDiskBytes  int64 `json:"min_free_disk_bytes"`
+	LowDiskAction     int   `json:"low_disk_action"`
 }
 
 type RelayPushConfig struct {
 	Enable   bool     `json:"enable"`
 	AddrList []string `json:"addr_list"`
+	ProxyUrl string   `json:"proxy_url"`
+
+	// 转推使用的chunk size、window ack size、peer bandwidth，见 rtmp.PushSessionOption
+	ChunkSize     int `json:"chunk_size"`
+	WindowAckSize int `json:"window_ack_size"`
+	PeerBandwidth int `json:"peer_bandwidth"`
+
+	MaxBitrateKbits int `json:"max_bitrate_kbits"` // 每个转推session的发送码率上限，单位kbit/s，为0则不限制，见 base.ShapedConn
 }
 
 type RelayPullConfig struct {
-	Enable bool   `json:"enable"`
-	Addr   string `json:"addr"`
+	Enable   bool   `json:"enable"`
+	Addr     string `json:"addr"`
+	ProxyUrl string `json:"proxy_url"`
+
+	WaitTimeoutMs int `json:"wait_timeout_ms"` // 回源期间等待的sub session，超过该时间依然没有拉到流则全部关闭。为0则不关闭
 }
 
 type HttpApiConfig struct {
-	Enable bool   `json:"enable"`
-	Addr   string `json:"addr"`
+	Enable              bool                  `json:"enable"`
+	Addr                string                `json:"addr"`
+	UnixListenAddr      string                `json:"unix_listen_addr"` // 不为空时，额外监听该路径的unix domain socket
+	ProxyProtocolEnable bool                  `json:"proxy_protocol_enable"`
+	HttpHeader          base.HttpHeaderOption `json:"http_header"`
+
+	AuditConfig       AuditConfig        `json:"audit"`
+	RateLimitConfig   RateLimitConfig    `json:"rate_limit"`
+	IdempotencyTtlSec int                `json:"idempotency_ttl_sec"`
+	DebugConfig       HttpApiDebugConfig `json:"debug"`
+
+	BatchMaxItems    int `json:"batch_max_items"`   // 批量接口一次请求最多的项数，为0则使用默认值，见 defaultBatchMaxItems
+	BatchConcurrency int `json:"batch_concurrency"` // batch_start_pull同时执行的项数，为0则使用默认值，见 defaultBatchConcurrency
+}
+
+// HttpApiDebugConfig http api监听上的pprof、expvar以及调试接口，需要携带`auth_token`访问
+//
+type HttpApiDebugConfig struct {
+	Enable    bool   `json:"enable"`
+	AuthToken string `json:"auth_token"`
+}
+
+// RateLimitConfig 按客户端ip限制http api的请求频率
+//
+type RateLimitConfig struct {
+	Enable     bool    `json:"enable"`
+	RatePerSec float64 `json:"rate_per_sec"`
+	Burst      int     `json:"burst"`
+}
+
+// AuditConfig http api ctrl类型接口调用的审计日志
+//
+type AuditConfig struct {
+	Enable      bool   `json:"enable"`
+	MaxEntries  int    `json:"max_entries"`
+	File        string `json:"file"`
+	ActorHeader string `json:"actor_header"`
 }
 
 type HttpNotifyConfig struct {
 	Enable            bool   `json:"enable"`
 	UpdateIntervalSec int    `json:"update_interval_sec"`
 	OnServerStart     string `json:"on_server_start"`
+	OnServerStop      string `json:"on_server_stop"`
+	OnHeartbeat       string `json:"on_heartbeat"`
 	OnUpdate          string `json:"on_update"`
 	OnPubStart        string `json:"on_pub_start"`
 	OnPubStop         string `json:"on_pub_stop"`
 	OnSubStart        string `json:"on_sub_start"`
 	OnSubStop         string `json:"on_sub_stop"`
 	OnRtmpConnect     string `json:"on_rtmp_connect"`
+	OnRelayPullStart  string `json:"on_relay_pull_start"`
+	OnRelayPullStop   string `json:"on_relay_pull_stop"`
+	OnRelayPushStart  string `json:"on_relay_push_start"`
+	OnRelayPushStop   string `json:"on_relay_push_stop"`
+	OnBitstreamError  string `json:"on_bitstream_error"`
+	OnBackupSwitch    string `json:"on_backup_switch"`
+	OnViewerChange    string `json:"on_viewer_change"`
+	OnPubViolation    string `json:"on_pub_violation"`
+	OnSubReject       string `json:"on_sub_reject"`
+	ProxyUrl          string `json:"proxy_url"`
+
+	HeartbeatIntervalSec int `json:"heartbeat_interval_sec"` // on_heartbeat事件的间隔，0表示不发送
 }
 
 type SimpleAuthConfig struct {
@@ -133,6 +506,124 @@ type SimpleAuthConfig struct {
 	PubRtspEnable      bool   `json:"pub_rtsp_enable"`
 	SubRtspEnable      bool   `json:"sub_rtsp_enable"`
 	HlsM3u8Enable      bool   `json:"hls_m3u8_enable"`
+
+	SingleSubPerToken bool   `json:"single_sub_per_token"` // 每个token同时只允许一个拉流，见 SubTokenRegistry
+	TokenParam        string `json:"token_param"`          // token所在的url参数名，开启single_sub_per_token时必须配置
+}
+
+// checkTokenParam 单token单拉流需要每个观看者独有的token，simple_auth的lal_secret对同一路流的所有观看者都相同，
+// 使用它会导致每路流只能有一个观看者
+//
+func (c *SimpleAuthConfig) checkTokenParam() error {
+	if !c.SingleSubPerToken {
+		return nil
+	}
+	if c.TokenParam == "" || c.TokenParam == secretName {
+		return fmt.Errorf("token_param must be a per-viewer url param other than %s while single_sub_per_token is true. token_param=%s",
+			secretName, c.TokenParam)
+	}
+	return nil
+}
+
+// StreamOverrideConfig 按流名称匹配的配置，覆盖全局配置中的对应配置项
+//
+// 值为nil（也即配置文件中没有该字段）的配置项不覆盖
+//
+type StreamOverrideConfig struct {
+	StreamNamePattern     string `json:"stream_name_pattern"`      // 流名称匹配规则。默认为通配符（path.Match），以`~`开头则为正则表达式
+	HlsFragmentDurationMs *int   `json:"hls_fragment_duration_ms"` // 覆盖hls.fragment_duration_ms
+	GopNum                *int   `json:"gop_num"`                  // 覆盖rtmp、httpflv、httpts的gop_num
+	RecordFlvEnable       *bool  `json:"record_flv_enable"`        // 覆盖record.enable_flv
+	RecordMpegtsEnable    *bool  `json:"record_mpegts_enable"`     // 覆盖record.enable_mpegts
+	AuthPubEnable         *bool  `json:"auth_pub_enable"`          // 覆盖simple_auth中所有协议的推流鉴权开关
+	AuthSubEnable         *bool  `json:"auth_sub_enable"`          // 覆盖simple_auth中所有协议的拉流鉴权开关（包括hls）
+
+	re *regexp.Regexp
+}
+
+type SubWaitPubConfig struct {
+	Enable     bool `json:"enable"`
+	TimeoutSec int  `json:"timeout_sec"`
+}
+
+type PluginConfig struct {
+	Enable   bool            `json:"enable"`
+	SoFiles  []string        `json:"so_files"`
+	Sidecars []SidecarConfig `json:"sidecars"`
+}
+
+type MpegtsConfig struct {
+	mpegts.PsiOption
+}
+
+// BitstreamCheckConfig 输入流h264、h265视频数据的合法性检查
+//
+type BitstreamCheckConfig struct {
+	Enable            bool `json:"enable"`
+	MaxFrameSize      int  `json:"max_frame_size"`      // 单个视频帧的最大字节数，超过的帧被丢弃
+	NotifyIntervalSec int  `json:"notify_interval_sec"` // 同一路流两次on_bitstream_error事件通知的最小间隔
+}
+
+type StatHistoryConfig struct {
+	Enable      bool `json:"enable"`
+	IntervalSec int  `json:"interval_sec"`
+	KeepMinutes int  `json:"keep_minutes"`
+}
+
+type ScriptHookConfig struct {
+	Enable     bool   `json:"enable"`
+	ScriptFile string `json:"script_file"`
+}
+
+type SidecarConfig struct {
+	Name         string `json:"name"`
+	UrlPrefix    string `json:"url_prefix"`
+	AuthEnable   bool   `json:"auth_enable"`
+	NotifyEnable bool   `json:"notify_enable"`
+	TimeoutMs    int    `json:"timeout_ms"`
+}
+
+type TranscodeConfig struct {
+	Enable     bool                   `json:"enable"`
+	FfmpegPath string                 `json:"ffmpeg_path"`
+	Stages     []TranscodeStageConfig `json:"stages"`
+}
+
+// TranscodeStageConfig 一个转码处理阶段，对流名称匹配的输入流做处理，并输出为一路新的流
+//
+type TranscodeStageConfig struct {
+	StreamNamePattern string           `json:"stream_name_pattern"` // 流名称匹配规则，同 StreamOverrideConfig.StreamNamePattern
+	OutStreamSuffix   string           `json:"out_stream_suffix"`   // 输出流名称为输入流名称加上该后缀
+	AudioToAac        bool             `json:"audio_to_aac"`        // 音频重新编码为aac，配置了Loudnorm时忽略，Loudnorm本身会编码为aac
+	Watermark         *WatermarkConfig `json:"watermark"`           // 视频叠加水印，为nil时不处理
+	Loudnorm          *LoudnormConfig  `json:"loudnorm"`            // 音频响度标准化，为nil时不处理
+
+	re *regexp.Regexp
+}
+
+const (
+	WatermarkPositionTopLeft     = "top_left"
+	WatermarkPositionTopRight    = "top_right"
+	WatermarkPositionBottomLeft  = "bottom_left"
+	WatermarkPositionBottomRight = "bottom_right"
+	WatermarkPositionCenter      = "center"
+)
+
+type WatermarkConfig struct {
+	ImagePath string `json:"image_path"`
+	Position  string `json:"position"` // 取值见 WatermarkPositionXxx，默认为top_right
+	Margin    int    `json:"margin"`   // 距离视频边缘的像素
+}
+
+// LoudnormConfig 音频响度标准化（EBU R128），使用ffmpeg的loudnorm滤镜
+//
+// 值为0的配置项使用默认值
+//
+type LoudnormConfig struct {
+	IntegratedLufs   float64 `json:"integrated_lufs"`    // 目标响度，单位LUFS，默认-23
+	LoudnessRange    float64 `json:"loudness_range"`     // 目标响度范围，单位LU，默认7
+	TruePeakDbtp     float64 `json:"true_peak_dbtp"`     // 最大真峰值，单位dBTP，默认-2
+	AudioBitrateKbps int     `json:"audio_bitrate_kbps"` // 重新编码的aac码率，默认128
 }
 
 type PprofConfig struct {
@@ -149,9 +640,10 @@ type DebugConfig struct {
 type CommonHttpServerConfig struct {
 	CommonHttpAddrConfig
 
-	Enable      bool   `json:"enable"`
-	EnableHttps bool   `json:"enable_https"`
-	UrlPattern  string `json:"url_pattern"`
+	Enable      bool                  `json:"enable"`
+	EnableHttps bool                  `json:"enable_https"`
+	UrlPattern  string                `json:"url_pattern"`
+	HttpHeader  base.HttpHeaderOption `json:"http_header"`
 }
 
 type CommonHttpAddrConfig struct {
@@ -159,25 +651,59 @@ type CommonHttpAddrConfig struct {
 	HttpsListenAddr string `json:"https_listen_addr"`
 	HttpsCertFile   string `json:"https_cert_file"`
 	HttpsKeyFile    string `json:"https_key_file"`
+	HttpsTlsProfile string `json:"https_tls_profile"` // 不为空时使用`tls.profiles`中该名称的配置，忽略`https_cert_file`和`https_key_file`
+
+	ProxyProtocolEnable bool `json:"proxy_protocol_enable"`
+
+	// 不为空时，额外监听该路径的unix domain socket，方便同机部署的nginx、envoy等反向代理不经过tcp访问
+	UnixListenAddr string `json:"unix_listen_addr"`
 }
 
 func LoadConfAndInitLog(confFile string) *Config {
-	var config *Config
+	return loadConf(readConfFile(confFile), true, false)
+}
+
+// LoadConfAndInitLogWithRawContent 使用json格式的配置内容，而不是配置文件，主要用于将lalserver嵌入到其他程序中
+//
+func LoadConfAndInitLogWithRawContent(rawContent []byte) *Config {
+	return loadConf(rawContent, true, false)
+}
 
-	// 读取配置文件并解析原始内容
+func readConfFile(confFile string) []byte {
 	rawContent, err := ioutil.ReadFile(confFile)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "read conf file failed. file=%s err=%+v", confFile, err)
 		base.OsExitAndWaitPressIfWindows(1)
 	}
-	if err = json.Unmarshal(rawContent, &config); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "unmarshal conf file failed. file=%s err=%+v", confFile, err)
+	return rawContent
+}
+
+// loadConf
+//
+// @param initLog: 是否使用配置中的`log`初始化全局日志，见 Option.SkipLogInit
+//
+// @param checkOnly: 是否只检查配置（lalserver -t），此时不启动后台任务，见 TestConf
+//
+func loadConf(rawContent []byte, initLog bool, checkOnly bool) *Config {
+	var config *Config
+
+	// 解析原始内容
+	err := json.Unmarshal(rawContent, &config)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "unmarshal conf failed. err=%+v", err)
 		base.OsExitAndWaitPressIfWindows(1)
 	}
 
 	j, err := nazajson.New(rawContent)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "nazajson unmarshal conf file failed. file=%s err=%+v", confFile, err)
+		_, _ = fmt.Fprintf(os.Stderr, "nazajson unmarshal conf failed. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+
+	// 替换配置项中对环境变量、secrets文件的引用
+	secretRefs, secretValues, err := resolveConfigSecrets(config)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "resolve conf secrets failed. err=%+v", err)
 		base.OsExitAndWaitPressIfWindows(1)
 	}
 
@@ -224,13 +750,15 @@ func LoadConfAndInitLog(confFile string) *Config {
 		cacheLog = append(cacheLog, fmt.Sprintf("log.assert_behavior=%s", config.LogConfig.AssertBehavior.ReadableString()))
 	}
 
-	if err := Log.Init(func(option *nazalog.Option) {
-		*option = config.LogConfig
-	}); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "initial log failed. err=%+v\n", err)
-		base.OsExitAndWaitPressIfWindows(1)
+	if initLog {
+		if err := Log.Init(func(option *nazalog.Option) {
+			*option = config.LogConfig
+		}); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "initial log failed. err=%+v\n", err)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+		Log.Info("initial log succ.")
 	}
-	Log.Info("initial log succ.")
 
 	// 打印Logo
 	Log.Info(`
@@ -254,6 +782,20 @@ func LoadConfAndInitLog(confFile string) *Config {
 		"httpflv.http_listen_addr", "httpflv.https_listen_addr", "httpflv.https_cert_file", "httpflv.https_key_file",
 		"hls.http_listen_addr", "hls.https_listen_addr", "hls.https_cert_file", "hls.https_key_file",
 		"httpts.http_listen_addr", "httpts.https_listen_addr", "httpts.https_cert_file", "httpts.https_key_file",
+		"relay_push.proxy_url", "relay_pull.proxy_url", "http_notify.proxy_url",
+		"rtmp.proxy_protocol_enable", "rtmp.chunk_size", "rtmp.window_ack_size", "rtmp.peer_bandwidth",
+		"relay_push.chunk_size", "relay_push.max_bitrate_kbits", "relay_push.window_ack_size", "relay_push.peer_bandwidth",
+		"rtsp.proxy_protocol_enable", "rtsp.udp_min_port", "rtsp.udp_max_port", "rtsp.jitter_buffer_ms", "http_api.proxy_protocol_enable",
+		"default_http.proxy_protocol_enable", "httpflv.proxy_protocol_enable", "hls.proxy_protocol_enable", "httpts.proxy_protocol_enable",
+		"httpflv.http_header", "httpts.http_header", "hls.http_header", "http_api.http_header",
+		"stream_overrides", "record_retention.", "record.resume_grace_sec",
+		"transcode.", "hls.program_date_time_enable", "hls.ntp_server", "hls.resume_enable",
+		"relay_pull.wait_timeout_ms", "sub_wait_pub.", "http_api.audit", "http_api.rate_limit", "http_api.idempotency_ttl_sec", "http_api.debug", "http_api.batch_max_items", "http_api.batch_concurrency", "plugin.", "script_hook.", "stat_history.", "mpegts.",
+		"http_notify.on_relay_", "http_notify.on_bitstream_error", "http_notify.on_backup_switch", "http_notify.on_viewer_change", "http_notify.on_pub_violation", "http_notify.on_sub_reject", "http_notify.on_server_stop", "http_notify.on_heartbeat", "http_notify.heartbeat_interval_sec", "bitstream_check.",
+		"rtmp.extra_listeners", "rtsp.extra_listeners", "rist.", "onvif.", "es_ingest.", "file_publish.", "backup_publish.", "fast_start.", "viewer_notify.", "geoip.", "conn_guard.",
+		"simple_auth.single_sub_per_token", "simple_auth.token_param", "httpflv.resume_grace_sec", "httpflv.record_url_pattern",
+		"tls.", "secrets.", "record_encrypt.", "health.", "reuse_port.", "outbound.", "publish_constraint.", "stream_key.", "publish_token.", "admission.", "trace.", "stream_event.", "proxy_protocol.", "default_http.https_tls_profile", "httpflv.https_tls_profile", "hls.https_tls_profile", "httpts.https_tls_profile",
+		"default_http.unix_listen_addr", "httpflv.unix_listen_addr", "hls.unix_listen_addr", "httpts.unix_listen_addr", "http_api.unix_listen_addr",
 	)
 	if err != nil {
 		Log.Warnf("config nazajson collect not exist fields failed. err=%+v", err)
@@ -282,6 +824,16 @@ func LoadConfAndInitLog(confFile string) *Config {
 			config.HlsConfig.FragmentNum)
 		config.HlsConfig.DeleteThreshold = config.HlsConfig.FragmentNum
 	}
+	// 没有配置http_header时，httpflv、httpts、hls保持原有的行为（允许所有跨域，不缓存），http_api不返回额外的header
+	if !j.Exist("httpflv.http_header") {
+		config.HttpflvConfig.HttpHeader = base.DefaultHttpHeaderOption
+	}
+	if !j.Exist("httpts.http_header") {
+		config.HttptsConfig.HttpHeader = base.DefaultHttpHeaderOption
+	}
+	if !j.Exist("hls.http_header") {
+		config.HlsConfig.HttpHeader = base.DefaultHttpHeaderOption
+	}
 	if (config.HttpflvConfig.Enable || config.HttpflvConfig.EnableHttps) && !j.Exist("httpflv.url_pattern") {
 		Log.Warnf("config httpflv.url_pattern not exist. set to default wchich is %s", defaultHttpflvUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHttpflvUrlPattern
@@ -310,6 +862,60 @@ func LoadConfAndInitLog(confFile string) *Config {
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
 
+	for i := range config.StreamOverrides {
+		if err := config.StreamOverrides[i].compile(); err != nil {
+			Log.Errorf("config stream_overrides invalid. stream_name_pattern=%s, err=%+v",
+				config.StreamOverrides[i].StreamNamePattern, err)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+	}
+
+	for i := range config.TranscodeConfig.Stages {
+		stage := &config.TranscodeConfig.Stages[i]
+		if err := stage.compile(); err != nil {
+			Log.Errorf("config transcode.stages invalid. stream_name_pattern=%s, err=%+v", stage.StreamNamePattern, err)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+		if stage.OutStreamSuffix == "" {
+			Log.Errorf("config transcode.stages invalid. out_stream_suffix is empty. stream_name_pattern=%s", stage.StreamNamePattern)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+	}
+
+	if err := config.SimpleAuthConfig.checkTokenParam(); err != nil {
+		Log.Errorf("config simple_auth invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	if err := config.RecordEncryptConfig.compile(); err != nil {
+		Log.Errorf("config record_encrypt invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	if err := config.ReusePortConfig.compile(); err != nil {
+		Log.Errorf("config reuse_port invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	if config.ProxyProtocolConfig.trustedNets, err = base.ParseCidrs(config.ProxyProtocolConfig.TrustedCidrs); err != nil {
+		Log.Errorf("config proxy_protocol.trusted_cidrs invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	if err := config.TlsConfig.compile(checkOnly); err != nil {
+		Log.Errorf("config tls invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	for name, c := range map[string]*CommonHttpServerConfig{
+		"httpflv": &config.HttpflvConfig.CommonHttpServerConfig,
+		"hls":     &config.HlsConfig.CommonHttpServerConfig,
+		"httpts":  &config.HttptsConfig.CommonHttpServerConfig,
+	} {
+		if !c.EnableHttps || c.HttpsTlsProfile == "" {
+			continue
+		}
+		if _, ok := config.TlsConfig.Get(c.HttpsTlsProfile); !ok {
+			Log.Errorf("config %s.https_tls_profile invalid. profile not exist. https_tls_profile=%s", name, c.HttpsTlsProfile)
+			base.OsExitAndWaitPressIfWindows(1)
+		}
+	}
+
 	// 打印配置文件中的元素内容，以及解析后的最终值
 	// 把配置文件原始内容中的换行去掉，使得打印日志时紧凑一些
 	lines := strings.Split(string(rawContent), "\n")
@@ -321,7 +927,9 @@ func LoadConfAndInitLog(confFile string) *Config {
 		tlines = append(tlines, strings.TrimSpace(l))
 	}
 	compactRawContent := strings.Join(tlines, " ")
-	Log.Infof("load conf file succ. filename=%s, raw content=%s parsed=%+v", confFile, compactRawContent, config)
+	withSecretsMasked(secretRefs, secretValues, func() {
+		Log.Infof("load conf succ. raw content=%s parsed=%+v", compactRawContent, config)
+	})
 
 	return config
 }
@@ -341,6 +949,15 @@ func mergeCommonHttpAddrConfig(dst, src *CommonHttpAddrConfig) {
 	if dst.HttpsKeyFile == "" && src.HttpsKeyFile != "" {
 		dst.HttpsKeyFile = src.HttpsKeyFile
 	}
+	if dst.HttpsTlsProfile == "" && src.HttpsTlsProfile != "" {
+		dst.HttpsTlsProfile = src.HttpsTlsProfile
+	}
+	if !dst.ProxyProtocolEnable && src.ProxyProtocolEnable {
+		dst.ProxyProtocolEnable = src.ProxyProtocolEnable
+	}
+	if dst.UnixListenAddr == "" && src.UnixListenAddr != "" {
+		dst.UnixListenAddr = src.UnixListenAddr
+	}
 }
 
 func ensureStartWithSlash(in string) (out string, changed bool) {
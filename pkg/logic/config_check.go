@@ -0,0 +1,412 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// TestConf 加载并完整检查配置文件，不启动服务，用于`lalserver -t`，在CI/CD中上线前发现配置错误
+//
+// 所有错误打印到stderr
+//
+// @param confFile 为空时，使用 DefaultConfFilenameList 中第一个存在的文件
+//
+// @return 进程退出码，检查通过时为0
+//
+func TestConf(confFile string) int {
+	if confFile == "" {
+		confFile = firstExistDefaultConfFilename()
+		if confFile == "" {
+			_, _ = fmt.Fprintf(os.Stderr, "config test failed. conf file not specified and no default conf file exist. list=%+v\n", DefaultConfFilenameList)
+			return 1
+		}
+	}
+
+	// 注意，加载阶段的错误（json格式、tls profile等）内部直接退出进程，退出码为1
+	config := loadConf(readConfFile(confFile), true, true)
+
+	errs := CheckConfig(config)
+	if len(errs) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "config test succ. file=%s\n", confFile)
+		return 0
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "config test failed. file=%s, errors=%d\n", confFile, len(errs))
+	for _, err := range errs {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", err.Error())
+	}
+	return 1
+}
+
+// CheckConfig 对已经加载的配置做启动前的完整检查
+//
+// 包括https证书能否加载、监听地址的格式以及相互之间是否冲突、回源和转推地址以及代理地址、http notify地址的格式、推流密钥的配置。
+// 注意，其中一些错误在正常启动时只打印日志并继续运行（比如https证书加载失败只是不开启https）
+//
+// @return 所有检查出的错误，每个错误以出错的配置项开头。为nil表示检查通过
+//
+func CheckConfig(config *Config) (errs []error) {
+	errs = append(errs, checkConfigTls(config)...)
+	errs = append(errs, checkConfigListeners(config)...)
+	errs = append(errs, checkConfigRelay(config)...)
+	errs = append(errs, checkConfigNotify(config)...)
+	errs = append(errs, checkConfigStreamKey(config)...)
+	errs = append(errs, checkConfigAdmission(config)...)
+	errs = append(errs, checkConfigRateLimit(config)...)
+	errs = append(errs, checkConfigSubWaitPub(config)...)
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func checkConfigTls(config *Config) (errs []error) {
+	for _, item := range httpServerConfigs(config) {
+		c := item.config
+		if !c.EnableHttps {
+			continue
+		}
+		if c.HttpsListenAddr == "" {
+			errs = append(errs, fmt.Errorf("%s.https_listen_addr: empty while enable_https is true", item.name))
+		}
+		// 引用了tls profile时，证书在加载配置时已经检查过
+		if c.HttpsTlsProfile != "" {
+			continue
+		}
+		_, err := base.NewTlsConfig(base.TlsOption{Certs: []base.TlsCertOption{{CertFile: c.HttpsCertFile, KeyFile: c.HttpsKeyFile}}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.https_cert_file, %s.https_key_file: load failed. cert=%s, key=%s, err=%v",
+				item.name, item.name, c.HttpsCertFile, c.HttpsKeyFile, err))
+		}
+	}
+	return
+}
+
+// configListener 配置中的一个监听地址
+type configListener struct {
+	name    string // 配置项
+	network string // tcp、udp或unix
+	addr    string
+
+	// 相同的地址可以被多个使用相同`shareKind`的配置项共享，比如httpflv和hls使用同一个http监听地址。为空表示不能共享
+	shareKind string
+}
+
+func checkConfigListeners(config *Config) (errs []error) {
+	var listeners []configListener
+	add := func(name, network, addr, shareKind string) {
+		listeners = append(listeners, configListener{name: name, network: network, addr: addr, shareKind: shareKind})
+	}
+
+	if config.RtmpConfig.Enable {
+		add("rtmp.addr", "tcp", config.RtmpConfig.Addr, "")
+		for i, l := range config.RtmpConfig.ExtraListeners {
+			add(fmt.Sprintf("rtmp.extra_listeners[%d].addr", i), "tcp", l.Addr, "")
+		}
+	}
+	if config.RtspConfig.Enable {
+		add("rtsp.addr", "tcp", config.RtspConfig.Addr, "")
+		for i, l := range config.RtspConfig.ExtraListeners {
+			add(fmt.Sprintf("rtsp.extra_listeners[%d].addr", i), "tcp", l.Addr, "")
+		}
+	}
+	for _, item := range httpServerConfigs(config) {
+		if item.config.Enable {
+			add(item.name+".http_listen_addr", "tcp", item.config.HttpListenAddr, "http")
+			if item.config.UnixListenAddr != "" {
+				add(item.name+".unix_listen_addr", "unix", item.config.UnixListenAddr, "http")
+			}
+		}
+		if item.config.EnableHttps && item.config.HttpsListenAddr != "" {
+			add(item.name+".https_listen_addr", "tcp", item.config.HttpsListenAddr, "https")
+		}
+	}
+	if config.HttpApiConfig.Enable {
+		add("http_api.addr", "tcp", config.HttpApiConfig.Addr, "")
+		if config.HttpApiConfig.UnixListenAddr != "" {
+			add("http_api.unix_listen_addr", "unix", config.HttpApiConfig.UnixListenAddr, "")
+		}
+	}
+	if config.EsIngestConfig.Enable {
+		add("es_ingest.addr", "tcp", config.EsIngestConfig.Addr, "")
+	}
+	if config.PprofConfig.Enable {
+		add("pprof.addr", "tcp", config.PprofConfig.Addr, "")
+	}
+	if rc := config.ReusePortConfig; rc.Enable {
+		for i := 0; i < rc.WorkerNum; i++ {
+			add(fmt.Sprintf("reuse_port.inner_rtmp_addr(worker %d)", i), "tcp", rc.InnerRtmpAddrOf(i), "")
+		}
+	}
+	if config.RistConfig.Enable {
+		for i, in := range config.RistConfig.Inputs {
+			name := fmt.Sprintf("rist.inputs[%d].addr", i)
+			add(name, "udp", in.Addr, "")
+			// rtcp使用端口+1
+			if host, port, err := splitListenAddr(in.Addr); err == nil {
+				add(name+"(rtcp)", "udp", net.JoinHostPort(host, strconv.Itoa(port+1)), "")
+			}
+		}
+	}
+
+	var valid []configListener
+	for _, l := range listeners {
+		if l.network == "unix" {
+			valid = append(valid, l)
+			continue
+		}
+		if _, _, err := splitListenAddr(l.addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid listen addr. addr=%s, err=%v", l.name, l.addr, err))
+			continue
+		}
+		valid = append(valid, l)
+	}
+
+	for i := 0; i < len(valid); i++ {
+		for j := i + 1; j < len(valid); j++ {
+			a, b := valid[i], valid[j]
+			if !isListenAddrConflict(a, b) {
+				continue
+			}
+			if a.shareKind != "" && a.shareKind == b.shareKind {
+				// 注意，能够共享的前提是地址写法完全相同，否则会各自建立监听
+				if a.addr == b.addr {
+					continue
+				}
+			}
+			errs = append(errs, fmt.Errorf("%s, %s: listen addr conflict. %s=%s, %s=%s", a.name, b.name, a.name, a.addr, b.name, b.addr))
+		}
+	}
+	return
+}
+
+func checkConfigRelay(config *Config) (errs []error) {
+	if config.RelayPushConfig.Enable {
+		for i, addr := range config.RelayPushConfig.AddrList {
+			if err := checkRelayAddr(addr); err != nil {
+				errs = append(errs, fmt.Errorf("relay_push.addr_list[%d]: %v", i, err))
+			}
+		}
+		if config.RelayPushConfig.ProxyUrl != "" {
+			if _, err := base.ParseProxyUrl(config.RelayPushConfig.ProxyUrl); err != nil {
+				errs = append(errs, fmt.Errorf("relay_push.proxy_url: %v", err))
+			}
+		}
+	}
+	if config.RelayPullConfig.Enable {
+		if err := checkRelayAddr(config.RelayPullConfig.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("relay_pull.addr: %v", err))
+		}
+		if config.RelayPullConfig.ProxyUrl != "" {
+			if _, err := base.ParseProxyUrl(config.RelayPullConfig.ProxyUrl); err != nil {
+				errs = append(errs, fmt.Errorf("relay_pull.proxy_url: %v", err))
+			}
+		}
+	}
+	return
+}
+
+func checkConfigNotify(config *Config) (errs []error) {
+	c := config.HttpNotifyConfig
+	if !c.Enable {
+		return
+	}
+	for name, v := range map[string]string{
+		"on_server_start":     c.OnServerStart,
+		"on_server_stop":      c.OnServerStop,
+		"on_heartbeat":        c.OnHeartbeat,
+		"on_update":           c.OnUpdate,
+		"on_pub_start":        c.OnPubStart,
+		"on_pub_stop":         c.OnPubStop,
+		"on_sub_start":        c.OnSubStart,
+		"on_sub_stop":         c.OnSubStop,
+		"on_rtmp_connect":     c.OnRtmpConnect,
+		"on_relay_pull_start": c.OnRelayPullStart,
+		"on_relay_pull_stop":  c.OnRelayPullStop,
+		"on_relay_push_start": c.OnRelayPushStart,
+		"on_relay_push_stop":  c.OnRelayPushStop,
+		"on_bitstream_error":  c.OnBitstreamError,
+		"on_backup_switch":    c.OnBackupSwitch,
+		"on_viewer_change":    c.OnViewerChange,
+		"on_pub_violation":    c.OnPubViolation,
+		"on_sub_reject":       c.OnSubReject,
+	} {
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = base.ErrInvalidUrl
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("http_notify.%s: invalid url. url=%s, err=%v", name, v, err))
+		}
+	}
+	if c.ProxyUrl != "" {
+		// notify使用net/http原生的代理，同样支持http和socks5
+		if _, err := base.ParseProxyUrl(c.ProxyUrl); err != nil {
+			errs = append(errs, fmt.Errorf("http_notify.proxy_url: %v", err))
+		}
+	}
+	// map遍历顺序随机，按配置项排序，方便对比多次的输出
+	sortErrors(errs)
+	return
+}
+
+// checkConfigStreamKey 密钥和流名称都必须一一对应，否则更换密钥时无法确定要替换的是哪一个
+//
+func checkConfigStreamKey(config *Config) (errs []error) {
+	c := config.StreamKeyConfig
+	if !c.Enable {
+		return
+	}
+	keys := make(map[string]struct{})
+	names := make(map[string]struct{})
+	for i, item := range c.Keys {
+		if item.StreamKey == "" || item.StreamName == "" {
+			errs = append(errs, fmt.Errorf("stream_key.keys[%d]: stream_key and stream_name must not be empty", i))
+			continue
+		}
+		if _, ok := keys[item.StreamKey]; ok {
+			errs = append(errs, fmt.Errorf("stream_key.keys[%d]: duplicate stream_key", i))
+		}
+		if _, ok := names[item.StreamName]; ok {
+			errs = append(errs, fmt.Errorf("stream_key.keys[%d]: duplicate stream_name. stream_name=%s", i, item.StreamName))
+		}
+		keys[item.StreamKey] = struct{}{}
+		names[item.StreamName] = struct{}{}
+	}
+	if c.ResolveUrl != "" {
+		u, err := url.Parse(c.ResolveUrl)
+		if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = base.ErrInvalidUrl
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stream_key.resolve_url: invalid url. url=%s, err=%v", c.ResolveUrl, err))
+		}
+	}
+	return
+}
+
+// checkConfigAdmission 没有配置出口带宽上限时，所有拉流都会被拒绝
+//
+func checkConfigAdmission(config *Config) (errs []error) {
+	c := config.AdmissionConfig
+	if c.Enable && c.EgressBudgetKbits <= 0 {
+		errs = append(errs, fmt.Errorf("admission.egress_budget_kbits: must be positive while enable is true. egress_budget_kbits=%d", c.EgressBudgetKbits))
+	}
+	return
+}
+
+// checkConfigRateLimit 速率或者突发数不为正数时，令牌桶中没有令牌，所有http api请求都会被拒绝
+//
+func checkConfigRateLimit(config *Config) (errs []error) {
+	c := config.HttpApiConfig.RateLimitConfig
+	if !c.Enable {
+		return
+	}
+	if c.RatePerSec <= 0 {
+		errs = append(errs, fmt.Errorf("http_api.rate_limit.rate_per_sec: must be positive while enable is true. rate_per_sec=%v", c.RatePerSec))
+	}
+	if c.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("http_api.rate_limit.burst: must be positive while enable is true. burst=%d", c.Burst))
+	}
+	return
+}
+
+// checkConfigSubWaitPub 等待时间不为正数时，sub session在第一次定时检查时就会被当作等待超时关闭
+//
+func checkConfigSubWaitPub(config *Config) (errs []error) {
+	c := config.SubWaitPubConfig
+	if c.Enable && c.TimeoutSec <= 0 {
+		errs = append(errs, fmt.Errorf("sub_wait_pub.timeout_sec: must be positive while enable is true. timeout_sec=%d", c.TimeoutSec))
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type namedHttpServerConfig struct {
+	name   string
+	config CommonHttpServerConfig
+}
+
+func httpServerConfigs(config *Config) []namedHttpServerConfig {
+	return []namedHttpServerConfig{
+		{"httpflv", config.HttpflvConfig.CommonHttpServerConfig},
+		{"hls", config.HlsConfig.CommonHttpServerConfig},
+		{"httpts", config.HttptsConfig.CommonHttpServerConfig},
+	}
+}
+
+// checkRelayAddr 回源、转推地址为`host:port`或`host`的形式，使用时拼接成`rtmp://{addr}/{app}/{stream}`
+func checkRelayAddr(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("empty")
+	}
+	ctx, err := base.ParseRtmpUrl(fmt.Sprintf("rtmp://%s/app/stream", addr))
+	if err == nil && ctx.HostWithPort != addr && ctx.Host != addr {
+		// 比如addr中带了path或者query
+		err = base.ErrInvalidUrl
+	}
+	if err == nil && (ctx.Port <= 0 || ctx.Port > 65535) {
+		err = fmt.Errorf("invalid port %d", ctx.Port)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid addr. addr=%s, err=%v", addr, err)
+	}
+	return nil
+}
+
+func splitListenAddr(addr string) (host string, port int, err error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	if port, err = strconv.Atoi(p); err != nil {
+		return
+	}
+	if port < 0 || port > 65535 {
+		err = fmt.Errorf("invalid port %d", port)
+	}
+	return
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+// isListenAddrConflict 两个监听地址是否会冲突，端口相同，并且ip相同或者其中一个监听所有ip
+func isListenAddrConflict(a, b configListener) bool {
+	if a.network != b.network {
+		return false
+	}
+	if a.network == "unix" {
+		return a.addr == b.addr
+	}
+	ha, pa, _ := splitListenAddr(a.addr)
+	hb, pb, _ := splitListenAddr(b.addr)
+	// 端口为0时由系统分配
+	if pa != pb || pa == 0 {
+		return false
+	}
+	return ha == hb || isWildcardHost(ha) || isWildcardHost(hb)
+}
+
+func sortErrors(errs []error) {
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	newConfig := func() *Config {
+		config := &Config{}
+		config.RtmpConfig.Enable = true
+		config.RtmpConfig.Addr = ":1935"
+		config.HttpflvConfig.Enable = true
+		config.HttpflvConfig.HttpListenAddr = ":8080"
+		config.HlsConfig.Enable = true
+		config.HlsConfig.HttpListenAddr = ":8080"
+		config.HttpApiConfig.Enable = true
+		config.HttpApiConfig.Addr = ":8083"
+		config.RelayPushConfig.Enable = true
+		config.RelayPushConfig.AddrList = []string{"127.0.0.1:19350", "example.com", "[::1]:1935"}
+		config.HttpNotifyConfig.Enable = true
+		config.HttpNotifyConfig.OnPubStart = "http://127.0.0.1:10101/on_pub_start"
+		return config
+	}
+	assert.Equal(t, 0, len(CheckConfig(newConfig())))
+
+	// 端口冲突
+	config := newConfig()
+	config.HttpApiConfig.Addr = "127.0.0.1:1935"
+	config.HlsConfig.HttpListenAddr = "0.0.0.0:8080"
+	config.RtmpConfig.ExtraListeners = []ListenerConfig{{Addr: "1935"}}
+	errs := CheckConfig(config)
+	assert.Equal(t, 3, len(errs))
+	assert.Equal(t, true, strings.HasPrefix(errs[0].Error(), "rtmp.extra_listeners[0].addr: invalid listen addr"))
+	assert.Equal(t, true, strings.HasPrefix(errs[1].Error(), "rtmp.addr, http_api.addr: listen addr conflict"))
+	assert.Equal(t, true, strings.HasPrefix(errs[2].Error(), "httpflv.http_listen_addr, hls.http_listen_addr: listen addr conflict"))
+
+	// https证书、回源地址、notify地址
+	config = newConfig()
+	config.HttptsConfig.EnableHttps = true
+	config.HttptsConfig.HttpsListenAddr = ":8080"
+	config.HttptsConfig.HttpsCertFile = "/not/exist/cert.pem"
+	config.HttptsConfig.HttpsKeyFile = "/not/exist/key.pem"
+	config.RelayPushConfig.AddrList = []string{"127.0.0.1:19350/live"}
+	config.RelayPullConfig.Enable = true
+	config.RelayPullConfig.Addr = "127.0.0.1:99999"
+	config.RelayPullConfig.ProxyUrl = "ftp://127.0.0.1:21"
+	config.HttpNotifyConfig.OnSubStart = "127.0.0.1:10101/on_sub_start"
+	errs = CheckConfig(config)
+	assert.Equal(t, 7, len(errs))
+	for i, prefix := range []string{
+		"httpts.https_cert_file, httpts.https_key_file: load failed",
+		"httpflv.http_listen_addr, httpts.https_listen_addr: listen addr conflict",
+		"hls.http_listen_addr, httpts.https_listen_addr: listen addr conflict",
+		"relay_push.addr_list[0]: invalid addr",
+		"relay_pull.addr: invalid addr",
+		"relay_pull.proxy_url:",
+		"http_notify.on_sub_start: invalid url",
+	} {
+		assert.Equal(t, true, strings.HasPrefix(errs[i].Error(), prefix), errs[i].Error())
+	}
+
+	// 推流密钥
+	config = newConfig()
+	config.StreamKeyConfig.Enable = true
+	config.StreamKeyConfig.Keys = []StreamKeyItem{{"k1", "s1"}, {"k1", "s2"}, {"k3", "s1"}, {"k4", ""}}
+	config.StreamKeyConfig.ResolveUrl = "127.0.0.1:10101/resolve"
+	errs = CheckConfig(config)
+	assert.Equal(t, 4, len(errs))
+	for i, prefix := range []string{
+		"stream_key.keys[1]: duplicate stream_key",
+		"stream_key.keys[2]: duplicate stream_name",
+		"stream_key.keys[3]: stream_key and stream_name must not be empty",
+		"stream_key.resolve_url: invalid url",
+	} {
+		assert.Equal(t, true, strings.HasPrefix(errs[i].Error(), prefix), errs[i].Error())
+	}
+
+	// 拉流准入控制
+	config = newConfig()
+	config.AdmissionConfig.Enable = true
+	errs = CheckConfig(config)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, true, strings.HasPrefix(errs[0].Error(), "admission.egress_budget_kbits:"), errs[0].Error())
+
+	// http api限流
+	config = newConfig()
+	config.HttpApiConfig.RateLimitConfig = RateLimitConfig{Enable: true, RatePerSec: 0, Burst: -1}
+	errs = CheckConfig(config)
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, true, strings.HasPrefix(errs[0].Error(), "http_api.rate_limit.rate_per_sec:"), errs[0].Error())
+	assert.Equal(t, true, strings.HasPrefix(errs[1].Error(), "http_api.rate_limit.burst:"), errs[1].Error())
+
+	// 拉流等待推流
+	config = newConfig()
+	config.SubWaitPubConfig = SubWaitPubConfig{Enable: true, TimeoutSec: 0}
+	errs = CheckConfig(config)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, true, strings.HasPrefix(errs[0].Error(), "sub_wait_pub.timeout_sec:"), errs[0].Error())
+}
@@ -0,0 +1,136 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// config_secrets.go
+//
+// 配置文件中字符串类型的配置项，值可以是以下形式的引用，在加载配置时替换为实际的值，见 SecretsConfig
+//
+// - `${env:NAME}`    环境变量NAME的值
+// - `${secret:NAME}` secrets文件中NAME的值
+//
+
+// DefaultSecretsKeyEnv 默认的secrets文件解密密钥所在的环境变量
+const DefaultSecretsKeyEnv = "LAL_SECRETS_KEY"
+
+var secretRefRegexp = regexp.MustCompile(`^\$\{(env|secret):([A-Za-z0-9_.\-]+)\}$`)
+
+// secretRef 被替换的配置项，打印配置时用于还原成引用，避免secret出现在日志中
+type secretRef struct {
+	ref string
+	set func(v string)
+}
+
+// resolveConfigSecrets 替换`config`中所有的引用
+//
+// 引用的环境变量不存在、secret不存在、或者secrets文件解密失败时返回error
+//
+func resolveConfigSecrets(config *Config) (refs []secretRef, values []string, err error) {
+	var secrets map[string]string
+	if config.SecretsConfig.File != "" {
+		keyEnv := config.SecretsConfig.KeyEnv
+		if keyEnv == "" {
+			keyEnv = DefaultSecretsKeyEnv
+		}
+		content, err := ioutil.ReadFile(config.SecretsConfig.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		if secrets, err = base.DecryptSecrets(content, os.Getenv(keyEnv)); err != nil {
+			return nil, nil, fmt.Errorf("%w. file=%s, key env=%s", err, config.SecretsConfig.File, keyEnv)
+		}
+	}
+
+	walkConfigStrings(reflect.ValueOf(config).Elem(), func(s string, set func(string)) {
+		if err != nil {
+			return
+		}
+		m := secretRefRegexp.FindStringSubmatch(s)
+		if m == nil {
+			return
+		}
+		var (
+			v  string
+			ok bool
+		)
+		if m[1] == "env" {
+			v, ok = os.LookupEnv(m[2])
+		} else {
+			v, ok = secrets[m[2]]
+		}
+		if !ok {
+			err = fmt.Errorf("%w. reference not found. ref=%s", base.ErrSecrets, s)
+			return
+		}
+		set(v)
+		refs = append(refs, secretRef{ref: s, set: set})
+		values = append(values, v)
+	})
+	return
+}
+
+// withSecretsMasked 临时把替换过的配置项还原成引用，执行`fn`
+//
+func withSecretsMasked(refs []secretRef, values []string, fn func()) {
+	for _, r := range refs {
+		r.set(r.ref)
+	}
+	fn()
+	for i, r := range refs {
+		r.set(values[i])
+	}
+}
+
+// walkConfigStrings 遍历所有可修改的字符串，包括结构体、数组、指针以及map中的
+//
+func walkConfigStrings(v reflect.Value, fn func(s string, set func(string))) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			fn(v.String(), v.SetString)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkConfigStrings(v.Elem(), fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// 非导出字段
+				continue
+			}
+			walkConfigStrings(v.Field(i), fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkConfigStrings(v.Index(i), fn)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			fn(iter.Value().String(), func(s string) {
+				v.SetMapIndex(k, reflect.ValueOf(s).Convert(v.Type().Elem()))
+			})
+		}
+	}
+}
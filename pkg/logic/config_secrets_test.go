@@ -0,0 +1,78 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestResolveConfigSecrets(t *testing.T) {
+	const key = "lal test passphrase"
+	enc, err := base.EncryptSecrets([]byte(`{"auth_key": "k1", "onvif_password": "p1"}`), key)
+	assert.Equal(t, nil, err)
+	filename := filepath.Join(t.TempDir(), "secrets.enc")
+	_ = ioutil.WriteFile(filename, enc, 0600)
+
+	const keyEnv = "LAL_TEST_SECRETS_KEY"
+	_ = os.Setenv(keyEnv, key)
+	_ = os.Setenv("LAL_TEST_TLS_KEY", "/etc/lal/key.pem")
+	defer os.Unsetenv(keyEnv)
+	defer os.Unsetenv("LAL_TEST_TLS_KEY")
+
+	var config Config
+	config.SecretsConfig.File = filename
+	config.SecretsConfig.KeyEnv = keyEnv
+	config.SimpleAuthConfig.Key = "${secret:auth_key}"
+	config.OnvifConfig.Password = "${secret:onvif_password}"
+	config.OnvifConfig.Username = "admin"
+	config.TlsConfig.Profiles = []TlsProfileConfig{{Name: "default"}}
+	config.TlsConfig.Profiles[0].Certs = []base.TlsCertOption{{CertFile: "./cert.pem", KeyFile: "${env:LAL_TEST_TLS_KEY}"}}
+	config.HttpflvConfig.HttpHeader.ExtraHeaders = map[string]string{"X-Token": "${secret:auth_key}"}
+
+	refs, values, err := resolveConfigSecrets(&config)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(refs))
+	assert.Equal(t, "k1", config.SimpleAuthConfig.Key)
+	assert.Equal(t, "p1", config.OnvifConfig.Password)
+	assert.Equal(t, "admin", config.OnvifConfig.Username)
+	assert.Equal(t, "/etc/lal/key.pem", config.TlsConfig.Profiles[0].Certs[0].KeyFile)
+	assert.Equal(t, "k1", config.HttpflvConfig.HttpHeader.ExtraHeaders["X-Token"])
+
+	// 打印时不包含secret的值
+	var s string
+	withSecretsMasked(refs, values, func() {
+		s = fmt.Sprintf("%+v", config)
+	})
+	assert.Equal(t, false, strings.Contains(s, "p1"))
+	assert.Equal(t, true, strings.Contains(s, "${secret:onvif_password}"))
+	assert.Equal(t, "p1", config.OnvifConfig.Password)
+	assert.Equal(t, "k1", config.HttpflvConfig.HttpHeader.ExtraHeaders["X-Token"])
+
+	// 引用不存在
+	config.OnvifConfig.Password = "${secret:not_exist}"
+	_, _, err = resolveConfigSecrets(&config)
+	assert.Equal(t, true, errors.Is(err, base.ErrSecrets))
+	config.OnvifConfig.Password = "${env:LAL_TEST_NOT_EXIST}"
+	_, _, err = resolveConfigSecrets(&config)
+	assert.Equal(t, true, errors.Is(err, base.ErrSecrets))
+
+	// 密钥错误
+	_ = os.Setenv(keyEnv, "wrong")
+	_, _, err = resolveConfigSecrets(&config)
+	assert.Equal(t, true, errors.Is(err, base.ErrSecrets))
+}
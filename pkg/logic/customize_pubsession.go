@@ -31,6 +31,7 @@ func NewCustomizePubSessionContext(streamName string) *CustomizePubSessionContex
 
 func (ctx *CustomizePubSessionContext) WithOnRtmpMsg(onRtmpMsg func(msg base.RtmpMsg)) *CustomizePubSessionContext {
 	ctx.remuxer.WithOnRtmpMsg(onRtmpMsg)
+	ctx.onRtmpMsg = onRtmpMsg
 	return ctx
 }
 
@@ -55,3 +56,7 @@ func (ctx *CustomizePubSessionContext) FeedAudioSpecificConfig(asc []byte) {
 func (ctx *CustomizePubSessionContext) FeedAvPacket(packet base.AvPacket) {
 	ctx.remuxer.FeedAvPacket(packet)
 }
+
+func (ctx *CustomizePubSessionContext) FeedRtmpMsg(msg base.RtmpMsg) {
+	ctx.onRtmpMsg(msg)
+}
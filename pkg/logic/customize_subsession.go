@@ -0,0 +1,80 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+)
+
+type CustomizeSubSessionContext struct {
+	uniqueKey string
+
+	streamName string
+	handler    ICustomizeSubHandler
+
+	ascSent                 bool
+	shouldWaitVideoKeyFrame bool
+}
+
+func NewCustomizeSubSessionContext(streamName string, handler ICustomizeSubHandler) *CustomizeSubSessionContext {
+	return &CustomizeSubSessionContext{
+		uniqueKey:               base.GenUkCustomizeSubSession(),
+		streamName:              streamName,
+		handler:                 handler,
+		shouldWaitVideoKeyFrame: true,
+	}
+}
+
+func (ctx *CustomizeSubSessionContext) UniqueKey() string {
+	return ctx.uniqueKey
+}
+
+func (ctx *CustomizeSubSessionContext) StreamName() string {
+	return ctx.streamName
+}
+
+// feedAvPacket
+//
+// 第一个音频数据前先回调AudioSpecificConfig。视频从关键帧开始回调
+//
+func (ctx *CustomizeSubSessionContext) feedAvPacket(packet base.AvPacket, asc []byte) {
+	if packet.IsAudio() {
+		if !ctx.ascSent {
+			ctx.handler.OnAudioSpecificConfig(append([]byte(nil), asc...))
+			ctx.ascSent = true
+		}
+	} else if ctx.shouldWaitVideoKeyFrame {
+		if !isKeyFrameAnnexb(packet) {
+			return
+		}
+		ctx.shouldWaitVideoKeyFrame = false
+	}
+	ctx.handler.OnAvPacket(packet)
+}
+
+func isKeyFrameAnnexb(packet base.AvPacket) bool {
+	nals, err := avc.SplitNaluAnnexb(packet.Payload)
+	if err != nil {
+		return false
+	}
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+		if packet.PayloadType == base.AvPacketPtAvc && avc.ParseNaluType(nal[0]) == avc.NaluTypeIdrSlice {
+			return true
+		}
+		if packet.PayloadType == base.AvPacketPtHevc && hevc.IsIrapNalu(hevc.ParseNaluType(nal[0])) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/esingest"
+)
+
+// ES ingest（音视频裸流over tcp）推流，协议见 esingest 包
+//
+// 每路ES输入流在group中以customize pub session的形式存在，鉴权、事件通知等与其他推流协议一致
+//
+
+func (sm *ServerManager) OnNewEsPubSession(session *esingest.PubSession) error {
+	var info base.PubStartInfo
+	info.ServerId = sm.config.ServerId
+	info.Protocol = base.ProtocolEs
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.SessionId = session.UniqueKey()
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckPub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
+
+	// 鉴权、流名称解析可能请求外部服务，不持有sm的锁
+	if err := sm.option.Authentication.OnPubStart(info); err != nil {
+		return err
+	}
+	if err := sm.resolveStreamKey(&info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+	if err := sm.runScriptHook(ScriptHookTypePub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.checkPublishToken(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
+	if sm.backupPublisher != nil {
+		sm.backupPublisher.ApplyRole(&info.SessionEventCommonInfo, session)
+	}
+
+	group := sm.getOrCreateGroup("", session.StreamName())
+	pubCtx, err := group.AddCustomizePubSession(session.StreamName())
+	if err != nil {
+		return err
+	}
+	sm.consumePublishToken(info.SessionEventCommonInfo)
+	session.WithAvPacketStream(pubCtx)
+	sm.esPubSessions[session] = pubCtx
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnPubStart(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStart(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStart(group, info.StreamName)
+	}
+	return nil
+}
+
+func (sm *ServerManager) OnDelEsPubSession(session *esingest.PubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	pubCtx, ok := sm.esPubSessions[session]
+	if !ok {
+		return
+	}
+	delete(sm.esPubSessions, session)
+	group := sm.getGroup("", session.StreamName())
+	if group == nil {
+		return
+	}
+
+	tags := group.GetSessionTags(session.UniqueKey())
+	group.DelCustomizePubSession(pubCtx)
+
+	var info base.PubStopInfo
+	info.ServerId = sm.config.ServerId
+	info.Protocol = base.ProtocolEs
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.SessionId = session.UniqueKey()
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	info.Tags = tags
+	sm.option.NotifyHandler.OnPubStop(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStop(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStop(group, info.StreamName)
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/mp4"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// 切换文件、循环播放时，上一个文件最后一帧和下一个文件第一帧之间的时间戳间隔，单位毫秒
+const filePublishLoopGapMs = 40
+
+// filePublisher 将本地flv、mp4文件按时间戳的实际间隔输入到group中，模拟一路直播流
+//
+// `filenames`有多个时，依次播放列表中的文件（频道）
+// 输出的时间戳从0开始，切换文件、循环播放时连续递增，每个文件开始时重新发送该文件的metadata和seq header
+// 打开、读取失败的文件会被跳过
+//
+type filePublisher struct {
+	sm         *ServerManager
+	streamName string
+	filenames  []string
+	loop       bool
+	seekMs     int64 // 只对第一轮的第一个文件生效
+
+	pubCtx ICustomizePubSessionContext
+
+	stopOnce sync.Once
+	exitChan chan struct{}
+}
+
+func (p *filePublisher) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.exitChan)
+	})
+}
+
+func (p *filePublisher) RunLoop() {
+	defer p.sm.onFilePublishDone(p)
+	p.play(time.Now())
+}
+
+// play 播放列表，直到播放结束或者被Stop
+func (p *filePublisher) play(startTime time.Time) {
+	Log.Infof("[%s] file publish start. stream=%s, files=%v, loop=%v, seek=%d",
+		p.pubCtx.UniqueKey(), p.streamName, p.filenames, p.loop, p.seekMs)
+	var offset int64
+	seekMs := p.seekMs
+	for {
+		var played bool
+		for _, filename := range p.filenames {
+			lastTs, err := p.playOnce(filename, seekMs, offset, startTime)
+			// 之后的文件都从头开始播放
+			seekMs = 0
+			if err == base.ErrFilePublishStopped {
+				Log.Infof("[%s] file publish stopped.", p.pubCtx.UniqueKey())
+				return
+			}
+			if err != nil {
+				Log.Warnf("[%s] file publish skip file. file=%s, err=%+v", p.pubCtx.UniqueKey(), filename, err)
+				continue
+			}
+			played = true
+			offset = lastTs + filePublishLoopGapMs
+		}
+		// 一轮中没有任何文件播放成功时也结束，避免空转
+		if !p.loop || !played {
+			Log.Infof("[%s] file publish done. played=%v", p.pubCtx.UniqueKey(), played)
+			return
+		}
+	}
+}
+
+// playOnce 从seekMs之后的第一个关键帧（纯音频文件为第一个音频帧）开始播放一遍文件
+//
+// @param offset:    本轮输出的第一帧的时间戳
+// @return lastTs:   本轮输出的最后一帧的时间戳
+//
+func (p *filePublisher) playOnce(filename string, seekMs int64, offset int64, startTime time.Time) (lastTs int64, err error) {
+	src, err := openFileSource(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Dispose()
+
+	var (
+		started bool
+		firstTs int64 = -1 // 文件中第一个音视频帧的时间戳，seek的位置相对于它
+		baseTs  int64
+		pending []base.RtmpMsg // 开始播放之前，文件中的metadata、seq header，开始播放时发送
+	)
+	lastTs = offset
+	for {
+		msg, err := src.ReadMsg()
+		if err == io.EOF {
+			if !started {
+				return 0, fmt.Errorf("%w. seek=%d", base.ErrFilePublishNoAvData, seekMs)
+			}
+			return lastTs, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if isFilePublishConfigMsg(msg) {
+			if !started {
+				pending = appendOrReplaceConfigMsg(pending, msg)
+				continue
+			}
+			msg.Header.TimestampAbs = uint32(lastTs)
+			p.pubCtx.FeedRtmpMsg(msg)
+			continue
+		}
+
+		if !started {
+			if firstTs < 0 {
+				firstTs = int64(msg.Header.TimestampAbs)
+			}
+			if !isFilePublishStartPoint(msg, int64(msg.Header.TimestampAbs)-firstTs, seekMs, src.HasVideo()) {
+				continue
+			}
+			started = true
+			baseTs = int64(msg.Header.TimestampAbs)
+			for _, m := range pending {
+				m.Header.TimestampAbs = uint32(offset)
+				p.pubCtx.FeedRtmpMsg(m)
+			}
+		}
+
+		ts := int64(msg.Header.TimestampAbs) - baseTs + offset
+		if ts < offset {
+			ts = offset
+		}
+		if err = p.waitUntil(startTime.Add(time.Duration(ts) * time.Millisecond)); err != nil {
+			return 0, err
+		}
+		msg.Header.TimestampAbs = uint32(ts)
+		p.pubCtx.FeedRtmpMsg(msg)
+		lastTs = ts
+	}
+}
+
+func (p *filePublisher) waitUntil(t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		select {
+		case <-p.exitChan:
+			return base.ErrFilePublishStopped
+		default:
+			return nil
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.exitChan:
+		return base.ErrFilePublishStopped
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isFilePublishConfigMsg(msg base.RtmpMsg) bool {
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdMetadata:
+		return true
+	case base.RtmpTypeIdVideo, base.RtmpTypeIdAudio:
+		return len(msg.Payload) > 1 && (msg.IsVideoKeySeqHeader() || msg.IsAacSeqHeader())
+	}
+	return false
+}
+
+func isFilePublishStartPoint(msg base.RtmpMsg, pos int64, seekMs int64, hasVideo bool) bool {
+	if pos < seekMs {
+		return false
+	}
+	if !hasVideo {
+		return msg.Header.MsgTypeId == base.RtmpTypeIdAudio
+	}
+	return msg.Header.MsgTypeId == base.RtmpTypeIdVideo && len(msg.Payload) > 0 && msg.Payload[0]>>4 == base.RtmpFrameTypeKey
+}
+
+// appendOrReplaceConfigMsg 同一种类型只保留最后一个
+func appendOrReplaceConfigMsg(msgs []base.RtmpMsg, msg base.RtmpMsg) []base.RtmpMsg {
+	for i := range msgs {
+		if msgs[i].Header.MsgTypeId == msg.Header.MsgTypeId {
+			msgs[i] = msg
+			return msgs
+		}
+	}
+	return append(msgs, msg)
+}
+
+func (sm *ServerManager) startFilePublish(streamName string, filenames []string, loop bool, seekMs int64) (*filePublisher, error) {
+	pubCtx, err := sm.AddCustomizePubSession(streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filePublisher{
+		sm:         sm,
+		streamName: streamName,
+		filenames:  filenames,
+		loop:       loop,
+		seekMs:     seekMs,
+		pubCtx:     pubCtx,
+		exitChan:   make(chan struct{}),
+	}
+	sm.mutex.Lock()
+	sm.filePublishers[streamName] = p
+	sm.mutex.Unlock()
+	go p.RunLoop()
+	return p, nil
+}
+
+// startFilePublishChannels 启动配置中的频道，文件不存在等错误只打印日志，在播放时跳过
+func (sm *ServerManager) startFilePublishChannels() {
+	root := sm.config.FilePublishConfig.RootDir
+	for _, c := range sm.config.FilePublishConfig.Channels {
+		filenames := make([]string, 0, len(c.Files))
+		for _, f := range c.Files {
+			filename := resolveFilePublishPath(root, f)
+			if src, err := openFileSource(filename); err != nil {
+				Log.Warnf("file publish channel file invalid. stream=%s, file=%s, err=%+v", c.StreamName, filename, err)
+			} else {
+				src.Dispose()
+			}
+			filenames = append(filenames, filename)
+		}
+		if _, err := sm.startFilePublish(c.StreamName, filenames, c.Loop, 0); err != nil {
+			Log.Errorf("start file publish channel failed. stream=%s, err=%+v", c.StreamName, err)
+		}
+	}
+}
+
+func (sm *ServerManager) onFilePublishDone(p *filePublisher) {
+	sm.DelCustomizePubSession(p.pubCtx)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if sm.filePublishers[p.streamName] == p {
+		delete(sm.filePublishers, p.streamName)
+	}
+}
+
+// resolveFilePublishPath 文件路径限制在`root`目录下
+func resolveFilePublishPath(root string, filename string) string {
+	return filepath.Join(root, filepath.Clean("/"+filename))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type fileSource interface {
+	HasVideo() bool
+
+	// ReadMsg 按照文件中的顺序读取音视频数据，读完时返回io.EOF
+	ReadMsg() (base.RtmpMsg, error)
+
+	Dispose()
+}
+
+// openFileSource 根据文件后缀名选择flv或者mp4
+func openFileSource(filename string) (fileSource, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".flv":
+		return openFlvFileSource(filename)
+	case ".mp4", ".m4v", ".mov":
+		return openMp4FileSource(filename)
+	}
+	return nil, fmt.Errorf("%w. file=%s", base.ErrFilePublishFileType, filename)
+}
+
+type flvFileSource struct {
+	ffr      httpflv.FlvFileReader
+	hasVideo bool
+}
+
+func openFlvFileSource(filename string) (*flvFileSource, error) {
+	s := &flvFileSource{}
+	if err := s.ffr.Open(filename); err != nil {
+		return nil, err
+	}
+	header, err := s.ffr.ReadFlvHeader()
+	if err != nil {
+		s.ffr.Dispose()
+		return nil, err
+	}
+	// flv header中的TypeFlagsVideo
+	s.hasVideo = len(header) > 4 && header[4]&0x01 != 0
+	return s, nil
+}
+
+func (s *flvFileSource) HasVideo() bool {
+	return s.hasVideo
+}
+
+func (s *flvFileSource) ReadMsg() (base.RtmpMsg, error) {
+	for {
+		tag, err := s.ffr.ReadTag()
+		if err != nil {
+			return base.RtmpMsg{}, err
+		}
+		if len(tag.Payload()) == 0 {
+			continue
+		}
+		return remux.FlvTag2RtmpMsg(tag), nil
+	}
+}
+
+func (s *flvFileSource) Dispose() {
+	s.ffr.Dispose()
+}
+
+type mp4FileSource struct {
+	r        *mp4.Reader
+	hasVideo bool
+	pending  []base.RtmpMsg // 还没有读取的seq header
+	next     []int          // 每个轨道下一个sample的下标
+}
+
+func openMp4FileSource(filename string) (*mp4FileSource, error) {
+	r, err := mp4.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &mp4FileSource{
+		r:    r,
+		next: make([]int, len(r.Tracks)),
+	}
+	for i := range r.Tracks {
+		t := &r.Tracks[i]
+		if t.Type == mp4.TrackTypeVideo {
+			s.hasVideo = true
+		}
+		s.pending = append(s.pending, remux.Mp4Track2RtmpSeqHeader(t))
+	}
+	return s, nil
+}
+
+func (s *mp4FileSource) HasVideo() bool {
+	return s.hasVideo
+}
+
+func (s *mp4FileSource) ReadMsg() (base.RtmpMsg, error) {
+	if len(s.pending) > 0 {
+		msg := s.pending[0]
+		s.pending = s.pending[1:]
+		return msg, nil
+	}
+
+	// 选择下一个sample的dts最小的轨道
+	index := -1
+	for i := range s.r.Tracks {
+		if s.next[i] >= len(s.r.Tracks[i].Samples) {
+			continue
+		}
+		if index == -1 || s.r.Tracks[i].Samples[s.next[i]].Dts < s.r.Tracks[index].Samples[s.next[index]].Dts {
+			index = i
+		}
+	}
+	if index == -1 {
+		return base.RtmpMsg{}, io.EOF
+	}
+
+	t := &s.r.Tracks[index]
+	sample := t.Samples[s.next[index]]
+	s.next[index]++
+	data, err := s.r.ReadSample(sample)
+	if err != nil {
+		return base.RtmpMsg{}, err
+	}
+	return remux.Mp4Sample2RtmpMsg(t, sample, data), nil
+}
+
+func (s *mp4FileSource) Dispose() {
+	_ = s.r.Dispose()
+}
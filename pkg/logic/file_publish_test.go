@@ -0,0 +1,111 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestResolveFilePublishPath(t *testing.T) {
+	assert.Equal(t, filepath.Join("/data/vod", "a.flv"), resolveFilePublishPath("/data/vod", "a.flv"))
+	assert.Equal(t, filepath.Join("/data/vod", "b/a.flv"), resolveFilePublishPath("/data/vod", "/b/a.flv"))
+	assert.Equal(t, filepath.Join("/data/vod", "etc/passwd.flv"), resolveFilePublishPath("/data/vod", "../../etc/passwd.flv"))
+}
+
+func TestFilePublisher_playOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_file_publish_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.flv")
+
+	newVideoMsg := func(ts uint32, payload ...byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header: base.RtmpHeader{
+				MsgTypeId:    base.RtmpTypeIdVideo,
+				MsgLen:       uint32(len(payload)),
+				TimestampAbs: ts,
+			},
+			Payload: payload,
+		}
+	}
+	var ffw httpflv.FlvFileWriter
+	assert.Equal(t, nil, ffw.Open(filename))
+	assert.Equal(t, nil, ffw.WriteFlvHeader())
+	for _, msg := range []base.RtmpMsg{
+		newVideoMsg(1000, 0x17, 0x00, 0, 0, 0, 0x01),
+		newVideoMsg(1000, 0x17, 0x01, 0, 0, 0, 0x01),
+		newVideoMsg(1040, 0x27, 0x01, 0, 0, 0, 0x02),
+		newVideoMsg(1080, 0x17, 0x01, 0, 0, 0, 0x03),
+		newVideoMsg(1120, 0x27, 0x01, 0, 0, 0, 0x04),
+	} {
+		assert.Equal(t, nil, ffw.WriteTag(*remux.RtmpMsg2FlvTag(msg)))
+	}
+	assert.Equal(t, nil, ffw.Dispose())
+
+	var msgs []base.RtmpMsg
+	pubCtx := NewCustomizePubSessionContext("test").WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		msgs = append(msgs, msg)
+	})
+	p := &filePublisher{
+		filenames: []string{filename},
+		pubCtx:    pubCtx,
+		exitChan:  make(chan struct{}),
+	}
+	startTime := time.Now().Add(-time.Hour)
+
+	// 从头播放，时间戳从offset开始
+	lastTs, err := p.playOnce(filename, 0, 100, startTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(220), lastTs)
+	assert.Equal(t, 5, len(msgs))
+	assert.Equal(t, true, msgs[0].IsVideoKeySeqHeader())
+	for i, ts := range []uint32{100, 100, 140, 180, 220} {
+		assert.Equal(t, ts, msgs[i].Header.TimestampAbs)
+	}
+
+	// seek到第二个关键帧，seq header仍然会发送
+	msgs = nil
+	lastTs, err = p.playOnce(filename, 50, 0, startTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(40), lastTs)
+	assert.Equal(t, 3, len(msgs))
+	assert.Equal(t, true, msgs[0].IsVideoKeySeqHeader())
+	assert.Equal(t, byte(0x03), msgs[1].Payload[5])
+
+	_, err = p.playOnce(filename, 5000, 0, startTime)
+	assert.Equal(t, true, errors.Is(err, base.ErrFilePublishNoAvData))
+
+	// 频道，跳过无效的文件，文件之间的时间戳连续
+	msgs = nil
+	p.filenames = []string{filename, filepath.Join(dir, "not_exist.flv"), filename}
+	p.seekMs = 50
+	p.play(startTime)
+	assert.Equal(t, 8, len(msgs))
+	for i, ts := range []uint32{0, 0, 40, 80, 80, 120, 160, 200} {
+		assert.Equal(t, ts, msgs[i].Header.TimestampAbs)
+	}
+	assert.Equal(t, true, msgs[3].IsVideoKeySeqHeader())
+
+	p.Stop()
+	_, err = p.playOnce(filename, 0, 0, time.Now())
+	assert.Equal(t, true, errors.Is(err, base.ErrFilePublishStopped))
+
+	_, err = openFileSource(filepath.Join(dir, "test.ts"))
+	assert.Equal(t, true, errors.Is(err, base.ErrFilePublishFileType))
+}
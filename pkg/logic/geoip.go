@@ -0,0 +1,135 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/geoip"
+)
+
+// 缓存的ip数量上限，超过时清空重建
+const geoIpCacheMaxSize = 65536
+
+// GeoIp 根据session的远端地址查询地理位置，用于stat、日志以及事件通知
+//
+// 位置数据库（country、city）和ASN数据库是MaxMind分开发布的，所以可以分别配置，查询结果会合并
+//
+// 并发安全。方法可以在nil上调用，此时表示没有开启geoip，返回nil
+//
+type GeoIp struct {
+	readers []*geoip.Reader
+
+	mutex sync.Mutex
+	cache map[string]*base.StatGeo // key: ip, value为nil表示没有记录
+}
+
+// NewGeoIp 打开配置的数据库，打开失败的数据库会被忽略，全部失败时返回nil
+//
+func NewGeoIp(config GeoIpConfig) *GeoIp {
+	gi := &GeoIp{
+		cache: make(map[string]*base.StatGeo),
+	}
+	for _, filename := range []string{config.DbFile, config.AsnDbFile} {
+		if filename == "" {
+			continue
+		}
+		b := time.Now()
+		r, err := geoip.Open(filename)
+		if err != nil {
+			Log.Warnf("open geoip db failed. file=%s, err=%+v", filename, err)
+			continue
+		}
+		Log.Infof("open geoip db. file=%s, type=%s, build epoch=%d, cost=%dms",
+			filename, r.Metadata.DatabaseType, r.Metadata.BuildEpoch, time.Since(b).Milliseconds())
+		gi.readers = append(gi.readers, r)
+	}
+	if len(gi.readers) == 0 {
+		return nil
+	}
+	return gi
+}
+
+// LookupAddr
+//
+// @param addr: 格式为ip:port，或者只有ip
+//
+func (gi *GeoIp) LookupAddr(addr string) *base.StatGeo {
+	if gi == nil || addr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	gi.mutex.Lock()
+	defer gi.mutex.Unlock()
+	if geo, ok := gi.cache[host]; ok {
+		return geo
+	}
+
+	geo := gi.lookup(host)
+	Log.Infof("lookup geoip. ip=%s, geo=%+v", host, geo)
+	if len(gi.cache) >= geoIpCacheMaxSize {
+		gi.cache = make(map[string]*base.StatGeo)
+	}
+	gi.cache[host] = geo
+	return geo
+}
+
+// FillStatGroup 填充group中所有pub、sub、pull session的地理位置
+//
+func (gi *GeoIp) FillStatGroup(sg *base.StatGroup) {
+	if gi == nil {
+		return
+	}
+	sg.StatPub.Geo = gi.LookupAddr(sg.StatPub.RemoteAddr)
+	sg.StatPull.Geo = gi.LookupAddr(sg.StatPull.RemoteAddr)
+	for i := range sg.StatSubs {
+		sg.StatSubs[i].Geo = gi.LookupAddr(sg.StatSubs[i].RemoteAddr)
+	}
+}
+
+func (gi *GeoIp) lookup(host string) *base.StatGeo {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	var ret base.StatGeo
+	var found bool
+	for _, r := range gi.readers {
+		geo, ok, err := r.LookupGeo(ip)
+		if err != nil {
+			Log.Warnf("lookup geoip failed. ip=%s, type=%s, err=%+v", host, r.Metadata.DatabaseType, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		found = true
+		if geo.Country != "" {
+			ret.Country = geo.Country
+			ret.Region = geo.Region
+			ret.City = geo.City
+		}
+		if geo.Asn != 0 {
+			ret.Asn = geo.Asn
+			ret.AsnOrg = geo.AsnOrg
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &ret
+}
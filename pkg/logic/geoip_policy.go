@@ -0,0 +1,117 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// GeoAccessPolicy 按推拉流url中的域名（vhost）匹配策略，根据session的地理位置（见 GeoIp ）决定是否允许推拉流
+//
+// 方法可以在nil上调用，此时表示没有配置策略，全部允许
+//
+type GeoAccessPolicy struct {
+	vhosts        map[string]GeoAccessPolicyConfig // key: 小写的域名
+	defaultPolicy *GeoAccessPolicyConfig
+}
+
+func NewGeoAccessPolicy(policies []GeoAccessPolicyConfig) *GeoAccessPolicy {
+	if len(policies) == 0 {
+		return nil
+	}
+	gap := &GeoAccessPolicy{
+		vhosts: make(map[string]GeoAccessPolicyConfig),
+	}
+	for i := range policies {
+		if policies[i].Vhost == "" {
+			gap.defaultPolicy = &policies[i]
+			continue
+		}
+		gap.vhosts[strings.ToLower(policies[i].Vhost)] = policies[i]
+	}
+	return gap
+}
+
+func (gap *GeoAccessPolicy) CheckPub(info base.SessionEventCommonInfo) error {
+	policy := gap.match(info.Url)
+	if policy == nil {
+		return nil
+	}
+	return gap.check(policy.Pub, info)
+}
+
+func (gap *GeoAccessPolicy) CheckSub(info base.SessionEventCommonInfo) error {
+	policy := gap.match(info.Url)
+	if policy == nil {
+		return nil
+	}
+	return gap.check(policy.Sub, info)
+}
+
+func (gap *GeoAccessPolicy) match(rawUrl string) *GeoAccessPolicyConfig {
+	if gap == nil {
+		return nil
+	}
+	if ctx, err := base.ParseUrl(rawUrl, -1); err == nil {
+		if policy, ok := gap.vhosts[strings.ToLower(ctx.Host)]; ok {
+			return &policy
+		}
+	}
+	return gap.defaultPolicy
+}
+
+func (gap *GeoAccessPolicy) check(rule GeoAccessRuleConfig, info base.SessionEventCommonInfo) error {
+	geo := info.Geo
+	if geo == nil {
+		if rule.AllowUnknown || (len(rule.AllowCountries) == 0 && len(rule.AllowAsns) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%w. geo unknown. remote addr=%s", base.ErrGeoAccessDenied, info.RemoteAddr)
+	}
+
+	for _, c := range rule.DenyCountries {
+		if strings.EqualFold(c, geo.Country) {
+			return fmt.Errorf("%w. country denied. remote addr=%s, country=%s", base.ErrGeoAccessDenied, info.RemoteAddr, geo.Country)
+		}
+	}
+	for _, asn := range rule.DenyAsns {
+		if asn == geo.Asn {
+			return fmt.Errorf("%w. asn denied. remote addr=%s, asn=%d", base.ErrGeoAccessDenied, info.RemoteAddr, geo.Asn)
+		}
+	}
+
+	if len(rule.AllowCountries) != 0 && !(geo.Country == "" && rule.AllowUnknown) {
+		var allowed bool
+		for _, c := range rule.AllowCountries {
+			if strings.EqualFold(c, geo.Country) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w. country not allowed. remote addr=%s, country=%s", base.ErrGeoAccessDenied, info.RemoteAddr, geo.Country)
+		}
+	}
+	if len(rule.AllowAsns) != 0 && !(geo.Asn == 0 && rule.AllowUnknown) {
+		var allowed bool
+		for _, asn := range rule.AllowAsns {
+			if asn == geo.Asn {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w. asn not allowed. remote addr=%s, asn=%d", base.ErrGeoAccessDenied, info.RemoteAddr, geo.Asn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/esingest"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGeoAccessPolicy(t *testing.T) {
+	var nilPolicy *GeoAccessPolicy
+	assert.Equal(t, nil, nilPolicy.CheckPub(base.SessionEventCommonInfo{}))
+
+	gap := NewGeoAccessPolicy([]GeoAccessPolicyConfig{
+		{
+			Vhost: "Live.Example.com",
+			Pub:   GeoAccessRuleConfig{AllowCountries: []string{"CN"}},
+			Sub:   GeoAccessRuleConfig{DenyCountries: []string{"US"}, DenyAsns: []uint32{15169}},
+		},
+		{
+			Pub: GeoAccessRuleConfig{AllowAsns: []uint32{4134}, AllowUnknown: true},
+		},
+	})
+	info := func(url string, geo *base.StatGeo) base.SessionEventCommonInfo {
+		return base.SessionEventCommonInfo{Url: url, RemoteAddr: "1.2.3.4:5678", Geo: geo}
+	}
+	denied := func(err error) bool {
+		return errors.Is(err, base.ErrGeoAccessDenied)
+	}
+	cn := &base.StatGeo{Country: "CN", Asn: 4134}
+	us := &base.StatGeo{Country: "US", Asn: 15169}
+	jp := &base.StatGeo{Country: "JP", Asn: 15169}
+
+	// vhost策略
+	assert.Equal(t, nil, gap.CheckPub(info("rtmp://live.example.com/live/a", cn)))
+	assert.Equal(t, true, denied(gap.CheckPub(info("rtmp://live.example.com:1935/live/a", us))))
+	assert.Equal(t, true, denied(gap.CheckPub(info("rtmp://live.example.com/live/a", nil))))
+	assert.Equal(t, nil, gap.CheckSub(info("http://live.example.com/live/a.flv", cn)))
+	assert.Equal(t, true, denied(gap.CheckSub(info("http://live.example.com/live/a.flv", us))))
+	assert.Equal(t, true, denied(gap.CheckSub(info("http://live.example.com/live/a.flv", jp))))
+	assert.Equal(t, nil, gap.CheckSub(info("http://live.example.com/live/a.flv", nil)))
+
+	// 默认策略
+	assert.Equal(t, nil, gap.CheckPub(info("rtmp://127.0.0.1/live/a", cn)))
+	assert.Equal(t, true, denied(gap.CheckPub(info("rtmp://127.0.0.1/live/a", jp))))
+	assert.Equal(t, nil, gap.CheckPub(info("rtmp://127.0.0.1/live/a", nil)))
+	assert.Equal(t, nil, gap.CheckPub(info("rtmp://127.0.0.1/live/a", &base.StatGeo{Country: "JP"})))
+	assert.Equal(t, nil, gap.CheckSub(info("rtmp://127.0.0.1/live/a", us)))
+}
+
+func TestGeoAccessPolicy_Hls(t *testing.T) {
+	sm := &ServerManager{
+		option: Option{Authentication: NewSimpleAuthCtx(SimpleAuthConfig{})},
+		geoAccessPolicy: NewGeoAccessPolicy([]GeoAccessPolicyConfig{
+			{
+				Vhost: "live.example.com",
+				Sub:   GeoAccessRuleConfig{AllowCountries: []string{"CN"}},
+			},
+		}),
+	}
+	check := func(rawUrl string) error {
+		urlCtx, err := base.ParseUrl(rawUrl, 80)
+		assert.Equal(t, nil, err)
+		return sm.checkHls(rawUrl, urlCtx, "1.2.3.4:5678")
+	}
+
+	// 没有geoip数据库时地区未知，不在允许的地区中，m3u8和ts都被拒绝
+	assert.Equal(t, true, errors.Is(check("http://live.example.com/hls/test110.m3u8"), base.ErrGeoAccessDenied))
+	assert.Equal(t, true, errors.Is(check("http://live.example.com/hls/test110/test110-1.ts"), base.ErrGeoAccessDenied))
+	assert.Equal(t, nil, check("http://127.0.0.1:8080/hls/test110.m3u8"))
+}
+
+func TestGeoAccessPolicy_EsPub(t *testing.T) {
+	sm := &ServerManager{
+		config: &Config{},
+		option: Option{Authentication: NewSimpleAuthCtx(SimpleAuthConfig{})},
+		geoAccessPolicy: NewGeoAccessPolicy([]GeoAccessPolicyConfig{
+			{
+				Pub: GeoAccessRuleConfig{AllowCountries: []string{"CN"}},
+			},
+		}),
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	// es ingest推流没有url，使用默认策略
+	err := sm.OnNewEsPubSession(esingest.NewPubSession(c1))
+	assert.Equal(t, true, errors.Is(err, base.ErrGeoAccessDenied))
+}
@@ -0,0 +1,28 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGeoIp(t *testing.T) {
+	gi := NewGeoIp(GeoIpConfig{Enable: true, DbFile: "/not/exist.mmdb"})
+	assert.Equal(t, true, gi == nil)
+
+	// 没有开启时
+	assert.Equal(t, true, gi.LookupAddr("1.2.3.4:1935") == nil)
+	sg := base.StatGroup{StatSubs: []base.StatSub{{}}}
+	sg.StatSubs[0].RemoteAddr = "1.2.3.4:1935"
+	gi.FillStatGroup(&sg)
+	assert.Equal(t, true, sg.StatSubs[0].Geo == nil)
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/hls"
@@ -26,6 +27,24 @@ import (
 
 type IGroupObserver interface {
 	CleanupHlsIfNeeded(appName string, streamName string, path string)
+
+	// IsRecordPaused 是否暂停flv、mpegts录制，比如磁盘空间不足
+	IsRecordPaused() bool
+
+	// OnRelayPullStart 等relay事件，注意，调用时没有持有group的锁
+	OnRelayPullStart(info base.RelayPullStartInfo)
+	OnRelayPullStop(info base.RelayPullStopInfo)
+	OnRelayPushStart(info base.RelayPushStartInfo)
+	OnRelayPushStop(info base.RelayPushStopInfo)
+
+	// OnBitstreamError 注意，调用时持有group的锁
+	OnBitstreamError(info base.BitstreamErrorInfo)
+
+	// OnPubViolation 注意，调用时持有group的锁
+	OnPubViolation(info base.PubViolationInfo)
+
+	// OnStreamEvent 没有对应事件通知的流事件，比如编码参数变化，记录到流的时间线中。注意，调用时持有group的锁
+	OnStreamEvent(streamName string, event base.StreamEvent)
 }
 
 type Group struct {
@@ -46,17 +65,44 @@ type Group struct {
 	rtmp2RtspRemuxer    *remux.Rtmp2RtspRemuxer
 	rtmp2MpegtsRemuxer  *remux.Rtmp2MpegtsRemuxer
 	// pull
-	pullEnable bool
-	pullUrl    string
-	pullProxy  *pullProxy
+	pullEnable     bool
+	pullUrl        string
+	pullUrlFromApi bool // 拉流地址是否由http api指定
+	pullKeepAlone  bool // http api指定，没有sub session时也保持拉流
+	pullProxy      *pullProxy
+	pullRequestId  string // http api指定，触发拉流的请求的request id
 	// rtmp pub使用
 	dummyAudioFilter *remux.DummyAudioFilter
+	// 输入流视频数据合法性检查
+	bitstreamChecker *bitstreamChecker
+	// 输入流视频的gop、B帧统计
+	videoStatAnalyser *videoStatAnalyser
+	// 推流编码参数限制的检查状态
+	pubConstraint *pubConstraintState
 	// rtmp sub使用
 	rtmpGopCache *remux.GopCache
 	// httpflv sub使用
 	httpflvGopCache *remux.GopCache
 	// httpts使用
 	httptsGopCache *remux.GopCacheMpegts
+	// 输入流最近一次的视频seq header，用于判断流中途是否发生了变化
+	videoSeqHeader []byte
+	// 输入流最近一次的音频seq header
+	aacSeqHeader []byte
+	// 输入流的metadata，以及通过http api设置的覆盖字段
+	metadata         rtmp.ObjectPairArray
+	metadataHeader   base.RtmpHeader
+	metadataOverride map[string]interface{}
+	// 最近一次转发的音视频消息的时间戳，用于http api注入的data message
+	lastAvTs uint32
+	// 当前的输入流（pub或pull）的音视频编码信息是否已经完整，见 onInAvData
+	inCodecReady bool
+	// 当前的输入流是否已经收到音频、视频的seq header，以及metadata是否表明没有视频
+	inHasAudioSeqHeader bool
+	inHasVideoSeqHeader bool
+	inMetadataNoVideo   bool
+	// 有 WaitPullAvData 在等待时不为nil，输入流的编码信息完整时close，通知等待方
+	avDataCh chan struct{}
 	// rtsp使用
 	sdpCtx *sdp.LogicContext
 	// mpegts使用
@@ -66,6 +112,9 @@ type Group struct {
 	httpflvSubSessionSet map[*httpflv.SubSession]struct{}
 	httptsSubSessionSet  map[*httpts.SubSession]struct{}
 	rtspSubSessionSet    map[*rtsp.SubSession]struct{}
+	// customize sub使用
+	customizeSubSessionSet map[*CustomizeSubSessionContext]struct{}
+	rtmp2AvPacketRemuxer   *remux.Rtmp2AvPacketRemuxer
 	// push
 	pushEnable    bool
 	url2PushProxy map[string]*pushProxy
@@ -76,6 +125,27 @@ type Group struct {
 	recordMpegts *mpegts.FileWriter
 	// rtmp sub使用
 	rtmpMergeWriter *base.MergeWriter // TODO(chef): 后面可以在业务层加一个定时Flush
+	// 磁盘空间不足等原因暂停了录制
+	recordPaused bool
+	// 续录使用
+	resumeDeadline int64 // 等待续录的截止时间，unix秒，0表示没有在等待续录
+	resumeTsFlag   bool  // 续录后的第一个消息，需要计算时间戳偏移
+	resumeTsOffset uint32
+	resumeMaxTs    uint32
+	// session id -> 业务方设置的标签
+	sessionTags map[string]map[string]string
+	// hls挂载的WebVTT字幕
+	subtitleTracks []hls.SubtitleTrack
+	// 断开的httpflv sub session id -> 断点，见 group__httpflv_resume.go
+	httpflvResumes map[string]httpflvResumePoint
+	// 等待推流的sub session id -> 加入时间，unix秒
+	subWaitPubSince map[string]int64
+	// lalserver内部的sub session id，比如转码的ffmpeg拉流，不计入观看人数
+	internalSubs map[string]struct{}
+	// 切换输入源，见 group__source_switch.go
+	sourceSwitch  *sourceSwitch
+	rtmpTaps      map[*Group]struct{} // 以本group为输入源的group
+	ownInputCount uint64              // 本group自身输入的消息数量，用于判断输入是否卡顿
 	//
 	stat base.StatGroup
 }
@@ -102,10 +172,17 @@ func NewGroup(appName string, streamName string, config *Config, observer IGroup
 		httptsGopCache:       remux.NewGopCacheMpegts(uk, config.HttptsConfig.GopNum),
 		pullProxy:            &pullProxy{},
 	}
+	g.customizeSubSessionSet = make(map[*CustomizeSubSessionContext]struct{})
+	g.rtmp2AvPacketRemuxer = remux.NewRtmp2AvPacketRemuxer(g.onAvPacketFromRemux)
 
 	g.initRelayPush()
 	g.initRelayPull()
 
+	if config.FastStartConfig.Enable {
+		g.rtmpGopCache.SetKeepKeyFrame(true)
+		g.httpflvGopCache.SetKeepKeyFrame(true)
+	}
+
 	if config.RtmpConfig.MergeWriteSize > 0 {
 		g.rtmpMergeWriter = base.NewMergeWriter(g.writev2RtmpSubSessions, config.RtmpConfig.MergeWriteSize)
 	}
@@ -129,6 +206,11 @@ func (group *Group) Tick(tickCount uint32) {
 	group.stopPullIfNeeded()
 	group.pullIfNeeded()
 	group.startPushIfNeeded()
+	group.pauseOrResumeRecordIfNeeded()
+	group.stopResumeRecordIfTimeout(time.Now().Unix())
+	group.stopPullWaitIfTimeout(time.Now().UnixNano() / 1e6)
+	group.keepaliveOrDisposeSubWaitPub(tickCount, time.Now().Unix())
+	group.checkPublishConstraint()
 
 	// 定时关闭没有数据的session
 	if tickCount%checkSessionAliveIntervalSec == 0 {
@@ -176,7 +258,9 @@ func (group *Group) Dispose() {
 	}
 	group.httptsSubSessionSet = nil
 
+	group.disposeSourceSwitch()
 	group.delIn()
+	group.stopWaitingResumeRecord()
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -194,16 +278,27 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 
 	if group.rtmpPubSession != nil {
 		group.stat.StatPub = base.StatSession2Pub(group.rtmpPubSession.GetStat())
+		group.stat.StatPub.Tags = group.getSessionTags(group.stat.StatPub.SessionId)
 	} else if group.rtspPubSession != nil {
 		group.stat.StatPub = base.StatSession2Pub(group.rtspPubSession.GetStat())
+		group.stat.StatPub.Tags = group.getSessionTags(group.stat.StatPub.SessionId)
 	} else {
 		group.stat.StatPub = base.StatPub{}
 	}
 
 	if group.pullProxy.pullSession != nil {
 		group.stat.StatPull = base.StatSession2Pull(group.pullProxy.pullSession.GetStat())
+		group.stat.StatPull.Tags = group.getSessionTags(group.stat.StatPull.SessionId)
+	} else {
+		group.stat.StatPull = base.StatPull{}
 	}
 
+	group.stat.Metadata, group.stat.MetadataOverride = group.metadataStat()
+
+	group.stat.ViewerCount = group.viewerCount()
+
+	group.stat.VideoStat = group.videoStat()
+
 	group.stat.StatSubs = nil
 	var statSubCount int
 	for s := range group.rtmpSubSessionSet {
@@ -211,50 +306,58 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		if statSubCount > maxsub {
 			break
 		}
-		group.stat.StatSubs = append(group.stat.StatSubs, base.StatSession2Sub(s.GetStat()))
+		group.stat.StatSubs = append(group.stat.StatSubs, group.statSub(s.GetStat()))
 	}
 	for s := range group.httpflvSubSessionSet {
 		statSubCount++
 		if statSubCount > maxsub {
 			break
 		}
-		group.stat.StatSubs = append(group.stat.StatSubs, base.StatSession2Sub(s.GetStat()))
+		group.stat.StatSubs = append(group.stat.StatSubs, group.statSub(s.GetStat()))
 	}
 	for s := range group.httptsSubSessionSet {
 		statSubCount++
 		if statSubCount > maxsub {
 			break
 		}
-		group.stat.StatSubs = append(group.stat.StatSubs, base.StatSession2Sub(s.GetStat()))
+		group.stat.StatSubs = append(group.stat.StatSubs, group.statSub(s.GetStat()))
 	}
 	for s := range group.rtspSubSessionSet {
 		statSubCount++
 		if statSubCount > maxsub {
 			break
 		}
-		group.stat.StatSubs = append(group.stat.StatSubs, base.StatSession2Sub(s.GetStat()))
+		group.stat.StatSubs = append(group.stat.StatSubs, group.statSub(s.GetStat()))
 	}
 
 	return group.stat
 }
 
+func (group *Group) statSub(ss base.StatSession) base.StatSub {
+	ret := base.StatSession2Sub(ss)
+	ret.Tags = group.getSessionTags(ret.SessionId)
+	return ret
+}
+
 func (group *Group) KickOutSession(sessionId string) bool {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
 	Log.Infof("[%s] kick out session. session id=%s", group.UniqueKey, sessionId)
 
-	if strings.HasPrefix(sessionId, base.UkPreRtmpServerSession) {
+	// 设置了全局唯一的session id时，去掉前缀后再判断session的类型
+	kind := strings.TrimPrefix(sessionId, base.UniqueKeyPrefix())
+	if strings.HasPrefix(kind, base.UkPreRtmpServerSession) {
 		if group.rtmpPubSession != nil {
 			group.rtmpPubSession.Dispose()
 			return true
 		}
-	} else if strings.HasPrefix(sessionId, base.UkPreRtspPubSession) {
+	} else if strings.HasPrefix(kind, base.UkPreRtspPubSession) {
 		if group.rtspPubSession != nil {
 			group.rtspPubSession.Dispose()
 			return true
 		}
-	} else if strings.HasPrefix(sessionId, base.UkPreFlvSubSession) {
+	} else if strings.HasPrefix(kind, base.UkPreFlvSubSession) {
 		// TODO chef: 考虑数据结构改成sessionIdzuokey的map
 		for s := range group.httpflvSubSessionSet {
 			if s.UniqueKey() == sessionId {
@@ -262,14 +365,14 @@ func (group *Group) KickOutSession(sessionId string) bool {
 				return true
 			}
 		}
-	} else if strings.HasPrefix(sessionId, base.UkPreTsSubSession) {
+	} else if strings.HasPrefix(kind, base.UkPreTsSubSession) {
 		for s := range group.httptsSubSessionSet {
 			if s.UniqueKey() == sessionId {
 				s.Dispose()
 				return true
 			}
 		}
-	} else if strings.HasPrefix(sessionId, base.UkPreRtspSubSession) {
+	} else if strings.HasPrefix(kind, base.UkPreRtspSubSession) {
 		for s := range group.rtspSubSessionSet {
 			if s.UniqueKey() == sessionId {
 				s.Dispose()
@@ -327,25 +430,25 @@ func (group *Group) disposeInactiveSessions() {
 		}
 	}
 	for session := range group.rtmpSubSessionSet {
-		if _, writeAlive := session.IsAlive(); !writeAlive {
+		if _, writeAlive := session.IsAlive(); !writeAlive && !group.isSubWaitingPub(session.UniqueKey()) {
 			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
 			session.Dispose()
 		}
 	}
 	for session := range group.rtspSubSessionSet {
-		if _, writeAlive := session.IsAlive(); !writeAlive {
+		if _, writeAlive := session.IsAlive(); !writeAlive && !group.isSubWaitingPub(session.UniqueKey()) {
 			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
 			session.Dispose()
 		}
 	}
 	for session := range group.httpflvSubSessionSet {
-		if _, writeAlive := session.IsAlive(); !writeAlive {
+		if _, writeAlive := session.IsAlive(); !writeAlive && !group.isSubWaitingPub(session.UniqueKey()) {
 			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
 			session.Dispose()
 		}
 	}
 	for session := range group.httptsSubSessionSet {
-		if _, writeAlive := session.IsAlive(); !writeAlive {
+		if _, writeAlive := session.IsAlive(); !writeAlive && !group.isSubWaitingPub(session.UniqueKey()) {
 			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
 			session.Dispose()
 		}
@@ -401,7 +504,8 @@ func (group *Group) hasSubSession() bool {
 	return len(group.rtmpSubSessionSet) != 0 ||
 		len(group.httpflvSubSessionSet) != 0 ||
 		len(group.httptsSubSessionSet) != 0 ||
-		len(group.rtspSubSessionSet) != 0
+		len(group.rtspSubSessionSet) != 0 ||
+		len(group.customizeSubSessionSet) != 0
 }
 
 func (group *Group) hasPullSession() bool {
@@ -430,7 +534,7 @@ func (group *Group) hasOutSession() bool {
 // isTotalEmpty 当前group是否完全没有流了
 //
 func (group *Group) isTotalEmpty() bool {
-	return !group.hasInSession() && !group.hasOutSession()
+	return !group.hasInSession() && !group.hasOutSession() && !group.isWaitingResumeRecord() && !group.pullKeepAlone
 }
 
 func (group *Group) inSessionUniqueKey() string {
@@ -440,6 +544,9 @@ func (group *Group) inSessionUniqueKey() string {
 	if group.rtspPubSession != nil {
 		return group.rtspPubSession.UniqueKey()
 	}
+	if group.customizePubSession != nil {
+		return group.customizePubSession.UniqueKey()
+	}
 	if group.pullProxy.pullSession != nil {
 		return group.pullProxy.pullSession.UniqueKey()
 	}
@@ -0,0 +1,252 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+const (
+	defaultBitstreamCheckMaxFrameSize      = 8 * 1024 * 1024
+	defaultBitstreamCheckNotifyIntervalSec = 10
+)
+
+const (
+	BitstreamErrorReasonShortPayload     = "short_payload"
+	BitstreamErrorReasonInvalidSeqHeader = "invalid_seq_header"
+	BitstreamErrorReasonMissingSeqHeader = "missing_seq_header"
+	BitstreamErrorReasonFrameTooLarge    = "frame_too_large"
+	BitstreamErrorReasonMalformedNalu    = "malformed_nalu"
+)
+
+// bitstreamChecker 检查输入流中h264、h265视频数据的合法性，避免有问题的数据影响所有下游的转封装、拉流
+//
+// - 无法修复的帧直接丢弃，之后的视频帧也都丢弃，直到下一个合法的关键帧（隔离期），避免下游解码出花屏
+// - 帧尾部的nalu不完整时，截断掉不完整的部分，转发前面完整的nalu
+// - 还没有收到过seq header，并且帧中也不包含sps、pps时，丢弃
+//
+// 音频以及其他编码格式的视频不做检查
+//
+type bitstreamChecker struct {
+	config BitstreamCheckConfig
+
+	seqHeaderCached bool
+	quarantine      bool
+
+	// 用于事件通知的统计，通知后清零
+	lastReason  string
+	dropCount   int
+	repairCount int
+	lastNotify  time.Time
+}
+
+func newBitstreamChecker(config BitstreamCheckConfig) *bitstreamChecker {
+	if config.MaxFrameSize <= 0 {
+		config.MaxFrameSize = defaultBitstreamCheckMaxFrameSize
+	}
+	if config.NotifyIntervalSec <= 0 {
+		config.NotifyIntervalSec = defaultBitstreamCheckNotifyIntervalSec
+	}
+	return &bitstreamChecker{
+		config: config,
+	}
+}
+
+// Check
+//
+// @return out: 检查通过或者修复后的数据。修复时会重新申请内存，否则和输入msg相同
+// @return ok:  false表示丢弃该msg
+//
+func (c *bitstreamChecker) Check(msg base.RtmpMsg) (out base.RtmpMsg, ok bool) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo {
+		return msg, true
+	}
+	codecId := msg.Payload[0] & 0xF
+	if codecId != base.RtmpCodecIdAvc && codecId != base.RtmpCodecIdHevc {
+		return msg, true
+	}
+	if len(msg.Payload) < 5 {
+		return c.drop(msg, BitstreamErrorReasonShortPayload)
+	}
+
+	switch msg.Payload[1] {
+	case base.RtmpAvcPacketTypeSeqHeader:
+		var err error
+		if codecId == base.RtmpCodecIdAvc {
+			_, _, err = avc.ParseSpsPpsFromSeqHeaderWithoutMalloc(msg.Payload)
+		} else {
+			_, _, _, err = hevc.ParseVpsSpsPpsFromSeqHeaderWithoutMalloc(msg.Payload)
+		}
+		if err != nil {
+			// 注意，不影响之前缓存的seq header，所以不进入隔离期
+			c.record(BitstreamErrorReasonInvalidSeqHeader, false)
+			return msg, false
+		}
+		c.seqHeaderCached = true
+		return msg, true
+	case base.RtmpAvcPacketTypeNalu:
+		// noop
+	default:
+		// end of sequence等
+		return msg, true
+	}
+
+	if len(msg.Payload) > c.config.MaxFrameSize {
+		return c.drop(msg, BitstreamErrorReasonFrameTooLarge)
+	}
+
+	r := scanAvccNalus(msg.Payload[5:], codecId == base.RtmpCodecIdHevc)
+	if r.goodLen == 0 {
+		return c.drop(msg, BitstreamErrorReasonMalformedNalu)
+	}
+	if !c.seqHeaderCached && !r.hasSeqHeader {
+		return c.drop(msg, BitstreamErrorReasonMissingSeqHeader)
+	}
+
+	if c.quarantine {
+		if msg.Payload[0]>>4 != base.RtmpFrameTypeKey || !r.hasKey || r.broken {
+			c.dropCount++
+			return msg, false
+		}
+		c.quarantine = false
+	}
+
+	if !r.broken {
+		return msg, true
+	}
+
+	c.record(BitstreamErrorReasonMalformedNalu, true)
+	out.Header = msg.Header
+	out.Payload = make([]byte, 5+r.goodLen)
+	copy(out.Payload, msg.Payload[:5+r.goodLen])
+	out.Header.MsgLen = uint32(len(out.Payload))
+	return out, true
+}
+
+// PopNotifyInfo 距离上次通知超过`notify_interval_sec`，并且期间有过丢弃、修复时，返回需要通知的统计信息，并清零
+//
+func (c *bitstreamChecker) PopNotifyInfo(now time.Time) (info base.BitstreamErrorInfo, ok bool) {
+	if c.dropCount == 0 && c.repairCount == 0 {
+		return info, false
+	}
+	if now.Sub(c.lastNotify) < time.Duration(c.config.NotifyIntervalSec)*time.Second {
+		return info, false
+	}
+	info.Reason = c.lastReason
+	info.DropCount = c.dropCount
+	info.RepairCount = c.repairCount
+	info.Quarantine = c.quarantine
+	c.dropCount, c.repairCount = 0, 0
+	c.lastNotify = now
+	return info, true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *bitstreamChecker) drop(msg base.RtmpMsg, reason string) (base.RtmpMsg, bool) {
+	c.record(reason, false)
+	c.quarantine = true
+	return msg, false
+}
+
+func (c *bitstreamChecker) record(reason string, repair bool) {
+	if c.lastReason != reason || c.dropCount+c.repairCount == 0 {
+		Log.Warnf("bitstream check failed. reason=%s, repair=%v", reason, repair)
+	}
+	c.lastReason = reason
+	if repair {
+		c.repairCount++
+	} else {
+		c.dropCount++
+	}
+}
+
+type avccScanResult struct {
+	goodLen      int  // 从头开始，完整的nalu（包含4字节长度）的总长度
+	broken       bool // goodLen之后存在不完整或者不合法的nalu
+	hasKey       bool
+	hasSeqHeader bool // 同时包含sps、pps
+}
+
+func scanAvccNalus(b []byte, isHevc bool) (r avccScanResult) {
+	var hasSps, hasPps bool
+	pos := 0
+	for pos < len(b) {
+		if len(b)-pos < 4 {
+			r.broken = true
+			break
+		}
+		length := int(bele.BeUint32(b[pos:]))
+		if length == 0 {
+			pos += 4
+			r.goodLen = pos
+			continue
+		}
+		if length > len(b)-pos-4 || b[pos+4]&0x80 != 0 {
+			// 长度越界，或者forbidden_zero_bit不为0
+			r.broken = true
+			break
+		}
+
+		if isHevc {
+			switch t := hevc.ParseNaluType(b[pos+4]); {
+			case t >= hevc.NaluTypeSliceBlaWlp && t <= hevc.NaluTypeSliceCranut:
+				r.hasKey = true
+			case t == hevc.NaluTypeSps:
+				hasSps = true
+			case t == hevc.NaluTypePps:
+				hasPps = true
+			}
+		} else {
+			switch avc.ParseNaluType(b[pos+4]) {
+			case avc.NaluTypeIdrSlice:
+				r.hasKey = true
+			case avc.NaluTypeSps:
+				hasSps = true
+			case avc.NaluTypePps:
+				hasPps = true
+			}
+		}
+
+		pos += 4 + length
+		r.goodLen = pos
+	}
+	r.hasSeqHeader = hasSps && hasPps
+	if r.goodLen == 0 {
+		r.broken = true
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// checkBitstream 对输入数据做合法性检查，并在需要时发送事件通知
+//
+// @return ok: false表示丢弃该msg
+//
+func (group *Group) checkBitstream(msg base.RtmpMsg) (out base.RtmpMsg, ok bool) {
+	if group.bitstreamChecker == nil {
+		group.bitstreamChecker = newBitstreamChecker(group.config.BitstreamCheckConfig)
+	}
+	out, ok = group.bitstreamChecker.Check(msg)
+
+	if info, notify := group.bitstreamChecker.PopNotifyInfo(time.Now()); notify {
+		info.AppName = group.appName
+		info.StreamName = group.streamName
+		info.SessionId = group.inSessionUniqueKey()
+		Log.Warnf("[%s] bitstream error. info=%+v", group.UniqueKey, info)
+		group.observer.OnBitstreamError(info)
+	}
+	return
+}
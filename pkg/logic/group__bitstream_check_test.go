@@ -0,0 +1,92 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestBitstreamChecker(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96}
+	pps := []byte{0x68, 0xEB, 0xEC, 0xB2, 0x2C}
+	seqHeader, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	videoMsg := func(payload []byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: uint32(len(payload))},
+			Payload: payload,
+		}
+	}
+	keyFrame := videoMsg([]byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88})
+	interFrame := videoMsg([]byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9A})
+
+	c := newBitstreamChecker(BitstreamCheckConfig{Enable: true, MaxFrameSize: 64})
+
+	// 还没有seq header
+	_, ok := c.Check(keyFrame)
+	assert.Equal(t, false, ok)
+	// 不合法的seq header
+	_, ok = c.Check(videoMsg([]byte{0x17, 0x00, 0, 0, 0, 0x01}))
+	assert.Equal(t, false, ok)
+
+	_, ok = c.Check(videoMsg(seqHeader))
+	assert.Equal(t, true, ok)
+	// 处于隔离期，等待关键帧
+	_, ok = c.Check(interFrame)
+	assert.Equal(t, false, ok)
+	_, ok = c.Check(keyFrame)
+	assert.Equal(t, true, ok)
+	_, ok = c.Check(interFrame)
+	assert.Equal(t, true, ok)
+
+	// 尾部nalu不完整，截断修复
+	out, ok := c.Check(videoMsg([]byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9A, 0, 0, 0, 9, 0x41}))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, interFrame.Payload, out.Payload)
+	assert.Equal(t, uint32(len(interFrame.Payload)), out.Header.MsgLen)
+
+	// 过大的帧，丢弃并进入隔离期
+	_, ok = c.Check(videoMsg(append([]byte{0x27, 0x01, 0, 0, 0}, make([]byte, 60)...)))
+	assert.Equal(t, false, ok)
+	_, ok = c.Check(interFrame)
+	assert.Equal(t, false, ok)
+	_, ok = c.Check(videoMsg([]byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 2, 0xE5, 0x88})) // forbidden_zero_bit
+	assert.Equal(t, false, ok)
+	_, ok = c.Check(keyFrame)
+	assert.Equal(t, true, ok)
+
+	// 音频不检查
+	_, ok = c.Check(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio}, Payload: []byte{0xAF}})
+	assert.Equal(t, true, ok)
+
+	now := time.Now()
+	info, ok := c.PopNotifyInfo(now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, BitstreamErrorReasonMalformedNalu, info.Reason)
+	assert.Equal(t, 6, info.DropCount)
+	assert.Equal(t, 1, info.RepairCount)
+	assert.Equal(t, false, info.Quarantine)
+	_, ok = c.PopNotifyInfo(now)
+	assert.Equal(t, false, ok)
+
+	// 合并通知间隔内的错误
+	_, _ = c.Check(videoMsg([]byte{0x17, 0x01}))
+	_, ok = c.PopNotifyInfo(now.Add(time.Second))
+	assert.Equal(t, false, ok)
+	info, ok = c.PopNotifyInfo(now.Add(10 * time.Second))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, BitstreamErrorReasonShortPayload, info.Reason)
+	assert.Equal(t, true, info.Quarantine)
+}
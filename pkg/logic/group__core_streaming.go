@@ -9,6 +9,8 @@
 package logic
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 
 	"github.com/q191201771/lal/pkg/mpegts"
@@ -31,11 +33,15 @@ import (
 // OnReadRtmpAvMsg
 //
 // 输入rtmp数据.
-// 来自 rtmp.ServerSession(Pub), rtmp.PullSession, CustomizePubSessionContext, (remux.DummyAudioFilter) 的回调.
+// 来自 rtmp.ServerSession(Pub), CustomizePubSessionContext, (remux.DummyAudioFilter) 的回调.
+// rtmp.PullSession 的回调见 onReadPullRtmpAvMsg.
 //
 func (group *Group) OnReadRtmpAvMsg(msg base.RtmpMsg) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+	if !group.filterOwnInput(msg) {
+		return
+	}
 	group.broadcastByRtmpMsg(msg)
 }
 
@@ -101,6 +107,9 @@ func (group *Group) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary
 // 来自 remux.AvPacket2RtmpRemuxer 的回调.
 //
 func (group *Group) onRtmpMsgFromRemux(msg base.RtmpMsg) {
+	if !group.filterOwnInput(msg) {
+		return
+	}
 	group.broadcastByRtmpMsg(msg)
 }
 
@@ -149,6 +158,21 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		Log.Warnf("[%s] msg payload length is 0. %+v", group.UniqueKey, msg.Header)
 		return
 	}
+
+	if group.config.BitstreamCheckConfig.Enable {
+		var ok bool
+		if msg, ok = group.checkBitstream(msg); !ok {
+			return
+		}
+	}
+
+	// 注意，记录的是续录修正前的时间戳，注入的data message同样会经过修正
+	if msg.Header.MsgTypeId == base.RtmpTypeIdAudio || msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+		group.lastAvTs = msg.Header.TimestampAbs
+	}
+	if group.config.RecordConfig.ResumeGraceSec > 0 {
+		group.fixResumeTimestamp(&msg)
+	}
 	// TODO(chef): 暂时不打开，因为过滤掉了innertest中rtmp和flv的输出和输入就不完全相同了
 	//if msg.Header.MsgTypeId == base.RtmpTypeIdAudio {
 	//	if len(msg.Payload) <= 2 {
@@ -174,6 +198,20 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	//	}
 	//}
 
+	if msg.IsMetadata() {
+		msg = group.onMetadata(msg)
+	}
+
+	if msg.IsVideoKeySeqHeader() {
+		group.onVideoSeqHeader(msg)
+	}
+	if msg.IsAacSeqHeader() {
+		if group.aacSeqHeader != nil && !bytes.Equal(group.aacSeqHeader, msg.Payload) {
+			group.onStreamEvent(StreamEventTypeCodecChange, "audio seq header changed")
+		}
+		group.aacSeqHeader = msg.Clone().Payload
+	}
+
 	// # mpegts remuxer
 	if group.rtmp2MpegtsRemuxer != nil {
 		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(msg)
@@ -184,6 +222,9 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		group.rtmp2RtspRemuxer.FeedRtmpMsg(msg)
 	}
 
+	// # customize sub
+	group.feedCustomizeSubSessions(msg)
+
 	// # 设置好用于发送的 rtmp 头部信息
 	currHeader := remux.MakeDefaultRtmpHeader(msg.Header)
 	if currHeader.MsgLen != uint32(len(msg.Payload)) {
@@ -198,31 +239,8 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	// ## 如果是新的 sub session，发送已缓存的信息
 	for session := range group.rtmpSubSessionSet {
 		if session.IsFresh {
-			// TODO chef: 头信息和full gop也可以在SubSession刚加入时发送
-			if group.rtmpGopCache.Metadata != nil {
-				Log.Debugf("[%s] [%s] write metadata", group.UniqueKey, session.UniqueKey())
-				_ = session.Write(group.rtmpGopCache.Metadata)
-			}
-			if group.rtmpGopCache.VideoSeqHeader != nil {
-				Log.Debugf("[%s] [%s] write vsh", group.UniqueKey, session.UniqueKey())
-				_ = session.Write(group.rtmpGopCache.VideoSeqHeader)
-			}
-			if group.rtmpGopCache.AacSeqHeader != nil {
-				Log.Debugf("[%s] [%s] write ash", group.UniqueKey, session.UniqueKey())
-				_ = session.Write(group.rtmpGopCache.AacSeqHeader)
-			}
-			gopCount := group.rtmpGopCache.GetGopCount()
-			if gopCount > 0 {
-				// GOP缓存中肯定包含了关键帧
-				session.ShouldWaitVideoKeyFrame = false
-
-				Log.Debugf("[%s] [%s] write gop cache. gop num=%d", group.UniqueKey, session.UniqueKey(), gopCount)
-			}
-			for i := 0; i < gopCount; i++ {
-				for _, item := range group.rtmpGopCache.GetGopDataAt(i) {
-					_ = session.Write(item)
-				}
-			}
+			// 注意，如果开启了快速起播，在SubSession刚加入时就已经发送了头信息和gop，不会走到这里，见 AddRtmpSubSession
+			group.writeRtmpCache2Sub(session)
 
 			// 有新加入的sub session（本次循环的第一个新加入的sub session），把rtmp buf writer中的缓存数据全部广播发送给老的sub session
 			// 从而确保新加入的sub session不会发送这部分脏的数据
@@ -234,6 +252,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 			session.IsFresh = false
 		}
 
+		if session.ShouldWaitVideoKeyFrame && msg.IsVideoKeySeqHeader() {
+			// 等待关键帧期间seq header发生了变化，单独发送新的seq header
+			_ = session.Write(lcd.Get())
+		}
+
 		if session.ShouldWaitVideoKeyFrame && msg.IsVideoKeyNalu() {
 			// 有sub session在等待关键帧，并且当前是关键帧
 			// 把rtmp buf writer中的缓存数据全部广播发送给老的sub session
@@ -288,26 +311,7 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	// # 广播。遍历所有 httpflv sub session，转发数据
 	for session := range group.httpflvSubSessionSet {
 		if session.IsFresh {
-			if group.httpflvGopCache.Metadata != nil {
-				session.Write(group.httpflvGopCache.Metadata)
-			}
-			if group.httpflvGopCache.VideoSeqHeader != nil {
-				session.Write(group.httpflvGopCache.VideoSeqHeader)
-			}
-			if group.httpflvGopCache.AacSeqHeader != nil {
-				session.Write(group.httpflvGopCache.AacSeqHeader)
-			}
-			gopCount := group.httpflvGopCache.GetGopCount()
-			if gopCount > 0 {
-				// GOP缓存中肯定包含了关键帧
-				session.ShouldWaitVideoKeyFrame = false
-			}
-			for i := 0; i < gopCount; i++ {
-				for _, item := range group.httpflvGopCache.GetGopDataAt(i) {
-					session.Write(item)
-				}
-			}
-
+			group.writeHttpflvCache2Sub(session)
 			session.IsFresh = false
 		}
 
@@ -316,6 +320,9 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 			if msg.IsVideoKeyNalu() {
 				session.Write(lrm2ft.Get())
 				session.ShouldWaitVideoKeyFrame = false
+			} else if msg.IsVideoKeySeqHeader() {
+				// 等待关键帧期间seq header发生了变化，需要发送新的seq header
+				session.Write(lrm2ft.Get())
 			}
 		} else {
 			session.Write(lrm2ft.Get())
@@ -338,10 +345,14 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	}
 
 	// # 记录stat
+	group.feedVideoStat(msg)
 	if group.stat.AudioCodec == "" {
 		if msg.IsAacSeqHeader() {
 			group.stat.AudioCodec = base.AudioCodecAac
 		}
+		if msg.IsMp3() {
+			group.stat.AudioCodec = base.AudioCodecMp3
+		}
 	}
 	if group.stat.VideoCodec == "" {
 		if msg.IsAvcKeySeqHeader() {
@@ -375,6 +386,56 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 			}
 		}
 	}
+
+	if msg.Header.MsgTypeId == base.RtmpTypeIdAudio || msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+		group.onInAvData(msg)
+	}
+
+	// # 以本group为输入源的group
+	group.feedRtmpTaps(msg)
+}
+
+// onVideoSeqHeader 输入流中途发送了新的视频seq header（比如编码器切换了分辨率、profile）时，各输出协议做对应的处理：
+//
+// - rtmp、httpflv：新的seq header和其他数据一样转发给已有的sub，这里清空gop缓存，避免新加入的sub收到新的seq header后，
+//                  又收到使用旧的sps、pps编码的gop
+// - hls：在下一个关键帧处开启新的ts切片，并标记`#EXT-X-DISCONTINUITY`
+// - rtsp：见 remux.Rtmp2RtspRemuxer ，新加入的sub使用更新后的sdp
+//
+func (group *Group) onVideoSeqHeader(msg base.RtmpMsg) {
+	prev := group.videoSeqHeader
+	group.videoSeqHeader = msg.Clone().Payload
+	if prev == nil || bytes.Equal(prev, msg.Payload) {
+		return
+	}
+
+	Log.Infof("[%s] video seq header changed. prev len=%d, curr len=%d", group.UniqueKey, len(prev), len(msg.Payload))
+	codec := base.VideoCodecAvc
+	if msg.IsHevcKeySeqHeader() {
+		codec = base.VideoCodecHevc
+	}
+	group.onStreamEvent(StreamEventTypeCodecChange, fmt.Sprintf("video seq header changed. codec=%s", codec))
+	group.rtmpGopCache.ClearGop()
+	group.httpflvGopCache.ClearGop()
+	if group.hlsMuxer != nil {
+		group.hlsMuxer.Discontinue()
+	}
+
+	// 重新记录stat中的视频信息
+	group.stat.VideoCodec = ""
+	group.stat.VideoWidth = 0
+	group.stat.VideoHeight = 0
+}
+
+func (group *Group) onStreamEvent(typ string, detail string) {
+	if group.observer == nil {
+		return
+	}
+	group.observer.OnStreamEvent(group.streamName, base.StreamEvent{
+		Type:      typ,
+		SessionId: group.inSessionUniqueKey(),
+		Detail:    detail,
+	})
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -443,12 +504,13 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 			// 如果有缓存，发送缓存
 			// 并且设置标志，后续都实时转发就行了
 			gopCount := group.httptsGopCache.GetGopCount()
-			for i := 0; i < gopCount; i++ {
+			gopStart := subGopStartIndex(parseSubStart(session.RawQuery()), gopCount)
+			for i := gopStart; i < gopCount; i++ {
 				for _, item := range group.httptsGopCache.GetGopDataAt(i) {
 					session.Write(item)
 				}
 			}
-			if gopCount > 0 {
+			if gopStart < gopCount {
 				session.ShouldWaitBoundary = false
 			}
 
@@ -0,0 +1,64 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// group__customize_sub.go
+//
+// 进程内拉流，将rtmp数据转换为 base.AvPacket 后回调给业务方
+//
+
+func (group *Group) AddCustomizeSubSession(streamName string, handler ICustomizeSubHandler) ICustomizeSubSessionContext {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	ctx := NewCustomizeSubSessionContext(streamName, handler)
+	Log.Debugf("[%s] [%s] add customize SubSession into group.", group.UniqueKey, ctx.UniqueKey())
+	group.customizeSubSessionSet[ctx] = struct{}{}
+	group.pullIfNeeded()
+	return ctx
+}
+
+func (group *Group) DelCustomizeSubSession(sessionCtx ICustomizeSubSessionContext) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	Log.Debugf("[%s] [%s] del customize SubSession from group.", group.UniqueKey, sessionCtx.UniqueKey())
+	ctx, ok := sessionCtx.(*CustomizeSubSessionContext)
+	if !ok {
+		return
+	}
+	delete(group.customizeSubSessionSet, ctx)
+}
+
+// feedCustomizeSubSessions
+//
+// 没有customize sub时，只处理seq header，使得后续加入的customize sub可以拿到sps、pps以及AudioSpecificConfig
+//
+func (group *Group) feedCustomizeSubSessions(msg base.RtmpMsg) {
+	if len(group.customizeSubSessionSet) == 0 &&
+		!(len(msg.Payload) > 2 && (msg.IsVideoKeySeqHeader() || msg.IsAacSeqHeader())) {
+		return
+	}
+	group.rtmp2AvPacketRemuxer.FeedRtmpMsg(msg)
+}
+
+// onAvPacketFromRemux
+//
+// 来自 remux.Rtmp2AvPacketRemuxer 的回调
+//
+func (group *Group) onAvPacketFromRemux(packet base.AvPacket) {
+	asc := group.rtmp2AvPacketRemuxer.Asc()
+	for ctx := range group.customizeSubSessionSet {
+		ctx.feedAvPacket(packet, asc)
+	}
+}
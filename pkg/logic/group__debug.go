@@ -0,0 +1,86 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"sort"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// DebugState 获取group内部处理流程的状态，用于http api的调试接口
+//
+func (group *Group) DebugState() base.DebugGroupState {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	s := base.DebugGroupState{
+		UniqueKey:  group.UniqueKey,
+		AppName:    group.appName,
+		StreamName: group.streamName,
+
+		Rtsp2RtmpRemuxer:   group.rtsp2RtmpRemuxer != nil,
+		Rtmp2RtspRemuxer:   group.rtmp2RtspRemuxer != nil,
+		Rtmp2MpegtsRemuxer: group.rtmp2MpegtsRemuxer != nil,
+		HasSdp:             group.sdpCtx != nil,
+		HasPatPmt:          group.patpmt != nil,
+
+		RtmpGopCount:    group.rtmpGopCache.GetGopCount(),
+		HttpflvGopCount: group.httpflvGopCache.GetGopCount(),
+		HttptsGopCount:  group.httptsGopCache.GetGopCount(),
+
+		RtmpSubCount:      len(group.rtmpSubSessionSet),
+		HttpflvSubCount:   len(group.httpflvSubSessionSet),
+		HttptsSubCount:    len(group.httptsSubSessionSet),
+		RtspSubCount:      len(group.rtspSubSessionSet),
+		CustomizeSubCount: len(group.customizeSubSessionSet),
+		SubWaitPubCount:   len(group.subWaitPubSince),
+
+		PullEnable:     group.pullEnable,
+		PullUrl:        group.pullUrl,
+		IsPulling:      group.pullProxy.isPulling,
+		PullRetryCount: group.pullProxy.retryCount,
+
+		RecordFlv:           group.recordFlv != nil,
+		RecordMpegts:        group.recordMpegts != nil,
+		RecordPaused:        group.recordPaused,
+		WaitingResumeRecord: group.isWaitingResumeRecord(),
+	}
+
+	switch {
+	case group.rtmpPubSession != nil:
+		s.InSession, s.InSessionId = "rtmp_pub", group.rtmpPubSession.UniqueKey()
+	case group.rtspPubSession != nil:
+		s.InSession, s.InSessionId = "rtsp_pub", group.rtspPubSession.UniqueKey()
+	case group.customizePubSession != nil:
+		s.InSession, s.InSessionId = "customize_pub", group.customizePubSession.UniqueKey()
+	case group.pullProxy.pullSession != nil:
+		s.InSession, s.InSessionId = "rtmp_pull", group.pullProxy.pullSession.UniqueKey()
+	}
+
+	for url, v := range group.url2PushProxy {
+		p := base.DebugPushState{
+			Url:        url,
+			IsPushing:  v.isPushing,
+			RetryCount: v.retryCount,
+		}
+		if v.pushSession != nil {
+			p.SessionId = v.pushSession.UniqueKey()
+		}
+		s.Pushes = append(s.Pushes, p)
+	}
+	sort.Slice(s.Pushes, func(i, j int) bool {
+		return s.Pushes[i].Url < s.Pushes[j].Url
+	})
+
+	if group.hlsMuxer != nil {
+		s.HlsOutPath = group.hlsMuxer.OutPath()
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGroupDebugState(t *testing.T) {
+	var config Config
+	config.RelayPushConfig.Enable = true
+	config.RelayPushConfig.AddrList = []string{"127.0.0.1:19350"}
+	group := NewGroup("live", "test110", &config, nil)
+	group.pullProxy.retryCount = 2
+
+	s := group.DebugState()
+	assert.Equal(t, "test110", s.StreamName)
+	assert.Equal(t, "", s.InSession)
+	assert.Equal(t, 2, s.PullRetryCount)
+	assert.Equal(t, 1, len(s.Pushes))
+	assert.Equal(t, "rtmp://127.0.0.1:19350/live/test110", s.Pushes[0].Url)
+	assert.Equal(t, false, s.Pushes[0].IsPushing)
+}
+
+func TestHttpApiDebugAuth(t *testing.T) {
+	var config Config
+	h := &HttpApiServer{sm: &ServerManager{config: &config}}
+	handler := h.withDebugAuth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	code := func(header string, query string) int {
+		req := httptest.NewRequest("GET", "/api/debug/dump"+query, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 没有配置token
+	assert.Equal(t, http.StatusUnauthorized, code("", ""))
+
+	config.HttpApiConfig.DebugConfig.AuthToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, code("", ""))
+	assert.Equal(t, http.StatusUnauthorized, code("Bearer wrong", ""))
+	assert.Equal(t, http.StatusOK, code("Bearer secret", ""))
+	assert.Equal(t, http.StatusOK, code("", "?token=secret"))
+}
@@ -0,0 +1,109 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+)
+
+// group__httpflv_resume.go
+//
+// httpflv sub断点续播，见 HttpflvConfig.ResumeGraceSec
+//
+// sub断开时记录最后发送的音视频时间戳，客户端在宽限时间内带上断开的session id重连时，
+// 发送完头信息后，只发送GOP缓存中断点之后的数据，避免播放器收到重复的数据
+//
+
+// HttpflvResumeParam 拉流url参数，值为断开的httpflv sub的session id，比如 http://127.0.0.1:8080/live/test110.flv?session_id=FLVSUB1
+const HttpflvResumeParam = "session_id"
+
+type httpflvResumePoint struct {
+	ts     uint32
+	expire int64 // unix秒
+}
+
+// saveHttpflvResumePoint 调用时需持有锁
+func (group *Group) saveHttpflvResumePoint(session *httpflv.SubSession) {
+	if group.config.HttpflvConfig.ResumeGraceSec <= 0 {
+		return
+	}
+	ts, ok := session.LastAvTimestamp()
+	if !ok {
+		return
+	}
+
+	if group.httpflvResumes == nil {
+		group.httpflvResumes = make(map[string]httpflvResumePoint)
+	}
+	now := time.Now().Unix()
+	for k, v := range group.httpflvResumes {
+		if now > v.expire {
+			delete(group.httpflvResumes, k)
+		}
+	}
+	group.httpflvResumes[session.UniqueKey()] = httpflvResumePoint{
+		ts:     ts,
+		expire: now + int64(group.config.HttpflvConfig.ResumeGraceSec),
+	}
+}
+
+// writeHttpflvResume2Sub 如果sub是断点续播，发送GOP缓存中断点之后的数据。调用时需持有锁
+//
+// @return 断点不存在、已过期、或者已经不在GOP缓存中时返回false，此时没有发送任何数据
+//
+func (group *Group) writeHttpflvResume2Sub(session *httpflv.SubSession) bool {
+	if group.config.HttpflvConfig.ResumeGraceSec <= 0 {
+		return false
+	}
+	values, err := url.ParseQuery(session.RawQuery())
+	if err != nil {
+		return false
+	}
+	prevSessionId := values.Get(HttpflvResumeParam)
+	point, ok := group.httpflvResumes[prevSessionId]
+	if !ok {
+		return false
+	}
+	delete(group.httpflvResumes, prevSessionId)
+	if time.Now().Unix() > point.expire {
+		return false
+	}
+
+	gc := group.httpflvGopCache
+	gopCount := gc.GetGopCount()
+	if gopCount == 0 {
+		return false
+	}
+	// 最老的GOP的时间戳在断点之后，说明断开期间的数据已经不在缓存中了
+	first := gc.GetGopDataAt(0)
+	if len(first) == 0 || httpflvTagTimestamp(first[0]) > point.ts {
+		return false
+	}
+
+	Log.Infof("[%s] [%s] httpflv resume. prev session=%s, ts=%d", group.UniqueKey, session.UniqueKey(), prevSessionId, point.ts)
+	for i := 0; i < gopCount; i++ {
+		for _, item := range gc.GetGopDataAt(i) {
+			if httpflvTagTimestamp(item) > point.ts {
+				session.Write(item)
+			}
+		}
+	}
+	session.ShouldWaitVideoKeyFrame = false
+	return true
+}
+
+func httpflvTagTimestamp(tag []byte) uint32 {
+	if len(tag) < httpflv.TagHeaderSize {
+		return 0
+	}
+	return uint32(tag[4])<<16 | uint32(tag[5])<<8 | uint32(tag[6]) | uint32(tag[7])<<24
+}
@@ -0,0 +1,79 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGroupHttpflvResume(t *testing.T) {
+	var config Config
+	config.HttpflvConfig.Enable = true
+	config.HttpflvConfig.GopNum = 2
+	config.HttpflvConfig.ResumeGraceSec = 10
+	group := NewGroup("live", "test110", &config, nil)
+
+	feed := func(ts uint32, key bool) {
+		payload := []byte{0x27, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x41}
+		if key {
+			payload[0] = base.RtmpAvcKeyFrame
+		}
+		group.OnReadRtmpAvMsg(base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: uint32(len(payload)), TimestampAbs: ts},
+			Payload: payload,
+		})
+	}
+	newSub := func(rawQuery string) *httpflv.SubSession {
+		conn, _ := net.Pipe()
+		urlCtx, err := base.ParseUrl("http://127.0.0.1:8080/live/test110.flv?"+rawQuery, 80)
+		assert.Equal(t, nil, err)
+		return httpflv.NewSubSession(conn, urlCtx, false, "")
+	}
+
+	feed(0, true)
+	feed(40, false)
+	feed(2000, true)
+	feed(2040, false)
+
+	// 断开前发送到了80ms
+	prev := newSub("")
+	defer prev.Dispose()
+	prev.Write(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 40, []byte{0x27, 1, 0, 0, 0}))
+	group.DelHttpflvSubSession(prev)
+
+	// 重连时只发送断点之后的数据
+	curr := newSub(HttpflvResumeParam + "=" + prev.UniqueKey())
+	defer curr.Dispose()
+	curr.ShouldWaitVideoKeyFrame = true
+	group.mutex.Lock()
+	assert.Equal(t, true, group.writeHttpflvResume2Sub(curr))
+	ts, ok := curr.LastAvTimestamp()
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint32(2040), ts)
+	assert.Equal(t, false, curr.ShouldWaitVideoKeyFrame)
+	// 断点只能使用一次
+	assert.Equal(t, false, group.writeHttpflvResume2Sub(curr))
+	group.mutex.Unlock()
+
+	// 断点已经不在GOP缓存中
+	feed(4000, true)
+	feed(6000, true)
+	prev.Write(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 40, []byte{0x27, 1, 0, 0, 0}))
+	group.DelHttpflvSubSession(prev)
+	other := newSub(HttpflvResumeParam + "=" + prev.UniqueKey())
+	defer other.Dispose()
+	group.mutex.Lock()
+	assert.Equal(t, false, group.writeHttpflvResume2Sub(other))
+	group.mutex.Unlock()
+}
@@ -178,6 +178,7 @@ func (group *Group) delRtmpPubSession(session *rtmp.ServerSession) {
 		return
 	}
 
+	group.delSessionTags(session.UniqueKey())
 	group.delIn()
 }
 
@@ -190,12 +191,14 @@ func (group *Group) delRtspPubSession(session *rtsp.PubSession) {
 		return
 	}
 
+	group.delSessionTags(session.UniqueKey())
 	group.delIn()
 }
 
 func (group *Group) delRtmpPullSession(session *rtmp.PullSession) {
 	Log.Debugf("[%s] [%s] del rtmp PullSession from group.", group.UniqueKey, session.UniqueKey())
 
+	group.delSessionTags(session.UniqueKey())
 	group.pullProxy.pullSession = nil
 	group.setPullingFlag(false)
 	group.delIn()
@@ -207,12 +210,21 @@ func (group *Group) delRtmpPullSession(session *rtmp.PullSession) {
 //
 func (group *Group) addIn() {
 	now := time.Now().Unix()
+	group.inCodecReady = false
+	group.inHasAudioSeqHeader = false
+	group.inHasVideoSeqHeader = false
+	group.inMetadataNoVideo = false
 
 	if group.shouldStartMpegtsRemuxer() {
-		group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group)
+		group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group, func(option *remux.Rtmp2MpegtsRemuxerOption) {
+			option.Psi = group.config.MpegtsConfig.PsiOption
+		})
 	}
 
 	group.startPushIfNeeded()
+	if group.resumeRecordIfNeeded() {
+		return
+	}
 	group.startHlsIfNeeded()
 	group.startRecordFlvIfNeeded(now)
 	group.startRecordMpegtsIfNeeded(now)
@@ -228,9 +240,12 @@ func (group *Group) delIn() {
 	}
 
 	group.stopPushIfNeeded()
-	group.stopHlsIfNeeded()
-	group.stopRecordFlvIfNeeded()
-	group.stopRecordMpegtsIfNeeded()
+	if !group.waitResumeRecordIfNeeded() {
+		group.stopHlsIfNeeded()
+		group.stopRecordFlvIfNeeded()
+		group.stopRecordMpegtsIfNeeded()
+		group.releaseRecordKey()
+	}
 
 	group.rtmpPubSession = nil
 	group.rtspPubSession = nil
@@ -238,10 +253,18 @@ func (group *Group) delIn() {
 	group.rtsp2RtmpRemuxer = nil
 	group.rtmp2RtspRemuxer = nil
 	group.dummyAudioFilter = nil
+	group.bitstreamChecker = nil
+	group.videoStatAnalyser = nil
+	group.pubConstraint = nil
 
 	group.rtmpGopCache.Clear()
 	group.httpflvGopCache.Clear()
 	group.httptsGopCache.Clear()
 	group.sdpCtx = nil
 	group.patpmt = nil
+	group.videoSeqHeader = nil
+	group.aacSeqHeader = nil
+	group.metadata = nil
+
+	group.endRtmpTaps()
 }
@@ -0,0 +1,217 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// SetMetadataOverride 覆盖输入流onMetaData中的字段，比如修正编码器发送的错误的宽高。之后（新加入）的sub收到的是修改后的metadata
+//
+// 覆盖的字段在输入流重新推流后依然生效
+//
+// @param fields: 值为nil表示删除该字段的覆盖。值只支持float64、string、bool
+// @param reset:  为true时，先清空之前所有的覆盖
+//
+func (group *Group) SetMetadataOverride(fields map[string]interface{}, reset bool) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if reset || group.metadataOverride == nil {
+		group.metadataOverride = make(map[string]interface{})
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(group.metadataOverride, k)
+		} else {
+			group.metadataOverride[k] = v
+		}
+	}
+
+	// 使用输入流原始的metadata重新走一遍转发流程，从而更新gop缓存中的metadata，已有的sub也会收到新的metadata
+	if group.metadata != nil {
+		payload, err := packMetadata(group.metadata)
+		if err != nil {
+			Log.Errorf("[%s] pack metadata failed. err=%+v", group.UniqueKey, err)
+			return
+		}
+		header := group.metadataHeader
+		header.MsgLen = uint32(len(payload))
+		group.broadcastByRtmpMsg(base.RtmpMsg{Header: header, Payload: payload})
+	}
+}
+
+// InjectDataMessage 往流中注入一个data message，比如onCuePoint、onTextData，和输入流中的data message一样转发给rtmp、httpflv的sub，
+// 以及relay push、flv录制等
+//
+// 时间戳使用输入流最近一次音视频消息的时间戳
+//
+// @param name: data message的名称，调用方需保证不是onMetaData，修改metadata见 SetMetadataOverride
+// @param data: 值只支持float64、string、bool，以及嵌套的map[string]interface{}，调用方需保证
+//
+func (group *Group) InjectDataMessage(name string, data map[string]interface{}) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	payload, err := packDataMessage(name, data)
+	if err != nil {
+		Log.Errorf("[%s] pack data message failed. err=%+v", group.UniqueKey, err)
+		return
+	}
+	Log.Infof("[%s] inject data message. name=%s, ts=%d, len=%d", group.UniqueKey, name, group.lastAvTs, len(payload))
+	group.broadcastByRtmpMsg(base.RtmpMsg{
+		Header: base.RtmpHeader{
+			Csid:         rtmp.CsidAmf,
+			MsgLen:       uint32(len(payload)),
+			MsgTypeId:    base.RtmpTypeIdMetadata,
+			MsgStreamId:  rtmp.Msid1,
+			TimestampAbs: group.lastAvTs,
+		},
+		Payload: payload,
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// onMetadata 记录输入流的metadata，如果设置过覆盖的字段，返回修改后的metadata
+//
+func (group *Group) onMetadata(msg base.RtmpMsg) base.RtmpMsg {
+	opa, err := rtmp.ParseMetadata(msg.Payload)
+	if err != nil {
+		Log.Warnf("[%s] parse metadata failed. err=%+v", group.UniqueKey, err)
+		return msg
+	}
+	group.metadata = opa
+	group.metadataHeader = msg.Header
+	group.inMetadataNoVideo = opa.Find("videocodecid") == nil
+
+	if len(group.metadataOverride) == 0 {
+		return msg
+	}
+
+	payload, err := packMetadata(mergeMetadata(opa, group.metadataOverride))
+	if err != nil {
+		Log.Errorf("[%s] pack metadata failed. err=%+v", group.UniqueKey, err)
+		return msg
+	}
+	msg.Payload = payload
+	msg.Header.MsgLen = uint32(len(payload))
+	return msg
+}
+
+// metadataStat 用于stat，注意，调用方需要持有group的锁
+func (group *Group) metadataStat() (metadata map[string]interface{}, override map[string]interface{}) {
+	if group.metadata != nil {
+		metadata = objectPairArray2Map(group.metadata)
+	}
+	if len(group.metadataOverride) != 0 {
+		override = make(map[string]interface{}, len(group.metadataOverride))
+		for k, v := range group.metadataOverride {
+			override[k] = v
+		}
+	}
+	return
+}
+
+// mergeMetadata 保持原有字段的顺序，不存在的覆盖字段按key排序追加在后面
+func mergeMetadata(opa rtmp.ObjectPairArray, override map[string]interface{}) rtmp.ObjectPairArray {
+	ret := make(rtmp.ObjectPairArray, 0, len(opa)+len(override))
+	exist := make(map[string]struct{}, len(opa))
+	for _, op := range opa {
+		if v, ok := override[op.Key]; ok {
+			op.Value = v
+		}
+		exist[op.Key] = struct{}{}
+		ret = append(ret, op)
+	}
+
+	var keys []string
+	for k := range override {
+		if _, ok := exist[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ret = append(ret, rtmp.ObjectPair{Key: k, Value: override[k]})
+	}
+	return ret
+}
+
+func packMetadata(opa rtmp.ObjectPairArray) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := rtmp.Amf0.WriteString(buf, "onMetaData"); err != nil {
+		return nil, err
+	}
+	if err := rtmp.Amf0.WriteArray(buf, opa); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func packDataMessage(name string, data map[string]interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := rtmp.Amf0.WriteString(buf, name); err != nil {
+		return nil, err
+	}
+	if err := rtmp.Amf0.WriteObject(buf, map2ObjectPairArray(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isValidDataMessageData 检查 InjectDataMessage 的`data`中是否有不支持的值类型，比如null、数组
+func isValidDataMessageData(m map[string]interface{}) bool {
+	for _, v := range m {
+		switch val := v.(type) {
+		case float64, string, bool:
+		case map[string]interface{}:
+			if !isValidDataMessageData(val) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// map2ObjectPairArray 按key排序，嵌套的map同样转换
+func map2ObjectPairArray(m map[string]interface{}) rtmp.ObjectPairArray {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	opa := make(rtmp.ObjectPairArray, 0, len(m))
+	for _, k := range keys {
+		v := m[k]
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = map2ObjectPairArray(sub)
+		}
+		opa = append(opa, rtmp.ObjectPair{Key: k, Value: v})
+	}
+	return opa
+}
+
+func objectPairArray2Map(opa rtmp.ObjectPairArray) map[string]interface{} {
+	m := make(map[string]interface{}, len(opa))
+	for _, op := range opa {
+		if sub, ok := op.Value.(rtmp.ObjectPairArray); ok {
+			m[op.Key] = objectPairArray2Map(sub)
+		} else {
+			m[op.Key] = op.Value
+		}
+	}
+	return m
+}
@@ -0,0 +1,130 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestGroupMetadataOverride(t *testing.T) {
+	var config Config
+	config.HttpflvConfig.Enable = true
+	group := NewGroup("live", "test110", &config, nil)
+
+	payload, err := rtmp.BuildMetadata(640, 360, 10, 7)
+	assert.Equal(t, nil, err)
+	group.OnReadRtmpAvMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata, MsgLen: uint32(len(payload))},
+		Payload: payload,
+	})
+
+	cachedMetadata := func() rtmp.ObjectPairArray {
+		opa, err := rtmp.ParseMetadata(group.httpflvGopCache.Metadata[httpflv.TagHeaderSize:])
+		assert.Equal(t, nil, err)
+		return opa
+	}
+	assert.Equal(t, float64(640), cachedMetadata().Find("width"))
+
+	// 已经有metadata时，立即更新缓存
+	group.SetMetadataOverride(map[string]interface{}{"width": float64(1280), "height": float64(720), "title": "abc"}, false)
+	opa := cachedMetadata()
+	assert.Equal(t, float64(1280), opa.Find("width"))
+	assert.Equal(t, float64(720), opa.Find("height"))
+	assert.Equal(t, "abc", opa.Find("title"))
+	assert.Equal(t, "width", opa[0].Key)
+
+	stat := group.GetStat(10)
+	assert.Equal(t, float64(640), stat.Metadata["width"])
+	assert.Equal(t, 3, len(stat.MetadataOverride))
+
+	// 删除单个字段的覆盖
+	group.SetMetadataOverride(map[string]interface{}{"width": nil}, false)
+	opa = cachedMetadata()
+	assert.Equal(t, float64(640), opa.Find("width"))
+	assert.Equal(t, float64(720), opa.Find("height"))
+
+	// 之后输入流发送的metadata同样被覆盖
+	group.OnReadRtmpAvMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata, MsgLen: uint32(len(payload))},
+		Payload: payload,
+	})
+	assert.Equal(t, float64(720), cachedMetadata().Find("height"))
+
+	group.SetMetadataOverride(nil, true)
+	assert.Equal(t, float64(360), cachedMetadata().Find("height"))
+	assert.Equal(t, 0, len(group.GetStat(10).MetadataOverride))
+}
+
+func TestGroupDataMessage(t *testing.T) {
+	var config Config
+	config.HttpflvConfig.Enable = true
+	config.HttpflvConfig.GopNum = 1
+	config.RtmpConfig.Enable = true
+	config.RtmpConfig.GopNum = 1
+	group := NewGroup("live", "test110", &config, nil)
+
+	payload, err := rtmp.BuildMetadata(640, 360, 10, 7)
+	assert.Equal(t, nil, err)
+	group.OnReadRtmpAvMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata, MsgLen: uint32(len(payload))},
+		Payload: payload,
+	})
+	keyFrame := []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	group.OnReadRtmpAvMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: uint32(len(keyFrame)), TimestampAbs: 1000},
+		Payload: keyFrame,
+	})
+
+	// 输入流中的onCuePoint不会被当成metadata
+	cuePoint, err := packDataMessage("onCuePoint", map[string]interface{}{"name": "ad"})
+	assert.Equal(t, nil, err)
+	msg := base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata, MsgLen: uint32(len(cuePoint)), TimestampAbs: 1000},
+		Payload: cuePoint,
+	}
+	assert.Equal(t, false, msg.IsMetadata())
+	assert.Equal(t, "onCuePoint", msg.DataMessageName())
+	group.OnReadRtmpAvMsg(msg)
+	opa, err := rtmp.ParseMetadata(group.httpflvGopCache.Metadata[httpflv.TagHeaderSize:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(640), opa.Find("width"))
+	assert.Equal(t, float64(640), group.metadata.Find("width"))
+
+	// 注入的data message使用最近一次音视频消息的时间戳，和其他数据一样缓存在gop中
+	group.InjectDataMessage("onTextData", map[string]interface{}{
+		"text":   "hello",
+		"nested": map[string]interface{}{"n": float64(1)},
+	})
+	gop := group.httpflvGopCache.GetGopDataAt(0)
+	assert.Equal(t, 3, len(gop))
+	tag := gop[2]
+	assert.Equal(t, uint8(httpflv.TagTypeMetadata), tag[0])
+	assert.Equal(t, uint32(1000), bele.BeUint24(tag[4:])|uint32(tag[7])<<24)
+	name, l, err := rtmp.Amf0.ReadString(tag[httpflv.TagHeaderSize:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "onTextData", name)
+	data, _, err := rtmp.Amf0.ReadObject(tag[httpflv.TagHeaderSize+l:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "nested", data[0].Key)
+	assert.Equal(t, "hello", data.Find("text"))
+	// rtmp使用amf的chunk stream，以及和音视频相同的message stream
+	chunks := group.rtmpGopCache.GetGopDataAt(0)[2]
+	assert.Equal(t, byte(rtmp.CsidAmf), chunks[0]&0x3F)
+	assert.Equal(t, uint32(rtmp.Msid1), bele.LeUint32(chunks[8:]))
+
+	assert.Equal(t, true, isValidDataMessageData(map[string]interface{}{"a": "x", "b": map[string]interface{}{"c": true}}))
+	assert.Equal(t, false, isValidDataMessageData(map[string]interface{}{"a": nil}))
+	assert.Equal(t, false, isValidDataMessageData(map[string]interface{}{"b": map[string]interface{}{"c": []interface{}{1}}}))
+}
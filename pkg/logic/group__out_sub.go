@@ -29,7 +29,18 @@ func (group *Group) AddRtmpSubSession(session *rtmp.ServerSession) {
 		session.ShouldWaitVideoKeyFrame = false
 	}
 
-	group.addSub()
+	// 快速起播，加入时立即发送缓存的头信息、gop（或最近一个关键帧），而不是等到下一个音视频消息到来时再发送，
+	// 缩短首帧时间
+	if group.config.FastStartConfig.Enable {
+		// 先把merge writer中的缓存数据发送给老的sub session，避免新加入的sub session收到这部分数据
+		if group.rtmpMergeWriter != nil {
+			group.rtmpMergeWriter.Flush()
+		}
+		group.writeRtmpCache2Sub(session)
+		session.IsFresh = false
+	}
+
+	group.addSub(session.UniqueKey(), session.RawQuery())
 }
 
 func (group *Group) AddHttpflvSubSession(session *httpflv.SubSession) {
@@ -45,7 +56,13 @@ func (group *Group) AddHttpflvSubSession(session *httpflv.SubSession) {
 		session.ShouldWaitVideoKeyFrame = false
 	}
 
-	group.addSub()
+	// 快速起播，见 AddRtmpSubSession
+	if group.config.FastStartConfig.Enable {
+		group.writeHttpflvCache2Sub(session)
+		session.IsFresh = false
+	}
+
+	group.addSub(session.UniqueKey(), session.RawQuery())
 }
 
 // AddHttptsSubSession ...
@@ -57,7 +74,7 @@ func (group *Group) AddHttptsSubSession(session *httpts.SubSession) {
 	defer group.mutex.Unlock()
 	group.httptsSubSessionSet[session] = struct{}{}
 
-	group.addSub()
+	group.addSub(session.UniqueKey(), session.RawQuery())
 }
 
 func (group *Group) HandleNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
@@ -83,7 +100,7 @@ func (group *Group) HandleNewRtspSubSessionPlay(session *rtsp.SubSession) {
 		session.ShouldWaitVideoKeyFrame = false
 	}
 
-	group.addSub()
+	group.addSub(session.UniqueKey(), session.RawQuery())
 }
 
 func (group *Group) DelRtmpSubSession(session *rtmp.ServerSession) {
@@ -115,25 +132,84 @@ func (group *Group) DelRtspSubSession(session *rtsp.SubSession) {
 func (group *Group) delRtmpSubSession(session *rtmp.ServerSession) {
 	Log.Debugf("[%s] [%s] del rtmp SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.rtmpSubSessionSet, session)
+	delete(group.internalSubs, session.UniqueKey())
+	group.delSessionTags(session.UniqueKey())
+	group.delSubWaitPub(session.UniqueKey())
 }
 
 func (group *Group) delHttpflvSubSession(session *httpflv.SubSession) {
 	Log.Debugf("[%s] [%s] del httpflv SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.httpflvSubSessionSet, session)
+	group.saveHttpflvResumePoint(session)
+	group.delSessionTags(session.UniqueKey())
+	group.delSubWaitPub(session.UniqueKey())
 }
 
 func (group *Group) delHttptsSubSession(session *httpts.SubSession) {
 	Log.Debugf("[%s] [%s] del httpts SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.httptsSubSessionSet, session)
+	group.delSessionTags(session.UniqueKey())
+	group.delSubWaitPub(session.UniqueKey())
 }
 
 func (group *Group) delRtspSubSession(session *rtsp.SubSession) {
 	Log.Debugf("[%s] [%s] del rtsp SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.rtspSubSessionSet, session)
+	group.delSessionTags(session.UniqueKey())
+	group.delSubWaitPub(session.UniqueKey())
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-func (group *Group) addSub() {
+// disposeAllSubSessions 关闭所有sub session，关闭后的清理由各session的Del流程完成
+//
+func (group *Group) disposeAllSubSessions() {
+	for session := range group.rtmpSubSessionSet {
+		session.Dispose()
+	}
+	for session := range group.rtspSubSessionSet {
+		session.Dispose()
+	}
+	for session := range group.httpflvSubSessionSet {
+		session.Dispose()
+	}
+	for session := range group.httptsSubSessionSet {
+		session.Dispose()
+	}
+}
+
+// ViewerCount 观看人数，见 base.StatGroup.ViewerCount
+//
+func (group *Group) ViewerCount() int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.viewerCount()
+}
+
+// MarkInternalSub 标记lalserver内部的sub session（比如转码的ffmpeg拉流），不计入观看人数，并打上标签`lal_internal`
+//
+// @param source: 标签的值，比如"transcode"
+//
+func (group *Group) MarkInternalSub(sessionId string, source string) {
+	group.mutex.Lock()
+	if group.internalSubs == nil {
+		group.internalSubs = make(map[string]struct{})
+	}
+	group.internalSubs[sessionId] = struct{}{}
+	group.mutex.Unlock()
+
+	group.TagSession(sessionId, map[string]string{internalSubTagKey: source})
+}
+
+func (group *Group) viewerCount() int {
+	n := len(group.rtmpSubSessionSet) + len(group.rtspSubSessionSet) + len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet)
+	return n - len(group.internalSubs)
+}
+
+// @param rawQuery 新加入的sub session的url参数，回源拉流时携带
+//
+func (group *Group) addSub(sessionId string, rawQuery string) {
+	group.addSubWaitPubIfNeeded(sessionId)
+	group.setPullRawQueryIfNeeded(rawQuery)
 	group.pullIfNeeded()
 }
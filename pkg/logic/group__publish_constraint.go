@@ -0,0 +1,167 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+const (
+	PubViolationReasonResolution       = "resolution"
+	PubViolationReasonBitrate          = "bitrate"
+	PubViolationReasonVideoCodec       = "video_codec"
+	PubViolationReasonAudioCodec       = "audio_codec"
+	PubViolationReasonKeyFrameInterval = "key_frame_interval"
+)
+
+type pubViolation struct {
+	reason string
+	detail string
+}
+
+// pubConstraintState 当前推流session的检查状态，推流结束时清空
+//
+type pubConstraintState struct {
+	policy   *PublishPolicyConfig // nil表示没有匹配的策略
+	notified map[string]struct{}  // 已经通知过的原因
+}
+
+// matchPublishPolicy 按推流url中的域名匹配，匹配不到时使用`vhost`为空的默认策略，都没有时返回nil
+//
+func matchPublishPolicy(policies []PublishPolicyConfig, rawUrl string) *PublishPolicyConfig {
+	var host string
+	if ctx, err := base.ParseUrl(rawUrl, -1); err == nil {
+		host = ctx.Host
+	}
+	var defaultPolicy *PublishPolicyConfig
+	for i := range policies {
+		if policies[i].Vhost == "" {
+			if defaultPolicy == nil {
+				defaultPolicy = &policies[i]
+			}
+			continue
+		}
+		if host != "" && strings.EqualFold(policies[i].Vhost, host) {
+			return &policies[i]
+		}
+	}
+	return defaultPolicy
+}
+
+// checkPublishPolicy 检查当前的输入流信息是否违反`policy`，还不知道的信息（比如还没有收到seq header）不检查
+//
+// @param bitrate 推流的码率，单位kbit/s
+//
+func checkPublishPolicy(policy *PublishPolicyConfig, stat *base.StatGroup, bitrate int) (violations []pubViolation) {
+	if stat.VideoWidth != 0 && stat.VideoHeight != 0 {
+		if (policy.MaxWidth > 0 && stat.VideoWidth > policy.MaxWidth) || (policy.MaxHeight > 0 && stat.VideoHeight > policy.MaxHeight) {
+			violations = append(violations, pubViolation{PubViolationReasonResolution,
+				fmt.Sprintf("resolution %dx%d exceeds limit %dx%d", stat.VideoWidth, stat.VideoHeight, policy.MaxWidth, policy.MaxHeight)})
+		}
+	}
+	if policy.MaxBitrateKbits > 0 && bitrate > policy.MaxBitrateKbits {
+		violations = append(violations, pubViolation{PubViolationReasonBitrate,
+			fmt.Sprintf("bitrate %dkbit/s exceeds limit %dkbit/s", bitrate, policy.MaxBitrateKbits)})
+	}
+	if stat.VideoCodec != "" && !codecAllowed(policy.AllowedVideoCodecs, stat.VideoCodec) {
+		violations = append(violations, pubViolation{PubViolationReasonVideoCodec,
+			fmt.Sprintf("video codec %s not allowed, allowed=%s", stat.VideoCodec, strings.Join(policy.AllowedVideoCodecs, ","))})
+	}
+	if stat.AudioCodec != "" && !codecAllowed(policy.AllowedAudioCodecs, stat.AudioCodec) {
+		violations = append(violations, pubViolation{PubViolationReasonAudioCodec,
+			fmt.Sprintf("audio codec %s not allowed, allowed=%s", stat.AudioCodec, strings.Join(policy.AllowedAudioCodecs, ","))})
+	}
+	if policy.MaxKeyFrameIntervalMs > 0 && stat.VideoStat != nil && stat.VideoStat.LastKeyFrameIntervalMs > policy.MaxKeyFrameIntervalMs {
+		violations = append(violations, pubViolation{PubViolationReasonKeyFrameInterval,
+			fmt.Sprintf("key frame interval %dms exceeds limit %dms", stat.VideoStat.LastKeyFrameIntervalMs, policy.MaxKeyFrameIntervalMs)})
+	}
+	return
+}
+
+func codecAllowed(allowed []string, codec string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, c := range allowed {
+		if strings.EqualFold(c, codec) {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// checkPublishConstraint 检查rtmp、rtsp推流是否违反`publish_constraint`，违反时发送事件通知，并按配置关闭推流
+//
+func (group *Group) checkPublishConstraint() {
+	if !group.config.PublishConstraintConfig.Enable {
+		return
+	}
+
+	var url string
+	var bitrate int
+	if group.rtmpPubSession != nil {
+		url = group.rtmpPubSession.Url()
+		bitrate = group.rtmpPubSession.GetStat().ReadBitrate
+	} else if group.rtspPubSession != nil {
+		url = group.rtspPubSession.Url()
+		bitrate = group.rtspPubSession.GetStat().ReadBitrate
+	} else {
+		return
+	}
+	if group.config.StreamKeyConfig.Enable {
+		// 推流url中的流名称可能是密钥
+		url = replaceUrlStreamName(url, group.streamName)
+	}
+
+	if group.pubConstraint == nil {
+		group.pubConstraint = &pubConstraintState{
+			policy:   matchPublishPolicy(group.config.PublishConstraintConfig.Policies, url),
+			notified: make(map[string]struct{}),
+		}
+	}
+	policy := group.pubConstraint.policy
+	if policy == nil {
+		return
+	}
+
+	group.stat.VideoStat = group.videoStat()
+	for _, v := range checkPublishPolicy(policy, &group.stat, bitrate) {
+		if _, ok := group.pubConstraint.notified[v.reason]; ok {
+			continue
+		}
+		group.pubConstraint.notified[v.reason] = struct{}{}
+
+		info := base.PubViolationInfo{
+			SessionId:  group.inSessionUniqueKey(),
+			AppName:    group.appName,
+			StreamName: group.streamName,
+			Url:        url,
+			Reason:     v.reason,
+			Detail:     v.detail,
+			Disconnect: policy.Disconnect,
+		}
+		Log.Warnf("[%s] publish constraint violated. info=%+v", group.UniqueKey, info)
+		group.observer.OnPubViolation(info)
+
+		if policy.Disconnect {
+			if group.rtmpPubSession != nil {
+				_ = group.rtmpPubSession.DisposeWithStatus(rtmp.OnStatusCodePublishRejected, v.detail)
+			} else {
+				_ = group.rtspPubSession.Dispose()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMatchPublishPolicy(t *testing.T) {
+	policies := []PublishPolicyConfig{
+		{Vhost: "Live.Example.com", MaxWidth: 1920},
+		{MaxWidth: 1280},
+	}
+	assert.Equal(t, 1920, matchPublishPolicy(policies, "rtmp://live.example.com/live/test").MaxWidth)
+	assert.Equal(t, 1280, matchPublishPolicy(policies, "rtmp://127.0.0.1/live/test").MaxWidth)
+	assert.Equal(t, 1280, matchPublishPolicy(policies, "invalid").MaxWidth)
+	assert.Equal(t, true, matchPublishPolicy(policies[:1], "rtmp://127.0.0.1/live/test") == nil)
+}
+
+func TestCheckPublishPolicy(t *testing.T) {
+	policy := &PublishPolicyConfig{
+		MaxWidth:              1280,
+		MaxHeight:             720,
+		MaxBitrateKbits:       2000,
+		AllowedVideoCodecs:    []string{"h264"},
+		AllowedAudioCodecs:    []string{base.AudioCodecAac},
+		MaxKeyFrameIntervalMs: 4000,
+	}
+
+	// 还没有收到音视频信息
+	assert.Equal(t, 0, len(checkPublishPolicy(policy, &base.StatGroup{}, 0)))
+
+	stat := &base.StatGroup{
+		VideoCodec:  base.VideoCodecAvc,
+		AudioCodec:  base.AudioCodecAac,
+		VideoWidth:  1280,
+		VideoHeight: 720,
+		VideoStat:   &base.StatVideo{LastKeyFrameIntervalMs: 2000},
+	}
+	assert.Equal(t, 0, len(checkPublishPolicy(policy, stat, 2000)))
+
+	stat.VideoCodec = base.VideoCodecHevc
+	stat.AudioCodec = base.AudioCodecMp3
+	stat.VideoHeight = 1080
+	stat.VideoStat.LastKeyFrameIntervalMs = 10000
+	var reasons []string
+	for _, v := range checkPublishPolicy(policy, stat, 3000) {
+		reasons = append(reasons, v.reason)
+	}
+	assert.Equal(t, []string{PubViolationReasonResolution, PubViolationReasonBitrate, PubViolationReasonVideoCodec,
+		PubViolationReasonAudioCodec, PubViolationReasonKeyFrameInterval}, reasons)
+
+	// 不限制
+	assert.Equal(t, 0, len(checkPublishPolicy(&PublishPolicyConfig{}, stat, 3000)))
+}
@@ -11,6 +11,7 @@ package logic
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/q191201771/lal/pkg/httpflv"
 )
@@ -21,6 +22,10 @@ func (group *Group) startRecordFlvIfNeeded(nowUnix int64) {
 	if !group.config.RecordConfig.EnableFlv {
 		return
 	}
+	if group.isRecordPaused() {
+		group.recordPaused = true
+		return
+	}
 
 	// 构造文件名
 	filename := fmt.Sprintf("%s-%d.flv", group.streamName, nowUnix)
@@ -28,7 +33,7 @@ func (group *Group) startRecordFlvIfNeeded(nowUnix int64) {
 
 	// 初始化录制
 	group.recordFlv = &httpflv.FlvFileWriter{}
-	if err := group.recordFlv.Open(filenameWithPath); err != nil {
+	if err := group.recordFlv.OpenWithKeyring(filenameWithPath, group.config.RecordEncryptConfig.WriteKeyring(), group.streamName); err != nil {
 		Log.Errorf("[%s] record flv open file failed. filename=%s, err=%+v",
 			group.UniqueKey, filenameWithPath, err)
 		group.recordFlv = nil
@@ -50,3 +55,33 @@ func (group *Group) stopRecordFlvIfNeeded() {
 		group.recordFlv = nil
 	}
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// pauseOrResumeRecordIfNeeded 根据外部状态（比如磁盘空间）暂停或恢复flv、mpegts录制，恢复时录制到新的文件中
+//
+func (group *Group) pauseOrResumeRecordIfNeeded() {
+	paused := group.isRecordPaused()
+	if paused == group.recordPaused {
+		return
+	}
+
+	Log.Infof("[%s] record paused %v -> %v", group.UniqueKey, group.recordPaused, paused)
+	if paused {
+		group.stopRecordFlvIfNeeded()
+		group.stopRecordMpegtsIfNeeded()
+		group.recordPaused = true
+		return
+	}
+
+	group.recordPaused = false
+	if group.hasInSession() {
+		now := time.Now().Unix()
+		group.startRecordFlvIfNeeded(now)
+		group.startRecordMpegtsIfNeeded(now)
+	}
+}
+
+func (group *Group) isRecordPaused() bool {
+	return group.observer != nil && group.observer.IsRecordPaused()
+}
@@ -25,6 +25,20 @@ func (group *Group) startHlsIfNeeded() {
 
 	group.hlsMuxer = hls.NewMuxer(group.streamName, &group.config.HlsConfig.MuxerConfig, group)
 	group.hlsMuxer.Start()
+	if len(group.subtitleTracks) != 0 {
+		group.hlsMuxer.SetSubtitleTracks(group.subtitleTracks)
+	}
+}
+
+// SetSubtitleTracks 给hls挂载WebVTT字幕，hls重新开启时依然生效
+//
+func (group *Group) SetSubtitleTracks(tracks []hls.SubtitleTrack) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.subtitleTracks = tracks
+	if group.hlsMuxer != nil {
+		group.hlsMuxer.SetSubtitleTracks(tracks)
+	}
 }
 
 func (group *Group) stopHlsIfNeeded() {
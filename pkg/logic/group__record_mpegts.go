@@ -21,13 +21,17 @@ func (group *Group) startRecordMpegtsIfNeeded(nowUnix int64) {
 	if !group.config.RecordConfig.EnableMpegts {
 		return
 	}
+	if group.isRecordPaused() {
+		group.recordPaused = true
+		return
+	}
 
 	// 构造文件名
 	filename := fmt.Sprintf("%s-%d.ts", group.streamName, nowUnix)
 	filenameWithPath := filepath.Join(group.config.RecordConfig.MpegtsOutPath, filename)
 
 	group.recordMpegts = &mpegts.FileWriter{}
-	if err := group.recordMpegts.Create(filenameWithPath); err != nil {
+	if err := group.recordMpegts.CreateWithKeyring(filenameWithPath, group.config.RecordEncryptConfig.WriteKeyring(), group.streamName); err != nil {
 		Log.Errorf("[%s] record mpegts open file failed. filename=%s, err=%+v",
 			group.UniqueKey, filenameWithPath, err)
 		group.recordMpegts = nil
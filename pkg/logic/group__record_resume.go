@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// group__record_resume.go
+//
+// 输入流断开后，如果在`record.resume_grace_sec`时间内重新输入，则继续写入原来的flv、mpegts录制文件以及hls，
+// 并修正时间戳，使其和断开前保持连续
+//
+
+// 续录时，断开前最后一个消息和重连后第一个消息之间的时间戳间隔，单位毫秒
+const resumeTsGapMs = 40
+
+// waitResumeRecordIfNeeded 输入流断开时调用
+//
+// @return 如果返回true，表示录制没有关闭，正在等待续录
+//
+func (group *Group) waitResumeRecordIfNeeded() bool {
+	if group.config.RecordConfig.ResumeGraceSec <= 0 {
+		return false
+	}
+	if group.hlsMuxer == nil && group.recordFlv == nil && group.recordMpegts == nil {
+		return false
+	}
+	group.resumeDeadline = time.Now().Unix() + int64(group.config.RecordConfig.ResumeGraceSec)
+	Log.Infof("[%s] in session gone, wait %d seconds for resume record.", group.UniqueKey, group.config.RecordConfig.ResumeGraceSec)
+	return true
+}
+
+// resumeRecordIfNeeded 输入流加入时调用
+//
+// @return 如果返回true，表示继续使用断开前的录制，不需要重新开启
+//
+func (group *Group) resumeRecordIfNeeded() bool {
+	if group.resumeDeadline == 0 {
+		group.resumeTsOffset = 0
+		group.resumeTsFlag = false
+		group.resumeMaxTs = 0
+		return false
+	}
+	group.resumeDeadline = 0
+	group.resumeTsFlag = true
+	Log.Infof("[%s] resume record.", group.UniqueKey)
+	return true
+}
+
+// stopResumeRecordIfTimeout 定时器调用，超过等待时间没有重新输入，则关闭录制
+//
+func (group *Group) stopResumeRecordIfTimeout(nowUnix int64) {
+	if group.resumeDeadline == 0 || nowUnix < group.resumeDeadline {
+		return
+	}
+	Log.Infof("[%s] resume record timeout.", group.UniqueKey)
+	group.stopWaitingResumeRecord()
+}
+
+func (group *Group) stopWaitingResumeRecord() {
+	if group.resumeDeadline == 0 {
+		return
+	}
+	group.resumeDeadline = 0
+	group.stopHlsIfNeeded()
+	group.stopRecordFlvIfNeeded()
+	group.stopRecordMpegtsIfNeeded()
+	group.releaseRecordKey()
+}
+
+func (group *Group) isWaitingResumeRecord() bool {
+	return group.resumeDeadline != 0
+}
+
+// fixResumeTimestamp 续录后，修正时间戳，使其和断开前保持连续
+//
+func (group *Group) fixResumeTimestamp(msg *base.RtmpMsg) {
+	if group.resumeTsFlag {
+		group.resumeTsFlag = false
+		group.resumeTsOffset = group.resumeMaxTs + resumeTsGapMs - msg.Header.TimestampAbs
+	}
+	msg.Header.TimestampAbs += group.resumeTsOffset
+	if msg.Header.TimestampAbs > group.resumeMaxTs {
+		group.resumeMaxTs = msg.Header.TimestampAbs
+	}
+}
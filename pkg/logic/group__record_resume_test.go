@@ -0,0 +1,49 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGroupResumeRecordTimestamp(t *testing.T) {
+	var config Config
+	config.RecordConfig.ResumeGraceSec = 10
+	group := NewGroup("live", "test110", &config, nil)
+
+	fix := func(ts uint32) uint32 {
+		msg := base.RtmpMsg{Header: base.RtmpHeader{TimestampAbs: ts}}
+		group.fixResumeTimestamp(&msg)
+		return msg.Header.TimestampAbs
+	}
+
+	// 第一次推流，时间戳不变
+	assert.Equal(t, false, group.resumeRecordIfNeeded())
+	assert.Equal(t, uint32(0), fix(0))
+	assert.Equal(t, uint32(5000), fix(5000))
+
+	// 没有录制时，不等待续录
+	assert.Equal(t, false, group.waitResumeRecordIfNeeded())
+
+	// 断开后重新推流，时间戳和断开前连续
+	group.resumeDeadline = 1
+	assert.Equal(t, true, group.resumeRecordIfNeeded())
+	assert.Equal(t, uint32(5000+resumeTsGapMs), fix(100))
+	assert.Equal(t, uint32(5000+resumeTsGapMs+40), fix(140))
+
+	// 超时后，不再续录，时间戳重新开始
+	group.resumeDeadline = 1
+	group.stopResumeRecordIfTimeout(2)
+	assert.Equal(t, false, group.isWaitingResumeRecord())
+	assert.Equal(t, false, group.resumeRecordIfNeeded())
+	assert.Equal(t, uint32(100), fix(100))
+}
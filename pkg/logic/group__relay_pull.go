@@ -10,7 +10,9 @@ package logic
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/rtmp"
 )
 
@@ -19,19 +21,87 @@ import (
 // 当前调用时机：
 // 1. 比如http api
 //
-func (group *Group) StartPull(url string) {
+// @param keepWithoutSub: 为true时，没有sub session也开始拉流，并且没有sub session时不自动停止，直到调用 StopPull
+//
+// @param requestId: 触发拉流的http api请求的request id，之后的拉流（包括重试）都携带该值，直到调用 StopPull
+//
+func (group *Group) StartPull(url string, keepWithoutSub bool, requestId string) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
 	group.setPullUrl(true, url)
+	group.pullUrlFromApi = true
+	group.pullKeepAlone = keepWithoutSub
+	group.pullRequestId = requestId
 	group.pullIfNeeded()
 }
 
+// StopPull 外部命令主动停止pull拉流，并恢复为配置文件中的回源设置
+//
+// 注意，如果配置文件中开启了静态回源并且还有sub session，会按静态回源的地址重新拉流
+//
+// @return 是否有正在进行的拉流
+//
+func (group *Group) StopPull() bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	group.pullUrlFromApi = false
+	group.pullKeepAlone = false
+	group.pullRequestId = ""
+	group.initRelayPull()
+
+	if !group.getPullingFlag() {
+		return false
+	}
+	Log.Infof("[%s] stop pull by api.", group.UniqueKey)
+	if group.pullProxy.pullSession != nil {
+		group.pullProxy.pullSession.Dispose()
+	}
+	return true
+}
+
+// WaitPullAvData 等待回源拉流收到音视频数据，并且音视频编码信息完整，见 onInAvData
+//
+// 注意，如果group中已经有pub输入流，不会回源拉流，此时等待的是pub输入流的音视频数据
+//
+// @return sessionId: 输入流的session id，一般是回源拉流的session
+// @return stat:      收到数据时group的stat，可以获取音视频编码等信息
+// @return ok:        false表示超时
+//
+func (group *Group) WaitPullAvData(timeout time.Duration) (sessionId string, stat base.StatGroup, ok bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		group.mutex.Lock()
+		if group.hasInSession() && group.inCodecReady {
+			sessionId, stat = group.inSessionUniqueKey(), group.stat
+			group.mutex.Unlock()
+			return sessionId, stat, true
+		}
+		if group.avDataCh == nil {
+			group.avDataCh = make(chan struct{})
+		}
+		ch := group.avDataCh
+		group.mutex.Unlock()
+
+		// 收到通知后重新检查，因为期间输入流的session可能已经变化
+		select {
+		case <-ch:
+		case <-timer.C:
+			return "", stat, false
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 type pullProxy struct {
-	isPulling   bool
-	pullSession *rtmp.PullSession
+	isPulling      bool
+	pullSession    *rtmp.PullSession
+	rawQuery       string // 触发回源拉流的sub session的url参数
+	waitDeadlineMs int64  // 开始回源时，等待的sub session的超时时间，unix毫秒，0表示没有在等待
+	retryCount     int    // 连续失败的次数，连接成功后清零
 }
 
 func (group *Group) initRelayPull() {
@@ -57,8 +127,21 @@ func (group *Group) setPullUrl(enable bool, url string) {
 	group.pullUrl = url
 }
 
+// 静态回源时，携带触发回源的sub session的url参数，比如鉴权参数
+// http api触发的拉流使用接口中指定的参数
 func (group *Group) getPullUrl() string {
-	return group.pullUrl
+	if group.pullUrlFromApi {
+		return group.pullUrl
+	}
+	return base.AppendRawQuery(group.pullUrl, group.pullProxy.rawQuery)
+}
+
+// 没有在拉流时，使用最近加入的sub session的参数
+func (group *Group) setPullRawQueryIfNeeded(rawQuery string) {
+	if group.getPullingFlag() {
+		return
+	}
+	group.pullProxy.rawQuery = rawQuery
 }
 
 func (group *Group) setPullingFlag(flag bool) {
@@ -81,7 +164,7 @@ func (group *Group) pullIfNeeded() {
 		return
 	}
 	// 如果没有从本地拉流的，就不需要pull了
-	if !group.hasSubSession() {
+	if !group.hasSubSession() && !group.pullKeepAlone {
 		return
 	}
 	// 如果本地已经有输入型的流，就不需要pull了
@@ -93,16 +176,25 @@ func (group *Group) pullIfNeeded() {
 		return
 	}
 	group.setPullingFlag(true)
+	// 多个sub session同时等待同一路流时，只会有一个回源拉流，超时时间从第一次回源开始计算
+	if group.config.RelayPullConfig.WaitTimeoutMs > 0 && group.pullProxy.waitDeadlineMs == 0 {
+		group.pullProxy.waitDeadlineMs = time.Now().UnixNano()/1e6 + int64(group.config.RelayPullConfig.WaitTimeoutMs)
+	}
 
-	Log.Infof("[%s] start relay pull. url=%s", group.UniqueKey, group.getPullUrl())
+	url := group.getPullUrl()
+	retryCount := group.pullProxy.retryCount
+	requestId := group.pullRequestId
+	Log.Infof("[%s] start relay pull. url=%s, retry=%d, request_id=%s", group.UniqueKey, url, retryCount, requestId)
 
 	go func() {
 		pullSession := rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
 			option.PullTimeoutMs = relayPullTimeoutMs
 			option.ReadAvTimeoutMs = relayPullReadAvTimeoutMs
+			option.ProxyUrl = group.config.RelayPullConfig.ProxyUrl
 		})
 		// TODO(chef): 处理数据回调，是否应该等待Add成功之后。避免竞态条件中途加入了其他in session
-		err := pullSession.Pull(group.getPullUrl(), group.OnReadRtmpAvMsg)
+		err := pullSession.Pull(url, group.onReadPullRtmpAvMsg)
+		group.onRelayPullStart(pullSession.UniqueKey(), url, retryCount, requestId, err)
 		if err != nil {
 			Log.Errorf("[%s] relay pull fail. err=%v", pullSession.UniqueKey(), err)
 			group.DelRtmpPullSession(pullSession)
@@ -110,15 +202,41 @@ func (group *Group) pullIfNeeded() {
 		}
 		res := group.AddRtmpPullSession(pullSession)
 		if res {
+			if requestId != "" {
+				group.TagSession(pullSession.UniqueKey(), map[string]string{SessionTagKeyRequestId: requestId})
+			}
 			err = <-pullSession.WaitChan()
 			Log.Infof("[%s] relay pull done. err=%v", pullSession.UniqueKey(), err)
 			group.DelRtmpPullSession(pullSession)
 		} else {
 			pullSession.Dispose()
+			err = base.ErrDupInStream
 		}
+		group.onRelayPullStop(pullSession.UniqueKey(), url, requestId, err)
 	}()
 }
 
+// 回源超时，关闭所有等待的sub session
+//
+// 当前调用时机：
+// 1. 定时器定时检查
+//
+func (group *Group) stopPullWaitIfTimeout(nowMs int64) {
+	if group.pullProxy.waitDeadlineMs == 0 {
+		return
+	}
+	if group.hasInSession() || !group.hasSubSession() {
+		group.pullProxy.waitDeadlineMs = 0
+		return
+	}
+	if nowMs < group.pullProxy.waitDeadlineMs {
+		return
+	}
+	Log.Warnf("[%s] relay pull wait timeout, dispose all sub session.", group.UniqueKey)
+	group.pullProxy.waitDeadlineMs = 0
+	group.disposeAllSubSessions()
+}
+
 // 判断是否需要停止pull
 //
 // 当前调用时机：
@@ -126,8 +244,87 @@ func (group *Group) pullIfNeeded() {
 //
 func (group *Group) stopPullIfNeeded() {
 	// 没有输出型的流了
-	if group.pullProxy.pullSession != nil && !group.hasSubSession() {
+	if group.pullProxy.pullSession != nil && !group.hasSubSession() && !group.pullKeepAlone {
 		Log.Infof("[%s] stop pull since no sub session.", group.UniqueKey)
 		group.pullProxy.pullSession.Dispose()
 	}
 }
+
+// onInAvData 输入流收到音视频数据，并且已经记录到stat后调用，编码信息完整时唤醒 WaitPullAvData 的等待方，注意，调用方需要持有锁
+//
+// 编码信息完整是指以下任意一种情况：
+// - 音频和视频的seq header都已经收到
+// - 收到了第一个视频关键帧，之后不会再有新的seq header
+// - metadata表明没有视频，并且收到了第一个不是seq header的音频帧
+//
+func (group *Group) onInAvData(msg base.RtmpMsg) {
+	if group.inCodecReady {
+		return
+	}
+	if msg.IsAacSeqHeader() {
+		group.inHasAudioSeqHeader = true
+	}
+	if msg.IsVideoKeySeqHeader() {
+		group.inHasVideoSeqHeader = true
+	}
+	audioFrame := msg.Header.MsgTypeId == base.RtmpTypeIdAudio && !msg.IsAacSeqHeader()
+	if !(group.inHasAudioSeqHeader && group.inHasVideoSeqHeader) && !msg.IsVideoKeyNalu() &&
+		!(group.inMetadataNoVideo && audioFrame) {
+		return
+	}
+	group.inCodecReady = true
+	if group.avDataCh != nil {
+		close(group.avDataCh)
+		group.avDataCh = nil
+	}
+}
+
+func (group *Group) onReadPullRtmpAvMsg(msg base.RtmpMsg) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if !group.filterOwnInput(msg) {
+		return
+	}
+	group.broadcastByRtmpMsg(msg)
+}
+
+// onRelayPullStart 回源拉流连接结束握手时调用，更新连续失败次数，并通知业务方
+//
+func (group *Group) onRelayPullStart(sessionId string, url string, retryCount int, requestId string, err error) {
+	group.mutex.Lock()
+	if err != nil {
+		group.pullProxy.retryCount++
+	} else {
+		group.pullProxy.retryCount = 0
+	}
+	group.mutex.Unlock()
+
+	var info base.RelayPullStartInfo
+	info.RelayEventCommonInfo = group.makeRelayEventCommonInfo(sessionId, url, retryCount)
+	info.RequestId = requestId
+	info.Success = err == nil
+	if err != nil {
+		info.Reason = err.Error()
+	}
+	group.observer.OnRelayPullStart(info)
+}
+
+func (group *Group) onRelayPullStop(sessionId string, url string, requestId string, err error) {
+	var info base.RelayPullStopInfo
+	info.RelayEventCommonInfo = group.makeRelayEventCommonInfo(sessionId, url, 0)
+	info.RequestId = requestId
+	if err != nil {
+		info.Reason = err.Error()
+	}
+	group.observer.OnRelayPullStop(info)
+}
+
+func (group *Group) makeRelayEventCommonInfo(sessionId string, url string, retryCount int) base.RelayEventCommonInfo {
+	return base.RelayEventCommonInfo{
+		SessionId:  sessionId,
+		Url:        url,
+		AppName:    group.appName,
+		StreamName: group.streamName,
+		RetryCount: retryCount,
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testRelayObserver struct {
+	IGroupObserver
+
+	pullStarts []base.RelayPullStartInfo
+	pullStops  []base.RelayPullStopInfo
+}
+
+func (o *testRelayObserver) OnRelayPullStart(info base.RelayPullStartInfo) {
+	o.pullStarts = append(o.pullStarts, info)
+}
+
+func (o *testRelayObserver) OnRelayPullStop(info base.RelayPullStopInfo) {
+	o.pullStops = append(o.pullStops, info)
+}
+
+func TestGroupRelayPullEvent(t *testing.T) {
+	var config Config
+	observer := &testRelayObserver{}
+	group := NewGroup("live", "test110", &config, observer)
+
+	url := "rtmp://127.0.0.1/live/test110"
+	group.onRelayPullStart("PULL1", url, group.pullProxy.retryCount, "", errors.New("connection refused"))
+	group.onRelayPullStart("PULL2", url, group.pullProxy.retryCount, "", errors.New("connection refused"))
+	assert.Equal(t, 2, group.pullProxy.retryCount)
+	group.onRelayPullStart("PULL3", url, group.pullProxy.retryCount, "REQ1", nil)
+	assert.Equal(t, 0, group.pullProxy.retryCount)
+	group.onRelayPullStop("PULL3", url, "REQ1", base.ErrDupInStream)
+
+	assert.Equal(t, 3, len(observer.pullStarts))
+	assert.Equal(t, false, observer.pullStarts[0].Success)
+	assert.Equal(t, "connection refused", observer.pullStarts[0].Reason)
+	assert.Equal(t, 1, observer.pullStarts[1].RetryCount)
+	assert.Equal(t, true, observer.pullStarts[2].Success)
+	assert.Equal(t, 2, observer.pullStarts[2].RetryCount)
+	assert.Equal(t, "test110", observer.pullStarts[2].StreamName)
+	assert.Equal(t, "", observer.pullStarts[0].RequestId)
+	assert.Equal(t, "REQ1", observer.pullStarts[2].RequestId)
+
+	assert.Equal(t, 1, len(observer.pullStops))
+	assert.Equal(t, "PULL3", observer.pullStops[0].SessionId)
+	assert.Equal(t, base.ErrDupInStream.Error(), observer.pullStops[0].Reason)
+	assert.Equal(t, "REQ1", observer.pullStops[0].RequestId)
+}
+
+func TestGroupWaitPullAvData(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, &testRelayObserver{})
+
+	// 没有在拉流
+	_, _, ok := group.WaitPullAvData(50 * time.Millisecond)
+	assert.Equal(t, false, ok)
+
+	// 多个等待方同时等待，收到数据时都被唤醒
+	pullSession := rtmp.NewPullSession()
+	otherDone := make(chan bool, 1)
+	go func() {
+		_, _, ok := group.WaitPullAvData(time.Second)
+		otherDone <- ok
+	}()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		group.mutex.Lock()
+		group.pullProxy.pullSession = pullSession
+		group.mutex.Unlock()
+		group.onReadPullRtmpAvMsg(testAacSeqHeaderMsg)
+		group.onReadPullRtmpAvMsg(testAvcSeqHeaderMsg)
+	}()
+	sessionId, stat, ok := group.WaitPullAvData(time.Second)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, pullSession.UniqueKey(), sessionId)
+	assert.Equal(t, base.AudioCodecAac, stat.AudioCodec)
+	assert.Equal(t, base.VideoCodecAvc, stat.VideoCodec)
+	assert.Equal(t, true, <-otherDone)
+	assert.Equal(t, true, group.avDataCh == nil)
+}
+
+func TestGroupWaitPullAvData_Pub(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, &testRelayObserver{})
+
+	// 已经有pub输入流，但是还没有数据时，pub收到数据也会唤醒等待方
+	ctx, err := group.AddCustomizePubSession("test110")
+	assert.Equal(t, nil, err)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ctx.FeedRtmpMsg(testAacSeqHeaderMsg)
+		ctx.FeedRtmpMsg(testAvcSeqHeaderMsg)
+	}()
+	start := time.Now()
+	sessionId, _, ok := group.WaitPullAvData(time.Second)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ctx.UniqueKey(), sessionId)
+	assert.Equal(t, true, time.Since(start) < 500*time.Millisecond)
+
+	// pub已经有数据时，直接返回
+	sessionId, _, ok = group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ctx.UniqueKey(), sessionId)
+
+	// 新的输入流需要重新收到数据
+	group.DelCustomizePubSession(ctx)
+	ctx, err = group.AddCustomizePubSession("test110")
+	assert.Equal(t, nil, err)
+	_, _, ok = group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, false, ok)
+}
+
+// 音频的seq header先到时，需要等到视频的编码信息也完整
+func TestGroupWaitPullAvData_AudioBeforeVideo(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, &testRelayObserver{})
+	ctx, err := group.AddCustomizePubSession("test110")
+	assert.Equal(t, nil, err)
+
+	ctx.FeedRtmpMsg(testAacSeqHeaderMsg)
+	ctx.FeedRtmpMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, MsgLen: 3},
+		Payload: []byte{0xAF, 0x01, 0x21},
+	})
+	_, _, ok := group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, false, ok)
+
+	ctx.FeedRtmpMsg(testAvcSeqHeaderMsg)
+	sessionId, stat, ok := group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, ctx.UniqueKey(), sessionId)
+	assert.Equal(t, base.AudioCodecAac, stat.AudioCodec)
+	assert.Equal(t, base.VideoCodecAvc, stat.VideoCodec)
+	assert.Equal(t, 768, stat.VideoWidth)
+	assert.Equal(t, 320, stat.VideoHeight)
+	group.DelCustomizePubSession(ctx)
+
+	// metadata表明没有视频时，收到第一个音频帧即可
+	ctx, err = group.AddCustomizePubSession("test110")
+	assert.Equal(t, nil, err)
+	payload, err := rtmp.BuildMetadata(-1, -1, 10, -1)
+	assert.Equal(t, nil, err)
+	ctx.FeedRtmpMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata, MsgLen: uint32(len(payload))},
+		Payload: payload,
+	})
+	ctx.FeedRtmpMsg(testAacSeqHeaderMsg)
+	_, _, ok = group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, false, ok)
+	ctx.FeedRtmpMsg(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, MsgLen: 3},
+		Payload: []byte{0xAF, 0x01, 0x21},
+	})
+	_, _, ok = group.WaitPullAvData(10 * time.Millisecond)
+	assert.Equal(t, true, ok)
+}
+
+var (
+	testAacSeqHeaderMsg = base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, MsgLen: 4},
+		Payload: []byte{0xAF, 0x00, 0x12, 0x10},
+	}
+	testAvcSeqHeaderPayload = []byte{
+		0x17, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x64, 0x00, 0x20, 0xFF,
+		0xE1, 0x00, 0x19,
+		0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96,
+		0x01, 0x00, 0x05,
+		0x68, 0xEB, 0xEC, 0xB2, 0x2C,
+	}
+	testAvcSeqHeaderMsg = base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, MsgLen: uint32(len(testAvcSeqHeaderPayload))},
+		Payload: testAvcSeqHeaderPayload,
+	}
+)
+
+func TestGroupStopPull(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, &testRelayObserver{})
+
+	// 没有在拉流
+	assert.Equal(t, false, group.StopPull())
+
+	group.setPullUrl(true, "rtmp://127.0.0.1/live/test110")
+	group.pullUrlFromApi = true
+	group.pullKeepAlone = true
+	group.pullRequestId = "REQ1"
+	group.setPullingFlag(true)
+	assert.Equal(t, false, group.IsTotalEmpty())
+
+	assert.Equal(t, true, group.StopPull())
+	assert.Equal(t, true, group.IsTotalEmpty())
+	assert.Equal(t, false, group.isPullEnable())
+	assert.Equal(t, false, group.pullUrlFromApi)
+	assert.Equal(t, "", group.pullRequestId)
+}
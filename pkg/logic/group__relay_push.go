@@ -11,6 +11,7 @@ package logic
 import (
 	"fmt"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/rtmp"
 )
 
@@ -38,6 +39,7 @@ func (group *Group) DelRtmpPushSession(url string, session *rtmp.PushSession) {
 type pushProxy struct {
 	isPushing   bool
 	pushSession *rtmp.PushSession
+	retryCount  int // 连续失败的次数，连接成功后清零
 }
 
 func (group *Group) initRelayPush() {
@@ -73,11 +75,13 @@ func (group *Group) startPushIfNeeded() {
 		return
 	}
 
-	// relay push时携带rtmp pub的参数
+	// relay push时携带pub的参数
 	// TODO chef: 这个逻辑放这里不太好看
 	var urlParam string
 	if group.rtmpPubSession != nil {
 		urlParam = group.rtmpPubSession.RawQuery()
+	} else if group.rtspPubSession != nil {
+		urlParam = group.rtspPubSession.RawQuery()
 	}
 
 	for url, v := range group.url2PushProxy {
@@ -91,14 +95,23 @@ func (group *Group) startPushIfNeeded() {
 		if urlParam != "" {
 			urlWithParam += "?" + urlParam
 		}
-		Log.Infof("[%s] start relay push. url=%s", group.UniqueKey, urlWithParam)
+		Log.Infof("[%s] start relay push. url=%s, retry=%d", group.UniqueKey, urlWithParam, v.retryCount)
 
-		go func(u, u2 string) {
+		go func(u, u2 string, retryCount int) {
 			pushSession := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
 				option.PushTimeoutMs = relayPushTimeoutMs
 				option.WriteAvTimeoutMs = relayPushWriteAvTimeoutMs
+				option.ProxyUrl = group.config.RelayPushConfig.ProxyUrl
+				option.ChunkSize = group.config.RelayPushConfig.ChunkSize
+				option.WinAckSize = group.config.RelayPushConfig.WindowAckSize
+				option.PeerBandwidth = group.config.RelayPushConfig.PeerBandwidth
+				if group.config.RelayPushConfig.MaxBitrateKbits > 0 {
+					option.MaxWriteBitrateKbits = group.config.RelayPushConfig.MaxBitrateKbits
+					option.WriteChanSize = relayPushShapedWriteChanSize
+				}
 			})
 			err := pushSession.Push(u2)
+			group.onRelayPushStart(u, pushSession.UniqueKey(), u2, retryCount, err)
 			if err != nil {
 				Log.Errorf("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
 				group.DelRtmpPushSession(u, pushSession)
@@ -108,7 +121,8 @@ func (group *Group) startPushIfNeeded() {
 			err = <-pushSession.WaitChan()
 			Log.Infof("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
 			group.DelRtmpPushSession(u, pushSession)
-		}(url, urlWithParam)
+			group.onRelayPushStop(pushSession.UniqueKey(), u2, err)
+		}(url, urlWithParam, v.retryCount)
 	}
 }
 
@@ -123,3 +137,37 @@ func (group *Group) stopPushIfNeeded() {
 		v.pushSession = nil
 	}
 }
+
+// onRelayPushStart 转推连接结束握手时调用，更新连续失败次数，并通知业务方
+//
+// @param url:          不带参数的转推地址，也即 url2PushProxy 的key
+// @param urlWithParam: 实际使用的转推地址
+//
+func (group *Group) onRelayPushStart(url string, sessionId string, urlWithParam string, retryCount int, err error) {
+	group.mutex.Lock()
+	if v, ok := group.url2PushProxy[url]; ok {
+		if err != nil {
+			v.retryCount++
+		} else {
+			v.retryCount = 0
+		}
+	}
+	group.mutex.Unlock()
+
+	var info base.RelayPushStartInfo
+	info.RelayEventCommonInfo = group.makeRelayEventCommonInfo(sessionId, urlWithParam, retryCount)
+	info.Success = err == nil
+	if err != nil {
+		info.Reason = err.Error()
+	}
+	group.observer.OnRelayPushStart(info)
+}
+
+func (group *Group) onRelayPushStop(sessionId string, urlWithParam string, err error) {
+	var info base.RelayPushStopInfo
+	info.RelayEventCommonInfo = group.makeRelayEventCommonInfo(sessionId, urlWithParam, 0)
+	if err != nil {
+		info.Reason = err.Error()
+	}
+	group.observer.OnRelayPushStop(info)
+}
@@ -0,0 +1,94 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// group__session_by_ip.go
+//
+// 按客户端ip查询、踢掉session，只处理由客户端发起的pub、sub session，不包含中继拉流、中继推流
+//
+
+// StatSessionsByIp 获取远端地址为`ip`的所有pub、sub session的状态
+//
+func (group *Group) StatSessionsByIp(ip string) (ret []base.StatIpSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	for _, s := range group.serverSessionsByIp(ip) {
+		ss := s.GetStat()
+		ss.Tags = group.getSessionTags(ss.SessionId)
+		ret = append(ret, base.StatIpSession{
+			StreamName:  group.streamName,
+			StatSession: ss,
+		})
+	}
+	return
+}
+
+// KickOutSessionsByIp 关闭远端地址为`ip`的所有pub、sub session
+//
+// @return 关闭的session数量
+//
+func (group *Group) KickOutSessionsByIp(ip string) int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	sessions := group.serverSessionsByIp(ip)
+	for _, s := range sessions {
+		Log.Infof("[%s] kick out session by ip. ip=%s, session id=%s", group.UniqueKey, ip, s.UniqueKey())
+		_ = s.Dispose()
+	}
+	return len(sessions)
+}
+
+func (group *Group) serverSessionsByIp(ip string) (ret []base.IServerSession) {
+	add := func(s base.IServerSession) {
+		if isRemoteAddrOfIp(s.GetStat().RemoteAddr, ip) {
+			ret = append(ret, s)
+		}
+	}
+	if group.rtmpPubSession != nil {
+		add(group.rtmpPubSession)
+	}
+	if group.rtspPubSession != nil {
+		add(group.rtspPubSession)
+	}
+	for s := range group.rtmpSubSessionSet {
+		add(s)
+	}
+	for s := range group.httpflvSubSessionSet {
+		add(s)
+	}
+	for s := range group.httptsSubSessionSet {
+		add(s)
+	}
+	for s := range group.rtspSubSessionSet {
+		add(s)
+	}
+	return
+}
+
+// isRemoteAddrOfIp
+//
+// @param remoteAddr: 格式为`ip:port`，也兼容只有ip的情况
+//
+func isRemoteAddrOfIp(remoteAddr string, ip string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if host == ip {
+		return true
+	}
+	a, b := net.ParseIP(host), net.ParseIP(ip)
+	return a != nil && b != nil && a.Equal(b)
+}
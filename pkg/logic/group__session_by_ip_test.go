@@ -0,0 +1,24 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestIsRemoteAddrOfIp(t *testing.T) {
+	assert.Equal(t, true, isRemoteAddrOfIp("10.1.1.1:54321", "10.1.1.1"))
+	assert.Equal(t, false, isRemoteAddrOfIp("10.1.1.11:54321", "10.1.1.1"))
+	assert.Equal(t, true, isRemoteAddrOfIp("10.1.1.1", "10.1.1.1"))
+	assert.Equal(t, true, isRemoteAddrOfIp("[::1]:1935", "::1"))
+	assert.Equal(t, true, isRemoteAddrOfIp("[2001:db8::1]:1935", "2001:DB8:0::1"))
+	assert.Equal(t, false, isRemoteAddrOfIp("", "10.1.1.1"))
+}
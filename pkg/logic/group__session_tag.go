@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+// TagSession 给session打上业务方自定义的key/value标签，比如鉴权回调中得到的用户id
+//
+// 标签会出现在stat、日志以及后续的事件通知中。多次调用时合并，value为空表示删除该key
+//
+// @return 如果session不存在，返回false
+//
+func (group *Group) TagSession(sessionId string, tags map[string]string) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.hasSession(sessionId) {
+		return false
+	}
+
+	if group.sessionTags == nil {
+		group.sessionTags = make(map[string]map[string]string)
+	}
+	m, ok := group.sessionTags[sessionId]
+	if !ok {
+		m = make(map[string]string)
+		group.sessionTags[sessionId] = m
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(m, k)
+		} else {
+			m[k] = v
+		}
+	}
+	Log.Infof("[%s] [%s] tag session. tags=%+v", group.UniqueKey, sessionId, m)
+	return true
+}
+
+// GetSessionTags 返回session标签的拷贝，没有标签时返回nil
+//
+func (group *Group) GetSessionTags(sessionId string) map[string]string {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.getSessionTags(sessionId)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (group *Group) getSessionTags(sessionId string) map[string]string {
+	m := group.sessionTags[sessionId]
+	if len(m) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (group *Group) delSessionTags(sessionId string) {
+	delete(group.sessionTags, sessionId)
+}
+
+func (group *Group) hasSession(sessionId string) bool {
+	if group.inSessionUniqueKey() == sessionId {
+		return true
+	}
+	for s := range group.rtmpSubSessionSet {
+		if s.UniqueKey() == sessionId {
+			return true
+		}
+	}
+	for s := range group.httpflvSubSessionSet {
+		if s.UniqueKey() == sessionId {
+			return true
+		}
+	}
+	for s := range group.httptsSubSessionSet {
+		if s.UniqueKey() == sessionId {
+			return true
+		}
+	}
+	for s := range group.rtspSubSessionSet {
+		if s.UniqueKey() == sessionId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// group__source_switch.go
+//
+// 切换group的输入源：使用另一个group（流）的数据作为本group的输出，在新输入源的下一个关键帧处切换，sub不需要断开重连。
+// 常用于主备编码器的切换。
+//
+// - 切换后输出的时间戳是连续的
+// - 切换时先发送新输入源的metadata、seq header，编码参数不同时各输出协议的处理见 onVideoSeqHeader
+// - 切换到其他输入源期间，本group自身的输入（pub、pull）继续接收，但是不转发，可以随时切换回来
+// - 输入源的输入结束时，自动切换回本group自身的输入
+//
+
+// 切换输入源时，切换前最后一帧和切换后第一帧之间的时间戳间隔，单位毫秒
+const switchSourceGapMs = 40
+
+type sourceSwitch struct {
+	own    *switchInput // 本group自身的输入
+	active *switchInput // 当前转发的输入
+	target *switchInput // 等待切换的输入，在它的下一个关键帧处切换。为nil表示没有在切换
+
+	hasOut   bool
+	lastTs   uint32 // 最近一次转发的时间戳
+	switchTs uint32 // 最近一次切换时的时间戳，切换后的数据的时间戳不小于它
+}
+
+type switchInput struct {
+	group    *Group // 输入源的group，本group自身的输入时为nil
+	tsOffset int64
+
+	// 输入的最新的metadata和seq header，切换到该输入时先发送
+	metadata       *base.RtmpMsg
+	videoSeqHeader *base.RtmpMsg
+	aacSeqHeader   *base.RtmpMsg
+}
+
+// SwitchSource 切换输入源
+//
+// @param source: 新的输入源，为nil表示切换回本group自身的输入
+//
+// @return prevTarget: 之前等待切换、但是还没有切换成功的输入源，调用方需要将本group从它的tap中删除
+//
+func (group *Group) SwitchSource(source *Group) (prevTarget *Group) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.sourceSwitch == nil {
+		group.sourceSwitch = &sourceSwitch{
+			own: group.newOwnSwitchInput(),
+		}
+		group.sourceSwitch.active = group.sourceSwitch.own
+	}
+	s := group.sourceSwitch
+
+	if s.target != nil {
+		prevTarget = s.target.group
+	}
+
+	switch {
+	case s.active.group == source:
+		// 取消还没有完成的切换
+		s.target = nil
+	case source == nil:
+		s.target = s.own
+	case prevTarget == source:
+		// 已经在切换到该输入源，保持不变
+		prevTarget = nil
+	default:
+		s.target = &switchInput{group: source}
+	}
+	Log.Infof("[%s] switch source. active=%s, target=%s", group.UniqueKey, s.active.name(group), s.target.name(group))
+	return
+}
+
+// SwitchSourceGroups 当前转发的以及等待切换的输入源，不包含本group自身的输入
+//
+func (group *Group) SwitchSourceGroups() (ret []*Group) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.switchSourceGroups()
+}
+
+// OwnInputStat 本group自身是否有输入，以及自身输入的消息数量，见 BackupPublisher
+//
+func (group *Group) OwnInputStat() (hasIn bool, count uint64) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.hasInSession(), group.ownInputCount
+}
+
+// AddRtmpTap 将本group的输入数据转给`dst`，作为`dst`的输入源，见 Group.SwitchSource
+//
+// 加入时先给`dst`发送已缓存的metadata、seq header
+//
+func (group *Group) AddRtmpTap(dst *Group) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.rtmpTaps == nil {
+		group.rtmpTaps = make(map[*Group]struct{})
+	}
+	group.rtmpTaps[dst] = struct{}{}
+
+	if group.metadata != nil {
+		if payload, err := packMetadata(group.metadata); err == nil {
+			header := group.metadataHeader
+			header.MsgLen = uint32(len(payload))
+			dst.OnSourceRtmpMsg(group, base.RtmpMsg{Header: header, Payload: payload})
+		}
+	}
+	if group.videoSeqHeader != nil {
+		dst.OnSourceRtmpMsg(group, newSwitchConfigMsg(base.RtmpTypeIdVideo, group.videoSeqHeader))
+	}
+	if group.aacSeqHeader != nil {
+		dst.OnSourceRtmpMsg(group, newSwitchConfigMsg(base.RtmpTypeIdAudio, group.aacSeqHeader))
+	}
+}
+
+func (group *Group) DelRtmpTap(dst *Group) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	delete(group.rtmpTaps, dst)
+}
+
+// OnSourceRtmpMsg 输入源`source`的数据，来自 Group.AddRtmpTap
+//
+// 注意，调用时持有`source`的锁
+//
+func (group *Group) OnSourceRtmpMsg(source *Group, msg base.RtmpMsg) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	s := group.sourceSwitch
+	if s == nil {
+		return
+	}
+	if s.active.group == source {
+		group.feedSwitchInput(s.active, msg)
+	} else if s.target != nil && s.target.group == source {
+		group.feedSwitchInput(s.target, msg)
+	}
+}
+
+// OnSourceEnd 输入源`source`的输入结束
+//
+// 注意，调用时持有`source`的锁
+//
+func (group *Group) OnSourceEnd(source *Group) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	s := group.sourceSwitch
+	if s == nil {
+		return
+	}
+	if s.target != nil && s.target.group == source {
+		s.target = nil
+	}
+	if s.active.group == source {
+		Log.Warnf("[%s] switch source input end, switch back. source=%s", group.UniqueKey, source.streamName)
+		s.target = s.own
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// filterOwnInput 本group自身的输入数据，返回false表示已经由 sourceSwitch 处理，调用方不需要再转发
+//
+func (group *Group) filterOwnInput(msg base.RtmpMsg) bool {
+	group.ownInputCount++
+	if group.sourceSwitch == nil {
+		return true
+	}
+	group.feedSwitchInput(group.sourceSwitch.own, msg)
+	return false
+}
+
+func (group *Group) feedSwitchInput(in *switchInput, msg base.RtmpMsg) {
+	s := group.sourceSwitch
+
+	if len(msg.Payload) > 1 && in.cacheConfigMsg(msg) {
+		if in == s.active {
+			group.broadcastSwitchMsg(in, msg)
+		}
+		return
+	}
+
+	if in == s.target && in.isSwitchPoint(msg) {
+		group.doSwitchSource(in, msg.Header.TimestampAbs)
+	}
+	if in == s.active {
+		group.broadcastSwitchMsg(in, msg)
+	}
+}
+
+func (group *Group) doSwitchSource(in *switchInput, ts uint32) {
+	s := group.sourceSwitch
+	prev := s.active
+
+	if s.hasOut {
+		s.switchTs = s.lastTs + switchSourceGapMs
+	}
+	in.tsOffset = int64(s.switchTs) - int64(ts)
+	s.active = in
+	s.target = nil
+	Log.Infof("[%s] source switched. prev=%s, curr=%s, ts=%d", group.UniqueKey, prev.name(group), in.name(group), s.switchTs)
+
+	if group.hlsMuxer != nil {
+		group.hlsMuxer.Discontinue()
+	}
+	for _, m := range []*base.RtmpMsg{in.metadata, in.videoSeqHeader, in.aacSeqHeader} {
+		if m != nil {
+			msg := *m
+			msg.Header.TimestampAbs = ts
+			group.broadcastSwitchMsg(in, msg)
+		}
+	}
+
+	// 注意，这里持有本group的锁，并且可能持有`in.group`的锁，所以异步删除
+	if prev.group != nil {
+		go prev.group.DelRtmpTap(group)
+	}
+}
+
+func (group *Group) broadcastSwitchMsg(in *switchInput, msg base.RtmpMsg) {
+	s := group.sourceSwitch
+	ts := int64(msg.Header.TimestampAbs) + in.tsOffset
+	if ts < int64(s.switchTs) {
+		ts = int64(s.switchTs)
+	}
+	msg.Header.TimestampAbs = uint32(ts)
+	if !s.hasOut || msg.Header.TimestampAbs > s.lastTs {
+		s.lastTs = msg.Header.TimestampAbs
+	}
+	s.hasOut = true
+	group.broadcastByRtmpMsg(msg)
+}
+
+// feedRtmpTaps 将输入数据转给以本group为输入源的group
+//
+func (group *Group) feedRtmpTaps(msg base.RtmpMsg) {
+	for dst := range group.rtmpTaps {
+		dst.OnSourceRtmpMsg(group, msg)
+	}
+}
+
+// endRtmpTaps 本group的输入结束
+//
+func (group *Group) endRtmpTaps() {
+	for dst := range group.rtmpTaps {
+		dst.OnSourceEnd(group)
+	}
+	group.rtmpTaps = nil
+}
+
+// disposeSourceSwitch group销毁时，将本group从输入源的tap中删除
+//
+func (group *Group) disposeSourceSwitch() {
+	for _, g := range group.switchSourceGroups() {
+		go g.DelRtmpTap(group)
+	}
+	group.sourceSwitch = nil
+}
+
+func (group *Group) switchSourceGroups() (ret []*Group) {
+	s := group.sourceSwitch
+	if s == nil {
+		return nil
+	}
+	if s.active.group != nil {
+		ret = append(ret, s.active.group)
+	}
+	if s.target != nil && s.target.group != nil {
+		ret = append(ret, s.target.group)
+	}
+	return
+}
+
+func (group *Group) newOwnSwitchInput() *switchInput {
+	in := &switchInput{}
+	if group.metadata != nil {
+		if payload, err := packMetadata(group.metadata); err == nil {
+			header := group.metadataHeader
+			header.MsgLen = uint32(len(payload))
+			in.metadata = &base.RtmpMsg{Header: header, Payload: payload}
+		}
+	}
+	if group.videoSeqHeader != nil {
+		msg := newSwitchConfigMsg(base.RtmpTypeIdVideo, group.videoSeqHeader)
+		in.videoSeqHeader = &msg
+	}
+	if group.aacSeqHeader != nil {
+		msg := newSwitchConfigMsg(base.RtmpTypeIdAudio, group.aacSeqHeader)
+		in.aacSeqHeader = &msg
+	}
+	return in
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// cacheConfigMsg 如果是metadata或seq header，则缓存并返回true
+//
+func (in *switchInput) cacheConfigMsg(msg base.RtmpMsg) bool {
+	var p **base.RtmpMsg
+	switch {
+	case msg.IsMetadata():
+		p = &in.metadata
+	case msg.IsVideoKeySeqHeader():
+		p = &in.videoSeqHeader
+	case msg.IsAacSeqHeader():
+		p = &in.aacSeqHeader
+	default:
+		return false
+	}
+	c := msg.Clone()
+	*p = &c
+	return true
+}
+
+// isSwitchPoint 视频关键帧，纯音频的输入为音频帧
+//
+func (in *switchInput) isSwitchPoint(msg base.RtmpMsg) bool {
+	if in.videoSeqHeader == nil {
+		return msg.Header.MsgTypeId == base.RtmpTypeIdAudio
+	}
+	return msg.IsVideoKeyNalu()
+}
+
+func (in *switchInput) name(group *Group) string {
+	if in == nil {
+		return ""
+	}
+	if in.group == nil {
+		return group.streamName
+	}
+	return in.group.streamName
+}
+
+func newSwitchConfigMsg(typeId uint8, payload []byte) base.RtmpMsg {
+	var msg base.RtmpMsg
+	msg.Header.MsgStreamId = rtmp.Msid1
+	msg.Header.MsgTypeId = typeId
+	if typeId == base.RtmpTypeIdAudio {
+		msg.Header.Csid = rtmp.CsidAudio
+	} else {
+		msg.Header.Csid = rtmp.CsidVideo
+	}
+	msg.Header.MsgLen = uint32(len(payload))
+	msg.Payload = append([]byte(nil), payload...)
+	return msg
+}
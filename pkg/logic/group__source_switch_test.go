@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestGroupSwitchSource(t *testing.T) {
+	var config Config
+	config.HttpflvConfig.Enable = true
+	config.HttpflvConfig.GopNum = 4
+	a := NewGroup("live", "a", &config, nil)
+	b := NewGroup("live", "b", &config, nil)
+
+	newVideoMsg := func(ts uint32, payload ...byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header: base.RtmpHeader{
+				MsgTypeId:    base.RtmpTypeIdVideo,
+				MsgLen:       uint32(len(payload)),
+				TimestampAbs: ts,
+			},
+			Payload: payload,
+		}
+	}
+	seqHeader := func(ts uint32) base.RtmpMsg {
+		return newVideoMsg(ts, 0x17, 0x00, 0, 0, 0, 0x01)
+	}
+	frame := func(ts uint32, key bool, id byte) base.RtmpMsg {
+		if key {
+			return newVideoMsg(ts, 0x17, 0x01, 0, 0, 0, id)
+		}
+		return newVideoMsg(ts, 0x27, 0x01, 0, 0, 0, id)
+	}
+	// 返回a的httpflv gop缓存中最后一个gop的每一帧的时间戳和id
+	lastGop := func() (tss []uint32, ids []byte) {
+		gop := a.httpflvGopCache.GetGopDataAt(a.httpflvGopCache.GetGopCount() - 1)
+		for _, tag := range gop {
+			tss = append(tss, bele.BeUint24(tag[4:])|uint32(tag[7])<<24)
+			ids = append(ids, tag[len(tag)-httpflv.PrevTagSizeFieldSize-1])
+		}
+		return
+	}
+
+	a.OnReadRtmpAvMsg(seqHeader(0))
+	a.OnReadRtmpAvMsg(frame(0, true, 0x01))
+	a.OnReadRtmpAvMsg(frame(40, false, 0x02))
+	b.OnReadRtmpAvMsg(seqHeader(1000))
+	b.OnReadRtmpAvMsg(frame(1000, true, 0x11))
+
+	assert.Equal(t, (*Group)(nil), a.SwitchSource(b))
+	b.AddRtmpTap(a)
+	assert.Equal(t, []*Group{b}, a.SwitchSourceGroups())
+
+	// 输入源的关键帧到来之前，继续转发自身的输入
+	b.OnReadRtmpAvMsg(frame(1040, false, 0x12))
+	a.OnReadRtmpAvMsg(frame(80, false, 0x03))
+	tss, ids := lastGop()
+	assert.Equal(t, []uint32{0, 40, 80}, tss)
+	assert.Equal(t, []byte{0x01, 0x02, 0x03}, ids)
+
+	// 在输入源的关键帧处切换，时间戳连续
+	b.OnReadRtmpAvMsg(frame(1080, true, 0x13))
+	b.OnReadRtmpAvMsg(frame(1120, false, 0x14))
+	a.OnReadRtmpAvMsg(frame(120, false, 0x04))
+	tss, ids = lastGop()
+	assert.Equal(t, []uint32{120, 160}, tss)
+	assert.Equal(t, []byte{0x13, 0x14}, ids)
+
+	// 输入结束时，在自身输入的下一个关键帧处切换回来，在此之前继续转发输入源的数据
+	a.OnSourceEnd(b)
+	b.OnReadRtmpAvMsg(frame(1160, false, 0x15))
+	a.OnReadRtmpAvMsg(frame(160, true, 0x05))
+	a.OnReadRtmpAvMsg(frame(200, false, 0x06))
+	tss, ids = lastGop()
+	assert.Equal(t, []uint32{240, 280}, tss)
+	assert.Equal(t, []byte{0x05, 0x06}, ids)
+	assert.Equal(t, 0, len(a.SwitchSourceGroups()))
+
+	// 不能形成环
+	assert.Equal(t, false, isSwitchSourceLoop(a, b))
+	b.SwitchSource(a)
+	assert.Equal(t, true, isSwitchSourceLoop(a, b))
+}
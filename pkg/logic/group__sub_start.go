@@ -0,0 +1,123 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+const (
+	// SubStartParam 拉流url参数，sub选择从哪里开始播放，覆盖服务端的默认行为（发送所有缓存的GOP）
+	//
+	// 只对有GOP缓存的rtmp、httpflv、httpts sub有效，比如 http://127.0.0.1:8080/live/test110.flv?lal_start=latest
+	//
+	SubStartParam = "lal_start"
+
+	SubStartLatest  = "latest"   // 不发送GOP缓存，等待下一个关键帧，延时最低
+	SubStartLastGop = "last_gop" // 只发送最新的一个GOP
+	SubStartGop     = "gop"      // 发送所有缓存的GOP，从最老的GOP开始，首帧最快。默认值
+)
+
+func parseSubStart(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	return values.Get(SubStartParam)
+}
+
+// subGopStartIndex 新加入的sub，从GOP缓存中的第几个GOP开始发送
+//
+// @param start: 见 SubStartParam
+//
+// @return 取值范围[0, gopCount]，等于gopCount时表示不发送GOP缓存
+//
+func subGopStartIndex(start string, gopCount int) int {
+	switch start {
+	case SubStartLatest:
+		return gopCount
+	case SubStartLastGop:
+		if gopCount > 0 {
+			return gopCount - 1
+		}
+	}
+	return 0
+}
+
+// writeRtmpCache2Sub 给新加入的rtmp sub发送缓存的头信息以及GOP
+//
+func (group *Group) writeRtmpCache2Sub(session *rtmp.ServerSession) {
+	gc := group.rtmpGopCache
+	if gc.Metadata != nil {
+		Log.Debugf("[%s] [%s] write metadata", group.UniqueKey, session.UniqueKey())
+		_ = session.Write(gc.Metadata)
+	}
+	if gc.VideoSeqHeader != nil {
+		Log.Debugf("[%s] [%s] write vsh", group.UniqueKey, session.UniqueKey())
+		_ = session.Write(gc.VideoSeqHeader)
+	}
+	if gc.AacSeqHeader != nil {
+		Log.Debugf("[%s] [%s] write ash", group.UniqueKey, session.UniqueKey())
+		_ = session.Write(gc.AacSeqHeader)
+	}
+
+	start := parseSubStart(session.RawQuery())
+	gopCount := gc.GetGopCount()
+	gopStart := subGopStartIndex(start, gopCount)
+	if gopStart < gopCount {
+		// GOP缓存中肯定包含了关键帧
+		session.ShouldWaitVideoKeyFrame = false
+
+		Log.Debugf("[%s] [%s] write gop cache. gop num=%d", group.UniqueKey, session.UniqueKey(), gopCount-gopStart)
+	} else if start != SubStartLatest && gc.KeyFrame != nil {
+		// 快速起播，见 FastStartConfig ，没有GOP缓存时先发送最近一个关键帧用于显示首帧，然后继续等待下一个关键帧
+		Log.Debugf("[%s] [%s] write key frame", group.UniqueKey, session.UniqueKey())
+		_ = session.Write(gc.KeyFrame)
+	}
+	for i := gopStart; i < gopCount; i++ {
+		for _, item := range gc.GetGopDataAt(i) {
+			_ = session.Write(item)
+		}
+	}
+}
+
+// writeHttpflvCache2Sub 给新加入的httpflv sub发送缓存的头信息以及GOP，逻辑同 writeRtmpCache2Sub
+//
+func (group *Group) writeHttpflvCache2Sub(session *httpflv.SubSession) {
+	gc := group.httpflvGopCache
+	if gc.Metadata != nil {
+		session.Write(gc.Metadata)
+	}
+	if gc.VideoSeqHeader != nil {
+		session.Write(gc.VideoSeqHeader)
+	}
+	if gc.AacSeqHeader != nil {
+		session.Write(gc.AacSeqHeader)
+	}
+	if group.writeHttpflvResume2Sub(session) {
+		return
+	}
+
+	start := parseSubStart(session.RawQuery())
+	gopCount := gc.GetGopCount()
+	gopStart := subGopStartIndex(start, gopCount)
+	if gopStart < gopCount {
+		session.ShouldWaitVideoKeyFrame = false
+	} else if start != SubStartLatest && gc.KeyFrame != nil {
+		session.Write(gc.KeyFrame)
+	}
+	for i := gopStart; i < gopCount; i++ {
+		for _, item := range gc.GetGopDataAt(i) {
+			session.Write(item)
+		}
+	}
+}
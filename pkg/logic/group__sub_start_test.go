@@ -0,0 +1,28 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSubGopStartIndex(t *testing.T) {
+	index := func(rawQuery string, gopCount int) int {
+		return subGopStartIndex(parseSubStart(rawQuery), gopCount)
+	}
+	assert.Equal(t, 0, index("", 3))
+	assert.Equal(t, 0, index("lal_start=gop", 3))
+	assert.Equal(t, 3, index("lal_start=latest", 3))
+	assert.Equal(t, 2, index("token=abc&lal_start=last_gop", 3))
+	assert.Equal(t, 0, index("lal_start=last_gop", 0))
+	assert.Equal(t, 0, index("lal_start=unknown", 3))
+	assert.Equal(t, 0, index("%zz", 3))
+}
@@ -0,0 +1,88 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import "time"
+
+// group__sub_wait_pub.go
+//
+// 开启`sub_wait_pub`后，拉流时如果流还不存在（比如预约的直播还没开始），sub session不会因为长时间没有数据而被关闭，
+// 而是最多等待`sub_wait_pub.timeout_sec`秒。等待期间，定时给rtmp sub session发送ping，避免中间链路因为空闲断开连接
+//
+
+func (group *Group) addSubWaitPubIfNeeded(sessionId string) {
+	if !group.config.SubWaitPubConfig.Enable || group.hasInSession() {
+		return
+	}
+	if group.subWaitPubSince == nil {
+		group.subWaitPubSince = make(map[string]int64)
+	}
+	group.subWaitPubSince[sessionId] = time.Now().Unix()
+}
+
+func (group *Group) delSubWaitPub(sessionId string) {
+	delete(group.subWaitPubSince, sessionId)
+}
+
+// isSubWaitingPub sub session是否在等待推流，并且没有超时
+//
+func (group *Group) isSubWaitingPub(sessionId string) bool {
+	since, ok := group.subWaitPubSince[sessionId]
+	if !ok || group.hasInSession() {
+		return false
+	}
+	return time.Now().Unix()-since < int64(group.config.SubWaitPubConfig.TimeoutSec)
+}
+
+// keepaliveOrDisposeSubWaitPub 定时器调用
+//
+// 推流到达后，不再需要等待；等待超时后，关闭sub session
+//
+func (group *Group) keepaliveOrDisposeSubWaitPub(tickCount uint32, nowUnix int64) {
+	if len(group.subWaitPubSince) == 0 {
+		return
+	}
+	if group.hasInSession() {
+		group.subWaitPubSince = nil
+		return
+	}
+
+	timeout := int64(group.config.SubWaitPubConfig.TimeoutSec)
+	keepalive := tickCount%subWaitPubKeepaliveIntervalSec == 0
+	for session := range group.rtmpSubSessionSet {
+		since, ok := group.subWaitPubSince[session.UniqueKey()]
+		if !ok {
+			continue
+		}
+		if nowUnix-since >= timeout {
+			Log.Warnf("[%s] sub wait pub timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		} else if keepalive {
+			_ = session.WritePingRequest()
+		}
+	}
+	for session := range group.httpflvSubSessionSet {
+		if since, ok := group.subWaitPubSince[session.UniqueKey()]; ok && nowUnix-since >= timeout {
+			Log.Warnf("[%s] sub wait pub timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
+	for session := range group.httptsSubSessionSet {
+		if since, ok := group.subWaitPubSince[session.UniqueKey()]; ok && nowUnix-since >= timeout {
+			Log.Warnf("[%s] sub wait pub timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
+	for session := range group.rtspSubSessionSet {
+		if since, ok := group.subWaitPubSince[session.UniqueKey()]; ok && nowUnix-since >= timeout {
+			Log.Warnf("[%s] sub wait pub timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
+}
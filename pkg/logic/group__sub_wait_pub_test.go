@@ -0,0 +1,37 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGroupSubWaitPub(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, nil)
+
+	// 没有开启时，不等待
+	group.addSubWaitPubIfNeeded("SUB1")
+	assert.Equal(t, false, group.isSubWaitingPub("SUB1"))
+
+	config.SubWaitPubConfig.Enable = true
+	config.SubWaitPubConfig.TimeoutSec = 30
+	group.addSubWaitPubIfNeeded("SUB1")
+	assert.Equal(t, true, group.isSubWaitingPub("SUB1"))
+
+	// 超时
+	group.subWaitPubSince["SUB1"] = time.Now().Unix() - 31
+	assert.Equal(t, false, group.isSubWaitingPub("SUB1"))
+
+	group.delSubWaitPub("SUB1")
+	assert.Equal(t, 0, len(group.subWaitPubSince))
+}
@@ -0,0 +1,165 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+var (
+	avcProfileNames = map[uint8]string{
+		66:  "Baseline",
+		77:  "Main",
+		88:  "Extended",
+		100: "High",
+		110: "High 10",
+		122: "High 4:2:2",
+		244: "High 4:4:4",
+	}
+	hevcProfileNames = map[uint8]string{
+		1: "Main",
+		2: "Main 10",
+		3: "Main Still Picture",
+		4: "Rext",
+	}
+)
+
+// videoStatAnalyser 分析输入流的h264、h265视频，统计gop、B帧以及profile/level，见 base.StatVideo
+//
+type videoStatAnalyser struct {
+	stat base.StatVideo
+
+	prevKeyTs     int64 // -1表示还没有关键帧
+	intervalSum   int64
+	intervalCount int
+	gopHasFrame   bool
+	gopMaxPts     int64
+}
+
+func newVideoStatAnalyser() *videoStatAnalyser {
+	return &videoStatAnalyser{
+		prevKeyTs: -1,
+	}
+}
+
+func (a *videoStatAnalyser) Feed(msg base.RtmpMsg) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) < 5 {
+		return
+	}
+	codecId := msg.Payload[0] & 0xF
+	if codecId != base.RtmpCodecIdAvc && codecId != base.RtmpCodecIdHevc {
+		return
+	}
+
+	switch msg.Payload[1] {
+	case base.RtmpAvcPacketTypeSeqHeader:
+		a.parseSeqHeader(codecId, msg.Payload)
+		return
+	case base.RtmpAvcPacketTypeNalu:
+		// noop
+	default:
+		return
+	}
+
+	dts := int64(msg.Header.TimestampAbs)
+	// composition time为有符号的24位
+	cts := int64(int32(bele.BeUint24(msg.Payload[2:])<<8) >> 8)
+	pts := dts + cts
+
+	a.stat.FrameCount++
+	if msg.Payload[0]>>4 == base.RtmpFrameTypeKey {
+		a.stat.KeyFrameCount++
+		if a.prevKeyTs != -1 && dts > a.prevKeyTs {
+			a.addKeyFrameInterval(dts - a.prevKeyTs)
+		}
+		a.prevKeyTs = dts
+		a.gopHasFrame = false
+	}
+
+	if a.gopHasFrame && pts < a.gopMaxPts {
+		a.stat.BFrameCount++
+		a.stat.HasBFrame = true
+	}
+	if !a.gopHasFrame || pts > a.gopMaxPts {
+		a.gopMaxPts = pts
+	}
+	a.gopHasFrame = true
+}
+
+func (a *videoStatAnalyser) Stat() *base.StatVideo {
+	stat := a.stat
+	return &stat
+}
+
+func (a *videoStatAnalyser) addKeyFrameInterval(interval int64) {
+	a.intervalSum += interval
+	a.intervalCount++
+	a.stat.LastKeyFrameIntervalMs = int(interval)
+	a.stat.AvgKeyFrameIntervalMs = int(a.intervalSum / int64(a.intervalCount))
+	if a.stat.MinKeyFrameIntervalMs == 0 || int(interval) < a.stat.MinKeyFrameIntervalMs {
+		a.stat.MinKeyFrameIntervalMs = int(interval)
+	}
+	if int(interval) > a.stat.MaxKeyFrameIntervalMs {
+		a.stat.MaxKeyFrameIntervalMs = int(interval)
+	}
+}
+
+// parseSeqHeader 直接从AVCDecoderConfigurationRecord、HEVCDecoderConfigurationRecord中读取profile和level
+//
+func (a *videoStatAnalyser) parseSeqHeader(codecId uint8, payload []byte) {
+	var profile, level uint8
+	var names map[uint8]string
+	var levelDiv int
+	if codecId == base.RtmpCodecIdAvc {
+		if len(payload) < 9 {
+			return
+		}
+		profile, level = payload[6], payload[8]
+		names, levelDiv = avcProfileNames, 10
+	} else {
+		if len(payload) < 18 {
+			return
+		}
+		profile, level = payload[6]&0x1F, payload[17]
+		names, levelDiv = hevcProfileNames, 30
+	}
+
+	if name, ok := names[profile]; ok {
+		a.stat.Profile = name
+	} else {
+		a.stat.Profile = fmt.Sprintf("%d", profile)
+	}
+	// h264的level_idc为level*10，h265的general_level_idc为level*30
+	a.stat.Level = fmt.Sprintf("%d.%d", int(level)/levelDiv, int(level)%levelDiv*10/levelDiv)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (group *Group) feedVideoStat(msg base.RtmpMsg) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) == 0 {
+		return
+	}
+	if codecId := msg.Payload[0] & 0xF; codecId != base.RtmpCodecIdAvc && codecId != base.RtmpCodecIdHevc {
+		return
+	}
+	if group.videoStatAnalyser == nil {
+		group.videoStatAnalyser = newVideoStatAnalyser()
+	}
+	group.videoStatAnalyser.Feed(msg)
+}
+
+func (group *Group) videoStat() *base.StatVideo {
+	if group.videoStatAnalyser == nil {
+		return nil
+	}
+	return group.videoStatAnalyser.Stat()
+}
@@ -0,0 +1,73 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestVideoStatAnalyser(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96}
+	pps := []byte{0x68, 0xEB, 0xEC, 0xB2, 0x2C}
+	seqHeader, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	videoMsg := func(key bool, dts uint32, cts uint32) base.RtmpMsg {
+		payload := []byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9A}
+		if key {
+			payload[0] = 0x17
+		}
+		bele.BePutUint24(payload[2:], cts)
+		return base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo, TimestampAbs: dts},
+			Payload: payload,
+		}
+	}
+
+	a := newVideoStatAnalyser()
+	a.Feed(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo}, Payload: seqHeader})
+	// 没有B帧
+	a.Feed(videoMsg(true, 0, 0))
+	a.Feed(videoMsg(false, 40, 0))
+	// I P B B P，解码顺序
+	for _, dts := range []uint32{2000, 4000} {
+		a.Feed(videoMsg(true, dts, 40))
+		a.Feed(videoMsg(false, dts+40, 120))
+		a.Feed(videoMsg(false, dts+80, 0))
+		a.Feed(videoMsg(false, dts+120, 0))
+		a.Feed(videoMsg(false, dts+160, 120))
+	}
+	a.Feed(videoMsg(true, 5000, 40))
+
+	assert.Equal(t, &base.StatVideo{
+		Profile:                "High",
+		Level:                  "3.2",
+		FrameCount:             13,
+		KeyFrameCount:          4,
+		LastKeyFrameIntervalMs: 1000,
+		AvgKeyFrameIntervalMs:  1666,
+		MinKeyFrameIntervalMs:  1000,
+		MaxKeyFrameIntervalMs:  2000,
+		BFrameCount:            4,
+		HasBFrame:              true,
+	}, a.Stat())
+
+	// h265的level
+	a = newVideoStatAnalyser()
+	hevcSeqHeader := make([]byte, 32)
+	hevcSeqHeader[0], hevcSeqHeader[6], hevcSeqHeader[17] = 0x1C, 0x02, 123
+	a.Feed(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo}, Payload: hevcSeqHeader})
+	assert.Equal(t, "Main 10", a.Stat().Profile)
+	assert.Equal(t, "4.1", a.Stat().Level)
+}
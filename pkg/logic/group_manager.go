@@ -8,6 +8,11 @@
 
 package logic
 
+import (
+	"hash/fnv"
+	"sync"
+)
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 type IGroupCreator interface {
@@ -229,3 +234,102 @@ func (gm *ComplexGroupManager) Len() int {
 	}
 	return c + len(gm.onlyStreamNameGroups)
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// ShardedGroupManager 并发安全的 IGroupManager
+//
+// 按streamName的hash将group分散到多个分片中，每个分片使用单独的锁，不同流的创建、查找、删除互不阻塞，
+// 避免大量短连接（比如httpflv拉流）频繁进出时，所有流都竞争同一把锁。
+//
+// 每个分片内部是一个非并发安全的 IGroupManager （ SimpleGroupManager 或 ComplexGroupManager ）。
+// 两者对同一个streamName的查找都只涉及相同streamName的group，所以按streamName分片后结果不变。
+//
+// 注意，分片的锁不可重入，Iterate 、 Do 的回调函数中不能再调用当前对象的方法
+//
+type ShardedGroupManager struct {
+	shards []*groupManagerShard
+}
+
+type groupManagerShard struct {
+	mutex sync.Mutex
+	gm    IGroupManager
+}
+
+// NewShardedGroupManager
+//
+// @param shardNum 分片数量，小于1时使用1
+// @param newShard 创建每个分片内部的 IGroupManager
+//
+func NewShardedGroupManager(shardNum int, newShard func() IGroupManager) *ShardedGroupManager {
+	if shardNum < 1 {
+		shardNum = 1
+	}
+	s := &ShardedGroupManager{
+		shards: make([]*groupManagerShard, shardNum),
+	}
+	for i := range s.shards {
+		s.shards[i] = &groupManagerShard{gm: newShard()}
+	}
+	return s
+}
+
+func (s *ShardedGroupManager) GetOrCreateGroup(appName string, streamName string) (group *Group, createFlag bool) {
+	shard := s.shardOf(streamName)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return shard.gm.GetOrCreateGroup(appName, streamName)
+}
+
+func (s *ShardedGroupManager) GetGroup(appName string, streamName string) *Group {
+	shard := s.shardOf(streamName)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return shard.gm.GetGroup(appName, streamName)
+}
+
+// Iterate 依次持有每个分片的锁遍历，注意，不是所有分片的快照
+//
+func (s *ShardedGroupManager) Iterate(onIterateGroup func(group *Group) bool) {
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
+		shard.gm.Iterate(onIterateGroup)
+		shard.mutex.Unlock()
+	}
+}
+
+func (s *ShardedGroupManager) Len() int {
+	var c int
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
+		c += shard.gm.Len()
+		shard.mutex.Unlock()
+	}
+	return c
+}
+
+// Do 持有streamName所在分片的锁执行`fn`
+//
+// `fn`执行期间，group不会被 Iterate 删除，用于查找（或创建）group后，需要和删除空闲group互斥的操作，比如向group中添加session
+//
+// @param shouldCreate 为true时，group不存在则创建，为false时，group不存在则`fn`的参数group为nil
+//
+func (s *ShardedGroupManager) Do(appName string, streamName string, shouldCreate bool, fn func(group *Group, createFlag bool)) {
+	shard := s.shardOf(streamName)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shouldCreate {
+		fn(shard.gm.GetOrCreateGroup(appName, streamName))
+	} else {
+		fn(shard.gm.GetGroup(appName, streamName), false)
+	}
+}
+
+func (s *ShardedGroupManager) shardOf(streamName string) *groupManagerShard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(streamName))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
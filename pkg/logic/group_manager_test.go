@@ -9,6 +9,9 @@
 package logic
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/q191201771/naza/pkg/assert"
@@ -333,3 +336,83 @@ func TestGroupManager(t *testing.T) {
 	})
 	assert.Equal(t, 0, cgm.Len())
 }
+
+func TestShardedGroupManager(t *testing.T) {
+	gm := NewShardedGroupManager(8, func() IGroupManager {
+		return NewComplexGroupManager(mgc)
+	})
+
+	g1, createFlag := gm.GetOrCreateGroup("app1", "stream1")
+	assert.Equal(t, true, createFlag)
+	// 没有appName时，依然能找到其他appName下同名的流
+	assert.Equal(t, g1, gm.GetGroup("", "stream1"))
+	assert.Equal(t, nil, gm.GetGroup("", "stream2"))
+
+	gm.Do("app1", "stream2", false, func(group *Group, createFlag bool) {
+		assert.Equal(t, nil, group)
+		assert.Equal(t, false, createFlag)
+	})
+	gm.Do("app1", "stream2", true, func(group *Group, createFlag bool) {
+		assert.IsNotNil(t, group)
+		assert.Equal(t, true, createFlag)
+	})
+	assert.Equal(t, 2, gm.Len())
+
+	// 并发创建同一路流，只创建一次。同时遍历删除
+	var createCount int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				gm.Do("app1", fmt.Sprintf("stream-%d", j), true, func(group *Group, createFlag bool) {
+					if createFlag {
+						atomic.AddInt32(&createCount, 1)
+					}
+				})
+				if i == 0 && j%10 == 0 {
+					gm.Iterate(func(group *Group) bool {
+						return group.streamName == "stream1" || group.streamName == "stream2"
+					})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, true, createCount >= 100)
+
+	gm.Iterate(func(group *Group) bool {
+		return group.streamName == "stream1"
+	})
+	assert.Equal(t, 1, gm.Len())
+	assert.Equal(t, g1, gm.GetGroup("app1", "stream1"))
+}
+
+// BenchmarkShardedGroupManager 大量协程并发查找不同的流，对比只有一个分片（相当于全局锁）和多个分片
+//
+func BenchmarkShardedGroupManager(b *testing.B) {
+	for _, shardNum := range []int{1, groupManagerShardNum} {
+		b.Run(fmt.Sprintf("shard%d", shardNum), func(b *testing.B) {
+			gm := NewShardedGroupManager(shardNum, func() IGroupManager {
+				return NewSimpleGroupManager(mgc)
+			})
+			names := make([]string, 1024)
+			for i := range names {
+				names[i] = fmt.Sprintf("stream-%d", i)
+				gm.GetOrCreateGroup("", names[i])
+			}
+			var seq uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddUint32(&seq, 1))
+				for pb.Next() {
+					i++
+					gm.Do("", names[i%len(names)], false, func(group *Group, createFlag bool) {
+						_ = group.HasInSession()
+					})
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// health.go
+//
+// 健康检查，用于k8s等的liveness、readiness探针，见 HealthConfig
+//
+// - liveness  主循环是否在正常运行（比如没有死锁）
+// - readiness 后台定时执行配置的检查项，探针请求直接返回最近一次的结果
+//
+
+const (
+	HealthCheckStartup   = "startup"    // 所有监听是否已经建立
+	HealthCheckListeners = "listeners"  // 所有监听地址是否可以连接
+	HealthCheckDisk      = "disk"       // 录制、hls目录所在磁盘的剩余空间
+	HealthCheckNotify    = "notify"     // http notify的地址是否可以连接
+	HealthCheckClockSkew = "clock_skew" // 和ntp服务器之间的时钟偏差
+)
+
+const (
+	defaultHealthCheckIntervalSec   = 10
+	defaultHealthLivenessTimeoutSec = 10
+	healthDialTimeout               = 2 * time.Second
+	healthNtpTimeout                = 5 * time.Second
+)
+
+type HealthChecker struct {
+	config HealthConfig
+	sm     *ServerManager
+
+	lastBeat int64 // unix纳秒，主循环每秒更新
+	started  int32
+
+	mutex     sync.Mutex
+	readiness base.ApiHealth
+}
+
+func NewHealthChecker(sm *ServerManager, config HealthConfig) *HealthChecker {
+	if config.CheckIntervalSec <= 0 {
+		config.CheckIntervalSec = defaultHealthCheckIntervalSec
+	}
+	if config.LivenessTimeoutSec <= 0 {
+		config.LivenessTimeoutSec = defaultHealthLivenessTimeoutSec
+	}
+	return &HealthChecker{
+		config: config,
+		sm:     sm,
+		readiness: base.ApiHealth{
+			Status: base.HealthStatusFail,
+			Checks: []base.ApiHealthCheck{{Name: HealthCheckStartup, Msg: "not started"}},
+		},
+	}
+}
+
+func (hc *HealthChecker) RunLoop() {
+	t := time.NewTicker(time.Duration(hc.config.CheckIntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		hc.checkReadiness()
+		<-t.C
+	}
+}
+
+// Beat 主循环调用
+func (hc *HealthChecker) Beat(now time.Time) {
+	atomic.StoreInt64(&hc.lastBeat, now.UnixNano())
+}
+
+// SetStarted 所有监听建立后调用
+func (hc *HealthChecker) SetStarted() {
+	atomic.StoreInt32(&hc.started, 1)
+}
+
+func (hc *HealthChecker) Liveness() base.ApiHealth {
+	check := base.ApiHealthCheck{Name: "main_loop", Ok: true}
+	// 主循环还没有开始时（启动中）不认为失败，启动是否完成由readiness判断
+	if last := atomic.LoadInt64(&hc.lastBeat); last != 0 {
+		if d := time.Since(time.Unix(0, last)); d > time.Duration(hc.config.LivenessTimeoutSec)*time.Second {
+			check.Ok = false
+			check.Msg = fmt.Sprintf("main loop stalled for %s", d.Truncate(time.Second))
+		}
+	}
+	return makeApiHealth([]base.ApiHealthCheck{check})
+}
+
+func (hc *HealthChecker) Readiness() base.ApiHealth {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	return hc.readiness
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (hc *HealthChecker) checkReadiness() {
+	var checks []base.ApiHealthCheck
+
+	startup := base.ApiHealthCheck{Name: HealthCheckStartup, Ok: atomic.LoadInt32(&hc.started) == 1}
+	if !startup.Ok {
+		startup.Msg = "not started"
+	}
+	checks = append(checks, startup)
+
+	if hc.config.ListenersCheck {
+		checks = append(checks, checkDial(HealthCheckListeners, hc.listenAddrs()))
+	}
+	if hc.config.MinFreeDiskBytes > 0 {
+		checks = append(checks, hc.checkDisk())
+	}
+	if hc.config.NotifyCheck && hc.sm.config.HttpNotifyConfig.Enable {
+		checks = append(checks, checkDial(HealthCheckNotify, notifyHostPorts(hc.sm.config.HttpNotifyConfig)))
+	}
+	if hc.config.NtpServer != "" {
+		checks = append(checks, hc.checkClockSkew())
+	}
+
+	readiness := makeApiHealth(checks)
+	hc.mutex.Lock()
+	if readiness.Status != hc.readiness.Status {
+		Log.Infof("health readiness %s -> %s. checks=%+v", hc.readiness.Status, readiness.Status, readiness.Checks)
+	}
+	hc.readiness = readiness
+	hc.mutex.Unlock()
+}
+
+// listenAddrs 需要检查的tcp监听地址，`:1935`这种形式的地址使用127.0.0.1连接
+func (hc *HealthChecker) listenAddrs() []string {
+	c := hc.sm.config
+	var addrs []string
+	if c.RtmpConfig.Enable {
+		addrs = append(addrs, c.RtmpConfig.Addr)
+	}
+	if c.RtspConfig.Enable {
+		addrs = append(addrs, c.RtspConfig.Addr)
+	}
+	for _, hsc := range []CommonHttpServerConfig{c.HttpflvConfig.CommonHttpServerConfig, c.HttptsConfig.CommonHttpServerConfig, c.HlsConfig.CommonHttpServerConfig} {
+		if hsc.Enable {
+			addrs = append(addrs, hsc.HttpListenAddr)
+		}
+		if hsc.EnableHttps {
+			addrs = append(addrs, hsc.HttpsListenAddr)
+		}
+	}
+	if c.HttpApiConfig.Enable {
+		addrs = append(addrs, c.HttpApiConfig.Addr)
+	}
+
+	ret := make([]string, 0, len(addrs))
+	exist := make(map[string]struct{})
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+		if _, ok := exist[addr]; ok || addr == "" {
+			continue
+		}
+		exist[addr] = struct{}{}
+		ret = append(ret, addr)
+	}
+	return ret
+}
+
+func (hc *HealthChecker) checkDisk() base.ApiHealthCheck {
+	c := hc.sm.config
+	var dirs []string
+	if c.RecordConfig.EnableFlv {
+		dirs = append(dirs, c.RecordConfig.FlvOutPath)
+	}
+	if c.RecordConfig.EnableMpegts {
+		dirs = append(dirs, c.RecordConfig.MpegtsOutPath)
+	}
+	if c.HlsConfig.Enable && !c.HlsConfig.UseMemoryAsDiskFlag {
+		dirs = append(dirs, c.HlsConfig.OutPath)
+	}
+
+	check := base.ApiHealthCheck{Name: HealthCheckDisk, Ok: true}
+	var msgs []string
+	for _, dir := range dirs {
+		free, err := base.GetDiskFreeBytes(dir)
+		if err != nil {
+			// 不支持的平台，或者目录还没有创建，不认为失败
+			continue
+		}
+		if free < hc.config.MinFreeDiskBytes {
+			check.Ok = false
+			msgs = append(msgs, fmt.Sprintf("%s free %d bytes", dir, free))
+		}
+	}
+	check.Msg = strings.Join(msgs, "; ")
+	return check
+}
+
+func (hc *HealthChecker) checkClockSkew() base.ApiHealthCheck {
+	check := base.ApiHealthCheck{Name: HealthCheckClockSkew, Ok: true}
+	offset, err := base.QueryNtpOffset(hc.config.NtpServer, healthNtpTimeout)
+	if err != nil {
+		// ntp服务器不可用时不认为失败，避免ntp服务器的问题导致所有节点不可用
+		check.Msg = fmt.Sprintf("query ntp failed: %v", err)
+		return check
+	}
+	if offset < 0 {
+		offset = -offset
+	}
+	check.Msg = fmt.Sprintf("offset %s", offset)
+	if hc.config.MaxClockSkewMs > 0 && offset > time.Duration(hc.config.MaxClockSkewMs)*time.Millisecond {
+		check.Ok = false
+	}
+	return check
+}
+
+func checkDial(name string, addrs []string) base.ApiHealthCheck {
+	check := base.ApiHealthCheck{Name: name, Ok: true}
+	var msgs []string
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, healthDialTimeout)
+		if err != nil {
+			check.Ok = false
+			msgs = append(msgs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		_ = conn.Close()
+	}
+	check.Msg = strings.Join(msgs, "; ")
+	return check
+}
+
+// notifyHostPorts 所有http notify地址去重后的host:port
+func notifyHostPorts(c HttpNotifyConfig) []string {
+	exist := make(map[string]struct{})
+	for _, rawUrl := range []string{c.OnServerStart, c.OnServerStop, c.OnHeartbeat, c.OnUpdate, c.OnPubStart, c.OnPubStop, c.OnSubStart, c.OnSubStop,
+		c.OnRtmpConnect, c.OnRelayPullStart, c.OnRelayPullStop, c.OnRelayPushStart, c.OnRelayPushStop,
+		c.OnBitstreamError, c.OnBackupSwitch, c.OnViewerChange, c.OnPubViolation, c.OnSubReject} {
+		if rawUrl == "" {
+			continue
+		}
+		u, err := url.Parse(rawUrl)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		host := u.Host
+		if u.Port() == "" {
+			if u.Scheme == "https" {
+				host = net.JoinHostPort(u.Hostname(), "443")
+			} else {
+				host = net.JoinHostPort(u.Hostname(), "80")
+			}
+		}
+		exist[host] = struct{}{}
+	}
+	ret := make([]string, 0, len(exist))
+	for k := range exist {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func makeApiHealth(checks []base.ApiHealthCheck) base.ApiHealth {
+	ret := base.ApiHealth{Status: base.HealthStatusOk, Checks: checks}
+	for _, c := range checks {
+		if !c.Ok {
+			ret.Status = base.HealthStatusFail
+			break
+		}
+	}
+	return ret
+}
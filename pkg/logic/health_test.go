@@ -0,0 +1,102 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer ln.Close()
+
+	config := &Config{}
+	config.RtmpConfig.Enable = true
+	config.RtmpConfig.Addr = ln.Addr().String()
+	sm := &ServerManager{config: config}
+	hc := NewHealthChecker(sm, HealthConfig{Enable: true, ListenersCheck: true, LivenessTimeoutSec: 1})
+
+	// 启动中
+	assert.Equal(t, base.HealthStatusOk, hc.Liveness().Status)
+	assert.Equal(t, base.HealthStatusFail, hc.Readiness().Status)
+	hc.checkReadiness()
+	assert.Equal(t, base.HealthStatusFail, hc.Readiness().Status)
+
+	hc.SetStarted()
+	hc.checkReadiness()
+	r := hc.Readiness()
+	assert.Equal(t, base.HealthStatusOk, r.Status)
+	assert.Equal(t, 2, len(r.Checks))
+	assert.Equal(t, HealthCheckListeners, r.Checks[1].Name)
+
+	// 监听地址不可用
+	_ = ln.Close()
+	hc.checkReadiness()
+	r = hc.Readiness()
+	assert.Equal(t, base.HealthStatusFail, r.Status)
+	assert.Equal(t, false, r.Checks[1].Ok)
+
+	// 主循环卡住
+	hc.Beat(time.Now())
+	assert.Equal(t, base.HealthStatusOk, hc.Liveness().Status)
+	hc.Beat(time.Now().Add(-2 * time.Second))
+	assert.Equal(t, base.HealthStatusFail, hc.Liveness().Status)
+}
+
+func TestHealthChecker_Addrs(t *testing.T) {
+	config := &Config{}
+	config.RtmpConfig.Enable = true
+	config.RtmpConfig.Addr = ":1935"
+	config.HttpflvConfig.Enable = true
+	config.HttpflvConfig.HttpListenAddr = ":8080"
+	config.HlsConfig.Enable = true
+	config.HlsConfig.HttpListenAddr = ":8080"
+	config.HttpApiConfig.Enable = true
+	config.HttpApiConfig.Addr = "10.0.0.1:8083"
+	hc := NewHealthChecker(&ServerManager{config: config}, HealthConfig{})
+	assert.Equal(t, []string{"127.0.0.1:1935", "127.0.0.1:8080", "10.0.0.1:8083"}, hc.listenAddrs())
+
+	assert.Equal(t, []string{"127.0.0.1:10101", "notify.example.com:443", "notify.example.com:80"}, notifyHostPorts(HttpNotifyConfig{
+		OnPubStart: "http://notify.example.com/on_pub_start",
+		OnPubStop:  "http://notify.example.com/on_pub_stop",
+		OnSubStart: "https://notify.example.com/on_sub_start",
+		OnUpdate:   "http://127.0.0.1:10101/on_update",
+	}))
+}
+
+// 探针不受http api限流的影响
+func TestHealthChecker_NotRateLimited(t *testing.T) {
+	config := &Config{}
+	config.HttpApiConfig.RateLimitConfig.Enable = true
+	config.HttpApiConfig.RateLimitConfig.RatePerSec = 0.001
+	config.HttpApiConfig.RateLimitConfig.Burst = 1
+	sm := &ServerManager{config: config}
+	sm.healthChecker = NewHealthChecker(sm, HealthConfig{Enable: true})
+	handler := NewHttpApiServer("", sm).newHandler()
+
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("/healthz"))
+		assert.Equal(t, http.StatusServiceUnavailable, serve("/readyz"))
+	}
+	assert.Equal(t, http.StatusOK, serve("/api/list"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/api/list"))
+}
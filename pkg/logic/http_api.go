@@ -9,9 +9,18 @@
 package logic
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"expvar"
+	"fmt"
 	"net"
 	"net/http"
+	httppprof "net/http/pprof"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazahttp"
 
@@ -22,37 +31,178 @@ type HttpApiServer struct {
 	addr string
 	sm   *ServerManager
 
-	ln net.Listener
+	ln          net.Listener
+	unixLn      net.Listener
+	audit       *AuditLog
+	rateLimiter *base.IpRateLimiter
+	idempotency *IdempotencyCache
+
+	requestIdHeader string
 }
 
 func NewHttpApiServer(addr string, sm *ServerManager) *HttpApiServer {
-	return &HttpApiServer{
-		addr: addr,
-		sm:   sm,
+	h := &HttpApiServer{
+		addr:            addr,
+		sm:              sm,
+		requestIdHeader: sm.config.TraceConfig.RequestIdHeader,
+	}
+	if h.requestIdHeader == "" {
+		h.requestIdHeader = defaultRequestIdHeader
+	}
+	if sm.config.HttpApiConfig.AuditConfig.Enable {
+		h.audit = NewAuditLog(sm.config.HttpApiConfig.AuditConfig)
+	}
+	if c := sm.config.HttpApiConfig.RateLimitConfig; c.Enable {
+		h.rateLimiter = base.NewIpRateLimiter(c.RatePerSec, c.Burst)
+	}
+	if ttl := sm.config.HttpApiConfig.IdempotencyTtlSec; ttl > 0 {
+		h.idempotency = NewIdempotencyCache(time.Duration(ttl) * time.Second)
 	}
+	return h
 }
 
 func (h *HttpApiServer) Listen() (err error) {
 	if h.ln, err = net.Listen("tcp", h.addr); err != nil {
 		return
 	}
+	if h.sm.config.HttpApiConfig.ProxyProtocolEnable {
+		h.ln = base.NewProxyProtocolListener(h.ln, h.sm.config.ProxyProtocolConfig.trustedNets)
+	}
 	Log.Infof("start httpapi server listen. addr=%s", h.addr)
+
+	if path := h.sm.config.HttpApiConfig.UnixListenAddr; path != "" {
+		if h.unixLn, err = base.ListenUnix(path); err != nil {
+			return
+		}
+		Log.Infof("start httpapi server listen. unix addr=%s", path)
+	}
 	return
 }
 
 func (h *HttpApiServer) RunLoop() error {
+	var srv http.Server
+	srv.Handler = h.newHandler()
+	if h.unixLn != nil {
+		go func() {
+			if err := srv.Serve(h.unixLn); err != nil {
+				Log.Error(err)
+			}
+		}()
+	}
+	return srv.Serve(h.ln)
+}
+
+// newHandler 注册所有接口
+//
+// 注意，`/healthz`、`/readyz`不经过限流。kubelet的探针来自节点ip，和同一节点上的其他调用方（比如调度服务、stat采集）共用令牌桶，
+// 被限流时liveness探针失败会导致正常的pod被重启
+//
+func (h *HttpApiServer) newHandler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/list", h.apiListHandler)
 	mux.HandleFunc("/api/stat/lal_info", h.statLalInfoHandler)
 	mux.HandleFunc("/api/stat/group", h.statGroupHandler)
 	mux.HandleFunc("/api/stat/all_group", h.statAllGroupHandler)
-	mux.HandleFunc("/api/ctrl/start_pull", h.ctrlStartPullHandler)
-	mux.HandleFunc("/api/ctrl/kick_out_session", h.ctrlKickOutSessionHandler)
+	mux.HandleFunc("/api/stat/record_retention", h.statRecordRetentionHandler)
+	mux.HandleFunc("/api/stat/sessions_by_ip", h.statSessionsByIpHandler)
+	mux.HandleFunc("/api/stat/audit", h.statAuditHandler)
+	mux.HandleFunc("/api/stat/rate_limit", h.statRateLimitHandler)
+	mux.HandleFunc("/api/stat/conn_guard", h.statConnGuardHandler)
+	mux.HandleFunc("/api/stat/history", h.statHistoryHandler)
+	mux.HandleFunc("/api/stat/stream_events", h.statStreamEventsHandler)
+	mux.HandleFunc("/api/stat/record_flv_keyframes", h.statRecordFlvKeyframesHandler)
+	h.handleIdempotentCtrl(mux, "/api/ctrl/start_pull", h.ctrlStartPullHandler)
+	h.handleCtrl(mux, "/api/ctrl/stop_pull", h.ctrlStopPullHandler)
+	h.handleIdempotentCtrl(mux, "/api/ctrl/kick_out_session", h.ctrlKickOutSessionHandler)
+	h.handleCtrl(mux, "/api/ctrl/tag_session", h.ctrlTagSessionHandler)
+	h.handleIdempotentCtrl(mux, "/api/ctrl/kick_by_ip", h.ctrlKickByIpHandler)
+	h.handleCtrl(mux, "/api/ctrl/set_subtitle", h.ctrlSetSubtitleHandler)
+	h.handleCtrl(mux, "/api/ctrl/set_metadata", h.ctrlSetMetadataHandler)
+	h.handleCtrl(mux, "/api/ctrl/inject_data", h.ctrlInjectDataHandler)
+	h.handleIdempotentCtrl(mux, "/api/ctrl/batch_start_pull", h.ctrlBatchStartPullHandler)
+	h.handleIdempotentCtrl(mux, "/api/ctrl/batch_kick_out_session", h.ctrlBatchKickOutSessionHandler)
+	h.handleCtrl(mux, "/api/ctrl/start_file_publish", h.ctrlStartFilePublishHandler)
+	h.handleCtrl(mux, "/api/ctrl/stop_file_publish", h.ctrlStopFilePublishHandler)
+	h.handleCtrl(mux, "/api/ctrl/switch_source", h.ctrlSwitchSourceHandler)
+	h.handleCtrl(mux, "/api/ctrl/rotate_stream_key", h.ctrlRotateStreamKeyHandler)
+	h.handleCtrl(mux, "/api/ctrl/create_publish_token", h.ctrlCreatePublishTokenHandler)
 
-	var srv http.Server
-	srv.Handler = mux
-	return srv.Serve(h.ln)
+	if h.sm.config.HttpApiConfig.DebugConfig.Enable {
+		// 显式注册pprof和expvar，不挂载http.DefaultServeMux，避免其他包注册在上面的handler绕过鉴权
+		mux.Handle("/debug/pprof/", h.withDebugAuth(http.HandlerFunc(httppprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", h.withDebugAuth(http.HandlerFunc(httppprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", h.withDebugAuth(http.HandlerFunc(httppprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", h.withDebugAuth(http.HandlerFunc(httppprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", h.withDebugAuth(http.HandlerFunc(httppprof.Trace)))
+		mux.Handle("/debug/vars", h.withDebugAuth(expvar.Handler()))
+		mux.Handle("/api/debug/dump", h.withDebugAuth(http.HandlerFunc(h.debugDumpHandler)))
+		mux.Handle("/api/debug/group/", h.withDebugAuth(http.HandlerFunc(h.debugGroupHandler)))
+	}
+
+	var handler http.Handler = mux
+	if h.rateLimiter != nil {
+		handler = h.rateLimiter.Wrap(handler)
+	}
+	if h.sm.healthChecker != nil {
+		root := http.NewServeMux()
+		root.HandleFunc("/healthz", h.healthzHandler)
+		root.HandleFunc("/readyz", h.readyzHandler)
+		root.Handle("/", handler)
+		handler = root
+	}
+	return h.withHttpHeader(handler)
+}
+
+// handleCtrl 注册ctrl类型的接口，如果开启了审计日志，则记录每次调用。所有调用都带有request id，见 withRequestId
+func (h *HttpApiServer) handleCtrl(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	if h.audit != nil {
+		handler = h.audit.Wrap(pattern, handler)
+	}
+	mux.HandleFunc(pattern, withRequestId(h.requestIdHeader, handler))
+}
+
+// handleIdempotentCtrl 注册支持幂等key的ctrl类型接口，重复的请求也会记录审计日志
+func (h *HttpApiServer) handleIdempotentCtrl(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	if h.idempotency != nil {
+		handler = h.idempotency.Wrap(pattern, handler)
+	}
+	h.handleCtrl(mux, pattern, handler)
+}
+
+// withDebugAuth 调试接口的鉴权，token通过`Authorization: Bearer <token>` header或者url参数`token`携带
+//
+// 没有配置token时，拒绝所有请求
+//
+func (h *HttpApiServer) withDebugAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		expected := h.sm.config.HttpApiConfig.DebugConfig.AuthToken
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = req.URL.Query().Get("token")
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			Log.Warnf("http api debug auth failed. remote=%s, path=%s", req.RemoteAddr, req.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 设置配置中的CORS等header，并处理浏览器跨域的预检请求
+func (h *HttpApiServer) withHttpHeader(next http.Handler) http.Handler {
+	option := h.sm.config.HttpApiConfig.HttpHeader
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		option.SetTo(w.Header(), req.Header.Get("Origin"))
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
 }
 
 // TODO chef: dispose
@@ -99,6 +249,173 @@ func (h *HttpApiServer) statGroupHandler(w http.ResponseWriter, req *http.Reques
 	return
 }
 
+func (h *HttpApiServer) statRecordFlvKeyframesHandler(w http.ResponseWriter, req *http.Request) {
+	filename := req.URL.Query().Get("filename")
+	if filename == "" {
+		var v base.ApiStatRecordFlvKeyframes
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	feedback(h.sm.StatRecordFlvKeyframes(filename, base.ParseHttpRequest(req), req.URL.RawQuery, req.RemoteAddr), w)
+	return
+}
+
+func (h *HttpApiServer) statRecordRetentionHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatRecordRetention
+	var ok bool
+	v.Data, ok = h.sm.StatRecordRetention()
+	if !ok {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statHistoryHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatHistory
+	var ok bool
+	v.Data.Streams, ok = h.sm.StatHistory(req.URL.Query().Get("stream_name"))
+	if !ok {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statStreamEventsHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatStreamEvents
+	q := req.URL.Query()
+	v.Data.StreamName = q.Get("stream_name")
+	if v.Data.StreamName == "" {
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	var ok bool
+	v.Data.Events, ok = h.sm.StatStreamEvents(v.Data.StreamName, limit)
+	if !ok {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+// debugDumpHandler 导出所有group的状态，以及所有goroutine的调用栈
+func (h *HttpApiServer) debugDumpHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	groups := h.sm.StatAllGroup()
+	_, _ = fmt.Fprintf(w, "goroutine num: %d\ngroup num: %d\n\n", runtime.NumGoroutine(), len(groups))
+	for _, g := range groups {
+		b, _ := json.Marshal(g)
+		_, _ = fmt.Fprintf(w, "%s\n", b)
+	}
+	_, _ = fmt.Fprintf(w, "\n")
+	_ = pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// debugGroupHandler /api/debug/group/{stream_name}
+func (h *HttpApiServer) debugGroupHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiDebugGroup
+
+	streamName := strings.TrimPrefix(req.URL.Path, "/api/debug/group/")
+	if streamName == "" {
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	v.Data = h.sm.DebugGroup(streamName)
+	if v.Data == nil {
+		v.ErrorCode = base.ErrorCodeGroupNotFound
+		v.Desp = base.DespGroupNotFound
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statSessionsByIpHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatSessionsByIp
+
+	ip := req.URL.Query().Get("ip")
+	if ip == "" {
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	v.Data.Sessions = h.sm.StatSessionsByIp(ip)
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statAuditHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatAudit
+	if h.audit == nil {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+
+	q := req.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	v.Data.Entries = h.audit.Query(q.Get("actor"), limit)
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statRateLimitHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatRateLimit
+	if h.rateLimiter == nil {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.Data = h.rateLimiter.GetStat()
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
+func (h *HttpApiServer) statConnGuardHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiStatConnGuard
+	var ok bool
+	v.Data, ok = h.sm.StatConnGuard()
+	if !ok {
+		v.ErrorCode = base.ErrorCodeNotEnabled
+		v.Desp = base.DespNotEnabled
+		feedback(v, w)
+		return
+	}
+	v.ErrorCode = base.ErrorCodeSucc
+	v.Desp = base.DespSucc
+	feedback(v, w)
+}
+
 func (h *HttpApiServer) ctrlStartPullHandler(w http.ResponseWriter, req *http.Request) {
 	var v base.HttpResponseBasic
 	var info base.ApiCtrlStartPullReq
@@ -111,12 +428,32 @@ func (h *HttpApiServer) ctrlStartPullHandler(w http.ResponseWriter, req *http.Re
 		feedback(v, w)
 		return
 	}
+	if info.RequestId == "" {
+		info.RequestId = requestIdFromContext(req.Context())
+	}
 	Log.Infof("http api start pull. req info=%+v", info)
 
-	h.sm.CtrlStartPull(info)
-	v.ErrorCode = base.ErrorCodeSucc
-	v.Desp = base.DespSucc
-	feedback(v, w)
+	resp := h.sm.CtrlStartPull(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlStopPullHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlStopPull
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api stop pull error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api stop pull. req info=%+v", info)
+
+	resp := h.sm.CtrlStopPull(info)
+	feedback(resp, w)
 	return
 }
 
@@ -139,6 +476,240 @@ func (h *HttpApiServer) ctrlKickOutSessionHandler(w http.ResponseWriter, req *ht
 	return
 }
 
+func (h *HttpApiServer) ctrlKickByIpHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlKickByIp
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "ip")
+	if err != nil {
+		Log.Warnf("http api kick by ip error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api kick by ip. req info=%+v", info)
+
+	resp := h.sm.CtrlKickByIp(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlBatchStartPullHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlBatchStartPullReq
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "items")
+	if err != nil {
+		Log.Warnf("http api batch start pull error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	for i := range info.Items {
+		if info.Items[i].RequestId == "" {
+			info.Items[i].RequestId = requestIdFromContext(req.Context())
+		}
+	}
+	Log.Infof("http api batch start pull. req info=%+v", info)
+
+	resp := h.sm.CtrlBatchStartPull(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlBatchKickOutSessionHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlBatchKickOutSessionReq
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "items")
+	if err != nil {
+		Log.Warnf("http api batch kick out session error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api batch kick out session. req info=%+v", info)
+
+	resp := h.sm.CtrlBatchKickOutSession(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlTagSessionHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlTagSession
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name", "session_id", "tags")
+	if err != nil {
+		Log.Warnf("http api tag session error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api tag session. req info=%+v", info)
+
+	resp := h.sm.CtrlTagSession(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlSetSubtitleHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlSetSubtitle
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api set subtitle error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api set subtitle. req info=%+v", info)
+
+	resp := h.sm.CtrlSetSubtitle(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlSetMetadataHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlSetMetadata
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api set metadata error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api set metadata. req info=%+v", info)
+
+	resp := h.sm.CtrlSetMetadata(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlInjectDataHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlInjectData
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name", "name")
+	if err != nil {
+		Log.Warnf("http api inject data error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api inject data. req info=%+v", info)
+
+	resp := h.sm.CtrlInjectData(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlStartFilePublishHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartFilePublishResp
+	var info base.ApiCtrlStartFilePublishReq
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name", "filename")
+	if err != nil {
+		Log.Warnf("http api start file publish error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api start file publish. req info=%+v", info)
+
+	resp := h.sm.CtrlStartFilePublish(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlStopFilePublishHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlStopFilePublish
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api stop file publish error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api stop file publish. req info=%+v", info)
+
+	resp := h.sm.CtrlStopFilePublish(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlSwitchSourceHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlSwitchSource
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api switch source error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api switch source. req info=%+v", info)
+
+	resp := h.sm.CtrlSwitchSource(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlRotateStreamKeyHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlRotateStreamKeyReq
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api rotate stream key error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	// 不打印密钥
+	Log.Infof("http api rotate stream key. stream=%s, kick out pub=%v", info.StreamName, info.KickOutPub)
+
+	resp := h.sm.CtrlRotateStreamKey(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlCreatePublishTokenHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlCreatePublishTokenReq
+
+	err := nazahttp.UnmarshalRequestJsonBody(req, &info, "stream_name")
+	if err != nil {
+		Log.Warnf("http api create publish token error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+	Log.Infof("http api create publish token. req info=%+v", info)
+
+	resp := h.sm.CtrlCreatePublishToken(info)
+	feedback(resp, w)
+	return
+}
+
 func (h *HttpApiServer) apiListHandler(w http.ResponseWriter, req *http.Request) {
 	// TODO chef: 写完api list页面
 	b := []byte(`
@@ -153,7 +724,14 @@ func (h *HttpApiServer) apiListHandler(w http.ResponseWriter, req *http.Request)
 	<li><a href="/api/stat/group?stream_name=test110">/api/stat/group?stream_name=test110</a></li>
 	<li><a href="/api/stat/all_group">/api/stat/all_group</a></li>
 	<li><a href="/api/stat/lal_info">/api/stat/lal_info</a></li>
+	<li><a href="/api/stat/record_retention">/api/stat/record_retention</a></li>
+	<li><a href="/api/stat/sessions_by_ip?ip=127.0.0.1">/api/stat/sessions_by_ip?ip=127.0.0.1</a></li>
+	<li><a href="/api/stat/audit?limit=100">/api/stat/audit?limit=100</a></li>
+	<li><a href="/api/stat/rate_limit">/api/stat/rate_limit</a></li>
+	<li><a href="/api/stat/conn_guard">/api/stat/conn_guard</a></li>
+	<li><a href="/api/stat/stream_events?stream_name=test110&limit=100">/api/stat/stream_events?stream_name=test110&limit=100</a></li>
 	<li><a href="/api/ctrl/start_pull?protocol=rtmp&addr=127.0.0.1:1935&app_name=live&stream_name=test110&url_param=token=aaa">/api/ctrl/start_pull?protocol=rtmp&addr=127.0.0.1:1935&app_name=live&stream_name=test110&url_param=token=aaa</a></li>
+	<li><a href="/api/ctrl/stop_pull?app_name=live&stream_name=test110">/api/ctrl/stop_pull?app_name=live&stream_name=test110</a></li>
 </ul>
 <br>
 <p>其他链接：</p>
@@ -171,6 +749,25 @@ func (h *HttpApiServer) apiListHandler(w http.ResponseWriter, req *http.Request)
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+func (h *HttpApiServer) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	feedbackHealth(h.sm.healthChecker.Liveness(), w)
+}
+
+func (h *HttpApiServer) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	feedbackHealth(h.sm.healthChecker.Readiness(), w)
+}
+
+func feedbackHealth(v base.ApiHealth, w http.ResponseWriter) {
+	resp, _ := json.Marshal(v)
+	w.Header().Add("Server", base.LalHttpApiServer)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if v.Status != base.HealthStatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(resp)
+}
+
 func feedback(v interface{}, w http.ResponseWriter) {
 	resp, _ := json.Marshal(v)
 	w.Header().Add("Server", base.LalHttpApiServer)
@@ -9,42 +9,32 @@
 package logic
 
 import (
-	"net/http"
-	"time"
-
 	"github.com/q191201771/lal/pkg/base"
-	"github.com/q191201771/naza/pkg/nazahttp"
-)
-
-// TODO(chef): refactor 配置参数供外部传入
-// TODO(chef): refactor maxTaskLen修改为能表示是阻塞任务的意思
-var (
-	maxTaskLen       = 1024
-	notifyTimeoutSec = 3
 )
 
-type PostTask struct {
-	url  string
-	info interface{}
-}
-
 type HttpNotify struct {
-	cfg       HttpNotifyConfig
-	taskQueue chan PostTask
-	client    *http.Client
+	cfg      HttpNotifyConfig
+	outbound *OutboundPool
 }
 
+// NewHttpNotify 使用默认配置创建自己的协程池发送请求，需要和其他模块共用协程池时使用 NewHttpNotifyWithOutbound
+//
 func NewHttpNotify(cfg HttpNotifyConfig) *HttpNotify {
-	httpNotify := &HttpNotify{
-		cfg:       cfg,
-		taskQueue: make(chan PostTask, maxTaskLen),
-		client: &http.Client{
-			Timeout: time.Duration(notifyTimeoutSec) * time.Second,
-		},
-	}
-	go httpNotify.RunLoop()
+	return NewHttpNotifyWithOutbound(cfg, nil)
+}
 
-	return httpNotify
+// NewHttpNotifyWithOutbound
+//
+// @param outbound 发送请求使用的协程池，可以和其他模块共用。为nil时使用默认配置创建
+//
+func NewHttpNotifyWithOutbound(cfg HttpNotifyConfig, outbound *OutboundPool) *HttpNotify {
+	if outbound == nil {
+		outbound = NewOutboundPool(OutboundConfig{})
+	}
+	return &HttpNotify{
+		cfg:      cfg,
+		outbound: outbound,
+	}
 }
 
 // TODO(chef): Dispose
@@ -55,6 +45,21 @@ func (h *HttpNotify) NotifyServerStart(info base.LalInfo) {
 	h.asyncPost(h.cfg.OnServerStart, info)
 }
 
+// NotifyServerStop 注意，同步发送，使得进程退出前事件已经发出
+//
+func (h *HttpNotify) NotifyServerStop(info base.ServerStopInfo) {
+	if !h.cfg.Enable || h.cfg.OnServerStop == "" {
+		return
+	}
+	if _, err := h.outbound.PostSync(OutboundRequest{Url: h.cfg.OnServerStop, Info: info, ProxyUrl: h.cfg.ProxyUrl}); err != nil {
+		Log.Errorf("http notify post error. url=%s, err=%+v", h.cfg.OnServerStop, err)
+	}
+}
+
+func (h *HttpNotify) NotifyHeartbeat(info base.ServerHeartbeatInfo) {
+	h.asyncPost(h.cfg.OnHeartbeat, info)
+}
+
 func (h *HttpNotify) NotifyUpdate(info base.UpdateInfo) {
 	h.asyncPost(h.cfg.OnUpdate, info)
 }
@@ -79,12 +84,56 @@ func (h *HttpNotify) NotifyRtmpConnect(info base.RtmpConnectInfo) {
 	h.asyncPost(h.cfg.OnRtmpConnect, info)
 }
 
+func (h *HttpNotify) NotifyRelayPullStart(info base.RelayPullStartInfo) {
+	h.asyncPost(h.cfg.OnRelayPullStart, info)
+}
+
+func (h *HttpNotify) NotifyRelayPullStop(info base.RelayPullStopInfo) {
+	h.asyncPost(h.cfg.OnRelayPullStop, info)
+}
+
+func (h *HttpNotify) NotifyRelayPushStart(info base.RelayPushStartInfo) {
+	h.asyncPost(h.cfg.OnRelayPushStart, info)
+}
+
+func (h *HttpNotify) NotifyRelayPushStop(info base.RelayPushStopInfo) {
+	h.asyncPost(h.cfg.OnRelayPushStop, info)
+}
+
+func (h *HttpNotify) NotifyBitstreamError(info base.BitstreamErrorInfo) {
+	h.asyncPost(h.cfg.OnBitstreamError, info)
+}
+
+func (h *HttpNotify) NotifyBackupSwitch(info base.BackupSwitchInfo) {
+	h.asyncPost(h.cfg.OnBackupSwitch, info)
+}
+
+func (h *HttpNotify) NotifyViewerChange(info base.ViewerChangeInfo) {
+	h.asyncPost(h.cfg.OnViewerChange, info)
+}
+
+func (h *HttpNotify) NotifyPubViolation(info base.PubViolationInfo) {
+	h.asyncPost(h.cfg.OnPubViolation, info)
+}
+
+func (h *HttpNotify) NotifySubReject(info base.SubRejectInfo) {
+	h.asyncPost(h.cfg.OnSubReject, info)
+}
+
 // ----- implement INotifyHandler interface ----------------------------------------------------------------------------
 
 func (h *HttpNotify) OnServerStart(info base.LalInfo) {
 	h.NotifyServerStart(info)
 }
 
+func (h *HttpNotify) OnServerStop(info base.ServerStopInfo) {
+	h.NotifyServerStop(info)
+}
+
+func (h *HttpNotify) OnHeartbeat(info base.ServerHeartbeatInfo) {
+	h.NotifyHeartbeat(info)
+}
+
 func (h *HttpNotify) OnUpdate(info base.UpdateInfo) {
 	h.NotifyUpdate(info)
 }
@@ -109,15 +158,40 @@ func (h *HttpNotify) OnRtmpConnect(info base.RtmpConnectInfo) {
 	h.NotifyRtmpConnect(info)
 }
 
-// ---------------------------------------------------------------------------------------------------------------------
+func (h *HttpNotify) OnRelayPullStart(info base.RelayPullStartInfo) {
+	h.NotifyRelayPullStart(info)
+}
 
-func (h *HttpNotify) RunLoop() {
-	for {
-		select {
-		case t := <-h.taskQueue:
-			h.post(t.url, t.info)
-		}
-	}
+func (h *HttpNotify) OnRelayPullStop(info base.RelayPullStopInfo) {
+	h.NotifyRelayPullStop(info)
+}
+
+func (h *HttpNotify) OnRelayPushStart(info base.RelayPushStartInfo) {
+	h.NotifyRelayPushStart(info)
+}
+
+func (h *HttpNotify) OnRelayPushStop(info base.RelayPushStopInfo) {
+	h.NotifyRelayPushStop(info)
+}
+
+func (h *HttpNotify) OnBitstreamError(info base.BitstreamErrorInfo) {
+	h.NotifyBitstreamError(info)
+}
+
+func (h *HttpNotify) OnBackupSwitch(info base.BackupSwitchInfo) {
+	h.NotifyBackupSwitch(info)
+}
+
+func (h *HttpNotify) OnViewerChange(info base.ViewerChangeInfo) {
+	h.NotifyViewerChange(info)
+}
+
+func (h *HttpNotify) OnPubViolation(info base.PubViolationInfo) {
+	h.NotifyPubViolation(info)
+}
+
+func (h *HttpNotify) OnSubReject(info base.SubRejectInfo) {
+	h.NotifySubReject(info)
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -127,16 +201,8 @@ func (h *HttpNotify) asyncPost(url string, info interface{}) {
 		return
 	}
 
-	select {
-	case h.taskQueue <- PostTask{url: url, info: info}:
-		// noop
-	default:
-		Log.Error("http notify queue full.")
-	}
-}
-
-func (h *HttpNotify) post(url string, info interface{}) {
-	if _, err := nazahttp.PostJson(url, info, h.client); err != nil {
-		Log.Errorf("http notify post error. err=%+v", err)
+	// 配置了出口代理，则notify的http请求通过代理发送
+	if err := h.outbound.Post(OutboundRequest{Url: url, Info: info, ProxyUrl: h.cfg.ProxyUrl}); err != nil {
+		Log.Errorf("http notify post error. url=%s, err=%+v", url, err)
 	}
 }
@@ -9,6 +9,8 @@
 package logic
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -33,11 +35,29 @@ type IHttpServerHandlerObserver interface {
 
 type HttpServerHandler struct {
 	observer IHttpServerHandlerObserver
+	option   HttpServerHandlerOption
 }
 
-func NewHttpServerHandler(observer IHttpServerHandlerObserver) *HttpServerHandler {
+type HttpServerHandlerOption struct {
+	HttpflvHeader base.HttpHeaderOption // httpflv响应中CORS、Cache-Control以及自定义的header
+	HttptsHeader  base.HttpHeaderOption // httpts响应中CORS、Cache-Control以及自定义的header
+}
+
+var defaultHttpServerHandlerOption = HttpServerHandlerOption{
+	HttpflvHeader: base.DefaultHttpHeaderOption,
+	HttptsHeader:  base.DefaultHttpHeaderOption,
+}
+
+type ModHttpServerHandlerOption func(option *HttpServerHandlerOption)
+
+func NewHttpServerHandler(observer IHttpServerHandlerObserver, modOptions ...ModHttpServerHandlerOption) *HttpServerHandler {
+	option := defaultHttpServerHandlerOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
 	return &HttpServerHandler{
 		observer: observer,
+		option:   option,
 	}
 }
 
@@ -68,9 +88,11 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 
 	if strings.HasSuffix(urlCtx.LastItemOfPath, ".flv") {
 		session := httpflv.NewSubSession(conn, urlCtx, isWebSocket, webSocketKey)
+		session.SetHttpHeaderOption(h.option.HttpflvHeader, req.Header.Get("Origin"))
 		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
 		if err = h.observer.OnNewHttpflvSubSession(session); err != nil {
 			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
+			writeRejectResponse(conn, err)
 			_ = session.Dispose()
 			return
 		}
@@ -82,9 +104,11 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 
 	if strings.HasSuffix(urlCtx.LastItemOfPath, ".ts") {
 		session := httpts.NewSubSession(conn, urlCtx, isWebSocket, webSocketKey)
+		session.SetHttpHeaderOption(h.option.HttptsHeader, req.Header.Get("Origin"))
 		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
 		if err = h.observer.OnNewHttptsSubSession(session); err != nil {
 			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
+			writeRejectResponse(conn, err)
 			_ = session.Dispose()
 			return
 		}
@@ -94,3 +118,12 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 		return
 	}
 }
+
+// writeRejectResponse 出口带宽不足被拒绝时回复503，客户端或者调度服务可以据此换一个节点重试。其他原因直接关闭连接
+//
+func writeRejectResponse(conn net.Conn, err error) {
+	if !errors.Is(err, base.ErrAdmissionRejected) {
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+}
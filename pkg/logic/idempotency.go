@@ -0,0 +1,160 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// IdempotencyCache 用于http api ctrl类型接口的幂等
+//
+// 调用方通过`Idempotency-Key` header或者请求json中的`idempotency_key`字段携带幂等key，
+// 在`http_api.idempotency_ttl_sec`时间内，同一个接口相同key的请求只会执行一次，之后的请求直接返回第一次执行的结果，
+// 并在响应中携带`Idempotent-Replayed: true` header。相同key的请求并发到达时，后到的请求等待第一个请求执行完成
+//
+// 没有携带key的请求不受影响
+//
+// 第一个请求没有正常完成时（比如handler panic），删除该key，等待中的请求重新竞争执行
+//
+type IdempotencyCache struct {
+	ttl time.Duration
+
+	mutex     sync.Mutex
+	items     map[string]*idempotencyItem // key: api + 幂等key
+	lastSweep time.Time
+}
+
+type idempotencyItem struct {
+	done    chan struct{} // 第一次请求执行结束后关闭，关闭之前写入以下字段
+	expire  time.Time
+	aborted bool // 第一次请求没有正常完成
+	header  http.Header
+	body    []byte
+}
+
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:   ttl,
+		items: make(map[string]*idempotencyItem),
+	}
+}
+
+// Wrap 包装ctrl类型接口的handler
+//
+func (c *IdempotencyCache) Wrap(api string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := idempotencyKeyOf(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+
+		ck := api + "|" + key
+		for {
+			item, first := c.acquire(ck, time.Now())
+			if first {
+				c.do(ck, item, w, req, next)
+				return
+			}
+
+			<-item.done
+			if item.aborted {
+				continue
+			}
+			for k, v := range item.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(IdempotencyReplayedHeader, "true")
+			_, _ = w.Write(item.body)
+			return
+		}
+	}
+}
+
+// do 执行第一次请求，不管`next`是否正常返回（比如panic），都会关闭item.done，避免等待中的请求永远阻塞
+//
+func (c *IdempotencyCache) do(k string, item *idempotencyItem, w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	aw := &auditResponseWriter{ResponseWriter: w}
+	completed := false
+	defer func() {
+		if completed {
+			item.header = w.Header().Clone()
+			item.body = aw.body.Bytes()
+		} else {
+			item.aborted = true
+			c.mutex.Lock()
+			if c.items[k] == item {
+				delete(c.items, k)
+			}
+			c.mutex.Unlock()
+		}
+		close(item.done)
+	}()
+
+	next(aw, req)
+	completed = true
+}
+
+// acquire
+//
+// @return first: true表示是第一次请求，调用方需要执行请求，并在完成后关闭item.done
+//
+func (c *IdempotencyCache) acquire(k string, now time.Time) (item *idempotencyItem, first bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// 每隔ttl清理一次过期的key，而不是每次都遍历
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for ik, it := range c.items {
+			if now.After(it.expire) {
+				delete(c.items, ik)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if item, ok := c.items[k]; ok && !now.After(item.expire) {
+		return item, false
+	}
+	item = &idempotencyItem{
+		done:   make(chan struct{}),
+		expire: now.Add(c.ttl),
+	}
+	c.items[k] = item
+	return item, true
+}
+
+// idempotencyKeyOf 优先使用header中的key，没有的话从请求json中获取，注意，读取后会重新设置req.Body
+func idempotencyKeyOf(req *http.Request) string {
+	if key := req.Header.Get(IdempotencyKeyHeader); key != "" {
+		return key
+	}
+	if req.Body == nil {
+		return ""
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var v struct {
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	_ = json.Unmarshal(body, &v)
+	return v.IdempotencyKey
+}
@@ -0,0 +1,134 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	var mutex sync.Mutex
+	count := 0
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		count++
+		n := count
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"error_code":0,"desp":"%d"}`, n)))
+	}
+	c := NewIdempotencyCache(time.Minute)
+	wrapped := c.Wrap("/api/ctrl/start_pull", handler)
+
+	call := func(header string, body string) (string, bool) {
+		req := httptest.NewRequest("POST", "/api/ctrl/start_pull", strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(IdempotencyKeyHeader, header)
+		}
+		w := httptest.NewRecorder()
+		wrapped(w, req)
+		b, _ := ioutil.ReadAll(w.Result().Body)
+		return string(b), w.Result().Header.Get(IdempotencyReplayedHeader) == "true"
+	}
+
+	// 没有key，每次都执行
+	call("", `{}`)
+	call("", `{}`)
+	assert.Equal(t, 2, count)
+
+	// 并发的相同key只执行一次
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = call("k1", `{}`)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 3, count)
+	for _, r := range results {
+		assert.Equal(t, `{"error_code":0,"desp":"3"}`, r)
+	}
+
+	// body中携带key
+	body, replayed := call("", `{"stream_name":"test110","idempotency_key":"k2"}`)
+	assert.Equal(t, false, replayed)
+	assert.Equal(t, `{"error_code":0,"desp":"4"}`, body)
+	body, replayed = call("", `{"stream_name":"test110","idempotency_key":"k2"}`)
+	assert.Equal(t, true, replayed)
+	assert.Equal(t, `{"error_code":0,"desp":"4"}`, body)
+	assert.Equal(t, 4, count)
+
+	// 过期
+	_, first := c.acquire("/api/ctrl/start_pull|k2", time.Now().Add(2*time.Minute))
+	assert.Equal(t, true, first)
+}
+
+func TestIdempotencyCache_Panic(t *testing.T) {
+	var mutex sync.Mutex
+	count := 0
+	started := make(chan struct{})
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		count++
+		n := count
+		mutex.Unlock()
+		if n == 1 {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("test")
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"error_code":0,"desp":"%d"}`, n)))
+	}
+	c := NewIdempotencyCache(time.Minute)
+	wrapped := c.Wrap("/api/ctrl/start_pull", handler)
+
+	call := func() string {
+		req := httptest.NewRequest("POST", "/api/ctrl/start_pull", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		wrapped(w, req)
+		b, _ := ioutil.ReadAll(w.Result().Body)
+		return string(b)
+	}
+
+	go func() {
+		// 和net/http一样，recover handler的panic
+		defer func() {
+			_ = recover()
+		}()
+		call()
+	}()
+	<-started
+
+	// 第一个请求panic之后，等待中的请求重新执行，而不是永远阻塞
+	done := make(chan string, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case r := <-done:
+		assert.Equal(t, `{"error_code":0,"desp":"2"}`, r)
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait timeout")
+	}
+	assert.Equal(t, `{"error_code":0,"desp":"2"}`, call())
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,143 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
+)
+
+// 通过额外监听地址接入的session，会被打上该key的标签，value为 ListenerConfig.Tag
+const SessionTagKeyListener = "listener"
+
+// rtmpListenerObserver 额外监听地址的rtmp server回调，附带上监听地址的配置后转给ServerManager处理
+type rtmpListenerObserver struct {
+	sm     *ServerManager
+	config ListenerConfig
+}
+
+func (o *rtmpListenerObserver) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.ObjectPairArray) {
+	o.sm.OnRtmpConnect(session, opa)
+}
+
+func (o *rtmpListenerObserver) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
+	return o.sm.onNewRtmpPubSession(session, &o.config)
+}
+
+func (o *rtmpListenerObserver) OnDelRtmpPubSession(session *rtmp.ServerSession) {
+	o.sm.OnDelRtmpPubSession(session)
+}
+
+func (o *rtmpListenerObserver) OnNewRtmpSubSession(session *rtmp.ServerSession) error {
+	return o.sm.onNewRtmpSubSession(session, &o.config)
+}
+
+func (o *rtmpListenerObserver) OnDelRtmpSubSession(session *rtmp.ServerSession) {
+	o.sm.OnDelRtmpSubSession(session)
+}
+
+// rtspListenerObserver 额外监听地址的rtsp server回调，同 rtmpListenerObserver
+type rtspListenerObserver struct {
+	sm     *ServerManager
+	config ListenerConfig
+}
+
+func (o *rtspListenerObserver) OnNewRtspSessionConnect(session *rtsp.ServerCommandSession) {
+	o.sm.OnNewRtspSessionConnect(session)
+}
+
+func (o *rtspListenerObserver) OnDelRtspSession(session *rtsp.ServerCommandSession) {
+	o.sm.OnDelRtspSession(session)
+}
+
+func (o *rtspListenerObserver) OnNewRtspPubSession(session *rtsp.PubSession) error {
+	return o.sm.onNewRtspPubSession(session, &o.config)
+}
+
+func (o *rtspListenerObserver) OnDelRtspPubSession(session *rtsp.PubSession) {
+	o.sm.OnDelRtspPubSession(session)
+}
+
+func (o *rtspListenerObserver) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	return o.sm.onNewRtspSubSessionDescribe(session, &o.config)
+}
+
+func (o *rtspListenerObserver) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
+	return o.sm.onNewRtspSubSessionPlay(session, &o.config)
+}
+
+func (o *rtspListenerObserver) OnDelRtspSubSession(session *rtsp.SubSession) {
+	o.sm.OnDelRtspSubSession(session)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// needAuth 主监听地址（nil）总是需要鉴权
+func (l *ListenerConfig) needAuth() bool {
+	return l == nil || !l.AuthDisable
+}
+
+// tagSession 给从该监听地址接入的session打上标签，注意，调用方需要持有ServerManager的锁
+func (l *ListenerConfig) tagSession(group *Group, sessionId string) {
+	if l == nil || l.Tag == "" {
+		return
+	}
+	group.TagSession(sessionId, map[string]string{SessionTagKeyListener: l.Tag})
+}
+
+func (sm *ServerManager) newExtraRtmpServers(configs []ListenerConfig, reusePort bool) (servers []*rtmp.Server) {
+	for _, c := range configs {
+		observer := &rtmpListenerObserver{sm: sm, config: c}
+		servers = append(servers, rtmp.NewServer(c.Addr, observer, func(option *rtmp.ServerOption) {
+			option.ProxyProtocolEnable = c.ProxyProtocolEnable
+			option.ReusePort = reusePort
+			option.ConnGuard = sm.connGuard
+			option.ProxyProtocolTrustedNets = sm.config.ProxyProtocolConfig.trustedNets
+		}))
+	}
+	return
+}
+
+func (sm *ServerManager) newExtraRtspServers(configs []ListenerConfig, reusePort bool) (servers []*rtsp.Server) {
+	for _, c := range configs {
+		observer := &rtspListenerObserver{sm: sm, config: c}
+		servers = append(servers, rtsp.NewServer(c.Addr, observer, func(option *rtsp.ServerOption) {
+			option.ProxyProtocolEnable = c.ProxyProtocolEnable
+			option.JitterBufferMs = sm.config.RtspConfig.JitterBufferMs
+			option.ReusePort = reusePort
+			option.ConnGuard = sm.connGuard
+			option.ProxyProtocolTrustedNets = sm.config.ProxyProtocolConfig.trustedNets
+		}))
+	}
+	return
+}
+
+func (sm *ServerManager) runExtraListeners() error {
+	for _, s := range sm.extraRtmpServers {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+		go func(s *rtmp.Server) {
+			if err := s.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}(s)
+	}
+	for _, s := range sm.extraRtspServers {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+		go func(s *rtsp.Server) {
+			if err := s.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}(s)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testRejectAuthentication struct{}
+
+func (a *testRejectAuthentication) OnPubStart(info base.PubStartInfo) error {
+	return errors.New("reject")
+}
+
+func (a *testRejectAuthentication) OnSubStart(info base.SubStartInfo) error {
+	return errors.New("reject")
+}
+
+func (a *testRejectAuthentication) OnHls(streamName string, urlParam string) error {
+	return errors.New("reject")
+}
+
+func TestExtraListener(t *testing.T) {
+	rawContent := []byte(`{
+  "rtmp": {
+    "enable": true,
+    "addr": ":19440",
+    "extra_listeners": [{"addr": "127.0.0.1:19441", "tag": "internal", "auth_disable": true}]
+  },
+  "log": {"level": 2, "filename": "", "is_to_stdout": true}
+}`)
+	sm := NewServerManager(func(option *Option) {
+		option.ConfRawContent = rawContent
+		option.Authentication = &testRejectAuthentication{}
+	})
+	go sm.RunLoop()
+	defer sm.Dispose()
+	time.Sleep(100 * time.Millisecond)
+
+	// 主监听地址需要鉴权，鉴权失败时服务端关闭连接
+	ps := rtmp.NewPushSession()
+	_ = ps.Push("rtmp://127.0.0.1:19440/live/test110")
+	time.Sleep(100 * time.Millisecond)
+	stat := sm.StatGroup("test110")
+	assert.Equal(t, true, stat == nil || stat.StatPub.SessionId == "")
+	_ = ps.Dispose()
+
+	ps = rtmp.NewPushSession()
+	err := ps.Push("rtmp://127.0.0.1:19441/live/test110")
+	assert.Equal(t, nil, err)
+	defer ps.Dispose()
+	time.Sleep(100 * time.Millisecond)
+
+	stat = sm.StatGroup("test110")
+	assert.IsNotNil(t, stat)
+	assert.Equal(t, map[string]string{SessionTagKeyListener: "internal"}, stat.StatPub.Tags)
+}
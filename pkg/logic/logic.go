@@ -31,6 +31,16 @@ type ILalServer interface {
 	//
 	DelCustomizePubSession(ICustomizePubSessionContext)
 
+	// AddCustomizeSubSession 定制化增强功能。业务方可以在进程内从 ILalServer 中拉取流的音视频数据
+	//
+	// 音视频数据通过 ICustomizeSubHandler 回调，格式见 ICustomizeSubHandler
+	//
+	AddCustomizeSubSession(streamName string, handler ICustomizeSubHandler) (ICustomizeSubSessionContext, error)
+
+	// DelCustomizeSubSession 将 ICustomizeSubSessionContext 从 ILalServer 中删除
+	//
+	DelCustomizeSubSession(ICustomizeSubSessionContext)
+
 	// StatLalInfo StatAllGroup StatGroup CtrlStartPull CtrlKickOutSession
 	//
 	// 一些获取状态、发送控制命令的API。
@@ -39,8 +49,14 @@ type ILalServer interface {
 	StatLalInfo() base.LalInfo
 	StatAllGroup() (sgs []base.StatGroup)
 	StatGroup(streamName string) *base.StatGroup
-	CtrlStartPull(info base.ApiCtrlStartPullReq)
+	CtrlStartPull(info base.ApiCtrlStartPullReq) base.ApiCtrlStartPullResp
+	CtrlStopPull(info base.ApiCtrlStopPull) base.HttpResponseBasic
 	CtrlKickOutSession(info base.ApiCtrlKickOutSession) base.HttpResponseBasic
+	CtrlTagSession(info base.ApiCtrlTagSession) base.HttpResponseBasic
+	StatSessionsByIp(ip string) []base.StatIpSession
+	CtrlKickByIp(info base.ApiCtrlKickByIp) base.ApiCtrlKickByIpResp
+	CtrlBatchStartPull(info base.ApiCtrlBatchStartPullReq) base.ApiCtrlBatchStartPullResp
+	CtrlBatchKickOutSession(info base.ApiCtrlBatchKickOutSessionReq) base.ApiCtrlBatchKickOutSessionResp
 }
 
 // NewLalServer 创建一个lal server
@@ -58,22 +74,79 @@ type ICustomizePubSessionContext interface {
 	//
 	base.IAvPacketStream
 
+	// FeedRtmpMsg 直接传入rtmp格式的音视频数据（比如flv文件中的tag），不经过 IAvPacketStream 的转换
+	//
+	// 注意，不要和 IAvPacketStream 的接口混用。内部不持有msg的内存块
+	//
+	FeedRtmpMsg(msg base.RtmpMsg)
+
 	UniqueKey() string
 	StreamName() string
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+type ICustomizeSubSessionContext interface {
+	UniqueKey() string
+	StreamName() string
+}
+
+// ICustomizeSubHandler 进程内拉流的回调
+//
+// 注意，回调发生在lalserver内部转发数据的流程中，业务方不应该在回调中阻塞，也不应该在回调中调用 ILalServer 的函数
+//
+type ICustomizeSubHandler interface {
+	// OnAudioSpecificConfig 音频AAC的初始化数据，在第一个AAC音频数据回调前回调一次
+	//
+	OnAudioSpecificConfig(asc []byte)
+
+	// OnAvPacket
+	//
+	// 音频为不包含adts头的AAC裸数据。
+	// 视频为Annexb格式，从关键帧开始回调，关键帧前面会带上sps、pps（h265还有vps）。
+	// 时间戳为pts，单位毫秒。
+	// 回调结束后，业务方可以继续持有packet.Payload内存块，但是不能修改，因为多个customize sub共享同一块内存。
+	//
+	OnAvPacket(packet base.AvPacket)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
 // INotifyHandler 事件通知接口
 //
 type INotifyHandler interface {
 	OnServerStart(info base.LalInfo)
+	OnServerStop(info base.ServerStopInfo)
+	OnHeartbeat(info base.ServerHeartbeatInfo)
 	OnUpdate(info base.UpdateInfo)
 	OnPubStart(info base.PubStartInfo)
 	OnPubStop(info base.PubStopInfo)
 	OnSubStart(info base.SubStartInfo)
 	OnSubStop(info base.SubStopInfo)
 	OnRtmpConnect(info base.RtmpConnectInfo)
+	OnRelayPullStart(info base.RelayPullStartInfo)
+	OnRelayPullStop(info base.RelayPullStopInfo)
+	OnRelayPushStart(info base.RelayPushStartInfo)
+	OnRelayPushStop(info base.RelayPushStopInfo)
+	OnBitstreamError(info base.BitstreamErrorInfo)
+	OnBackupSwitch(info base.BackupSwitchInfo)
+	OnViewerChange(info base.ViewerChangeInfo)
+	OnPubViolation(info base.PubViolationInfo)
+	OnSubReject(info base.SubRejectInfo)
+}
+
+// IAuthentication 鉴权接口
+//
+// 返回非nil的error表示鉴权失败，对应的session会被关闭
+//
+// 注意，实现必须是并发安全的：所有协议的pub、sub鉴权都不持有lalserver内部的锁，
+// 多个session的鉴权（以及hls的m3u8请求）会在各自的协程中同时调用。
+// 因为不持有锁，鉴权中也可以同步请求外部服务，耗时只影响当前session
+//
+type IAuthentication interface {
+	OnPubStart(info base.PubStartInfo) error
+	OnSubStart(info base.SubStartInfo) error
+	OnHls(streamName string, urlParam string) error
 }
 
 type Option struct {
@@ -81,6 +154,12 @@ type Option struct {
 	//
 	ConfFilename string
 
+	// ConfRawContent json格式的配置内容
+	//
+	// 不为nil时，使用该内容作为配置，忽略 ConfFilename ，方便将lalserver嵌入到其他程序中，而不依赖配置文件
+	//
+	ConfRawContent []byte
+
 	// NotifyHandler
 	//
 	// 事件监听
@@ -89,10 +168,42 @@ type Option struct {
 	// 注意，如果业务方实现了自己的事件监听，则lal server内部不再走http notify的逻辑（也即二选一）。
 	//
 	NotifyHandler INotifyHandler
+
+	// Authentication
+	//
+	// 鉴权
+	// 业务方可实现 IAuthentication 接口并传入，在进程内完成pub、sub的鉴权。
+	// 如果不填写保持默认值nil，内部使用配置文件中的simple_auth鉴权。
+	// 注意，如果业务方实现了自己的鉴权，则不再走simple_auth的逻辑（也即二选一）。
+	// 注意，会被并发调用，实现需要是并发安全的，见 IAuthentication 。
+	//
+	Authentication IAuthentication
+
+	// Plugins
+	//
+	// 插件，具体见 IPlugin 。
+	// 和配置文件中`plugin`加载的插件一起生效，注意，需要在配置文件中开启`plugin.enable`。
+	//
+	Plugins []IPlugin
+
+	// WorkerIndex
+	//
+	// 大于等于0时，覆盖配置文件中的`reuse_port.worker_index`，使得多个worker进程可以使用同一个配置文件
+	//
+	WorkerIndex int
+
+	// SkipLogInit
+	//
+	// 为true时，不使用配置文件中的`log`初始化全局日志，沿用调用方已经初始化好的日志，
+	// 方便在同一个进程中多次创建 ServerManager （比如测试），避免重新初始化日志和上一个lalserver残留的协程竞争
+	//
+	SkipLogInit bool
 }
 
 var defaultOption = Option{
-	NotifyHandler: nil, // 注意，为nil时，内部会赋值为 HttpNotify
+	NotifyHandler:  nil, // 注意，为nil时，内部会赋值为 HttpNotify
+	Authentication: nil, // 注意，为nil时，内部会赋值为 SimpleAuthCtx
+	WorkerIndex:    -1,
 }
 
 type ModOption func(option *Option)
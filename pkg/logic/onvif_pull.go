@@ -0,0 +1,254 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/onvif"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/sdp"
+)
+
+const (
+	defaultOnvifProbeIntervalSec   = 60
+	defaultOnvifProbeTimeoutMs     = 3000
+	defaultOnvifStreamNameTemplate = "onvif_${ip}_${profile_index}"
+
+	onvifRequestTimeout = 5 * time.Second
+	onvifPullTimeoutMs  = 10000
+)
+
+// OnvifPuller 定时通过WS-Discovery发现局域网中的Onvif摄像头，获取rtsp地址，并自动创建rtsp拉流，输入到按模板命名的group中
+//
+// 拉流断开后从列表中删除，在下一次发现设备时重新拉流
+//
+type OnvifPuller struct {
+	sm     *ServerManager
+	config OnvifConfig
+
+	probe func(timeout time.Duration) ([]onvif.Device, error)
+
+	mutex    sync.Mutex
+	pulls    map[string]*onvifPull // key: stream name
+	disposed bool
+	exitChan chan struct{}
+}
+
+type onvifPull struct {
+	url     string
+	session *rtsp.PullSession
+	pubCtx  ICustomizePubSessionContext
+}
+
+func NewOnvifPuller(sm *ServerManager, config OnvifConfig) *OnvifPuller {
+	if config.ProbeIntervalSec <= 0 {
+		config.ProbeIntervalSec = defaultOnvifProbeIntervalSec
+	}
+	if config.ProbeTimeoutMs <= 0 {
+		config.ProbeTimeoutMs = defaultOnvifProbeTimeoutMs
+	}
+	if config.StreamNameTemplate == "" {
+		config.StreamNameTemplate = defaultOnvifStreamNameTemplate
+	}
+	return &OnvifPuller{
+		sm:       sm,
+		config:   config,
+		probe:    onvif.Probe,
+		pulls:    make(map[string]*onvifPull),
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (p *OnvifPuller) RunLoop() {
+	t := time.NewTicker(time.Duration(p.config.ProbeIntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		p.discover()
+		select {
+		case <-p.exitChan:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *OnvifPuller) Dispose() {
+	p.mutex.Lock()
+	if p.disposed {
+		p.mutex.Unlock()
+		return
+	}
+	p.disposed = true
+	close(p.exitChan)
+	pulls := p.pulls
+	p.pulls = make(map[string]*onvifPull)
+	p.mutex.Unlock()
+
+	for _, pull := range pulls {
+		pull.session.Dispose()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (p *OnvifPuller) discover() {
+	devices, err := p.probe(time.Duration(p.config.ProbeTimeoutMs) * time.Millisecond)
+	if err != nil {
+		Log.Errorf("onvif probe failed. err=%+v", err)
+		return
+	}
+	Log.Debugf("onvif probe. devices=%d", len(devices))
+
+	for _, d := range devices {
+		client := onvif.NewClient(d.XAddrs[0], p.config.Username, p.config.Password, onvifRequestTimeout)
+		profiles, err := client.GetProfiles()
+		if err != nil {
+			Log.Warnf("onvif get profiles failed. device=%s, xaddr=%s, err=%+v", d.Uuid, d.XAddrs[0], err)
+			continue
+		}
+		for i, profile := range profiles {
+			if i > 0 && !p.config.AllProfiles {
+				break
+			}
+			streamName := makeOnvifStreamName(p.config.StreamNameTemplate, &d, i, profile)
+			if p.isPulling(streamName) {
+				continue
+			}
+			uri, err := client.GetStreamUri(profile.Token)
+			if err != nil {
+				Log.Warnf("onvif get stream uri failed. device=%s, profile=%s, err=%+v", d.Uuid, profile.Token, err)
+				continue
+			}
+			p.startPull(streamName, withUserinfo(uri, p.config.Username, p.config.Password))
+		}
+	}
+}
+
+func (p *OnvifPuller) isPulling(streamName string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, ok := p.pulls[streamName]
+	return ok
+}
+
+func (p *OnvifPuller) startPull(streamName string, rawUrl string) {
+	pubCtx, err := p.sm.AddCustomizePubSession(streamName)
+	if err != nil {
+		// 比如该流名称已经有其他输入
+		Log.Warnf("onvif add pub session failed. stream=%s, err=%+v", streamName, err)
+		return
+	}
+
+	pull := &onvifPull{
+		url:    rawUrl,
+		pubCtx: pubCtx,
+	}
+	pull.session = rtsp.NewPullSession(&onvifPullObserver{pubCtx: pubCtx}, func(option *rtsp.PullSessionOption) {
+		option.PullTimeoutMs = onvifPullTimeoutMs
+		option.OverTcp = p.config.PullOverTcp
+	})
+
+	p.mutex.Lock()
+	if p.disposed {
+		p.mutex.Unlock()
+		p.sm.DelCustomizePubSession(pubCtx)
+		return
+	}
+	p.pulls[streamName] = pull
+	p.mutex.Unlock()
+
+	Log.Infof("[%s] onvif start pull. stream=%s, url=%s", pull.session.UniqueKey(), streamName, rawUrl)
+	go func() {
+		err := pull.session.Pull(rawUrl)
+		if err == nil {
+			err = <-pull.session.WaitChan()
+		}
+		Log.Infof("[%s] onvif pull done. stream=%s, err=%+v", pull.session.UniqueKey(), streamName, err)
+		pull.session.Dispose()
+		p.sm.DelCustomizePubSession(pubCtx)
+
+		p.mutex.Lock()
+		if p.pulls[streamName] == pull {
+			delete(p.pulls, streamName)
+		}
+		p.mutex.Unlock()
+	}()
+}
+
+// makeOnvifStreamName 根据模板生成流名称
+//
+// 支持的变量：${ip}、${uuid}（去掉`urn:uuid:`前缀）、${profile_index}、${profile_token}、${profile_name}
+// 变量中的`.`、`:`等字符替换为`_`
+//
+func makeOnvifStreamName(template string, d *onvif.Device, profileIndex int, profile onvif.Profile) string {
+	return scriptTemplateRe.ReplaceAllStringFunc(template, func(s string) string {
+		var v string
+		switch s[2 : len(s)-1] {
+		case "ip":
+			v = d.Host()
+		case "uuid":
+			v = strings.TrimPrefix(d.Uuid, "urn:uuid:")
+		case "profile_index":
+			v = strconv.Itoa(profileIndex)
+		case "profile_token":
+			v = profile.Token
+		case "profile_name":
+			v = profile.Name
+		default:
+			return s
+		}
+		return strings.Map(func(r rune) rune {
+			if r == '.' || r == ':' || r == '/' || r == ' ' {
+				return '_'
+			}
+			return r
+		}, v)
+	})
+}
+
+// withUserinfo 设备返回的rtsp地址通常不包含用户名和密码，使用配置中的填充
+func withUserinfo(rawUrl string, username string, password string) string {
+	if username == "" {
+		return rawUrl
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User != nil {
+		return rawUrl
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// onvifPullObserver 将rtsp拉流的数据输入到customize pub session
+type onvifPullObserver struct {
+	pubCtx ICustomizePubSessionContext
+}
+
+func (o *onvifPullObserver) OnSdp(sdpCtx sdp.LogicContext) {
+	if ctx, ok := o.pubCtx.(*CustomizePubSessionContext); ok {
+		ctx.remuxer.OnSdp(sdpCtx)
+	}
+}
+
+func (o *onvifPullObserver) OnRtpPacket(pkt rtprtcp.RtpPacket) {
+	// noop
+}
+
+func (o *onvifPullObserver) OnAvPacket(pkt base.AvPacket) {
+	o.pubCtx.FeedAvPacket(pkt)
+}
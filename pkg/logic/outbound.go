@@ -0,0 +1,330 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+// OutboundPool 对外http请求（http notify、sidecar插件的鉴权和通知）使用的有界协程池
+//
+// - 固定数量的worker协程从有界队列中取请求执行，队列满时直接丢弃，接收方很慢时不会无限创建协程，也不会阻塞调用方（比如媒体数据的处理流程）
+// - 同步请求（鉴权、流名称解析）使用单独的队列和worker，不会排在大量异步通知后面。超时从提交时开始计算，包含排队等待的时间
+// - 所有请求共用http.Transport，按host复用连接
+// - 按host熔断，同一个host连续失败（请求错误、超时、http状态码5xx）达到阈值后，一段时间内直接丢弃发往该host的请求，避免占满worker影响其他host
+//   熔断时间结束后放行一个请求试探，成功则恢复
+//
+type OutboundPool struct {
+	config OutboundConfig
+
+	taskQueue     chan outboundTask // 异步请求
+	syncTaskQueue chan outboundTask // 同步请求
+	exitChan      chan struct{}
+
+	mutex    sync.Mutex
+	clients  map[string]*http.Client // key: proxy url，为空表示不使用代理
+	breakers map[string]*outboundBreaker
+
+	disposeOnce sync.Once
+}
+
+type OutboundRequest struct {
+	Url      string
+	Info     interface{} // 序列化为json作为body
+	ProxyUrl string      // 出口代理，支持http和socks5
+	Timeout  time.Duration
+}
+
+type outboundTask struct {
+	req      OutboundRequest
+	host     string
+	deadline time.Time           // 同步请求的截止时间，过期后worker不再执行
+	doneChan chan outboundResult // 为nil表示异步请求
+}
+
+type outboundResult struct {
+	body []byte
+	err  error
+}
+
+type outboundBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+const (
+	defaultOutboundWorkerNum    = 8
+	defaultOutboundQueueSize    = 1024
+	defaultOutboundTimeoutMs    = 3000
+	defaultOutboundBreakOpenSec = 30
+
+	// outboundMaxRespBodySize 读取响应body的最大长度，读完剩余数据后连接才能复用
+	outboundMaxRespBodySize = 1024 * 1024
+)
+
+func NewOutboundPool(config OutboundConfig) *OutboundPool {
+	if config.WorkerNum <= 0 {
+		config.WorkerNum = defaultOutboundWorkerNum
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultOutboundQueueSize
+	}
+	if config.TimeoutMs <= 0 {
+		config.TimeoutMs = defaultOutboundTimeoutMs
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = config.WorkerNum
+	}
+	if config.BreakerOpenSec <= 0 {
+		config.BreakerOpenSec = defaultOutboundBreakOpenSec
+	}
+	p := &OutboundPool{
+		config:        config,
+		taskQueue:     make(chan outboundTask, config.QueueSize),
+		syncTaskQueue: make(chan outboundTask, config.QueueSize),
+		exitChan:      make(chan struct{}),
+		clients:       make(map[string]*http.Client),
+		breakers:      make(map[string]*outboundBreaker),
+	}
+	for i := 0; i < config.WorkerNum; i++ {
+		go p.runWorker(p.taskQueue)
+		go p.runWorker(p.syncTaskQueue)
+	}
+	return p
+}
+
+// Post 异步post，不等待结果。队列满或者host处于熔断中时丢弃，返回错误
+//
+func (p *OutboundPool) Post(req OutboundRequest) error {
+	return p.submit(outboundTask{req: req}, p.taskQueue)
+}
+
+// PostSync 同步post，等待worker执行完成，返回响应的body
+//
+// 从提交开始最多等待`req.Timeout`（为0时使用`timeout_ms`），包含排队等待的时间
+//
+// 注意，http状态码不是2xx时也返回错误
+//
+func (p *OutboundPool) PostSync(req OutboundRequest) ([]byte, error) {
+	timeout := p.timeout(req)
+	t := outboundTask{
+		req:      req,
+		deadline: time.Now().Add(timeout),
+		doneChan: make(chan outboundResult, 1),
+	}
+	if err := p.submit(t, p.syncTaskQueue); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-t.doneChan:
+		return r.body, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w. url=%s, timeout=%v", base.ErrOutboundTimeout, req.Url, timeout)
+	case <-p.exitChan:
+		return nil, base.ErrOutboundDisposed
+	}
+}
+
+func (p *OutboundPool) Dispose() {
+	p.disposeOnce.Do(func() {
+		close(p.exitChan)
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (p *OutboundPool) submit(t outboundTask, queue chan outboundTask) error {
+	select {
+	case <-p.exitChan:
+		return base.ErrOutboundDisposed
+	default:
+	}
+	u, err := url.Parse(t.req.Url)
+	if err != nil {
+		return err
+	}
+	if !p.allow(u.Host, time.Now()) {
+		return fmt.Errorf("%w. host=%s", base.ErrOutboundBreakerOpen, u.Host)
+	}
+	t.host = u.Host
+	select {
+	case queue <- t:
+		return nil
+	default:
+		// 没有执行，不影响熔断状态，但是需要结束试探
+		p.cancelProbe(u.Host)
+		return base.ErrOutboundQueueFull
+	}
+}
+
+func (p *OutboundPool) runWorker(queue chan outboundTask) {
+	for {
+		select {
+		case <-p.exitChan:
+			return
+		case t := <-queue:
+			if !t.deadline.IsZero() && !time.Now().Before(t.deadline) {
+				// 调用方已经超时返回，不再执行
+				p.cancelProbe(t.host)
+				continue
+			}
+			body, statusCode, err := p.do(t)
+			// 4xx一般是请求本身的问题，不认为接收方不可用
+			p.onResult(t.host, err != nil && (statusCode == 0 || statusCode >= 500), err, time.Now())
+			if t.doneChan != nil {
+				t.doneChan <- outboundResult{body: body, err: err}
+			} else if err != nil {
+				Log.Errorf("outbound post error. url=%s, err=%+v", t.req.Url, err)
+			}
+		}
+	}
+}
+
+// do 执行请求
+//
+// @return statusCode 为0表示没有收到响应
+//
+func (p *OutboundPool) do(t outboundTask) (body []byte, statusCode int, err error) {
+	req := t.req
+	j, err := json.Marshal(req.Info)
+	if err != nil {
+		return nil, 0, err
+	}
+	deadline := t.deadline
+	if deadline.IsZero() {
+		deadline = time.Now().Add(p.timeout(req))
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.Url, bytes.NewReader(j))
+	if err != nil {
+		return nil, 0, err
+	}
+	httpReq.Header.Set("Content-Type", nazahttp.HeaderFieldContentType)
+
+	resp, err := p.client(req.ProxyUrl).Do(httpReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	// 读完并关闭body，连接才能被复用
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, outboundMaxRespBodySize))
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return body, resp.StatusCode, fmt.Errorf("%w. status code=%d", base.ErrOutboundStatusCode, resp.StatusCode)
+	}
+	return body, resp.StatusCode, nil
+}
+
+func (p *OutboundPool) timeout(req OutboundRequest) time.Duration {
+	if req.Timeout > 0 {
+		return req.Timeout
+	}
+	return time.Duration(p.config.TimeoutMs) * time.Millisecond
+}
+
+func (p *OutboundPool) client(proxyUrl string) *http.Client {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if c, ok := p.clients[proxyUrl]; ok {
+		return c
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        p.config.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost: p.config.MaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if proxyUrl != "" {
+		if u, err := url.Parse(proxyUrl); err != nil {
+			Log.Errorf("outbound proxy url invalid, ignore it. url=%s, err=%+v", proxyUrl, err)
+		} else {
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+	c := &http.Client{Transport: transport}
+	p.clients[proxyUrl] = c
+	return c
+}
+
+func (p *OutboundPool) allow(host string, now time.Time) bool {
+	if p.config.BreakerFailureThreshold <= 0 {
+		return true
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	b, ok := p.breakers[host]
+	if !ok || b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// onResult 更新熔断状态
+//
+// @param failed 是否认为接收方不可用
+//
+func (p *OutboundPool) onResult(host string, failed bool, err error, now time.Time) {
+	if p.config.BreakerFailureThreshold <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	b, ok := p.breakers[host]
+	if !ok {
+		if !failed {
+			return
+		}
+		b = &outboundBreaker{}
+		p.breakers[host] = b
+	}
+	if !failed {
+		if !b.openUntil.IsZero() {
+			Log.Infof("outbound breaker closed. host=%s", host)
+		}
+		delete(p.breakers, host)
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= p.config.BreakerFailureThreshold {
+		Log.Warnf("outbound breaker open. host=%s, failures=%d, open sec=%d, err=%+v", host, b.failures, p.config.BreakerOpenSec, err)
+		b.openUntil = now.Add(time.Duration(p.config.BreakerOpenSec) * time.Second)
+	}
+	b.probing = false
+}
+
+func (p *OutboundPool) cancelProbe(host string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if b, ok := p.breakers[host]; ok {
+		b.probing = false
+	}
+}
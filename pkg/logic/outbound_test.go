@@ -0,0 +1,140 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestOutboundPool(t *testing.T) {
+	var reqCount, connCount int32
+	var fail int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqCount, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"error_code":0}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connCount, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	p := NewOutboundPool(OutboundConfig{
+		WorkerNum:               1,
+		QueueSize:               16,
+		BreakerFailureThreshold: 3,
+		BreakerOpenSec:          1,
+	})
+	defer p.Dispose()
+
+	// 连接复用
+	for i := 0; i < 5; i++ {
+		body, err := p.PostSync(OutboundRequest{Url: server.URL + "/on_pub_start", Info: base.PubStartInfo{}})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"error_code":0}`, string(body))
+	}
+	assert.Equal(t, nil, p.Post(OutboundRequest{Url: server.URL + "/on_pub_stop", Info: base.PubStopInfo{}}))
+	for i := 0; i < 100 && atomic.LoadInt32(&reqCount) != 6; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(6), atomic.LoadInt32(&reqCount))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connCount))
+
+	// 连续失败后熔断，不再发送请求
+	atomic.StoreInt32(&fail, 1)
+	for i := 0; i < 3; i++ {
+		_, err := p.PostSync(OutboundRequest{Url: server.URL, Info: base.PubStartInfo{}})
+		assert.Equal(t, true, errors.Is(err, base.ErrOutboundStatusCode))
+	}
+	_, err := p.PostSync(OutboundRequest{Url: server.URL, Info: base.PubStartInfo{}})
+	assert.Equal(t, true, errors.Is(err, base.ErrOutboundBreakerOpen))
+	assert.Equal(t, int32(9), atomic.LoadInt32(&reqCount))
+
+	// 熔断时间结束后试探成功，恢复
+	atomic.StoreInt32(&fail, 0)
+	time.Sleep(1100 * time.Millisecond)
+	_, err = p.PostSync(OutboundRequest{Url: server.URL, Info: base.PubStartInfo{}})
+	assert.Equal(t, nil, err)
+	_, err = p.PostSync(OutboundRequest{Url: server.URL, Info: base.PubStartInfo{}})
+	assert.Equal(t, nil, err)
+}
+
+func TestOutboundPoolSyncLane(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		_, _ = w.Write([]byte(`{"error_code":0}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := NewOutboundPool(OutboundConfig{
+		WorkerNum: 1,
+		QueueSize: 16,
+		TimeoutMs: 2000,
+	})
+
+	// 异步通知占满了worker，不影响同步请求
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, p.Post(OutboundRequest{Url: server.URL + "/slow", Info: base.PubStopInfo{}}))
+	}
+	_, err := p.PostSync(OutboundRequest{Url: server.URL + "/fast", Info: base.PubStartInfo{}})
+	assert.Equal(t, nil, err)
+
+	// 超时包含排队等待的时间
+	go func() {
+		_, _ = p.PostSync(OutboundRequest{Url: server.URL + "/slow", Info: base.PubStartInfo{}})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b := time.Now()
+	_, err = p.PostSync(OutboundRequest{Url: server.URL + "/fast", Info: base.PubStartInfo{}, Timeout: 100 * time.Millisecond})
+	assert.Equal(t, true, errors.Is(err, base.ErrOutboundTimeout))
+	assert.Equal(t, true, time.Since(b) < time.Second)
+
+	// Dispose之后直接返回错误
+	p.Dispose()
+	assert.Equal(t, true, errors.Is(p.Post(OutboundRequest{Url: server.URL}), base.ErrOutboundDisposed))
+	_, err = p.PostSync(OutboundRequest{Url: server.URL})
+	assert.Equal(t, true, errors.Is(err, base.ErrOutboundDisposed))
+}
+
+// 不传入协程池的构造函数保持原有签名，使用自己的协程池
+func TestOutboundPoolPrivate(t *testing.T) {
+	n := NewHttpNotify(HttpNotifyConfig{})
+	assert.IsNotNil(t, n.outbound)
+	n.outbound.Dispose()
+
+	p := NewSidecarPlugin(SidecarConfig{Name: "sidecar"})
+	assert.IsNotNil(t, p.outbound)
+	p.outbound.Dispose()
+
+	shared := NewOutboundPool(OutboundConfig{})
+	defer shared.Dispose()
+	assert.Equal(t, shared, NewHttpNotifyWithOutbound(HttpNotifyConfig{}, shared).outbound)
+}
@@ -0,0 +1,438 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"plugin"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// IPlugin 插件
+//
+// 插件有三种加载方式：
+//   - 嵌入lalserver的程序，通过 Option.Plugins 传入
+//   - Go plugin，配置`plugin.so_files`，so中需要导出 PluginSymbolNew 函数
+//   - 独立进程的sidecar，配置`plugin.sidecars`，通过http json协议交互，具体见 SidecarPlugin
+//
+type IPlugin interface {
+	Name() string
+
+	// Authentication 鉴权。所有插件都鉴权通过，才允许pub、sub。注意，会被并发调用，见 IAuthentication
+	//
+	// @return 返回nil表示插件不提供该功能，下同
+	//
+	Authentication() IAuthentication
+
+	// NotifyHandler 事件通知
+	//
+	NotifyHandler() INotifyHandler
+
+	// StreamProcessor 处理输入流的音视频数据
+	//
+	StreamProcessor() IStreamProcessor
+}
+
+// IStreamProcessor 处理输入流的音视频数据
+//
+type IStreamProcessor interface {
+	// OnStreamStart 输入流开始时回调
+	//
+	// @return 返回nil表示不处理这路流。否则通过返回的 ICustomizeSubHandler 接收这路流的音视频数据，直到输入流结束
+	//
+	OnStreamStart(streamName string) ICustomizeSubHandler
+}
+
+// PluginSymbolNew Go plugin需要导出的函数名，函数类型为`func() logic.IPlugin`
+//
+const PluginSymbolNew = "NewPlugin"
+
+// PluginManager 管理所有插件，将所有插件以及插件之外的鉴权、事件通知组合成一个 IAuthentication 以及一个 INotifyHandler
+//
+type PluginManager struct {
+	plugins []IPlugin
+
+	auth   pluginAuthentication
+	notify pluginNotifyHandler
+
+	// stream name -> 插件处理这路流的customize sub
+	processing map[string][]ICustomizeSubSessionContext
+}
+
+// NewPluginManager
+//
+// @param plugins:    通过 Option.Plugins 传入的插件
+// @param baseAuth:   插件之外的鉴权，也即 Option.Authentication 或者simple_auth
+// @param baseNotify: 插件之外的事件通知，也即 Option.NotifyHandler 或者http notify
+//
+func NewPluginManager(config PluginConfig, plugins []IPlugin, baseAuth IAuthentication, baseNotify INotifyHandler) *PluginManager {
+	return NewPluginManagerWithOutbound(config, plugins, baseAuth, baseNotify, nil)
+}
+
+// NewPluginManagerWithOutbound 参数见 NewPluginManager
+//
+// @param outbound: sidecar插件的http请求使用的协程池。为nil并且配置了sidecar插件时，使用默认配置创建
+//
+func NewPluginManagerWithOutbound(config PluginConfig, plugins []IPlugin, baseAuth IAuthentication, baseNotify INotifyHandler, outbound *OutboundPool) *PluginManager {
+	if outbound == nil && len(config.Sidecars) != 0 {
+		outbound = NewOutboundPool(OutboundConfig{})
+	}
+	pm := &PluginManager{
+		plugins:    plugins,
+		processing: make(map[string][]ICustomizeSubSessionContext),
+	}
+	for _, filename := range config.SoFiles {
+		p, err := loadGoPlugin(filename)
+		if err != nil {
+			Log.Errorf("load go plugin failed. file=%s, err=%+v", filename, err)
+			continue
+		}
+		pm.plugins = append(pm.plugins, p)
+	}
+	for _, c := range config.Sidecars {
+		pm.plugins = append(pm.plugins, NewSidecarPluginWithOutbound(c, outbound))
+	}
+
+	if baseAuth != nil {
+		pm.auth = append(pm.auth, namedAuthentication{"", baseAuth})
+	}
+	if baseNotify != nil {
+		pm.notify = append(pm.notify, baseNotify)
+	}
+	for _, p := range pm.plugins {
+		a, h, sp := p.Authentication(), p.NotifyHandler(), p.StreamProcessor()
+		if a != nil {
+			pm.auth = append(pm.auth, namedAuthentication{p.Name(), a})
+		}
+		if h != nil {
+			pm.notify = append(pm.notify, h)
+		}
+		Log.Infof("plugin loaded. name=%s, auth=%v, notify=%v, processor=%v", p.Name(), a != nil, h != nil, sp != nil)
+	}
+	return pm
+}
+
+func (pm *PluginManager) Authentication() IAuthentication {
+	return pm.auth
+}
+
+func (pm *PluginManager) NotifyHandler() INotifyHandler {
+	return pm.notify
+}
+
+// OnStreamStart 输入流开始时调用，将流交给插件处理
+//
+func (pm *PluginManager) OnStreamStart(group *Group, streamName string) {
+	pm.OnStreamStop(group, streamName)
+	for _, p := range pm.plugins {
+		processor := p.StreamProcessor()
+		if processor == nil {
+			continue
+		}
+		handler := processor.OnStreamStart(streamName)
+		if handler == nil {
+			continue
+		}
+		pm.processing[streamName] = append(pm.processing[streamName], group.AddCustomizeSubSession(streamName, handler))
+	}
+}
+
+// OnStreamStop 输入流结束时调用
+//
+func (pm *PluginManager) OnStreamStop(group *Group, streamName string) {
+	for _, ctx := range pm.processing[streamName] {
+		group.DelCustomizeSubSession(ctx)
+	}
+	delete(pm.processing, streamName)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type namedAuthentication struct {
+	name string
+	IAuthentication
+}
+
+// pluginAuthentication 按顺序鉴权，有一个失败则失败
+type pluginAuthentication []namedAuthentication
+
+func (pa pluginAuthentication) OnPubStart(info base.PubStartInfo) error {
+	return pa.check(func(a IAuthentication) error { return a.OnPubStart(info) })
+}
+
+func (pa pluginAuthentication) OnSubStart(info base.SubStartInfo) error {
+	return pa.check(func(a IAuthentication) error { return a.OnSubStart(info) })
+}
+
+func (pa pluginAuthentication) OnHls(streamName string, urlParam string) error {
+	return pa.check(func(a IAuthentication) error { return a.OnHls(streamName, urlParam) })
+}
+
+func (pa pluginAuthentication) check(fn func(a IAuthentication) error) error {
+	for _, a := range pa {
+		if err := fn(a.IAuthentication); err != nil {
+			if a.name != "" {
+				return fmt.Errorf("plugin %s: %w", a.name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginNotifyHandler 按顺序通知所有的 INotifyHandler
+type pluginNotifyHandler []INotifyHandler
+
+func (pn pluginNotifyHandler) OnServerStart(info base.LalInfo) {
+	for _, h := range pn {
+		h.OnServerStart(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnServerStop(info base.ServerStopInfo) {
+	for _, h := range pn {
+		h.OnServerStop(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnHeartbeat(info base.ServerHeartbeatInfo) {
+	for _, h := range pn {
+		h.OnHeartbeat(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnUpdate(info base.UpdateInfo) {
+	for _, h := range pn {
+		h.OnUpdate(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnPubStart(info base.PubStartInfo) {
+	for _, h := range pn {
+		h.OnPubStart(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnPubStop(info base.PubStopInfo) {
+	for _, h := range pn {
+		h.OnPubStop(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnSubStart(info base.SubStartInfo) {
+	for _, h := range pn {
+		h.OnSubStart(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnSubStop(info base.SubStopInfo) {
+	for _, h := range pn {
+		h.OnSubStop(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnRtmpConnect(info base.RtmpConnectInfo) {
+	for _, h := range pn {
+		h.OnRtmpConnect(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnRelayPullStart(info base.RelayPullStartInfo) {
+	for _, h := range pn {
+		h.OnRelayPullStart(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnRelayPullStop(info base.RelayPullStopInfo) {
+	for _, h := range pn {
+		h.OnRelayPullStop(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnRelayPushStart(info base.RelayPushStartInfo) {
+	for _, h := range pn {
+		h.OnRelayPushStart(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnRelayPushStop(info base.RelayPushStopInfo) {
+	for _, h := range pn {
+		h.OnRelayPushStop(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnBitstreamError(info base.BitstreamErrorInfo) {
+	for _, h := range pn {
+		h.OnBitstreamError(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnBackupSwitch(info base.BackupSwitchInfo) {
+	for _, h := range pn {
+		h.OnBackupSwitch(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnViewerChange(info base.ViewerChangeInfo) {
+	for _, h := range pn {
+		h.OnViewerChange(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnPubViolation(info base.PubViolationInfo) {
+	for _, h := range pn {
+		h.OnPubViolation(info)
+	}
+}
+
+func (pn pluginNotifyHandler) OnSubReject(info base.SubRejectInfo) {
+	for _, h := range pn {
+		h.OnSubReject(info)
+	}
+}
+
+func loadGoPlugin(filename string) (IPlugin, error) {
+	p, err := plugin.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbolNew)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := sym.(func() IPlugin)
+	if !ok {
+		return nil, fmt.Errorf("symbol %s type invalid. %T", PluginSymbolNew, sym)
+	}
+	return fn(), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// SidecarPlugin 通过http json协议和独立进程的sidecar交互的插件
+//
+// 鉴权：开启`auth_enable`时，同步POST到`<url_prefix>/auth_pub`、`<url_prefix>/auth_sub`、`<url_prefix>/auth_hls`，
+// body分别为 base.PubStartInfo 、 base.SubStartInfo 、 base.HlsAuthInfo ，
+// sidecar返回 base.HttpResponseBasic ，`error_code`为0表示鉴权通过，请求失败或超时视为鉴权失败。
+//
+// 事件通知：开启`notify_enable`时，异步POST到`<url_prefix>/on_pub_start`等地址，格式和http notify相同。
+//
+type SidecarPlugin struct {
+	config   SidecarConfig
+	outbound *OutboundPool
+	notify   *HttpNotify
+}
+
+const defaultSidecarTimeoutMs = 1000
+
+// NewSidecarPlugin 使用默认配置创建自己的协程池发送请求，需要和其他模块共用协程池时使用 NewSidecarPluginWithOutbound
+//
+func NewSidecarPlugin(config SidecarConfig) *SidecarPlugin {
+	return NewSidecarPluginWithOutbound(config, nil)
+}
+
+// NewSidecarPluginWithOutbound
+//
+// @param outbound 鉴权、事件通知的http请求使用的协程池，由调用方负责创建和销毁，一般为lalserver的协程池。为nil时使用默认配置创建
+//
+func NewSidecarPluginWithOutbound(config SidecarConfig, outbound *OutboundPool) *SidecarPlugin {
+	if outbound == nil {
+		outbound = NewOutboundPool(OutboundConfig{})
+	}
+	config.UrlPrefix = strings.TrimSuffix(config.UrlPrefix, "/")
+	if config.TimeoutMs <= 0 {
+		config.TimeoutMs = defaultSidecarTimeoutMs
+	}
+	p := &SidecarPlugin{
+		config:   config,
+		outbound: outbound,
+	}
+	if config.NotifyEnable {
+		p.notify = NewHttpNotifyWithOutbound(HttpNotifyConfig{
+			Enable:        true,
+			OnServerStart: config.UrlPrefix + "/on_server_start",
+			OnServerStop:  config.UrlPrefix + "/on_server_stop",
+			OnHeartbeat:   config.UrlPrefix + "/on_heartbeat",
+			OnUpdate:      config.UrlPrefix + "/on_update",
+			OnPubStart:    config.UrlPrefix + "/on_pub_start",
+			OnPubStop:     config.UrlPrefix + "/on_pub_stop",
+			OnSubStart:    config.UrlPrefix + "/on_sub_start",
+			OnSubStop:     config.UrlPrefix + "/on_sub_stop",
+			OnRtmpConnect: config.UrlPrefix + "/on_rtmp_connect",
+
+			OnRelayPullStart: config.UrlPrefix + "/on_relay_pull_start",
+			OnRelayPullStop:  config.UrlPrefix + "/on_relay_pull_stop",
+			OnRelayPushStart: config.UrlPrefix + "/on_relay_push_start",
+			OnRelayPushStop:  config.UrlPrefix + "/on_relay_push_stop",
+			OnBitstreamError: config.UrlPrefix + "/on_bitstream_error",
+			OnBackupSwitch:   config.UrlPrefix + "/on_backup_switch",
+			OnViewerChange:   config.UrlPrefix + "/on_viewer_change",
+			OnPubViolation:   config.UrlPrefix + "/on_pub_violation",
+			OnSubReject:      config.UrlPrefix + "/on_sub_reject",
+		}, outbound)
+	}
+	return p
+}
+
+func (p *SidecarPlugin) Name() string {
+	return p.config.Name
+}
+
+func (p *SidecarPlugin) Authentication() IAuthentication {
+	if !p.config.AuthEnable {
+		return nil
+	}
+	return p
+}
+
+func (p *SidecarPlugin) NotifyHandler() INotifyHandler {
+	if p.notify == nil {
+		return nil
+	}
+	return p.notify
+}
+
+func (p *SidecarPlugin) StreamProcessor() IStreamProcessor {
+	return nil
+}
+
+func (p *SidecarPlugin) OnPubStart(info base.PubStartInfo) error {
+	return p.postAuth("/auth_pub", info)
+}
+
+func (p *SidecarPlugin) OnSubStart(info base.SubStartInfo) error {
+	return p.postAuth("/auth_sub", info)
+}
+
+func (p *SidecarPlugin) OnHls(streamName string, urlParam string) error {
+	return p.postAuth("/auth_hls", base.HlsAuthInfo{StreamName: streamName, UrlParam: urlParam})
+}
+
+func (p *SidecarPlugin) postAuth(path string, info interface{}) error {
+	body, err := p.outbound.PostSync(OutboundRequest{
+		Url:     p.config.UrlPrefix + path,
+		Info:    info,
+		Timeout: time.Duration(p.config.TimeoutMs) * time.Millisecond,
+	})
+	if err != nil {
+		return err
+	}
+	var v base.HttpResponseBasic
+	if err = json.Unmarshal(body, &v); err != nil {
+		return err
+	}
+	if v.ErrorCode != base.ErrorCodeSucc {
+		return fmt.Errorf("%w. error code=%d, desp=%s", base.ErrSidecarAuthDenied, v.ErrorCode, v.Desp)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+type testPlugin struct {
+	denyStream string
+}
+
+func (p *testPlugin) Name() string                      { return "test" }
+func (p *testPlugin) Authentication() IAuthentication   { return p }
+func (p *testPlugin) NotifyHandler() INotifyHandler     { return nil }
+func (p *testPlugin) StreamProcessor() IStreamProcessor { return nil }
+
+func (p *testPlugin) OnPubStart(info base.PubStartInfo) error {
+	if info.StreamName == p.denyStream {
+		return errors.New("denied")
+	}
+	return nil
+}
+func (p *testPlugin) OnSubStart(info base.SubStartInfo) error        { return nil }
+func (p *testPlugin) OnHls(streamName string, urlParam string) error { return nil }
+
+func TestPluginManager(t *testing.T) {
+	sidecar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var info base.PubStartInfo
+		_ = nazahttp.UnmarshalRequestJsonBody(r, &info)
+		if r.URL.Path == "/auth_pub" && info.StreamName == "b" {
+			feedback(base.HttpResponseBasic{ErrorCode: 403, Desp: "forbidden"}, w)
+			return
+		}
+		feedback(base.HttpResponseBasic{ErrorCode: base.ErrorCodeSucc, Desp: base.DespSucc}, w)
+	}))
+	defer sidecar.Close()
+
+	outbound := NewOutboundPool(OutboundConfig{})
+	defer outbound.Dispose()
+	pm := NewPluginManagerWithOutbound(PluginConfig{
+		Sidecars: []SidecarConfig{{Name: "sidecar", UrlPrefix: sidecar.URL + "/", AuthEnable: true}},
+	}, []IPlugin{&testPlugin{denyStream: "a"}}, NewSimpleAuthCtx(SimpleAuthConfig{}), nil, outbound)
+	auth := pm.Authentication()
+
+	pub := func(streamName string) error {
+		var info base.PubStartInfo
+		info.Protocol = base.ProtocolRtmp
+		info.StreamName = streamName
+		return auth.OnPubStart(info)
+	}
+	assert.Equal(t, nil, pub("c"))
+	assert.IsNotNil(t, pub("a"))
+	err := pub("b")
+	assert.Equal(t, true, errors.Is(err, base.ErrSidecarAuthDenied))
+	assert.Equal(t, nil, auth.OnHls("b", ""))
+}
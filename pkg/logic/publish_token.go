@@ -0,0 +1,200 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	defaultPublishTokenParam  = "lal_publish_token"
+	defaultPublishTokenTtlSec = 300
+	publishTokenRandomBytes   = 16
+)
+
+// PublishTokenAuth 一次性推流token，由http api（见 ServerManager.CtrlCreatePublishToken ）生成，
+// 在有效期内只能被一个推流session使用一次，用于用户自助开播等场景，不需要把长期有效的鉴权参数交给推流端
+//
+// 包装业务方的鉴权（默认为 SimpleAuthCtx ）：推流url中携带了token参数时只校验token，校验通过则不再调用`inner`；
+// 没有携带时，开启了`require_token`则拒绝推流，否则交给`inner`。拉流鉴权直接交给`inner`
+//
+// 校验分为两步：
+//   - 鉴权时（ OnPubStart ）只检查token是否存在以及是否过期，此时流名称可能还没有经过`stream_key`解析
+//   - 流名称解析完成后，加入group之前调用 Check 检查流名称是否匹配，加入group成功后调用 Consume 使token失效。
+//     被拒绝的推流（比如重复推流、script_hook拒绝）不消耗token
+//
+// 注意，token只保存在内存中，重启后失效
+//
+// 并发安全
+//
+type PublishTokenAuth struct {
+	config PublishTokenConfig
+	inner  IAuthentication
+
+	mutex  sync.Mutex
+	tokens map[string]*publishToken
+}
+
+type publishToken struct {
+	appName    string
+	streamName string
+	expireTime time.Time
+}
+
+func NewPublishTokenAuth(config PublishTokenConfig, inner IAuthentication) *PublishTokenAuth {
+	if config.TokenParam == "" {
+		config.TokenParam = defaultPublishTokenParam
+	}
+	if config.DefaultTtlSec <= 0 {
+		config.DefaultTtlSec = defaultPublishTokenTtlSec
+	}
+	return &PublishTokenAuth{
+		config: config,
+		inner:  inner,
+		tokens: make(map[string]*publishToken),
+	}
+}
+
+// Create 生成流`streamName`的一次性推流token
+//
+// @param ttl 为0则使用`default_ttl_sec`，超过`max_ttl_sec`时使用`max_ttl_sec`
+//
+func (a *PublishTokenAuth) Create(appName, streamName string, ttl time.Duration, now time.Time) (token string, expireTime time.Time, err error) {
+	if ttl <= 0 {
+		ttl = time.Duration(a.config.DefaultTtlSec) * time.Second
+	}
+	if a.config.MaxTtlSec > 0 && ttl > time.Duration(a.config.MaxTtlSec)*time.Second {
+		ttl = time.Duration(a.config.MaxTtlSec) * time.Second
+	}
+	b := make([]byte, publishTokenRandomBytes)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+	expireTime = now.Add(ttl)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	// 顺便清理过期的token
+	for k, v := range a.tokens {
+		if !now.Before(v.expireTime) {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[token] = &publishToken{
+		appName:    appName,
+		streamName: streamName,
+		expireTime: expireTime,
+	}
+	return
+}
+
+// PublishUrl 携带token的推流地址
+//
+// @param rtmpAddr 没有配置`url_prefix`时，使用rtmp的监听地址
+//
+func (a *PublishTokenAuth) PublishUrl(rtmpAddr, appName, streamName, token string) string {
+	prefix := a.config.UrlPrefix
+	if prefix == "" {
+		host, port, err := net.SplitHostPort(rtmpAddr)
+		if err != nil {
+			host, port = "", "1935"
+		}
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		prefix = fmt.Sprintf("rtmp://%s", net.JoinHostPort(host, port))
+	}
+	return fmt.Sprintf("%s/%s/%s?%s=%s", prefix, appName, streamName, a.config.TokenParam, token)
+}
+
+func (a *PublishTokenAuth) OnPubStart(info base.PubStartInfo) error {
+	token := a.token(info.UrlParam)
+	if token == "" {
+		if a.config.RequireToken {
+			return base.ErrPublishTokenNotFound
+		}
+		return a.inner.OnPubStart(info)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	_, err := a.lookup(token, time.Now())
+	return err
+}
+
+func (a *PublishTokenAuth) OnSubStart(info base.SubStartInfo) error {
+	return a.inner.OnSubStart(info)
+}
+
+func (a *PublishTokenAuth) OnHls(streamName string, urlParam string) error {
+	return a.inner.OnHls(streamName, urlParam)
+}
+
+// Check 使用解析后的流名称校验token，不使token失效。推流url中没有携带token时返回nil
+//
+// 注意，和 Consume 之间需要由调用方保证同一个token不会被并发使用（比如持有sm的锁）
+//
+func (a *PublishTokenAuth) Check(info base.SessionEventCommonInfo, now time.Time) error {
+	token := a.token(info.UrlParam)
+	if token == "" {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	t, err := a.lookup(token, now)
+	if err != nil {
+		return err
+	}
+	if t.streamName != info.StreamName || (t.appName != "" && t.appName != info.AppName) {
+		return fmt.Errorf("%w. stream mismatch. expect=%s/%s, actual=%s/%s",
+			base.ErrPublishTokenInvalid, t.appName, t.streamName, info.AppName, info.StreamName)
+	}
+	return nil
+}
+
+// Consume 推流成功加入group后调用，使推流url中携带的token失效
+//
+func (a *PublishTokenAuth) Consume(info base.SessionEventCommonInfo) {
+	token := a.token(info.UrlParam)
+	if token == "" {
+		return
+	}
+	a.mutex.Lock()
+	delete(a.tokens, token)
+	a.mutex.Unlock()
+}
+
+func (a *PublishTokenAuth) token(urlParam string) string {
+	q, _ := url.ParseQuery(urlParam)
+	return q.Get(a.config.TokenParam)
+}
+
+// lookup 注意，调用时需要持有锁
+//
+func (a *PublishTokenAuth) lookup(token string, now time.Time) (*publishToken, error) {
+	t, ok := a.tokens[token]
+	if !ok {
+		return nil, base.ErrPublishTokenInvalid
+	}
+	if !now.Before(t.expireTime) {
+		delete(a.tokens, token)
+		return nil, fmt.Errorf("%w. token expired", base.ErrPublishTokenInvalid)
+	}
+	return t, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPublishTokenAuth(t *testing.T) {
+	a := NewPublishTokenAuth(PublishTokenConfig{MaxTtlSec: 60}, &testPlugin{denyStream: "denied"})
+
+	// 模拟推流的流程：鉴权，stream_key解析为`streamName`，校验，加入group成功（`added`为true）后使token失效
+	pubResolved := func(appName, streamName, resolvedName, urlParam string, added bool) error {
+		var info base.PubStartInfo
+		info.Protocol = base.ProtocolRtmp
+		info.AppName = appName
+		info.StreamName = streamName
+		info.UrlParam = urlParam
+		if err := a.OnPubStart(info); err != nil {
+			return err
+		}
+		info.StreamName = resolvedName
+		if err := a.Check(info.SessionEventCommonInfo, time.Now()); err != nil {
+			return err
+		}
+		if added {
+			a.Consume(info.SessionEventCommonInfo)
+		}
+		return nil
+	}
+	pub := func(appName, streamName, urlParam string) error {
+		return pubResolved(appName, streamName, streamName, urlParam, true)
+	}
+
+	now := time.Now()
+	token, expireTime, err := a.Create("live", "test110", time.Hour, now)
+	assert.Equal(t, nil, err)
+	// 超过max_ttl_sec
+	assert.Equal(t, now.Add(time.Minute), expireTime)
+	assert.Equal(t, "rtmp://127.0.0.1:1935/live/test110?lal_publish_token="+token, a.PublishUrl(":1935", "live", "test110", token))
+
+	// 只能使用一次
+	assert.Equal(t, nil, pub("live", "test110", "lal_publish_token="+token))
+	assert.Equal(t, true, errors.Is(pub("live", "test110", "lal_publish_token="+token), base.ErrPublishTokenInvalid))
+
+	// 流不匹配时拒绝，不消耗token
+	token, _, _ = a.Create("live", "test110", 0, now)
+	assert.Equal(t, true, errors.Is(pub("live", "test111", "lal_publish_token="+token), base.ErrPublishTokenInvalid))
+	// 使用stream_key解析后的流名称校验
+	assert.Equal(t, true, errors.Is(pubResolved("live", "test110", "test111", "lal_publish_token="+token, true), base.ErrPublishTokenInvalid))
+	// 加入group失败（比如重复推流）不消耗token
+	assert.Equal(t, nil, pubResolved("live", "secret_key", "test110", "lal_publish_token="+token, false))
+	assert.Equal(t, nil, pubResolved("live", "secret_key", "test110", "lal_publish_token="+token, true))
+	assert.Equal(t, true, errors.Is(pub("live", "test110", "lal_publish_token="+token), base.ErrPublishTokenInvalid))
+
+	// 过期
+	token, _, _ = a.Create("", "test110", time.Millisecond, now.Add(-time.Second))
+	assert.Equal(t, true, errors.Is(pub("live", "test110", "lal_publish_token="+token), base.ErrPublishTokenInvalid))
+
+	// 没有携带token时交给inner
+	assert.Equal(t, nil, pub("live", "test110", ""))
+	assert.IsNotNil(t, pub("live", "denied", ""))
+
+	a = NewPublishTokenAuth(PublishTokenConfig{RequireToken: true}, &testPlugin{})
+	assert.Equal(t, true, errors.Is(pub("live", "test110", ""), base.ErrPublishTokenNotFound))
+}
+
+func TestServerManager_CreatePublishTokenWithStreamKey(t *testing.T) {
+	config := &Config{}
+	config.RtmpConfig.Addr = ":1935"
+	config.StreamKeyConfig.RejectUnknown = true
+	sm := &ServerManager{
+		config:       config,
+		streamKey:    NewStreamKeyResolver(StreamKeyConfig{Keys: []StreamKeyItem{{StreamKey: "k1", StreamName: "test110"}}, RejectUnknown: true}, nil),
+		publishToken: NewPublishTokenAuth(PublishTokenConfig{}, &testPlugin{}),
+	}
+
+	// 推流地址中使用密钥
+	ret := sm.CtrlCreatePublishToken(base.ApiCtrlCreatePublishTokenReq{AppName: "live", StreamName: "test110"})
+	assert.Equal(t, base.ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, "rtmp://127.0.0.1:1935/live/k1?lal_publish_token="+ret.Data.Token, ret.Data.Url)
+
+	// 使用该地址推流：密钥解析为公开的流名称后，token校验通过
+	var info base.PubStartInfo
+	info.Protocol = base.ProtocolRtmp
+	info.Url = ret.Data.Url
+	info.AppName = "live"
+	info.StreamName = "k1"
+	info.UrlParam = "lal_publish_token=" + ret.Data.Token
+	assert.Equal(t, nil, sm.publishToken.OnPubStart(info))
+	var session base.HttpSubSession
+	assert.Equal(t, nil, sm.resolveStreamKey(&info.SessionEventCommonInfo, &session))
+	assert.Equal(t, nil, sm.checkPublishToken(info.SessionEventCommonInfo))
+
+	// 没有密钥，并且开启了reject_unknown，生成的地址无法推流
+	ret = sm.CtrlCreatePublishToken(base.ApiCtrlCreatePublishTokenReq{AppName: "live", StreamName: "test111"})
+	assert.Equal(t, base.ErrorCodeParamInvalid, ret.ErrorCode)
+}
@@ -0,0 +1,58 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// WriteKeyring 写flv、mpegts录制文件时使用的密钥，没有开启加密时返回nil，见 base.FileKeyring
+//
+func (c *RecordEncryptConfig) WriteKeyring() *base.FileKeyring {
+	if !c.Enable {
+		return nil
+	}
+	return c.keyring
+}
+
+// HlsWriteKeyring 写hls ts切片时使用的密钥，没有开启加密时返回nil
+//
+func (c *RecordEncryptConfig) HlsWriteKeyring() *base.FileKeyring {
+	if !c.HlsEnable {
+		return nil
+	}
+	return c.keyring
+}
+
+// ReadKeyring 读取录制文件时使用的密钥，只要配置了主密钥就不为nil，用于解密之前加密的文件
+//
+func (c *RecordEncryptConfig) ReadKeyring() *base.FileKeyring {
+	return c.keyring
+}
+
+// releaseRecordKey 流的录制（flv、mpegts、hls）结束后调用，释放该流的数据密钥，避免keyring中的密钥随流的数量增长
+//
+func (group *Group) releaseRecordKey() {
+	group.config.RecordEncryptConfig.ReadKeyring().ReleaseStream(group.streamName)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *RecordEncryptConfig) compile() (err error) {
+	if c.MasterKey == "" {
+		if c.Enable || c.HlsEnable {
+			return fmt.Errorf("%w. master_key empty", base.ErrFileEncrypt)
+		}
+		return nil
+	}
+	c.keyring, err = base.NewFileKeyring(c.MasterKey)
+	return
+}
@@ -0,0 +1,246 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	LowDiskActionDeleteOldest = 1 // 磁盘空间不足时，删除最旧的文件
+	LowDiskActionStopRecord   = 2 // 磁盘空间不足时，暂停flv、mpegts录制
+)
+
+// RecordJanitor 按保留策略（最长保留时间、总大小、单条流大小、磁盘剩余空间），定时清理hls以及录制目录中的文件
+//
+type RecordJanitor struct {
+	config RecordRetentionConfig
+	dirs   []recordDir
+
+	mutex        sync.Mutex
+	recordPaused bool
+	stat         base.StatRecordRetention
+}
+
+type recordDir struct {
+	path        string
+	streamByDir bool // true表示一级子目录名为流名称（hls），false表示文件名为`streamName-xxx`（flv、mpegts录制）
+}
+
+type recordFile struct {
+	path       string
+	streamName string
+	size       int64
+	modTime    time.Time
+}
+
+func NewRecordJanitor(config RecordRetentionConfig) *RecordJanitor {
+	if config.CheckIntervalSec <= 0 {
+		config.CheckIntervalSec = defaultRecordRetentionCheckIntervalSec
+	}
+	return &RecordJanitor{
+		config: config,
+		stat: base.StatRecordRetention{
+			FreeDiskBytes: -1,
+		},
+	}
+}
+
+// AddDir 添加需要清理的目录，需在 RunLoop 之前调用
+//
+func (j *RecordJanitor) AddDir(path string, streamByDir bool) {
+	j.dirs = append(j.dirs, recordDir{
+		path:        path,
+		streamByDir: streamByDir,
+	})
+}
+
+func (j *RecordJanitor) RunLoop() {
+	t := time.NewTicker(time.Duration(j.config.CheckIntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		j.checkOnce(time.Now())
+		<-t.C
+	}
+}
+
+// IsRecordPaused 是否由于磁盘空间不足暂停了录制
+//
+func (j *RecordJanitor) IsRecordPaused() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.recordPaused
+}
+
+func (j *RecordJanitor) GetStat() base.StatRecordRetention {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	ret := j.stat
+	ret.RecordPaused = j.recordPaused
+	return ret
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (j *RecordJanitor) checkOnce(now time.Time) {
+	files := j.collectFiles()
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].modTime.Before(files[b].modTime)
+	})
+
+	var totalBytes int64
+	streamBytes := make(map[string]int64)
+	for _, f := range files {
+		totalBytes += f.size
+		streamBytes[f.streamName] += f.size
+	}
+
+	var reclaimedFiles, reclaimedBytes int64
+	deleted := make([]bool, len(files))
+	remove := func(i int, reason string) {
+		f := files[i]
+		if err := os.Remove(f.path); err != nil {
+			Log.Warnf("record retention remove file failed. file=%s, err=%+v", f.path, err)
+			return
+		}
+		Log.Infof("record retention remove file. reason=%s, file=%s, size=%d", reason, f.path, f.size)
+		deleted[i] = true
+		totalBytes -= f.size
+		streamBytes[f.streamName] -= f.size
+		reclaimedFiles++
+		reclaimedBytes += f.size
+	}
+	// 最近修改的文件可能是正在写入或正在被拉流的文件，除了过期清理，其他策略都不删除这些文件
+	canRemove := func(i int) bool {
+		return !deleted[i] && now.Sub(files[i].modTime) > recordRetentionProtectDuration
+	}
+
+	// 1. 超过最长保留时间的
+	if j.config.MaxAgeSec > 0 {
+		maxAge := time.Duration(j.config.MaxAgeSec) * time.Second
+		for i := range files {
+			if now.Sub(files[i].modTime) > maxAge {
+				remove(i, "max age")
+			}
+		}
+	}
+
+	// 2. 单条流超过大小限制的，从最旧的开始删
+	if j.config.MaxBytesPerStream > 0 {
+		for i := range files {
+			if streamBytes[files[i].streamName] > j.config.MaxBytesPerStream && canRemove(i) {
+				remove(i, "max bytes per stream")
+			}
+		}
+	}
+
+	// 3. 总大小超过限制的，从最旧的开始删
+	if j.config.MaxTotalBytes > 0 {
+		for i := range files {
+			if totalBytes <= j.config.MaxTotalBytes {
+				break
+			}
+			if canRemove(i) {
+				remove(i, "max total bytes")
+			}
+		}
+	}
+
+	// 4. 磁盘剩余空间不足的
+	freeDiskBytes := int64(-1)
+	if len(j.dirs) != 0 {
+		var err error
+		if freeDiskBytes, err = base.GetDiskFreeBytes(j.dirs[0].path); err != nil {
+			Log.Warnf("record retention get disk free bytes failed. path=%s, err=%+v", j.dirs[0].path, err)
+			freeDiskBytes = -1
+		}
+	}
+	recordPaused := false
+	if j.config.MinFreeDiskBytes > 0 && freeDiskBytes >= 0 && freeDiskBytes < j.config.MinFreeDiskBytes {
+		switch j.config.LowDiskAction {
+		case LowDiskActionStopRecord:
+			Log.Warnf("record retention low disk, pause record. free=%d, min=%d", freeDiskBytes, j.config.MinFreeDiskBytes)
+			recordPaused = true
+		default:
+			for i := range files {
+				if freeDiskBytes >= j.config.MinFreeDiskBytes {
+					break
+				}
+				if canRemove(i) {
+					remove(i, "low disk")
+					if deleted[i] {
+						freeDiskBytes += files[i].size
+					}
+				}
+			}
+		}
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.recordPaused != recordPaused {
+		Log.Infof("record retention record paused %v -> %v", j.recordPaused, recordPaused)
+	}
+	j.recordPaused = recordPaused
+	j.stat.LastCheckTime = now.Format("2006-01-02 15:04:05.999")
+	j.stat.TotalBytes = totalBytes
+	j.stat.FreeDiskBytes = freeDiskBytes
+	j.stat.ReclaimedFiles += reclaimedFiles
+	j.stat.ReclaimedBytes += reclaimedBytes
+}
+
+// 只处理ts、flv文件。m3u8文件很小，并且由hls模块自身管理
+func (j *RecordJanitor) collectFiles() []recordFile {
+	var files []recordFile
+	for _, dir := range j.dirs {
+		_ = filepath.Walk(dir.path, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if ext != ".ts" && ext != ".flv" {
+				return nil
+			}
+			files = append(files, recordFile{
+				path:       path,
+				streamName: recordStreamName(dir, path),
+				size:       info.Size(),
+				modTime:    info.ModTime(),
+			})
+			return nil
+		})
+	}
+	return files
+}
+
+func recordStreamName(dir recordDir, path string) string {
+	rel, err := filepath.Rel(dir.path, path)
+	if err != nil {
+		return ""
+	}
+	if dir.streamByDir {
+		items := strings.Split(filepath.ToSlash(rel), "/")
+		if len(items) > 1 {
+			return items[0]
+		}
+		return ""
+	}
+	name := filepath.Base(rel)
+	if pos := strings.LastIndex(name, "-"); pos != -1 {
+		return name[:pos]
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
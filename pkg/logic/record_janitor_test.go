@@ -0,0 +1,70 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRecordJanitor(t *testing.T) {
+	root, err := ioutil.TempDir("", "lal_record_janitor")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(root)
+
+	hlsDir := filepath.Join(root, "hls")
+	flvDir := filepath.Join(root, "flv")
+	now := time.Now()
+	write := func(path string, size int, age time.Duration) {
+		_ = os.MkdirAll(filepath.Dir(path), 0777)
+		assert.Equal(t, nil, ioutil.WriteFile(path, make([]byte, size), 0666))
+		assert.Equal(t, nil, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+	exist := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	write(filepath.Join(hlsDir, "a", "a-1.ts"), 100, 3*time.Hour)
+	write(filepath.Join(hlsDir, "a", "a-2.ts"), 100, 2*time.Hour)
+	write(filepath.Join(hlsDir, "a", "a-3.ts"), 100, time.Second) // 最近修改的
+	write(filepath.Join(hlsDir, "a", "playlist.m3u8"), 10, 3*time.Hour)
+	write(filepath.Join(flvDir, "b-c-1.flv"), 100, 5*time.Hour)
+	write(filepath.Join(flvDir, "b-c-2.flv"), 100, time.Hour)
+
+	j := NewRecordJanitor(RecordRetentionConfig{
+		MaxAgeSec:         4 * 3600,
+		MaxBytesPerStream: 150,
+	})
+	j.AddDir(hlsDir, true)
+	j.AddDir(flvDir, false)
+	j.checkOnce(now)
+
+	// 过期的
+	assert.Equal(t, false, exist(filepath.Join(flvDir, "b-c-1.flv")))
+	assert.Equal(t, true, exist(filepath.Join(flvDir, "b-c-2.flv")))
+	// 流a超过大小，删最旧的，直到不超过或者只剩下受保护的文件
+	assert.Equal(t, false, exist(filepath.Join(hlsDir, "a", "a-1.ts")))
+	assert.Equal(t, false, exist(filepath.Join(hlsDir, "a", "a-2.ts")))
+	assert.Equal(t, true, exist(filepath.Join(hlsDir, "a", "a-3.ts")))
+	assert.Equal(t, true, exist(filepath.Join(hlsDir, "a", "playlist.m3u8")))
+
+	stat := j.GetStat()
+	assert.Equal(t, int64(3), stat.ReclaimedFiles)
+	assert.Equal(t, int64(300), stat.ReclaimedBytes)
+	assert.Equal(t, int64(200), stat.TotalBytes)
+	assert.Equal(t, false, stat.RecordPaused)
+
+	assert.Equal(t, "b-c", recordStreamName(recordDir{path: flvDir}, filepath.Join(flvDir, "b-c-2.flv")))
+}
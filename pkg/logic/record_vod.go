@@ -0,0 +1,148 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+)
+
+// record_vod.go
+//
+// 录制的flv文件的点播，见 HttpflvConfig.RecordUrlPattern
+//
+// 文件内容使用 http.ServeContent 发送，支持Range请求，web播放器可以配合关键帧索引（见 StatRecordFlvKeyframes ）实现seek
+//
+// 和直播的httpflv拉流一样，执行地域访问策略以及拉流鉴权，见 checkRecordFlv
+//
+
+func (sm *ServerManager) serveRecordFlv(writer http.ResponseWriter, req *http.Request) {
+	sm.config.HttpflvConfig.HttpHeader.SetTo(writer.Header(), req.Header.Get("Origin"))
+	if req.Method == http.MethodOptions {
+		writer.Header().Set("Access-Control-Allow-Headers", "Range")
+		writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, sm.config.HttpflvConfig.RecordUrlPattern)
+	if !strings.HasSuffix(strings.ToLower(name), ".flv") {
+		http.NotFound(writer, req)
+		return
+	}
+	if err := sm.checkRecordFlv(name, base.ParseHttpRequest(req), req.URL.RawQuery, req.RemoteAddr); err != nil {
+		Log.Warnf("serve record flv denied. file=%s, remote=%s, err=%+v", name, req.RemoteAddr, err)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	filename := resolveFilePublishPath(sm.config.RecordConfig.FlvOutPath, name)
+	fi, err := os.Stat(filename)
+	if err != nil || fi.IsDir() {
+		http.NotFound(writer, req)
+		return
+	}
+	// 加密的录制文件，读取到的是解密后的内容，见 RecordEncryptConfig
+	fp, err := sm.config.RecordEncryptConfig.ReadKeyring().OpenFile(filename)
+	if err != nil {
+		Log.Warnf("serve record flv failed. file=%s, err=%+v", filename, err)
+		http.NotFound(writer, req)
+		return
+	}
+	defer fp.Close()
+
+	writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+	writer.Header().Set("Content-Type", "video/x-flv")
+	http.ServeContent(writer, req, fi.Name(), fi.ModTime(), fp)
+}
+
+// StatRecordFlvKeyframes 录制的flv文件的关键帧索引
+//
+// @param filename: 相对`record.flv_out_path`的路径，和点播url中`record_url_pattern`之后的部分相同
+//
+// @param rawUrl, urlParam, remoteAddr: 请求的信息，和点播一样用于地域访问策略以及鉴权，见 checkRecordFlv
+//
+func (sm *ServerManager) StatRecordFlvKeyframes(filename string, rawUrl string, urlParam string, remoteAddr string) (ret base.ApiStatRecordFlvKeyframes) {
+	if sm.config.HttpflvConfig.RecordUrlPattern == "" {
+		ret.ErrorCode = base.ErrorCodeNotEnabled
+		ret.Desp = base.DespNotEnabled
+		return
+	}
+	if err := sm.checkRecordFlv(filename, rawUrl, urlParam, remoteAddr); err != nil {
+		Log.Warnf("stat flv keyframe index denied. file=%s, remote=%s, err=%+v", filename, remoteAddr, err)
+		ret.ErrorCode = base.ErrorCodeParamInvalid
+		ret.Desp = err.Error()
+		return
+	}
+
+	items, durationMs, err := sm.readRecordFlvKeyframeIndex(resolveFilePublishPath(sm.config.RecordConfig.FlvOutPath, filename))
+	if err != nil {
+		Log.Warnf("read flv keyframe index failed. file=%s, err=%+v", filename, err)
+		ret.ErrorCode = base.ErrorCodeParamInvalid
+		ret.Desp = base.DespParamInvalid
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.Filename = filename
+	ret.Data.DurationMs = durationMs
+	ret.Data.Times = make([]float64, 0, len(items))
+	ret.Data.FilePositions = make([]int64, 0, len(items))
+	for _, item := range items {
+		ret.Data.Times = append(ret.Data.Times, float64(item.Timestamp)/1000)
+		ret.Data.FilePositions = append(ret.Data.FilePositions, item.Offset)
+	}
+	return
+}
+
+func (sm *ServerManager) readRecordFlvKeyframeIndex(filename string) ([]httpflv.KeyframeIndexItem, uint32, error) {
+	fp, err := sm.config.RecordEncryptConfig.ReadKeyring().OpenFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fp.Close()
+	return httpflv.ReadKeyframeIndexFrom(fp)
+}
+
+// checkRecordFlv 录制文件点播的地域访问策略以及拉流鉴权，和直播的httpflv拉流相同，流名称见 recordFlvStreamName
+//
+// 注意，不登记到 SubTokenRegistry ，点播的Range请求是同一个观看者的多个短连接，不能互相踢掉
+//
+func (sm *ServerManager) checkRecordFlv(name string, rawUrl string, urlParam string, remoteAddr string) error {
+	var info base.SubStartInfo
+	info.ServerId = sm.config.ServerId
+	info.Protocol = base.ProtocolHttpflv
+	info.Url = rawUrl
+	info.StreamName = recordFlvStreamName(name)
+	info.UrlParam = urlParam
+	info.RemoteAddr = remoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
+	return sm.option.Authentication.OnSubStart(info)
+}
+
+// recordFlvStreamName 录制文件名的格式为`{streamName}-{unix秒}.flv`，见 Group.startRecordFlvIfNeeded
+//
+func recordFlvStreamName(name string) string {
+	name = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if i := strings.LastIndexByte(name, '-'); i > 0 {
+		if _, err := strconv.ParseInt(name[i+1:], 10, 64); err == nil {
+			return name[:i]
+		}
+	}
+	return name
+}
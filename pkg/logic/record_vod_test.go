@@ -0,0 +1,35 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRecordFlvStreamName(t *testing.T) {
+	assert.Equal(t, "test110", recordFlvStreamName("test110-1666666666.flv"))
+	assert.Equal(t, "live-test110", recordFlvStreamName("live-test110-1666666666.flv"))
+	assert.Equal(t, "test110-a", recordFlvStreamName("test110-a.flv"))
+	assert.Equal(t, "test110", recordFlvStreamName("sub/test110-1666666666.flv"))
+}
+
+func TestCheckRecordFlv(t *testing.T) {
+	key := "q191201771"
+	sm := &ServerManager{
+		config:          &Config{},
+		option:          Option{Authentication: NewSimpleAuthCtx(SimpleAuthConfig{Key: key, SubHttpflvEnable: true})},
+		geoAccessPolicy: NewGeoAccessPolicy(nil),
+	}
+	rawUrl := "http://127.0.0.1:8080/record/test110-1666666666.flv"
+	assert.IsNotNil(t, sm.checkRecordFlv("test110-1666666666.flv", rawUrl, "", "1.2.3.4:5678"))
+	assert.IsNotNil(t, sm.checkRecordFlv("test110-1666666666.flv", rawUrl, "lal_secret="+SimpleAuthCalcSecret(key, "other"), "1.2.3.4:5678"))
+	assert.Equal(t, nil, sm.checkRecordFlv("test110-1666666666.flv", rawUrl, "lal_secret="+SimpleAuthCalcSecret(key, "test110"), "1.2.3.4:5678"))
+}
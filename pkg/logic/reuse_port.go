@@ -0,0 +1,154 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+)
+
+// reuse_port 多进程模式
+//
+// 启动`worker_num`个lalserver进程（worker），使用相同的配置文件，每个进程通过`-worker_index`指定不同的序号。
+// rtmp、rtsp、httpflv、httpts、hls的监听地址设置SO_REUSEPORT，由内核将新连接分配给其中一个worker，充分利用多核。
+//
+// 每路流按流名称的hash归属于一个worker（见 ReusePortConfig.OwnerOf ），hls、录制、配置中的回源和转推只在归属的worker上进行。
+// 其他worker上的同名流：
+//   - 有sub没有pub时，从归属的worker回源拉流
+//   - 有pub时，转推给归属的worker
+// worker之间通过`inner_rtmp_addr`转发，归属的worker上从该地址接入的session不做鉴权。
+//
+// 另外，每个worker上：
+//   - `server_id`后加上`-{worker_index}`，http notify中可以区分
+//   - http api、pprof使用配置的端口+worker_index，unix domain socket的路径后加上`.{worker_index}`
+//   - rtsp udp端口范围平均分配给各worker
+//   - rist、es_ingest、onvif、file_publish的自动频道、录制文件的清理，只在0号worker上运行
+//
+// 注意，hls切片由归属的worker写入，所有worker从磁盘读取，所以不能开启`hls.use_memory_as_disk_flag`
+//
+
+const reusePortInnerListenerTag = "worker"
+
+// OwnerOf 流`streamName`归属的worker序号
+//
+func (c *ReusePortConfig) OwnerOf(streamName string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(streamName))
+	return int(h.Sum32() % uint32(c.WorkerNum))
+}
+
+// InnerRtmpAddrOf 序号为`workerIndex`的worker的内部rtmp地址
+//
+func (c *ReusePortConfig) InnerRtmpAddrOf(workerIndex int) string {
+	return addrWithPortOffset(c.InnerRtmpAddr, workerIndex)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *ReusePortConfig) compile() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.WorkerNum < 1 {
+		return fmt.Errorf("worker_num invalid. worker_num=%d", c.WorkerNum)
+	}
+	if _, _, err := splitListenAddr(c.InnerRtmpAddr); err != nil {
+		return fmt.Errorf("inner_rtmp_addr invalid. inner_rtmp_addr=%s, err=%v", c.InnerRtmpAddr, err)
+	}
+	return nil
+}
+
+// applyReusePortWorker 根据当前worker的序号修改进程级别的配置，在确定了worker序号之后调用
+//
+func (c *Config) applyReusePortWorker() error {
+	rc := &c.ReusePortConfig
+	if !rc.Enable {
+		return nil
+	}
+	idx := rc.WorkerIndex
+	if idx < 0 || idx >= rc.WorkerNum {
+		return fmt.Errorf("worker_index invalid. worker_index=%d, worker_num=%d", idx, rc.WorkerNum)
+	}
+	if c.HlsConfig.UseMemoryAsDiskFlag {
+		return fmt.Errorf("hls.use_memory_as_disk_flag can not be used with reuse_port")
+	}
+
+	c.ServerId = fmt.Sprintf("%s-%d", c.ServerId, idx)
+
+	c.HttpApiConfig.Addr = addrWithPortOffset(c.HttpApiConfig.Addr, idx)
+	c.PprofConfig.Addr = addrWithPortOffset(c.PprofConfig.Addr, idx)
+	for _, p := range []*string{
+		&c.HttpApiConfig.UnixListenAddr,
+		&c.HttpflvConfig.UnixListenAddr,
+		&c.HttptsConfig.UnixListenAddr,
+		&c.HlsConfig.UnixListenAddr,
+	} {
+		if *p != "" {
+			*p = fmt.Sprintf("%s.%d", *p, idx)
+		}
+	}
+
+	if min, max := int(c.RtspConfig.UdpMinPort), int(c.RtspConfig.UdpMaxPort); min != 0 && max > min {
+		n := (max - min + 1) / rc.WorkerNum
+		if n < 2 {
+			return fmt.Errorf("rtsp udp port range too small for %d workers. udp_min_port=%d, udp_max_port=%d", rc.WorkerNum, min, max)
+		}
+		c.RtspConfig.UdpMinPort = uint16(min + n*idx)
+		c.RtspConfig.UdpMaxPort = uint16(min + n*(idx+1) - 1)
+	}
+
+	if idx != 0 {
+		c.RistConfig.Enable = false
+		c.EsIngestConfig.Enable = false
+		c.OnvifConfig.Enable = false
+		c.FilePublishConfig.Channels = nil
+		c.RecordRetentionConfig.Enable = false
+	}
+	return nil
+}
+
+// applyReusePortRelay 流归属于其他worker时，不做hls和录制，回源和转推都只和归属的worker进行
+//
+func (c *Config) applyReusePortRelay(owner int) {
+	addr := c.ReusePortConfig.InnerRtmpAddrOf(owner)
+
+	c.HlsConfig.Enable = false
+	c.HlsConfig.EnableHttps = false
+	c.RecordConfig.EnableFlv = false
+	c.RecordConfig.EnableMpegts = false
+
+	c.RelayPullConfig.Enable = true
+	c.RelayPullConfig.Addr = addr
+	c.RelayPullConfig.ProxyUrl = ""
+	c.RelayPushConfig.Enable = true
+	c.RelayPushConfig.AddrList = []string{addr}
+	c.RelayPushConfig.ProxyUrl = ""
+}
+
+// innerRtmpListenerConfig 当前worker的内部rtmp监听，不设置SO_REUSEPORT
+//
+func (c *Config) innerRtmpListenerConfig() ListenerConfig {
+	return ListenerConfig{
+		Addr:        c.ReusePortConfig.InnerRtmpAddrOf(c.ReusePortConfig.WorkerIndex),
+		Tag:         reusePortInnerListenerTag,
+		AuthDisable: true,
+	}
+}
+
+// addrWithPortOffset 将`addr`的端口加上`offset`，`addr`为空或者格式错误时原样返回
+//
+func addrWithPortOffset(addr string, offset int) string {
+	host, port, err := splitListenAddr(addr)
+	if err != nil || offset == 0 {
+		return addr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+offset))
+}
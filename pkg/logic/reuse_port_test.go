@@ -0,0 +1,69 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestReusePort(t *testing.T) {
+	config := &Config{}
+	config.ServerId = "1"
+	config.HlsConfig.Enable = true
+	config.RecordConfig.EnableFlv = true
+	config.RelayPushConfig.Enable = true
+	config.RelayPushConfig.AddrList = []string{"10.0.0.1:1935"}
+	config.HttpApiConfig.Addr = ":8083"
+	config.HttpApiConfig.UnixListenAddr = "/tmp/lal_api.sock"
+	config.RtspConfig.UdpMinPort = 30000
+	config.RtspConfig.UdpMaxPort = 30999
+	config.RistConfig.Enable = true
+	config.ReusePortConfig = ReusePortConfig{Enable: true, WorkerNum: 4, WorkerIndex: 2, InnerRtmpAddr: "127.0.0.1:29350"}
+	assert.Equal(t, nil, config.ReusePortConfig.compile())
+	assert.Equal(t, nil, config.applyReusePortWorker())
+
+	assert.Equal(t, "1-2", config.ServerId)
+	assert.Equal(t, ":8085", config.HttpApiConfig.Addr)
+	assert.Equal(t, "/tmp/lal_api.sock.2", config.HttpApiConfig.UnixListenAddr)
+	assert.Equal(t, uint16(30500), config.RtspConfig.UdpMinPort)
+	assert.Equal(t, uint16(30749), config.RtspConfig.UdpMaxPort)
+	assert.Equal(t, false, config.RistConfig.Enable)
+	assert.Equal(t, "127.0.0.1:29352", config.innerRtmpListenerConfig().Addr)
+
+	// 找到归属于当前worker和其他worker的流
+	var own, other string
+	for _, s := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		owner := config.ReusePortConfig.OwnerOf(s)
+		assert.Equal(t, owner, config.ReusePortConfig.OwnerOf(s))
+		if owner == 2 && own == "" {
+			own = s
+		} else if owner != 2 && other == "" {
+			other = s
+		}
+	}
+	assert.Equal(t, true, own != "" && other != "")
+
+	assert.Equal(t, config, config.ForStream(own))
+
+	c := config.ForStream(other)
+	addr := config.ReusePortConfig.InnerRtmpAddrOf(config.ReusePortConfig.OwnerOf(other))
+	assert.Equal(t, false, c.HlsConfig.Enable)
+	assert.Equal(t, false, c.RecordConfig.EnableFlv)
+	assert.Equal(t, true, c.RelayPullConfig.Enable)
+	assert.Equal(t, addr, c.RelayPullConfig.Addr)
+	assert.Equal(t, []string{addr}, c.RelayPushConfig.AddrList)
+	// 不影响原始配置
+	assert.Equal(t, true, config.HlsConfig.Enable)
+	assert.Equal(t, []string{"10.0.0.1:1935"}, config.RelayPushConfig.AddrList)
+
+	config.ReusePortConfig.WorkerIndex = 4
+	assert.IsNotNil(t, config.applyReusePortWorker())
+}
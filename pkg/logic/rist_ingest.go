@@ -0,0 +1,118 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rist"
+)
+
+// 超过该时长没有收到数据，认为推流结束
+const ristIngestIdleTimeoutMs = 5000
+
+// RistIngest 将一个地址上接收到的RIST输入流，通过customize pub session输入到对应的group中
+//
+// 收到数据时才创建pub session，超时没有数据时删除
+//
+type RistIngest struct {
+	sm     *ServerManager
+	config RistInputConfig
+
+	session *rist.PubSession
+
+	mutex   sync.Mutex
+	pubCtx  ICustomizePubSessionContext
+	remuxer *remux.Mpegts2AvPacketRemuxer
+	lastErr error
+}
+
+func NewRistIngest(sm *ServerManager, ristConfig RistConfig, config RistInputConfig) *RistIngest {
+	ri := &RistIngest{
+		sm:     sm,
+		config: config,
+	}
+	ri.session = rist.NewPubSession(config.Addr, ri.onTsPackets, func(option *rist.PubSessionOption) {
+		if ristConfig.BufferMs > 0 {
+			option.BufferMs = ristConfig.BufferMs
+		}
+		if ristConfig.ReorderWindow > 0 {
+			option.ReorderWindow = ristConfig.ReorderWindow
+		}
+	})
+	return ri
+}
+
+func (ri *RistIngest) Listen() error {
+	return ri.session.Listen()
+}
+
+func (ri *RistIngest) RunLoop() error {
+	return ri.session.RunLoop()
+}
+
+func (ri *RistIngest) Dispose() {
+	_ = ri.session.Dispose()
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+	ri.delPubSession()
+}
+
+// Tick 由ServerManager每秒调用，检查输入流是否已经结束
+//
+func (ri *RistIngest) Tick(now time.Time) {
+	// 注意，session持有自身的锁回调onTsPackets，所以需要在获取ri.mutex之前读取，避免加锁顺序相反导致死锁
+	lastActive := ri.session.LastActiveTime()
+
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+	if ri.pubCtx == nil {
+		return
+	}
+	if now.Sub(lastActive) > ristIngestIdleTimeoutMs*time.Millisecond {
+		Log.Infof("[%s] rist ingest idle timeout. stream=%s", ri.session.UniqueKey(), ri.config.StreamName)
+		ri.delPubSession()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (ri *RistIngest) onTsPackets(b []byte) {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	if ri.pubCtx == nil {
+		ctx, err := ri.sm.AddCustomizePubSession(ri.config.StreamName)
+		if err != nil {
+			// 比如流名称已经有其他输入，避免每个包都打印日志
+			if ri.lastErr == nil {
+				Log.Warnf("[%s] rist ingest add pub session failed. stream=%s, err=%+v", ri.session.UniqueKey(), ri.config.StreamName, err)
+			}
+			ri.lastErr = err
+			return
+		}
+		Log.Infof("[%s] rist ingest start. stream=%s, pub=%s", ri.session.UniqueKey(), ri.config.StreamName, ctx.UniqueKey())
+		ri.lastErr = nil
+		ri.pubCtx = ctx
+		ri.remuxer = remux.NewMpegts2AvPacketRemuxer(ctx)
+	}
+	ri.remuxer.FeedMpegts(b)
+}
+
+// delPubSession 注意，调用方需要持有锁
+func (ri *RistIngest) delPubSession() {
+	if ri.pubCtx == nil {
+		return
+	}
+	ri.sm.DelCustomizePubSession(ri.pubCtx)
+	ri.pubCtx = nil
+	ri.remuxer = nil
+}
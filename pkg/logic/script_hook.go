@@ -0,0 +1,419 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// ScriptHook 推流、拉流请求时执行的规则脚本，用于做放行、拒绝、重写流名称的决策，是外部鉴权服务的轻量级替代
+//
+// 脚本每行一条规则，从上往下匹配，使用第一条匹配的规则，都不匹配时放行。`#`开头的行为注释
+//
+// 规则格式：
+//   allow [if <条件>]
+//   deny [if <条件>]
+//   rewrite <新流名称模板> [if <条件>]
+//
+// 条件格式为`<变量> <操作符> <值>`，多个条件可以用`and`、`or`连接，`and`优先级高于`or`。各部分之间需要用空格分隔，
+// 值中包含空格时可以用双引号括起来
+//
+// 变量：type（pub、sub、hls），protocol（RTMP、RTSP、HTTP-FLV、HTTP-TS、HLS），app_name，stream_name，ip，
+//       param.<key>（url参数中key对应的值）
+//
+// 操作符：==，!=，~（正则匹配），!~（正则不匹配），in、!in（值为逗号分隔的列表，变量为ip时列表项可以是CIDR）
+//
+// 重写模板中可以使用${stream_name}、${app_name}、${ip}、${param.<key>}
+//
+// 例子：
+//   deny if ip in 10.0.0.0/8,192.168.1.1
+//   rewrite ${stream_name}_low if type == sub and param.quality == low
+//   deny if type == pub and stream_name !~ ^live_
+//
+// 脚本文件修改后，至多 scriptHookReloadInterval 后自动重新加载，加载失败时继续使用旧的规则
+//
+type ScriptHook struct {
+	config ScriptHookConfig
+
+	mutex         sync.Mutex
+	rules         []scriptRule
+	modTime       time.Time
+	lastCheckTime time.Time
+}
+
+type ScriptHookRequest struct {
+	Type       string // ScriptHookTypePub, ScriptHookTypeSub, ScriptHookTypeHls
+	Protocol   string
+	AppName    string
+	StreamName string
+	Ip         string
+	UrlParam   string
+}
+
+const (
+	ScriptHookTypePub = "pub"
+	ScriptHookTypeSub = "sub"
+	ScriptHookTypeHls = "hls"
+)
+
+const scriptHookReloadInterval = 5 * time.Second
+
+const (
+	scriptActionAllow   = "allow"
+	scriptActionDeny    = "deny"
+	scriptActionRewrite = "rewrite"
+)
+
+type scriptRule struct {
+	line     int
+	action   string
+	template string
+	orConds  [][]scriptCond // 外层为or，内层为and。为空时表示无条件匹配
+}
+
+type scriptCond struct {
+	name  string
+	op    string
+	value string
+
+	re   *regexp.Regexp
+	list []string
+	nets []*net.IPNet
+}
+
+var scriptTemplateRe = regexp.MustCompile(`\$\{([a-zA-Z0-9_.\-]+)\}`)
+
+func NewScriptHook(config ScriptHookConfig) *ScriptHook {
+	h := &ScriptHook{
+		config: config,
+	}
+	h.reloadIfNeeded(time.Now(), true)
+	return h
+}
+
+// Run 执行脚本
+//
+// @return streamName: 重写后的流名称，没有重写时为原流名称
+// @return err:        不为nil时表示拒绝该请求
+//
+func (h *ScriptHook) Run(req ScriptHookRequest) (streamName string, err error) {
+	h.reloadIfNeeded(time.Now(), false)
+
+	h.mutex.Lock()
+	rules := h.rules
+	h.mutex.Unlock()
+
+	return runScriptRules(rules, req)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (h *ScriptHook) reloadIfNeeded(now time.Time, force bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !force && now.Sub(h.lastCheckTime) < scriptHookReloadInterval {
+		return
+	}
+	h.lastCheckTime = now
+
+	fi, err := os.Stat(h.config.ScriptFile)
+	if err != nil {
+		Log.Errorf("stat script file failed. file=%s, err=%+v", h.config.ScriptFile, err)
+		return
+	}
+	if !force && fi.ModTime().Equal(h.modTime) {
+		return
+	}
+	h.modTime = fi.ModTime()
+
+	content, err := ioutil.ReadFile(h.config.ScriptFile)
+	if err != nil {
+		Log.Errorf("read script file failed. file=%s, err=%+v", h.config.ScriptFile, err)
+		return
+	}
+	rules, err := parseScript(string(content))
+	if err != nil {
+		Log.Errorf("parse script file failed, keep using old rules. file=%s, err=%+v", h.config.ScriptFile, err)
+		return
+	}
+	h.rules = rules
+	Log.Infof("load script file succ. file=%s, rules=%d", h.config.ScriptFile, len(rules))
+}
+
+func runScriptRules(rules []scriptRule, req ScriptHookRequest) (string, error) {
+	var params url.Values
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.match(req, &params) {
+			continue
+		}
+		switch rule.action {
+		case scriptActionDeny:
+			return req.StreamName, fmt.Errorf("%w. line=%d", base.ErrScriptHookDenied, rule.line)
+		case scriptActionRewrite:
+			streamName := scriptTemplateRe.ReplaceAllStringFunc(rule.template, func(s string) string {
+				return scriptVar(req, &params, s[2:len(s)-1])
+			})
+			return streamName, nil
+		}
+		return req.StreamName, nil
+	}
+	return req.StreamName, nil
+}
+
+func (r *scriptRule) match(req ScriptHookRequest, params *url.Values) bool {
+	if len(r.orConds) == 0 {
+		return true
+	}
+	for _, andConds := range r.orConds {
+		ok := true
+		for i := range andConds {
+			if !andConds[i].match(scriptVar(req, params, andConds[i].name)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *scriptCond) match(v string) bool {
+	switch c.op {
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	case "in":
+		return c.in(v)
+	case "!in":
+		return !c.in(v)
+	}
+	return false
+}
+
+func (c *scriptCond) in(v string) bool {
+	for _, item := range c.list {
+		if item == v {
+			return true
+		}
+	}
+	if len(c.nets) != 0 {
+		if ip := net.ParseIP(v); ip != nil {
+			for _, n := range c.nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// scriptVar 获取变量的值，url参数只在使用时解析一次
+func scriptVar(req ScriptHookRequest, params *url.Values, name string) string {
+	switch name {
+	case "type":
+		return req.Type
+	case "protocol":
+		return req.Protocol
+	case "app_name":
+		return req.AppName
+	case "stream_name":
+		return req.StreamName
+	case "ip":
+		return req.Ip
+	}
+	if strings.HasPrefix(name, "param.") {
+		if *params == nil {
+			*params, _ = url.ParseQuery(req.UrlParam)
+		}
+		return params.Get(strings.TrimPrefix(name, "param."))
+	}
+	return ""
+}
+
+func parseScript(content string) ([]scriptRule, error) {
+	var rules []scriptRule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseScriptRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w. line=%d, %s", base.ErrScriptHookSyntax, lineNo, err.Error())
+		}
+		rule.line = lineNo
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseScriptRule(line string) (rule scriptRule, err error) {
+	tokens, err := splitScriptTokens(line)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.action = tokens[0]
+	tokens = tokens[1:]
+	switch rule.action {
+	case scriptActionAllow, scriptActionDeny:
+	case scriptActionRewrite:
+		if len(tokens) == 0 || tokens[0] == "if" {
+			return rule, fmt.Errorf("rewrite template missing")
+		}
+		rule.template = tokens[0]
+		tokens = tokens[1:]
+	default:
+		return rule, fmt.Errorf("unknown action %s", rule.action)
+	}
+
+	if len(tokens) == 0 {
+		return rule, nil
+	}
+	if tokens[0] != "if" || len(tokens) == 1 {
+		return rule, fmt.Errorf("expect `if <condition>` after action")
+	}
+	tokens = tokens[1:]
+
+	var andConds []scriptCond
+	for {
+		if len(tokens) < 3 {
+			return rule, fmt.Errorf("incomplete condition")
+		}
+		cond, err := newScriptCond(tokens[0], tokens[1], tokens[2])
+		if err != nil {
+			return rule, err
+		}
+		andConds = append(andConds, cond)
+		tokens = tokens[3:]
+
+		if len(tokens) == 0 {
+			rule.orConds = append(rule.orConds, andConds)
+			return rule, nil
+		}
+		switch tokens[0] {
+		case "and":
+		case "or":
+			rule.orConds = append(rule.orConds, andConds)
+			andConds = nil
+		default:
+			return rule, fmt.Errorf("expect and/or, but got %s", tokens[0])
+		}
+		tokens = tokens[1:]
+	}
+}
+
+func newScriptCond(name, op, value string) (cond scriptCond, err error) {
+	switch name {
+	case "type", "protocol", "app_name", "stream_name", "ip":
+	default:
+		if !strings.HasPrefix(name, "param.") || name == "param." {
+			return cond, fmt.Errorf("unknown variable %s", name)
+		}
+	}
+
+	cond.name, cond.op, cond.value = name, op, value
+	switch op {
+	case "==", "!=":
+	case "~", "!~":
+		if cond.re, err = regexp.Compile(value); err != nil {
+			return cond, err
+		}
+	case "in", "!in":
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if name == "ip" && strings.Contains(item, "/") {
+				_, n, err := net.ParseCIDR(item)
+				if err != nil {
+					return cond, err
+				}
+				cond.nets = append(cond.nets, n)
+				continue
+			}
+			cond.list = append(cond.list, item)
+		}
+	default:
+		return cond, fmt.Errorf("unknown operator %s", op)
+	}
+	return cond, nil
+}
+
+// splitScriptTokens 按空白字符分割，双引号括起来的部分作为一个整体
+func splitScriptTokens(line string) ([]string, error) {
+	var tokens []string
+	var b strings.Builder
+	inQuote, hasToken := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasToken = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if hasToken {
+				tokens = append(tokens, b.String())
+				b.Reset()
+				hasToken = false
+			}
+		default:
+			b.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if hasToken {
+		tokens = append(tokens, b.String())
+	}
+	return tokens, nil
+}
+
+// newScriptHookRequest 由事件信息生成脚本的输入，其中ip从`ip:port`格式的RemoteAddr中解析
+//
+func newScriptHookRequest(typ string, info base.SessionEventCommonInfo) ScriptHookRequest {
+	ip := info.RemoteAddr
+	if host, _, err := net.SplitHostPort(info.RemoteAddr); err == nil {
+		ip = host
+	}
+	return ScriptHookRequest{
+		Type:       typ,
+		Protocol:   info.Protocol,
+		AppName:    info.AppName,
+		StreamName: info.StreamName,
+		Ip:         ip,
+		UrlParam:   info.UrlParam,
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRunScriptRules(t *testing.T) {
+	rules, err := parseScript(`
+# 注释
+deny if ip in 10.0.0.0/8,192.168.1.1
+rewrite ${stream_name}_low if type == sub and param.quality == low
+deny if type == pub and stream_name !~ ^live_ or param.token == "bad token"
+allow if app_name == live
+deny
+`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(rules))
+
+	run := func(req ScriptHookRequest) (string, bool) {
+		streamName, err := runScriptRules(rules, req)
+		if err != nil {
+			assert.Equal(t, true, errors.Is(err, base.ErrScriptHookDenied))
+			return streamName, false
+		}
+		return streamName, true
+	}
+
+	streamName, ok := run(ScriptHookRequest{Type: ScriptHookTypeSub, AppName: "live", StreamName: "live_a", Ip: "10.1.1.1"})
+	assert.Equal(t, false, ok)
+	_, ok = run(ScriptHookRequest{Type: ScriptHookTypeSub, AppName: "live", StreamName: "live_a", Ip: "192.168.1.1"})
+	assert.Equal(t, false, ok)
+
+	streamName, ok = run(ScriptHookRequest{Type: ScriptHookTypeSub, AppName: "live", StreamName: "live_a", Ip: "1.1.1.1", UrlParam: "quality=low"})
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "live_a_low", streamName)
+
+	_, ok = run(ScriptHookRequest{Type: ScriptHookTypePub, AppName: "live", StreamName: "a", Ip: "1.1.1.1"})
+	assert.Equal(t, false, ok)
+	_, ok = run(ScriptHookRequest{Type: ScriptHookTypePub, AppName: "live", StreamName: "live_a", Ip: "1.1.1.1", UrlParam: "token=bad+token"})
+	assert.Equal(t, false, ok)
+	streamName, ok = run(ScriptHookRequest{Type: ScriptHookTypePub, AppName: "live", StreamName: "live_a", Ip: "1.1.1.1"})
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "live_a", streamName)
+
+	_, ok = run(ScriptHookRequest{Type: ScriptHookTypeSub, AppName: "other", StreamName: "live_a", Ip: "1.1.1.1"})
+	assert.Equal(t, false, ok)
+}
+
+func TestParseScriptError(t *testing.T) {
+	for _, content := range []string{
+		"forbid",
+		"rewrite if type == pub",
+		"allow if type ==",
+		"allow if type = pub",
+		"allow if unknown == pub",
+		"allow if type == pub xor ip == 1.1.1.1",
+		"deny if stream_name ~ (",
+		"deny if ip in 10.0.0.0/33",
+		`deny if param.a == "b`,
+	} {
+		_, err := parseScript(content)
+		assert.Equal(t, true, errors.Is(err, base.ErrScriptHookSyntax), content)
+	}
+}
+
+func TestScriptHookReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_script_hook")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "hook.rules")
+
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("deny if stream_name == a"), 0666))
+	h := NewScriptHook(ScriptHookConfig{Enable: true, ScriptFile: filename})
+	_, err = h.Run(ScriptHookRequest{StreamName: "a"})
+	assert.Equal(t, true, errors.Is(err, base.ErrScriptHookDenied))
+
+	// 修改后重新加载
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("rewrite b if stream_name == a"), 0666))
+	future := time.Now().Add(time.Minute)
+	assert.Equal(t, nil, os.Chtimes(filename, future, future))
+	h.reloadIfNeeded(time.Now().Add(scriptHookReloadInterval), false)
+	streamName, err := h.Run(ScriptHookRequest{StreamName: "a"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "b", streamName)
+
+	// 语法错误时继续使用旧的规则
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("forbid"), 0666))
+	future = future.Add(time.Minute)
+	assert.Equal(t, nil, os.Chtimes(filename, future, future))
+	h.reloadIfNeeded(time.Now().Add(2*scriptHookReloadInterval), false)
+	streamName, err = h.Run(ScriptHookRequest{StreamName: "a"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "b", streamName)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/q191201771/naza/pkg/bininfo"
 	"github.com/q191201771/naza/pkg/defertaskthread"
 
+	"github.com/q191201771/lal/pkg/esingest"
 	"github.com/q191201771/lal/pkg/hls"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -52,10 +53,42 @@ type ServerManager struct {
 	pprofServer   *http.Server
 	exitChan      chan struct{}
 
-	mutex        sync.Mutex
-	groupManager IGroupManager
+	// 额外监听的地址，见 ListenerConfig
+	extraRtmpServers []*rtmp.Server
+	extraRtspServers []*rtsp.Server
 
-	simpleAuthCtx *SimpleAuthCtx
+	// 注意，加锁顺序为先mutex，再groupManager内部分片的锁
+	//
+	// groupManager本身并发安全，httpflv、httpts拉流的进出不持有mutex，只持有流所在分片的锁，见 doGroup 。
+	// 其他操作group的地方依然持有mutex，主循环中删除空闲group时同时持有两者
+	//
+	mutex        sync.Mutex
+	groupManager *ShardedGroupManager
+
+	recordJanitor *RecordJanitor
+	transcoder    *Transcoder
+	pluginManager *PluginManager
+	scriptHook    *ScriptHook
+	streamKey     *StreamKeyResolver
+	publishToken  *PublishTokenAuth
+	admission     *AdmissionController
+	statHistory   *StatHistory
+	streamEvents  *StreamEventLog
+	ristIngests   []*RistIngest
+	onvifPuller   *OnvifPuller
+
+	esIngestServer *esingest.Server
+	esPubSessions  map[*esingest.PubSession]ICustomizePubSessionContext
+
+	filePublishers  map[string]*filePublisher // key: stream name
+	backupPublisher *BackupPublisher
+	viewerNotifier  *ViewerNotifier
+	geoIp           *GeoIp
+	geoAccessPolicy *GeoAccessPolicy
+	connGuard       *base.ConnGuard
+	subTokens       *SubTokenRegistry
+	healthChecker   *HealthChecker
+	outbound        *OutboundPool
 }
 
 func NewServerManager(modOption ...ModOption) *ServerManager {
@@ -63,7 +96,9 @@ func NewServerManager(modOption ...ModOption) *ServerManager {
 		serverStartTime: base.ReadableNowTime(),
 		exitChan:        make(chan struct{}, 1),
 	}
-	sm.groupManager = NewSimpleGroupManager(sm)
+	sm.groupManager = NewShardedGroupManager(groupManagerShardNum, func() IGroupManager {
+		return NewSimpleGroupManager(sm)
+	})
 
 	sm.option = defaultOption
 	for _, fn := range modOption {
@@ -72,7 +107,7 @@ func NewServerManager(modOption ...ModOption) *ServerManager {
 
 	confFile := sm.option.ConfFilename
 	// 运行参数中没有配置文件，尝试从几个默认位置读取
-	if confFile == "" {
+	if confFile == "" && sm.option.ConfRawContent == nil {
 		nazalog.Warnf("config file did not specify in the command line, try to load it in the usual path.")
 		confFile = firstExistDefaultConfFilename()
 
@@ -91,13 +126,49 @@ Doc: %s
 			base.OsExitAndWaitPressIfWindows(1)
 		}
 	}
-	sm.config = LoadConfAndInitLog(confFile)
+	rawContent := sm.option.ConfRawContent
+	if rawContent == nil {
+		rawContent = readConfFile(confFile)
+	}
+	sm.config = loadConf(rawContent, !sm.option.SkipLogInit, false)
 	base.LogoutStartInfo()
 
+	if sm.option.WorkerIndex >= 0 {
+		sm.config.ReusePortConfig.WorkerIndex = sm.option.WorkerIndex
+	}
+	if err := sm.config.applyReusePortWorker(); err != nil {
+		Log.Errorf("config reuse_port invalid. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	if sm.config.ReusePortConfig.Enable {
+		Log.Infof("reuse port worker. worker_index=%d, worker_num=%d, server_id=%s",
+			sm.config.ReusePortConfig.WorkerIndex, sm.config.ReusePortConfig.WorkerNum, sm.config.ServerId)
+	}
+
+	if sm.config.TraceConfig.GlobalSessionId {
+		base.SetUniqueKeyPrefix(globalSessionIdPrefix(sm.config.ServerId))
+		Log.Infof("global session id prefix. prefix=%s", base.UniqueKeyPrefix())
+	}
+
 	if sm.config.HlsConfig.Enable && sm.config.HlsConfig.UseMemoryAsDiskFlag {
 		Log.Infof("hls use memory as disk.")
 		hls.SetUseMemoryAsDiskFlag(true)
 	}
+	if keyring := sm.config.RecordEncryptConfig.HlsWriteKeyring(); keyring != nil {
+		Log.Infof("hls encrypt ts fragment.")
+		hls.SetFileKeyring(keyring)
+	}
+
+	// 注意，rtmp包中是全局变量，需要在创建rtmp session之前设置
+	if sm.config.RtmpConfig.ChunkSize > 0 {
+		rtmp.LocalChunkSize = sm.config.RtmpConfig.ChunkSize
+	}
+	if sm.config.RtmpConfig.WindowAckSize > 0 {
+		rtmp.WindowAcknowledgementSize = sm.config.RtmpConfig.WindowAckSize
+	}
+	if sm.config.RtmpConfig.PeerBandwidth > 0 {
+		rtmp.PeerBandwidth = sm.config.RtmpConfig.PeerBandwidth
+	}
 
 	if sm.config.RecordConfig.EnableFlv {
 		if err := os.MkdirAll(sm.config.RecordConfig.FlvOutPath, 0777); err != nil {
@@ -111,23 +182,61 @@ Doc: %s
 		}
 	}
 
+	sm.outbound = NewOutboundPool(sm.config.OutboundConfig)
 	if sm.option.NotifyHandler == nil {
-		sm.option.NotifyHandler = NewHttpNotify(sm.config.HttpNotifyConfig)
+		sm.option.NotifyHandler = NewHttpNotifyWithOutbound(sm.config.HttpNotifyConfig, sm.outbound)
 	}
 
 	if sm.config.HttpflvConfig.Enable || sm.config.HttpflvConfig.EnableHttps ||
 		sm.config.HttptsConfig.Enable || sm.config.HttptsConfig.EnableHttps ||
 		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps {
 		sm.httpServerManager = base.NewHttpServerManager()
-		sm.httpServerHandler = NewHttpServerHandler(sm)
-		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath)
+		sm.httpServerHandler = NewHttpServerHandler(sm, func(option *HttpServerHandlerOption) {
+			option.HttpflvHeader = sm.config.HttpflvConfig.HttpHeader
+			option.HttptsHeader = sm.config.HttptsConfig.HttpHeader
+		})
+		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath, func(option *hls.ServerHandlerOption) {
+			option.HttpHeader = sm.config.HlsConfig.HttpHeader
+		})
+	}
+
+	if c := sm.config.ConnGuardConfig; c.Enable {
+		sm.connGuard = base.NewConnGuard(base.ConnGuardOption{
+			AcceptRate:         c.AcceptRate,
+			AcceptBurst:        c.AcceptBurst,
+			MaxConnsPerIp:      c.MaxConnsPerIp,
+			HandshakeTimeoutMs: c.HandshakeTimeoutMs,
+		})
 	}
 
 	if sm.config.RtmpConfig.Enable {
-		sm.rtmpServer = rtmp.NewServer(sm.config.RtmpConfig.Addr, sm)
+		sm.rtmpServer = rtmp.NewServer(sm.config.RtmpConfig.Addr, sm, func(option *rtmp.ServerOption) {
+			option.ProxyProtocolEnable = sm.config.RtmpConfig.ProxyProtocolEnable
+			option.ReusePort = sm.config.ReusePortConfig.Enable
+			option.ConnGuard = sm.connGuard
+			option.ProxyProtocolTrustedNets = sm.config.ProxyProtocolConfig.trustedNets
+		})
+		sm.extraRtmpServers = sm.newExtraRtmpServers(sm.config.RtmpConfig.ExtraListeners, sm.config.ReusePortConfig.Enable)
+	}
+	if sm.config.ReusePortConfig.Enable {
+		sm.extraRtmpServers = append(sm.extraRtmpServers, sm.newExtraRtmpServers([]ListenerConfig{sm.config.innerRtmpListenerConfig()}, false)...)
+	}
+	if sm.config.RtspConfig.UdpMinPort != 0 && sm.config.RtspConfig.UdpMaxPort > sm.config.RtspConfig.UdpMinPort {
+		rtsp.SetUdpPortRange(sm.config.RtspConfig.UdpMinPort, sm.config.RtspConfig.UdpMaxPort)
 	}
 	if sm.config.RtspConfig.Enable {
-		sm.rtspServer = rtsp.NewServer(sm.config.RtspConfig.Addr, sm)
+		sm.rtspServer = rtsp.NewServer(sm.config.RtspConfig.Addr, sm, func(option *rtsp.ServerOption) {
+			option.ProxyProtocolEnable = sm.config.RtspConfig.ProxyProtocolEnable
+			option.JitterBufferMs = sm.config.RtspConfig.JitterBufferMs
+			option.ReusePort = sm.config.ReusePortConfig.Enable
+			option.ConnGuard = sm.connGuard
+			option.ProxyProtocolTrustedNets = sm.config.ProxyProtocolConfig.trustedNets
+		})
+		sm.extraRtspServers = sm.newExtraRtspServers(sm.config.RtspConfig.ExtraListeners, sm.config.ReusePortConfig.Enable)
+	}
+	if sm.config.EsIngestConfig.Enable {
+		sm.esIngestServer = esingest.NewServer(sm.config.EsIngestConfig.Addr, sm)
+		sm.esPubSessions = make(map[*esingest.PubSession]ICustomizePubSessionContext)
 	}
 	if sm.config.HttpApiConfig.Enable {
 		sm.httpApiServer = NewHttpApiServer(sm.config.HttpApiConfig.Addr, sm)
@@ -137,7 +246,92 @@ Doc: %s
 		sm.pprofServer = &http.Server{Addr: sm.config.PprofConfig.Addr, Handler: nil}
 	}
 
-	sm.simpleAuthCtx = NewSimpleAuthCtx(sm.config.SimpleAuthConfig)
+	if sm.option.Authentication == nil {
+		sm.option.Authentication = NewSimpleAuthCtx(sm.config.SimpleAuthConfig, sm.config.StreamOverrides...)
+	}
+
+	if sm.config.PluginConfig.Enable {
+		sm.pluginManager = NewPluginManagerWithOutbound(sm.config.PluginConfig, sm.option.Plugins, sm.option.Authentication, sm.option.NotifyHandler, sm.outbound)
+		sm.option.Authentication = sm.pluginManager.Authentication()
+		sm.option.NotifyHandler = sm.pluginManager.NotifyHandler()
+	}
+
+	if sm.config.PublishTokenConfig.Enable {
+		sm.publishToken = NewPublishTokenAuth(sm.config.PublishTokenConfig, sm.option.Authentication)
+		sm.option.Authentication = sm.publishToken
+	}
+
+	if sm.config.StatHistoryConfig.Enable {
+		sm.statHistory = NewStatHistory(sm.config.StatHistoryConfig)
+	}
+
+	// 在http notify、插件之前记录事件，和是否配置了事件通知无关
+	sm.streamEvents = NewStreamEventLog(sm.config.StreamEventConfig)
+	if sm.streamEvents != nil {
+		sm.option.NotifyHandler = &streamEventNotifyHandler{INotifyHandler: sm.option.NotifyHandler, log: sm.streamEvents}
+	}
+
+	if sm.config.ScriptHookConfig.Enable {
+		sm.scriptHook = NewScriptHook(sm.config.ScriptHookConfig)
+	}
+
+	if sm.config.StreamKeyConfig.Enable {
+		sm.streamKey = NewStreamKeyResolver(sm.config.StreamKeyConfig, sm.outbound)
+	}
+
+	sm.admission = NewAdmissionController(sm.config.AdmissionConfig)
+
+	if sm.config.RecordRetentionConfig.Enable {
+		sm.recordJanitor = NewRecordJanitor(sm.config.RecordRetentionConfig)
+		if (sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps) && !sm.config.HlsConfig.UseMemoryAsDiskFlag {
+			sm.recordJanitor.AddDir(sm.config.HlsConfig.OutPath, true)
+		}
+		if sm.config.RecordConfig.EnableFlv {
+			sm.recordJanitor.AddDir(sm.config.RecordConfig.FlvOutPath, false)
+		}
+		if sm.config.RecordConfig.EnableMpegts {
+			sm.recordJanitor.AddDir(sm.config.RecordConfig.MpegtsOutPath, false)
+		}
+	}
+
+	if sm.config.RistConfig.Enable {
+		for _, c := range sm.config.RistConfig.Inputs {
+			sm.ristIngests = append(sm.ristIngests, NewRistIngest(sm, sm.config.RistConfig, c))
+		}
+	}
+
+	if sm.config.OnvifConfig.Enable {
+		sm.onvifPuller = NewOnvifPuller(sm, sm.config.OnvifConfig)
+	}
+
+	sm.filePublishers = make(map[string]*filePublisher)
+
+	if sm.config.BackupPublishConfig.Enable {
+		sm.backupPublisher = NewBackupPublisher(sm, sm.config.BackupPublishConfig)
+	}
+
+	if sm.config.ViewerNotifyConfig.Enable {
+		sm.viewerNotifier = NewViewerNotifier(sm.config.ViewerNotifyConfig, sm.config.ServerId, func(info base.ViewerChangeInfo) {
+			sm.option.NotifyHandler.OnViewerChange(info)
+		})
+	}
+
+	if sm.config.SimpleAuthConfig.SingleSubPerToken {
+		sm.subTokens = NewSubTokenRegistry(sm.config.SimpleAuthConfig.TokenParam)
+	}
+
+	if sm.config.GeoIpConfig.Enable {
+		sm.geoIp = NewGeoIp(sm.config.GeoIpConfig)
+		sm.geoAccessPolicy = NewGeoAccessPolicy(sm.config.GeoIpConfig.AccessPolicies)
+	}
+
+	if sm.config.TranscodeConfig.Enable {
+		sm.transcoder = NewTranscoder(sm.config.TranscodeConfig, sm.config.RtmpConfig.Addr, sm.config.SimpleAuthConfig)
+	}
+
+	if sm.config.HealthConfig.Enable {
+		sm.healthChecker = NewHealthChecker(sm, sm.config.HealthConfig)
+	}
 
 	return sm
 }
@@ -167,7 +361,7 @@ func (sm *ServerManager) RunLoop() error {
 	var addMux = func(config CommonHttpServerConfig, handler base.Handler, name string) error {
 		if config.Enable {
 			err := sm.httpServerManager.AddListen(
-				base.LocalAddrCtx{Addr: config.HttpListenAddr},
+				base.LocalAddrCtx{Addr: config.HttpListenAddr, ProxyProtocolEnable: config.ProxyProtocolEnable, ReusePort: sm.config.ReusePortConfig.Enable, ConnGuard: sm.connGuard, ProxyProtocolTrustedNets: sm.config.ProxyProtocolConfig.trustedNets},
 				config.UrlPattern,
 				handler,
 			)
@@ -179,7 +373,7 @@ func (sm *ServerManager) RunLoop() error {
 		}
 		if config.EnableHttps {
 			err := sm.httpServerManager.AddListen(
-				base.LocalAddrCtx{IsHttps: true, Addr: config.HttpsListenAddr, CertFile: config.HttpsCertFile, KeyFile: config.HttpsKeyFile},
+				base.LocalAddrCtx{IsHttps: true, Addr: config.HttpsListenAddr, CertFile: config.HttpsCertFile, KeyFile: config.HttpsKeyFile, TlsConfig: sm.config.TlsConfigOf(config.CommonHttpAddrConfig), ProxyProtocolEnable: config.ProxyProtocolEnable, ReusePort: sm.config.ReusePortConfig.Enable, ConnGuard: sm.connGuard, ProxyProtocolTrustedNets: sm.config.ProxyProtocolConfig.trustedNets},
 				config.UrlPattern,
 				handler,
 			)
@@ -189,6 +383,18 @@ func (sm *ServerManager) RunLoop() error {
 				Log.Infof("add https listen for %s. addr=%s, pattern=%s", name, config.HttpsListenAddr, config.UrlPattern)
 			}
 		}
+		if config.Enable && config.UnixListenAddr != "" {
+			err := sm.httpServerManager.AddListen(
+				base.LocalAddrCtx{Network: base.NetworkUnix, Addr: config.UnixListenAddr},
+				config.UrlPattern,
+				handler,
+			)
+			if err != nil {
+				Log.Errorf("add unix listen for %s failed. addr=%s, pattern=%s, err=%+v", name, config.UnixListenAddr, config.UrlPattern, err)
+				return err
+			}
+			Log.Infof("add unix listen for %s. addr=%s, pattern=%s", name, config.UnixListenAddr, config.UrlPattern)
+		}
 		return nil
 	}
 
@@ -201,6 +407,14 @@ func (sm *ServerManager) RunLoop() error {
 	if err := addMux(sm.config.HlsConfig.CommonHttpServerConfig, sm.serveHls, "hls"); err != nil {
 		return err
 	}
+	if pattern := sm.config.HttpflvConfig.RecordUrlPattern; pattern != "" {
+		// 和httpflv使用相同的监听地址
+		config := sm.config.HttpflvConfig.CommonHttpServerConfig
+		config.UrlPattern = pattern
+		if err := addMux(config, sm.serveRecordFlv, "record flv"); err != nil {
+			return err
+		}
+	}
 
 	if sm.httpServerManager != nil {
 		go func() {
@@ -232,6 +446,44 @@ func (sm *ServerManager) RunLoop() error {
 		}()
 	}
 
+	if sm.esIngestServer != nil {
+		if err := sm.esIngestServer.Listen(); err != nil {
+			return err
+		}
+		go func() {
+			if err := sm.esIngestServer.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}()
+	}
+
+	if err := sm.runExtraListeners(); err != nil {
+		return err
+	}
+
+	for _, ri := range sm.ristIngests {
+		if err := ri.Listen(); err != nil {
+			return err
+		}
+		go func(ri *RistIngest) {
+			if err := ri.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}(ri)
+	}
+
+	if sm.onvifPuller != nil {
+		go sm.onvifPuller.RunLoop()
+	}
+
+	if sm.config.FilePublishConfig.Enable {
+		sm.startFilePublishChannels()
+	}
+
+	if sm.backupPublisher != nil {
+		go sm.backupPublisher.RunLoop()
+	}
+
 	if sm.httpApiServer != nil {
 		if err := sm.httpApiServer.Listen(); err != nil {
 			return err
@@ -243,7 +495,28 @@ func (sm *ServerManager) RunLoop() error {
 		}()
 	}
 
+	if sm.config.HlsConfig.NtpServer != "" {
+		go sm.runNtpSyncLoop()
+	}
+
+	if sm.recordJanitor != nil {
+		go sm.recordJanitor.RunLoop()
+	}
+
+	if sm.healthChecker != nil {
+		sm.healthChecker.SetStarted()
+		go sm.healthChecker.RunLoop()
+	}
+
+	// 由systemd以`Type=notify`启动时，通知启动完成，watchdog的报活在主循环中发送，主循环卡住时由systemd重启
+	if err := base.SdNotify(base.SdNotifyReady); err != nil {
+		Log.Warnf("sd notify ready failed. err=%+v", err)
+	}
+	watchdogInterval := base.SdWatchdogInterval()
+	var lastWatchdogTime time.Time
+
 	uis := uint32(sm.config.HttpNotifyConfig.UpdateIntervalSec)
+	his := uint32(sm.config.HttpNotifyConfig.HeartbeatIntervalSec)
 	var updateInfo base.UpdateInfo
 	updateInfo.ServerId = sm.config.ServerId
 	updateInfo.Groups = sm.StatAllGroup()
@@ -261,7 +534,13 @@ func (sm *ServerManager) RunLoop() error {
 
 			sm.mutex.Lock()
 
+			var viewerCounts map[viewerStreamKey]int
+			if sm.viewerNotifier != nil {
+				viewerCounts = make(map[viewerStreamKey]int)
+			}
+
 			// 关闭空闲的group
+			// 注意，Iterate时持有group所在分片的锁，和 doGroup 中向group添加session互斥
 			sm.groupManager.Iterate(func(group *Group) bool {
 				if group.IsTotalEmpty() {
 					Log.Infof("erase empty group. [%s]", group.UniqueKey)
@@ -270,6 +549,9 @@ func (sm *ServerManager) RunLoop() error {
 				}
 
 				group.Tick(tickCount)
+				if viewerCounts != nil {
+					viewerCounts[viewerStreamKey{appName: group.appName, streamName: group.streamName}] = group.ViewerCount()
+				}
 				return true
 			})
 
@@ -292,12 +574,65 @@ func (sm *ServerManager) RunLoop() error {
 
 			sm.mutex.Unlock()
 
+			if sm.healthChecker != nil {
+				sm.healthChecker.Beat(time.Now())
+			}
+
+			if watchdogInterval > 0 && time.Since(lastWatchdogTime) >= watchdogInterval {
+				lastWatchdogTime = time.Now()
+				if err := base.SdNotify(base.SdNotifyWatchdog); err != nil {
+					Log.Warnf("sd notify watchdog failed. err=%+v", err)
+				}
+			}
+
+			if sm.viewerNotifier != nil {
+				sm.viewerNotifier.Check(time.Now(), viewerCounts)
+			}
+
+			// 注意，RistIngest内部会获取sm的锁，所以在释放锁之后调用
+			for _, ri := range sm.ristIngests {
+				ri.Tick(time.Now())
+			}
+
+			// 同一个tick中，以下多处使用所有group的stat，只在第一次使用时统计一次
+			var tickGroups []base.StatGroup
+			tickGroupsDone := false
+			statAllGroup := func() []base.StatGroup {
+				if !tickGroupsDone {
+					tickGroups = sm.StatAllGroup()
+					tickGroupsDone = true
+				}
+				return tickGroups
+			}
+
 			// 定时通过http notify发送group相关的信息
 			if uis != 0 && (tickCount%uis) == 0 {
 				updateInfo.ServerId = sm.config.ServerId
-				updateInfo.Groups = sm.StatAllGroup()
+				updateInfo.Groups = statAllGroup()
 				sm.option.NotifyHandler.OnUpdate(updateInfo)
 			}
+
+			// 定时发送节点心跳
+			if his != 0 && (tickCount%his) == 0 {
+				sm.option.NotifyHandler.OnHeartbeat(sm.statHeartbeat(statAllGroup()))
+			}
+
+			// 定时记录历史采样
+			if sm.statHistory != nil && tickCount%uint32(sm.statHistory.IntervalSec()) == 0 {
+				sm.statHistory.Sample(time.Now().Unix(), statAllGroup())
+			}
+
+			// 更新拉流准入控制使用的码率，并发送合并后的拒绝事件
+			if sm.admission != nil {
+				for _, info := range sm.admission.Update(statAllGroup(), time.Now()) {
+					sm.option.NotifyHandler.OnSubReject(info)
+				}
+			}
+
+			// 清理过期的流事件
+			if tickCount%60 == 0 {
+				sm.streamEvents.Cleanup(time.Now())
+			}
 		}
 	}
 
@@ -306,10 +641,40 @@ func (sm *ServerManager) RunLoop() error {
 
 func (sm *ServerManager) Dispose() {
 	Log.Debug("dispose server manager.")
+	_ = base.SdNotify(base.SdNotifyStopping)
+
+	// 最先通知，使得调度服务尽早不再往本节点调度
+	sm.option.NotifyHandler.OnServerStop(base.ServerStopInfo{
+		LalInfo:  sm.StatLalInfo(),
+		StopTime: base.ReadableNowTime(),
+	})
 
 	if sm.rtmpServer != nil {
 		sm.rtmpServer.Dispose()
 	}
+	for _, s := range sm.extraRtmpServers {
+		s.Dispose()
+	}
+	for _, s := range sm.extraRtspServers {
+		s.Dispose()
+	}
+	if sm.esIngestServer != nil {
+		sm.esIngestServer.Dispose()
+	}
+	for _, ri := range sm.ristIngests {
+		ri.Dispose()
+	}
+	if sm.onvifPuller != nil {
+		sm.onvifPuller.Dispose()
+	}
+	sm.mutex.Lock()
+	for _, p := range sm.filePublishers {
+		p.Stop()
+	}
+	sm.mutex.Unlock()
+	if sm.backupPublisher != nil {
+		sm.backupPublisher.Dispose()
+	}
 
 	if sm.httpServerManager != nil {
 		sm.httpServerManager.Dispose()
@@ -319,6 +684,10 @@ func (sm *ServerManager) Dispose() {
 		sm.pprofServer.Close()
 	}
 
+	if sm.transcoder != nil {
+		sm.transcoder.Dispose()
+	}
+
 	//if sm.hlsServer != nil {
 	//	sm.hlsServer.Dispose()
 	//}
@@ -330,6 +699,10 @@ func (sm *ServerManager) Dispose() {
 	})
 	sm.mutex.Unlock()
 
+	if sm.outbound != nil {
+		sm.outbound.Dispose()
+	}
+
 	sm.exitChan <- struct{}{}
 }
 
@@ -344,11 +717,48 @@ func (sm *ServerManager) StatLalInfo() base.LalInfo {
 	return lalInfo
 }
 
+// StatHeartbeat 节点心跳中负载的摘要
+//
+func (sm *ServerManager) StatHeartbeat() base.ServerHeartbeatInfo {
+	return sm.statHeartbeat(sm.StatAllGroup())
+}
+
+func (sm *ServerManager) statHeartbeat(groups []base.StatGroup) base.ServerHeartbeatInfo {
+	info := summarizeServerLoad(groups)
+	info.ServerId = sm.config.ServerId
+	info.StartTime = sm.serverStartTime
+	return info
+}
+
+// summarizeServerLoad 汇总所有group的stat，不包含ServerId等节点信息
+//
+func summarizeServerLoad(groups []base.StatGroup) (info base.ServerHeartbeatInfo) {
+	info.GroupNum = len(groups)
+	for i := range groups {
+		g := &groups[i]
+		if g.StatPub.SessionId != "" {
+			info.PubNum++
+			info.ReadBitrate += g.StatPub.ReadBitrate
+		}
+		if g.StatPull.SessionId != "" {
+			info.PullNum++
+			info.ReadBitrate += g.StatPull.ReadBitrate
+		}
+		info.ViewerNum += g.ViewerCount
+		for _, sub := range g.StatSubs {
+			info.WriteBitrate += sub.WriteBitrate
+		}
+	}
+	return
+}
+
 func (sm *ServerManager) StatAllGroup() (sgs []base.StatGroup) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	sm.groupManager.Iterate(func(group *Group) bool {
-		sgs = append(sgs, group.GetStat(math.MaxInt32))
+		sg := group.GetStat(math.MaxInt32)
+		sm.geoIp.FillStatGroup(&sg)
+		sgs = append(sgs, sg)
 		return true
 	})
 	return
@@ -364,35 +774,69 @@ func (sm *ServerManager) StatGroup(streamName string) *base.StatGroup {
 	// copy
 	var ret base.StatGroup
 	ret = g.GetStat(math.MaxInt32)
+	sm.geoIp.FillStatGroup(&ret)
 	return &ret
 }
-func (sm *ServerManager) CtrlStartPull(info base.ApiCtrlStartPullReq) {
+
+// StatSessionsByIp 在所有group中查找远端地址为`ip`的pub、sub session
+//
+func (sm *ServerManager) StatSessionsByIp(ip string) (ret []base.StatIpSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
-	g := sm.getGroup(info.AppName, info.StreamName)
+	sm.groupManager.Iterate(func(group *Group) bool {
+		ret = append(ret, group.StatSessionsByIp(ip)...)
+		return true
+	})
+	return
+}
+
+// CtrlStartPull
+//
+// 如果 info.WaitTimeoutMs 大于0，则等待回源拉流收到音视频数据后再返回，注意，等待期间不持有锁
+//
+func (sm *ServerManager) CtrlStartPull(info base.ApiCtrlStartPullReq) (ret base.ApiCtrlStartPullResp) {
+	g := sm.startPull(info)
 	if g == nil {
 		Log.Warnf("group not exist, ignore start pull. streamName=%s", info.StreamName)
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
 		return
 	}
-	var url string
-	if info.UrlParam != "" {
-		url = fmt.Sprintf("rtmp://%s/%s/%s?%s", info.Addr, info.AppName, info.StreamName, info.UrlParam)
-	} else {
-		url = fmt.Sprintf("rtmp://%s/%s/%s", info.Addr, info.AppName, info.StreamName)
+	if info.WaitTimeoutMs <= 0 {
+		ret.ErrorCode = base.ErrorCodeSucc
+		ret.Desp = base.DespSucc
+		return
+	}
+
+	sessionId, stat, ok := g.WaitPullAvData(time.Duration(info.WaitTimeoutMs) * time.Millisecond)
+	if !ok {
+		ret.ErrorCode = base.ErrorCodePullTimeout
+		ret.Desp = base.DespPullTimeout
+		return
 	}
-	g.StartPull(url)
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.SessionId = sessionId
+	ret.Data.AudioCodec = stat.AudioCodec
+	ret.Data.VideoCodec = stat.VideoCodec
+	ret.Data.VideoWidth = stat.VideoWidth
+	ret.Data.VideoHeight = stat.VideoHeight
+	return
 }
-func (sm *ServerManager) CtrlKickOutSession(info base.ApiCtrlKickOutSession) base.HttpResponseBasic {
+
+// CtrlStopPull 停止回源拉流，group中没有正在进行的拉流时返回 base.ErrorCodeSessionNotFound
+//
+func (sm *ServerManager) CtrlStopPull(info base.ApiCtrlStopPull) base.HttpResponseBasic {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
-	g := sm.getGroup("", info.StreamName)
+	g := sm.getGroup(info.AppName, info.StreamName)
 	if g == nil {
 		return base.HttpResponseBasic{
 			ErrorCode: base.ErrorCodeGroupNotFound,
 			Desp:      base.DespGroupNotFound,
 		}
 	}
-	if !g.KickOutSession(info.SessionId) {
+	if !g.StopPull() {
 		return base.HttpResponseBasic{
 			ErrorCode: base.ErrorCodeSessionNotFound,
 			Desp:      base.DespSessionNotFound,
@@ -404,74 +848,546 @@ func (sm *ServerManager) CtrlKickOutSession(info base.ApiCtrlKickOutSession) bas
 	}
 }
 
-func (sm *ServerManager) AddCustomizePubSession(streamName string) (ICustomizePubSessionContext, error) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	group := sm.getOrCreateGroup("", streamName)
-	return group.AddCustomizePubSession(streamName)
-}
+const (
+	defaultBatchMaxItems    = 100
+	defaultBatchConcurrency = 8
 
-func (sm *ServerManager) DelCustomizePubSession(sessionCtx ICustomizePubSessionContext) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	group := sm.getGroup("", sessionCtx.StreamName())
-	if group == nil {
+	// maxBatchWaitTimeoutMs 批量start_pull中单项`wait_timeout_ms`的上限，避免一次请求被大量等待拖住过久
+	maxBatchWaitTimeoutMs = 10000
+)
+
+// CtrlBatchStartPull 批量start_pull，缺少参数（包括`app_name`）的项返回 base.ErrorCodeParamMissing
+//
+// 项数超过`http_api.batch_max_items`时整个请求返回 base.ErrorCodeParamInvalid ，
+// 各项由`http_api.batch_concurrency`个协程并发处理，避免一次请求同时发起大量回源拉流，
+// 单项的`wait_timeout_ms`超过 maxBatchWaitTimeoutMs 时按 maxBatchWaitTimeoutMs 处理
+//
+func (sm *ServerManager) CtrlBatchStartPull(info base.ApiCtrlBatchStartPullReq) (ret base.ApiCtrlBatchStartPullResp) {
+	if !sm.checkBatchItemNum(len(info.Items), &ret.HttpResponseBasic) {
 		return
 	}
-	group.DelCustomizePubSession(sessionCtx)
-}
 
-// ----- implement rtmp.IServerObserver interface -----------------------------------------------------------------------
-
-func (sm *ServerManager) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.ObjectPairArray) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	items := make([]base.ApiCtrlStartPullReq, len(info.Items))
+	copy(items, info.Items)
+	ret.Data.Results = make([]base.ApiCtrlStartPullResp, len(items))
+	indexes := make(chan int, len(items))
+	for i, item := range items {
+		if item.Protocol == "" || item.Addr == "" || item.AppName == "" || item.StreamName == "" {
+			ret.Data.Results[i].ErrorCode = base.ErrorCodeParamMissing
+			ret.Data.Results[i].Desp = base.DespParamMissing
+			continue
+		}
+		if item.WaitTimeoutMs > maxBatchWaitTimeoutMs {
+			items[i].WaitTimeoutMs = maxBatchWaitTimeoutMs
+		}
+		indexes <- i
+	}
+	close(indexes)
 
-	var info base.RtmpConnectInfo
-	info.ServerId = sm.config.ServerId
-	info.SessionId = session.UniqueKey()
-	info.RemoteAddr = session.GetStat().RemoteAddr
-	if app, err := opa.FindString("app"); err == nil {
-		info.App = app
+	// 注意，需要在启动协程前取待处理的项数，协程启动后会并发消费`indexes`
+	n := len(indexes)
+	concurrency := sm.config.HttpApiConfig.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
 	}
-	if flashVer, err := opa.FindString("flashVer"); err == nil {
-		info.FlashVer = flashVer
+	if concurrency > n {
+		concurrency = n
 	}
-	if tcUrl, err := opa.FindString("tcUrl"); err == nil {
-		info.TcUrl = tcUrl
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				ret.Data.Results[i] = sm.CtrlStartPull(items[i])
+			}
+		}()
 	}
-	sm.option.NotifyHandler.OnRtmpConnect(info)
+	wg.Wait()
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
 }
 
-func (sm *ServerManager) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
+func (sm *ServerManager) CtrlKickOutSession(info base.ApiCtrlKickOutSession) base.HttpResponseBasic {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
-
-	// TODO chef: 每次赋值都逐个拼，代码冗余，考虑直接用ISession抽离一下代码
-	var info base.PubStartInfo
-	info.ServerId = sm.config.ServerId
-	info.Protocol = base.ProtocolRtmp
-	info.Url = session.Url()
-	info.AppName = session.AppName()
-	info.StreamName = session.StreamName()
-	info.UrlParam = session.RawQuery()
-	info.SessionId = session.UniqueKey()
-	info.RemoteAddr = session.GetStat().RemoteAddr
-
-	// 先做simple auth鉴权
-	if err := sm.simpleAuthCtx.OnPubStart(info); err != nil {
-		return err
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
 	}
-
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
-	if err := group.AddRtmpPubSession(session); err != nil {
-		return err
+	if !g.KickOutSession(info.SessionId) {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeSessionNotFound,
+			Desp:      base.DespSessionNotFound,
+		}
+	}
+	sm.streamEvents.Add(info.StreamName, base.StreamEvent{
+		Type:      StreamEventTypeKick,
+		SessionId: info.SessionId,
+		Detail:    "kick out session by http api",
+	}, time.Now())
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
 	}
+}
 
-	info.HasInSession = group.HasInSession()
-	info.HasOutSession = group.HasOutSession()
+// CtrlRotateStreamKey 更换推流密钥，见 StreamKeyResolver.Rotate
+//
+func (sm *ServerManager) CtrlRotateStreamKey(info base.ApiCtrlRotateStreamKeyReq) (ret base.ApiCtrlRotateStreamKeyResp) {
+	if sm.streamKey == nil {
+		ret.ErrorCode = base.ErrorCodeNotEnabled
+		ret.Desp = base.DespNotEnabled
+		return
+	}
+	key, err := sm.streamKey.Rotate(info.StreamName, info.StreamKey)
+	if err != nil {
+		Log.Warnf("rotate stream key failed. stream=%s, err=%+v", info.StreamName, err)
+		ret.ErrorCode = base.ErrorCodeParamInvalid
+		ret.Desp = err.Error()
+		return
+	}
+	Log.Infof("rotate stream key. stream=%s", info.StreamName)
+
+	if info.KickOutPub {
+		sm.mutex.Lock()
+		g := sm.getGroup("", info.StreamName)
+		sm.mutex.Unlock()
+		if g != nil {
+			if sessionId := g.GetStat(0).StatPub.SessionId; sessionId != "" {
+				g.KickOutSession(sessionId)
+			}
+		}
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.StreamName = info.StreamName
+	ret.Data.StreamKey = key
+	return
+}
+
+// CtrlCreatePublishToken 生成一次性推流token以及携带token的推流地址，见 PublishTokenAuth
+//
+func (sm *ServerManager) CtrlCreatePublishToken(info base.ApiCtrlCreatePublishTokenReq) (ret base.ApiCtrlCreatePublishTokenResp) {
+	if sm.publishToken == nil {
+		ret.ErrorCode = base.ErrorCodeNotEnabled
+		ret.Desp = base.DespNotEnabled
+		return
+	}
+	// 开启`stream_key`时，公开的流名称不能用来推流，推流地址中使用密钥。token仍然绑定公开的流名称，见 checkPublishToken
+	urlStreamName := info.StreamName
+	if sm.streamKey != nil {
+		if key, ok := sm.streamKey.KeyOf(info.StreamName); ok {
+			urlStreamName = key
+		} else if sm.config.StreamKeyConfig.RejectUnknown {
+			ret.ErrorCode = base.ErrorCodeParamInvalid
+			ret.Desp = fmt.Sprintf("%s. stream key of stream not found. stream=%s", base.DespParamInvalid, info.StreamName)
+			return
+		}
+	}
+	token, expireTime, err := sm.publishToken.Create(info.AppName, info.StreamName, time.Duration(info.TtlSec)*time.Second, time.Now())
+	if err != nil {
+		Log.Errorf("create publish token failed. err=%+v", err)
+		ret.ErrorCode = base.ErrorCodeParamInvalid
+		ret.Desp = err.Error()
+		return
+	}
+	// 不校验app name时，推流地址中使用live
+	appName := info.AppName
+	if appName == "" {
+		appName = "live"
+	}
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.Token = token
+	ret.Data.Url = sm.publishToken.PublishUrl(sm.config.RtmpConfig.Addr, appName, urlStreamName, token)
+	ret.Data.ExpireTime = expireTime.Format("2006-01-02 15:04:05.999")
+	return
+}
+
+// CtrlBatchKickOutSession 批量踢出session，每一项的处理同 CtrlKickOutSession ，结果按请求中的顺序返回
+//
+// 项数超过`http_api.batch_max_items`时整个请求返回 base.ErrorCodeParamInvalid
+//
+func (sm *ServerManager) CtrlBatchKickOutSession(info base.ApiCtrlBatchKickOutSessionReq) (ret base.ApiCtrlBatchKickOutSessionResp) {
+	if !sm.checkBatchItemNum(len(info.Items), &ret.HttpResponseBasic) {
+		return
+	}
+
+	ret.Data.Results = make([]base.HttpResponseBasic, len(info.Items))
+	for i, item := range info.Items {
+		if item.StreamName == "" || item.SessionId == "" {
+			ret.Data.Results[i].ErrorCode = base.ErrorCodeParamMissing
+			ret.Data.Results[i].Desp = base.DespParamMissing
+			continue
+		}
+		ret.Data.Results[i] = sm.CtrlKickOutSession(item)
+	}
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+// checkBatchItemNum 批量接口的项数超过`http_api.batch_max_items`时，填充`ret`并返回false
+//
+func (sm *ServerManager) checkBatchItemNum(n int, ret *base.HttpResponseBasic) bool {
+	maxItems := sm.config.HttpApiConfig.BatchMaxItems
+	if maxItems <= 0 {
+		maxItems = defaultBatchMaxItems
+	}
+	if n <= maxItems {
+		return true
+	}
+	ret.ErrorCode = base.ErrorCodeParamInvalid
+	ret.Desp = fmt.Sprintf("%s. too many items. num=%d, max=%d", base.DespParamInvalid, n, maxItems)
+	return false
+}
+
+// CtrlKickByIp 关闭所有group中远端地址为`ip`的pub、sub session
+//
+func (sm *ServerManager) CtrlKickByIp(info base.ApiCtrlKickByIp) (ret base.ApiCtrlKickByIpResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.groupManager.Iterate(func(group *Group) bool {
+		n := group.KickOutSessionsByIp(info.Ip)
+		if n > 0 {
+			sm.streamEvents.Add(group.streamName, base.StreamEvent{
+				Type:   StreamEventTypeKick,
+				Detail: fmt.Sprintf("kick out %d session(s) by ip %s by http api", n, info.Ip),
+			}, time.Now())
+		}
+		ret.Data.KickedCount += n
+		return true
+	})
+	if ret.Data.KickedCount == 0 {
+		ret.ErrorCode = base.ErrorCodeSessionNotFound
+		ret.Desp = base.DespSessionNotFound
+		return
+	}
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) CtrlTagSession(info base.ApiCtrlTagSession) base.HttpResponseBasic {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
+	}
+	if !g.TagSession(info.SessionId, info.Tags) {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeSessionNotFound,
+			Desp:      base.DespSessionNotFound,
+		}
+	}
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) CtrlSetSubtitle(info base.ApiCtrlSetSubtitle) base.HttpResponseBasic {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
+	}
+	tracks := make([]hls.SubtitleTrack, len(info.Tracks))
+	for i, t := range info.Tracks {
+		tracks[i] = hls.SubtitleTrack{
+			Name:     t.Name,
+			Language: t.Language,
+			Uri:      t.Uri,
+			Default:  t.Default,
+		}
+		if err := tracks[i].Check(); err != nil {
+			return base.HttpResponseBasic{
+				ErrorCode: base.ErrorCodeParamInvalid,
+				Desp:      err.Error(),
+			}
+		}
+	}
+	g.SetSubtitleTracks(tracks)
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) CtrlSetMetadata(info base.ApiCtrlSetMetadata) base.HttpResponseBasic {
+	for _, v := range info.Fields {
+		switch v.(type) {
+		case nil, float64, string, bool:
+		default:
+			return base.HttpResponseBasic{
+				ErrorCode: base.ErrorCodeParamInvalid,
+				Desp:      base.DespParamInvalid,
+			}
+		}
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
+	}
+	g.SetMetadataOverride(info.Fields, info.Reset)
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) CtrlInjectData(info base.ApiCtrlInjectData) base.HttpResponseBasic {
+	// onMetaData通过set_metadata修改，注入的话会覆盖gop缓存中的metadata
+	if info.Name == "" || info.Name == "onMetaData" || info.Name == "@setDataFrame" || !isValidDataMessageData(info.Data) {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeParamInvalid,
+			Desp:      base.DespParamInvalid,
+		}
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
+	}
+	g.InjectDataMessage(info.Name, info.Data)
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) CtrlStartFilePublish(info base.ApiCtrlStartFilePublishReq) (ret base.ApiCtrlStartFilePublishResp) {
+	if !sm.config.FilePublishConfig.Enable {
+		ret.ErrorCode = base.ErrorCodeNotEnabled
+		ret.Desp = base.DespNotEnabled
+		return
+	}
+
+	// 提前打开一次文件，在api中直接返回文件不存在、格式不支持等错误
+	filename := resolveFilePublishPath(sm.config.FilePublishConfig.RootDir, info.Filename)
+	src, err := openFileSource(filename)
+	if err != nil {
+		Log.Warnf("start file publish failed. file=%s, err=%+v", filename, err)
+		ret.ErrorCode = base.ErrorCodeParamInvalid
+		ret.Desp = base.DespParamInvalid
+		return
+	}
+	src.Dispose()
+
+	p, err := sm.startFilePublish(info.StreamName, []string{filename}, info.Loop, info.SeekMs)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeInStreamExist
+		ret.Desp = base.DespInStreamExist
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.SessionId = p.pubCtx.UniqueKey()
+	return
+}
+
+func (sm *ServerManager) CtrlStopFilePublish(info base.ApiCtrlStopFilePublish) base.HttpResponseBasic {
+	sm.mutex.Lock()
+	p, ok := sm.filePublishers[info.StreamName]
+	sm.mutex.Unlock()
+	if !ok {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeSessionNotFound,
+			Desp:      base.DespSessionNotFound,
+		}
+	}
+	p.Stop()
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) CtrlSwitchSource(info base.ApiCtrlSwitchSource) base.HttpResponseBasic {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		return base.HttpResponseBasic{
+			ErrorCode: base.ErrorCodeGroupNotFound,
+			Desp:      base.DespGroupNotFound,
+		}
+	}
+
+	var source *Group
+	if info.SourceStreamName != "" && info.SourceStreamName != info.StreamName {
+		source = sm.getGroup("", info.SourceStreamName)
+		if source == nil || !source.HasInSession() {
+			return base.HttpResponseBasic{
+				ErrorCode: base.ErrorCodeGroupNotFound,
+				Desp:      base.DespGroupNotFound,
+			}
+		}
+		// 输入源不能直接或间接的以本group为输入源
+		if isSwitchSourceLoop(g, source) {
+			return base.HttpResponseBasic{
+				ErrorCode: base.ErrorCodeParamInvalid,
+				Desp:      base.DespParamInvalid,
+			}
+		}
+	}
+
+	if prev := g.SwitchSource(source); prev != nil {
+		prev.DelRtmpTap(g)
+	}
+	if source != nil {
+		source.AddRtmpTap(g)
+	}
+	return base.HttpResponseBasic{
+		ErrorCode: base.ErrorCodeSucc,
+		Desp:      base.DespSucc,
+	}
+}
+
+func (sm *ServerManager) AddCustomizePubSession(streamName string) (ICustomizePubSessionContext, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getOrCreateGroup("", streamName)
+	return group.AddCustomizePubSession(streamName)
+}
+
+func (sm *ServerManager) DelCustomizePubSession(sessionCtx ICustomizePubSessionContext) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup("", sessionCtx.StreamName())
+	if group == nil {
+		return
+	}
+	group.DelCustomizePubSession(sessionCtx)
+}
+
+func (sm *ServerManager) AddCustomizeSubSession(streamName string, handler ICustomizeSubHandler) (ICustomizeSubSessionContext, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getOrCreateGroup("", streamName)
+	return group.AddCustomizeSubSession(streamName, handler), nil
+}
+
+func (sm *ServerManager) DelCustomizeSubSession(sessionCtx ICustomizeSubSessionContext) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup("", sessionCtx.StreamName())
+	if group == nil {
+		return
+	}
+	group.DelCustomizeSubSession(sessionCtx)
+}
+
+// ----- implement rtmp.IServerObserver interface -----------------------------------------------------------------------
+
+func (sm *ServerManager) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.ObjectPairArray) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	var info base.RtmpConnectInfo
+	info.ServerId = sm.config.ServerId
+	info.SessionId = session.UniqueKey()
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	if app, err := opa.FindString("app"); err == nil {
+		info.App = app
+	}
+	if flashVer, err := opa.FindString("flashVer"); err == nil {
+		info.FlashVer = flashVer
+	}
+	if tcUrl, err := opa.FindString("tcUrl"); err == nil {
+		info.TcUrl = tcUrl
+	}
+	sm.option.NotifyHandler.OnRtmpConnect(info)
+}
+
+func (sm *ServerManager) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
+	return sm.onNewRtmpPubSession(session, nil)
+}
+
+func (sm *ServerManager) onNewRtmpPubSession(session *rtmp.ServerSession, listener *ListenerConfig) error {
+	// TODO chef: 每次赋值都逐个拼，代码冗余，考虑直接用ISession抽离一下代码
+	var info base.PubStartInfo
+	info.ServerId = sm.config.ServerId
+	info.Protocol = base.ProtocolRtmp
+	info.Url = session.Url()
+	info.AppName = session.AppName()
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.SessionId = session.UniqueKey()
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckPub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
+
+	// 先做鉴权。鉴权、流名称解析可能请求外部服务，不持有sm的锁
+	if listener.needAuth() {
+		if err := sm.option.Authentication.OnPubStart(info); err != nil {
+			return err
+		}
+	}
+	if err := sm.resolveStreamKey(&info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+	if err := sm.runScriptHook(ScriptHookTypePub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if listener.needAuth() {
+		if err := sm.checkPublishToken(info.SessionEventCommonInfo); err != nil {
+			return err
+		}
+	}
+	if sm.backupPublisher != nil {
+		sm.backupPublisher.ApplyRole(&info.SessionEventCommonInfo, session)
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	if err := group.AddRtmpPubSession(session); err != nil {
+		return err
+	}
+	sm.consumePublishToken(info.SessionEventCommonInfo)
+	listener.tagSession(group, info.SessionId)
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
 
 	sm.option.NotifyHandler.OnPubStart(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStart(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStart(group, info.StreamName)
+	}
 	return nil
 }
 
@@ -483,26 +1399,36 @@ func (sm *ServerManager) OnDelRtmpPubSession(session *rtmp.ServerSession) {
 		return
 	}
 
+	tags := group.GetSessionTags(session.UniqueKey())
 	group.DelRtmpPubSession(session)
 
 	var info base.PubStopInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtmp
-	info.Url = session.Url()
+	info.Url = sm.pubSessionUrl(session.Url(), session.StreamName())
 	info.AppName = session.AppName()
 	info.StreamName = session.StreamName()
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
+	info.Tags = tags
 	sm.option.NotifyHandler.OnPubStop(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStop(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStop(group, info.StreamName)
+	}
 }
 
 func (sm *ServerManager) OnNewRtmpSubSession(session *rtmp.ServerSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	return sm.onNewRtmpSubSession(session, nil)
+}
 
+func (sm *ServerManager) onNewRtmpSubSession(session *rtmp.ServerSession, listener *ListenerConfig) error {
 	var info base.SubStartInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtmp
@@ -512,17 +1438,40 @@ func (sm *ServerManager) OnNewRtmpSubSession(session *rtmp.ServerSession) error
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
+
+	// 鉴权、脚本可能请求外部服务或者执行较慢，不持有sm的锁
+	if listener.needAuth() {
+		if err := sm.option.Authentication.OnSubStart(info); err != nil {
+			return err
+		}
+	}
+	if err := sm.runScriptHook(ScriptHookTypeSub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 
-	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+	if err := sm.checkAdmission(info.SessionEventCommonInfo); err != nil {
+		_ = session.DisposeWithStatus(rtmp.OnStatusCodePlayFailed, "egress bandwidth budget exceeded")
 		return err
 	}
 
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddRtmpSubSession(session)
+	listener.tagSession(group, info.SessionId)
+	if sm.transcoder.IsInternalSub(info.UrlParam) {
+		group.MarkInternalSub(info.SessionId, internalSubTranscode)
+	}
 
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 
+	sm.subTokens.OnSubStart(info.SessionEventCommonInfo, session)
 	sm.option.NotifyHandler.OnSubStart(info)
 	return nil
 }
@@ -535,27 +1484,31 @@ func (sm *ServerManager) OnDelRtmpSubSession(session *rtmp.ServerSession) {
 		return
 	}
 
+	tags := group.GetSessionTags(session.UniqueKey())
 	group.DelRtmpSubSession(session)
 
 	var info base.SubStopInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtmp
+	info.Url = session.Url()
 	info.AppName = session.AppName()
 	info.StreamName = session.StreamName()
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
+	info.Tags = tags
+	sm.subTokens.OnSubStop(info.SessionEventCommonInfo)
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
 // ----- implement IHttpServerHandlerObserver interface -----------------------------------------------------------------
 
+// OnNewHttpflvSubSession 注意，短连接频繁进出，为了减少锁竞争，不持有sm的锁，见 doGroup
+//
 func (sm *ServerManager) OnNewHttpflvSubSession(session *httpflv.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	var info base.SubStartInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolHttpflv
@@ -565,32 +1518,50 @@ func (sm *ServerManager) OnNewHttpflvSubSession(session *httpflv.SubSession) err
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
 
-	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+		return err
+	}
+	if err := sm.runScriptHook(ScriptHookTypeSub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+	if err := sm.checkAdmission(info.SessionEventCommonInfo); err != nil {
 		return err
 	}
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
-	group.AddHttpflvSubSession(session)
+	sm.doGroup(session.AppName(), session.StreamName(), true, func(group *Group) {
+		group.AddHttpflvSubSession(session)
 
-	info.HasInSession = group.HasInSession()
-	info.HasOutSession = group.HasOutSession()
+		info.HasInSession = group.HasInSession()
+		info.HasOutSession = group.HasOutSession()
+	})
 
+	sm.subTokens.OnSubStart(info.SessionEventCommonInfo, session)
 	sm.option.NotifyHandler.OnSubStart(info)
 	return nil
 }
 
 func (sm *ServerManager) OnDelHttpflvSubSession(session *httpflv.SubSession) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	group := sm.getGroup(session.AppName(), session.StreamName())
-	if group == nil {
+	var info base.SubStopInfo
+	var found bool
+	sm.doGroup(session.AppName(), session.StreamName(), false, func(group *Group) {
+		if group == nil {
+			return
+		}
+		found = true
+		info.Tags = group.GetSessionTags(session.UniqueKey())
+		group.DelHttpflvSubSession(session)
+		info.HasInSession = group.HasInSession()
+		info.HasOutSession = group.HasOutSession()
+	})
+	if !found {
 		return
 	}
 
-	group.DelHttpflvSubSession(session)
-
-	var info base.SubStopInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolHttpflv
 	info.Url = session.Url()
@@ -599,15 +1570,14 @@ func (sm *ServerManager) OnDelHttpflvSubSession(session *httpflv.SubSession) {
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
-	info.HasInSession = group.HasInSession()
-	info.HasOutSession = group.HasOutSession()
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	sm.subTokens.OnSubStop(info.SessionEventCommonInfo)
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
+// OnNewHttptsSubSession 注意，短连接频繁进出，为了减少锁竞争，不持有sm的锁，见 doGroup
+//
 func (sm *ServerManager) OnNewHttptsSubSession(session *httpts.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	var info base.SubStartInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolHttpts
@@ -617,34 +1587,51 @@ func (sm *ServerManager) OnNewHttptsSubSession(session *httpts.SubSession) error
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
-	sm.option.NotifyHandler.OnSubStart(info)
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
 
-	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+		return err
+	}
+	if err := sm.runScriptHook(ScriptHookTypeSub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+	if err := sm.checkAdmission(info.SessionEventCommonInfo); err != nil {
 		return err
 	}
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
-	group.AddHttptsSubSession(session)
+	sm.doGroup(session.AppName(), session.StreamName(), true, func(group *Group) {
+		group.AddHttptsSubSession(session)
 
-	info.HasInSession = group.HasInSession()
-	info.HasOutSession = group.HasOutSession()
+		info.HasInSession = group.HasInSession()
+		info.HasOutSession = group.HasOutSession()
+	})
 
+	sm.subTokens.OnSubStart(info.SessionEventCommonInfo, session)
 	sm.option.NotifyHandler.OnSubStart(info)
 
 	return nil
 }
 
 func (sm *ServerManager) OnDelHttptsSubSession(session *httpts.SubSession) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-	group := sm.getGroup(session.AppName(), session.StreamName())
-	if group == nil {
+	var info base.SubStopInfo
+	var found bool
+	sm.doGroup(session.AppName(), session.StreamName(), false, func(group *Group) {
+		if group == nil {
+			return
+		}
+		found = true
+		info.Tags = group.GetSessionTags(session.UniqueKey())
+		group.DelHttptsSubSession(session)
+		info.HasInSession = group.HasInSession()
+		info.HasOutSession = group.HasOutSession()
+	})
+	if !found {
 		return
 	}
 
-	group.DelHttptsSubSession(session)
-
-	var info base.SubStopInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolHttpts
 	info.Url = session.Url()
@@ -653,8 +1640,8 @@ func (sm *ServerManager) OnDelHttptsSubSession(session *httpts.SubSession) {
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
-	info.HasInSession = group.HasInSession()
-	info.HasOutSession = group.HasOutSession()
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	sm.subTokens.OnSubStop(info.SessionEventCommonInfo)
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
@@ -669,9 +1656,10 @@ func (sm *ServerManager) OnDelRtspSession(session *rtsp.ServerCommandSession) {
 }
 
 func (sm *ServerManager) OnNewRtspPubSession(session *rtsp.PubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	return sm.onNewRtspPubSession(session, nil)
+}
 
+func (sm *ServerManager) onNewRtspPubSession(session *rtsp.PubSession, listener *ListenerConfig) error {
 	var info base.PubStartInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtsp
@@ -681,20 +1669,53 @@ func (sm *ServerManager) OnNewRtspPubSession(session *rtsp.PubSession) error {
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckPub(info.SessionEventCommonInfo); err != nil {
+		return err
+	}
 
-	if err := sm.simpleAuthCtx.OnPubStart(info); err != nil {
+	// 鉴权、流名称解析可能请求外部服务，不持有sm的锁
+	if listener.needAuth() {
+		if err := sm.option.Authentication.OnPubStart(info); err != nil {
+			return err
+		}
+	}
+	if err := sm.resolveStreamKey(&info.SessionEventCommonInfo, session); err != nil {
 		return err
 	}
+	if err := sm.runScriptHook(ScriptHookTypePub, &info.SessionEventCommonInfo, session); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if listener.needAuth() {
+		if err := sm.checkPublishToken(info.SessionEventCommonInfo); err != nil {
+			return err
+		}
+	}
+	if sm.backupPublisher != nil {
+		sm.backupPublisher.ApplyRole(&info.SessionEventCommonInfo, session)
+	}
 
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	if err := group.AddRtspPubSession(session); err != nil {
 		return err
 	}
+	sm.consumePublishToken(info.SessionEventCommonInfo)
+	listener.tagSession(group, info.SessionId)
 
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 
 	sm.option.NotifyHandler.OnPubStart(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStart(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStart(group, info.StreamName)
+	}
 	return nil
 }
 
@@ -706,33 +1727,73 @@ func (sm *ServerManager) OnDelRtspPubSession(session *rtsp.PubSession) {
 		return
 	}
 
+	tags := group.GetSessionTags(session.UniqueKey())
 	group.DelRtspPubSession(session)
 
 	var info base.PubStopInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtsp
-	info.Url = session.Url()
+	info.Url = sm.pubSessionUrl(session.Url(), session.StreamName())
 	info.AppName = session.AppName()
 	info.StreamName = session.StreamName()
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
+	info.Tags = tags
 	sm.option.NotifyHandler.OnPubStop(info)
+	if sm.transcoder != nil {
+		sm.transcoder.OnPubStop(info.AppName, info.StreamName)
+	}
+	if sm.pluginManager != nil {
+		sm.pluginManager.OnStreamStop(group, info.StreamName)
+	}
 }
 
 func (sm *ServerManager) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	return sm.onNewRtspSubSessionDescribe(session, nil)
+}
+
+func (sm *ServerManager) onNewRtspSubSessionDescribe(session *rtsp.SubSession, listener *ListenerConfig) (ok bool, sdp []byte) {
+	// rtsp拉流在describe阶段就需要根据流名称找到group，所以在这里执行脚本
+	// 和rtmp、httpflv一样，鉴权使用的是客户端请求的流名称，所以鉴权也需要在describe阶段执行脚本之前
+	// 鉴权、脚本可能请求外部服务或者执行较慢，不持有sm的锁
+	var info base.SubStartInfo
+	info.ServerId = sm.config.ServerId
+	info.Protocol = base.ProtocolRtsp
+	info.Url = session.Url()
+	info.AppName = session.AppName()
+	info.StreamName = session.StreamName()
+	info.UrlParam = session.RawQuery()
+	info.SessionId = session.UniqueKey()
+	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info.SessionEventCommonInfo); err != nil {
+		return false, nil
+	}
+	if listener.needAuth() {
+		if err := sm.option.Authentication.OnSubStart(info); err != nil {
+			return false, nil
+		}
+	}
+	if err := sm.runScriptHook(ScriptHookTypeSub, &info.SessionEventCommonInfo, session); err != nil {
+		return false, nil
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	return group.HandleNewRtspSubSessionDescribe(session)
 }
 
 func (sm *ServerManager) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	return sm.onNewRtspSubSessionPlay(session, nil)
+}
 
+func (sm *ServerManager) onNewRtspSubSessionPlay(session *rtsp.SubSession, listener *ListenerConfig) error {
 	var info base.SubStartInfo
 	info.ServerId = sm.config.ServerId
 	info.Protocol = base.ProtocolRtsp
@@ -742,17 +1803,24 @@ func (sm *ServerManager) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	// 注意，geo策略检查和鉴权已经在describe阶段执行脚本之前完成，见 onNewRtspSubSessionDescribe
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 
-	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+	if err := sm.checkAdmission(info.SessionEventCommonInfo); err != nil {
 		return err
 	}
 
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.HandleNewRtspSubSessionPlay(session)
+	listener.tagSession(group, info.SessionId)
 
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 
+	sm.subTokens.OnSubStart(info.SessionEventCommonInfo, session)
 	sm.option.NotifyHandler.OnSubStart(info)
 	return nil
 }
@@ -765,6 +1833,7 @@ func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 		return
 	}
 
+	tags := group.GetSessionTags(session.UniqueKey())
 	group.DelRtspSubSession(session)
 
 	var info base.SubStopInfo
@@ -776,19 +1845,63 @@ func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 	info.UrlParam = session.RawQuery()
 	info.SessionId = session.UniqueKey()
 	info.RemoteAddr = session.GetStat().RemoteAddr
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
+	info.Tags = tags
+	sm.subTokens.OnSubStop(info.SessionEventCommonInfo)
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
 // ----- implement IGroupCreator interface -----------------------------------------------------------------------------
 
+func (sm *ServerManager) startPull(info base.ApiCtrlStartPullReq) *Group {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	var g *Group
+	if info.KeepWithoutSub {
+		g = sm.getOrCreateGroup(info.AppName, info.StreamName)
+	} else {
+		g = sm.getGroup(info.AppName, info.StreamName)
+	}
+	if g == nil {
+		return nil
+	}
+	var url string
+	if info.UrlParam != "" {
+		url = fmt.Sprintf("rtmp://%s/%s/%s?%s", info.Addr, info.AppName, info.StreamName, info.UrlParam)
+	} else {
+		url = fmt.Sprintf("rtmp://%s/%s/%s", info.Addr, info.AppName, info.StreamName)
+	}
+	g.StartPull(url, info.KeepWithoutSub, info.RequestId)
+	return g
+}
+
 func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
-	return NewGroup(appName, streamName, sm.config, sm)
+	return NewGroup(appName, streamName, sm.config.ForStream(streamName), sm)
 }
 
 // ----- implement IGroupObserver interface -----------------------------------------------------------------------------
 
+// isSwitchSourceLoop `g`切换到输入源`source`后，是否会形成环
+func isSwitchSourceLoop(g *Group, source *Group) bool {
+	visited := make(map[*Group]struct{})
+	queue := []*Group{source}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		if curr == g {
+			return true
+		}
+		if _, ok := visited[curr]; ok {
+			continue
+		}
+		visited[curr] = struct{}{}
+		queue = append(queue, curr.SwitchSourceGroups()...)
+	}
+	return false
+}
+
 func (sm *ServerManager) CleanupHlsIfNeeded(appName string, streamName string, path string) {
 	if sm.config.HlsConfig.Enable &&
 		(sm.config.HlsConfig.CleanupMode == hls.CleanupModeInTheEnd || sm.config.HlsConfig.CleanupMode == hls.CleanupModeAsap) {
@@ -818,15 +1931,110 @@ func (sm *ServerManager) CleanupHlsIfNeeded(appName string, streamName string, p
 	}
 }
 
+// 定时通过ntp校正hls使用的墙上时间
+func (sm *ServerManager) runNtpSyncLoop() {
+	t := time.NewTicker(ntpSyncInterval)
+	defer t.Stop()
+	for {
+		offset, err := base.QueryNtpOffset(sm.config.HlsConfig.NtpServer, 5*time.Second)
+		if err != nil {
+			Log.Warnf("query ntp offset failed. server=%s, err=%+v", sm.config.HlsConfig.NtpServer, err)
+		} else {
+			Log.Infof("query ntp offset succ. server=%s, offset=%v", sm.config.HlsConfig.NtpServer, offset)
+			hls.SetClockOffset(offset)
+		}
+		<-t.C
+	}
+}
+
+func (sm *ServerManager) IsRecordPaused() bool {
+	return sm.recordJanitor != nil && sm.recordJanitor.IsRecordPaused()
+}
+
+func (sm *ServerManager) OnRelayPullStart(info base.RelayPullStartInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnRelayPullStart(info)
+}
+
+func (sm *ServerManager) OnRelayPullStop(info base.RelayPullStopInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnRelayPullStop(info)
+}
+
+func (sm *ServerManager) OnRelayPushStart(info base.RelayPushStartInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnRelayPushStart(info)
+}
+
+func (sm *ServerManager) OnRelayPushStop(info base.RelayPushStopInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnRelayPushStop(info)
+}
+
+func (sm *ServerManager) OnBitstreamError(info base.BitstreamErrorInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnBitstreamError(info)
+}
+
+func (sm *ServerManager) OnPubViolation(info base.PubViolationInfo) {
+	info.ServerId = sm.config.ServerId
+	sm.option.NotifyHandler.OnPubViolation(info)
+}
+
+func (sm *ServerManager) OnStreamEvent(streamName string, event base.StreamEvent) {
+	sm.streamEvents.Add(streamName, event, time.Now())
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
+func (sm *ServerManager) DebugGroup(streamName string) *base.DebugGroupState {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", streamName)
+	if g == nil {
+		return nil
+	}
+	s := g.DebugState()
+	return &s
+}
+
+func (sm *ServerManager) StatHistory(streamName string) ([]base.StatStreamHistory, bool) {
+	if sm.statHistory == nil {
+		return nil, false
+	}
+	return sm.statHistory.Query(streamName, time.Now().Unix()), true
+}
+
+// StatStreamEvents 流的事件时间线，见 StreamEventLog.Query
+//
+func (sm *ServerManager) StatStreamEvents(streamName string, limit int) ([]base.StreamEvent, bool) {
+	if sm.streamEvents == nil {
+		return nil, false
+	}
+	return sm.streamEvents.Query(streamName, limit), true
+}
+
+func (sm *ServerManager) StatRecordRetention() (base.StatRecordRetention, bool) {
+	if sm.recordJanitor == nil {
+		return base.StatRecordRetention{}, false
+	}
+	return sm.recordJanitor.GetStat(), true
+}
+
+func (sm *ServerManager) StatConnGuard() (base.StatConnGuard, bool) {
+	if sm.connGuard == nil {
+		return base.StatConnGuard{}, false
+	}
+	return sm.connGuard.GetStat(), true
+}
+
 func (sm *ServerManager) Config() *Config {
 	return sm.config
 }
 
+// GetGroup 注意，groupManager本身并发安全，所以不需要持有sm的锁
+//
 func (sm *ServerManager) GetGroup(appName string, streamName string) *Group {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
 	return sm.getGroup(appName, streamName)
 }
 
@@ -845,22 +2053,148 @@ func (sm *ServerManager) getGroup(appName string, streamName string) *Group {
 	return sm.groupManager.GetGroup(appName, streamName)
 }
 
+// doGroup 查找（或创建）group并执行`fn`，期间group不会被主循环当作空闲group删除，不需要持有sm的锁
+//
+// 注意，`fn`中只能操作group，不能再调用sm中会获取锁或者操作groupManager的方法
+//
+func (sm *ServerManager) doGroup(appName string, streamName string, shouldCreate bool, fn func(group *Group)) {
+	sm.groupManager.Do(appName, streamName, shouldCreate, func(group *Group, createFlag bool) {
+		if createFlag {
+			go group.RunLoop()
+		}
+		fn(group)
+	})
+}
+
+// resolveStreamKey 推流url中的流名称为密钥时，将session和info中的流名称修改为公开的流名称，见 StreamKeyResolver
+//
+func (sm *ServerManager) resolveStreamKey(info *base.SessionEventCommonInfo, session interface{ SetStreamName(string) }) error {
+	if sm.streamKey == nil {
+		return nil
+	}
+	streamName, err := sm.streamKey.Resolve(*info)
+	if err != nil {
+		Log.Warnf("[%s] stream key denied. err=%+v", info.SessionId, err)
+		return err
+	}
+	if streamName != info.StreamName {
+		// 不打印密钥
+		Log.Infof("[%s] stream key resolved. stream=%s", info.SessionId, streamName)
+		session.SetStreamName(streamName)
+		info.StreamName = streamName
+		info.Url = replaceUrlStreamName(info.Url, streamName)
+	}
+	return nil
+}
+
+// pubSessionUrl 事件通知中使用的推流url。开启`stream_key`时，session中的url仍然包含密钥，替换为session当前的（公开的）流名称
+//
+func (sm *ServerManager) pubSessionUrl(url string, streamName string) string {
+	if sm.streamKey == nil || url == "" {
+		return url
+	}
+	return replaceUrlStreamName(url, streamName)
+}
+
+// checkPublishToken 使用解析后的流名称校验一次性推流token，见 PublishTokenAuth.Check
+//
+// 注意，需要持有sm的锁，保证同一个token只有一个推流能通过校验并加入group
+//
+func (sm *ServerManager) checkPublishToken(info base.SessionEventCommonInfo) error {
+	if sm.publishToken == nil {
+		return nil
+	}
+	return sm.publishToken.Check(info, time.Now())
+}
+
+func (sm *ServerManager) consumePublishToken(info base.SessionEventCommonInfo) {
+	if sm.publishToken != nil {
+		sm.publishToken.Consume(info)
+	}
+}
+
+// checkAdmission 节点出口带宽不足时拒绝拉流，见 AdmissionController
+//
+func (sm *ServerManager) checkAdmission(info base.SessionEventCommonInfo) error {
+	notify, err := sm.admission.Check(info, time.Now())
+	if notify != nil {
+		sm.option.NotifyHandler.OnSubReject(*notify)
+	}
+	if err != nil {
+		Log.Warnf("[%s] admission rejected. stream=%s, err=%+v", info.SessionId, info.StreamName, err)
+	}
+	return err
+}
+
+// runScriptHook 执行脚本，如果脚本重写了流名称，则同时修改session和info中的流名称
+//
+func (sm *ServerManager) runScriptHook(typ string, info *base.SessionEventCommonInfo, session interface{ SetStreamName(string) }) error {
+	if sm.scriptHook == nil {
+		return nil
+	}
+	streamName, err := sm.scriptHook.Run(newScriptHookRequest(typ, *info))
+	if err != nil {
+		Log.Warnf("[%s] script hook denied. stream=%s, err=%+v", info.SessionId, info.StreamName, err)
+		return err
+	}
+	if streamName != info.StreamName {
+		Log.Infof("[%s] script hook rewrite stream name. %s -> %s", info.SessionId, info.StreamName, streamName)
+		session.SetStreamName(streamName)
+		info.StreamName = streamName
+	}
+	return nil
+}
+
 func (sm *ServerManager) serveHls(writer http.ResponseWriter, req *http.Request) {
-	urlCtx, err := base.ParseUrl(base.ParseHttpRequest(req), 80)
+	rawUrl := base.ParseHttpRequest(req)
+	urlCtx, err := base.ParseUrl(rawUrl, 80)
 	if err != nil {
 		Log.Errorf("parse url. err=%+v", err)
 		return
 	}
-	if urlCtx.GetFileType() == "m3u8" {
-		if err = sm.simpleAuthCtx.OnHls(urlCtx.GetFilenameWithoutType(), urlCtx.RawQuery); err != nil {
-			Log.Errorf("simple auth failed. err=%+v", err)
-			return
-		}
+	if err = sm.checkHls(rawUrl, urlCtx, req.RemoteAddr); err != nil {
+		return
 	}
 
 	sm.hlsServerHandler.ServeHTTP(writer, req)
 }
 
+// checkHls hls请求的地域访问策略、鉴权以及脚本
+//
+// 地域访问策略对m3u8和ts都生效，避免绕过m3u8直接下载ts；鉴权和脚本只对m3u8生效
+//
+func (sm *ServerManager) checkHls(rawUrl string, urlCtx base.UrlContext, remoteAddr string) error {
+	info := base.SessionEventCommonInfo{
+		Protocol:   base.ProtocolHls,
+		Url:        rawUrl,
+		StreamName: urlCtx.GetFilenameWithoutType(),
+		UrlParam:   urlCtx.RawQuery,
+		RemoteAddr: remoteAddr,
+	}
+	info.Geo = sm.geoIp.LookupAddr(info.RemoteAddr)
+	if err := sm.geoAccessPolicy.CheckSub(info); err != nil {
+		Log.Errorf("geo access denied. err=%+v", err)
+		return err
+	}
+
+	// 注意，hls不做拉流准入控制，见 AdmissionController
+	if urlCtx.GetFileType() != "m3u8" {
+		return nil
+	}
+	if err := sm.option.Authentication.OnHls(info.StreamName, info.UrlParam); err != nil {
+		Log.Errorf("auth failed. err=%+v", err)
+		return err
+	}
+	// hls不支持重写流名称，只使用脚本的拒绝结果
+	if sm.scriptHook != nil {
+		if _, err := sm.scriptHook.Run(newScriptHookRequest(ScriptHookTypeHls, info)); err != nil {
+			Log.Errorf("script hook denied. err=%+v", err)
+			return err
+		}
+	}
+	return nil
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func firstExistDefaultConfFilename() string {
@@ -0,0 +1,69 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSummarizeServerLoad(t *testing.T) {
+	groups := []base.StatGroup{
+		{
+			StatPub:     base.StatPub{StatSession: base.StatSession{SessionId: "RTMPPUBSUB1", ReadBitrate: 2000}},
+			StatSubs:    []base.StatSub{{StatSession: base.StatSession{WriteBitrate: 2000}}, {StatSession: base.StatSession{WriteBitrate: 1900}}},
+			ViewerCount: 2,
+		},
+		{
+			StatPull:    base.StatPull{StatSession: base.StatSession{SessionId: "RTMPPULL1", ReadBitrate: 1000}},
+			StatSubs:    []base.StatSub{{StatSession: base.StatSession{WriteBitrate: 1000}}},
+			ViewerCount: 1,
+		},
+		{},
+	}
+	assert.Equal(t, base.ServerHeartbeatInfo{
+		GroupNum:     3,
+		PubNum:       1,
+		PullNum:      1,
+		ViewerNum:    3,
+		ReadBitrate:  3000,
+		WriteBitrate: 4900,
+	}, summarizeServerLoad(groups))
+}
+
+func TestCtrlBatchMaxItems(t *testing.T) {
+	config := &Config{}
+	config.HttpApiConfig.BatchMaxItems = 2
+	sm := &ServerManager{config: config}
+
+	var req base.ApiCtrlBatchStartPullReq
+	req.Items = make([]base.ApiCtrlStartPullReq, 3)
+	ret := sm.CtrlBatchStartPull(req)
+	assert.Equal(t, base.ErrorCodeParamInvalid, ret.ErrorCode)
+	assert.Equal(t, 0, len(ret.Data.Results))
+
+	// 没有超过上限时，缺少参数的项单独返回错误
+	req.Items = req.Items[:2]
+	ret = sm.CtrlBatchStartPull(req)
+	assert.Equal(t, base.ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, 2, len(ret.Data.Results))
+	assert.Equal(t, base.ErrorCodeParamMissing, ret.Data.Results[1].ErrorCode)
+
+	// 每一项都必须带app_name
+	req.Items = []base.ApiCtrlStartPullReq{{Protocol: base.ProtocolRtmp, Addr: "127.0.0.1:1935", StreamName: "test110"}}
+	ret = sm.CtrlBatchStartPull(req)
+	assert.Equal(t, base.ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, base.ErrorCodeParamMissing, ret.Data.Results[0].ErrorCode)
+
+	var kickReq base.ApiCtrlBatchKickOutSessionReq
+	kickReq.Items = make([]base.ApiCtrlKickOutSession, 3)
+	assert.Equal(t, base.ErrorCodeParamInvalid, sm.CtrlBatchKickOutSession(kickReq).ErrorCode)
+}
@@ -27,40 +27,58 @@ func SimpleAuthCalcSecret(key string, streamName string) string {
 const secretName = "lal_secret"
 
 type SimpleAuthCtx struct {
-	config SimpleAuthConfig
+	config          SimpleAuthConfig
+	streamOverrides []StreamOverrideConfig
 }
 
-func NewSimpleAuthCtx(config SimpleAuthConfig) *SimpleAuthCtx {
+// NewSimpleAuthCtx
+//
+// @param streamOverrides 可选，按流名称覆盖鉴权开关
+//
+func NewSimpleAuthCtx(config SimpleAuthConfig, streamOverrides ...StreamOverrideConfig) *SimpleAuthCtx {
 	return &SimpleAuthCtx{
-		config: config,
+		config:          config,
+		streamOverrides: streamOverrides,
 	}
 }
 
 func (s *SimpleAuthCtx) OnPubStart(info base.PubStartInfo) error {
-	if s.config.PubRtmpEnable && info.Protocol == base.ProtocolRtmp ||
-		s.config.PubRtspEnable && info.Protocol == base.ProtocolRtsp {
+	config := s.configOfStream(info.StreamName)
+	if config.PubRtmpEnable && info.Protocol == base.ProtocolRtmp ||
+		config.PubRtspEnable && info.Protocol == base.ProtocolRtsp {
 		return s.check(info.StreamName, info.UrlParam)
 	}
 	return nil
 }
 
 func (s *SimpleAuthCtx) OnSubStart(info base.SubStartInfo) error {
-	if (s.config.SubRtmpEnable && info.Protocol == base.ProtocolRtmp) ||
-		(s.config.SubHttpflvEnable && info.Protocol == base.ProtocolHttpflv) ||
-		(s.config.SubHttptsEnable && info.Protocol == base.ProtocolHttpts) ||
-		(s.config.SubRtspEnable && info.Protocol == base.ProtocolRtsp) {
+	config := s.configOfStream(info.StreamName)
+	if (config.SubRtmpEnable && info.Protocol == base.ProtocolRtmp) ||
+		(config.SubHttpflvEnable && info.Protocol == base.ProtocolHttpflv) ||
+		(config.SubHttptsEnable && info.Protocol == base.ProtocolHttpts) ||
+		(config.SubRtspEnable && info.Protocol == base.ProtocolRtsp) {
 		return s.check(info.StreamName, info.UrlParam)
 	}
 	return nil
 }
 
 func (s *SimpleAuthCtx) OnHls(streamName string, urlParam string) error {
-	if s.config.HlsM3u8Enable {
+	if s.configOfStream(streamName).HlsM3u8Enable {
 		return s.check(streamName, urlParam)
 	}
 	return nil
 }
 
+func (s *SimpleAuthCtx) configOfStream(streamName string) SimpleAuthConfig {
+	config := s.config
+	for i := range s.streamOverrides {
+		if s.streamOverrides[i].Match(streamName) {
+			s.streamOverrides[i].applyToSimpleAuth(&config)
+		}
+	}
+	return config
+}
+
 func (s *SimpleAuthCtx) check(streamName string, urlParam string) error {
 	q, err := url.ParseQuery(urlParam)
 	if err != nil {
@@ -0,0 +1,175 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	defaultStatHistoryIntervalSec = 5
+	defaultStatHistoryKeepMinutes = 30
+)
+
+// StatHistory 在内存中保留每路流最近`keep_minutes`分钟的码率、sub数量采样，用于展示近期的趋势，不依赖外部存储
+//
+// 每路流使用一个固定大小的环形缓冲区。流结束后，历史数据继续保留，直到所有采样都过期
+//
+type StatHistory struct {
+	config StatHistoryConfig
+
+	mutex   sync.Mutex
+	streams map[string]*statHistoryRing
+}
+
+type statHistoryRing struct {
+	samples []base.StatHistorySample
+	next    int // 下一个写入的位置
+	full    bool
+}
+
+func NewStatHistory(config StatHistoryConfig) *StatHistory {
+	if config.IntervalSec <= 0 {
+		config.IntervalSec = defaultStatHistoryIntervalSec
+	}
+	if config.KeepMinutes <= 0 {
+		config.KeepMinutes = defaultStatHistoryKeepMinutes
+	}
+	return &StatHistory{
+		config:  config,
+		streams: make(map[string]*statHistoryRing),
+	}
+}
+
+func (sh *StatHistory) IntervalSec() int {
+	return sh.config.IntervalSec
+}
+
+// Sample 记录所有流的一次采样
+//
+// @param nowUnix: 采样时间，unix秒
+// @param groups:  当前所有流的stat
+//
+func (sh *StatHistory) Sample(nowUnix int64, groups []base.StatGroup) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	for _, g := range groups {
+		r, ok := sh.streams[g.StreamName]
+		if !ok {
+			r = &statHistoryRing{
+				samples: make([]base.StatHistorySample, sh.capacity()),
+			}
+			sh.streams[g.StreamName] = r
+		}
+		r.add(makeStatHistorySample(nowUnix, g))
+	}
+
+	// 清理所有采样都已经过期的流
+	expire := nowUnix - int64(sh.config.KeepMinutes*60)
+	for streamName, r := range sh.streams {
+		if latest, ok := r.latest(); !ok || latest.Time <= expire {
+			delete(sh.streams, streamName)
+		}
+	}
+}
+
+// Query
+//
+// @param streamName: 为空时返回所有流
+//
+func (sh *StatHistory) Query(streamName string, nowUnix int64) (ret []base.StatStreamHistory) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	expire := nowUnix - int64(sh.config.KeepMinutes*60)
+	for name, r := range sh.streams {
+		if streamName != "" && name != streamName {
+			continue
+		}
+		samples := r.list(expire)
+		if len(samples) == 0 {
+			continue
+		}
+		ret = append(ret, base.StatStreamHistory{
+			StreamName: name,
+			Samples:    samples,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StreamName < ret[j].StreamName
+	})
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (sh *StatHistory) capacity() int {
+	n := sh.config.KeepMinutes * 60 / sh.config.IntervalSec
+	if n <= 0 {
+		n = 1
+	}
+	return n
+}
+
+func (r *statHistoryRing) add(sample base.StatHistorySample) {
+	r.samples[r.next] = sample
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+func (r *statHistoryRing) latest() (base.StatHistorySample, bool) {
+	if !r.full && r.next == 0 {
+		return base.StatHistorySample{}, false
+	}
+	i := r.next - 1
+	if i < 0 {
+		i = len(r.samples) - 1
+	}
+	return r.samples[i], true
+}
+
+// list 按时间从旧到新返回所有没有过期的采样
+func (r *statHistoryRing) list(expire int64) (ret []base.StatHistorySample) {
+	start, n := 0, r.next
+	if r.full {
+		start, n = r.next, len(r.samples)
+	}
+	for i := 0; i < n; i++ {
+		s := r.samples[(start+i)%len(r.samples)]
+		if s.Time > expire {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func makeStatHistorySample(nowUnix int64, g base.StatGroup) base.StatHistorySample {
+	sample := base.StatHistorySample{
+		Time:     nowUnix,
+		SubCount: len(g.StatSubs),
+	}
+	if g.StatPub.SessionId != "" {
+		sample.HasInStream = true
+		sample.InBitrate = g.StatPub.ReadBitrate
+	} else if g.StatPull.SessionId != "" {
+		sample.HasInStream = true
+		sample.InBitrate = g.StatPull.ReadBitrate
+	}
+	for _, sub := range g.StatSubs {
+		sample.OutBitrate += sub.WriteBitrate
+	}
+	return sample
+}
@@ -0,0 +1,58 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestStatHistory(t *testing.T) {
+	sh := NewStatHistory(StatHistoryConfig{Enable: true, IntervalSec: 60, KeepMinutes: 3})
+	assert.Equal(t, 3, sh.capacity())
+
+	var pub base.StatGroup
+	pub.StreamName = "a"
+	pub.StatPub.SessionId = "RTMPPUBSUB1"
+	pub.StatPub.ReadBitrate = 1000
+	pub.StatSubs = []base.StatSub{
+		{StatSession: base.StatSession{WriteBitrate: 900}},
+		{StatSession: base.StatSession{WriteBitrate: 1100}},
+	}
+	var pull base.StatGroup
+	pull.StreamName = "b"
+	pull.StatPull.SessionId = "RTMPPULL1"
+	pull.StatPull.ReadBitrate = 500
+
+	sh.Sample(1000, []base.StatGroup{pub, pull})
+	sh.Sample(1060, []base.StatGroup{pub, pull})
+	sh.Sample(1120, []base.StatGroup{pub})
+	sh.Sample(1180, []base.StatGroup{pub}) // 覆盖最旧的采样
+
+	ret := sh.Query("a", 1180)
+	assert.Equal(t, 1, len(ret))
+	assert.Equal(t, 3, len(ret[0].Samples))
+	assert.Equal(t, int64(1060), ret[0].Samples[0].Time)
+	assert.Equal(t, int64(1180), ret[0].Samples[2].Time)
+	assert.Equal(t, base.StatHistorySample{Time: 1180, InBitrate: 1000, OutBitrate: 2000, SubCount: 2, HasInStream: true},
+		ret[0].Samples[2])
+
+	ret = sh.Query("", 1180)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "b", ret[1].StreamName)
+	assert.Equal(t, 500, ret[1].Samples[0].InBitrate)
+
+	// 流b的采样全部过期后被清理
+	sh.Sample(1240, []base.StatGroup{pub})
+	assert.Equal(t, 1, len(sh.Query("", 1240)))
+	assert.Equal(t, 0, len(sh.Query("b", 1240)))
+	assert.Equal(t, 1, len(sh.streams))
+}
@@ -0,0 +1,267 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	StreamEventTypePubStart       = "pub_start"
+	StreamEventTypePubStop        = "pub_stop"
+	StreamEventTypeCodecChange    = "codec_change"
+	StreamEventTypeRelayPullStart = "relay_pull_start"
+	StreamEventTypeRelayPullStop  = "relay_pull_stop"
+	StreamEventTypeRelayPushStart = "relay_push_start"
+	StreamEventTypeRelayPushStop  = "relay_push_stop"
+	StreamEventTypeKick           = "kick"
+	StreamEventTypeBitstreamError = "bitstream_error"
+	StreamEventTypeBackupSwitch   = "backup_switch"
+	StreamEventTypePubViolation   = "pub_violation"
+	StreamEventTypeSubReject      = "sub_reject"
+
+	defaultStreamEventMaxEventsPerStream = 100
+	defaultStreamEventKeepMinutes        = 60
+	defaultStreamEventMaxStreams         = 10000
+)
+
+// StreamEventLog 在内存中保留每路流最近的`max_events_per_stream`个事件，通过`/api/stat/stream_events`查询，
+// 排查问题时可以还原一路流发生了什么，不需要登录机器查看日志
+//
+// 事件来自事件通知（见 streamEventNotifyHandler ，不依赖是否配置了http notify），以及音视频编码参数变化、http api踢出session。
+// 流结束后，事件继续保留，直到最后一个事件之后超过`keep_minutes`
+// 记录的流的数量超过`max_streams`时，删除最后一个事件最旧的流，避免大量不存在的流名称（比如扫描）占用内存
+//
+// 方法可以在nil上调用，此时表示没有开启
+//
+// 并发安全
+//
+type StreamEventLog struct {
+	config StreamEventConfig
+
+	mutex   sync.Mutex
+	streams map[string]*streamEventList // key: 流名称
+}
+
+type streamEventList struct {
+	events   []base.StreamEvent // 按时间从旧到新
+	lastTime time.Time
+}
+
+func NewStreamEventLog(config StreamEventConfig) *StreamEventLog {
+	if !config.Enable {
+		return nil
+	}
+	if config.MaxEventsPerStream <= 0 {
+		config.MaxEventsPerStream = defaultStreamEventMaxEventsPerStream
+	}
+	if config.KeepMinutes <= 0 {
+		config.KeepMinutes = defaultStreamEventKeepMinutes
+	}
+	if config.MaxStreams <= 0 {
+		config.MaxStreams = defaultStreamEventMaxStreams
+	}
+	return &StreamEventLog{
+		config:  config,
+		streams: make(map[string]*streamEventList),
+	}
+}
+
+// Add
+//
+// @param event: `Time`为空时使用`now`
+//
+func (l *StreamEventLog) Add(streamName string, event base.StreamEvent, now time.Time) {
+	if l == nil || streamName == "" {
+		return
+	}
+	if event.Time == "" {
+		event.Time = now.Format("2006-01-02 15:04:05.000")
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s, ok := l.streams[streamName]
+	if !ok {
+		if len(l.streams) >= l.config.MaxStreams {
+			l.evictOldest()
+		}
+		s = &streamEventList{}
+		l.streams[streamName] = s
+	}
+	if len(s.events) >= l.config.MaxEventsPerStream {
+		s.events = append(s.events[:0], s.events[len(s.events)-l.config.MaxEventsPerStream+1:]...)
+	}
+	s.events = append(s.events, event)
+	s.lastTime = now
+}
+
+// Query 按时间从旧到新返回流`streamName`的事件
+//
+// @param limit: 最多返回最近的多少个事件，小于等于0时不限制
+//
+// @return 没有该流的事件时返回nil
+//
+func (l *StreamEventLog) Query(streamName string, limit int) []base.StreamEvent {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s, ok := l.streams[streamName]
+	if !ok {
+		return nil
+	}
+	events := s.events
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	ret := make([]base.StreamEvent, len(events))
+	copy(ret, events)
+	return ret
+}
+
+// Cleanup 删除最后一个事件之后超过`keep_minutes`的流，需要定时调用
+//
+func (l *StreamEventLog) Cleanup(now time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	keep := time.Duration(l.config.KeepMinutes) * time.Minute
+	for streamName, s := range l.streams {
+		if now.Sub(s.lastTime) > keep {
+			delete(l.streams, streamName)
+		}
+	}
+}
+
+// evictOldest 删除最后一个事件最旧的流，注意，调用方需要持有锁
+//
+func (l *StreamEventLog) evictOldest() {
+	var (
+		oldestName string
+		oldestTime time.Time
+	)
+	for streamName, s := range l.streams {
+		if oldestName == "" || s.lastTime.Before(oldestTime) {
+			oldestName, oldestTime = streamName, s.lastTime
+		}
+	}
+	delete(l.streams, oldestName)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// streamEventNotifyHandler 包装 INotifyHandler ，将和流相关的事件记录到 StreamEventLog 中，再交给`INotifyHandler`
+//
+type streamEventNotifyHandler struct {
+	INotifyHandler
+	log *StreamEventLog
+}
+
+func (h *streamEventNotifyHandler) OnPubStart(info base.PubStartInfo) {
+	h.addSession(StreamEventTypePubStart, info.SessionEventCommonInfo, "")
+	h.INotifyHandler.OnPubStart(info)
+}
+
+func (h *streamEventNotifyHandler) OnPubStop(info base.PubStopInfo) {
+	h.addSession(StreamEventTypePubStop, info.SessionEventCommonInfo, "")
+	h.INotifyHandler.OnPubStop(info)
+}
+
+func (h *streamEventNotifyHandler) OnRelayPullStart(info base.RelayPullStartInfo) {
+	h.addRelay(StreamEventTypeRelayPullStart, info.RelayEventCommonInfo, formatRelayStartDetail(info.Url, info.Success, info.Reason, info.RetryCount))
+	h.INotifyHandler.OnRelayPullStart(info)
+}
+
+func (h *streamEventNotifyHandler) OnRelayPullStop(info base.RelayPullStopInfo) {
+	h.addRelay(StreamEventTypeRelayPullStop, info.RelayEventCommonInfo, fmt.Sprintf("url=%s, reason=%s", info.Url, info.Reason))
+	h.INotifyHandler.OnRelayPullStop(info)
+}
+
+func (h *streamEventNotifyHandler) OnRelayPushStart(info base.RelayPushStartInfo) {
+	h.addRelay(StreamEventTypeRelayPushStart, info.RelayEventCommonInfo, formatRelayStartDetail(info.Url, info.Success, info.Reason, info.RetryCount))
+	h.INotifyHandler.OnRelayPushStart(info)
+}
+
+func (h *streamEventNotifyHandler) OnRelayPushStop(info base.RelayPushStopInfo) {
+	h.addRelay(StreamEventTypeRelayPushStop, info.RelayEventCommonInfo, fmt.Sprintf("url=%s, reason=%s", info.Url, info.Reason))
+	h.INotifyHandler.OnRelayPushStop(info)
+}
+
+func (h *streamEventNotifyHandler) OnBitstreamError(info base.BitstreamErrorInfo) {
+	h.log.Add(info.StreamName, base.StreamEvent{
+		Type:      StreamEventTypeBitstreamError,
+		SessionId: info.SessionId,
+		Detail: fmt.Sprintf("reason=%s, drop=%d, repair=%d, quarantine=%t",
+			info.Reason, info.DropCount, info.RepairCount, info.Quarantine),
+	}, time.Now())
+	h.INotifyHandler.OnBitstreamError(info)
+}
+
+func (h *streamEventNotifyHandler) OnBackupSwitch(info base.BackupSwitchInfo) {
+	h.log.Add(info.StreamName, base.StreamEvent{
+		Type:   StreamEventTypeBackupSwitch,
+		Detail: fmt.Sprintf("%s -> %s, reason=%s", info.From, info.To, info.Reason),
+	}, time.Now())
+	h.INotifyHandler.OnBackupSwitch(info)
+}
+
+func (h *streamEventNotifyHandler) OnPubViolation(info base.PubViolationInfo) {
+	h.log.Add(info.StreamName, base.StreamEvent{
+		Type:      StreamEventTypePubViolation,
+		SessionId: info.SessionId,
+		Detail:    fmt.Sprintf("reason=%s, disconnect=%t, %s", info.Reason, info.Disconnect, info.Detail),
+	}, time.Now())
+	h.INotifyHandler.OnPubViolation(info)
+}
+
+func (h *streamEventNotifyHandler) OnSubReject(info base.SubRejectInfo) {
+	h.addSession(StreamEventTypeSubReject, info.SessionEventCommonInfo,
+		fmt.Sprintf("reason=%s, egress=%dkbit/s, budget=%dkbit/s, reject count=%d",
+			info.Reason, info.EgressKbits, info.BudgetKbits, info.RejectCount))
+	h.INotifyHandler.OnSubReject(info)
+}
+
+func (h *streamEventNotifyHandler) addSession(typ string, info base.SessionEventCommonInfo, detail string) {
+	h.log.Add(info.StreamName, base.StreamEvent{
+		Type:       typ,
+		SessionId:  info.SessionId,
+		Protocol:   info.Protocol,
+		RemoteAddr: info.RemoteAddr,
+		Detail:     detail,
+	}, time.Now())
+}
+
+func (h *streamEventNotifyHandler) addRelay(typ string, info base.RelayEventCommonInfo, detail string) {
+	h.log.Add(info.StreamName, base.StreamEvent{
+		Type:      typ,
+		SessionId: info.SessionId,
+		Detail:    detail,
+		RequestId: info.RequestId,
+	}, time.Now())
+}
+
+func formatRelayStartDetail(url string, success bool, reason string, retryCount int) string {
+	if success {
+		return fmt.Sprintf("url=%s, success, retry count=%d", url, retryCount)
+	}
+	return fmt.Sprintf("url=%s, failed, reason=%s, retry count=%d", url, reason, retryCount)
+}
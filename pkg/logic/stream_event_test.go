@@ -0,0 +1,101 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestStreamEventLog(t *testing.T) {
+	// 没有开启
+	var nilLog *StreamEventLog
+	assert.Equal(t, true, NewStreamEventLog(StreamEventConfig{}) == nil)
+	nilLog.Add("test110", base.StreamEvent{Type: StreamEventTypePubStart}, time.Now())
+	nilLog.Cleanup(time.Now())
+	assert.Equal(t, 0, len(nilLog.Query("test110", 0)))
+
+	l := NewStreamEventLog(StreamEventConfig{
+		Enable:             true,
+		MaxEventsPerStream: 3,
+		KeepMinutes:        1,
+	})
+	now := time.Now()
+	for i, typ := range []string{StreamEventTypePubStart, StreamEventTypeCodecChange, StreamEventTypeKick, StreamEventTypePubStop} {
+		l.Add("test110", base.StreamEvent{Type: typ}, now.Add(time.Duration(i)*time.Second))
+	}
+	l.Add("test111", base.StreamEvent{Type: StreamEventTypePubStart}, now.Add(30*time.Second))
+
+	// 超过上限时丢弃最旧的
+	events := l.Query("test110", 0)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, StreamEventTypeCodecChange, events[0].Type)
+	assert.Equal(t, StreamEventTypePubStop, events[2].Type)
+	assert.Equal(t, now.Add(3*time.Second).Format("2006-01-02 15:04:05.000"), events[2].Time)
+
+	events = l.Query("test110", 2)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, StreamEventTypeKick, events[0].Type)
+	assert.Equal(t, true, l.Query("test112", 0) == nil)
+
+	// 最后一个事件之后超过keep_minutes的流被删除
+	l.Cleanup(now.Add(80 * time.Second))
+	assert.Equal(t, 0, len(l.Query("test110", 0)))
+	assert.Equal(t, 1, len(l.Query("test111", 0)))
+
+	// 超过max_streams时删除最后一个事件最旧的流
+	l = NewStreamEventLog(StreamEventConfig{
+		Enable:     true,
+		MaxStreams: 2,
+	})
+	l.Add("test110", base.StreamEvent{Type: StreamEventTypePubStart}, now)
+	l.Add("test111", base.StreamEvent{Type: StreamEventTypePubStart}, now.Add(time.Second))
+	l.Add("test110", base.StreamEvent{Type: StreamEventTypePubStop}, now.Add(2*time.Second))
+	l.Add("test112", base.StreamEvent{Type: StreamEventTypePubStart}, now.Add(3*time.Second))
+	assert.Equal(t, 2, len(l.Query("test110", 0)))
+	assert.Equal(t, true, l.Query("test111", 0) == nil)
+	assert.Equal(t, 1, len(l.Query("test112", 0)))
+}
+
+type streamEventTestNotifyHandler struct {
+	INotifyHandler
+	relayPullStarts []base.RelayPullStartInfo
+}
+
+func (h *streamEventTestNotifyHandler) OnRelayPullStart(info base.RelayPullStartInfo) {
+	h.relayPullStarts = append(h.relayPullStarts, info)
+}
+
+func TestStreamEventNotifyHandler(t *testing.T) {
+	l := NewStreamEventLog(StreamEventConfig{Enable: true})
+	inner := &streamEventTestNotifyHandler{}
+	h := &streamEventNotifyHandler{INotifyHandler: inner, log: l}
+
+	var info base.RelayPullStartInfo
+	info.SessionId = "RTMPPULL1"
+	info.Url = "rtmp://127.0.0.1/live/test110"
+	info.StreamName = "test110"
+	info.Reason = "connection refused"
+	info.RetryCount = 2
+	info.RequestId = "REQ1"
+	h.OnRelayPullStart(info)
+
+	// 交给实际的handler
+	assert.Equal(t, 1, len(inner.relayPullStarts))
+
+	events := l.Query("test110", 0)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, StreamEventTypeRelayPullStart, events[0].Type)
+	assert.Equal(t, "RTMPPULL1", events[0].SessionId)
+	assert.Equal(t, "REQ1", events[0].RequestId)
+	assert.Equal(t, "url=rtmp://127.0.0.1/live/test110, failed, reason=connection refused, retry count=2", events[0].Detail)
+}
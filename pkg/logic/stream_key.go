@@ -0,0 +1,225 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	streamKeyRandomBytes = 16
+
+	// streamKeyMaxCacheNum `resolve_url`解析结果的最大缓存数量，超过时不再缓存，避免随机的密钥占用内存
+	streamKeyMaxCacheNum = 10000
+)
+
+// StreamKeyResolver 推流密钥和公开流名称之间的映射
+//
+// 推流url中的流名称为密钥，解析为公开的流名称后再进入group，播放、录制、事件通知等都使用公开的流名称，密钥不会出现在播放地址中。
+// 先查找静态配置以及通过http api更换后的映射（见 Rotate ），找不到时请求`resolve_url`。
+// 有密钥的流名称不能直接用来推流，避免绕过密钥。
+//
+// `resolve_url`的解析结果按密钥缓存`cache_ttl_sec`，期间业务方修改的映射不会立即生效
+//
+// 并发安全
+//
+type StreamKeyResolver struct {
+	config   StreamKeyConfig
+	outbound *OutboundPool
+
+	mutex    sync.Mutex
+	key2Name map[string]string
+	name2Key map[string]string
+	cache    map[string]streamKeyCacheItem // key: 密钥，`resolve_url`的解析结果
+}
+
+type streamKeyCacheItem struct {
+	streamName string
+	err        error
+	expireTime time.Time
+}
+
+func NewStreamKeyResolver(config StreamKeyConfig, outbound *OutboundPool) *StreamKeyResolver {
+	r := &StreamKeyResolver{
+		config:   config,
+		outbound: outbound,
+		key2Name: make(map[string]string),
+		name2Key: make(map[string]string),
+		cache:    make(map[string]streamKeyCacheItem),
+	}
+	for _, item := range config.Keys {
+		r.key2Name[item.StreamKey] = item.StreamName
+		r.name2Key[item.StreamName] = item.StreamKey
+	}
+	return r
+}
+
+// Resolve 将推流url中的流名称`info.StreamName`解析为公开的流名称
+//
+// @return 不需要修改时，返回`info.StreamName`
+//
+func (r *StreamKeyResolver) Resolve(info base.SessionEventCommonInfo) (string, error) {
+	r.mutex.Lock()
+	name, ok := r.key2Name[info.StreamName]
+	_, isPublicName := r.name2Key[info.StreamName]
+	r.mutex.Unlock()
+	if ok {
+		return name, nil
+	}
+	if isPublicName {
+		return "", fmt.Errorf("%w. publish with public stream name. stream=%s", base.ErrStreamKeyUnknown, info.StreamName)
+	}
+
+	if r.config.ResolveUrl != "" {
+		name, err := r.resolveByUrl(info)
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			return name, nil
+		}
+	}
+
+	if r.config.RejectUnknown {
+		return "", base.ErrStreamKeyUnknown
+	}
+	return info.StreamName, nil
+}
+
+// Rotate 更换流`streamName`的密钥，旧的密钥立即失效。流之前没有密钥时，新增映射
+//
+// @param key 为空时随机生成
+//
+// @return 新的密钥。`key`已经被其他流使用时返回错误
+//
+func (r *StreamKeyResolver) Rotate(streamName string, key string) (string, error) {
+	if key == "" {
+		b := make([]byte, streamKeyRandomBytes)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		key = hex.EncodeToString(b)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if name, ok := r.key2Name[key]; ok && name != streamName {
+		return "", fmt.Errorf("%w. stream key already used by other stream", base.ErrStreamKeyConflict)
+	}
+	if _, ok := r.name2Key[key]; ok {
+		return "", fmt.Errorf("%w. stream key same as a public stream name", base.ErrStreamKeyConflict)
+	}
+	if prev, ok := r.name2Key[streamName]; ok {
+		delete(r.key2Name, prev)
+	}
+	// 之前由`resolve_url`解析到该流的密钥也需要失效
+	for k, item := range r.cache {
+		if item.streamName == streamName {
+			delete(r.cache, k)
+		}
+	}
+	r.key2Name[key] = streamName
+	r.name2Key[streamName] = key
+	return key, nil
+}
+
+// KeyOf 查找流`streamName`的密钥，包括静态配置以及通过 Rotate 更换后的映射，不包括`resolve_url`的解析结果
+//
+func (r *StreamKeyResolver) KeyOf(streamName string) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key, ok := r.name2Key[streamName]
+	return key, ok
+}
+
+func (r *StreamKeyResolver) resolveByUrl(info base.SessionEventCommonInfo) (string, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	item, ok := r.cache[info.StreamName]
+	r.mutex.Unlock()
+	if ok && now.Before(item.expireTime) {
+		return item.streamName, item.err
+	}
+
+	body, err := r.outbound.PostSync(OutboundRequest{
+		Url: r.config.ResolveUrl,
+		Info: base.StreamKeyResolveInfo{
+			ServerId:   info.ServerId,
+			Protocol:   info.Protocol,
+			SessionId:  info.SessionId,
+			RemoteAddr: info.RemoteAddr,
+			AppName:    info.AppName,
+			StreamKey:  info.StreamName,
+			UrlParam:   info.UrlParam,
+		},
+		Timeout: time.Duration(r.config.TimeoutMs) * time.Millisecond,
+	})
+	if err != nil {
+		// 解析服务不可用时拒绝推流，避免绕过密钥
+		return "", fmt.Errorf("%w. resolve failed. err=%+v", base.ErrStreamKeyDenied, err)
+	}
+	var v base.StreamKeyResolveResp
+	if err = json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("%w. invalid resolve resp. err=%+v", base.ErrStreamKeyDenied, err)
+	}
+	if v.ErrorCode != base.ErrorCodeSucc {
+		err = fmt.Errorf("%w. error code=%d, desp=%s", base.ErrStreamKeyDenied, v.ErrorCode, v.Desp)
+		r.addCache(info.StreamName, "", err, now)
+		return "", err
+	}
+	r.addCache(info.StreamName, v.Data.StreamName, nil, now)
+	return v.Data.StreamName, nil
+}
+
+// replaceUrlStreamName 将url路径的最后一级（推流密钥）替换为`streamName`，保留url参数
+//
+func replaceUrlStreamName(rawUrl string, streamName string) string {
+	path, query := rawUrl, ""
+	if i := strings.IndexByte(rawUrl, '?'); i != -1 {
+		path, query = rawUrl[:i], rawUrl[i:]
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i == -1 {
+		// 无法定位密钥时不返回原始url，避免泄露密钥
+		return ""
+	}
+	return path[:i+1] + streamName + query
+}
+
+func (r *StreamKeyResolver) addCache(key string, streamName string, err error, now time.Time) {
+	if r.config.CacheTtlSec <= 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.cache) >= streamKeyMaxCacheNum {
+		for k, item := range r.cache {
+			if !now.Before(item.expireTime) {
+				delete(r.cache, k)
+			}
+		}
+		if len(r.cache) >= streamKeyMaxCacheNum {
+			return
+		}
+	}
+	r.cache[key] = streamKeyCacheItem{
+		streamName: streamName,
+		err:        err,
+		expireTime: now.Add(time.Duration(r.config.CacheTtlSec) * time.Second),
+	}
+}
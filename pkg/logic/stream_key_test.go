@@ -0,0 +1,129 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+func TestStreamKeyResolver(t *testing.T) {
+	var resolveCount int32
+	resolveSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&resolveCount, 1)
+		var info base.StreamKeyResolveInfo
+		_ = nazahttp.UnmarshalRequestJsonBody(r, &info)
+		var v base.StreamKeyResolveResp
+		switch info.StreamKey {
+		case "remote_key":
+			v.ErrorCode = base.ErrorCodeSucc
+			v.Data.StreamName = "remote_stream"
+		case "forbidden_key":
+			v.ErrorCode = 403
+		default:
+			v.ErrorCode = base.ErrorCodeSucc
+		}
+		feedback(v, w)
+	}))
+	defer resolveSrv.Close()
+
+	outbound := NewOutboundPool(OutboundConfig{})
+	defer outbound.Dispose()
+
+	resolve := func(r *StreamKeyResolver, streamName string) (string, error) {
+		var info base.SessionEventCommonInfo
+		info.StreamName = streamName
+		return r.Resolve(info)
+	}
+
+	r := NewStreamKeyResolver(StreamKeyConfig{
+		Keys:       []StreamKeyItem{{StreamKey: "k1", StreamName: "test110"}},
+		ResolveUrl: resolveSrv.URL,
+	}, outbound)
+	name, err := resolve(r, "k1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test110", name)
+	// 有密钥的流名称不能直接推流
+	_, err = resolve(r, "test110")
+	assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyUnknown))
+	name, err = resolve(r, "remote_key")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "remote_stream", name)
+	_, err = resolve(r, "forbidden_key")
+	assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyDenied))
+	// 解析服务没有返回流名称，并且没有开启reject_unknown，使用原名称
+	name, err = resolve(r, "other")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "other", name)
+
+	// 更换密钥
+	key, err := r.Rotate("test110", "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, streamKeyRandomBytes*2, len(key))
+	name, err = resolve(r, key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test110", name)
+	// 旧密钥失效，没有开启reject_unknown时作为普通的流名称
+	name, err = resolve(r, "k1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "k1", name)
+	_, err = r.Rotate("test111", key)
+	assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyConflict))
+	_, err = r.Rotate("test111", "test110")
+	assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyConflict))
+
+	r = NewStreamKeyResolver(StreamKeyConfig{RejectUnknown: true}, outbound)
+	_, err = resolve(r, "other")
+	assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyUnknown))
+
+	// 缓存解析结果，包括拒绝
+	r = NewStreamKeyResolver(StreamKeyConfig{ResolveUrl: resolveSrv.URL, CacheTtlSec: 60}, outbound)
+	atomic.StoreInt32(&resolveCount, 0)
+	for i := 0; i < 3; i++ {
+		name, err = resolve(r, "remote_key")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "remote_stream", name)
+		_, err = resolve(r, "forbidden_key")
+		assert.Equal(t, true, errors.Is(err, base.ErrStreamKeyDenied))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&resolveCount))
+	// 更换密钥后，之前解析到该流的缓存失效
+	_, err = r.Rotate("remote_stream", "")
+	assert.Equal(t, nil, err)
+	_, err = resolve(r, "remote_key")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&resolveCount))
+}
+
+func TestReplaceUrlStreamName(t *testing.T) {
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110", replaceUrlStreamName("rtmp://127.0.0.1/live/k1", "test110"))
+	assert.Equal(t, "rtsp://127.0.0.1:5544/live/test110?a=1&b=2", replaceUrlStreamName("rtsp://127.0.0.1:5544/live/k1?a=1&b=2", "test110"))
+	assert.Equal(t, "", replaceUrlStreamName("k1", "test110"))
+}
+
+func TestServerManager_ResolveStreamKeyUrl(t *testing.T) {
+	sm := &ServerManager{
+		streamKey: NewStreamKeyResolver(StreamKeyConfig{Keys: []StreamKeyItem{{StreamKey: "k1", StreamName: "test110"}}}, nil),
+	}
+	var info base.SessionEventCommonInfo
+	info.Url = "rtmp://127.0.0.1/live/k1?token=t"
+	info.StreamName = "k1"
+	var session base.HttpSubSession
+	assert.Equal(t, nil, sm.resolveStreamKey(&info, &session))
+	assert.Equal(t, "test110", info.StreamName)
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110?token=t", info.Url)
+	assert.Equal(t, "rtmp://127.0.0.1/live/test110", sm.pubSessionUrl("rtmp://127.0.0.1/live/k1", "test110"))
+}
@@ -0,0 +1,132 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ForStream 返回流`streamName`实际使用的配置
+//
+// 按顺序应用所有匹配的 StreamOverrideConfig ，后面的覆盖前面的。
+// 开启了`reuse_port`并且流不归属当前worker时，再修改为从归属的worker回源和转推，见 applyReusePortRelay 。
+// 不需要修改时，直接返回`c`
+//
+func (c *Config) ForStream(streamName string) *Config {
+	var ret *Config
+	for i := range c.StreamOverrides {
+		o := &c.StreamOverrides[i]
+		if !o.Match(streamName) {
+			continue
+		}
+		if ret == nil {
+			tmp := *c
+			ret = &tmp
+		}
+		o.applyTo(ret)
+	}
+	if c.ReusePortConfig.Enable {
+		if owner := c.ReusePortConfig.OwnerOf(streamName); owner != c.ReusePortConfig.WorkerIndex {
+			if ret == nil {
+				tmp := *c
+				ret = &tmp
+			}
+			ret.applyReusePortRelay(owner)
+		}
+	}
+	if ret == nil {
+		return c
+	}
+	return ret
+}
+
+// Match 流名称是否匹配`StreamNamePattern`
+//
+func (o *StreamOverrideConfig) Match(streamName string) bool {
+	return matchStreamNamePattern(o.StreamNamePattern, o.re, streamName)
+}
+
+// Match 流名称是否匹配`StreamNamePattern`
+//
+func (s *TranscodeStageConfig) Match(streamName string) bool {
+	return matchStreamNamePattern(s.StreamNamePattern, s.re, streamName)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (o *StreamOverrideConfig) compile() (err error) {
+	o.re, err = compileStreamNamePattern(o.StreamNamePattern)
+	return
+}
+
+func (s *TranscodeStageConfig) compile() (err error) {
+	s.re, err = compileStreamNamePattern(s.StreamNamePattern)
+	return
+}
+
+// 默认为通配符（path.Match），以`~`开头则为正则表达式
+//
+// @param re: 预先编译好的正则表达式，为nil时临时编译
+//
+func matchStreamNamePattern(pattern string, re *regexp.Regexp, streamName string) bool {
+	if strings.HasPrefix(pattern, "~") {
+		if re == nil {
+			// 没有经过配置文件加载流程时，临时编译
+			var err error
+			if re, err = regexp.Compile(pattern[1:]); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(streamName)
+	}
+	ok, err := path.Match(pattern, streamName)
+	return err == nil && ok
+}
+
+func compileStreamNamePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "~") {
+		return regexp.Compile(pattern[1:])
+	}
+	_, err := path.Match(pattern, "")
+	return nil, err
+}
+
+func (o *StreamOverrideConfig) applyTo(c *Config) {
+	if o.HlsFragmentDurationMs != nil {
+		c.HlsConfig.FragmentDurationMs = *o.HlsFragmentDurationMs
+	}
+	if o.GopNum != nil {
+		c.RtmpConfig.GopNum = *o.GopNum
+		c.HttpflvConfig.GopNum = *o.GopNum
+		c.HttptsConfig.GopNum = *o.GopNum
+	}
+	if o.RecordFlvEnable != nil {
+		c.RecordConfig.EnableFlv = *o.RecordFlvEnable
+	}
+	if o.RecordMpegtsEnable != nil {
+		c.RecordConfig.EnableMpegts = *o.RecordMpegtsEnable
+	}
+	o.applyToSimpleAuth(&c.SimpleAuthConfig)
+}
+
+func (o *StreamOverrideConfig) applyToSimpleAuth(c *SimpleAuthConfig) {
+	if o.AuthPubEnable != nil {
+		c.PubRtmpEnable = *o.AuthPubEnable
+		c.PubRtspEnable = *o.AuthPubEnable
+	}
+	if o.AuthSubEnable != nil {
+		c.SubRtmpEnable = *o.AuthSubEnable
+		c.SubHttpflvEnable = *o.AuthSubEnable
+		c.SubHttptsEnable = *o.AuthSubEnable
+		c.SubRtspEnable = *o.AuthSubEnable
+		c.HlsM3u8Enable = *o.AuthSubEnable
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestConfigForStream(t *testing.T) {
+	gopNum := 1
+	fragmentDurationMs := 2000
+	enable := true
+	disable := false
+
+	var config Config
+	config.RtmpConfig.GopNum = 2
+	config.HlsConfig.FragmentDurationMs = 3000
+	config.StreamOverrides = []StreamOverrideConfig{
+		{StreamNamePattern: "premium_*", GopNum: &gopNum, RecordFlvEnable: &enable},
+		{StreamNamePattern: "~^premium_\\d+$", HlsFragmentDurationMs: &fragmentDurationMs, RecordFlvEnable: &disable},
+	}
+
+	c := config.ForStream("test110")
+	assert.Equal(t, &config, c)
+
+	c = config.ForStream("premium_a")
+	assert.Equal(t, 1, c.RtmpConfig.GopNum)
+	assert.Equal(t, 1, c.HttpflvConfig.GopNum)
+	assert.Equal(t, 3000, c.HlsConfig.FragmentDurationMs)
+	assert.Equal(t, true, c.RecordConfig.EnableFlv)
+
+	c = config.ForStream("premium_110")
+	assert.Equal(t, 1, c.RtmpConfig.GopNum)
+	assert.Equal(t, 2000, c.HlsConfig.FragmentDurationMs)
+	assert.Equal(t, false, c.RecordConfig.EnableFlv)
+
+	// 原始配置不受影响
+	assert.Equal(t, 2, config.RtmpConfig.GopNum)
+	assert.Equal(t, 3000, config.HlsConfig.FragmentDurationMs)
+}
+
+func TestSimpleAuthCtxStreamOverride(t *testing.T) {
+	enable := true
+	ctx := NewSimpleAuthCtx(SimpleAuthConfig{Key: "q191201771"},
+		StreamOverrideConfig{StreamNamePattern: "premium_*", AuthSubEnable: &enable})
+
+	var info base.SubStartInfo
+	info.Protocol = base.ProtocolHttpflv
+	info.StreamName = "test110"
+	assert.Equal(t, nil, ctx.OnSubStart(info))
+
+	info.StreamName = "premium_a"
+	assert.Equal(t, base.ErrSimpleAuthParamNotFound, ctx.OnSubStart(info))
+	assert.Equal(t, base.ErrSimpleAuthParamNotFound, ctx.OnHls("premium_a", ""))
+}
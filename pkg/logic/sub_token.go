@@ -0,0 +1,93 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// SubTokenRegistry 单token单拉流，每个token同时只允许一个拉流session，新的拉流会踢掉使用相同token的旧拉流，
+// 用于付费内容等一个账号只允许一处观看的场景，见 SimpleAuthConfig.SingleSubPerToken
+//
+// token从拉流url的参数中获取，没有token的拉流不受限制。
+// 注意，本身不做鉴权，token的校验由simple_auth或者业务方的自定义鉴权完成。
+// token需要是每个观看者独有的，不能使用simple_auth的lal_secret，它对同一路流的所有观看者都相同
+//
+// 并发安全，方法可以在nil上调用，此时表示没有开启
+//
+type SubTokenRegistry struct {
+	tokenParam string
+
+	mutex     sync.Mutex
+	token2Sub map[string]subTokenItem
+}
+
+type subTokenItem struct {
+	streamName string
+	sessionId  string
+	session    base.IServerSessionLifecycle
+}
+
+// NewSubTokenRegistry @param tokenParam: token所在的url参数名，调用方需保证不为空，见 SimpleAuthConfig.checkTokenParam
+//
+func NewSubTokenRegistry(tokenParam string) *SubTokenRegistry {
+	return &SubTokenRegistry{
+		tokenParam: tokenParam,
+		token2Sub:  make(map[string]subTokenItem),
+	}
+}
+
+// OnSubStart 拉流鉴权通过后调用，如果token已经有拉流session，则关闭旧的session
+//
+func (r *SubTokenRegistry) OnSubStart(info base.SessionEventCommonInfo, session base.IServerSessionLifecycle) {
+	token := r.token(info.UrlParam)
+	if token == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if prev, ok := r.token2Sub[token]; ok && prev.sessionId != info.SessionId {
+		Log.Infof("[%s] kick out previous sub session with same token. prev session=%s, prev stream=%s, stream=%s",
+			info.SessionId, prev.sessionId, prev.streamName, info.StreamName)
+		// 注意，关闭后旧session的OnDel回调在session自己的协程中异步执行，此处不会死锁
+		_ = prev.session.Dispose()
+	}
+	r.token2Sub[token] = subTokenItem{
+		streamName: info.StreamName,
+		sessionId:  info.SessionId,
+		session:    session,
+	}
+}
+
+func (r *SubTokenRegistry) OnSubStop(info base.SessionEventCommonInfo) {
+	token := r.token(info.UrlParam)
+	if token == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// 被踢掉的旧session，token已经属于新的session了
+	if item, ok := r.token2Sub[token]; ok && item.sessionId == info.SessionId {
+		delete(r.token2Sub, token)
+	}
+}
+
+func (r *SubTokenRegistry) token(rawQuery string) string {
+	if r == nil || rawQuery == "" {
+		return ""
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	return q.Get(r.tokenParam)
+}
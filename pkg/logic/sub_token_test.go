@@ -0,0 +1,68 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testDisposeSession struct {
+	disposed int
+}
+
+func (s *testDisposeSession) Dispose() error {
+	s.disposed++
+	return nil
+}
+
+func TestSubTokenRegistry(t *testing.T) {
+	var nilRegistry *SubTokenRegistry
+	nilRegistry.OnSubStart(base.SessionEventCommonInfo{UrlParam: "lal_secret=a"}, &testDisposeSession{})
+	nilRegistry.OnSubStop(base.SessionEventCommonInfo{UrlParam: "lal_secret=a"})
+
+	r := NewSubTokenRegistry("token")
+	info := func(sessionId string, urlParam string) base.SessionEventCommonInfo {
+		return base.SessionEventCommonInfo{SessionId: sessionId, StreamName: "test110", UrlParam: urlParam}
+	}
+
+	// 没有token不受限制
+	s0 := &testDisposeSession{}
+	r.OnSubStart(info("s0", "lal_secret=a"), s0)
+	assert.Equal(t, 0, len(r.token2Sub))
+
+	s1 := &testDisposeSession{}
+	s2 := &testDisposeSession{}
+	s3 := &testDisposeSession{}
+	r.OnSubStart(info("s1", "token=a"), s1)
+	r.OnSubStart(info("s2", "x=1&token=b"), s2)
+	assert.Equal(t, 0, s1.disposed)
+
+	// 相同token踢掉旧的
+	r.OnSubStart(info("s3", "token=a"), s3)
+	assert.Equal(t, 1, s1.disposed)
+	assert.Equal(t, 0, s2.disposed)
+
+	// 旧session的stop不影响新session
+	r.OnSubStop(info("s1", "token=a"))
+	assert.Equal(t, "s3", r.token2Sub["a"].sessionId)
+	r.OnSubStop(info("s3", "token=a"))
+	_, ok := r.token2Sub["a"]
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 1, len(r.token2Sub))
+}
+
+func TestSimpleAuthConfig_CheckTokenParam(t *testing.T) {
+	assert.Equal(t, nil, (&SimpleAuthConfig{}).checkTokenParam())
+	assert.IsNotNil(t, (&SimpleAuthConfig{SingleSubPerToken: true}).checkTokenParam())
+	assert.IsNotNil(t, (&SimpleAuthConfig{SingleSubPerToken: true, TokenParam: "lal_secret"}).checkTokenParam())
+	assert.Equal(t, nil, (&SimpleAuthConfig{SingleSubPerToken: true, TokenParam: "token"}).checkTokenParam())
+}
@@ -0,0 +1,59 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// Get 返回名称为`name`的profile构造好的 tls.Config
+//
+func (c *TlsConfig) Get(name string) (*tls.Config, bool) {
+	tc, ok := c.name2TlsConfig[name]
+	return tc, ok
+}
+
+// TlsConfigOf 返回https监听地址使用的 tls.Config ，没有引用profile时返回nil，此时使用`https_cert_file`和`https_key_file`
+//
+func (c *Config) TlsConfigOf(addrConfig CommonHttpAddrConfig) *tls.Config {
+	if addrConfig.HttpsTlsProfile == "" {
+		return nil
+	}
+	tc, _ := c.TlsConfig.Get(addrConfig.HttpsTlsProfile)
+	return tc
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// compile 检查所有profile，并加载证书，在启动阶段发现配置错误
+//
+// @param checkOnly: 只检查配置时为true，不启动OCSP等后台更新，见 base.TlsOption.CheckOnly
+//
+func (c *TlsConfig) compile(checkOnly bool) error {
+	c.name2TlsConfig = make(map[string]*tls.Config, len(c.Profiles))
+	for _, p := range c.Profiles {
+		if p.Name == "" {
+			return fmt.Errorf("%w. profile name empty", base.ErrTlsOption)
+		}
+		if _, ok := c.name2TlsConfig[p.Name]; ok {
+			return fmt.Errorf("%w. duplicate profile name. name=%s", base.ErrTlsOption, p.Name)
+		}
+		option := p.TlsOption
+		option.CheckOnly = checkOnly
+		tc, err := base.NewTlsConfig(option)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		c.name2TlsConfig[p.Name] = tc
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	defaultRequestIdHeader = "X-Request-ID"
+	bootIdRandomBytes      = 3
+
+	// SessionTagKeyRequestId http api触发的回源拉流session，会打上触发请求的request id标签
+	SessionTagKeyRequestId = "request_id"
+)
+
+// globalSessionIdPrefix 开启`trace.global_session_id`时session id的前缀，由server id和本次启动的随机串组成
+//
+func globalSessionIdPrefix(serverId string) string {
+	b := make([]byte, bootIdRandomBytes)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%s-", serverId, hex.EncodeToString(b))
+}
+
+type requestIdCtxKey struct{}
+
+// withRequestId 读取调用方通过header携带的request id，没有时生成一个，放入请求的context中，并在响应的header中返回
+//
+// handler可以通过 requestIdFromContext 获取，传递给由该请求创建的session，用于关联事件通知、stat以及日志
+//
+func withRequestId(header string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(header)
+		if id == "" {
+			id = base.GenUkHttpApiRequest()
+		}
+		w.Header().Set(header, id)
+		next(w, req.WithContext(context.WithValue(req.Context(), requestIdCtxKey{}, id)))
+	}
+}
+
+func requestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdCtxKey{}).(string)
+	return id
+}
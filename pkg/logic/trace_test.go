@@ -0,0 +1,48 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestWithRequestId(t *testing.T) {
+	var got string
+	h := withRequestId(defaultRequestIdHeader, func(w http.ResponseWriter, req *http.Request) {
+		got = requestIdFromContext(req.Context())
+	})
+
+	// 调用方携带了request id
+	req := httptest.NewRequest(http.MethodPost, "/api/ctrl/start_pull", nil)
+	req.Header.Set(defaultRequestIdHeader, "dispatch-1")
+	w := httptest.NewRecorder()
+	h(w, req)
+	assert.Equal(t, "dispatch-1", got)
+	assert.Equal(t, "dispatch-1", w.Header().Get(defaultRequestIdHeader))
+
+	// 没有携带时生成
+	req = httptest.NewRequest(http.MethodPost, "/api/ctrl/start_pull", nil)
+	w = httptest.NewRecorder()
+	h(w, req)
+	assert.Equal(t, true, strings.HasPrefix(got, base.UkPreHttpApiRequest))
+	assert.Equal(t, got, w.Header().Get(defaultRequestIdHeader))
+}
+
+func TestGlobalSessionIdPrefix(t *testing.T) {
+	p := globalSessionIdPrefix("node1")
+	assert.Equal(t, true, strings.HasPrefix(p, "node1-"))
+	assert.Equal(t, len("node1-")+bootIdRandomBytes*2+1, len(p))
+	assert.Equal(t, true, p != globalSessionIdPrefix("node1"))
+}
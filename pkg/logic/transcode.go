@@ -0,0 +1,277 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcoder 内置的转码编排模块
+//
+// 输入流开始时，对于流名称匹配的每个`transcode.stages`配置，启动一个ffmpeg进程，从本机rtmp拉取原始流，处理后再推回本机，
+// 流名称为原始流名称加上`out_stream_suffix`。输入流结束时，关闭对应的ffmpeg进程
+//
+// ffmpeg进程异常退出时，如果输入流还在，则间隔 transcodeRestartInterval 后重新启动
+//
+// ffmpeg拉流的url携带随机生成的`lal_transcode_token`参数，用于识别内部的拉流，不计入观看人数，见 IsInternalSub
+//
+type Transcoder struct {
+	config     TranscodeConfig
+	rtmpAddr   string
+	authConfig SimpleAuthConfig
+	token      string
+
+	mutex sync.Mutex
+	procs map[string]*transcodeProc // key: appName/streamName/stage index
+}
+
+type transcodeProc struct {
+	args []string
+	cmd  *exec.Cmd
+}
+
+const transcodeRestartInterval = 2 * time.Second
+
+const (
+	transcodeTokenParam  = "lal_transcode_token"
+	internalSubTagKey    = "lal_internal"
+	internalSubTranscode = "transcode"
+)
+
+const (
+	defaultLoudnormIntegratedLufs   = -23
+	defaultLoudnormLoudnessRange    = 7
+	defaultLoudnormTruePeakDbtp     = -2
+	defaultLoudnormAudioBitrateKbps = 128
+)
+
+// NewTranscoder
+//
+// @param rtmpAddr:   本机rtmp服务的监听地址
+// @param authConfig: 用于给ffmpeg拉流、推流地址加上鉴权参数
+//
+func NewTranscoder(config TranscodeConfig, rtmpAddr string, authConfig SimpleAuthConfig) *Transcoder {
+	if config.FfmpegPath == "" {
+		config.FfmpegPath = "ffmpeg"
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return &Transcoder{
+		config:     config,
+		rtmpAddr:   localRtmpAddr(rtmpAddr),
+		authConfig: authConfig,
+		token:      hex.EncodeToString(b),
+		procs:      make(map[string]*transcodeProc),
+	}
+}
+
+// IsInternalSub 拉流的url参数`rawQuery`是否为转码的ffmpeg拉流
+//
+func (t *Transcoder) IsInternalSub(rawQuery string) bool {
+	if t == nil {
+		return false
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(q.Get(transcodeTokenParam)), []byte(t.token)) == 1
+}
+
+func (t *Transcoder) OnPubStart(appName string, streamName string) {
+	if t.isOutStream(streamName) {
+		return
+	}
+	for i := range t.config.Stages {
+		stage := &t.config.Stages[i]
+		if !stage.Match(streamName) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%d", appName, streamName, i)
+		inUrl := t.makePullUrl(appName, streamName)
+		outUrl := t.makeRtmpUrl(appName, streamName+stage.OutStreamSuffix)
+		proc := &transcodeProc{
+			args: buildFfmpegArgs(inUrl, outUrl, stage),
+		}
+
+		t.mutex.Lock()
+		if old, ok := t.procs[key]; ok {
+			t.killProc(old)
+		}
+		t.procs[key] = proc
+		t.startProc(key, proc)
+		t.mutex.Unlock()
+	}
+}
+
+func (t *Transcoder) OnPubStop(appName string, streamName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	prefix := fmt.Sprintf("%s/%s/", appName, streamName)
+	for key, proc := range t.procs {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.procs, key)
+			t.killProc(proc)
+		}
+	}
+}
+
+func (t *Transcoder) Dispose() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for key, proc := range t.procs {
+		delete(t.procs, key)
+		t.killProc(proc)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// 注意，调用方需持有锁
+func (t *Transcoder) startProc(key string, proc *transcodeProc) {
+	cmd := exec.Command(t.config.FfmpegPath, proc.args...)
+	if err := cmd.Start(); err != nil {
+		Log.Errorf("transcode start ffmpeg failed. key=%s, err=%+v", key, err)
+		return
+	}
+	Log.Infof("transcode start ffmpeg. key=%s, pid=%d, args=%s", key, cmd.Process.Pid, strings.Join(proc.args, " "))
+	proc.cmd = cmd
+
+	go func() {
+		err := cmd.Wait()
+		Log.Infof("transcode ffmpeg exit. key=%s, pid=%d, err=%+v", key, cmd.Process.Pid, err)
+
+		time.Sleep(transcodeRestartInterval)
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		// 已经被关闭或者已经被替换，不再重启
+		if p, ok := t.procs[key]; !ok || p != proc || p.cmd != cmd {
+			return
+		}
+		t.startProc(key, proc)
+	}()
+}
+
+// 注意，调用方需持有锁
+func (t *Transcoder) killProc(proc *transcodeProc) {
+	if proc.cmd == nil || proc.cmd.Process == nil {
+		return
+	}
+	_ = proc.cmd.Process.Kill()
+	proc.cmd = nil
+}
+
+// 转码输出的流也会触发输入流开始事件，需要过滤掉，避免循环转码
+func (t *Transcoder) isOutStream(streamName string) bool {
+	for _, stage := range t.config.Stages {
+		if stage.OutStreamSuffix != "" && strings.HasSuffix(streamName, stage.OutStreamSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Transcoder) makePullUrl(appName string, streamName string) string {
+	u := t.makeRtmpUrl(appName, streamName)
+	if strings.Contains(u, "?") {
+		return u + "&" + transcodeTokenParam + "=" + t.token
+	}
+	return u + "?" + transcodeTokenParam + "=" + t.token
+}
+
+func (t *Transcoder) makeRtmpUrl(appName string, streamName string) string {
+	url := fmt.Sprintf("rtmp://%s/%s/%s", t.rtmpAddr, appName, streamName)
+	if t.authConfig.Key != "" {
+		url = fmt.Sprintf("%s?%s=%s", url, secretName, SimpleAuthCalcSecret(t.authConfig.Key, streamName))
+	}
+	return url
+}
+
+// buildFfmpegArgs 根据stage的配置，生成ffmpeg的参数
+//
+// 没有配置对应处理阶段的音频、视频，直接拷贝，不做重新编码
+//
+func buildFfmpegArgs(inUrl string, outUrl string, stage *TranscodeStageConfig) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", inUrl}
+	if stage.Watermark != nil {
+		args = append(args, "-i", stage.Watermark.ImagePath,
+			"-filter_complex", fmt.Sprintf("[0:v][1:v]overlay=%s", stage.Watermark.overlayExpr()),
+			"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	if stage.Loudnorm != nil {
+		args = append(args, "-af", stage.Loudnorm.filterExpr(),
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", stage.Loudnorm.audioBitrateKbps()))
+	} else if stage.AudioToAac {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", defaultLoudnormAudioBitrateKbps))
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, "-f", "flv", outUrl)
+	return args
+}
+
+func (w *WatermarkConfig) overlayExpr() string {
+	m := w.Margin
+	switch w.Position {
+	case WatermarkPositionTopLeft:
+		return fmt.Sprintf("%d:%d", m, m)
+	case WatermarkPositionBottomLeft:
+		return fmt.Sprintf("%d:main_h-overlay_h-%d", m, m)
+	case WatermarkPositionBottomRight:
+		return fmt.Sprintf("main_w-overlay_w-%d:main_h-overlay_h-%d", m, m)
+	case WatermarkPositionCenter:
+		return "(main_w-overlay_w)/2:(main_h-overlay_h)/2"
+	default:
+		return fmt.Sprintf("main_w-overlay_w-%d:%d", m, m)
+	}
+}
+
+func (l *LoudnormConfig) filterExpr() string {
+	i, lra, tp := l.IntegratedLufs, l.LoudnessRange, l.TruePeakDbtp
+	if i == 0 {
+		i = defaultLoudnormIntegratedLufs
+	}
+	if lra == 0 {
+		lra = defaultLoudnormLoudnessRange
+	}
+	if tp == 0 {
+		tp = defaultLoudnormTruePeakDbtp
+	}
+	return fmt.Sprintf("loudnorm=I=%g:LRA=%g:TP=%g", i, lra, tp)
+}
+
+func (l *LoudnormConfig) audioBitrateKbps() int {
+	if l.AudioBitrateKbps <= 0 {
+		return defaultLoudnormAudioBitrateKbps
+	}
+	return l.AudioBitrateKbps
+}
+
+// 监听地址为`:1935`或`0.0.0.0:1935`时，使用127.0.0.1访问
+func localRtmpAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
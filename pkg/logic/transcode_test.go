@@ -0,0 +1,76 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestBuildFfmpegArgs(t *testing.T) {
+	stage := TranscodeStageConfig{
+		StreamNamePattern: "premium_*",
+		OutStreamSuffix:   "_wm",
+		Watermark: &WatermarkConfig{
+			ImagePath: "./logo.png",
+			Position:  WatermarkPositionBottomLeft,
+			Margin:    10,
+		},
+	}
+	args := buildFfmpegArgs("rtmp://127.0.0.1:1935/live/premium_a", "rtmp://127.0.0.1:1935/live/premium_a_wm", &stage)
+	assert.Equal(t, "-hide_banner -loglevel error -i rtmp://127.0.0.1:1935/live/premium_a -i ./logo.png "+
+		"-filter_complex [0:v][1:v]overlay=10:main_h-overlay_h-10 -c:v libx264 -preset veryfast -tune zerolatency "+
+		"-c:a copy -f flv rtmp://127.0.0.1:1935/live/premium_a_wm", strings.Join(args, " "))
+
+	stage.Watermark = nil
+	args = buildFfmpegArgs("in", "out", &stage)
+	assert.Equal(t, "-hide_banner -loglevel error -i in -c:v copy -c:a copy -f flv out", strings.Join(args, " "))
+
+	stage.AudioToAac = true
+	args = buildFfmpegArgs("in", "out", &stage)
+	assert.Equal(t, "-hide_banner -loglevel error -i in -c:v copy -c:a aac -b:a 128k -f flv out", strings.Join(args, " "))
+
+	stage.Loudnorm = &LoudnormConfig{IntegratedLufs: -16}
+	args = buildFfmpegArgs("in", "out", &stage)
+	assert.Equal(t, "-hide_banner -loglevel error -i in -c:v copy -af loudnorm=I=-16:LRA=7:TP=-2 -c:a aac -b:a 128k -f flv out",
+		strings.Join(args, " "))
+}
+
+func TestTranscoder(t *testing.T) {
+	tr := NewTranscoder(TranscodeConfig{
+		Stages: []TranscodeStageConfig{{StreamNamePattern: "premium_*", OutStreamSuffix: "_wm"}},
+	}, ":1935", SimpleAuthConfig{Key: "q191201771"})
+	assert.Equal(t, "127.0.0.1:1935", tr.rtmpAddr)
+	assert.Equal(t, true, tr.isOutStream("premium_a_wm"))
+	assert.Equal(t, false, tr.isOutStream("premium_a"))
+	assert.Equal(t, "rtmp://127.0.0.1:1935/live/test110?lal_secret="+SimpleAuthCalcSecret("q191201771", "test110"),
+		tr.makeRtmpUrl("live", "test110"))
+
+	// ffmpeg拉流携带内部token，不计入观看人数
+	pullUrl := tr.makePullUrl("live", "test110")
+	assert.Equal(t, true, strings.HasPrefix(pullUrl, tr.makeRtmpUrl("live", "test110")+"&lal_transcode_token="))
+	assert.Equal(t, true, tr.IsInternalSub(pullUrl[strings.Index(pullUrl, "?")+1:]))
+	assert.Equal(t, false, tr.IsInternalSub("lal_transcode_token=x"))
+	var nilTr *Transcoder
+	assert.Equal(t, false, nilTr.IsInternalSub(""))
+
+	var config Config
+	group := NewGroup("live", "test110", &config, nil)
+	group.rtmpSubSessionSet[&rtmp.ServerSession{}] = struct{}{}
+	assert.Equal(t, 1, group.ViewerCount())
+	group.MarkInternalSub("", internalSubTranscode)
+	assert.Equal(t, 0, group.ViewerCount())
+	assert.Equal(t, map[string]string{internalSubTagKey: internalSubTranscode}, group.GetSessionTags(""))
+
+	assert.Equal(t, "10.1.1.1:1935", localRtmpAddr("10.1.1.1:1935"))
+	assert.Equal(t, "127.0.0.1:1935", localRtmpAddr("0.0.0.0:1935"))
+}
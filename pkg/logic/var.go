@@ -8,7 +8,11 @@
 
 package logic
 
-import "github.com/q191201771/naza/pkg/nazalog"
+import (
+	"time"
+
+	"github.com/q191201771/naza/pkg/nazalog"
+)
 
 var Log = nazalog.GetGlobalLogger()
 
@@ -18,6 +22,10 @@ var (
 	relayPullTimeoutMs        = 5000
 	relayPullReadAvTimeoutMs  = 5000
 
+	// relayPushShapedWriteChanSize 限制了转推码率时，异步发送队列的大小，用于缓冲gop缓存等突发数据
+	//
+	relayPushShapedWriteChanSize = 1024
+
 	// calcSessionStatIntervalSec 计算所有session收发码率的时间间隔
 	//
 	calcSessionStatIntervalSec uint32 = 5
@@ -29,4 +37,20 @@ var (
 	//   注意，这里既检查socket发送阻塞，又检查上层没有给session喂数据
 	//
 	checkSessionAliveIntervalSec uint32 = 10
+
+	// recordRetentionProtectDuration 最近修改过的文件可能正在写入或者正在被拉流，录制清理时不删除（过期清理除外）
+	//
+	recordRetentionProtectDuration = 60 * time.Second
+
+	// subWaitPubKeepaliveIntervalSec 拉流等待推流期间，给rtmp sub session发送ping的间隔
+	//
+	subWaitPubKeepaliveIntervalSec uint32 = 5
+
+	// ntpSyncInterval 配置了hls.ntp_server时，ntp校时的时间间隔
+	//
+	ntpSyncInterval = 10 * time.Minute
+
+	// groupManagerShardNum 管理group的容器的分片数量，见 ShardedGroupManager
+	//
+	groupManagerShardNum = 64
 )
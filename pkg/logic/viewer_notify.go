@@ -0,0 +1,141 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"sort"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	ViewerChangeDirectionUp   = "up"
+	ViewerChangeDirectionDown = "down"
+
+	defaultViewerNotifyDebounceMs = 3000
+)
+
+// ViewerNotifier 每路流的观看人数（见 Group.ViewerCount ）跨越配置的阈值时，发送http_notify.on_viewer_change事件
+//
+// 去抖：人数跨越阈值后，需要在`debounce_ms`时间内保持在同一个阈值区间才发送，避免人数在阈值附近抖动时频繁通知。
+// 没有配置阈值时，人数的任意变化（同样经过去抖）都会通知。
+//
+// 非并发安全，由 ServerManager 的RunLoop协程调用
+//
+type ViewerNotifier struct {
+	config   ViewerNotifyConfig
+	serverId string
+	onChange func(info base.ViewerChangeInfo)
+
+	streams map[viewerStreamKey]*viewerState
+}
+
+type viewerStreamKey struct {
+	appName    string
+	streamName string
+}
+
+type viewerState struct {
+	count         int // 上一次通知时的人数
+	level         int // 上一次通知时所在的阈值区间
+	pendingLevel  int
+	pendingSince  time.Time
+	pendingActive bool
+}
+
+func NewViewerNotifier(config ViewerNotifyConfig, serverId string, onChange func(info base.ViewerChangeInfo)) *ViewerNotifier {
+	if config.DebounceMs <= 0 {
+		config.DebounceMs = defaultViewerNotifyDebounceMs
+	}
+	config.Thresholds = append([]int(nil), config.Thresholds...)
+	sort.Ints(config.Thresholds)
+	return &ViewerNotifier{
+		config:   config,
+		serverId: serverId,
+		onChange: onChange,
+		streams:  make(map[viewerStreamKey]*viewerState),
+	}
+}
+
+// Check
+//
+// @param counts: 当前所有流的观看人数，不在其中的流视为0人
+//
+func (vn *ViewerNotifier) Check(now time.Time, counts map[viewerStreamKey]int) {
+	for key, count := range counts {
+		st, ok := vn.streams[key]
+		if !ok {
+			st = &viewerState{}
+			vn.streams[key] = st
+		}
+		vn.check(now, key, st, count)
+	}
+	for key, st := range vn.streams {
+		if _, ok := counts[key]; ok {
+			continue
+		}
+		vn.check(now, key, st, 0)
+		if st.level == 0 && !st.pendingActive {
+			delete(vn.streams, key)
+		}
+	}
+}
+
+func (vn *ViewerNotifier) check(now time.Time, key viewerStreamKey, st *viewerState, count int) {
+	level := vn.level(count)
+	if level == st.level {
+		st.pendingActive = false
+		return
+	}
+	if !st.pendingActive || st.pendingLevel != level {
+		st.pendingActive = true
+		st.pendingLevel = level
+		st.pendingSince = now
+	}
+	if now.Sub(st.pendingSince) < time.Duration(vn.config.DebounceMs)*time.Millisecond {
+		return
+	}
+
+	info := base.ViewerChangeInfo{
+		ServerId:        vn.serverId,
+		AppName:         key.appName,
+		StreamName:      key.streamName,
+		ViewerCount:     count,
+		PrevViewerCount: st.count,
+		Direction:       ViewerChangeDirectionUp,
+	}
+	if level < st.level {
+		info.Direction = ViewerChangeDirectionDown
+	}
+	if len(vn.config.Thresholds) != 0 {
+		if level > st.level {
+			info.Threshold = vn.config.Thresholds[level-1]
+		} else {
+			info.Threshold = vn.config.Thresholds[level]
+		}
+	}
+	st.count = count
+	st.level = level
+	st.pendingActive = false
+
+	Log.Infof("viewer change. info=%+v", info)
+	vn.onChange(info)
+}
+
+// level 人数所在的阈值区间，也即不大于人数的阈值的数量。没有配置阈值时为人数本身
+//
+func (vn *ViewerNotifier) level(count int) int {
+	if len(vn.config.Thresholds) == 0 {
+		return count
+	}
+	return sort.Search(len(vn.config.Thresholds), func(i int) bool {
+		return vn.config.Thresholds[i] > count
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestViewerNotifier(t *testing.T) {
+	var infos []base.ViewerChangeInfo
+	vn := NewViewerNotifier(ViewerNotifyConfig{Enable: true, Thresholds: []int{100, 10}, DebounceMs: 2000}, "1", func(info base.ViewerChangeInfo) {
+		infos = append(infos, info)
+	})
+	key := viewerStreamKey{appName: "live", streamName: "test110"}
+	now := time.Now()
+	check := func(sec int, count int) {
+		vn.Check(now.Add(time.Duration(sec)*time.Second), map[viewerStreamKey]int{key: count})
+	}
+
+	// 跨越阈值后在去抖时长内回落，不通知
+	check(0, 5)
+	check(1, 12)
+	check(2, 8)
+	check(5, 8)
+	assert.Equal(t, 0, len(infos))
+
+	// 保持超过去抖时长后通知
+	check(6, 15)
+	check(7, 20)
+	check(8, 30)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, base.ViewerChangeInfo{ServerId: "1", AppName: "live", StreamName: "test110",
+		ViewerCount: 30, PrevViewerCount: 0, Threshold: 10, Direction: ViewerChangeDirectionUp}, infos[0])
+
+	// 流结束时视为0人
+	vn.Check(now.Add(10*time.Second), nil)
+	vn.Check(now.Add(12*time.Second), nil)
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, 10, infos[1].Threshold)
+	assert.Equal(t, ViewerChangeDirectionDown, infos[1].Direction)
+	assert.Equal(t, 30, infos[1].PrevViewerCount)
+	assert.Equal(t, 0, len(vn.streams))
+
+	// 没有配置阈值时，任意变化都通知
+	infos = nil
+	vn = NewViewerNotifier(ViewerNotifyConfig{DebounceMs: 1000}, "1", func(info base.ViewerChangeInfo) {
+		infos = append(infos, info)
+	})
+	check(0, 1)
+	check(1, 1)
+	check(2, 2)
+	check(3, 2)
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, 2, infos[1].ViewerCount)
+	assert.Equal(t, 0, infos[1].Threshold)
+}
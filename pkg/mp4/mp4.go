@@ -0,0 +1,22 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mp4
+
+// 读取普通（非fragmented）mp4文件中的h264、h265视频轨道以及aac音频轨道
+//
+// 打开文件时解析moov中的sample表，得到每个sample在文件中的位置、大小、时间戳，之后按需读取sample的数据
+//
+// 参考 ISO/IEC 14496-12（ISO base media file format）、ISO/IEC 14496-15（AVC、HEVC file format）、ISO/IEC 14496-14（MP4 file format）
+
+type TrackType int
+
+const (
+	TrackTypeVideo TrackType = 1
+	TrackTypeAudio TrackType = 2
+)
@@ -0,0 +1,141 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mp4
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func box(typ string, children ...[]byte) []byte {
+	var body []byte
+	for _, c := range children {
+		body = append(body, c...)
+	}
+	out := make([]byte, 8, 8+len(body))
+	bele.BePutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+func u32s(vs ...uint32) []byte {
+	out := make([]byte, 4*len(vs))
+	for i, v := range vs {
+		bele.BePutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+func fullBoxTable(typ string, version uint8, entries ...uint32) []byte {
+	return box(typ, []byte{version, 0, 0, 0}, u32s(entries...))
+}
+
+func trak(handler string, timescale uint32, stsd []byte, tables ...[]byte) []byte {
+	mdhd := box("mdhd", make([]byte, 12), u32s(timescale, 0), make([]byte, 4))
+	hdlr := box("hdlr", make([]byte, 8), []byte(handler), make([]byte, 13))
+	stbl := box("stbl", append([][]byte{stsd}, tables...)...)
+	return box("trak", box("mdia", mdhd, hdlr, box("minf", stbl)))
+}
+
+func TestReader(t *testing.T) {
+	avcc := []byte{0x01, 0x64, 0x00, 0x20, 0xFF, 0xE1, 0x00, 0x04, 0x67, 0x64, 0x00, 0x20, 0x01, 0x00, 0x02, 0x68, 0xEB}
+	asc := []byte{0x12, 0x10}
+
+	// 3个视频sample（I P B），2个音频sample
+	samples := [][]byte{
+		{0, 0, 0, 2, 0x65, 0x01},
+		{0, 0, 0, 2, 0x41, 0x02},
+		{0, 0, 0, 2, 0x01, 0x03},
+		{0x21, 0x01},
+		{0x21, 0x02, 0x03},
+	}
+	ftyp := box("ftyp", []byte("isom"), make([]byte, 4))
+	var mdatBody []byte
+	for _, s := range samples {
+		mdatBody = append(mdatBody, s...)
+	}
+	mdatOffset := uint32(len(ftyp) + 8)
+
+	avc1 := box("avc1", make([]byte, visualSampleEntrySize), box("avcC", avcc))
+	videoTrak := trak("vide", 90000,
+		box("stsd", u32s(0, 1), avc1),
+		fullBoxTable("stts", 0, 1, 3, 3600),
+		fullBoxTable("ctts", 0, 3, 1, 0, 1, 7200, 1, 0),
+		fullBoxTable("stss", 0, 1, 1),
+		fullBoxTable("stsc", 0, 1, 1, 2, 1, 2, 1, 1),
+		box("stsz", []byte{0, 0, 0, 0}, u32s(0, 3, 6, 6, 6)),
+		fullBoxTable("stco", 0, 2, mdatOffset, mdatOffset+12),
+	)
+
+	// ES_Descriptor -> DecoderConfigDescriptor -> DecoderSpecificInfo
+	dsi := append([]byte{0x05, 0x80, 0x80, 0x80, byte(len(asc))}, asc...)
+	dcd := append(append([]byte{0x04, byte(13 + len(dsi)), 0x40, 0x15}, make([]byte, 11)...), dsi...)
+	esd := append([]byte{0x03, byte(3 + len(dcd)), 0x00, 0x01, 0x00}, dcd...)
+	mp4a := box("mp4a", make([]byte, audioSampleEntrySize), box("esds", []byte{0, 0, 0, 0}, esd))
+	audioTrak := trak("soun", 44100,
+		box("stsd", u32s(0, 1), mp4a),
+		fullBoxTable("stts", 0, 1, 2, 1024),
+		fullBoxTable("stsc", 0, 1, 1, 2, 1),
+		box("stsz", []byte{0, 0, 0, 0}, u32s(0, 2, 2, 3)),
+		fullBoxTable("stco", 0, 1, mdatOffset+18),
+	)
+
+	var file []byte
+	file = append(file, ftyp...)
+	file = append(file, box("mdat", mdatBody)...)
+	file = append(file, box("moov", box("mvhd", make([]byte, 100)), videoTrak, audioTrak, trak("text", 1000, box("stsd", u32s(0, 0))))...)
+
+	fp, err := ioutil.TempFile("", "lal_mp4_test_*.mp4")
+	assert.Equal(t, nil, err)
+	defer os.Remove(fp.Name())
+	_, _ = fp.Write(file)
+	_ = fp.Close()
+
+	r, err := Open(fp.Name())
+	assert.Equal(t, nil, err)
+	defer r.Dispose()
+
+	assert.Equal(t, 2, len(r.Tracks))
+	v := r.Tracks[0]
+	assert.Equal(t, TrackTypeVideo, v.Type)
+	assert.Equal(t, base.AvPacketPtAvc, v.Codec)
+	assert.Equal(t, avcc, v.Config)
+	assert.Equal(t, []Sample{
+		{Offset: int64(mdatOffset), Size: 6, Dts: 0, Pts: 0, IsKey: true},
+		{Offset: int64(mdatOffset) + 6, Size: 6, Dts: 40, Pts: 120, IsKey: false},
+		{Offset: int64(mdatOffset) + 12, Size: 6, Dts: 80, Pts: 80, IsKey: false},
+	}, v.Samples)
+	for i, s := range v.Samples {
+		b, err := r.ReadSample(s)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, samples[i], b)
+	}
+
+	a := r.Tracks[1]
+	assert.Equal(t, TrackTypeAudio, a.Type)
+	assert.Equal(t, base.AvPacketPtAac, a.Codec)
+	assert.Equal(t, asc, a.Config)
+	assert.Equal(t, 2, len(a.Samples))
+	assert.Equal(t, int64(23), a.Samples[1].Dts)
+	b, err := r.ReadSample(a.Samples[1])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, samples[4], b)
+
+	assert.Equal(t, int64(120), r.Duration())
+
+	// 没有moov
+	_ = ioutil.WriteFile(fp.Name(), ftyp, 0644)
+	_, err = Open(fp.Name())
+	assert.IsNotNil(t, err)
+}
@@ -0,0 +1,580 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mp4
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Track 一个音频或视频轨道
+type Track struct {
+	Type      TrackType
+	Codec     base.AvPacketPt
+	Timescale uint32
+
+	// h264为avcC box的内容（AVCDecoderConfigurationRecord），h265为hvcC box的内容（HEVCDecoderConfigurationRecord），
+	// aac为AudioSpecificConfig
+	Config []byte
+
+	Samples []Sample
+}
+
+// Sample 时间戳单位都为毫秒
+type Sample struct {
+	Offset int64
+	Size   uint32
+	Dts    int64
+	Pts    int64
+	IsKey  bool // 音频的sample都为true
+}
+
+type Reader struct {
+	fp     *os.File
+	Tracks []Track
+}
+
+// Open 打开文件并解析moov。没有支持的音视频轨道时返回错误
+//
+func Open(filename string) (*Reader, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{fp: fp}
+	if err = r.parse(); err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadSample 读取sample的数据，h264、h265为avcc格式（每个nalu前包含4字节长度），aac为不带adts头的一帧
+//
+func (r *Reader) ReadSample(s Sample) ([]byte, error) {
+	b := make([]byte, s.Size)
+	_, err := r.fp.ReadAt(b, s.Offset)
+	return b, err
+}
+
+// Duration 所有轨道中最大的结束时间，单位毫秒
+func (r *Reader) Duration() int64 {
+	var d int64
+	for _, t := range r.Tracks {
+		n := len(t.Samples)
+		if n == 0 {
+			continue
+		}
+		end := t.Samples[n-1].Dts
+		if n > 1 {
+			// 最后一个sample的时长按前一个sample的时长估算
+			end += t.Samples[n-1].Dts - t.Samples[n-2].Dts
+		}
+		if end > d {
+			d = end
+		}
+	}
+	return d
+}
+
+func (r *Reader) Dispose() error {
+	return r.fp.Close()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *Reader) parse() error {
+	fi, err := r.fp.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 遍历顶层box，找到moov
+	var pos int64
+	for pos < fi.Size() {
+		typ, size, headerSize, err := r.readBoxHeader(pos, fi.Size()-pos)
+		if err != nil {
+			return err
+		}
+		if typ == "moov" {
+			body := make([]byte, size-headerSize)
+			if _, err = r.fp.ReadAt(body, pos+headerSize); err != nil {
+				return err
+			}
+			return r.parseMoov(body)
+		}
+		pos += size
+	}
+	return fmt.Errorf("%w. moov not found", base.ErrMp4)
+}
+
+func (r *Reader) readBoxHeader(pos int64, remain int64) (typ string, size int64, headerSize int64, err error) {
+	h := make([]byte, 16)
+	n, err := r.fp.ReadAt(h, pos)
+	if n < 8 {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("%w. box header too short. pos=%d", base.ErrMp4, pos)
+		}
+		return
+	}
+	err = nil
+	typ = string(h[4:8])
+	size = int64(bele.BeUint32(h))
+	headerSize = 8
+	switch size {
+	case 0:
+		// 一直到文件末尾
+		size = remain
+	case 1:
+		if n < 16 {
+			err = fmt.Errorf("%w. largesize box header too short. pos=%d", base.ErrMp4, pos)
+			return
+		}
+		size = int64(bele.BeUint64(h[8:]))
+		headerSize = 16
+	}
+	if size < headerSize || size > remain {
+		err = fmt.Errorf("%w. invalid box size. type=%s, size=%d, remain=%d", base.ErrMp4, typ, size, remain)
+	}
+	return
+}
+
+func (r *Reader) parseMoov(b []byte) error {
+	err := iterateBoxes(b, func(typ string, body []byte) error {
+		if typ != "trak" {
+			return nil
+		}
+		t, err := parseTrak(body)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			r.Tracks = append(r.Tracks, *t)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(r.Tracks) == 0 {
+		return fmt.Errorf("%w. no supported track", base.ErrMp4)
+	}
+	return nil
+}
+
+// trakBoxes 解析trak时需要的box
+type trakBoxes struct {
+	timescale uint32
+	handler   string
+	stsd      []byte
+	stts      []byte
+	ctts      []byte
+	stss      []byte
+	stsc      []byte
+	stsz      []byte
+	stco      []byte
+	co64      []byte
+}
+
+// parseTrak 不支持的轨道返回nil
+func parseTrak(b []byte) (*Track, error) {
+	var tb trakBoxes
+	if err := collectTrakBoxes(b, &tb); err != nil {
+		return nil, err
+	}
+	t := &Track{Timescale: tb.timescale}
+	switch tb.handler {
+	case "vide":
+		t.Type = TrackTypeVideo
+	case "soun":
+		t.Type = TrackTypeAudio
+	default:
+		return nil, nil
+	}
+	if t.Timescale == 0 {
+		return nil, fmt.Errorf("%w. invalid timescale", base.ErrMp4)
+	}
+	ok, err := parseStsd(tb.stsd, t)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if err = buildSamples(&tb, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func collectTrakBoxes(b []byte, tb *trakBoxes) error {
+	return iterateBoxes(b, func(typ string, body []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return collectTrakBoxes(body, tb)
+		case "mdhd":
+			if len(body) < 24 {
+				return fmt.Errorf("%w. mdhd too short", base.ErrMp4)
+			}
+			if body[0] == 1 {
+				// version 1，creation_time、modification_time为64位
+				if len(body) < 32 {
+					return fmt.Errorf("%w. mdhd too short", base.ErrMp4)
+				}
+				tb.timescale = bele.BeUint32(body[20:])
+			} else {
+				tb.timescale = bele.BeUint32(body[12:])
+			}
+		case "hdlr":
+			if len(body) < 12 {
+				return fmt.Errorf("%w. hdlr too short", base.ErrMp4)
+			}
+			tb.handler = string(body[8:12])
+		case "stsd":
+			tb.stsd = body
+		case "stts":
+			tb.stts = body
+		case "ctts":
+			tb.ctts = body
+		case "stss":
+			tb.stss = body
+		case "stsc":
+			tb.stsc = body
+		case "stsz":
+			tb.stsz = body
+		case "stco":
+			tb.stco = body
+		case "co64":
+			tb.co64 = body
+		}
+		return nil
+	})
+}
+
+// parseStsd 解析第一个sample entry，得到编码格式以及解码配置。不支持的编码格式返回false
+func parseStsd(b []byte, t *Track) (bool, error) {
+	if len(b) < 8 || bele.BeUint32(b[4:]) == 0 {
+		return false, fmt.Errorf("%w. stsd empty", base.ErrMp4)
+	}
+	var found bool
+	err := iterateBoxes(b[8:], func(typ string, body []byte) error {
+		if found {
+			return nil
+		}
+		found = true
+
+		switch typ {
+		case "avc1", "avc3":
+			t.Codec = base.AvPacketPtAvc
+			return parseVisualSampleEntry(body, "avcC", t)
+		case "hvc1", "hev1":
+			t.Codec = base.AvPacketPtHevc
+			return parseVisualSampleEntry(body, "hvcC", t)
+		case "mp4a":
+			t.Codec = base.AvPacketPtAac
+			return parseMp4a(body, t)
+		default:
+			Log.Warnf("mp4 codec not support. type=%s", typ)
+			t.Codec = base.AvPacketPtUnknown
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return t.Codec != base.AvPacketPtUnknown && t.Codec != 0, nil
+}
+
+// VisualSampleEntry中，子box之前的固定字段的长度
+const visualSampleEntrySize = 78
+
+func parseVisualSampleEntry(b []byte, configType string, t *Track) error {
+	if len(b) < visualSampleEntrySize {
+		return fmt.Errorf("%w. visual sample entry too short", base.ErrMp4)
+	}
+	err := iterateBoxes(b[visualSampleEntrySize:], func(typ string, body []byte) error {
+		if typ == configType {
+			t.Config = body
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if t.Config == nil {
+		return fmt.Errorf("%w. %s not found", base.ErrMp4, configType)
+	}
+	return nil
+}
+
+// AudioSampleEntry中，子box之前的固定字段的长度
+const audioSampleEntrySize = 28
+
+func parseMp4a(b []byte, t *Track) error {
+	if len(b) < audioSampleEntrySize {
+		return fmt.Errorf("%w. audio sample entry too short", base.ErrMp4)
+	}
+	err := iterateBoxes(b[audioSampleEntrySize:], func(typ string, body []byte) error {
+		if typ == "esds" && len(body) > 4 {
+			t.Config = parseEsdsAsc(body[4:])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if t.Config == nil {
+		return fmt.Errorf("%w. aac audio specific config not found", base.ErrMp4)
+	}
+	return nil
+}
+
+// parseEsdsAsc 从ES_Descriptor中找到DecoderSpecificInfo，也即AudioSpecificConfig
+func parseEsdsAsc(b []byte) []byte {
+	for len(b) > 0 {
+		tag := b[0]
+		length, n := readDescriptorLength(b[1:])
+		if n == 0 {
+			return nil
+		}
+		body := b[1+n:]
+		if length > len(body) {
+			return nil
+		}
+		body = body[:length]
+		switch tag {
+		case 0x03: // ES_DescrTag
+			if len(body) < 3 {
+				return nil
+			}
+			flags := body[2]
+			skip := 3
+			if flags&0x80 != 0 { // streamDependenceFlag
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(body) > skip { // URL_Flag
+				skip += 1 + int(body[skip])
+			}
+			if flags&0x20 != 0 { // OCRstreamFlag
+				skip += 2
+			}
+			if skip > len(body) {
+				return nil
+			}
+			return parseEsdsAsc(body[skip:])
+		case 0x04: // DecoderConfigDescrTag
+			if len(body) < 13 {
+				return nil
+			}
+			return parseEsdsAsc(body[13:])
+		case 0x05: // DecSpecificInfoTag
+			return body
+		}
+		b = b[1+n+length:]
+	}
+	return nil
+}
+
+// readDescriptorLength 描述符的长度字段，每个字节的最高位表示后面还有字节，最多4个字节
+func readDescriptorLength(b []byte) (length int, n int) {
+	for n < 4 && n < len(b) {
+		v := b[n]
+		n++
+		length = length<<7 | int(v&0x7F)
+		if v&0x80 == 0 {
+			return length, n
+		}
+	}
+	return 0, 0
+}
+
+func buildSamples(tb *trakBoxes, t *Track) error {
+	// stsz
+	if len(tb.stsz) < 12 {
+		return fmt.Errorf("%w. stsz invalid", base.ErrMp4)
+	}
+	sampleSize := bele.BeUint32(tb.stsz[4:])
+	count := int(bele.BeUint32(tb.stsz[8:]))
+	if sampleSize == 0 && len(tb.stsz) < 12+4*count {
+		return fmt.Errorf("%w. stsz too short", base.ErrMp4)
+	}
+	t.Samples = make([]Sample, count)
+	for i := range t.Samples {
+		if sampleSize != 0 {
+			t.Samples[i].Size = sampleSize
+		} else {
+			t.Samples[i].Size = bele.BeUint32(tb.stsz[12+4*i:])
+		}
+	}
+
+	// stts
+	entries, err := readTable(tb.stts, 8, "stts")
+	if err != nil {
+		return err
+	}
+	var dts uint64
+	i := 0
+	for _, e := range entries {
+		n, delta := bele.BeUint32(e), bele.BeUint32(e[4:])
+		for j := uint32(0); j < n && i < count; j++ {
+			t.Samples[i].Dts = int64(dts)
+			dts += uint64(delta)
+			i++
+		}
+	}
+
+	// ctts，可选
+	ctts := make([]int64, count)
+	if tb.ctts != nil {
+		entries, err = readTable(tb.ctts, 8, "ctts")
+		if err != nil {
+			return err
+		}
+		signed := tb.ctts[0] == 1
+		i = 0
+		for _, e := range entries {
+			n := bele.BeUint32(e)
+			var offset int64
+			if signed {
+				offset = int64(int32(bele.BeUint32(e[4:])))
+			} else {
+				offset = int64(bele.BeUint32(e[4:]))
+			}
+			for j := uint32(0); j < n && i < count; j++ {
+				ctts[i] = offset
+				i++
+			}
+		}
+	}
+
+	// stss，可选，不存在时所有sample都是关键帧
+	if tb.stss == nil || t.Type == TrackTypeAudio {
+		for i := range t.Samples {
+			t.Samples[i].IsKey = true
+		}
+	} else {
+		entries, err = readTable(tb.stss, 4, "stss")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			idx := int(bele.BeUint32(e)) - 1
+			if idx >= 0 && idx < count {
+				t.Samples[idx].IsKey = true
+			}
+		}
+	}
+
+	// 计算时间戳，转换为毫秒
+	for i := range t.Samples {
+		s := &t.Samples[i]
+		pts := s.Dts + ctts[i]
+		s.Dts = s.Dts * 1000 / int64(t.Timescale)
+		s.Pts = pts * 1000 / int64(t.Timescale)
+	}
+
+	// chunk offset
+	var chunkOffsets []int64
+	if tb.co64 != nil {
+		entries, err = readTable(tb.co64, 8, "co64")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			chunkOffsets = append(chunkOffsets, int64(bele.BeUint64(e)))
+		}
+	} else {
+		entries, err = readTable(tb.stco, 4, "stco")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			chunkOffsets = append(chunkOffsets, int64(bele.BeUint32(e)))
+		}
+	}
+
+	// stsc，每个chunk中包含的sample数量
+	entries, err = readTable(tb.stsc, 12, "stsc")
+	if err != nil {
+		return err
+	}
+	i = 0
+	for k, e := range entries {
+		firstChunk := int(bele.BeUint32(e)) - 1
+		samplesPerChunk := int(bele.BeUint32(e[4:]))
+		lastChunk := len(chunkOffsets)
+		if k+1 < len(entries) {
+			lastChunk = int(bele.BeUint32(entries[k+1])) - 1
+		}
+		for c := firstChunk; c < lastChunk && c >= 0 && c < len(chunkOffsets); c++ {
+			offset := chunkOffsets[c]
+			for j := 0; j < samplesPerChunk && i < count; j++ {
+				t.Samples[i].Offset = offset
+				offset += int64(t.Samples[i].Size)
+				i++
+			}
+		}
+	}
+	if i != count {
+		return fmt.Errorf("%w. sample table mismatch. count=%d, located=%d", base.ErrMp4, count, i)
+	}
+
+	sort.SliceStable(t.Samples, func(a, b int) bool {
+		return t.Samples[a].Dts < t.Samples[b].Dts
+	})
+	return nil
+}
+
+// readTable 读取full box中`entry_count`以及之后的所有固定长度的条目
+func readTable(b []byte, entrySize int, name string) ([][]byte, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w. %s invalid", base.ErrMp4, name)
+	}
+	n := int(bele.BeUint32(b[4:]))
+	if len(b) < 8+n*entrySize {
+		return nil, fmt.Errorf("%w. %s too short", base.ErrMp4, name)
+	}
+	entries := make([][]byte, n)
+	for i := range entries {
+		entries[i] = b[8+i*entrySize : 8+(i+1)*entrySize]
+	}
+	return entries, nil
+}
+
+// iterateBoxes 遍历b中连续的box，回调box的类型以及内容（不包含box头）
+func iterateBoxes(b []byte, handler func(typ string, body []byte) error) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return fmt.Errorf("%w. box header too short", base.ErrMp4)
+		}
+		size := uint64(bele.BeUint32(b))
+		typ := string(b[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return fmt.Errorf("%w. box header too short", base.ErrMp4)
+			}
+			size = bele.BeUint64(b[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return fmt.Errorf("%w. invalid box size. type=%s, size=%d", base.ErrMp4, typ, size)
+		}
+		if err := handler(typ, b[headerSize:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
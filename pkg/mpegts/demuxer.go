@@ -0,0 +1,202 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// OnDemuxFrame
+//
+// @param pt:    音视频类型，只回调h264、h265、aac
+// @param frame: Pts、Dts、Pid、Sid、Raw有效，Raw为PES的payload（h264、h265为Annexb格式，aac带adts头）
+//               注意，回调结束后内部会复用frame.Raw的内存块，上层需要持有时应拷贝
+//
+type OnDemuxFrame func(pt base.AvPacketPt, frame *Frame)
+
+// Demuxer 解析mpegts流，按PES输出音视频帧，用于ts格式的输入（比如RIST、SRT等）
+//
+// 只处理PAT中的第一个节目
+//
+type Demuxer struct {
+	onFrame OnDemuxFrame
+
+	pmtPid  uint16
+	streams map[uint16]*demuxStream
+}
+
+type demuxStream struct {
+	pt  base.AvPacketPt
+	buf []byte
+}
+
+func NewDemuxer(onFrame OnDemuxFrame) *Demuxer {
+	return &Demuxer{
+		onFrame: onFrame,
+		streams: make(map[uint16]*demuxStream),
+	}
+}
+
+// Feed @param b: 一个或多个完整的188字节的ts包
+//
+func (d *Demuxer) Feed(b []byte) {
+	for len(b) >= 188 {
+		d.feedPacket(b[:188])
+		b = b[188:]
+	}
+}
+
+// Flush 输出缓存中还没有结束的PES，比如输入流结束时
+//
+func (d *Demuxer) Flush() {
+	for pid, s := range d.streams {
+		d.flush(pid, s)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (d *Demuxer) feedPacket(b []byte) {
+	if b[0] != syncByte {
+		Log.Warnf("invalid ts sync byte. b=%x", b[0])
+		return
+	}
+	h := ParseTsPacketHeader(b)
+	if h.Err != 0 || h.Adaptation&AdaptationFieldControlNo == 0 {
+		return
+	}
+	offset := 4
+	if h.Adaptation&AdaptationFieldControlOnly != 0 {
+		offset += 1 + int(b[4])
+	}
+	if offset >= len(b) {
+		return
+	}
+	payload := b[offset:]
+
+	switch {
+	case h.Pid == PidPat:
+		if section := psiSection(payload, h.PayloadUnitStart); section != nil {
+			d.parsePat(section)
+		}
+	case d.pmtPid != 0 && h.Pid == d.pmtPid:
+		if section := psiSection(payload, h.PayloadUnitStart); section != nil {
+			d.parsePmt(section)
+		}
+	default:
+		s, ok := d.streams[h.Pid]
+		if !ok {
+			return
+		}
+		if h.PayloadUnitStart != 0 {
+			d.flush(h.Pid, s)
+			s.buf = append(s.buf[:0], payload...)
+		} else if len(s.buf) != 0 {
+			s.buf = append(s.buf, payload...)
+		}
+		// PES_packet_length不为0时，收齐即可输出，不需要等下一个PES
+		if len(s.buf) >= 6 {
+			if ppl := int(bele.BeUint16(s.buf[4:])); ppl != 0 && len(s.buf) >= 6+ppl {
+				s.buf = s.buf[:6+ppl]
+				d.flush(h.Pid, s)
+			}
+		}
+	}
+}
+
+func (d *Demuxer) flush(pid uint16, s *demuxStream) {
+	buf := s.buf
+	s.buf = s.buf[:0]
+	if len(buf) < 9 || buf[0] != 0 || buf[1] != 0 || buf[2] != 1 {
+		return
+	}
+	// PES_header_data_length需要能容纳PTS_DTS_flags指示的时间戳，否则丢弃该PES
+	phdl := int(buf[8])
+	ptsDtsFlag := buf[7] >> 6
+	if len(buf) < 9+phdl || (ptsDtsFlag&0x2 != 0 && phdl < 5) || (ptsDtsFlag == 0x3 && phdl < 10) {
+		return
+	}
+	pes, length := ParsePes(buf)
+	if pes.ptsDtsFlag&0x2 == 0 {
+		return
+	}
+	frame := Frame{
+		Pts: pes.pts,
+		Dts: pes.dts,
+		Pid: pid,
+		Sid: pes.sid,
+		Raw: buf[length:],
+	}
+	d.onFrame(s.pt, &frame)
+}
+
+func (d *Demuxer) parsePat(section []byte) {
+	// table_id(8) + ... + section_length(12) + transport_stream_id(16) + ... 共8字节，最后4字节为crc
+	if section[0] != tableIdPat || len(section) < 8 {
+		return
+	}
+	end := 3 + int(bele.BeUint16(section[1:])&0xFFF) - 4
+	if end > len(section) {
+		return
+	}
+	for i := 8; i+4 <= end; i += 4 {
+		pn := bele.BeUint16(section[i:])
+		if pn == 0 {
+			// network PID
+			continue
+		}
+		d.pmtPid = bele.BeUint16(section[i+2:]) & 0x1FFF
+		return
+	}
+}
+
+func (d *Demuxer) parsePmt(section []byte) {
+	if section[0] != tableIdPmt || len(section) < 12 {
+		return
+	}
+	end := 3 + int(bele.BeUint16(section[1:])&0xFFF) - 4
+	if end > len(section) {
+		return
+	}
+	pil := int(bele.BeUint16(section[10:]) & 0xFFF)
+	for i := 12 + pil; i+5 <= end; {
+		streamType := section[i]
+		pid := bele.BeUint16(section[i+1:]) & 0x1FFF
+		esil := int(bele.BeUint16(section[i+3:]) & 0xFFF)
+		i += 5 + esil
+
+		var pt base.AvPacketPt
+		switch streamType {
+		case streamTypeAvc:
+			pt = base.AvPacketPtAvc
+		case streamTypeHevc:
+			pt = base.AvPacketPtHevc
+		case streamTypeAac:
+			pt = base.AvPacketPtAac
+		default:
+			continue
+		}
+		if s, ok := d.streams[pid]; !ok || s.pt != pt {
+			d.streams[pid] = &demuxStream{pt: pt}
+		}
+	}
+}
+
+// psiSection 返回section，只处理section在一个ts包中的情况
+func psiSection(payload []byte, payloadUnitStart uint8) []byte {
+	if payloadUnitStart == 0 || len(payload) < 1 {
+		return nil
+	}
+	pos := 1 + int(payload[0]) // pointer_field
+	if pos+3 > len(payload) {
+		return nil
+	}
+	return payload[pos:]
+}
@@ -9,17 +9,27 @@
 package mpegts
 
 import (
-	"os"
+	"io"
 
 	"github.com/q191201771/lal/pkg/base"
 )
 
 type FileWriter struct {
-	fp *os.File
+	fp   io.WriteCloser
+	name string
 }
 
 func (fw *FileWriter) Create(filename string) (err error) {
-	fw.fp, err = os.Create(filename)
+	return fw.CreateWithKeyring(filename, nil, "")
+}
+
+// CreateWithKeyring 加密写入，见 base.FileKeyring 。`keyring`为nil时不加密
+//
+func (fw *FileWriter) CreateWithKeyring(filename string, keyring *base.FileKeyring, streamName string) (err error) {
+	if fw.fp, err = keyring.Create(filename, streamName); err != nil {
+		return
+	}
+	fw.name = filename
 	return
 }
 
@@ -42,5 +52,5 @@ func (fw *FileWriter) Name() string {
 	if fw.fp == nil {
 		return ""
 	}
-	return fw.fp.Name()
+	return fw.name
 }
@@ -155,8 +155,10 @@ const (
 	// <iso13818-1.pdf> <Table 2-29 Stream type assignments> <page 66/174>
 	// 0x0F AAC  (ISO/IEC 13818-7 Audio with ADTS transport syntax)
 	// 0x1B AVC  (video stream as defined in ITU-T Rec. H.264 | ISO/IEC 14496-10 Video)
+	// 0x03 MP3  (ISO/IEC 11172-3 Audio)
 	// 0x24 HEVC (HEVC video stream as defined in Rec. ITU-T H.265 | ISO/IEC 23008-2  MPEG-H Part 2)
 	// -----------------------------------------------------------------------------
+	streamTypeMp3  uint8 = 0x03
 	streamTypeAac  uint8 = 0x0F
 	streamTypeAvc  uint8 = 0x1B
 	streamTypeHevc uint8 = 0x24
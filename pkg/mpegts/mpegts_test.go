@@ -9,11 +9,14 @@
 package mpegts_test
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/innertest"
 
 	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestMpegts(t *testing.T) {
@@ -31,3 +34,83 @@ func TestParseFixedTsPacket(t *testing.T) {
 	pmt := mpegts.ParsePmt(mpegts.FixedFragmentHeader[188+5:])
 	mpegts.Log.Debugf("%+v", pmt)
 }
+
+func TestPackPsi(t *testing.T) {
+	// 默认选项生成的PAT、PMT与固定的头完全一致
+	assert.Equal(t, mpegts.FixedFragmentHeader, mpegts.PackPsi(mpegts.PsiOption{}, false, false, 0))
+	assert.Equal(t, mpegts.FixedFragmentHeaderHevc, mpegts.PackPsi(mpegts.PsiOption{}, true, false, 0))
+
+	option := mpegts.PsiOption{PcrPid: 0x1FF, ServiceProvider: "lal", ServiceName: "test110"}
+	b := mpegts.PackPsi(option, false, true, 3)
+	assert.Equal(t, 188*3, len(b))
+	h := mpegts.ParseTsPacketHeader(b[188:])
+	assert.Equal(t, mpegts.PidPmt, h.Pid)
+	assert.Equal(t, uint8(3), h.Cc)
+	pmt := mpegts.ParsePmt(b[188+5:])
+	assert.Equal(t, 2, len(pmt.ProgramElements))
+	assert.Equal(t, uint8(0x03), pmt.SearchPid(mpegts.PidAudio).StreamType)
+	h = mpegts.ParseTsPacketHeader(b[188*2:])
+	assert.Equal(t, mpegts.PidSdt, h.Pid)
+	assert.Equal(t, true, bytes.Contains(b[188*2:], []byte("test110")))
+
+	b = mpegts.PackPcrPacket(0x1FF, 90000)
+	h = mpegts.ParseTsPacketHeader(b)
+	assert.Equal(t, uint16(0x1FF), h.Pid)
+	assert.Equal(t, mpegts.AdaptationFieldControlOnly, h.Adaptation)
+}
+
+func TestDemuxer(t *testing.T) {
+	type result struct {
+		pt  base.AvPacketPt
+		pts uint64
+		dts uint64
+		raw []byte
+	}
+	var results []result
+	d := mpegts.NewDemuxer(func(pt base.AvPacketPt, frame *mpegts.Frame) {
+		results = append(results, result{pt, frame.Pts, frame.Dts, append([]byte(nil), frame.Raw...)})
+	})
+
+	video := mpegts.Frame{Pts: 9000, Dts: 6000, Pid: mpegts.PidVideo, Sid: mpegts.StreamIdVideo, Key: true,
+		Raw: append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xAB}, 500)...)}
+	audio := mpegts.Frame{Pts: 7000, Dts: 7000, Pid: mpegts.PidAudio, Sid: mpegts.StreamIdAudio,
+		Raw: []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x3F, 0xFC, 0x21}}
+
+	// 还没有PAT、PMT时忽略
+	d.Feed(audio.Pack())
+	assert.Equal(t, 0, len(results))
+
+	d.Feed(mpegts.PackPsi(mpegts.PsiOption{}, false, false, 0))
+	// PES_packet_length不为0时，收齐即输出。注意，打包时时间戳加上了63000的偏移
+	d.Feed(video.Pack())
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, result{base.AvPacketPtAvc, 9000 + 63000, 6000 + 63000, video.Raw}, results[0])
+	d.Feed(audio.Pack())
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, result{base.AvPacketPtAac, 7000 + 63000, 7000 + 63000, audio.Raw}, results[1])
+
+	// 丢失了开头的PES，等待下一个PES
+	b := video.Pack()
+	d.Feed(b[188:])
+	assert.Equal(t, 2, len(results))
+	d.Feed(b)
+	assert.Equal(t, 3, len(results))
+	d.Flush()
+	assert.Equal(t, 3, len(results))
+
+	// PES_header_data_length不足以容纳PTS、DTS时丢弃
+	for _, pes := range [][]byte{
+		{0, 0, 1, 0xC0, 0, 3, 0x80, 0xC0, 0},
+		{0, 0, 1, 0xC0, 0, 8, 0x80, 0xC0, 5, 0x21, 0, 1, 0, 1},
+	} {
+		b = make([]byte, 188)
+		b[0] = 0x47
+		b[1] = 0x40 | byte(mpegts.PidAudio>>8)
+		b[2] = byte(mpegts.PidAudio & 0xFF)
+		b[3] = 0x10
+		copy(b[4:], pes)
+		d.Feed(b)
+		d.Flush()
+	}
+	assert.Equal(t, 3, len(results))
+}
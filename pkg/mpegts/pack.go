@@ -29,6 +29,9 @@ type Frame struct {
 	// 视频 关键帧为true，非关键帧为false
 	Key bool
 
+	// 为true时，帧的首个packet携带PCR。注意，视频关键帧总是携带PCR
+	WithPcr bool
+
 	// 音频AAC 格式为2字节ADTS头加raw frame
 	// 视频AVC 格式为Annexb
 	Raw []byte
@@ -94,8 +97,8 @@ func (frame *Frame) Pack() []byte {
 		wpos += 4
 
 		if first {
-			if frame.Key {
-				// 关键帧的首个packet需要添加Adaptation
+			if frame.Key || frame.WithPcr {
+				// 关键帧（或者需要携带PCR的帧）的首个packet需要添加Adaptation
 				// -----Adaptation-----------------------
 				// adaptation_field_length
 				// discontinuity_indicator              0
@@ -110,9 +113,12 @@ func (frame *Frame) Pack() []byte {
 				// reserved
 				// program_clock_reference_extension
 				// --------------------------------------
-				packet[3] |= 0x20                    // adaptation_field_control 设置Adaptation
-				packet[4] = 7                        // adaptation_field_length
-				packet[5] = 0x50                     // random_access_indicator + PCR_flag
+				packet[3] |= 0x20 // adaptation_field_control 设置Adaptation
+				packet[4] = 7     // adaptation_field_length
+				packet[5] = 0x10  // PCR_flag
+				if frame.Key {
+					packet[5] |= 0x40 // random_access_indicator
+				}
 				packPcr(packet[6:], frame.Dts-delay) // using 6 byte
 				wpos += 8
 			}
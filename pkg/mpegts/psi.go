@@ -0,0 +1,211 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+import "github.com/q191201771/naza/pkg/bele"
+
+// PsiOption mpegts打包时PSI（PAT、PMT、SDT）以及PCR相关的可选项，用于对接广电等下游设备
+//
+// 零值时与 FixedFragmentHeader 、 FixedFragmentHeaderHevc 的行为完全一致
+//
+type PsiOption struct {
+	// 大于0时，除了流的开头（以及hls每个ts文件的开头），每隔该时长在流中重复插入一次PAT、PMT（以及SDT），单位毫秒
+	PatPmtIntervalMs int `json:"pat_pmt_interval_ms"`
+
+	// PCR所在的PID，为0时PCR携带在视频PID上
+	PcrPid uint16 `json:"pcr_pid"`
+
+	// 插入PCR的最大间隔，单位毫秒
+	// PCR携带在视频PID上时，为0表示只在视频关键帧上携带PCR，大于0时如果距离上次PCR超过该时长，非关键帧也携带PCR
+	// PCR使用单独的PID时，按该间隔插入只包含PCR的ts包，为0时使用 defaultPcrIntervalMs
+	PcrIntervalMs int `json:"pcr_interval_ms"`
+
+	// 不为空时，生成包含service descriptor的SDT
+	ServiceProvider string `json:"service_provider"`
+	ServiceName     string `json:"service_name"`
+}
+
+const (
+	PidPmt uint16 = 0x1001
+	PidSdt uint16 = 0x0011
+
+	defaultPcrIntervalMs = 40
+
+	tableIdPat uint8 = 0x00
+	tableIdPmt uint8 = 0x02
+	tableIdSdt uint8 = 0x42
+
+	programNumber     uint16 = 1
+	transportStreamId uint16 = 1
+	originalNetworkId uint16 = 0xFF01
+
+	descriptorTagService uint8 = 0x48
+	serviceTypeDigitalTv uint8 = 0x01
+)
+
+func (o *PsiOption) HasSdt() bool {
+	return o.ServiceProvider != "" || o.ServiceName != ""
+}
+
+// PcrInterval 返回插入PCR的间隔，单位（毫秒*90），为0表示只在视频关键帧上携带PCR
+func (o *PsiOption) PcrInterval() uint64 {
+	if o.PcrPid != 0 && o.PcrIntervalMs <= 0 {
+		return defaultPcrIntervalMs * 90
+	}
+	return uint64(o.PcrIntervalMs) * 90
+}
+
+// PackPsi 生成PAT、PMT，以及可选的SDT
+//
+// @param isHevc: 视频是否为h265
+// @param isMp3:  音频是否为mp3，否则为aac
+// @param cc:     ts包头的continuity_counter，所有PSI的ts包使用相同的值
+//
+// @return: 每个表占用一个188字节的ts包
+//
+func PackPsi(option PsiOption, isHevc bool, isMp3 bool, cc uint8) []byte {
+	out := packPsiPacket(PidPat, cc, packPatSection())
+	out = append(out, packPsiPacket(PidPmt, cc, packPmtSection(option, isHevc, isMp3))...)
+	if option.HasSdt() {
+		out = append(out, packPsiPacket(PidSdt, cc, packSdtSection(option))...)
+	}
+	return out
+}
+
+// PackPcrPacket 生成只包含adaptation field的ts包，用于在单独的PID上携带PCR
+//
+// 注意，没有payload的ts包，continuity_counter不递增，所以固定为0
+//
+// @param dts: 根据该时间戳计算PCR，计算方式与 Frame.Pack 相同，单位（毫秒*90）
+//
+func PackPcrPacket(pid uint16, dts uint64) []byte {
+	packet := make([]byte, 188)
+	packet[0] = syncByte
+	packet[1] = uint8((pid >> 8) & 0x1F)
+	packet[2] = uint8(pid & 0xFF)
+	packet[3] = 0x20 // adaptation_field_control: adaptation only
+	packet[4] = 183  // adaptation_field_length
+	packet[5] = 0x10 // PCR_flag
+	packPcr(packet[6:], dts-delay)
+	for i := 12; i < 188; i++ {
+		packet[i] = 0xFF
+	}
+	return packet
+}
+
+// ----- private -------------------------------------------------------------------------------------------------------
+
+// packPsiPacket 将一个section（不包含CRC）装入一个ts包，并追加CRC
+func packPsiPacket(pid uint16, cc uint8, section []byte) []byte {
+	packet := make([]byte, 188)
+	packet[0] = syncByte
+	packet[1] = 0x40 | uint8((pid>>8)&0x1F) // payload_unit_start_indicator
+	packet[2] = uint8(pid & 0xFF)
+	packet[3] = 0x10 | (cc & 0x0F)
+	packet[4] = 0 // pointer_field
+	n := copy(packet[5:], section)
+	bele.BePutUint32(packet[5+n:], crc32Mpeg(section))
+	for i := 5 + n + 4; i < 188; i++ {
+		packet[i] = 0xFF
+	}
+	return packet
+}
+
+// packSectionHeader 写入section的前8个字节，section_length根据整个section（包含CRC）的大小计算
+func packSectionHeader(section []byte, tableId uint8, id uint16) {
+	sl := len(section) - 3 + 4
+	section[0] = tableId
+	section[1] = 0xB0 | uint8(sl>>8) // section_syntax_indicator + reserved
+	section[2] = uint8(sl)
+	bele.BePutUint16(section[3:], id)
+	section[5] = 0xC1 // reserved + version_number(0) + current_next_indicator(1)
+	section[6] = 0    // section_number
+	section[7] = 0    // last_section_number
+}
+
+func packPatSection() []byte {
+	section := make([]byte, 12)
+	packSectionHeader(section, tableIdPat, transportStreamId)
+	bele.BePutUint16(section[8:], programNumber)
+	bele.BePutUint16(section[10:], 0xE000|PidPmt)
+	return section
+}
+
+func packPmtSection(option PsiOption, isHevc bool, isMp3 bool) []byte {
+	videoStreamType := streamTypeAvc
+	if isHevc {
+		videoStreamType = streamTypeHevc
+	}
+	audioStreamType := streamTypeAac
+	if isMp3 {
+		audioStreamType = streamTypeMp3
+	}
+	pcrPid := PidVideo
+	if option.PcrPid != 0 {
+		pcrPid = option.PcrPid
+	}
+
+	section := make([]byte, 22)
+	packSectionHeader(section, tableIdPmt, programNumber)
+	bele.BePutUint16(section[8:], 0xE000|pcrPid)
+	bele.BePutUint16(section[10:], 0xF000) // program_info_length
+	section[12] = videoStreamType
+	bele.BePutUint16(section[13:], 0xE000|PidVideo)
+	bele.BePutUint16(section[15:], 0xF000)
+	section[17] = audioStreamType
+	bele.BePutUint16(section[18:], 0xE000|PidAudio)
+	bele.BePutUint16(section[20:], 0xF000)
+	return section
+}
+
+func packSdtSection(option PsiOption) []byte {
+	provider, name := []byte(option.ServiceProvider), []byte(option.ServiceName)
+	// 保证一个ts包可以装下整个section，名称过长时截断
+	const maxNameLen = 64
+	if len(provider) > maxNameLen {
+		provider = provider[:maxNameLen]
+	}
+	if len(name) > maxNameLen {
+		name = name[:maxNameLen]
+	}
+
+	descriptor := []byte{descriptorTagService, uint8(3 + len(provider) + len(name)), serviceTypeDigitalTv}
+	descriptor = append(descriptor, uint8(len(provider)))
+	descriptor = append(descriptor, provider...)
+	descriptor = append(descriptor, uint8(len(name)))
+	descriptor = append(descriptor, name...)
+
+	section := make([]byte, 16, 16+len(descriptor))
+	bele.BePutUint16(section[8:], originalNetworkId)
+	section[10] = 0xFF // reserved_future_use
+	bele.BePutUint16(section[11:], programNumber)
+	section[13] = 0xFC // reserved_future_use + EIT_schedule_flag(0) + EIT_present_following_flag(0)
+	// running_status(4, running) + free_CA_mode(0) + descriptors_loop_length
+	bele.BePutUint16(section[14:], 0x8000|uint16(len(descriptor)))
+	section = append(section, descriptor...)
+	packSectionHeader(section, tableIdSdt, transportStreamId)
+	section[1] |= 0x40 // SDT中该位为reserved_future_use，值为1
+	return section
+}
+
+// crc32Mpeg CRC-32/MPEG-2，<iso13818-1.pdf> <Annex A>
+func crc32Mpeg(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,208 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package onvif
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// Profile media service中的一个profile，通常对应摄像头的主码流、子码流
+type Profile struct {
+	Token string
+	Name  string
+}
+
+// Client 调用设备的device service、media service，获取rtsp地址
+//
+// Username不为空时，使用WS-Security UsernameToken（PasswordDigest）鉴权
+//
+type Client struct {
+	xaddr    string
+	username string
+	password string
+
+	httpClient *http.Client
+	mediaXAddr string
+}
+
+// NewClient
+//
+// @param xaddr: device service的地址，也即 Device.XAddrs 中的一项
+//
+func NewClient(xaddr string, username string, password string, timeout time.Duration) *Client {
+	return &Client{
+		xaddr:    xaddr,
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// GetMediaXAddr 通过GetCapabilities获取media service的地址，会缓存结果
+func (c *Client) GetMediaXAddr() (string, error) {
+	if c.mediaXAddr != "" {
+		return c.mediaXAddr, nil
+	}
+
+	var resp struct {
+		XAddr string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+	}
+	body := `<tds:GetCapabilities xmlns:tds="http://www.onvif.org/ver10/device/wsdl">` +
+		`<tds:Category>Media</tds:Category></tds:GetCapabilities>`
+	if err := c.call(c.xaddr, body, &resp); err != nil {
+		return "", err
+	}
+	if resp.XAddr == "" {
+		return "", fmt.Errorf("%w. media xaddr not found", base.ErrOnvif)
+	}
+	c.mediaXAddr = strings.TrimSpace(resp.XAddr)
+	return c.mediaXAddr, nil
+}
+
+func (c *Client) GetProfiles() ([]Profile, error) {
+	mediaXAddr, err := c.GetMediaXAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+			Name  string `xml:"Name"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	body := `<trt:GetProfiles xmlns:trt="http://www.onvif.org/ver10/media/wsdl"/>`
+	if err = c.call(mediaXAddr, body, &resp); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]Profile, 0, len(resp.Profiles))
+	for _, p := range resp.Profiles {
+		profiles = append(profiles, Profile{Token: p.Token, Name: p.Name})
+	}
+	return profiles, nil
+}
+
+// GetStreamUri 获取profile对应的rtsp地址
+//
+// 注意，返回的地址通常不包含用户名和密码
+//
+func (c *Client) GetStreamUri(profileToken string) (string, error) {
+	mediaXAddr, err := c.GetMediaXAddr()
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Uri string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+	}
+	body := `<trt:GetStreamUri xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">` +
+		`<trt:StreamSetup><tt:Stream>RTP-Unicast</tt:Stream><tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport></trt:StreamSetup>` +
+		`<trt:ProfileToken>` + escape(profileToken) + `</trt:ProfileToken>` +
+		`</trt:GetStreamUri>`
+	if err = c.call(mediaXAddr, body, &resp); err != nil {
+		return "", err
+	}
+	if resp.Uri == "" {
+		return "", fmt.Errorf("%w. stream uri not found. profile=%s", base.ErrOnvif, profileToken)
+	}
+	return strings.TrimSpace(resp.Uri), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *Client) call(xaddr string, body string, resp interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, xaddr, strings.NewReader(c.packEnvelope(body, time.Now())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	b, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+
+	// 出错时，部分设备返回200，部分设备返回400、500，都需要检查是否为soap fault
+	var fault struct {
+		Fault *struct {
+			Reason      string `xml:"Reason>Text"`
+			FaultString string `xml:"faultstring"`
+		} `xml:"Body>Fault"`
+	}
+	if xml.Unmarshal(b, &fault) == nil && fault.Fault != nil {
+		reason := fault.Fault.Reason
+		if reason == "" {
+			reason = fault.Fault.FaultString
+		}
+		return fmt.Errorf("%w. status=%d, reason=%s", base.ErrOnvifFault, httpResp.StatusCode, strings.TrimSpace(reason))
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w. status=%d", base.ErrOnvif, httpResp.StatusCode)
+	}
+	return xml.Unmarshal(b, resp)
+}
+
+func (c *Client) packEnvelope(body string, now time.Time) string {
+	var header string
+	if c.username != "" {
+		nonce := make([]byte, 16)
+		_, _ = rand.Read(nonce)
+		created := now.UTC().Format("2006-01-02T15:04:05.000Z")
+		header = `<s:Header>` +
+			`<wsse:Security s:mustUnderstand="1" ` +
+			`xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd" ` +
+			`xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+			`<wsse:UsernameToken>` +
+			`<wsse:Username>` + escape(c.username) + `</wsse:Username>` +
+			`<wsse:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` +
+			PasswordDigest(nonce, created, c.password) + `</wsse:Password>` +
+			`<wsse:Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+			base64.StdEncoding.EncodeToString(nonce) + `</wsse:Nonce>` +
+			`<wsu:Created>` + created + `</wsu:Created>` +
+			`</wsse:UsernameToken></wsse:Security></s:Header>`
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		header +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+}
+
+// PasswordDigest WS-Security UsernameToken的摘要，Base64(SHA-1(nonce + created + password))
+func PasswordDigest(nonce []byte, created string, password string) string {
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,154 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package onvif
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// WS-Discovery使用的组播地址
+const discoveryMulticastAddr = "239.255.255.250:3702"
+
+const udpMaxPacketSize = 65535
+
+// Device WS-Discovery发现的设备
+type Device struct {
+	Uuid   string   // EndpointReference的地址，比如`urn:uuid:xxx`，用于区分设备
+	XAddrs []string // device service的http地址
+	Scopes []string // 比如`onvif://www.onvif.org/name/xxx`
+}
+
+// Host 返回第一个XAddr中的host（不包含端口），比如`192.168.1.10`
+func (d *Device) Host() string {
+	for _, xaddr := range d.XAddrs {
+		u, err := url.Parse(xaddr)
+		if err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
+
+// Probe 在局域网中组播发送WS-Discovery Probe消息，收集timeout时长内收到的所有ProbeMatches响应
+//
+// 同一个设备的多个响应只保留第一个
+//
+func Probe(timeout time.Duration) ([]Device, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", discoveryMulticastAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.WriteToUDP(PackProbe(newUuid()), raddr); err != nil {
+		return nil, err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	exist := make(map[string]struct{})
+	b := make([]byte, udpMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return devices, err
+		}
+		ds, err := ParseProbeMatches(b[:n])
+		if err != nil {
+			Log.Debugf("parse probe matches failed. addr=%s, err=%+v", addr.String(), err)
+			continue
+		}
+		for _, d := range ds {
+			if _, ok := exist[d.Uuid]; ok {
+				continue
+			}
+			exist[d.Uuid] = struct{}{}
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// PackProbe 生成查找NetworkVideoTransmitter（比如网络摄像头）的Probe消息
+func PackProbe(messageId string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" ` +
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" ` +
+		`xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<s:Header>` +
+		`<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>` +
+		`<a:MessageID>urn:uuid:` + messageId + `</a:MessageID>` +
+		`<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>` +
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>` +
+		`</s:Header>` +
+		`<s:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></s:Body>` +
+		`</s:Envelope>`)
+}
+
+// ParseProbeMatches 解析ProbeMatches响应
+func ParseProbeMatches(b []byte) ([]Device, error) {
+	var env struct {
+		Matches []struct {
+			Address string `xml:"EndpointReference>Address"`
+			XAddrs  string `xml:"XAddrs"`
+			Scopes  string `xml:"Scopes"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	if len(env.Matches) == 0 {
+		return nil, fmt.Errorf("%w. no probe match", base.ErrOnvif)
+	}
+
+	var devices []Device
+	for _, m := range env.Matches {
+		d := Device{
+			Uuid:   strings.TrimSpace(m.Address),
+			XAddrs: strings.Fields(m.XAddrs),
+			Scopes: strings.Fields(m.Scopes),
+		}
+		if len(d.XAddrs) == 0 {
+			continue
+		}
+		if d.Uuid == "" {
+			d.Uuid = d.XAddrs[0]
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newUuid() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0F) | 0x40
+	b[8] = (b[8] & 0x3F) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,17 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package onvif
+
+// Onvif设备（网络摄像头）的发现和rtsp地址获取
+//
+// - 通过WS-Discovery组播Probe发现局域网中的设备，得到device service的地址
+// - 通过device service的GetCapabilities得到media service的地址
+// - 通过media service的GetProfiles、GetStreamUri得到每个profile（主码流、子码流等）的rtsp地址
+//
+// 只实现了以上流程所需的最小子集，不包含云台控制、事件订阅等
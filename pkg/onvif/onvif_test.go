@@ -0,0 +1,109 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package onvif
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestParseProbeMatches(t *testing.T) {
+	b := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<SOAP-ENV:Header><wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action></SOAP-ENV:Header>
+<SOAP-ENV:Body><d:ProbeMatches><d:ProbeMatch>
+<wsa:EndpointReference><wsa:Address>urn:uuid:4614f0b6-0000-1000-8000-00123456789a</wsa:Address></wsa:EndpointReference>
+<d:Types>dn:NetworkVideoTransmitter</d:Types>
+<d:Scopes>onvif://www.onvif.org/type/video_encoder onvif://www.onvif.org/name/IPC</d:Scopes>
+<d:XAddrs>http://192.168.1.64/onvif/device_service http://[fe80::1]/onvif/device_service</d:XAddrs>
+</d:ProbeMatch></d:ProbeMatches></SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+	devices, err := ParseProbeMatches(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, "urn:uuid:4614f0b6-0000-1000-8000-00123456789a", devices[0].Uuid)
+	assert.Equal(t, 2, len(devices[0].XAddrs))
+	assert.Equal(t, 2, len(devices[0].Scopes))
+	assert.Equal(t, "192.168.1.64", devices[0].Host())
+
+	_, err = ParseProbeMatches([]byte(`<Envelope><Body></Body></Envelope>`))
+	assert.Equal(t, true, errors.Is(err, base.ErrOnvif))
+
+	assert.Equal(t, true, strings.Contains(string(PackProbe("x")), "dn:NetworkVideoTransmitter"))
+}
+
+func TestClient(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		// 校验鉴权信息
+		var env struct {
+			Username string `xml:"Header>Security>UsernameToken>Username"`
+			Password string `xml:"Header>Security>UsernameToken>Password"`
+			Nonce    string `xml:"Header>Security>UsernameToken>Nonce"`
+			Created  string `xml:"Header>Security>UsernameToken>Created"`
+		}
+		_ = xml.Unmarshal(b, &env)
+		nonce, _ := base64.StdEncoding.DecodeString(env.Nonce)
+		if env.Username != "admin" || env.Password != PasswordDigest(nonce, env.Created, "123<456") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault>` +
+				`<s:Reason><s:Text xml:lang="en">Sender not Authorized</s:Text></s:Reason></s:Fault></s:Body></s:Envelope>`))
+			return
+		}
+
+		s := string(b)
+		switch {
+		case strings.Contains(s, "GetCapabilities"):
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>` +
+				`<tds:GetCapabilitiesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl"><tds:Capabilities>` +
+				`<tt:Media xmlns:tt="http://www.onvif.org/ver10/schema"><tt:XAddr>` + server.URL + `/onvif/media</tt:XAddr></tt:Media>` +
+				`</tds:Capabilities></tds:GetCapabilitiesResponse></s:Body></s:Envelope>`))
+		case strings.Contains(s, "GetProfiles") && r.URL.Path == "/onvif/media":
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>` +
+				`<trt:GetProfilesResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">` +
+				`<trt:Profiles token="main" fixed="true"><tt:Name>mainStream</tt:Name></trt:Profiles>` +
+				`<trt:Profiles token="sub"><tt:Name>subStream</tt:Name></trt:Profiles>` +
+				`</trt:GetProfilesResponse></s:Body></s:Envelope>`))
+		case strings.Contains(s, "<trt:ProfileToken>sub</trt:ProfileToken>"):
+			_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>` +
+				`<trt:GetStreamUriResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">` +
+				`<trt:MediaUri><tt:Uri>rtsp://192.168.1.64:554/Streaming/Channels/102</tt:Uri></trt:MediaUri>` +
+				`</trt:GetStreamUriResponse></s:Body></s:Envelope>`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL+"/onvif/device_service", "admin", "123<456", time.Second)
+	profiles, err := c.GetProfiles()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{{Token: "main", Name: "mainStream"}, {Token: "sub", Name: "subStream"}}, profiles)
+	uri, err := c.GetStreamUri("sub")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "rtsp://192.168.1.64:554/Streaming/Channels/102", uri)
+	_, err = c.GetStreamUri("other")
+	assert.Equal(t, true, errors.Is(err, base.ErrOnvif))
+
+	c = NewClient(server.URL+"/onvif/device_service", "admin", "wrong", time.Second)
+	_, err = c.GetProfiles()
+	assert.Equal(t, true, errors.Is(err, base.ErrOnvifFault))
+	assert.Equal(t, true, strings.Contains(err.Error(), "Sender not Authorized"))
+}
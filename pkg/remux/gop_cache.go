@@ -15,7 +15,7 @@ import (
 // GopCache
 //
 // 提供两个功能:
-//   1. 缓存Metadata, VideoSeqHeader, AacSeqHeader
+//   1. 缓存Metadata, VideoSeqHeader, AacSeqHeader，以及最近一个视频关键帧（需要开启，见SetKeepKeyFrame）
 //   2. 缓存音视频GOP数据
 //
 // 以下，只讨论GopCache的第2点功能
@@ -58,6 +58,9 @@ type GopCache struct {
 	Metadata       []byte
 	VideoSeqHeader []byte
 	AacSeqHeader   []byte
+	KeyFrame       []byte // 最近一个视频关键帧
+
+	keepKeyFrame bool
 
 	gopRing      []Gop
 	gopRingFirst int
@@ -84,6 +87,15 @@ func NewGopCache(t string, uniqueKey string, gopNum int) *GopCache {
 	}
 }
 
+// SetKeepKeyFrame 是否缓存最近一个视频关键帧，不受gopNum的影响，gopNum为0时也缓存
+//
+func (gc *GopCache) SetKeepKeyFrame(keep bool) {
+	gc.keepKeyFrame = keep
+	if !keep {
+		gc.KeyFrame = nil
+	}
+}
+
 type LazyGet func() []byte
 
 // Feed
@@ -93,9 +105,12 @@ type LazyGet func() []byte
 func (gc *GopCache) Feed(msg base.RtmpMsg, lg LazyGet) {
 	switch msg.Header.MsgTypeId {
 	case base.RtmpTypeIdMetadata:
-		gc.Metadata = lg()
-		Log.Debugf("[%s] cache %s metadata. size:%d", gc.uniqueKey, gc.t, len(gc.Metadata))
-		return
+		// onTextData、onCuePoint等其他data message和音视频数据一样缓存在gop中
+		if msg.IsMetadata() {
+			gc.Metadata = lg()
+			Log.Debugf("[%s] cache %s metadata. size:%d", gc.uniqueKey, gc.t, len(gc.Metadata))
+			return
+		}
 	case base.RtmpTypeIdAudio:
 		if msg.IsAacSeqHeader() {
 			gc.AacSeqHeader = lg()
@@ -110,6 +125,10 @@ func (gc *GopCache) Feed(msg base.RtmpMsg, lg LazyGet) {
 		}
 	}
 
+	if gc.keepKeyFrame && msg.IsVideoKeyNalu() {
+		gc.KeyFrame = lg()
+	}
+
 	if gc.gopSize > 1 {
 		if msg.IsVideoKeyNalu() {
 			gc.feedNewGop(msg, lg())
@@ -132,10 +151,19 @@ func (gc *GopCache) GetGopDataAt(pos int) [][]byte {
 	return gc.gopRing[(pos+gc.gopRingFirst)%gc.gopSize].data
 }
 
+// ClearGop 只清空缓存的GOP数据以及关键帧，保留Metadata等头信息
+//
+func (gc *GopCache) ClearGop() {
+	gc.KeyFrame = nil
+	gc.gopRingLast = 0
+	gc.gopRingFirst = 0
+}
+
 func (gc *GopCache) Clear() {
 	gc.Metadata = nil
 	gc.VideoSeqHeader = nil
 	gc.AacSeqHeader = nil
+	gc.KeyFrame = nil
 	gc.gopRingLast = 0
 	gc.gopRingFirst = 0
 }
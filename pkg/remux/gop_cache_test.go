@@ -118,3 +118,38 @@ func TestGopCache_Feed(t *testing.T) {
 	assert.Equal(t, [][]byte{{1, 4}, {0, 4}}, nc.GetGopDataAt(2))
 	assert.Equal(t, nil, nc.GetGopDataAt(3))
 }
+
+func TestGopCache_ClearGop(t *testing.T) {
+	nc := NewGopCache("rtmp", "test", 2)
+	nc.Feed(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdMetadata}, Payload: []byte{2}}, func() []byte { return []byte{2} })
+	nc.Feed(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo}, Payload: []byte{23, 1}}, func() []byte { return []byte{1, 1} })
+	assert.Equal(t, 1, nc.GetGopCount())
+
+	nc.ClearGop()
+	assert.Equal(t, 0, nc.GetGopCount())
+	assert.Equal(t, []byte{2}, nc.Metadata)
+}
+
+func TestGopCache_KeepKeyFrame(t *testing.T) {
+	i := base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo},
+		Payload: []byte{0x17, 1},
+	}
+	p := base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo},
+		Payload: []byte{0x27, 1},
+	}
+
+	gc := NewGopCache("test", "test", 0)
+	gc.Feed(i, func() []byte { return []byte{1, 1} })
+	assert.Equal(t, []byte(nil), gc.KeyFrame)
+
+	gc.SetKeepKeyFrame(true)
+	gc.Feed(i, func() []byte { return []byte{1, 2} })
+	gc.Feed(p, func() []byte { return []byte{0, 2} })
+	assert.Equal(t, []byte{1, 2}, gc.KeyFrame)
+	assert.Equal(t, 0, gc.GetGopCount())
+
+	gc.ClearGop()
+	assert.Equal(t, []byte(nil), gc.KeyFrame)
+}
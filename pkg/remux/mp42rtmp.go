@@ -0,0 +1,83 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mp4"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Mp4Track2RtmpSeqHeader 生成mp4轨道对应的rtmp seq header
+//
+// 注意，avcC、hvcC box的内容即为rtmp视频seq header中5字节头之后的部分，esds中的AudioSpecificConfig即为aac seq header中2字节头之后的部分
+//
+func Mp4Track2RtmpSeqHeader(track *mp4.Track) base.RtmpMsg {
+	var payload []byte
+	switch track.Codec {
+	case base.AvPacketPtAac:
+		payload = append([]byte{aacSoundFlag, base.RtmpAacPacketTypeSeqHeader}, track.Config...)
+	case base.AvPacketPtHevc:
+		payload = append([]byte{base.RtmpHevcKeyFrame, base.RtmpHevcPacketTypeSeqHeader, 0, 0, 0}, track.Config...)
+	default:
+		payload = append([]byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0}, track.Config...)
+	}
+	return newMp4RtmpMsg(track, 0, payload)
+}
+
+// Mp4Sample2RtmpMsg
+//
+// @param data: 通过 mp4.Reader.ReadSample 读取的sample数据，返回的msg为新申请的内存块，不引用data
+//
+func Mp4Sample2RtmpMsg(track *mp4.Track, sample mp4.Sample, data []byte) base.RtmpMsg {
+	if track.Codec == base.AvPacketPtAac {
+		payload := make([]byte, 2+len(data))
+		payload[0] = aacSoundFlag
+		payload[1] = base.RtmpAacPacketTypeRaw
+		copy(payload[2:], data)
+		return newMp4RtmpMsg(track, sample.Dts, payload)
+	}
+
+	payload := make([]byte, 5+len(data))
+	frameType := base.RtmpFrameTypeInter
+	if sample.IsKey {
+		frameType = base.RtmpFrameTypeKey
+	}
+	codecId := base.RtmpCodecIdAvc
+	if track.Codec == base.AvPacketPtHevc {
+		codecId = base.RtmpCodecIdHevc
+	}
+	payload[0] = frameType<<4 | codecId
+	payload[1] = base.RtmpAvcPacketTypeNalu
+	bele.BePutUint24(payload[2:], uint32(sample.Pts-sample.Dts)) // composition time
+	copy(payload[5:], data)
+	return newMp4RtmpMsg(track, sample.Dts, payload)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// aac的rtmp音频头，SoundFormat(10) SoundRate(3, 44kHz) SoundSize(1, 16bit) SoundType(1, stereo)
+// 对于aac，后面3个字段固定为该值，实际参数以AudioSpecificConfig为准
+const aacSoundFlag = base.RtmpSoundFormatAac<<4 | 0xF
+
+func newMp4RtmpMsg(track *mp4.Track, timestamp int64, payload []byte) (msg base.RtmpMsg) {
+	msg.Header.MsgStreamId = rtmp.Msid1
+	if track.Type == mp4.TrackTypeAudio {
+		msg.Header.Csid = rtmp.CsidAudio
+		msg.Header.MsgTypeId = base.RtmpTypeIdAudio
+	} else {
+		msg.Header.Csid = rtmp.CsidVideo
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+	}
+	msg.Header.MsgLen = uint32(len(payload))
+	msg.Header.TimestampAbs = uint32(timestamp)
+	msg.Payload = payload
+	return
+}
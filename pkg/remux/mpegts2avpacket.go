@@ -0,0 +1,88 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// Mpegts2AvPacketRemuxer mpegts流转换为AvPacket，输入到 base.IAvPacketStream 中（比如lalserver的customize pub session）
+//
+// 用于RIST等以mpegts封装音视频数据的输入
+//
+type Mpegts2AvPacketRemuxer struct {
+	stream  base.IAvPacketStream
+	demuxer *mpegts.Demuxer
+
+	ascSent bool
+}
+
+func NewMpegts2AvPacketRemuxer(stream base.IAvPacketStream) *Mpegts2AvPacketRemuxer {
+	r := &Mpegts2AvPacketRemuxer{
+		stream: stream,
+	}
+	r.demuxer = mpegts.NewDemuxer(r.onFrame)
+	stream.WithOption(func(option *base.AvPacketStreamOption) {
+		option.VideoFormat = base.AvPacketStreamVideoFormatAnnexb
+	})
+	return r
+}
+
+// FeedMpegts @param b: 一个或多个完整的188字节的ts包，函数调用结束后，内部不持有该内存块
+//
+func (r *Mpegts2AvPacketRemuxer) FeedMpegts(b []byte) {
+	r.demuxer.Feed(b)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *Mpegts2AvPacketRemuxer) onFrame(pt base.AvPacketPt, frame *mpegts.Frame) {
+	if pt != base.AvPacketPtAac {
+		r.stream.FeedAvPacket(base.AvPacket{
+			PayloadType: pt,
+			Timestamp:   int64(frame.Dts / 90),
+			Payload:     frame.Raw,
+		})
+		return
+	}
+
+	// 一个PES中可能包含多个adts帧
+	b := frame.Raw
+	var index int64
+	for len(b) >= aac.AdtsHeaderLength {
+		ctx, err := aac.NewAdtsHeaderContext(b)
+		if err != nil || int(ctx.AdtsLength) > len(b) || int(ctx.AdtsLength) <= aac.AdtsHeaderLength {
+			Log.Warnf("invalid adts frame. len=%d", len(b))
+			return
+		}
+		if !r.ascSent {
+			r.stream.FeedAudioSpecificConfig(ctx.AscCtx.Pack())
+			r.ascSent = true
+		}
+		headerLen := aac.AdtsHeaderLength
+		if b[1]&0x1 == 0 {
+			// protection_absent为0，带2字节crc
+			headerLen += 2
+		}
+
+		ts := int64(frame.Dts / 90)
+		if freq, err := ctx.AscCtx.GetSamplingFrequency(); err == nil && freq > 0 {
+			ts += index * 1024 * 1000 / int64(freq)
+		}
+		r.stream.FeedAvPacket(base.AvPacket{
+			PayloadType: base.AvPacketPtAac,
+			Timestamp:   ts,
+			Payload:     b[headerLen:ctx.AdtsLength],
+		})
+		b = b[ctx.AdtsLength:]
+		index++
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+)
+
+// Rtmp2AvPacketRemuxer 将rtmp的音视频数据转换为 base.AvPacket
+//
+// 输出的格式和 AvPacket2RtmpRemuxer 的输入格式对应：
+// 音频为不包含adts头的AAC裸数据，AudioSpecificConfig通过 Asc 获取。
+// 视频为Annexb格式，关键帧前面会加上sps、pps（h265还有vps）。
+// 时间戳为pts，单位毫秒。
+//
+type Rtmp2AvPacketRemuxer struct {
+	onAvPacket func(pkt base.AvPacket)
+
+	asc    []byte
+	spspps []byte // Annexb格式
+}
+
+func NewRtmp2AvPacketRemuxer(onAvPacket func(pkt base.AvPacket)) *Rtmp2AvPacketRemuxer {
+	return &Rtmp2AvPacketRemuxer{
+		onAvPacket: onAvPacket,
+	}
+}
+
+// FeedRtmpMsg
+//
+// @param msg: 调用结束后，内部不持有msg.Payload内存块
+//
+func (r *Rtmp2AvPacketRemuxer) FeedRtmpMsg(msg base.RtmpMsg) {
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdAudio:
+		r.feedAudio(msg)
+	case base.RtmpTypeIdVideo:
+		r.feedVideo(msg)
+	}
+}
+
+// Asc 最近一次收到的AAC AudioSpecificConfig，没有收到时返回nil
+//
+func (r *Rtmp2AvPacketRemuxer) Asc() []byte {
+	return r.asc
+}
+
+func (r *Rtmp2AvPacketRemuxer) feedAudio(msg base.RtmpMsg) {
+	if len(msg.Payload) <= 2 || msg.Payload[0]>>4 != base.RtmpSoundFormatAac {
+		return
+	}
+	if msg.IsAacSeqHeader() {
+		r.asc = append(r.asc[0:0], msg.Payload[2:]...)
+		return
+	}
+	if r.asc == nil {
+		return
+	}
+
+	payload := make([]byte, len(msg.Payload)-2)
+	copy(payload, msg.Payload[2:])
+	r.onAvPacket(base.AvPacket{
+		PayloadType: base.AvPacketPtAac,
+		Timestamp:   int64(msg.Dts()),
+		Payload:     payload,
+	})
+}
+
+func (r *Rtmp2AvPacketRemuxer) feedVideo(msg base.RtmpMsg) {
+	if len(msg.Payload) <= 5 {
+		return
+	}
+	var pt base.AvPacketPt
+	switch msg.Payload[0] & 0xF {
+	case base.RtmpCodecIdAvc:
+		pt = base.AvPacketPtAvc
+	case base.RtmpCodecIdHevc:
+		pt = base.AvPacketPtHevc
+	default:
+		return
+	}
+
+	var err error
+	if msg.IsAvcKeySeqHeader() {
+		if r.spspps, err = avc.SpsPpsSeqHeader2Annexb(msg.Payload); err != nil {
+			Log.Errorf("cache spspps failed. err=%+v", err)
+		}
+		return
+	} else if msg.IsHevcKeySeqHeader() {
+		if r.spspps, err = hevc.VpsSpsPpsSeqHeader2Annexb(msg.Payload); err != nil {
+			Log.Errorf("cache vpsspspps failed. err=%+v", err)
+		}
+		return
+	}
+
+	nals, err := avc.Avcc2Annexb(msg.Payload[5:])
+	if err != nil {
+		Log.Errorf("avcc to annexb failed. err=%+v, header=%+v", err, msg.Header)
+		return
+	}
+
+	var payload []byte
+	if msg.IsVideoKeyNalu() {
+		payload = make([]byte, 0, len(r.spspps)+len(nals))
+		payload = append(payload, r.spspps...)
+		payload = append(payload, nals...)
+	} else {
+		payload = nals
+	}
+	r.onAvPacket(base.AvPacket{
+		PayloadType: pt,
+		Timestamp:   int64(msg.Pts()),
+		Payload:     payload,
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRtmp2AvPacketRemuxer(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	nonIdr := []byte{0x41, 0x9a, 0x02, 0x00}
+	asc := []byte{0x12, 0x10}
+	aacRaw := []byte{0x21, 0x10, 0x04}
+
+	var out []base.AvPacket
+	r2a := remux.NewRtmp2AvPacketRemuxer(func(pkt base.AvPacket) {
+		out = append(out, pkt)
+	})
+	a2r := remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		r2a.FeedRtmpMsg(msg)
+	})
+	a2r.WithOption(func(option *base.AvPacketStreamOption) {
+		option.VideoFormat = base.AvPacketStreamVideoFormatAnnexb
+	})
+
+	annexb := func(nals ...[]byte) (ret []byte) {
+		for _, nal := range nals {
+			ret = append(ret, avc.NaluStartCode4...)
+			ret = append(ret, nal...)
+		}
+		return
+	}
+
+	a2r.InitWithAvConfig(asc, nil, nil, nil)
+	a2r.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 40, Payload: annexb(sps, pps, idr)})
+	a2r.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAac, Timestamp: 50, Payload: aacRaw})
+	a2r.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 80, Payload: annexb(nonIdr)})
+
+	assert.Equal(t, asc, r2a.Asc())
+	assert.Equal(t, 3, len(out))
+	assert.Equal(t, base.AvPacketPtAvc, out[0].PayloadType)
+	assert.Equal(t, int64(40), out[0].Timestamp)
+	assert.Equal(t, annexb(sps, pps, idr), out[0].Payload)
+	assert.Equal(t, base.AvPacketPtAac, out[1].PayloadType)
+	assert.Equal(t, int64(50), out[1].Timestamp)
+	assert.Equal(t, aacRaw, out[1].Payload)
+	assert.Equal(t, annexb(nonIdr), out[2].Payload)
+}
@@ -48,6 +48,14 @@ type IRtmp2MpegtsRemuxerObserver interface {
 	OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool)
 }
 
+type Rtmp2MpegtsRemuxerOption struct {
+	Psi mpegts.PsiOption
+}
+
+var defaultRtmp2MpegtsRemuxerOption = Rtmp2MpegtsRemuxerOption{}
+
+type ModRtmp2MpegtsRemuxerOption func(option *Rtmp2MpegtsRemuxerOption)
+
 // Rtmp2MpegtsRemuxer 输入rtmp流，输出mpegts流
 //
 type Rtmp2MpegtsRemuxer struct {
@@ -62,15 +70,30 @@ type Rtmp2MpegtsRemuxer struct {
 	audioCacheFirstFramePts uint64 // audioCacheFrames中第一个音频帧的时间戳 TODO chef: rename to DTS
 	audioCc                 uint8
 	videoCc                 uint8
+	audioUnsupported        bool // asc无法打包成ADTS
 
 	opened bool
+
+	option     Rtmp2MpegtsRemuxerOption
+	isHevc     bool
+	isMp3      bool
+	psiCc      uint8
+	psiInited  bool
+	lastPsiDts uint64 // 上次插入PAT、PMT的时间戳，单位（毫秒*90）
+	pcrInited  bool
+	lastPcrDts uint64
 }
 
-func NewRtmp2MpegtsRemuxer(observer IRtmp2MpegtsRemuxerObserver) *Rtmp2MpegtsRemuxer {
+func NewRtmp2MpegtsRemuxer(observer IRtmp2MpegtsRemuxerObserver, modOptions ...ModRtmp2MpegtsRemuxerOption) *Rtmp2MpegtsRemuxer {
 	uk := base.GenUkRtmp2MpegtsRemuxer()
+	option := defaultRtmp2MpegtsRemuxerOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
 	r := &Rtmp2MpegtsRemuxer{
 		UniqueKey: uk,
 		observer:  observer,
+		option:    option,
 	}
 	r.audioCacheFrames = nil
 	r.videoOut = make([]byte, initialVideoOutBufferSize)
@@ -129,6 +152,11 @@ func (s *Rtmp2MpegtsRemuxer) FlushAudio() {
 // 实现 iRtmp2MpegtsFilterObserver
 //
 func (s *Rtmp2MpegtsRemuxer) onPatPmt(b []byte) {
+	s.isHevc = s.filter.videoCodecId == int(base.RtmpCodecIdHevc)
+	s.isMp3 = s.filter.audioCodecId == int(base.RtmpSoundFormatMp3)
+	if s.option.Psi != (mpegts.PsiOption{}) || s.isMp3 {
+		b = mpegts.PackPsi(s.option.Psi, s.isHevc, s.isMp3, 0)
+	}
 	s.observer.OnPatPmt(b)
 }
 
@@ -326,6 +354,10 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		Log.Warnf("[%s] rtmp msg too short, ignore. header=%+v, payload=%s", s.UniqueKey, msg.Header, hex.Dump(msg.Payload))
 		return
 	}
+	if msg.IsMp3() {
+		s.feedMp3(msg)
+		return
+	}
 	if msg.Payload[0]>>4 != base.RtmpSoundFormatAac {
 		return
 	}
@@ -339,6 +371,9 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		return
 	}
 
+	if s.audioUnsupported {
+		return
+	}
 	if !s.audioSeqHeaderCached() {
 		Log.Warnf("[%s] feed audio message but aac seq header not exist.", s.UniqueKey)
 		return
@@ -359,14 +394,41 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 	s.audioCacheFrames = append(s.audioCacheFrames, msg.Payload[2:]...)
 }
 
+// feedMp3 mp3帧本身带有帧头，可以直接放入PES，不需要像aac那样添加ADTS头
+//
+func (s *Rtmp2MpegtsRemuxer) feedMp3(msg base.RtmpMsg) {
+	s.isMp3 = true
+	pts := uint64(msg.Header.TimestampAbs) * 90
+
+	if !s.audioCacheEmpty() && s.audioCacheFirstFramePts+maxAudioCacheDelayByAudio < pts {
+		s.FlushAudio()
+	}
+
+	if s.audioCacheEmpty() {
+		s.audioCacheFirstFramePts = pts
+	}
+	s.audioCacheFrames = append(s.audioCacheFrames, msg.Payload[1:]...)
+}
+
 func (s *Rtmp2MpegtsRemuxer) cacheAacSeqHeader(msg base.RtmpMsg) error {
-	var err error
-	s.ascCtx, err = aac.NewAscContext(msg.Payload[2:])
-	return err
+	ascCtx, err := aac.NewAscContext(msg.Payload[2:])
+	if err != nil {
+		return err
+	}
+	// 比如AAC-ELD，无法打包成ADTS，忽略音频
+	if err = ascCtx.CheckAdtsSupported(); err != nil {
+		s.ascCtx = nil
+		s.audioUnsupported = true
+		return err
+	}
+	s.ascCtx = ascCtx
+	s.audioUnsupported = false
+	return nil
 }
 
+// audioSeqHeaderCached 音频是否已经ready，注意，mp3没有seq header，收到过mp3数据即可
 func (s *Rtmp2MpegtsRemuxer) audioSeqHeaderCached() bool {
-	return s.ascCtx != nil
+	return s.ascCtx != nil || s.isMp3
 }
 
 func (s *Rtmp2MpegtsRemuxer) appendSpsPps(out []byte) ([]byte, error) {
@@ -415,7 +477,55 @@ func (s *Rtmp2MpegtsRemuxer) onFrame(frame *mpegts.Frame) {
 		s.opened = true
 	}
 
+	extra := s.packPsiAndPcrIfNeeded(frame)
+
 	packets := frame.Pack()
+	if len(extra) != 0 {
+		packets = append(extra, packets...)
+	}
 
 	s.observer.OnTsPackets(packets, frame, boundary)
 }
+
+// packPsiAndPcrIfNeeded 根据 mpegts.PsiOption ，判断当前帧之前是否需要重复插入PAT、PMT，以及PCR
+//
+// PCR携带在视频PID上时，不生成额外的ts包，而是设置 mpegts.Frame 的WithPcr字段
+//
+func (s *Rtmp2MpegtsRemuxer) packPsiAndPcrIfNeeded(frame *mpegts.Frame) (extra []byte) {
+	psi := &s.option.Psi
+
+	if psi.PatPmtIntervalMs > 0 {
+		if !s.psiInited {
+			// 流开头的PAT、PMT已经通过OnPatPmt回调
+			s.psiInited = true
+			s.lastPsiDts = frame.Dts
+		} else if isIntervalReached(frame.Dts, s.lastPsiDts, uint64(psi.PatPmtIntervalMs)*90) {
+			s.psiCc++
+			extra = append(extra, mpegts.PackPsi(*psi, s.isHevc, s.isMp3, s.psiCc)...)
+			s.lastPsiDts = frame.Dts
+		}
+	}
+
+	interval := psi.PcrInterval()
+	if interval == 0 {
+		return
+	}
+	reached := !s.pcrInited || isIntervalReached(frame.Dts, s.lastPcrDts, interval)
+	if psi.PcrPid != 0 {
+		if reached {
+			extra = append(extra, mpegts.PackPcrPacket(psi.PcrPid, frame.Dts)...)
+			s.pcrInited = true
+			s.lastPcrDts = frame.Dts
+		}
+	} else if frame.Sid == mpegts.StreamIdVideo && (reached || frame.Key) {
+		frame.WithPcr = true
+		s.pcrInited = true
+		s.lastPcrDts = frame.Dts
+	}
+	return
+}
+
+// isIntervalReached 时间戳回退时，也认为达到了间隔
+func isIntervalReached(curr, last, interval uint64) bool {
+	return curr < last || curr-last >= interval
+}
@@ -0,0 +1,97 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRtmp2MpegtsRemuxer_PackPsiAndPcr(t *testing.T) {
+	s := NewRtmp2MpegtsRemuxer(nil, func(option *Rtmp2MpegtsRemuxerOption) {
+		option.Psi.PatPmtIntervalMs = 100
+		option.Psi.PcrPid = 0x1FF
+	})
+
+	frame := func(dtsMs uint64) *mpegts.Frame {
+		return &mpegts.Frame{Dts: dtsMs * 90, Pts: dtsMs * 90, Sid: mpegts.StreamIdVideo}
+	}
+
+	// 流开头只插入PCR
+	extra := s.packPsiAndPcrIfNeeded(frame(0))
+	assert.Equal(t, 188, len(extra))
+	// 没有达到PCR的默认间隔
+	assert.Equal(t, 0, len(s.packPsiAndPcrIfNeeded(frame(20))))
+	assert.Equal(t, 188, len(s.packPsiAndPcrIfNeeded(frame(40))))
+	// PAT、PMT + PCR
+	extra = s.packPsiAndPcrIfNeeded(frame(100))
+	assert.Equal(t, 188*3, len(extra))
+	assert.Equal(t, uint8(1), mpegts.ParseTsPacketHeader(extra).Cc)
+
+	// PCR携带在视频PID上
+	s = NewRtmp2MpegtsRemuxer(nil, func(option *Rtmp2MpegtsRemuxerOption) {
+		option.Psi.PcrIntervalMs = 100
+	})
+	f := frame(0)
+	assert.Equal(t, 0, len(s.packPsiAndPcrIfNeeded(f)))
+	assert.Equal(t, true, f.WithPcr)
+	f = frame(50)
+	s.packPsiAndPcrIfNeeded(f)
+	assert.Equal(t, false, f.WithPcr)
+	f = frame(100)
+	s.packPsiAndPcrIfNeeded(f)
+	assert.Equal(t, true, f.WithPcr)
+}
+
+type testRtmp2MpegtsObserver struct {
+	patPmt []byte
+	frames []mpegts.Frame
+}
+
+func (o *testRtmp2MpegtsObserver) OnPatPmt(b []byte) {
+	o.patPmt = b
+}
+
+func (o *testRtmp2MpegtsObserver) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	o.frames = append(o.frames, *frame)
+}
+
+func TestRtmp2MpegtsRemuxer_Mp3(t *testing.T) {
+	var o testRtmp2MpegtsObserver
+	s := NewRtmp2MpegtsRemuxer(&o)
+
+	mp3Msg := func(ts uint32) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio, TimestampAbs: ts},
+			// 0x2F: SoundFormat mp3, 44100, 16bit, stereo
+			Payload: []byte{0x2F, 0xFF, 0xFB, 0x90, 0x64, 0x00},
+		}
+	}
+	assert.Equal(t, true, mp3Msg(0).IsMp3())
+
+	s.FeedRtmpMessage(mp3Msg(0))
+	s.FeedRtmpMessage(base.RtmpMsg{
+		Header:  base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo},
+		Payload: []byte{0x27, 0x01, 0x00, 0x00, 0x00},
+	})
+	s.FeedRtmpMessage(mp3Msg(26))
+	s.FlushAudio()
+
+	pat := mpegts.ParsePat(o.patPmt[5:])
+	assert.Equal(t, true, pat.SearchPid(mpegts.PidPmt))
+	pmt := mpegts.ParsePmt(o.patPmt[188+5:])
+	assert.Equal(t, uint8(0x03), pmt.SearchPid(mpegts.PidAudio).StreamType)
+
+	assert.Equal(t, 1, len(o.frames))
+	assert.Equal(t, uint8(mpegts.StreamIdAudio), o.frames[0].Sid)
+	assert.Equal(t, []byte{0xFF, 0xFB, 0x90, 0x64, 0x00, 0xFF, 0xFB, 0x90, 0x64, 0x00}, o.frames[0].Raw)
+}
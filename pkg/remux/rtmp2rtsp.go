@@ -9,6 +9,7 @@
 package remux
 
 import (
+	"bytes"
 	"encoding/hex"
 	"math/rand"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/q191201771/lal/pkg/hevc"
 	"github.com/q191201771/lal/pkg/rtprtcp"
 	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/bele"
 )
 
 // TODO(chef): refactor 将analyze部分独立出来作为一个filter
@@ -118,13 +120,78 @@ func (r *Rtmp2RtspRemuxer) FeedRtmpMsg(msg base.RtmpMsg) {
 	// 正常阶段
 
 	// 音视频头已通过sdp回调，rtp数据中不再包含音视频头
-	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() || msg.IsAacSeqHeader() {
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+		r.updateVideoSeqHeader(msg)
+		return
+	}
+	if msg.IsAacSeqHeader() {
 		return
 	}
 
 	r.remux(msg)
 }
 
+// updateVideoSeqHeader 流中途视频seq header发生变化时（比如分辨率切换），重新回调sdp，使得新加入的sub使用新的sps、pps，
+// 并将新的sps、pps打包成rtp发送，使得已有的sub在流中收到新的sps、pps
+//
+// 注意，不支持中途切换视频编码格式
+//
+func (r *Rtmp2RtspRemuxer) updateVideoSeqHeader(msg base.RtmpMsg) {
+	var (
+		vps, sps, pps []byte
+		err           error
+	)
+	if msg.IsAvcKeySeqHeader() {
+		if r.videoPt != base.AvPacketPtAvc {
+			return
+		}
+		sps, pps, err = avc.ParseSpsPpsFromSeqHeader(msg.Payload)
+	} else {
+		if r.videoPt != base.AvPacketPtHevc {
+			return
+		}
+		vps, sps, pps, err = hevc.ParseVpsSpsPpsFromSeqHeader(msg.Payload)
+	}
+	if err != nil {
+		Log.Warnf("parse video seq header failed. err=%+v", err)
+		return
+	}
+	if bytes.Equal(vps, r.vps) && bytes.Equal(sps, r.sps) && bytes.Equal(pps, r.pps) {
+		return
+	}
+	r.vps, r.sps, r.pps = vps, sps, pps
+
+	ctx, err := sdp.Pack(r.vps, r.sps, r.pps, r.asc)
+	if err != nil {
+		Log.Errorf("pack sdp failed. err=%+v", err)
+		return
+	}
+	r.onSdp(ctx)
+
+	var avcc []byte
+	for _, nal := range [][]byte{vps, sps, pps} {
+		if len(nal) == 0 {
+			continue
+		}
+		length := make([]byte, 4)
+		bele.BePutUint32(length, uint32(len(nal)))
+		avcc = append(avcc, length...)
+		avcc = append(avcc, nal...)
+	}
+	packer := r.getVideoPacker()
+	if packer == nil {
+		return
+	}
+	rtppkts := packer.Pack(base.AvPacket{
+		Timestamp:   int64(msg.Header.TimestampAbs),
+		PayloadType: r.videoPt,
+		Payload:     avcc,
+	})
+	for i := range rtppkts {
+		r.onRtpPacket(rtppkts[i])
+	}
+}
+
 func (r *Rtmp2RtspRemuxer) doAnalyze() {
 	Log.Assert(false, r.analyzeDone)
 
@@ -213,7 +280,8 @@ func (r *Rtmp2RtspRemuxer) getAudioPacker() *rtprtcp.RtpPacker {
 			Log.Errorf("parse asc failed. err=%+v", err)
 			return nil
 		}
-		clockRate, err := ascCtx.GetSamplingFrequency()
+		// 与sdp中的采样率保持一致，见 sdp.Pack
+		clockRate, err := ascCtx.GetOutputSamplingFrequency()
 		if err != nil {
 			Log.Errorf("get sampling frequency failed. err=%+v, asc=%s", err, hex.Dump(r.asc))
 		}
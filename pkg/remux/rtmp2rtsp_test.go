@@ -0,0 +1,53 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRtmp2RtspRemuxer_UpdateVideoSeqHeader(t *testing.T) {
+	var (
+		sdpCtxs []sdp.LogicContext
+		pkts    []rtprtcp.RtpPacket
+	)
+	r := NewRtmp2RtspRemuxer(func(sdpCtx sdp.LogicContext) {
+		sdpCtxs = append(sdpCtxs, sdpCtx)
+	}, func(pkt rtprtcp.RtpPacket) {
+		pkts = append(pkts, pkt)
+	})
+
+	sps := []byte{0x67, 0x64, 0x00, 0x20, 0xAC, 0xD9, 0x40, 0xC0, 0x29, 0xB0, 0x11, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x32, 0x0F, 0x18, 0x31, 0x96}
+	seqHeaderMsg := func(pps []byte) base.RtmpMsg {
+		sh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+		assert.Equal(t, nil, err)
+		return base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdVideo}, Payload: sh}
+	}
+
+	r.FeedRtmpMsg(seqHeaderMsg([]byte{0x68, 0xEB, 0xEC, 0xB2, 0x2C}))
+	r.FeedRtmpMsg(base.RtmpMsg{Header: base.RtmpHeader{MsgTypeId: base.RtmpTypeIdAudio}, Payload: []byte{0xAF, 0x00, 0x12, 0x10}})
+	assert.Equal(t, 1, len(sdpCtxs))
+	assert.Equal(t, 0, len(pkts))
+
+	// 相同的seq header，不做处理
+	r.FeedRtmpMsg(seqHeaderMsg([]byte{0x68, 0xEB, 0xEC, 0xB2, 0x2C}))
+	assert.Equal(t, 1, len(sdpCtxs))
+
+	// seq header发生变化，重新回调sdp，并在流中发送新的sps、pps
+	r.FeedRtmpMsg(seqHeaderMsg([]byte{0x68, 0xEE, 0x3C, 0x80}))
+	assert.Equal(t, 2, len(sdpCtxs))
+	assert.Equal(t, []byte{0x68, 0xEE, 0x3C, 0x80}, sdpCtxs[1].Pps)
+	assert.Equal(t, true, len(pkts) > 0)
+}
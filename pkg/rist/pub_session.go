@@ -0,0 +1,262 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rist
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+)
+
+// OnTsPackets @param b: 一个或多个完整的188字节的ts包，回调结束后，内部不再使用该内存块
+type OnTsPackets func(b []byte)
+
+type PubSessionOption struct {
+	// 缺失的包等待重传的最长时间，单位毫秒。越大抗丢包能力越强，但是延时也越大
+	BufferMs int
+
+	// 重排序缓冲区最多缓存的包数量，超过时不再等待缺失的包
+	ReorderWindow int
+}
+
+var defaultPubSessionOption = PubSessionOption{
+	BufferMs:      1000,
+	ReorderWindow: 4096,
+}
+
+type ModPubSessionOption func(option *PubSessionOption)
+
+// PubSession 在`addr`上接收一路RIST simple profile的输入流
+//
+type PubSession struct {
+	uniqueKey string
+	addr      string
+	option    PubSessionOption
+	onTs      OnTsPackets
+
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+
+	mutex      sync.Mutex
+	buffer     *rtprtcp.RtpReorderBuffer
+	rrProducer *rtprtcp.RrProducer
+	mediaSsrc  uint32
+	rtpSrc     *net.UDPAddr // 对端发送rtp的地址
+	rtcpSrc    *net.UDPAddr // 对端发送rtcp的地址，没有收到过rtcp时，使用rtpSrc的端口+1
+	lastSr     uint32
+	lastActive time.Time
+	readBytes  uint64
+
+	disposeOnce sync.Once
+	exitChan    chan struct{}
+}
+
+func NewPubSession(addr string, onTs OnTsPackets, modOptions ...ModPubSessionOption) *PubSession {
+	option := defaultPubSessionOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+	s := &PubSession{
+		uniqueKey:  base.GenUkRistPubSession(),
+		addr:       addr,
+		option:     option,
+		onTs:       onTs,
+		buffer:     rtprtcp.NewRtpReorderBuffer(option.BufferMs, option.ReorderWindow),
+		rrProducer: rtprtcp.NewRrProducer(clockRate),
+		exitChan:   make(chan struct{}),
+	}
+	Log.Infof("[%s] lifecycle new rist PubSession. addr=%s, option=%+v", s.uniqueKey, addr, option)
+	return s
+}
+
+// Listen 监听`addr`接收rtp，并监听端口+1接收rtcp
+//
+func (s *PubSession) Listen() (err error) {
+	rtpAddr, err := net.ResolveUDPAddr("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	rtcpAddr := *rtpAddr
+	rtcpAddr.Port++
+	if s.rtpConn, err = net.ListenUDP("udp", rtpAddr); err != nil {
+		return err
+	}
+	if s.rtcpConn, err = net.ListenUDP("udp", &rtcpAddr); err != nil {
+		_ = s.rtpConn.Close()
+		return err
+	}
+	Log.Infof("[%s] start rist listen. rtp=%s, rtcp=%s", s.uniqueKey, s.rtpConn.LocalAddr(), s.rtcpConn.LocalAddr())
+	return nil
+}
+
+// RunLoop 阻塞直到调用 Dispose
+//
+func (s *PubSession) RunLoop() error {
+	go s.runRtcpLoop()
+	go s.runTickLoop()
+
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, raddr, err := s.rtpConn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.exitChan:
+				return nil
+			default:
+			}
+			return err
+		}
+		s.onRtp(buf[:n], raddr, time.Now())
+	}
+}
+
+func (s *PubSession) Dispose() error {
+	var err error
+	s.disposeOnce.Do(func() {
+		Log.Infof("[%s] lifecycle dispose rist PubSession.", s.uniqueKey)
+		close(s.exitChan)
+		if s.rtpConn != nil {
+			err = s.rtpConn.Close()
+		}
+		if s.rtcpConn != nil {
+			_ = s.rtcpConn.Close()
+		}
+	})
+	return err
+}
+
+func (s *PubSession) UniqueKey() string {
+	return s.uniqueKey
+}
+
+// LocalAddr 实际监听的rtp地址，`addr`中端口为0时可以用于获取实际的端口
+//
+func (s *PubSession) LocalAddr() net.Addr {
+	return s.rtpConn.LocalAddr()
+}
+
+// LastActiveTime 最后一次收到rtp包的时间
+//
+func (s *PubSession) LastActiveTime() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastActive
+}
+
+func (s *PubSession) GetStat() (stat base.StatSession, reorder rtprtcp.RtpReorderBufferStat) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stat.Protocol = base.ProtocolRist
+	stat.SessionId = s.uniqueKey
+	if s.rtpSrc != nil {
+		stat.RemoteAddr = s.rtpSrc.String()
+	}
+	stat.ReadBytesSum = s.readBytes
+	return stat, s.buffer.Stat()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (s *PubSession) onRtp(b []byte, raddr *net.UDPAddr, now time.Time) {
+	pkt, err := rtprtcp.ParseRtpPacket(b)
+	if err != nil || pkt.Header.PacketType != RtpPayloadTypeMp2t {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rtpSrc == nil || s.mediaSsrc != pkt.Header.Ssrc {
+		Log.Infof("[%s] rist source. addr=%s, ssrc=%d", s.uniqueKey, raddr, pkt.Header.Ssrc)
+		s.mediaSsrc = pkt.Header.Ssrc
+	}
+	s.rtpSrc = raddr
+	s.lastActive = now
+	s.readBytes += uint64(len(b))
+	s.rrProducer.FeedRtpPacket(pkt.Header)
+	s.buffer.Push(pkt, now)
+	s.deliver(now)
+}
+
+func (s *PubSession) runRtcpLoop() {
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, raddr, err := s.rtcpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packets, err := rtprtcp.SplitCompoundRtcp(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.mutex.Lock()
+		s.rtcpSrc = raddr
+		for _, p := range packets {
+			if rtprtcp.ParseRtcpHeader(p).PacketType == rtprtcp.RtcpPacketTypeSr && len(p) >= rtprtcp.RtcpSrMinLength {
+				sr := rtprtcp.ParseSr(p)
+				s.lastSr = sr.GetMiddleNtp()
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (s *PubSession) runTickLoop() {
+	nackInterval := time.Duration(s.option.BufferMs/5) * time.Millisecond
+	if nackInterval < minNackIntervalMs*time.Millisecond {
+		nackInterval = minNackIntervalMs * time.Millisecond
+	}
+
+	ticker := time.NewTicker(tickIntervalMs * time.Millisecond)
+	defer ticker.Stop()
+	var lastRr time.Time
+	for {
+		select {
+		case <-s.exitChan:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			s.deliver(now)
+			if seqs := s.buffer.Missing(now, nackInterval); len(seqs) != 0 {
+				s.sendRtcp(rtprtcp.PackNack(0, s.mediaSsrc, seqs))
+			}
+			if s.rtpSrc != nil && now.Sub(lastRr) >= rrIntervalMs*time.Millisecond {
+				s.sendRtcp(s.rrProducer.Produce(s.lastSr))
+				lastRr = now
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// deliver 注意，调用方需要持有锁
+func (s *PubSession) deliver(now time.Time) {
+	for _, pkt := range s.buffer.Pop(now) {
+		s.onTs(pkt.Body())
+	}
+}
+
+// sendRtcp 注意，调用方需要持有锁
+func (s *PubSession) sendRtcp(b []byte) {
+	if b == nil {
+		return
+	}
+	dst := s.rtcpSrc
+	if dst == nil {
+		if s.rtpSrc == nil {
+			return
+		}
+		dst = &net.UDPAddr{IP: s.rtpSrc.IP, Port: s.rtpSrc.Port + 1, Zone: s.rtpSrc.Zone}
+	}
+	if _, err := s.rtcpConn.WriteToUDP(b, dst); err != nil {
+		Log.Warnf("[%s] send rtcp failed. addr=%s, err=%+v", s.uniqueKey, dst, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rist_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/rist"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPubSession(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []byte
+	)
+	s := rist.NewPubSession("127.0.0.1:19450", func(b []byte) {
+		mu.Lock()
+		got = append(got, b[0])
+		mu.Unlock()
+	}, func(option *rist.PubSessionOption) {
+		option.BufferMs = 500
+	})
+	assert.Equal(t, nil, s.Listen())
+	go s.RunLoop()
+	defer s.Dispose()
+
+	rtpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19450})
+	assert.Equal(t, nil, err)
+	defer rtpConn.Close()
+	rtcpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19451})
+	assert.Equal(t, nil, err)
+	defer rtcpConn.Close()
+
+	sendRtp := func(seq uint16) {
+		h := rtprtcp.MakeDefaultRtpHeader()
+		h.PacketType = rist.RtpPayloadTypeMp2t
+		h.Seq = seq
+		h.Ssrc = 1234
+		payload := make([]byte, 188)
+		payload[0] = byte(seq)
+		_, err := rtpConn.Write(rtprtcp.MakeRtpPacket(h, payload).Raw)
+		assert.Equal(t, nil, err)
+	}
+
+	// 让接收端知道rtcp的地址
+	sr := rtprtcp.Sr{SenderSsrc: 1234}
+	_, err = rtcpConn.Write(sr.Pack())
+	assert.Equal(t, nil, err)
+	time.Sleep(20 * time.Millisecond)
+
+	sendRtp(0)
+	sendRtp(2)
+
+	// 收到缺失包的NACK
+	_ = rtcpConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	var seqs []uint16
+	for len(seqs) == 0 {
+		n, err := rtcpConn.Read(buf)
+		assert.Equal(t, nil, err)
+		if err != nil {
+			break
+		}
+		packets, _ := rtprtcp.SplitCompoundRtcp(buf[:n])
+		for _, p := range packets {
+			h := rtprtcp.ParseRtcpHeader(p)
+			if h.PacketType == rtprtcp.RtcpPacketTypeRtpfb && h.CountOrFormat == rtprtcp.RtcpFormatNack {
+				var ssrc uint32
+				ssrc, seqs, _ = rtprtcp.ParseNack(p)
+				assert.Equal(t, uint32(1234), ssrc)
+			}
+		}
+	}
+	assert.Equal(t, []uint16{1}, seqs)
+
+	sendRtp(1)
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []byte{0, 1, 2}, got)
+	mu.Unlock()
+
+	stat, reorder := s.GetStat()
+	assert.Equal(t, "RIST", stat.Protocol)
+	assert.Equal(t, uint64(1), reorder.Recovered)
+}
@@ -0,0 +1,34 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rist
+
+// RIST（Reliable Internet Stream Transport）simple profile，VSF TR-06-1
+//
+// - 媒体数据为rtp over udp，payload为mpegts（payload type 33），rtp使用偶数端口P，rtcp使用端口P+1
+// - 接收端通过rtcp generic NACK（rfc4585）请求发送端重传丢失的包
+// - 接收端按seq重新排序，缺失的包在缓冲时长内等待重传
+//
+// 目前只支持作为接收端（推流输入）
+
+const (
+	RtpPayloadTypeMp2t = 33
+
+	// mpegts的rtp时间戳为90kHz
+	clockRate = 90000
+
+	// 发送rtcp receiver report的间隔
+	rrIntervalMs = 1000
+
+	// 处理缓冲区超时、发送NACK的间隔
+	tickIntervalMs = 10
+
+	minNackIntervalMs = 20
+
+	udpMaxPacketSize = 1500
+)
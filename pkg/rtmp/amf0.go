@@ -14,6 +14,7 @@ package rtmp
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -32,6 +33,9 @@ const (
 	Amf0TypeMarkerObjectEnd  = uint8(0x09)
 	Amf0TypeMarkerLongString = uint8(0x0c)
 
+	// Amf0TypeMarkerAvmplusObject 表示接下来的一个值使用amf3编码，见 Amf3
+	Amf0TypeMarkerAvmplusObject = uint8(0x11)
+
 	// 还没用到的类型
 	//Amf0TypeMarkerMovieclip   = uint8(0x04)
 	//Amf0TypeMarkerUndefined   = uint8(0x06)
@@ -138,6 +142,21 @@ func (amf0) WriteObject(writer io.Writer, opa ObjectPairArray) error {
 	if _, err := writer.Write([]byte{Amf0TypeMarkerObject}); err != nil {
 		return err
 	}
+	return writeObjectProperties(writer, opa)
+}
+
+// WriteArray 写入ecma array
+func (amf0) WriteArray(writer io.Writer, opa ObjectPairArray) error {
+	if _, err := writer.Write([]byte{Amf0TypeMarkerEcmaArray}); err != nil {
+		return err
+	}
+	if err := bele.WriteBe(writer, uint32(len(opa))); err != nil {
+		return err
+	}
+	return writeObjectProperties(writer, opa)
+}
+
+func writeObjectProperties(writer io.Writer, opa ObjectPairArray) error {
 	for i := 0; i < len(opa); i++ {
 		if err := bele.WriteBe(writer, uint16(len(opa[i].Key))); err != nil {
 			return err
@@ -158,8 +177,21 @@ func (amf0) WriteObject(writer io.Writer, opa ObjectPairArray) error {
 			if err := Amf0.WriteBoolean(writer, opa[i].Value.(bool)); err != nil {
 				return err
 			}
+		case float64:
+			if err := Amf0.WriteNumber(writer, opa[i].Value.(float64)); err != nil {
+				return err
+			}
+		case ObjectPairArray:
+			if err := Amf0.WriteArray(writer, opa[i].Value.(ObjectPairArray)); err != nil {
+				return err
+			}
+		case nil:
+			if err := Amf0.WriteNull(writer); err != nil {
+				return err
+			}
 		default:
-			Log.Panicf("unknown value type. i=%d, v=%+v", i, opa[i].Value)
+			// 比如amf3解码出的[]byte、[]interface{}，amf0中没有对应的类型
+			return fmt.Errorf("%w. key=%s, value=%T", base.ErrAmfInvalidType, opa[i].Key, opa[i].Value)
 		}
 	}
 	_, err := writer.Write(Amf0TypeMarkerObjectEndBytes)
@@ -210,6 +242,14 @@ func (amf0) ReadString(b []byte) (val string, l int, err error) {
 	case Amf0TypeMarkerLongString:
 		val, l, err = Amf0.ReadLongStringWithoutType(b[1:])
 		l++
+	case Amf0TypeMarkerAvmplusObject:
+		var v interface{}
+		if v, l, err = readAvmplusValue(b); err == nil {
+			var ok bool
+			if val, ok = v.(string); !ok {
+				return "", 0, base.NewErrAmfInvalidType(b[0])
+			}
+		}
 	default:
 		err = base.NewErrAmfInvalidType(b[0])
 	}
@@ -217,6 +257,16 @@ func (amf0) ReadString(b []byte) (val string, l int, err error) {
 }
 
 func (amf0) ReadNumber(b []byte) (float64, int, error) {
+	if len(b) > 0 && b[0] == Amf0TypeMarkerAvmplusObject {
+		v, l, err := readAvmplusValue(b)
+		if err != nil {
+			return 0, 0, err
+		}
+		if val, ok := v.(float64); ok {
+			return val, l, nil
+		}
+		return 0, 0, base.NewErrAmfInvalidType(b[0])
+	}
 	if len(b) < 9 {
 		return 0, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
@@ -240,6 +290,16 @@ func (amf0) ReadNull(b []byte) (int, error) {
 	if len(b) < 1 {
 		return 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
+	if b[0] == Amf0TypeMarkerAvmplusObject {
+		v, l, err := readAvmplusValue(b)
+		if err != nil {
+			return 0, err
+		}
+		if v != nil {
+			return 0, base.NewErrAmfInvalidType(b[0])
+		}
+		return l, nil
+	}
 	if b[0] != Amf0TypeMarkerNull {
 		return 0, base.NewErrAmfInvalidType(b[0])
 	}
@@ -250,6 +310,9 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 	if len(b) < 1 {
 		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
+	if b[0] == Amf0TypeMarkerAvmplusObject {
+		return readAvmplusObject(b)
+	}
 	if b[0] != Amf0TypeMarkerObject {
 		return nil, 0, base.NewErrAmfInvalidType(b[0])
 	}
@@ -305,6 +368,13 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 				return nil, 0, err
 			}
 			index += l
+		case Amf0TypeMarkerAvmplusObject:
+			v, l, err := readAvmplusValue(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
 		default:
 			Log.Panicf("unknown type. vt=%d", vt)
 		}
@@ -312,7 +382,6 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 }
 
 // TODO chef:
-// - 实现WriteArray
 // - ReadArray和ReadObject有些代码重复
 
 func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
@@ -364,6 +433,13 @@ func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
 				return nil, 0, err
 			}
 			index += l
+		case Amf0TypeMarkerAvmplusObject:
+			v, l, err := readAvmplusValue(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
 		default:
 			Log.Panicf("unknown type. vt=%d", vt)
 		}
@@ -387,6 +463,32 @@ func (amf0) ReadObjectOrArray(b []byte) (ObjectPairArray, int, error) {
 		return Amf0.ReadObject(b)
 	case Amf0TypeMarkerEcmaArray:
 		return Amf0.ReadArray(b)
+	case Amf0TypeMarkerAvmplusObject:
+		return readAvmplusObject(b)
 	}
 	return nil, 0, base.NewErrAmfInvalidType(b[0])
 }
+
+// ----------------------------------------------------------------------------
+
+// readAvmplusValue `b`的第一个字节为 Amf0TypeMarkerAvmplusObject ，返回的长度包含该字节
+func readAvmplusValue(b []byte) (interface{}, int, error) {
+	v, l, err := Amf3.ReadValue(b[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, l + 1, nil
+}
+
+// readAvmplusObject amf3的object和带associative部分的array都解码为 ObjectPairArray
+func readAvmplusObject(b []byte) (ObjectPairArray, int, error) {
+	v, l, err := readAvmplusValue(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	ops, ok := v.(ObjectPairArray)
+	if !ok {
+		return nil, 0, base.NewErrAmfInvalidType(b[0])
+	}
+	return ops, l, nil
+}
@@ -74,15 +74,26 @@ func TestAmf0_WriteObject_ReadObject(t *testing.T) {
 		{Key: "air", Value: 3},
 		{Key: "ban", Value: "cat"},
 		{Key: "dog", Value: true},
+		{Key: "egg", Value: 29.97},
+		{Key: "fox", Value: ObjectPairArray{{Key: "gun", Value: 1}}},
 	}
 	err := Amf0.WriteObject(out, objs)
 	assert.Equal(t, nil, err)
 	v, _, err := Amf0.ReadObject(out.Bytes())
 	assert.Equal(t, nil, err)
-	assert.Equal(t, 3, len(v))
+	assert.Equal(t, 5, len(v))
+	assert.Equal(t, 29.97, v.Find("egg"))
 	assert.Equal(t, float64(3), v.Find("air"))
 	assert.Equal(t, "cat", v.Find("ban"))
 	assert.Equal(t, true, v.Find("dog"))
+
+	// amf3解码出的值，amf0中没有对应类型的返回错误
+	for _, val := range []interface{}{[]byte{1}, []interface{}{1.0}} {
+		err = Amf0.WriteArray(&bytes.Buffer{}, ObjectPairArray{{Key: "hat", Value: val}})
+		assert.IsNotNil(t, err)
+	}
+	err = Amf0.WriteArray(&bytes.Buffer{}, ObjectPairArray{{Key: "hat", Value: nil}})
+	assert.Equal(t, nil, err)
 }
 
 func TestAmf0_WriteNull_readNull(t *testing.T) {
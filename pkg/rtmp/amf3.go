@@ -0,0 +1,487 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+// amf3.go
+// @pure
+// 提供amf3格式的编码与解码的操作
+//
+// 在rtmp中，amf3出现在以下场景：
+// 1. amf3类型的command message和data message（type id 17和15），消息体的第一个字节为0，之后为amf0编码
+// 2. amf0编码中的avmplus-object（0x11），表示接下来的一个值使用amf3编码，比如部分Flash客户端connect中的对象
+//
+// 解码后的类型：
+// undefined、null -> nil
+// false、true     -> bool
+// integer、double -> float64（和amf0的number保持一致，方便使用 ObjectPairArray.FindNumber ）
+// string、xml     -> string
+// date            -> float64，unix毫秒
+// byte array      -> []byte
+// object          -> ObjectPairArray
+// array           -> 只有dense部分时为[]interface{}，否则为 ObjectPairArray ，dense部分的key为下标
+//
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+const (
+	Amf3TypeMarkerUndefined = uint8(0x00)
+	Amf3TypeMarkerNull      = uint8(0x01)
+	Amf3TypeMarkerFalse     = uint8(0x02)
+	Amf3TypeMarkerTrue      = uint8(0x03)
+	Amf3TypeMarkerInteger   = uint8(0x04)
+	Amf3TypeMarkerDouble    = uint8(0x05)
+	Amf3TypeMarkerString    = uint8(0x06)
+	Amf3TypeMarkerXmlDoc    = uint8(0x07)
+	Amf3TypeMarkerDate      = uint8(0x08)
+	Amf3TypeMarkerArray     = uint8(0x09)
+	Amf3TypeMarkerObject    = uint8(0x0a)
+	Amf3TypeMarkerXml       = uint8(0x0b)
+	Amf3TypeMarkerByteArray = uint8(0x0c)
+
+	// 还没用到的类型
+	//Amf3TypeMarkerVectorInt    = uint8(0x0d)
+	//Amf3TypeMarkerVectorUint   = uint8(0x0e)
+	//Amf3TypeMarkerVectorDouble = uint8(0x0f)
+	//Amf3TypeMarkerVectorObject = uint8(0x10)
+	//Amf3TypeMarkerDictionary   = uint8(0x11)
+)
+
+const (
+	amf3IntegerMax = 1<<28 - 1
+	amf3IntegerMin = -1 << 28
+)
+
+// 对象、数组嵌套的最大深度，避免恶意构造的数据导致栈溢出
+const amf3MaxDecodeDepth = 32
+
+type amf3 struct{}
+
+var Amf3 amf3
+
+// ----------------------------------------------------------------------------
+
+// WriteValue 写入一个amf3编码的值，支持的类型见文件头部的说明，另外支持int
+//
+// 注意，写入时不使用引用表，每个值都完整写入
+//
+func (amf3) WriteValue(writer io.Writer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return writeBytes(writer, Amf3TypeMarkerNull)
+	case bool:
+		if val {
+			return writeBytes(writer, Amf3TypeMarkerTrue)
+		}
+		return writeBytes(writer, Amf3TypeMarkerFalse)
+	case int:
+		if val >= amf3IntegerMin && val <= amf3IntegerMax {
+			if err := writeBytes(writer, Amf3TypeMarkerInteger); err != nil {
+				return err
+			}
+			return writeU29(writer, uint32(val))
+		}
+		return Amf3.WriteValue(writer, float64(val))
+	case float64:
+		if err := writeBytes(writer, Amf3TypeMarkerDouble); err != nil {
+			return err
+		}
+		return bele.WriteBe(writer, val)
+	case string:
+		if err := writeBytes(writer, Amf3TypeMarkerString); err != nil {
+			return err
+		}
+		return writeUtf8Vr(writer, val)
+	case []byte:
+		if err := writeBytes(writer, Amf3TypeMarkerByteArray); err != nil {
+			return err
+		}
+		if err := writeU29(writer, uint32(len(val))<<1|1); err != nil {
+			return err
+		}
+		_, err := writer.Write(val)
+		return err
+	case []interface{}:
+		if err := writeBytes(writer, Amf3TypeMarkerArray); err != nil {
+			return err
+		}
+		if err := writeU29(writer, uint32(len(val))<<1|1); err != nil {
+			return err
+		}
+		// 没有associative部分
+		if err := writeUtf8Vr(writer, ""); err != nil {
+			return err
+		}
+		for _, item := range val {
+			if err := Amf3.WriteValue(writer, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ObjectPairArray:
+		// 匿名的dynamic object，没有sealed成员：traits inline（0b11）、dynamic（0b1000）、成员数0
+		if err := writeBytes(writer, Amf3TypeMarkerObject, 0x0b); err != nil {
+			return err
+		}
+		// class name
+		if err := writeUtf8Vr(writer, ""); err != nil {
+			return err
+		}
+		for _, op := range val {
+			if err := writeUtf8Vr(writer, op.Key); err != nil {
+				return err
+			}
+			if err := Amf3.WriteValue(writer, op.Value); err != nil {
+				return err
+			}
+		}
+		return writeUtf8Vr(writer, "")
+	}
+	return nazaerrors.Wrap(base.ErrAmfInvalidType)
+}
+
+// ReadValue 从`b`中读取一个amf3编码的值，返回值的含义和amf0的read类型的方法相同
+//
+// 注意，引用表只在本次调用中有效
+//
+func (amf3) ReadValue(b []byte) (interface{}, int, error) {
+	r := amf3Reader{b: b}
+	v, err := r.readValue()
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, r.index, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func writeBytes(writer io.Writer, b ...byte) error {
+	_, err := writer.Write(b)
+	return err
+}
+
+// writeU29 amf3中的变长整数，最多4个字节，前3个字节每个字节使用低7位，最高位表示后面是否还有字节，第4个字节使用全部8位
+func writeU29(writer io.Writer, v uint32) error {
+	v &= 0x1fffffff
+	switch {
+	case v < 0x80:
+		return writeBytes(writer, byte(v))
+	case v < 0x4000:
+		return writeBytes(writer, byte(v>>7|0x80), byte(v&0x7f))
+	case v < 0x200000:
+		return writeBytes(writer, byte(v>>14|0x80), byte(v>>7&0x7f|0x80), byte(v&0x7f))
+	}
+	return writeBytes(writer, byte(v>>22|0x80), byte(v>>15&0x7f|0x80), byte(v>>8&0x7f|0x80), byte(v))
+}
+
+func writeUtf8Vr(writer io.Writer, s string) error {
+	if err := writeU29(writer, uint32(len(s))<<1|1); err != nil {
+		return err
+	}
+	_, err := writer.Write([]byte(s))
+	return err
+}
+
+// ----------------------------------------------------------------------------
+
+type amf3Traits struct {
+	dynamic bool
+	sealed  []string
+}
+
+// amf3Reader 解码时的上下文，字符串、对象、traits的引用表
+type amf3Reader struct {
+	b     []byte
+	index int
+	depth int
+
+	strings []string
+	objects []interface{}
+	traits  []amf3Traits
+}
+
+func (r *amf3Reader) readValue() (interface{}, error) {
+	if r.depth >= amf3MaxDecodeDepth {
+		return nil, fmt.Errorf("%w. amf3 nested too deep", base.ErrAmfInvalidType)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	marker, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case Amf3TypeMarkerUndefined, Amf3TypeMarkerNull:
+		return nil, nil
+	case Amf3TypeMarkerFalse:
+		return false, nil
+	case Amf3TypeMarkerTrue:
+		return true, nil
+	case Amf3TypeMarkerInteger:
+		v, err := r.readU29()
+		if err != nil {
+			return nil, err
+		}
+		// 29位有符号整数
+		if v&0x10000000 != 0 {
+			return float64(int32(v) - 0x20000000), nil
+		}
+		return float64(v), nil
+	case Amf3TypeMarkerDouble:
+		return r.readDouble()
+	case Amf3TypeMarkerString:
+		return r.readUtf8Vr()
+	case Amf3TypeMarkerXmlDoc, Amf3TypeMarkerXml:
+		return r.readObjectRefOr(func(l int) (interface{}, error) {
+			b, err := r.readN(l)
+			return string(b), err
+		})
+	case Amf3TypeMarkerDate:
+		return r.readObjectRefOr(func(int) (interface{}, error) {
+			return r.readDouble()
+		})
+	case Amf3TypeMarkerByteArray:
+		return r.readObjectRefOr(func(l int) (interface{}, error) {
+			b, err := r.readN(l)
+			if err != nil {
+				return nil, err
+			}
+			return append([]byte(nil), b...), nil
+		})
+	case Amf3TypeMarkerArray:
+		return r.readArray()
+	case Amf3TypeMarkerObject:
+		return r.readObject()
+	}
+	return nil, base.NewErrAmfInvalidType(marker)
+}
+
+// readObjectRefOr 读取U29O-ref，是引用时返回引用表中的对象，否则使用`fn`读取，并加入引用表
+//
+// @param fn: 参数为U29中去掉引用标志位后的值，一般是长度
+//
+func (r *amf3Reader) readObjectRefOr(fn func(l int) (interface{}, error)) (interface{}, error) {
+	h, err := r.readU29()
+	if err != nil {
+		return nil, err
+	}
+	if h&1 == 0 {
+		return r.objectRef(int(h >> 1))
+	}
+	v, err := fn(int(h >> 1))
+	if err != nil {
+		return nil, err
+	}
+	r.objects = append(r.objects, v)
+	return v, nil
+}
+
+func (r *amf3Reader) readArray() (interface{}, error) {
+	h, err := r.readU29()
+	if err != nil {
+		return nil, err
+	}
+	if h&1 == 0 {
+		return r.objectRef(int(h >> 1))
+	}
+	denseCount := int(h >> 1)
+	// 先占位，数组内部可能引用自身
+	pos := len(r.objects)
+	r.objects = append(r.objects, nil)
+
+	var assoc ObjectPairArray
+	for {
+		k, err := r.readUtf8Vr()
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			break
+		}
+		v, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		assoc = append(assoc, ObjectPair{Key: k, Value: v})
+	}
+	if denseCount > len(r.b)-r.index {
+		return nil, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	dense := make([]interface{}, 0, denseCount)
+	for i := 0; i < denseCount; i++ {
+		v, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		dense = append(dense, v)
+	}
+
+	var ret interface{} = dense
+	if assoc != nil {
+		for i, v := range dense {
+			assoc = append(assoc, ObjectPair{Key: strconv.Itoa(i), Value: v})
+		}
+		ret = assoc
+	}
+	r.objects[pos] = ret
+	return ret, nil
+}
+
+func (r *amf3Reader) readObject() (interface{}, error) {
+	h, err := r.readU29()
+	if err != nil {
+		return nil, err
+	}
+	if h&1 == 0 {
+		return r.objectRef(int(h >> 1))
+	}
+
+	var traits amf3Traits
+	if h&2 == 0 {
+		i := int(h >> 2)
+		if i >= len(r.traits) {
+			return nil, nazaerrors.Wrap(base.ErrAmfInvalidType)
+		}
+		traits = r.traits[i]
+	} else {
+		if h&4 != 0 {
+			// externalizable对象的格式由类自己定义，无法通用解析
+			return nil, nazaerrors.Wrap(base.ErrAmfInvalidType)
+		}
+		traits.dynamic = h&8 != 0
+		if _, err := r.readUtf8Vr(); err != nil { // class name
+			return nil, err
+		}
+		sealedCount := int(h >> 4)
+		if sealedCount > len(r.b)-r.index {
+			return nil, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		for i := 0; i < sealedCount; i++ {
+			name, err := r.readUtf8Vr()
+			if err != nil {
+				return nil, err
+			}
+			traits.sealed = append(traits.sealed, name)
+		}
+		r.traits = append(r.traits, traits)
+	}
+
+	pos := len(r.objects)
+	r.objects = append(r.objects, nil)
+
+	var ops ObjectPairArray
+	for _, name := range traits.sealed {
+		v, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, ObjectPair{Key: name, Value: v})
+	}
+	if traits.dynamic {
+		for {
+			k, err := r.readUtf8Vr()
+			if err != nil {
+				return nil, err
+			}
+			if k == "" {
+				break
+			}
+			v, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, ObjectPair{Key: k, Value: v})
+		}
+	}
+	r.objects[pos] = ops
+	return ops, nil
+}
+
+func (r *amf3Reader) objectRef(i int) (interface{}, error) {
+	if i >= len(r.objects) {
+		return nil, nazaerrors.Wrap(base.ErrAmfInvalidType)
+	}
+	return r.objects[i], nil
+}
+
+func (r *amf3Reader) readUtf8Vr() (string, error) {
+	h, err := r.readU29()
+	if err != nil {
+		return "", err
+	}
+	if h&1 == 0 {
+		i := int(h >> 1)
+		if i >= len(r.strings) {
+			return "", nazaerrors.Wrap(base.ErrAmfInvalidType)
+		}
+		return r.strings[i], nil
+	}
+	b, err := r.readN(int(h >> 1))
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	// 空字符串不加入引用表
+	if s != "" {
+		r.strings = append(r.strings, s)
+	}
+	return s, nil
+}
+
+func (r *amf3Reader) readU29() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		c, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if i == 3 {
+			return v<<8 | uint32(c), nil
+		}
+		v = v<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	return v, nil
+}
+
+func (r *amf3Reader) readDouble() (float64, error) {
+	b, err := r.readN(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(bele.BeUint64(b)), nil
+}
+
+func (r *amf3Reader) readByte() (byte, error) {
+	b, err := r.readN(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *amf3Reader) readN(n int) ([]byte, error) {
+	if n < 0 || len(r.b)-r.index < n {
+		return nil, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	b := r.b[r.index : r.index+n]
+	r.index += n
+	return b, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+
+	. "github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAmf3_WriteValue_ReadValue(t *testing.T) {
+	obj := ObjectPairArray{
+		{Key: "app", Value: "live"},
+		{Key: "objectEncoding", Value: float64(3)},
+		{Key: "fpad", Value: false},
+		{Key: "nested", Value: ObjectPairArray{{Key: "a", Value: nil}}},
+	}
+	cases := []struct {
+		in  interface{}
+		out interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{false, false},
+		{0, float64(0)},
+		{127, float64(127)},
+		{-1, float64(-1)},
+		{0x0fffffff, float64(0x0fffffff)},
+		{-0x10000000, float64(-0x10000000)},
+		{1 << 30, float64(1 << 30)},
+		{1.5, 1.5},
+		{"", ""},
+		{"~!@#$%^&*()_+", "~!@#$%^&*()_+"},
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{[]interface{}{1, "a", obj}, []interface{}{float64(1), "a", obj}},
+		{obj, obj},
+	}
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		err := Amf3.WriteValue(out, c.in)
+		assert.Equal(t, nil, err)
+		v, l, err := Amf3.ReadValue(out.Bytes())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.out, v)
+		assert.Equal(t, out.Len(), l)
+	}
+
+	err := Amf3.WriteValue(&bytes.Buffer{}, struct{}{})
+	assert.Equal(t, true, errors.Is(err, base.ErrAmfInvalidType))
+}
+
+func TestAmf3_ReadValue_Reference(t *testing.T) {
+	cases := []struct {
+		in  []byte
+		out interface{}
+	}{
+		// 字符串引用
+		{
+			[]byte{0x09, 0x05, 0x01, 0x06, 0x07, 'a', 'b', 'c', 0x06, 0x00},
+			[]interface{}{"abc", "abc"},
+		},
+		// traits引用，第一个对象有一个sealed成员
+		{
+			[]byte{0x09, 0x05, 0x01, 0x0a, 0x13, 0x01, 0x03, 'a', 0x04, 0x01, 0x0a, 0x01, 0x04, 0x02},
+			[]interface{}{ObjectPairArray{{Key: "a", Value: float64(1)}}, ObjectPairArray{{Key: "a", Value: float64(2)}}},
+		},
+		// 对象引用，下标0为数组自身
+		{
+			[]byte{0x09, 0x05, 0x01, 0x0a, 0x0b, 0x01, 0x03, 'x', 0x04, 0x05, 0x01, 0x0a, 0x02},
+			[]interface{}{ObjectPairArray{{Key: "x", Value: float64(5)}}, ObjectPairArray{{Key: "x", Value: float64(5)}}},
+		},
+		// 带associative部分的数组
+		{
+			[]byte{0x09, 0x03, 0x03, 'k', 0x03, 0x01, 0x06, 0x03, 'v'},
+			ObjectPairArray{{Key: "k", Value: true}, {Key: "0", Value: "v"}},
+		},
+		// 4字节的U29
+		{
+			[]byte{0x04, 0xff, 0xff, 0xff, 0xff},
+			float64(-1),
+		},
+	}
+	for _, c := range cases {
+		v, l, err := Amf3.ReadValue(c.in)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.out, v)
+		assert.Equal(t, len(c.in), l)
+	}
+}
+
+func TestAmf3_ReadValue_Error(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		{0x05, 0x00},                  // double长度不够
+		{0x06, 0x07, 'a'},             // 字符串长度不够
+		{0x06, 0x00},                  // 字符串引用不存在
+		{0x0a, 0x02},                  // 对象引用不存在
+		{0x0a, 0x07, 0x01},            // externalizable
+		{0x09, 0x21, 0x01, 0x01},      // dense部分长度不够
+		{0x0d, 0x01},                  // 不支持的类型
+		{0x04, 0xff, 0xff, 0xff},      // U29长度不够
+		{0x0a, 0x0b, 0x01, 0x03, 'x'}, // 缺少成员的值
+	}
+	for _, c := range cases {
+		_, _, err := Amf3.ReadValue(c)
+		assert.IsNotNil(t, err)
+	}
+
+	// 嵌套过深，每一层是只有一个dense元素的数组
+	nested := bytes.Repeat([]byte{0x09, 0x03, 0x01}, 100000)
+	nested = append(nested, 0x01)
+	_, _, err := Amf3.ReadValue(nested)
+	assert.IsNotNil(t, err)
+	_, _, err = Amf3.ReadValue(nested[len(nested)-3*31-1:])
+	assert.Equal(t, nil, err)
+}
+
+func TestAmf0_ReadAvmplusObject(t *testing.T) {
+	amf3Obj := &bytes.Buffer{}
+	_ = Amf3.WriteValue(amf3Obj, ObjectPairArray{{Key: "app", Value: "live"}, {Key: "n", Value: 1}})
+
+	// amf0的object中，某个成员的值使用amf3编码
+	b := []byte{Amf0TypeMarkerObject, 0, 3, 'o', 'b', 'j', Amf0TypeMarkerAvmplusObject}
+	b = append(b, amf3Obj.Bytes()...)
+	b = append(b, 0, 3, 'k', 'e', 'y', Amf0TypeMarkerString, 0, 1, 'v')
+	b = append(b, Amf0TypeMarkerObjectEndBytes...)
+	opa, l, err := Amf0.ReadObject(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	assert.Equal(t, ObjectPairArray{
+		{Key: "obj", Value: ObjectPairArray{{Key: "app", Value: "live"}, {Key: "n", Value: float64(1)}}},
+		{Key: "key", Value: "v"},
+	}, opa)
+
+	// 整个object使用amf3编码
+	b = append([]byte{Amf0TypeMarkerAvmplusObject}, amf3Obj.Bytes()...)
+	opa, l, err = Amf0.ReadObject(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	app, err := opa.FindString("app")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "live", app)
+
+	// 字符串、数字、null
+	s, l, err := Amf0.ReadString([]byte{Amf0TypeMarkerAvmplusObject, 0x06, 0x03, 'a'})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a", s)
+	assert.Equal(t, 4, l)
+	n, l, err := Amf0.ReadNumber([]byte{Amf0TypeMarkerAvmplusObject, 0x04, 0x02})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(2), n)
+	assert.Equal(t, 3, l)
+	l, err = Amf0.ReadNull([]byte{Amf0TypeMarkerAvmplusObject, 0x01})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, l)
+
+	_, _, err = Amf0.ReadNumber([]byte{Amf0TypeMarkerAvmplusObject, 0x06, 0x03, 'a'})
+	assert.Equal(t, true, errors.Is(err, base.ErrAmfInvalidType))
+}
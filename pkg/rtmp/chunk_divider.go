@@ -19,13 +19,18 @@ type ChunkDivider struct {
 	localChunkSize int
 }
 
-var defaultChunkDivider = ChunkDivider{
-	localChunkSize: LocalChunkSize,
+func NewChunkDivider(localChunkSize int) *ChunkDivider {
+	return &ChunkDivider{
+		localChunkSize: localChunkSize,
+	}
 }
 
-// Message2Chunks @return 返回的内存块由内部申请，不依赖参数<message>内存块
+// Message2Chunks 使用 LocalChunkSize 切割
+//
+// @return 返回的内存块由内部申请，不依赖参数<message>内存块
+//
 func Message2Chunks(message []byte, header *base.RtmpHeader) []byte {
-	return defaultChunkDivider.Message2Chunks(message, header)
+	return message2Chunks(message, header, nil, LocalChunkSize)
 }
 
 // Message2Chunks TODO chef: 新的 message 的第一个 chunk 始终使用 fmt0 格式，没有参考前一个 message
@@ -33,6 +38,84 @@ func (d *ChunkDivider) Message2Chunks(message []byte, header *base.RtmpHeader) [
 	return message2Chunks(message, header, nil, d.localChunkSize)
 }
 
+// Rechunk 将 Message2Chunks 切割的、只包含一个message的chunk数据，按`d`的chunk size重新切割
+//
+// 用于发送端和对端要求的chunk size不同时，比如转推到要求特定chunk size的CDN
+//
+func (d *ChunkDivider) Rechunk(chunks []byte) ([]byte, error) {
+	if d.localChunkSize == LocalChunkSize {
+		return chunks, nil
+	}
+	message, header, err := chunks2Message(chunks, LocalChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return message2Chunks(message, &header, nil, d.localChunkSize), nil
+}
+
+// chunks2Message 解析 message2Chunks 切割的数据，第一个chunk为fmt0，后续chunk为fmt3
+//
+func chunks2Message(chunks []byte, chunkSize int) (message []byte, header base.RtmpHeader, err error) {
+	if len(chunks) < 1 || chunks[0]>>6 != 0 {
+		return nil, header, base.ErrRtmpUnexpectedMsg
+	}
+	basicHeaderLen := 1
+	switch chunks[0] & 0x3f {
+	case 0:
+		basicHeaderLen = 2
+	case 1:
+		basicHeaderLen = 3
+	}
+	if len(chunks) < basicHeaderLen+11 {
+		return nil, header, base.NewErrRtmpShortBuffer(basicHeaderLen+11, len(chunks), "chunks2Message")
+	}
+	switch basicHeaderLen {
+	case 1:
+		header.Csid = int(chunks[0] & 0x3f)
+	case 2:
+		header.Csid = int(chunks[1]) + 64
+	case 3:
+		header.Csid = int(chunks[1]) + int(chunks[2])<<8 + 64
+	}
+
+	index := basicHeaderLen
+	timestamp := bele.BeUint24(chunks[index:])
+	header.MsgLen = bele.BeUint24(chunks[index+3:])
+	header.MsgTypeId = chunks[index+6]
+	header.MsgStreamId = int(bele.LeUint32(chunks[index+7:]))
+	index += 11
+
+	// 扩展时间戳，后续的fmt3 chunk也会携带
+	extLen := 0
+	if timestamp == maxTimestampInMessageHeader {
+		extLen = 4
+		if len(chunks) < index+extLen {
+			return nil, header, base.NewErrRtmpShortBuffer(index+extLen, len(chunks), "chunks2Message")
+		}
+		timestamp = bele.BeUint32(chunks[index:])
+		index += extLen
+	}
+	header.TimestampAbs = timestamp
+
+	message = make([]byte, 0, header.MsgLen)
+	for {
+		n := int(header.MsgLen) - len(message)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if len(chunks) < index+n {
+			return nil, header, base.NewErrRtmpShortBuffer(index+n, len(chunks), "chunks2Message")
+		}
+		message = append(message, chunks[index:index+n]...)
+		index += n
+		if len(message) == int(header.MsgLen) {
+			break
+		}
+		index += basicHeaderLen + extLen
+	}
+	return message, header, nil
+}
+
 // @param 返回头的大小
 func calcHeader(header *base.RtmpHeader, prevHeader *base.RtmpHeader, out []byte) int {
 	var index int
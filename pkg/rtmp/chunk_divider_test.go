@@ -0,0 +1,47 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestChunkDividerRechunk(t *testing.T) {
+	message := bytes.Repeat([]byte{0x17, 0x01, 0x02, 0x03}, 2500)
+	headers := []base.RtmpHeader{
+		{Csid: CsidVideo, MsgLen: uint32(len(message)), MsgTypeId: base.RtmpTypeIdVideo, MsgStreamId: Msid1, TimestampAbs: 1000},
+		// 扩展时间戳，以及2字节的csid
+		{Csid: 100, MsgLen: uint32(len(message)), MsgTypeId: base.RtmpTypeIdVideo, MsgStreamId: Msid1, TimestampAbs: 0x1000000},
+	}
+	for _, h := range headers {
+		chunks := Message2Chunks(message, &h)
+
+		m, header, err := chunks2Message(chunks, LocalChunkSize)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, message, m)
+		assert.Equal(t, h, header)
+
+		out, err := NewChunkDivider(128).Rechunk(chunks)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, message2Chunks(message, &h, nil, 128), out)
+
+		// chunk size相同时，不重新切割
+		out, err = NewChunkDivider(LocalChunkSize).Rechunk(chunks)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, chunks, out)
+
+		_, err = NewChunkDivider(128).Rechunk(chunks[:len(chunks)-1])
+		assert.Equal(t, true, errors.Is(err, base.ErrRtmpShortBuffer))
+	}
+}
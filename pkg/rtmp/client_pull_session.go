@@ -27,6 +27,7 @@ type PullSessionOption struct {
 	ReadBufSize          int // io层读取音视频数据时的缓冲大小，如果为0，则没有缓冲
 	HandshakeComplexFlag bool
 	PeerWinAckSize       int
+	ProxyUrl             string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
 }
 
 var defaultPullSessionOption = PullSessionOption{
@@ -35,6 +36,7 @@ var defaultPullSessionOption = PullSessionOption{
 	ReadBufSize:          0,
 	HandshakeComplexFlag: false,
 	PeerWinAckSize:       0,
+	ProxyUrl:             "",
 }
 
 type ModPullSessionOption func(option *PullSessionOption)
@@ -52,6 +54,7 @@ func NewPullSession(modOptions ...ModPullSessionOption) *PullSession {
 			option.ReadBufSize = opt.ReadBufSize
 			option.HandshakeComplexFlag = opt.HandshakeComplexFlag
 			option.PeerWinAckSize = opt.PeerWinAckSize
+			option.ProxyUrl = opt.ProxyUrl
 		}),
 	}
 }
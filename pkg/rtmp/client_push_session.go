@@ -13,7 +13,8 @@ import "github.com/q191201771/lal/pkg/base"
 type PushSession struct {
 	IsFresh bool
 
-	core *ClientSession
+	core    *ClientSession
+	divider *ChunkDivider // ChunkSize和 LocalChunkSize 不同时，用于重新切割发送的数据
 }
 
 type PushSessionOption struct {
@@ -25,6 +26,15 @@ type PushSessionOption struct {
 	WriteBufSize         int // io层发送音视频数据的缓冲大小，如果为0，则没有缓冲
 	WriteChanSize        int // io层发送音视频数据的异步队列大小，如果为0，则同步发送
 	HandshakeComplexFlag bool
+	ProxyUrl             string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
+
+	// 见 ClientSessionOption 中的同名字段，用于对端有特定要求的场景，比如部分CDN
+	// ChunkSize和 LocalChunkSize 不同时，Write的数据会按ChunkSize重新切割
+	ChunkSize     int
+	WinAckSize    int
+	PeerBandwidth int
+
+	MaxWriteBitrateKbits int // 见 ClientSessionOption.MaxWriteBitrateKbits
 }
 
 var defaultPushSessionOption = PushSessionOption{
@@ -33,6 +43,11 @@ var defaultPushSessionOption = PushSessionOption{
 	WriteBufSize:         0,
 	WriteChanSize:        0,
 	HandshakeComplexFlag: false,
+	ProxyUrl:             "",
+	ChunkSize:            0,
+	WinAckSize:           0,
+	PeerBandwidth:        0,
+	MaxWriteBitrateKbits: 0,
 }
 
 type ModPushSessionOption func(option *PushSessionOption)
@@ -42,6 +57,10 @@ func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
 	for _, fn := range modOptions {
 		fn(&opt)
 	}
+	var divider *ChunkDivider
+	if opt.ChunkSize > 0 && opt.ChunkSize != LocalChunkSize {
+		divider = NewChunkDivider(opt.ChunkSize)
+	}
 	return &PushSession{
 		IsFresh: true,
 		core: NewClientSession(CstPushSession, func(option *ClientSessionOption) {
@@ -50,7 +69,13 @@ func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
 			option.WriteBufSize = opt.WriteBufSize
 			option.WriteChanSize = opt.WriteChanSize
 			option.HandshakeComplexFlag = opt.HandshakeComplexFlag
+			option.ProxyUrl = opt.ProxyUrl
+			option.ChunkSize = opt.ChunkSize
+			option.WinAckSize = opt.WinAckSize
+			option.PeerBandwidth = opt.PeerBandwidth
+			option.MaxWriteBitrateKbits = opt.MaxWriteBitrateKbits
 		}),
+		divider: divider,
 	}
 }
 
@@ -61,7 +86,15 @@ func (s *PushSession) Push(rawUrl string) error {
 
 // 发送数据
 // 注意，业务方需将数据打包成rtmp chunk格式后，再调用该函数发送
+// 设置了和 LocalChunkSize 不同的 PushSessionOption.ChunkSize 时，每次调用只能传入一个由 Message2Chunks 切割的message
 func (s *PushSession) Write(msg []byte) error {
+	if s.divider != nil {
+		chunks, err := s.divider.Rechunk(msg)
+		if err != nil {
+			return err
+		}
+		msg = chunks
+	}
 	return s.core.Write(msg)
 }
 
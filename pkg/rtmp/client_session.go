@@ -74,6 +74,25 @@ type ClientSessionOption struct {
 	HandshakeComplexFlag bool // 握手是否使用复杂模式
 
 	PeerWinAckSize int
+
+	// ChunkSize WinAckSize PeerBandwidth
+	//
+	// 本端发送的Set Chunk Size、Window Acknowledgement Size、Set Peer Bandwidth的值，用于对端有特定要求的场景，比如部分CDN。
+	// ChunkSize为0则使用 LocalChunkSize ，WinAckSize和PeerBandwidth为0则不发送
+	//
+	// 注意，ChunkSize只影响信令的切割，发送的音视频数据需要调用方按ChunkSize切割，见 PushSession
+	//
+	ChunkSize     int
+	WinAckSize    int
+	PeerBandwidth int
+
+	ProxyUrl string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
+
+	// MaxWriteBitrateKbits 发送码率上限，单位kbit/s，为0则不限制，见 base.ShapedConn
+	//
+	// 注意，限制后发送会阻塞，需要同时设置`WriteChanSize`异步发送，异步队列满时发送返回错误，而不是阻塞调用方
+	//
+	MaxWriteBitrateKbits int
 }
 
 var defaultClientSessOption = ClientSessionOption{
@@ -85,6 +104,11 @@ var defaultClientSessOption = ClientSessionOption{
 	WriteChanSize:        0,
 	HandshakeComplexFlag: false,
 	PeerWinAckSize:       0,
+	ChunkSize:            0,
+	WinAckSize:           0,
+	PeerBandwidth:        0,
+	ProxyUrl:             "",
+	MaxWriteBitrateKbits: 0,
 }
 
 type ModClientSessionOption func(option *ClientSessionOption)
@@ -111,12 +135,18 @@ func NewClientSession(t ClientSessionType, modOptions ...ModClientSessionOption)
 		hc = &HandshakeClientSimple{}
 	}
 
+	if option.ChunkSize == 0 {
+		option.ChunkSize = LocalChunkSize
+	}
+	packer := NewMessagePacker()
+	packer.chunkSize = option.ChunkSize
+
 	s := &ClientSession{
 		uniqueKey:     uk,
 		t:             t,
 		option:        option,
 		doResultChan:  make(chan struct{}, 1),
-		packer:        NewMessagePacker(),
+		packer:        packer,
 		chunkComposer: NewChunkComposer(),
 		stat: base.StatSession{
 			Protocol:  base.ProtocolRtmp,
@@ -254,12 +284,27 @@ func (s *ClientSession) doContext(ctx context.Context, rawUrl string) error {
 			return
 		}
 
-		Log.Infof("[%s] > W SetChunkSize %d.", s.uniqueKey, LocalChunkSize)
-		if err := s.packer.writeChunkSize(s.conn, LocalChunkSize); err != nil {
+		Log.Infof("[%s] > W SetChunkSize %d.", s.uniqueKey, s.option.ChunkSize)
+		if err := s.packer.writeChunkSize(s.conn, s.option.ChunkSize); err != nil {
 			errChan <- err
 			return
 		}
 
+		if s.option.WinAckSize > 0 {
+			Log.Infof("[%s] > W Window Acknowledgement Size %d.", s.uniqueKey, s.option.WinAckSize)
+			if err := s.packer.writeWinAckSize(s.conn, s.option.WinAckSize); err != nil {
+				errChan <- err
+				return
+			}
+		}
+		if s.option.PeerBandwidth > 0 {
+			Log.Infof("[%s] > W Set Peer Bandwidth %d.", s.uniqueKey, s.option.PeerBandwidth)
+			if err := s.packer.writePeerBandwidth(s.conn, s.option.PeerBandwidth, peerBandwidthLimitTypeDynamic); err != nil {
+				errChan <- err
+				return
+			}
+		}
+
 		Log.Infof("[%s] > W connect('%s'). tcUrl=%s", s.uniqueKey, s.appName(), s.tcUrl())
 		if err := s.packer.writeConnect(s.conn, s.appName(), s.tcUrl(), s.t == CstPushSession); err != nil {
 			errChan <- err
@@ -311,13 +356,20 @@ func (s *ClientSession) tcpConnect() error {
 	s.stat.RemoteAddr = s.urlCtx.HostWithPort
 
 	var conn net.Conn
-	if conn, err = net.Dial("tcp", s.urlCtx.HostWithPort); err != nil {
+	if conn, err = base.DialTcp(s.urlCtx.HostWithPort, s.option.ProxyUrl); err != nil {
 		return err
 	}
+	if s.option.MaxWriteBitrateKbits > 0 {
+		conn = base.NewShapedConn(conn, s.option.MaxWriteBitrateKbits)
+	}
 
 	s.conn = connection.New(conn, func(option *connection.Option) {
 		option.ReadBufSize = s.option.ReadBufSize
 		option.WriteChanFullBehavior = connection.WriteChanFullBehaviorBlock
+		if s.option.MaxWriteBitrateKbits > 0 {
+			// 数据的码率持续超过限制时，断开而不是阻塞调用方
+			option.WriteChanFullBehavior = connection.WriteChanFullBehaviorReturnError
+		}
 	})
 	return nil
 }
@@ -366,8 +418,12 @@ func (s *ClientSession) doMsg(stream *Stream) error {
 		return s.doProtocolControlMessage(stream)
 	case base.RtmpTypeIdCommandMessageAmf0:
 		return s.doCommandMessage(stream)
+	case base.RtmpTypeIdCommandMessageAmf3:
+		return s.doCommandAmf3Message(stream)
 	case base.RtmpTypeIdMetadata:
 		return s.doDataMessageAmf0(stream)
+	case base.RtmpTypeIdDataMessageAmf3:
+		return s.doDataMessageAmf3(stream)
 	case base.RtmpTypeIdAck:
 		return s.doAck(stream)
 	case base.RtmpTypeIdUserControl:
@@ -420,6 +476,18 @@ func (s *ClientSession) doDataMessageAmf0(stream *Stream) error {
 	return nil
 }
 
+func (s *ClientSession) doDataMessageAmf3(stream *Stream) error {
+	//去除前面的0就是Amf0的数据
+	stream.msg.Skip(1)
+	return s.doDataMessageAmf0(stream)
+}
+
+func (s *ClientSession) doCommandAmf3Message(stream *Stream) error {
+	//去除前面的0就是Amf0的数据
+	stream.msg.Skip(1)
+	return s.doCommandMessage(stream)
+}
+
 func (s *ClientSession) doCommandMessage(stream *Stream) error {
 	cmd, err := stream.msg.readStringWithType()
 	if err != nil {
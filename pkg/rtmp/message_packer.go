@@ -27,6 +27,8 @@ const (
 //
 type MessagePacker struct {
 	b *Buffer
+
+	chunkSize int // 信令大于chunk size时切割成多个chunk，为0则使用 LocalChunkSize
 }
 
 func NewMessagePacker() *MessagePacker {
@@ -57,7 +59,11 @@ func writeSingleChunkHeader(out []byte, csid int, bodyLen int, typeid uint8, str
 func (packer *MessagePacker) ChunkAndWrite(writer io.Writer, csid int, typeid uint8, streamid int) error {
 	bodyLen := packer.b.Len() - 12
 
-	if bodyLen <= LocalChunkSize {
+	chunkSize := packer.chunkSize
+	if chunkSize == 0 {
+		chunkSize = LocalChunkSize
+	}
+	if bodyLen <= chunkSize {
 		// 如果一个chunk就够放（大部分信令都是这种情况），我们直接在buffer前面预留的空间写入chunk header内容，避免造成拷贝
 		writeSingleChunkHeader(packer.b.Bytes(), csid, bodyLen, typeid, streamid)
 		_, err := packer.b.WriteTo(writer)
@@ -70,7 +76,7 @@ func (packer *MessagePacker) ChunkAndWrite(writer io.Writer, csid int, typeid ui
 	h.MsgTypeId = typeid
 	h.MsgStreamId = streamid
 	h.TimestampAbs = 0
-	chunks := Message2Chunks(packer.b.Bytes()[12:], &h)
+	chunks := message2Chunks(packer.b.Bytes()[12:], &h, nil, chunkSize)
 	packer.b.Reset()
 	_, err := writer.Write(chunks)
 	return err
@@ -231,6 +237,22 @@ func (packer *MessagePacker) writeOnStatusPlay(writer io.Writer, streamid int) e
 	return packer.ChunkAndWrite(writer, csidOverStream, base.RtmpTypeIdCommandMessageAmf0, streamid)
 }
 
+func (packer *MessagePacker) writeOnStatusError(writer io.Writer, streamid int, code string, description string) error {
+	packer.b.ModWritePos(12)
+
+	_ = Amf0.WriteString(packer.b, "onStatus")
+	_ = Amf0.WriteNumber(packer.b, 0)
+	_ = Amf0.WriteNull(packer.b)
+	objs := []ObjectPair{
+		{Key: "level", Value: "error"},
+		{Key: "code", Value: code},
+		{Key: "description", Value: description},
+	}
+	_ = Amf0.WriteObject(packer.b, objs)
+
+	return packer.ChunkAndWrite(writer, csidOverStream, base.RtmpTypeIdCommandMessageAmf0, streamid)
+}
+
 func (packer *MessagePacker) writeStreamIsRecorded(writer io.Writer, streamid uint32) error {
 	packer.b.ModWritePos(12)
 
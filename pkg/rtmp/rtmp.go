@@ -28,6 +28,12 @@ const (
 	tidClientPublish      = 3
 )
 
+// OnStatusCodePublishRejected 服务端拒绝或者中途断开推流时，onStatus中的code，见 ServerSession.DisposeWithStatus
+const OnStatusCodePublishRejected = "NetStream.Publish.Rejected"
+
+// OnStatusCodePlayFailed 服务端拒绝拉流时，onStatus中的code
+const OnStatusCodePlayFailed = "NetStream.Play.Failed"
+
 // basic header 3 | message header 11 | extended ts 4
 const maxHeaderSize = 18
 
@@ -10,6 +10,8 @@ package rtmp
 
 import (
 	"net"
+
+	"github.com/q191201771/lal/pkg/base"
 )
 
 type IServerObserver interface {
@@ -36,21 +38,51 @@ type IServerObserver interface {
 type Server struct {
 	addr     string
 	observer IServerObserver
+	option   ServerOption
 	ln       net.Listener
 }
 
-func NewServer(addr string, observer IServerObserver) *Server {
+type ServerOption struct {
+	ProxyProtocolEnable bool // 是否解析PROXY protocol头，获取客户端真实地址，见 base.ProxyProtocolListener
+	ReusePort           bool // 监听时是否设置SO_REUSEPORT，见 base.ListenTcp
+
+	ConnGuard *base.ConnGuard // 防连接风暴，为nil时不开启
+
+	ProxyProtocolTrustedNets []*net.IPNet // 只解析来自这些网段的PROXY protocol头，见 base.NewProxyProtocolListener
+}
+
+var defaultServerOption = ServerOption{
+	ProxyProtocolEnable: false,
+	ReusePort:           false,
+	ConnGuard:           nil,
+}
+
+type ModServerOption func(option *ServerOption)
+
+func NewServer(addr string, observer IServerObserver, modOptions ...ModServerOption) *Server {
+	option := defaultServerOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
 	return &Server{
 		addr:     addr,
 		observer: observer,
+		option:   option,
 	}
 }
 
 func (server *Server) Listen() (err error) {
-	if server.ln, err = net.Listen("tcp", server.addr); err != nil {
+	if server.ln, err = base.ListenTcp(server.addr, server.option.ReusePort); err != nil {
 		return
 	}
-	Log.Infof("start rtmp server listen. addr=%s", server.addr)
+	// 注意，PROXY protocol头的读取也受握手超时的限制
+	if server.option.ConnGuard != nil {
+		server.ln = server.option.ConnGuard.WrapListener(server.ln, true)
+	}
+	if server.option.ProxyProtocolEnable {
+		server.ln = base.NewProxyProtocolListener(server.ln, server.option.ProxyProtocolTrustedNets)
+	}
+	Log.Infof("start rtmp server listen. addr=%s, proxy protocol=%v", server.addr, server.option.ProxyProtocolEnable)
 	return
 }
 
@@ -11,8 +11,8 @@ package rtmp
 import (
 	"fmt"
 	"net"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -63,6 +63,7 @@ type ServerSession struct {
 	appName                string // const after set
 	streamName             string // const after set
 	rawQuery               string //const after set
+	appRawQuery            string // const after set. 部分客户端会把参数放在connect的app中，比如`live?token=1`
 
 	observer      IServerSessionObserver
 	t             ServerSessionType
@@ -70,11 +71,15 @@ type ServerSession struct {
 	chunkComposer *ChunkComposer
 	packer        *MessagePacker
 
+	rawConn      net.Conn // 握手完成后调用 base.MarkHandshakeDone
 	conn         connection.Connection
 	prevConnStat connection.Stat
 	staleStat    *connection.Stat
 	stat         base.StatSession
 
+	peerWinAckSize int    // 对端通过Window Acknowledgement Size设置的窗口大小，为0表示对端没有设置
+	recvLastAck    uint64 // 上一次回复Acknowledgement时，已接收的字节数
+
 	// only for PubSession
 	avObserver IPubSessionObserver
 
@@ -100,6 +105,7 @@ type ServerSession struct {
 func NewServerSession(observer IServerSessionObserver, conn net.Conn) *ServerSession {
 	uk := base.GenUkRtmpServerSession()
 	s := &ServerSession{
+		rawConn: conn,
 		conn: connection.New(conn, func(option *connection.Option) {
 			option.ReadBufSize = readBufSize
 		}),
@@ -126,6 +132,7 @@ func (s *ServerSession) RunLoop() (err error) {
 		_ = s.dispose(err)
 		return err
 	}
+	base.MarkHandshakeDone(s.rawConn)
 
 	err = s.runReadLoop()
 	_ = s.dispose(err)
@@ -147,10 +154,30 @@ func (s *ServerSession) Flush() error {
 	return s.conn.Flush()
 }
 
+// WritePingRequest 发送User Control Ping Request，比如用于等待推流期间的保活
+//
+// 注意，可以和读协程并发调用
+//
+func (s *ServerSession) WritePingRequest() error {
+	return NewMessagePacker().writePingRequest(s.conn, uint32(time.Now().Unix()))
+}
+
 func (s *ServerSession) Dispose() error {
 	return s.dispose(nil)
 }
 
+// DisposeWithStatus 先给对端发送level为error的onStatus，再关闭，用于告知对端被关闭的原因
+//
+// @param code 比如 OnStatusCodePublishRejected
+//
+func (s *ServerSession) DisposeWithStatus(code string, description string) error {
+	if s.conn != nil {
+		Log.Infof("[%s] > W onStatus('%s'). description=%s", s.uniqueKey, code, description)
+		_ = NewMessagePacker().writeOnStatusError(s.conn, Msid1, code, description)
+	}
+	return s.dispose(nil)
+}
+
 func (s *ServerSession) Url() string {
 	return s.url
 }
@@ -163,6 +190,14 @@ func (s *ServerSession) StreamName() string {
 	return s.streamName
 }
 
+// SetStreamName 修改流名称，用于业务方重写推流、拉流的流名称
+//
+// 注意，需要在rtmp publish或play信令处理完成，也即 IServerSessionObserver 的回调中调用
+//
+func (s *ServerSession) SetStreamName(streamName string) {
+	s.streamName = streamName
+}
+
 func (s *ServerSession) RawQuery() string {
 	return s.rawQuery
 }
@@ -231,16 +266,25 @@ func (s *ServerSession) handshake() error {
 
 func (s *ServerSession) doMsg(stream *Stream) error {
 	//log.Debugf("%d %d %v", stream.header.msgTypeId, stream.msgLen, stream.header)
+	if err := s.doRespAcknowledgement(); err != nil {
+		return err
+	}
 	switch stream.header.MsgTypeId {
 	case base.RtmpTypeIdSetChunkSize:
 		// noop
 		// 因为底层的 chunk composer 已经处理过了，这里就不用处理
+	case base.RtmpTypeIdWinAckSize:
+		return s.doWinAckSize(stream)
+	case base.RtmpTypeIdBandwidth:
+		Log.Debugf("[%s] < R Set Peer Bandwidth. ignore.", s.uniqueKey)
 	case base.RtmpTypeIdCommandMessageAmf0:
 		return s.doCommandMessage(stream)
 	case base.RtmpTypeIdCommandMessageAmf3:
 		return s.doCommandAmf3Message(stream)
 	case base.RtmpTypeIdMetadata:
 		return s.doDataMessageAmf0(stream)
+	case base.RtmpTypeIdDataMessageAmf3:
+		return s.doDataMessageAmf3(stream)
 	case base.RtmpTypeIdAck:
 		return s.doAck(stream)
 	case base.RtmpTypeIdUserControl:
@@ -259,6 +303,32 @@ func (s *ServerSession) doMsg(stream *Stream) error {
 	return nil
 }
 
+func (s *ServerSession) doWinAckSize(stream *Stream) error {
+	if stream.msg.Len() < 4 {
+		return base.NewErrRtmpShortBuffer(4, int(stream.msg.Len()), "ServerSession::doWinAckSize")
+	}
+	s.peerWinAckSize = int(bele.BeUint32(stream.msg.buff.Bytes()))
+	Log.Infof("[%s] < R Window Acknowledgement Size: %d", s.uniqueKey, s.peerWinAckSize)
+	return nil
+}
+
+// doRespAcknowledgement 对端设置了窗口大小时，每收到窗口大小的数据，回复一次Acknowledgement
+//
+// 部分推流端在收不到Acknowledgement时，会停止发送数据，码率较大时更容易出现
+//
+func (s *ServerSession) doRespAcknowledgement() error {
+	if s.peerWinAckSize <= 0 {
+		return nil
+	}
+	readBytesSum := s.conn.GetStat().ReadBytesSum
+	if readBytesSum-s.recvLastAck < uint64(s.peerWinAckSize) {
+		return nil
+	}
+	s.recvLastAck = readBytesSum
+	// 序列号为目前为止收到的字节数，超过uint32时回绕
+	return s.packer.writeAcknowledgement(s.conn, uint32(readBytesSum))
+}
+
 func (s *ServerSession) doAck(stream *Stream) error {
 	seqNum := bele.BeUint32(stream.msg.buff.Bytes())
 	Log.Infof("[%s] < R Acknowledgement. ignore. sequence number=%d.", s.uniqueKey, seqNum)
@@ -326,6 +396,12 @@ func (s *ServerSession) doDataMessageAmf0(stream *Stream) error {
 	//return nil
 }
 
+func (s *ServerSession) doDataMessageAmf3(stream *Stream) error {
+	//去除前面的0就是Amf0的数据
+	stream.msg.Skip(1)
+	return s.doDataMessageAmf0(stream)
+}
+
 func (s *ServerSession) doCommandMessage(stream *Stream) error {
 	cmd, err := stream.msg.readStringWithType()
 	if err != nil {
@@ -367,6 +443,13 @@ func (s *ServerSession) doCommandAmf3Message(stream *Stream) error {
 	return s.doCommandMessage(stream)
 }
 
+// 参数可能同时存在于publish/play的流名称和connect的app中，合并后作为session的参数
+func (s *ServerSession) parseStreamNameWithRawQuery() {
+	var rawQuery string
+	s.streamName, rawQuery = base.SplitRawQuery(s.streamNameWithRawQuery)
+	s.rawQuery = base.JoinRawQuery(rawQuery, s.appRawQuery)
+}
+
 func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 	val, err := stream.msg.readObjectWithType()
 	if err != nil {
@@ -376,6 +459,7 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 	if err != nil {
 		return err
 	}
+	s.appName, s.appRawQuery = base.SplitRawQuery(s.appName)
 	s.tcUrl, err = val.FindString("tcUrl")
 	if err != nil {
 		Log.Warnf("[%s] tcUrl not exist.", s.uniqueKey)
@@ -384,13 +468,13 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 
 	s.observer.OnRtmpConnect(s, val)
 
-	Log.Infof("[%s] > W Window Acknowledgement Size %d.", s.uniqueKey, windowAcknowledgementSize)
-	if err := s.packer.writeWinAckSize(s.conn, windowAcknowledgementSize); err != nil {
+	Log.Infof("[%s] > W Window Acknowledgement Size %d.", s.uniqueKey, WindowAcknowledgementSize)
+	if err := s.packer.writeWinAckSize(s.conn, WindowAcknowledgementSize); err != nil {
 		return err
 	}
 
-	Log.Infof("[%s] > W Set Peer Bandwidth.", s.uniqueKey)
-	if err := s.packer.writePeerBandwidth(s.conn, peerBandwidth, peerBandwidthLimitTypeDynamic); err != nil {
+	Log.Infof("[%s] > W Set Peer Bandwidth %d.", s.uniqueKey, PeerBandwidth)
+	if err := s.packer.writePeerBandwidth(s.conn, PeerBandwidth, peerBandwidthLimitTypeDynamic); err != nil {
 		return err
 	}
 
@@ -427,11 +511,7 @@ func (s *ServerSession) doPublish(tid int, stream *Stream) (err error) {
 	if err != nil {
 		return err
 	}
-	ss := strings.Split(s.streamNameWithRawQuery, "?")
-	s.streamName = ss[0]
-	if len(ss) == 2 {
-		s.rawQuery = ss[1]
-	}
+	s.parseStreamNameWithRawQuery()
 
 	s.url = fmt.Sprintf("%s/%s", s.tcUrl, s.streamNameWithRawQuery)
 
@@ -466,11 +546,7 @@ func (s *ServerSession) doPlay(tid int, stream *Stream) (err error) {
 	if err != nil {
 		return err
 	}
-	ss := strings.Split(s.streamNameWithRawQuery, "?")
-	s.streamName = ss[0]
-	if len(ss) == 2 {
-		s.rawQuery = ss[1]
-	}
+	s.parseStreamNameWithRawQuery()
 
 	s.url = fmt.Sprintf("%s/%s", s.tcUrl, s.streamNameWithRawQuery)
 
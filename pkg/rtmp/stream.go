@@ -41,12 +41,20 @@ func (stream *Stream) toDebugString() string {
 }
 
 func (stream *Stream) toAvMsg() base.RtmpMsg {
+	header := stream.header
+	if header.MsgTypeId == base.RtmpTypeIdDataMessageAmf3 {
+		// 消息体前面的1个字节已经去除，剩下的是amf0的数据，转换成amf0的data message交给上层，方便转发以及转换成其他协议
+		//
+		// 注意，不能修改stream.header，后续的chunk header可能依赖它
+		header.MsgTypeId = base.RtmpTypeIdMetadata
+		header.MsgLen--
+	}
 	// TODO chef: 考虑可能出现header中的len和buf的大小不一致的情况
-	if stream.header.MsgLen != uint32(stream.msg.buff.Len()) {
-		Log.Errorf("toAvMsg. headerMsgLen=%d, bufLen=%d", stream.header.MsgLen, stream.msg.buff.Len())
+	if header.MsgLen != uint32(stream.msg.buff.Len()) {
+		Log.Errorf("toAvMsg. headerMsgLen=%d, bufLen=%d", header.MsgLen, stream.msg.buff.Len())
 	}
 	return base.RtmpMsg{
-		Header:  stream.header,
+		Header:  header,
 		Payload: stream.msg.buff.Bytes(),
 	}
 }
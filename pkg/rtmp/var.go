@@ -39,8 +39,14 @@ var (
 	//
 	LocalChunkSize = 4096
 
-	windowAcknowledgementSize = 5000000
-	peerBandwidth             = 5000000
+	// WindowAcknowledgementSize PeerBandwidth
+	//
+	// Server Session在connect信令之后发送给对端的Window Acknowledgement Size和Set Peer Bandwidth的值
+	//
+	// 注意，和 LocalChunkSize 一样，需要在创建session之前设置，运行中不应该修改
+	//
+	WindowAcknowledgementSize = 5000000
+	PeerBandwidth             = 5000000
 )
 
 // 接收rtmp数据时，msg的初始内存块大小
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestMswLsw2UnixNano(t *testing.T) {
@@ -21,3 +22,10 @@ func TestMswLsw2UnixNano(t *testing.T) {
 	tt := time.Unix(int64(u/1e9), int64(u%1e9))
 	rtprtcp.Log.Debug(tt.String())
 }
+
+func TestUnixNano2Ntp(t *testing.T) {
+	u := uint64(1650000000123456789)
+	v := rtprtcp.Ntp2UnixNano(rtprtcp.UnixNano2Ntp(u))
+	// 转换有精度损失，误差在1纳秒内
+	assert.Equal(t, true, v <= u && u-v <= 1)
+}
@@ -9,6 +9,9 @@
 package rtprtcp
 
 import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/bele"
 )
 
@@ -83,11 +86,19 @@ import (
 //        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
 const (
-	RtcpPacketTypeSr  = 200 // 0xc8 Sender Report
-	RtcpPacketTypeRr  = 201 // 0xc9 Receiver Report
-	RtcpPacketTypeApp = 204
+	RtcpPacketTypeSr    = 200 // 0xc8 Sender Report
+	RtcpPacketTypeRr    = 201 // 0xc9 Receiver Report
+	RtcpPacketTypeApp   = 204
+	RtcpPacketTypeRtpfb = 205 // 0xcd Transport layer FB message, rfc4585
+
+	RtcpFormatNack = 1 // Generic NACK, RtcpPacketTypeRtpfb
 
 	RtcpHeaderLength = 4
+	RtcpSrMinLength  = 28
+	RtcpRrMinLength  = 8
+
+	RtcpReportBlockLength = 24
+	RtcpFbMinLength       = 12
 
 	RtcpVersion = 2
 )
@@ -109,6 +120,23 @@ type Sr struct {
 	OctetCnt   uint32
 }
 
+// ReportBlock SR和RR中的report block
+type ReportBlock struct {
+	MediaSsrc   uint32
+	Fraction    uint8  // 丢包率，定点数，实际值为Fraction/256
+	Lost        uint32 // 累计丢包数，24b
+	ExtendedSeq uint32
+	Jitter      uint32 // 单位为rtp时间戳
+	Lsr         uint32
+	Dlsr        uint32 // 单位为1/65536秒
+}
+
+// ReceiverReport 解析后的RR包
+type ReceiverReport struct {
+	SenderSsrc uint32
+	Blocks     []ReportBlock
+}
+
 func ParseRtcpHeader(b []byte) RtcpHeader {
 	var h RtcpHeader
 	h.Version = b[0] >> 6
@@ -133,6 +161,121 @@ func ParseSr(b []byte) Sr {
 	return s
 }
 
+// ParseRr rfc3550 6.4.2
+//
+// @param b rtcp包，包含包头
+func ParseRr(b []byte) (ReceiverReport, error) {
+	var r ReceiverReport
+	if len(b) < RtcpRrMinLength {
+		return r, base.ErrRtpRtcpShortBuffer
+	}
+	h := ParseRtcpHeader(b)
+	r.SenderSsrc = bele.BeUint32(b[4:])
+	if len(b) < RtcpRrMinLength+int(h.CountOrFormat)*RtcpReportBlockLength {
+		return r, base.ErrRtpRtcpShortBuffer
+	}
+	for i := 0; i < int(h.CountOrFormat); i++ {
+		r.Blocks = append(r.Blocks, parseReportBlock(b[RtcpRrMinLength+i*RtcpReportBlockLength:]))
+	}
+	return r, nil
+}
+
+// ParseNack rfc4585 6.2.1 Generic NACK
+//
+//        0                   1                   2                   3
+//        0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// header |V=2|P|  FMT=1  |   PT=205      |          length               |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        |                  SSRC of packet sender                        |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        |                  SSRC of media source                         |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// FCI    |            PID                |             BLP               |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        :                              ...                              :
+//
+// @param b rtcp包，包含包头
+//
+// @return mediaSsrc 请求重传的源
+// @return seqs      请求重传的所有rtp包序号
+//
+func ParseNack(b []byte) (mediaSsrc uint32, seqs []uint16, err error) {
+	if len(b) < RtcpFbMinLength {
+		return 0, nil, base.ErrRtpRtcpShortBuffer
+	}
+	mediaSsrc = bele.BeUint32(b[8:])
+	for i := RtcpFbMinLength; i+4 <= len(b); i += 4 {
+		pid := bele.BeUint16(b[i:])
+		blp := bele.BeUint16(b[i+2:])
+		seqs = append(seqs, pid)
+		for j := uint16(0); j < 16; j++ {
+			if blp&(1<<j) != 0 {
+				seqs = append(seqs, pid+j+1)
+			}
+		}
+	}
+	return
+}
+
+// SplitCompoundRtcp 将复合rtcp包（比如RR+SDES）拆分成多个独立的rtcp包
+//
+// 注意，返回的切片引用`b`的内存块
+//
+func SplitCompoundRtcp(b []byte) ([][]byte, error) {
+	var out [][]byte
+	for len(b) > 0 {
+		if len(b) < RtcpHeaderLength {
+			return out, base.ErrRtpRtcpShortBuffer
+		}
+		h := ParseRtcpHeader(b)
+		l := (int(h.Length) + 1) * 4
+		if len(b) < l {
+			return out, base.ErrRtpRtcpShortBuffer
+		}
+		out = append(out, b[:l])
+		b = b[l:]
+	}
+	return out, nil
+}
+
+func parseReportBlock(b []byte) ReportBlock {
+	var rb ReportBlock
+	rb.MediaSsrc = bele.BeUint32(b)
+	rb.Fraction = b[4]
+	rb.Lost = bele.BeUint24(b[5:])
+	rb.ExtendedSeq = bele.BeUint32(b[8:])
+	rb.Jitter = bele.BeUint32(b[12:])
+	rb.Lsr = bele.BeUint32(b[16:])
+	rb.Dlsr = bele.BeUint32(b[20:])
+	return rb
+}
+
+// CalcRttMs 通过RR中的LSR和DLSR计算rtt，单位毫秒
+//
+// @param now 收到RR的时间
+//
+// @return 如果对端还没收到过SR（LSR为0）返回-1
+//
+func CalcRttMs(lsr, dlsr uint32, now time.Time) int {
+	if lsr == 0 {
+		return -1
+	}
+	v := Ntp2Middle(UnixNano2Ntp(uint64(now.UnixNano()))) - lsr - dlsr
+	if int32(v) < 0 {
+		return 0
+	}
+	return int(uint64(v) * 1000 >> 16)
+}
+
+// JitterToMs 将rtcp中的jitter（单位为rtp时间戳）转换为毫秒
+func JitterToMs(jitter uint32, clockRate int) int {
+	if clockRate <= 0 {
+		return 0
+	}
+	return int(uint64(jitter) * 1000 / uint64(clockRate))
+}
+
 // PackTo @param out 传出参数，注意，调用方保证长度>=4
 func (r *RtcpHeader) PackTo(out []byte) {
 	out[0] = r.Version<<6 | r.Padding<<5 | r.CountOrFormat
@@ -15,7 +15,6 @@ type Rr struct {
 	mediaSsrc   uint32
 	fraction    uint8
 	lost        uint32
-	cycles      uint16
 	extendedSeq uint32
 	jitter      uint32
 	lsr         uint32
@@ -38,13 +37,79 @@ func (r *Rr) Pack() []byte {
 	bele.BePutUint32(b[4:], r.senderSsrc)
 	bele.BePutUint32(b[8:], r.mediaSsrc)
 	b[12] = r.fraction
-	// TODO chef: lost的表示是否正确
-	bele.BePutUint24(b[13:], r.lost>>8)
-	bele.BePutUint16(b[16:], r.cycles)
-	bele.BePutUint32(b[18:], r.extendedSeq)
+	bele.BePutUint24(b[13:], r.lost&0xFFFFFF)
+	bele.BePutUint32(b[16:], r.extendedSeq)
 	bele.BePutUint32(b[20:], r.jitter)
 	bele.BePutUint32(b[24:], r.lsr)
-	bele.BePutUint32(b[28:], 0)
+	bele.BePutUint32(b[28:], r.dlsr)
 
 	return b
 }
+
+// Pack 打包不带report block的SR包
+func (s *Sr) Pack() []byte {
+	const lenInWords = 7
+
+	b := make([]byte, lenInWords*4)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = 0
+	h.PacketType = RtcpPacketTypeSr
+	h.Length = lenInWords - 1
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], s.SenderSsrc)
+	bele.BePutUint32(b[8:], s.Msw)
+	bele.BePutUint32(b[12:], s.Lsw)
+	bele.BePutUint32(b[16:], s.Timestamp)
+	bele.BePutUint32(b[20:], s.PktCnt)
+	bele.BePutUint32(b[24:], s.OctetCnt)
+
+	return b
+}
+
+// PackNack 打包Generic NACK（rfc4585），格式见 ParseNack
+//
+// @param seqs: 请求重传的rtp包序号，内部按pid+blp合并，可以无序
+//
+func PackNack(senderSsrc uint32, mediaSsrc uint32, seqs []uint16) []byte {
+	var fcis [][2]uint16 // pid, blp
+	for _, seq := range seqs {
+		merged := false
+		for i := range fcis {
+			d := seq - fcis[i][0]
+			if d >= 1 && d <= 16 {
+				fcis[i][1] |= 1 << (d - 1)
+				merged = true
+				break
+			} else if d == 0 {
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			fcis = append(fcis, [2]uint16{seq, 0})
+		}
+	}
+
+	lenInWords := 3 + len(fcis)
+	b := make([]byte, lenInWords*4)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = RtcpFormatNack
+	h.PacketType = RtcpPacketTypeRtpfb
+	h.Length = uint16(lenInWords - 1)
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], senderSsrc)
+	bele.BePutUint32(b[8:], mediaSsrc)
+	for i, fci := range fcis {
+		bele.BePutUint16(b[RtcpFbMinLength+i*4:], fci[0])
+		bele.BePutUint16(b[RtcpFbMinLength+i*4+2:], fci[1])
+	}
+	return b
+}
@@ -8,7 +8,11 @@
 
 package rtprtcp
 
-import "time"
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
 
 // 通过收到的rtp包和rtcp sr包，产生rtcp rr包
 
@@ -29,6 +33,9 @@ type RrProducer struct {
 
 	expectedPrior uint32
 	receivedPrior uint32
+
+	lost     uint32
+	fraction uint8
 }
 
 func NewRrProducer(clockRate int) *RrProducer {
@@ -40,8 +47,12 @@ func NewRrProducer(clockRate int) *RrProducer {
 	}
 }
 
-// FeedRtpPacket 每次收到rtp包，都将seq序号传入这个函数
-func (r *RrProducer) FeedRtpPacket(seq uint16) {
+// FeedRtpPacket 每次收到rtp包，都将rtp包头传入这个函数
+func (r *RrProducer) FeedRtpPacket(h RtpHeader) {
+	seq := h.Seq
+	r.mediaSsrc = h.Ssrc
+	r.updateJitter(h.Timestamp)
+
 	r.received++
 
 	if r.baseSeq == -1 {
@@ -92,12 +103,14 @@ func (r *RrProducer) Produce(lsr uint32) []byte {
 		fraction = uint8((lostInterval << 8) / expectedInterval)
 	}
 
+	r.lost = lost
+	r.fraction = fraction
+
 	var rr Rr
 	rr.senderSsrc = r.senderSsrc
 	rr.mediaSsrc = r.mediaSsrc
 	rr.fraction = fraction
 	rr.lost = lost
-	rr.cycles = uint16(r.cycles)
 	rr.extendedSeq = r.extendedSeq
 	rr.jitter = r.getJitter()
 	rr.lsr = lsr
@@ -141,3 +154,16 @@ func (r *RrProducer) updateJitter(timestamp uint32) {
 func (r *RrProducer) getJitter() uint32 {
 	return r.jitter >> 4
 }
+
+// GetStat 获取接收统计
+//
+// 注意，丢包信息在每次调用 Produce 时更新，作为接收端无法计算rtt，所以rtt为-1
+//
+func (r *RrProducer) GetStat() base.StatRtcp {
+	return base.StatRtcp{
+		Jitter:      JitterToMs(r.getJitter(), r.clockRate),
+		Rtt:         -1,
+		LossRate:    float64(r.fraction) / 256,
+		LostPackets: r.lost,
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+import "time"
+
+// 通过发送的rtp包，产生rtcp sr包
+
+type SrProducer struct {
+	clockRate int
+
+	senderSsrc    uint32
+	pktCnt        uint32
+	octetCnt      uint32
+	lastTimestamp uint32
+	lastTime      time.Time
+}
+
+func NewSrProducer(clockRate int) *SrProducer {
+	return &SrProducer{
+		clockRate: clockRate,
+	}
+}
+
+// FeedRtpPacket 每次发送rtp包，都将rtp包传入这个函数
+func (s *SrProducer) FeedRtpPacket(pkt RtpPacket) {
+	s.senderSsrc = pkt.Header.Ssrc
+	s.pktCnt++
+	payloadOffset := int(pkt.Header.payloadOffset)
+	if payloadOffset == 0 {
+		payloadOffset = RtpFixedHeaderLength
+	}
+	if len(pkt.Raw) > payloadOffset {
+		s.octetCnt += uint32(len(pkt.Raw) - payloadOffset)
+	}
+	s.lastTimestamp = pkt.Header.Timestamp
+	s.lastTime = time.Now()
+}
+
+// Produce 产生sr包
+//
+// @param now: 当前时间
+// @return:    sr包的二进制数据，如果还没有发送过rtp包，则返回nil
+//
+func (s *SrProducer) Produce(now time.Time) []byte {
+	if s.pktCnt == 0 {
+		return nil
+	}
+
+	ntp := UnixNano2Ntp(uint64(now.UnixNano()))
+
+	var sr Sr
+	sr.SenderSsrc = s.senderSsrc
+	sr.Msw = uint32(ntp >> 32)
+	sr.Lsw = uint32(ntp)
+	// 将最后一个rtp包的时间戳，按物理时间的流逝，推算到当前时间
+	sr.Timestamp = s.lastTimestamp + uint32(now.Sub(s.lastTime).Milliseconds()*int64(s.clockRate)/1000)
+	sr.PktCnt = s.pktCnt
+	sr.OctetCnt = s.octetCnt
+	return sr.Pack()
+}
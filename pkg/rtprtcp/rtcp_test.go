@@ -0,0 +1,146 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestParseRr(t *testing.T) {
+	// RR(1 report block) + SDES
+	b := []byte{
+		0x81, 0xc9, 0x00, 0x07,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+		0x10, 0x00, 0x00, 0x05,
+		0x00, 0x01, 0x00, 0x10,
+		0x00, 0x00, 0x00, 0x20,
+		0x00, 0x00, 0x00, 0x30,
+		0x00, 0x00, 0x00, 0x40,
+		0x81, 0xca, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+	}
+	packets, err := rtprtcp.SplitCompoundRtcp(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(packets))
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeRr), packets[0][1])
+
+	rr, err := rtprtcp.ParseRr(packets[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(1), rr.SenderSsrc)
+	assert.Equal(t, 1, len(rr.Blocks))
+	assert.Equal(t, rtprtcp.ReportBlock{
+		MediaSsrc:   2,
+		Fraction:    0x10,
+		Lost:        5,
+		ExtendedSeq: 0x10010,
+		Jitter:      0x20,
+		Lsr:         0x30,
+		Dlsr:        0x40,
+	}, rr.Blocks[0])
+
+	_, err = rtprtcp.SplitCompoundRtcp(b[:20])
+	assert.IsNotNil(t, err)
+	_, err = rtprtcp.ParseRr(b[:20])
+	assert.IsNotNil(t, err)
+}
+
+func TestRrProducer(t *testing.T) {
+	p := rtprtcp.NewRrProducer(90000)
+	assert.Equal(t, true, p.Produce(0) == nil)
+
+	// 丢掉seq为3的包
+	for _, seq := range []uint16{1, 2, 4, 5} {
+		p.FeedRtpPacket(rtprtcp.RtpHeader{Seq: seq, Timestamp: uint32(seq) * 3000, Ssrc: 100})
+	}
+	b := p.Produce(0x12345678)
+	rr, err := rtprtcp.ParseRr(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(rr.Blocks))
+	assert.Equal(t, uint32(100), rr.Blocks[0].MediaSsrc)
+	assert.Equal(t, uint32(1), rr.Blocks[0].Lost)
+	assert.Equal(t, uint32(5), rr.Blocks[0].ExtendedSeq)
+	assert.Equal(t, uint32(0x12345678), rr.Blocks[0].Lsr)
+
+	stat := p.GetStat()
+	assert.Equal(t, uint32(1), stat.LostPackets)
+	assert.Equal(t, float64(1<<8/5)/256, stat.LossRate)
+	assert.Equal(t, -1, stat.Rtt)
+}
+
+func TestSrProducer(t *testing.T) {
+	p := rtprtcp.NewSrProducer(90000)
+	now := time.Now()
+	assert.Equal(t, true, p.Produce(now) == nil)
+
+	h := rtprtcp.RtpHeader{Version: rtprtcp.DefaultRtpVersion, Seq: 1, Timestamp: 9000, Ssrc: 200}
+	p.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 100)))
+	b := p.Produce(now)
+	h2 := rtprtcp.ParseRtcpHeader(b)
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeSr), h2.PacketType)
+	assert.Equal(t, len(b), (int(h2.Length)+1)*4)
+	sr := rtprtcp.ParseSr(b)
+	assert.Equal(t, uint32(200), sr.SenderSsrc)
+	assert.Equal(t, uint32(1), sr.PktCnt)
+	assert.Equal(t, uint32(100), sr.OctetCnt)
+
+	// 对端收到sr后立即回复rr（dlsr为0），100毫秒后收到rr
+	rtt := rtprtcp.CalcRttMs(sr.GetMiddleNtp(), 0, now.Add(100*time.Millisecond))
+	assert.Equal(t, true, rtt >= 99 && rtt <= 100)
+	assert.Equal(t, -1, rtprtcp.CalcRttMs(0, 0, now))
+}
+
+func TestParseNack(t *testing.T) {
+	b := []byte{
+		0x81, 0xcd, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x0a, 0x00, 0x05, // pid=10, blp=0b101
+	}
+	mediaSsrc, seqs, err := rtprtcp.ParseNack(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(2), mediaSsrc)
+	assert.Equal(t, []uint16{10, 11, 13}, seqs)
+
+	_, _, err = rtprtcp.ParseNack(b[:8])
+	assert.IsNotNil(t, err)
+}
+
+func TestPackNack(t *testing.T) {
+	b := rtprtcp.PackNack(1, 2, []uint16{10, 11, 13, 10, 65535, 40})
+	mediaSsrc, seqs, err := rtprtcp.ParseNack(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(2), mediaSsrc)
+	assert.Equal(t, []uint16{10, 11, 13, 65535, 40}, seqs)
+	assert.Equal(t, rtprtcp.RtcpFbMinLength+3*4, len(b))
+
+	h := rtprtcp.ParseRtcpHeader(b)
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeRtpfb), h.PacketType)
+	assert.Equal(t, uint8(rtprtcp.RtcpFormatNack), h.CountOrFormat)
+}
+
+func TestRtpPacketCache(t *testing.T) {
+	c := rtprtcp.NewRtpPacketCache(4)
+	for seq := uint16(65533); seq != 3; seq++ {
+		c.Put(rtprtcp.MakeRtpPacket(rtprtcp.RtpHeader{Seq: seq}, []byte{byte(seq)}))
+	}
+	_, ok := c.Get(65533)
+	assert.Equal(t, false, ok)
+	pkt, ok := c.Get(65535)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint16(65535), pkt.Header.Seq)
+	_, ok = c.Get(2)
+	assert.Equal(t, true, ok)
+	_, ok = c.Get(3)
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,50 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+import "sync"
+
+// RtpPacketCache 缓存最近发送的rtp包，收到对端的NACK时，用于重传
+//
+// 按seq取模作为下标的环形缓冲区，并发安全
+//
+type RtpPacketCache struct {
+	mu      sync.Mutex
+	packets []RtpPacket
+	valid   []bool
+}
+
+// NewRtpPacketCache @param size 最多缓存的包数量
+//
+func NewRtpPacketCache(size int) *RtpPacketCache {
+	return &RtpPacketCache{
+		packets: make([]RtpPacket, size),
+		valid:   make([]bool, size),
+	}
+}
+
+// Put 注意，内部只持有pkt.Raw的引用，不拷贝内存，调用方不应该再修改pkt.Raw
+//
+func (c *RtpPacketCache) Put(pkt RtpPacket) {
+	idx := int(pkt.Header.Seq) % len(c.packets)
+	c.mu.Lock()
+	c.packets[idx] = pkt
+	c.valid[idx] = true
+	c.mu.Unlock()
+}
+
+func (c *RtpPacketCache) Get(seq uint16) (RtpPacket, bool) {
+	idx := int(seq) % len(c.packets)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid[idx] || c.packets[idx].Header.Seq != seq {
+		return RtpPacket{}, false
+	}
+	return c.packets[idx], true
+}
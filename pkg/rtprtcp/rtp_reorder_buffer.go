@@ -0,0 +1,223 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+import "time"
+
+// RtpReorderBuffer 接收端的rtp包重排序缓冲区，用于udp等不可靠传输的输入
+//
+// - 乱序到达的包按seq重新排序后输出
+// - 缺失的包最多等待`delayMs`（期间可以通过 Missing 获取需要请求重传的seq），超时后跳过
+// - 缓冲区中的包数量超过`windowSize`时，不再等待，直接跳过缺失的包
+//
+// 非并发安全
+//
+type RtpReorderBuffer struct {
+	delay time.Duration
+	slots []reorderSlot
+
+	inited  bool
+	head    int    // nextSeq所在的slot下标
+	nextSeq uint16 // 下一个需要输出的seq
+	maxSeq  uint16 // 收到过的最大seq
+	count   int    // 缓冲区中的包数量
+
+	ready []RtpPacket
+	stat  RtpReorderBufferStat
+}
+
+type RtpReorderBufferStat struct {
+	Received  uint64 // 收到的包数量，包含重复、过期的包
+	Duplicate uint64 // 重复的包
+	Late      uint64 // 到达时已经被跳过（输出）的包
+	Lost      uint64 // 等待超时，最终跳过的包
+	Recovered uint64 // 请求重传后收到的包
+}
+
+type reorderSlot struct {
+	valid  bool
+	pkt    RtpPacket
+	arrive time.Time
+
+	nackSeq  uint16
+	nackTime time.Time
+}
+
+// NewRtpReorderBuffer
+//
+// @param delayMs:    缺失的包最多等待的时长，单位毫秒
+// @param windowSize: 缓冲区最多缓存的包数量
+//
+func NewRtpReorderBuffer(delayMs int, windowSize int) *RtpReorderBuffer {
+	if windowSize <= 0 {
+		windowSize = 1
+	}
+	return &RtpReorderBuffer{
+		delay: time.Duration(delayMs) * time.Millisecond,
+		slots: make([]reorderSlot, windowSize),
+	}
+}
+
+// Push 注意，内部持有pkt.Raw的引用，调用方不应该再修改
+//
+func (b *RtpReorderBuffer) Push(pkt RtpPacket, now time.Time) {
+	b.stat.Received++
+	seq := pkt.Header.Seq
+	if !b.inited {
+		b.inited = true
+		b.nextSeq = seq
+		b.maxSeq = seq
+	}
+
+	diff := SubSeq(seq, b.nextSeq)
+	if diff < -len(b.slots) || diff >= 2*len(b.slots) {
+		// seq跳变，比如对端重启，输出缓冲区中已有的包后重新开始
+		b.reset(seq)
+		diff = 0
+	}
+	if diff < 0 {
+		b.stat.Late++
+		return
+	}
+	// 超出窗口，强制跳过前面的包，腾出位置
+	for SubSeq(seq, b.nextSeq) >= len(b.slots) {
+		b.skip()
+	}
+
+	slot := &b.slots[b.index(seq)]
+	if slot.valid {
+		b.stat.Duplicate++
+		return
+	}
+	if !slot.nackTime.IsZero() && slot.nackSeq == seq {
+		b.stat.Recovered++
+	}
+	*slot = reorderSlot{valid: true, pkt: pkt, arrive: now}
+	b.count++
+	if SubSeq(seq, b.maxSeq) > 0 {
+		b.maxSeq = seq
+	}
+}
+
+// Pop 返回按seq排序后，可以输出的包
+//
+func (b *RtpReorderBuffer) Pop(now time.Time) (out []RtpPacket) {
+	out = b.ready
+	b.ready = nil
+	for b.count > 0 {
+		slot := &b.slots[b.index(b.nextSeq)]
+		if slot.valid {
+			out = append(out, slot.pkt)
+			b.release(slot)
+			b.advance()
+			continue
+		}
+
+		// 下一个包缺失，最早到达的后续包等待超时，或者缓冲区已满时跳过
+		oldest := b.oldestArrive()
+		if now.Sub(oldest) < b.delay && b.count < len(b.slots) {
+			break
+		}
+		b.stat.Lost++
+		b.advance()
+	}
+	return out
+}
+
+// Flush 输出缓冲区中剩余的所有包，缺失的包直接跳过，用于输入结束时
+//
+func (b *RtpReorderBuffer) Flush() (out []RtpPacket) {
+	for b.count > 0 {
+		b.skip()
+	}
+	out = b.ready
+	b.ready = nil
+	return out
+}
+
+// Missing 返回当前缺失，需要请求重传的seq，同一个seq距离上次请求超过`interval`才会再次返回
+//
+func (b *RtpReorderBuffer) Missing(now time.Time, interval time.Duration) (seqs []uint16) {
+	if b.count == 0 {
+		return nil
+	}
+	for seq := b.nextSeq; seq != b.maxSeq; seq++ {
+		slot := &b.slots[b.index(seq)]
+		if slot.valid {
+			continue
+		}
+		if slot.nackSeq == seq && !slot.nackTime.IsZero() && now.Sub(slot.nackTime) < interval {
+			continue
+		}
+		slot.nackSeq = seq
+		slot.nackTime = now
+		seqs = append(seqs, seq)
+	}
+	return
+}
+
+func (b *RtpReorderBuffer) Stat() RtpReorderBufferStat {
+	return b.stat
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// index 注意，`seq`不能小于nextSeq
+func (b *RtpReorderBuffer) index(seq uint16) int {
+	return (b.head + SubSeq(seq, b.nextSeq)) % len(b.slots)
+}
+
+func (b *RtpReorderBuffer) advance() {
+	b.nextSeq++
+	b.head = (b.head + 1) % len(b.slots)
+}
+
+func (b *RtpReorderBuffer) reset(seq uint16) {
+	for b.count > 0 {
+		b.skip()
+	}
+	for i := range b.slots {
+		b.slots[i] = reorderSlot{}
+	}
+	b.head = 0
+	b.nextSeq = seq
+	b.maxSeq = seq
+}
+
+// skip 输出或跳过nextSeq
+func (b *RtpReorderBuffer) skip() {
+	slot := &b.slots[b.index(b.nextSeq)]
+	if slot.valid {
+		b.ready = append(b.ready, slot.pkt)
+		b.release(slot)
+	} else {
+		b.stat.Lost++
+	}
+	b.advance()
+}
+
+func (b *RtpReorderBuffer) release(slot *reorderSlot) {
+	*slot = reorderSlot{}
+	b.count--
+}
+
+func (b *RtpReorderBuffer) oldestArrive() (oldest time.Time) {
+	n := 0
+	for seq := b.nextSeq; n < b.count; seq++ {
+		slot := &b.slots[b.index(seq)]
+		if !slot.valid {
+			continue
+		}
+		n++
+		if oldest.IsZero() || slot.arrive.Before(oldest) {
+			oldest = slot.arrive
+		}
+	}
+	return
+}
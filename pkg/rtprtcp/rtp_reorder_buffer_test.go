@@ -0,0 +1,74 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRtpReorderBuffer(t *testing.T) {
+	seqsOf := func(pkts []rtprtcp.RtpPacket) (ret []uint16) {
+		for _, pkt := range pkts {
+			ret = append(ret, pkt.Header.Seq)
+		}
+		return
+	}
+	pkt := func(seq uint16) rtprtcp.RtpPacket {
+		return rtprtcp.MakeRtpPacket(rtprtcp.RtpHeader{Seq: seq}, []byte{byte(seq)})
+	}
+
+	now := time.Now()
+	b := rtprtcp.NewRtpReorderBuffer(100, 6)
+
+	// 乱序，包含序号翻转
+	b.Push(pkt(65534), now)
+	b.Push(pkt(0), now)
+	assert.Equal(t, []uint16{65534}, seqsOf(b.Pop(now)))
+	assert.Equal(t, []uint16{65535}, b.Missing(now, 20*time.Millisecond))
+	assert.Equal(t, 0, len(b.Missing(now.Add(10*time.Millisecond), 20*time.Millisecond)))
+	b.Push(pkt(65535), now)
+	b.Push(pkt(65535), now)
+	assert.Equal(t, []uint16{65535, 0}, seqsOf(b.Pop(now)))
+
+	// 过期的包
+	b.Push(pkt(65533), now)
+
+	// 等待超时后跳过
+	b.Push(pkt(3), now)
+	assert.Equal(t, 0, len(b.Pop(now.Add(50*time.Millisecond))))
+	assert.Equal(t, []uint16{3}, seqsOf(b.Pop(now.Add(100*time.Millisecond))))
+
+	// 超出窗口，强制跳过
+	for seq := uint16(5); seq < 12; seq++ {
+		b.Push(pkt(seq), now)
+	}
+	assert.Equal(t, []uint16{5, 6, 7, 8, 9, 10, 11}, seqsOf(b.Pop(now)))
+
+	// seq跳变
+	b.Push(pkt(30000), now)
+	assert.Equal(t, []uint16{30000}, seqsOf(b.Pop(now)))
+
+	// 输入结束时，不再等待缺失的包
+	b.Push(pkt(30002), now)
+	b.Push(pkt(30004), now)
+	assert.Equal(t, 0, len(b.Pop(now)))
+	assert.Equal(t, []uint16{30002, 30004}, seqsOf(b.Flush()))
+	assert.Equal(t, 0, len(b.Flush()))
+
+	stat := b.Stat()
+	assert.Equal(t, uint64(16), stat.Received)
+	assert.Equal(t, uint64(1), stat.Duplicate)
+	assert.Equal(t, uint64(1), stat.Late)
+	assert.Equal(t, uint64(5), stat.Lost)
+	assert.Equal(t, uint64(1), stat.Recovered)
+}
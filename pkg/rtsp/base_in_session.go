@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaatomic"
 
@@ -76,6 +77,16 @@ type BaseInSession struct {
 	audioUnpacker rtprtcp.IRtpUnpacker
 	videoUnpacker rtprtcp.IRtpUnpacker
 
+	// rtp over udp时的抖动缓冲，为nil表示不开启，见 SetJitterBuffer
+	jitterBufferMs    int
+	audioJitterBuffer *rtprtcp.RtpReorderBuffer
+	videoJitterBuffer *rtprtcp.RtpReorderBuffer
+	// 读udp的协程和定时检查的协程都会从抖动缓冲输出，使用jitterMu保证按顺序交给unpacker
+	jitterMu        sync.Mutex
+	jitterDrainOnce sync.Once
+	jitterTimer     *base.WheelTimer
+	jitterExitChan  chan struct{}
+
 	audioSsrc nazaatomic.Uint32
 	videoSsrc nazaatomic.Uint32
 
@@ -98,6 +109,7 @@ func NewBaseInSession(uniqueKey string, cmdSession IInterleavedPacketWriter) *Ba
 		},
 		cmdSession:       cmdSession,
 		waitChan:         make(chan error, 1),
+		jitterExitChan:   make(chan struct{}),
 		dumpReadAudioRtp: base.NewLogDump(Log, 1),
 		dumpReadVideoRtp: base.NewLogDump(Log, 1),
 		dumpReadSr:       base.NewLogDump(Log, 2),
@@ -153,16 +165,38 @@ func (session *BaseInSession) SetObserver(observer IBaseInSessionObserver) {
 	}()
 }
 
+// SetJitterBuffer 开启rtp over udp输入的抖动缓冲，调用方保证调用该函数发生在调用SetupWithConn之前
+//
+// 乱序到达的rtp包按seq重新排序后再解析，缺失的包最多等待`delayMs`，超时后跳过。
+// 对端暂停或者停止推流时，缓冲区中的包也会在超时后输出，session关闭时输出剩余的所有包。
+// interleaved模式（rtp over tcp）不使用抖动缓冲。
+//
+// @param delayMs: 为0表示不开启
+//
+func (session *BaseInSession) SetJitterBuffer(delayMs int) {
+	session.jitterBufferMs = delayMs
+}
+
 func (session *BaseInSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
+	var jitterBuffer *rtprtcp.RtpReorderBuffer
+	if session.jitterBufferMs > 0 {
+		jitterBuffer = rtprtcp.NewRtpReorderBuffer(session.jitterBufferMs, jitterBufferWindowSize)
+	}
+
 	if session.sdpCtx.IsAudioUri(uri) {
 		session.audioRtpConn = rtpConn
 		session.audioRtcpConn = rtcpConn
+		session.audioJitterBuffer = jitterBuffer
 	} else if session.sdpCtx.IsVideoUri(uri) {
 		session.videoRtpConn = rtpConn
 		session.videoRtcpConn = rtcpConn
+		session.videoJitterBuffer = jitterBuffer
 	} else {
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
+	if jitterBuffer != nil {
+		session.startJitterBufferDrain()
+	}
 
 	go rtpConn.RunLoop(session.onReadRtpPacket)
 	go rtcpConn.RunLoop(session.onReadRtcpPacket)
@@ -243,6 +277,19 @@ func (session *BaseInSession) WriteRtpRtcpDummy() {
 func (session *BaseInSession) GetStat() base.StatSession {
 	session.stat.ReadBytesSum = session.currConnStat.ReadBytesSum.Load()
 	session.stat.WroteBytesSum = session.currConnStat.WroteBytesSum.Load()
+
+	// 有视频时使用视频的统计，否则使用音频的统计
+	session.mu.Lock()
+	producer := session.audioRrProducer
+	if session.videoSsrc.Load() != 0 {
+		producer = session.videoRrProducer
+	}
+	if producer != nil {
+		stat := producer.GetStat()
+		session.stat.Rtcp = &stat
+	}
+	session.stat.JitterBuffer = session.getJitterBufferStat()
+	session.mu.Unlock()
 	return session.stat
 }
 
@@ -404,8 +451,100 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 	pkt.Header = h
 	pkt.Raw = b
 
+	isAudio := session.sdpCtx.IsAudioPayloadTypeOrigin(packetType)
+	jitterBuffer := session.videoJitterBuffer
+	if isAudio {
+		jitterBuffer = session.audioJitterBuffer
+	}
+	if jitterBuffer == nil {
+		session.feedRtpPacket(pkt, isAudio)
+		return nil
+	}
+
+	// 注意，udp连接的读缓冲会被复用，需要拷贝后再放入抖动缓冲
+	pkt.Raw = append([]byte(nil), b...)
+	now := time.Now()
+	session.jitterMu.Lock()
+	defer session.jitterMu.Unlock()
+	session.mu.Lock()
+	jitterBuffer.Push(pkt, now)
+	pkts := jitterBuffer.Pop(now)
+	session.mu.Unlock()
+	for _, p := range pkts {
+		session.feedRtpPacket(p, isAudio)
+	}
+	return nil
+}
+
+// startJitterBufferDrain 使用共用的时间轮定时检查抖动缓冲，音频和视频共用一个定时任务
+//
+// 注意，不在时间轮的协程中输出，因为回调上层时可能阻塞，而时间轮的回调不能阻塞
+//
+func (session *BaseInSession) startJitterBufferDrain() {
+	session.jitterDrainOnce.Do(func() {
+		tickChan := make(chan struct{}, 1)
+		t := base.DefaultTimerWheel().Every(jitterBufferDrainInterval, func() {
+			select {
+			case tickChan <- struct{}{}:
+			default:
+			}
+		})
+		session.mu.Lock()
+		session.jitterTimer = t
+		session.mu.Unlock()
+		go session.runJitterBufferDrainLoop(tickChan)
+	})
+}
+
+// runJitterBufferDrainLoop session关闭后输出缓冲区中剩余的所有包，然后退出
+//
+// 注意，不在dispose中直接输出，因为上层可能持有自身的锁调用Dispose，而输出时会回调上层
+//
+func (session *BaseInSession) runJitterBufferDrainLoop(tickChan <-chan struct{}) {
+	for {
+		select {
+		case <-tickChan:
+			session.drainJitterBuffers(false)
+		case <-session.jitterExitChan:
+			session.drainJitterBuffers(true)
+			return
+		}
+	}
+}
+
+// drainJitterBuffers 输出抖动缓冲中等待超时的包，`flush`为true时输出所有的包
+func (session *BaseInSession) drainJitterBuffers(flush bool) {
+	session.jitterMu.Lock()
+	defer session.jitterMu.Unlock()
+	now := time.Now()
+	for _, isAudio := range []bool{true, false} {
+		jitterBuffer := session.videoJitterBuffer
+		if isAudio {
+			jitterBuffer = session.audioJitterBuffer
+		}
+		if jitterBuffer == nil {
+			continue
+		}
+		session.mu.Lock()
+		var pkts []rtprtcp.RtpPacket
+		if flush {
+			pkts = jitterBuffer.Flush()
+		} else {
+			pkts = jitterBuffer.Pop(now)
+		}
+		session.mu.Unlock()
+		for _, p := range pkts {
+			session.feedRtpPacket(p, isAudio)
+		}
+	}
+}
+
+func (session *BaseInSession) feedRtpPacket(pkt rtprtcp.RtpPacket, isAudio bool) {
+	h := pkt.Header
+	b := pkt.Raw
+
 	// 接收数据时，保证了sdp的原始类型对应
-	if session.sdpCtx.IsAudioPayloadTypeOrigin(packetType) {
+	if isAudio {
 		if session.dumpReadAudioRtp.ShouldDump() {
 			session.dumpReadAudioRtp.Outf("[%s] READ_RTP. audio, h=%+v, len=%d, hex=%s",
 				session.uniqueKey, h, len(b), hex.Dump(nazabytes.Prefix(b, 32)))
@@ -414,13 +553,13 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 		session.audioSsrc.Store(h.Ssrc)
 		session.observer.OnRtpPacket(pkt)
 		session.mu.Lock()
-		session.audioRrProducer.FeedRtpPacket(h.Seq)
+		session.audioRrProducer.FeedRtpPacket(h)
 		session.mu.Unlock()
 
 		if session.audioUnpacker != nil {
 			session.audioUnpacker.Feed(pkt)
 		}
-	} else if session.sdpCtx.IsVideoPayloadTypeOrigin(packetType) {
+	} else {
 		if session.dumpReadVideoRtp.ShouldDump() {
 			session.dumpReadVideoRtp.Outf("[%s] READ_RTP. video, h=%+v, len=%d, hex=%s",
 				session.uniqueKey, h, len(b), hex.Dump(nazabytes.Prefix(b, 32)))
@@ -429,17 +568,34 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 		session.videoSsrc.Store(h.Ssrc)
 		session.observer.OnRtpPacket(pkt)
 		session.mu.Lock()
-		session.videoRrProducer.FeedRtpPacket(h.Seq)
+		session.videoRrProducer.FeedRtpPacket(h)
 		session.mu.Unlock()
 
 		if session.videoUnpacker != nil {
 			session.videoUnpacker.Feed(pkt)
 		}
-	} else {
-		// noop 因为前面已经判断过type了，所以永远不会走到这
 	}
+}
 
-	return nil
+// getJitterBufferStat 注意，调用方需要持有mu锁
+func (session *BaseInSession) getJitterBufferStat() *base.StatJitterBuffer {
+	if session.audioJitterBuffer == nil && session.videoJitterBuffer == nil {
+		return nil
+	}
+	ret := &base.StatJitterBuffer{
+		DelayMs: session.jitterBufferMs,
+	}
+	for _, jb := range []*rtprtcp.RtpReorderBuffer{session.audioJitterBuffer, session.videoJitterBuffer} {
+		if jb == nil {
+			continue
+		}
+		stat := jb.Stat()
+		ret.Received += stat.Received
+		ret.Duplicate += stat.Duplicate
+		ret.Late += stat.Late
+		ret.Lost += stat.Lost
+	}
+	return ret
 }
 
 func (session *BaseInSession) dispose(err error) error {
@@ -460,6 +616,16 @@ func (session *BaseInSession) dispose(err error) error {
 			e4 = session.videoRtcpConn.Dispose()
 		}
 
+		session.mu.Lock()
+		if session.jitterTimer != nil {
+			session.jitterTimer.Stop()
+		}
+		if stat := session.getJitterBufferStat(); stat != nil {
+			Log.Infof("[%s] jitter buffer stat. stat=%+v", session.uniqueKey, *stat)
+		}
+		session.mu.Unlock()
+		close(session.jitterExitChan)
+
 		session.waitChan <- nil
 
 		retErr = nazaerrors.CombineErrors(e1, e2, e3, e4)
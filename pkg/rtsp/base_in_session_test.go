@@ -0,0 +1,123 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testInSessionObserver struct {
+	mutex sync.Mutex
+	seqs  []uint16
+}
+
+func (o *testInSessionObserver) OnSdp(sdpCtx sdp.LogicContext) {
+}
+
+func (o *testInSessionObserver) OnRtpPacket(pkt rtprtcp.RtpPacket) {
+	// 从原始数据中读取seq，用于检查缓存的包是否被修改
+	o.mutex.Lock()
+	o.seqs = append(o.seqs, uint16(pkt.Raw[2])<<8|uint16(pkt.Raw[3]))
+	o.mutex.Unlock()
+}
+
+// waitSeqs 等待收到`n`个包，超时返回已经收到的包
+func (o *testInSessionObserver) waitSeqs(n int, timeout time.Duration) []uint16 {
+	deadline := time.Now().Add(timeout)
+	for {
+		o.mutex.Lock()
+		seqs := append([]uint16(nil), o.seqs...)
+		o.mutex.Unlock()
+		if len(seqs) >= n || time.Now().After(deadline) {
+			return seqs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (o *testInSessionObserver) OnAvPacket(pkt base.AvPacket) {
+}
+
+func newTestJitterBufferSession(t *testing.T, observer *testInSessionObserver, delayMs int) *BaseInSession {
+	sdpCtx, err := sdp.ParseSdp2LogicContext([]byte("v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=No Name\r\n" +
+		"t=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=control:streamid=0\r\n"))
+	assert.Equal(t, nil, err)
+
+	session := NewBaseInSessionWithObserver("test", nil, observer)
+	session.InitWithSdp(sdpCtx)
+	session.SetJitterBuffer(delayMs)
+	// 模拟SetupWithConn，不创建udp连接
+	session.videoJitterBuffer = rtprtcp.NewRtpReorderBuffer(session.jitterBufferMs, jitterBufferWindowSize)
+	return session
+}
+
+func testRtpPacket(seq uint16) []byte {
+	return []byte{0x80, 96, byte(seq >> 8), byte(seq), 0, 0, 0, 0, 0, 0, 0, 1, 0x65, 0x00}
+}
+
+func TestBaseInSession_JitterBuffer(t *testing.T) {
+	var observer testInSessionObserver
+	session := newTestJitterBufferSession(t, &observer, 1000)
+	packet := testRtpPacket
+
+	// 乱序的包按seq排序后输出
+	b := packet(1)
+	assert.Equal(t, nil, session.handleRtpPacket(b))
+	b[3] = 3 // 模拟udp连接复用读缓冲
+	assert.Equal(t, nil, session.handleRtpPacket(b))
+	b[3] = 9
+	assert.Equal(t, []uint16{1}, observer.seqs)
+	assert.Equal(t, nil, session.handleRtpPacket(packet(2)))
+	assert.Equal(t, []uint16{1, 2, 3}, observer.seqs)
+
+	// 重复以及迟到（已经输出过）的包被丢弃
+	assert.Equal(t, nil, session.handleRtpPacket(packet(5)))
+	assert.Equal(t, nil, session.handleRtpPacket(packet(5)))
+	assert.Equal(t, nil, session.handleRtpPacket(packet(2)))
+	assert.Equal(t, nil, session.handleRtpPacket(packet(4)))
+	assert.Equal(t, []uint16{1, 2, 3, 4, 5}, observer.seqs)
+
+	stat := session.GetStat().JitterBuffer
+	assert.Equal(t, base.StatJitterBuffer{DelayMs: 1000, Received: 7, Duplicate: 1, Late: 1}, *stat)
+}
+
+// 没有新的包到达时，缺失的包等待超时后也会输出，session关闭时输出剩余的所有包
+func TestBaseInSession_JitterBufferDrain(t *testing.T) {
+	var observer testInSessionObserver
+	session := newTestJitterBufferSession(t, &observer, 200)
+	session.startJitterBufferDrain()
+
+	assert.Equal(t, nil, session.handleRtpPacket(testRtpPacket(1)))
+	assert.Equal(t, nil, session.handleRtpPacket(testRtpPacket(3)))
+	assert.Equal(t, []uint16{1}, observer.waitSeqs(1, 0))
+	assert.Equal(t, []uint16{1, 3}, observer.waitSeqs(2, 2*time.Second))
+	assert.Equal(t, nil, session.Dispose())
+
+	var observer2 testInSessionObserver
+	session = newTestJitterBufferSession(t, &observer2, 60*1000)
+	session.startJitterBufferDrain()
+	assert.Equal(t, nil, session.handleRtpPacket(testRtpPacket(1)))
+	assert.Equal(t, nil, session.handleRtpPacket(testRtpPacket(3)))
+	assert.Equal(t, nil, session.handleRtpPacket(testRtpPacket(5)))
+	assert.Equal(t, []uint16{1}, observer2.waitSeqs(1, 0))
+	assert.Equal(t, nil, session.Dispose())
+	assert.Equal(t, []uint16{1, 3, 5}, observer2.waitSeqs(3, 2*time.Second))
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaatomic"
 
@@ -42,6 +43,39 @@ type BaseOutSession struct {
 	videoRtpChannel  int
 	videoRtcpChannel int
 
+	// 对称rtp（symmetric rtp），用于穿越对端的NAT
+	// 对端在NAT后面时，SETUP中的client_port是对端的内网端口，我们发送的数据无法到达对端。
+	// 所以，如果收到了对端发送过来的rtp或rtcp包（比如打洞包或rtcp rr），则后续使用收到包的源地址发送数据
+	//
+	// 为了避免被第三方伪造的包劫持，只接受源IP和rtsp信令连接对端IP相同的包，并且每次SETUP后每个地址最多更新一次
+	//
+	mu               sync.Mutex
+	peerIp           net.IP
+	audioRtpRAddr    *net.UDPAddr
+	videoRtpRAddr    *net.UDPAddr
+	audioRtcpRAddr   *net.UDPAddr
+	videoRtcpRAddr   *net.UDPAddr
+	audioRtpLatched  bool
+	videoRtpLatched  bool
+	audioRtcpLatched bool
+	videoRtcpLatched bool
+
+	// 对端最近一次发送的rtcp rr中的report block，以及通过rr计算出的rtt
+	audioRr  rtprtcp.ReportBlock
+	videoRr  rtprtcp.ReportBlock
+	audioRtt int
+	videoRtt int
+
+	// 只在WriteRtpPacket中使用，不需要加锁
+	audioSrProducer *rtprtcp.SrProducer
+	videoSrProducer *rtprtcp.SrProducer
+	audioLastSrTime time.Time
+	videoLastSrTime time.Time
+
+	// 用于NACK重传，只有udp模式才创建
+	audioRtpCache *rtprtcp.RtpPacketCache
+	videoRtpCache *rtprtcp.RtpPacketCache
+
 	stat         base.StatSession
 	currConnStat connection.StatAtomic
 	prevConnStat connection.Stat
@@ -69,6 +103,10 @@ func NewBaseOutSession(uniqueKey string, cmdSession IInterleavedPacketWriter) *B
 		},
 		audioRtpChannel:  -1,
 		videoRtpChannel:  -1,
+		audioRtcpChannel: -1,
+		videoRtcpChannel: -1,
+		audioRtt:         -1,
+		videoRtt:         -1,
 		debugLogMaxCount: 3,
 		waitChan:         make(chan error, 1),
 	}
@@ -78,21 +116,56 @@ func NewBaseOutSession(uniqueKey string, cmdSession IInterleavedPacketWriter) *B
 
 func (session *BaseOutSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 	session.sdpCtx = sdpCtx
+	session.audioSrProducer = rtprtcp.NewSrProducer(sdpCtx.AudioClockRate)
+	session.videoSrProducer = rtprtcp.NewSrProducer(sdpCtx.VideoClockRate)
+}
+
+// SetPeerAddr 设置rtsp信令连接对端的地址，需要在 SetupWithConn 之前调用，用于校验对称rtp的源地址
+//
+// @param addr: 格式为`ip:port`
+//
+func (session *BaseOutSession) SetPeerAddr(addr string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	session.mu.Lock()
+	session.peerIp = net.ParseIP(host)
+	session.mu.Unlock()
 }
 
 func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
+	var isAudio bool
 	if session.sdpCtx.IsAudioUri(uri) {
 		session.audioRtpConn = rtpConn
 		session.audioRtcpConn = rtcpConn
+		session.audioRtpCache = rtprtcp.NewRtpPacketCache(rtpNackCacheSize)
+		isAudio = true
 	} else if session.sdpCtx.IsVideoUri(uri) {
 		session.videoRtpConn = rtpConn
 		session.videoRtcpConn = rtcpConn
+		session.videoRtpCache = rtprtcp.NewRtpPacketCache(rtpNackCacheSize)
 	} else {
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
 
-	go rtpConn.RunLoop(session.onReadRtpPacket)
-	go rtcpConn.RunLoop(session.onReadRtcpPacket)
+	// 新的SETUP，重新允许更新对称rtp的地址
+	session.mu.Lock()
+	if isAudio {
+		session.audioRtpRAddr, session.audioRtcpRAddr = nil, nil
+		session.audioRtpLatched, session.audioRtcpLatched = false, false
+	} else {
+		session.videoRtpRAddr, session.videoRtcpRAddr = nil, nil
+		session.videoRtpLatched, session.videoRtcpLatched = false, false
+	}
+	session.mu.Unlock()
+
+	go rtpConn.RunLoop(func(b []byte, rAddr *net.UDPAddr, err error) bool {
+		return session.onReadRtpPacket(b, rAddr, err, isAudio)
+	})
+	go rtcpConn.RunLoop(func(b []byte, rAddr *net.UDPAddr, err error) bool {
+		return session.onReadRtcpPacket(b, rAddr, err, isAudio)
+	})
 
 	return nil
 }
@@ -138,9 +211,9 @@ func (session *BaseOutSession) HandleInterleavedPacket(b []byte, channel int) {
 	case session.videoRtpChannel:
 		Log.Warnf("[%s] not supposed to read packet in rtp channel of BaseOutSession. channel=%d, len=%d", session.uniqueKey, channel, len(b))
 	case session.audioRtcpChannel:
-		fallthrough
+		session.handleRtcpPacket(b, true)
 	case session.videoRtcpChannel:
-		Log.Debugf("[%s] read interleaved rtcp packet. b=%s", session.uniqueKey, hex.Dump(nazabytes.Prefix(b, 32)))
+		session.handleRtcpPacket(b, false)
 	default:
 		Log.Errorf("[%s] read interleaved packet but channel invalid. channel=%d", session.uniqueKey, channel)
 	}
@@ -158,11 +231,15 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		}
 
 		if session.audioRtpConn != nil {
-			err = session.audioRtpConn.Write(packet.Raw)
+			session.audioRtpCache.Put(packet)
+			err = session.writeUdpPacket(session.audioRtpConn, &session.audioRtpRAddr, packet.Raw)
 		}
 		if session.audioRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.audioRtpChannel)
 		}
+		if err == nil {
+			session.feedAndSendSr(session.audioSrProducer, &session.audioLastSrTime, session.audioRtcpConn, &session.audioRtcpRAddr, session.audioRtcpChannel, packet)
+		}
 	} else if session.sdpCtx.IsVideoPayloadTypeOrigin(t) {
 		if session.loggedWriteVideoRtpCount < session.debugLogMaxCount {
 			Log.Debugf("[%s] LOGPACKET. write video rtp=%+v", session.uniqueKey, packet.Header)
@@ -170,11 +247,15 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		}
 
 		if session.videoRtpConn != nil {
-			err = session.videoRtpConn.Write(packet.Raw)
+			session.videoRtpCache.Put(packet)
+			err = session.writeUdpPacket(session.videoRtpConn, &session.videoRtpRAddr, packet.Raw)
 		}
 		if session.videoRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.videoRtpChannel)
 		}
+		if err == nil {
+			session.feedAndSendSr(session.videoSrProducer, &session.videoLastSrTime, session.videoRtcpConn, &session.videoRtcpRAddr, session.videoRtcpChannel, packet)
+		}
 	} else {
 		Log.Errorf("[%s] write rtp packet but type invalid. type=%d", session.uniqueKey, t)
 		err = nazaerrors.Wrap(base.ErrRtsp)
@@ -189,6 +270,15 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 func (session *BaseOutSession) GetStat() base.StatSession {
 	session.stat.ReadBytesSum = session.currConnStat.ReadBytesSum.Load()
 	session.stat.WroteBytesSum = session.currConnStat.WroteBytesSum.Load()
+
+	// 有视频的rr时使用视频的统计，否则使用音频的统计
+	session.mu.Lock()
+	if session.videoRr.MediaSsrc != 0 {
+		session.stat.Rtcp = makeStatRtcp(session.videoRr, session.videoRtt, session.sdpCtx.VideoClockRate)
+	} else if session.audioRr.MediaSsrc != 0 {
+		session.stat.Rtcp = makeStatRtcp(session.audioRr, session.audioRtt, session.sdpCtx.AudioClockRate)
+	}
+	session.mu.Unlock()
 	return session.stat
 }
 
@@ -225,24 +315,196 @@ func (session *BaseOutSession) UniqueKey() string {
 	return session.uniqueKey
 }
 
-func (session *BaseOutSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
+// GetReceiverReport 获取对端最近一次发送的rtcp rr信息
+//
+func (session *BaseOutSession) GetReceiverReport() (audio, video rtprtcp.ReportBlock) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.audioRr, session.videoRr
+}
+
+func (session *BaseOutSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err error, isAudio bool) bool {
+	if err != nil {
+		// conn被关闭时，也会走到这里，此时返回值不重要，RunLoop会退出
+		return false
+	}
+
 	if session.loggedReadRtpCount.Load() < int32(session.debugLogMaxCount) {
 		Log.Debugf("[%s] LOGPACKET. read rtp=%s", session.uniqueKey, hex.Dump(nazabytes.Prefix(b, 32)))
 		session.loggedReadRtpCount.Increment()
 	}
+
+	session.currConnStat.ReadBytesSum.Add(uint64(len(b)))
+	if isAudio {
+		session.updateRAddr(&session.audioRtpRAddr, &session.audioRtpLatched, rAddr, "audio rtp")
+	} else {
+		session.updateRAddr(&session.videoRtpRAddr, &session.videoRtpLatched, rAddr, "video rtp")
+	}
 	return true
 }
 
-func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
-	// TODO chef: impl me
+func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, err error, isAudio bool) bool {
+	if err != nil {
+		return false
+	}
 
 	if session.loggedReadRtcpCount.Load() < int32(session.debugLogMaxCount) {
 		Log.Debugf("[%s] LOGPACKET. read rtcp=%s", session.uniqueKey, hex.Dump(nazabytes.Prefix(b, 32)))
 		session.loggedReadRtcpCount.Increment()
 	}
+
+	if isAudio {
+		session.updateRAddr(&session.audioRtcpRAddr, &session.audioRtcpLatched, rAddr, "audio rtcp")
+	} else {
+		session.updateRAddr(&session.videoRtcpRAddr, &session.videoRtcpLatched, rAddr, "video rtcp")
+	}
+	session.handleRtcpPacket(b, isAudio)
 	return true
 }
 
+func (session *BaseOutSession) handleRtcpPacket(b []byte, isAudio bool) {
+	session.currConnStat.ReadBytesSum.Add(uint64(len(b)))
+
+	packets, err := rtprtcp.SplitCompoundRtcp(b)
+	if err != nil {
+		Log.Warnf("[%s] split rtcp packet failed. err=%+v, len=%d", session.uniqueKey, err, len(b))
+	}
+	for _, packet := range packets {
+		if packet[1] == rtprtcp.RtcpPacketTypeRtpfb && packet[0]&0x1F == rtprtcp.RtcpFormatNack {
+			session.handleNack(packet, isAudio)
+			continue
+		}
+		if packet[1] != rtprtcp.RtcpPacketTypeRr {
+			// 比如SDES、BYE等，忽略
+			continue
+		}
+		rr, err := rtprtcp.ParseRr(packet)
+		if err != nil {
+			Log.Warnf("[%s] parse rtcp rr failed. err=%+v, len=%d", session.uniqueKey, err, len(packet))
+			continue
+		}
+		if len(rr.Blocks) == 0 {
+			continue
+		}
+		rb := rr.Blocks[0]
+		rtt := rtprtcp.CalcRttMs(rb.Lsr, rb.Dlsr, time.Now())
+		session.mu.Lock()
+		if isAudio {
+			session.audioRr = rb
+			session.audioRtt = rtt
+		} else {
+			session.videoRr = rb
+			session.videoRtt = rtt
+		}
+		session.mu.Unlock()
+	}
+}
+
+// 收到对端的NACK，从缓存中找到对应的rtp包进行重传
+//
+// 注意，目前直接重传原始rtp包，不使用RTX（rfc4588）格式
+//
+func (session *BaseOutSession) handleNack(b []byte, isAudio bool) {
+	conn, cache, rAddr := session.videoRtpConn, session.videoRtpCache, &session.videoRtpRAddr
+	if isAudio {
+		conn, cache, rAddr = session.audioRtpConn, session.audioRtpCache, &session.audioRtpRAddr
+	}
+	if conn == nil || cache == nil {
+		// interleaved模式，tcp保证了可靠传输，忽略
+		return
+	}
+
+	_, seqs, err := rtprtcp.ParseNack(b)
+	if err != nil {
+		Log.Warnf("[%s] parse rtcp nack failed. err=%+v, len=%d", session.uniqueKey, err, len(b))
+		return
+	}
+
+	var retransmitted, missed int
+	for _, seq := range seqs {
+		pkt, ok := cache.Get(seq)
+		if !ok {
+			missed++
+			continue
+		}
+		if err := session.writeUdpPacket(conn, rAddr, pkt.Raw); err != nil {
+			continue
+		}
+		session.currConnStat.WroteBytesSum.Add(uint64(len(pkt.Raw)))
+		retransmitted++
+	}
+	Log.Debugf("[%s] handle nack. audio=%v, seqs=%v, retransmitted=%d, missed=%d",
+		session.uniqueKey, isAudio, seqs, retransmitted, missed)
+}
+
+// 如果对端发送数据的源地址和SETUP中的地址不同（对端在NAT后面），则使用源地址作为后续发送的目的地址
+//
+// 只接受源IP和rtsp信令连接对端IP相同的包，并且每次SETUP后最多更新一次
+//
+func (session *BaseOutSession) updateRAddr(dst **net.UDPAddr, latched *bool, rAddr *net.UDPAddr, name string) {
+	if rAddr == nil {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if *latched || session.peerIp == nil || !session.peerIp.Equal(rAddr.IP) {
+		return
+	}
+	*latched = true
+	Log.Infof("[%s] symmetric rtp, update remote addr. type=%s, addr=%s", session.uniqueKey, name, rAddr.String())
+	*dst = rAddr
+}
+
+// 每发送一个rtp包都调用，距离上次发送sr超过rtcpSrIntervalMs时，发送sr
+func (session *BaseOutSession) feedAndSendSr(producer *rtprtcp.SrProducer, lastSrTime *time.Time,
+	rtcpConn *nazanet.UdpConnection, rtcpRAddr **net.UDPAddr, rtcpChannel int, packet rtprtcp.RtpPacket) {
+
+	if producer == nil {
+		return
+	}
+	producer.FeedRtpPacket(packet)
+
+	now := time.Now()
+	if now.Sub(*lastSrTime) < time.Duration(rtcpSrIntervalMs)*time.Millisecond {
+		return
+	}
+	*lastSrTime = now
+	b := producer.Produce(now)
+	if b == nil {
+		return
+	}
+
+	var err error
+	if rtcpConn != nil {
+		err = session.writeUdpPacket(rtcpConn, rtcpRAddr, b)
+	}
+	if rtcpChannel != -1 {
+		err = session.cmdSession.WriteInterleavedPacket(b, rtcpChannel)
+	}
+	if err == nil {
+		session.currConnStat.WroteBytesSum.Add(uint64(len(b)))
+	}
+}
+
+func makeStatRtcp(rb rtprtcp.ReportBlock, rtt int, clockRate int) *base.StatRtcp {
+	return &base.StatRtcp{
+		Jitter:      rtprtcp.JitterToMs(rb.Jitter, clockRate),
+		Rtt:         rtt,
+		LossRate:    float64(rb.Fraction) / 256,
+		LostPackets: rb.Lost,
+	}
+}
+
+func (session *BaseOutSession) writeUdpPacket(conn *nazanet.UdpConnection, rAddr **net.UDPAddr, b []byte) error {
+	session.mu.Lock()
+	addr := *rAddr
+	session.mu.Unlock()
+	if addr != nil {
+		return conn.Write2Addr(b, addr)
+	}
+	return conn.Write(b)
+}
+
 func (session *BaseOutSession) dispose(err error) error {
 	var retErr error
 	session.disposeOnce.Do(func() {
@@ -0,0 +1,37 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestBaseOutSession_SymmetricRtp(t *testing.T) {
+	session := NewBaseOutSession("test", nil)
+	session.SetPeerAddr("192.168.1.100:554")
+
+	// 源IP和信令连接对端IP不同，忽略
+	session.updateRAddr(&session.videoRtpRAddr, &session.videoRtpLatched, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, "video rtp")
+	assert.Equal(t, true, session.videoRtpRAddr == nil)
+
+	session.updateRAddr(&session.videoRtpRAddr, &session.videoRtpLatched, &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 5000}, "video rtp")
+	assert.Equal(t, "192.168.1.100:5000", session.videoRtpRAddr.String())
+
+	// 每次SETUP后最多更新一次
+	session.updateRAddr(&session.videoRtpRAddr, &session.videoRtpLatched, &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 6000}, "video rtp")
+	assert.Equal(t, "192.168.1.100:5000", session.videoRtpRAddr.String())
+
+	// 没有设置对端地址时不更新
+	session = NewBaseOutSession("test", nil)
+	session.updateRAddr(&session.audioRtcpRAddr, &session.audioRtcpLatched, &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 5001}, "audio rtcp")
+	assert.Equal(t, true, session.audioRtcpRAddr == nil)
+}
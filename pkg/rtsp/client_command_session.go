@@ -42,11 +42,13 @@ const (
 type ClientCommandSessionOption struct {
 	DoTimeoutMs int
 	OverTcp     bool
+	ProxyUrl    string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp。注意，代理只作用于rtsp信令tcp连接，使用代理时应开启OverTcp
 }
 
 var defaultClientCommandSessionOption = ClientCommandSessionOption{
 	DoTimeoutMs: 10000,
 	OverTcp:     false,
+	ProxyUrl:    "",
 }
 
 type IClientCommandSessionObserver interface {
@@ -276,13 +278,20 @@ func (session *ClientCommandSession) runReadLoop() {
 
 	Log.Debugf("[%s] start get_parameter timer.", session.uniqueKey)
 	var r = bufio.NewReader(session.conn)
-	t := time.NewTicker(writeGetParameterIntervalMs * time.Millisecond)
+	// 使用共用的时间轮，避免每个session一个ticker
+	tickChan := make(chan struct{}, 1)
+	t := base.DefaultTimerWheel().Every(writeGetParameterIntervalMs*time.Millisecond, func() {
+		select {
+		case tickChan <- struct{}{}:
+		default:
+		}
+	})
 	defer t.Stop()
 
 	if session.option.OverTcp {
 		for {
 			select {
-			case <-t.C:
+			case <-tickChan:
 				session.cseq++
 				if err := session.writeCmd(MethodGetParameter, session.urlCtx.RawUrlWithoutUserInfo, nil, ""); err != nil {
 					loopErr = err
@@ -311,7 +320,7 @@ func (session *ClientCommandSession) runReadLoop() {
 	// not over tcp
 	for {
 		select {
-		case <-t.C:
+		case <-tickChan:
 			session.cseq++
 			if _, err := session.writeCmdReadResp(MethodGetParameter, session.urlCtx.RawUrlWithoutUserInfo, nil, ""); err != nil {
 				loopErr = err
@@ -335,7 +344,7 @@ func (session *ClientCommandSession) connect(rawUrl string) (err error) {
 	Log.Debugf("[%s] > tcp connect.", session.uniqueKey)
 
 	// # 建立连接
-	conn, err := net.Dial("tcp", session.urlCtx.HostWithPort)
+	conn, err := base.DialTcp(session.urlCtx.HostWithPort, session.option.ProxyUrl)
 	if err != nil {
 		return err
 	}
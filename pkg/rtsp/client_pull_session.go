@@ -27,11 +27,14 @@ type PullSessionOption struct {
 	PullTimeoutMs int
 
 	OverTcp bool // 是否使用interleaved模式，也即是否通过rtsp command tcp连接传输rtp/rtcp数据
+
+	ProxyUrl string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
 }
 
 var defaultPullSessionOption = PullSessionOption{
 	PullTimeoutMs: 10000,
 	OverTcp:       false,
+	ProxyUrl:      "",
 }
 
 type PullSession struct {
@@ -59,6 +62,7 @@ func NewPullSession(observer IPullSessionObserver, modOptions ...ModPullSessionO
 	cmdSession := NewClientCommandSession(CcstPullSession, uk, s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PullTimeoutMs
 		opt.OverTcp = option.OverTcp
+		opt.ProxyUrl = option.ProxyUrl
 	})
 	baseInSession := NewBaseInSessionWithObserver(uk, s, observer)
 	s.baseInSession = baseInSession
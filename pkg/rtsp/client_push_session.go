@@ -21,11 +21,13 @@ import (
 type PushSessionOption struct {
 	PushTimeoutMs int
 	OverTcp       bool
+	ProxyUrl      string // 出口代理地址，为空则不使用代理，格式见 base.DialTcp
 }
 
 var defaultPushSessionOption = PushSessionOption{
 	PushTimeoutMs: 10000,
 	OverTcp:       false,
+	ProxyUrl:      "",
 }
 
 type PushSession struct {
@@ -53,6 +55,7 @@ func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
 	cmdSession := NewClientCommandSession(CcstPushSession, uk, s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PushTimeoutMs
 		opt.OverTcp = option.OverTcp
+		opt.ProxyUrl = option.ProxyUrl
 	})
 	baseOutSession := NewBaseOutSession(uk, s)
 	s.cmdSession = cmdSession
@@ -190,6 +193,7 @@ func (session *PushSession) OnDescribeResponse(sdpCtx sdp.LogicContext) {
 
 // OnSetupWithConn IClientCommandSessionObserver, callback by ClientCommandSession
 func (session *PushSession) OnSetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) {
+	session.baseOutSession.SetPeerAddr(session.cmdSession.RemoteAddr())
 	_ = session.baseOutSession.SetupWithConn(uri, rtpConn, rtcpConn)
 }
 
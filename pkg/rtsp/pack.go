@@ -69,6 +69,13 @@ var ResponsePlayTmpl = "RTSP/1.0 200 OK\r\n" +
 	"Date: %s\r\n" +
 	"\r\n"
 
+// ResponseNotEnoughBandwidthTmpl CSeq
+//
+// rfc2326 11.3.9 453 Not Enough Bandwidth
+var ResponseNotEnoughBandwidthTmpl = "RTSP/1.0 453 Not Enough Bandwidth\r\n" +
+	"CSeq: %s\r\n" +
+	"\r\n"
+
 // rfc2326 10.7 TEARDOWN
 //var RequestTeardownTmpl = "not impl"
 
@@ -105,6 +112,10 @@ func PackResponsePlay(cseq string) string {
 	return fmt.Sprintf(ResponsePlayTmpl, cseq, date)
 }
 
+func PackResponseNotEnoughBandwidth(cseq string) string {
+	return fmt.Sprintf(ResponseNotEnoughBandwidthTmpl, cseq)
+}
+
 func PackResponseTeardown(cseq string) string {
 	return fmt.Sprintf(ResponseTeardownTmpl, cseq)
 }
@@ -13,6 +13,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -83,11 +84,23 @@ var (
 	// TODO chef: 参考协议标准，不要使用固定值
 	sessionId = "191201771"
 
+	// rtsp out session（sub、push）发送rtcp sr的间隔
+	rtcpSrIntervalMs = 5000
+
+	// rtsp out session使用udp发送时，缓存最近发送的rtp包，用于响应对端的NACK重传请求
+	// 注意，使用interleaved（tcp）模式时不需要重传
+	rtpNackCacheSize = 512
+
 	minServerPort = uint16(30000)
 	maxServerPort = uint16(60000)
 
 	unpackerItemMaxSize = 1024
 
+	// rtp over udp输入开启抖动缓冲时，缓冲区最多缓存的rtp包数量
+	jitterBufferWindowSize = 1024
+	// 抖动缓冲定时检查等待超时的间隔，没有新的rtp包到达时，也能按时输出缓冲区中的包
+	jitterBufferDrainInterval = 100 * time.Millisecond
+
 	serverCommandSessionReadBufSize   = 256
 	serverCommandSessionWriteChanSize = 1024
 
@@ -189,6 +202,17 @@ func makeSetupUri(urlCtx base.UrlContext, aControl string) string {
 	return fmt.Sprintf("%s/%s", urlCtx.RawUrlWithoutUserInfo, aControl)
 }
 
+// SetUdpPortRange 设置rtp/rtcp over udp时，本端使用的udp端口范围，默认为[30000, 60000]
+//
+// 防火墙只开放部分端口时使用
+// 注意，需在rtsp server以及rtsp client session启动前调用
+//
+func SetUdpPortRange(minPort, maxPort uint16) {
+	minServerPort = minPort
+	maxServerPort = maxPort
+	availUdpConnPool = nazanet.NewAvailUdpConnPool(minServerPort, maxServerPort)
+}
+
 func init() {
 	availUdpConnPool = nazanet.NewAvailUdpConnPool(minServerPort, maxServerPort)
 }
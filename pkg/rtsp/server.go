@@ -10,6 +10,8 @@ package rtsp
 
 import (
 	"net"
+
+	"github.com/q191201771/lal/pkg/base"
 )
 
 type IServerObserver interface {
@@ -52,23 +54,55 @@ type IServerObserver interface {
 type Server struct {
 	addr     string
 	observer IServerObserver
+	option   ServerOption
 
 	ln net.Listener
 }
 
-func NewServer(addr string, observer IServerObserver) *Server {
+type ServerOption struct {
+	ProxyProtocolEnable bool // 是否解析PROXY protocol头，获取客户端真实地址，见 base.ProxyProtocolListener
+	JitterBufferMs      int  // pub使用rtp over udp时，抖动缓冲的时长，为0表示不开启，见 BaseInSession.SetJitterBuffer
+	ReusePort           bool // 监听时是否设置SO_REUSEPORT，见 base.ListenTcp
+
+	ConnGuard *base.ConnGuard // 防连接风暴，为nil时不开启
+
+	ProxyProtocolTrustedNets []*net.IPNet // 只解析来自这些网段的PROXY protocol头，见 base.NewProxyProtocolListener
+}
+
+var defaultServerOption = ServerOption{
+	ProxyProtocolEnable: false,
+	JitterBufferMs:      0,
+	ReusePort:           false,
+	ConnGuard:           nil,
+}
+
+type ModServerOption func(option *ServerOption)
+
+func NewServer(addr string, observer IServerObserver, modOptions ...ModServerOption) *Server {
+	option := defaultServerOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
 	return &Server{
 		addr:     addr,
 		observer: observer,
+		option:   option,
 	}
 }
 
 func (s *Server) Listen() (err error) {
-	s.ln, err = net.Listen("tcp", s.addr)
+	s.ln, err = base.ListenTcp(s.addr, s.option.ReusePort)
 	if err != nil {
 		return
 	}
-	Log.Infof("start rtsp server listen. addr=%s", s.addr)
+	// 注意，PROXY protocol头的读取也受握手超时的限制
+	if s.option.ConnGuard != nil {
+		s.ln = s.option.ConnGuard.WrapListener(s.ln, true)
+	}
+	if s.option.ProxyProtocolEnable {
+		s.ln = base.NewProxyProtocolListener(s.ln, s.option.ProxyProtocolTrustedNets)
+	}
+	Log.Infof("start rtsp server listen. addr=%s, proxy protocol=%v", s.addr, s.option.ProxyProtocolEnable)
 	return
 }
 
@@ -117,6 +151,7 @@ func (s *Server) OnDelRtspSubSession(session *SubSession) {
 
 func (s *Server) handleTcpConnect(conn net.Conn) {
 	session := NewServerCommandSession(s, conn)
+	session.jitterBufferMs = s.option.JitterBufferMs
 	s.observer.OnNewRtspSessionConnect(session)
 
 	err := session.RunLoop()
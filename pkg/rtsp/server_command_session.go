@@ -10,6 +10,7 @@ package rtsp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -50,6 +51,7 @@ type IServerCommandSessionObserver interface {
 type ServerCommandSession struct {
 	uniqueKey    string                        // const after ctor
 	observer     IServerCommandSessionObserver // const after ctor
+	rawConn      net.Conn                      // 推拉流建立后调用 base.MarkHandshakeDone
 	conn         connection.Connection
 	prevConnStat connection.Stat
 	staleStat    *connection.Stat
@@ -57,6 +59,8 @@ type ServerCommandSession struct {
 
 	pubSession *PubSession
 	subSession *SubSession
+
+	jitterBufferMs int // 见 ServerOption.JitterBufferMs
 }
 
 func NewServerCommandSession(observer IServerCommandSessionObserver, conn net.Conn) *ServerCommandSession {
@@ -64,6 +68,7 @@ func NewServerCommandSession(observer IServerCommandSessionObserver, conn net.Co
 	s := &ServerCommandSession{
 		uniqueKey: uk,
 		observer:  observer,
+		rawConn:   conn,
 		conn: connection.New(conn, func(option *connection.Option) {
 			option.ReadBufSize = serverCommandSessionReadBufSize
 			option.WriteChanSize = serverCommandSessionWriteChanSize
@@ -203,6 +208,9 @@ Loop:
 			Log.Errorf("[%s] handle rtsp message error. err=%+v, ctx=%+v", session.uniqueKey, handleMsgErr, requestCtx)
 			break
 		}
+		if requestCtx.Method == MethodRecord || requestCtx.Method == MethodPlay {
+			base.MarkHandshakeDone(session.rawConn)
+		}
 	}
 
 	_ = session.conn.Close()
@@ -235,6 +243,7 @@ func (session *ServerCommandSession) handleAnnounce(requestCtx nazahttp.HttpReqM
 
 	session.pubSession = NewPubSession(urlCtx, session)
 	Log.Infof("[%s] link new PubSession. [%s]", session.uniqueKey, session.pubSession.uniqueKey)
+	session.pubSession.SetJitterBuffer(session.jitterBufferMs)
 	session.pubSession.InitWithSdp(sdpCtx)
 
 	if err = session.observer.OnNewRtspPubSession(session.pubSession); err != nil {
@@ -263,6 +272,13 @@ func (session *ServerCommandSession) handleDescribe(requestCtx nazahttp.HttpReqM
 		return base.ErrRtspClosedByObserver
 	}
 
+	// 信令连接是IPv6时，sdp中的地址也使用IPv6格式
+	if localHost, _, err := net.SplitHostPort(session.conn.LocalAddr().String()); err == nil {
+		if ip := net.ParseIP(localHost); ip != nil && ip.To4() == nil {
+			rawSdp = sdp.RewriteConnectionAddr(rawSdp, localHost)
+		}
+	}
+
 	sdpCtx, _ := sdp.ParseSdp2LogicContext(rawSdp)
 	session.subSession.InitWithSdp(sdpCtx)
 
@@ -352,6 +368,10 @@ func (session *ServerCommandSession) handlePlay(requestCtx nazahttp.HttpReqMsgCt
 	Log.Infof("[%s] < R PLAY", session.uniqueKey)
 	// TODO(chef): [opt] 上层关闭，可以考虑回复非200状态码再关闭
 	if err := session.observer.OnNewRtspSubSessionPlay(session.subSession); err != nil {
+		// 出口带宽不足时回复453，客户端或者调度服务可以据此换一个节点重试
+		if errors.Is(err, base.ErrAdmissionRejected) {
+			_, _ = session.conn.Write([]byte(PackResponseNotEnoughBandwidth(requestCtx.Headers.Get(HeaderCSeq))))
+		}
 		return err
 	}
 	resp := PackResponsePlay(requestCtx.Headers.Get(HeaderCSeq))
@@ -50,6 +50,10 @@ func (session *PubSession) SetObserver(observer IPubSessionObserver) {
 	session.baseInSession.SetObserver(observer)
 }
 
+func (session *PubSession) SetJitterBuffer(delayMs int) {
+	session.baseInSession.SetJitterBuffer(delayMs)
+}
+
 func (session *PubSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
 	return session.baseInSession.SetupWithConn(uri, rtpConn, rtcpConn)
 }
@@ -85,6 +89,12 @@ func (session *PubSession) StreamName() string {
 	return session.urlCtx.LastItemOfPath
 }
 
+// SetStreamName 修改流名称，用于业务方重写推流的流名称
+//
+func (session *PubSession) SetStreamName(streamName string) {
+	session.urlCtx.LastItemOfPath = streamName
+}
+
 func (session *PubSession) RawQuery() string {
 	return session.urlCtx.RawQuery
 }
@@ -46,6 +46,7 @@ func (session *SubSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 }
 
 func (session *SubSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
+	session.baseOutSession.SetPeerAddr(session.cmdSession.RemoteAddr())
 	return session.baseOutSession.SetupWithConn(uri, rtpConn, rtcpConn)
 }
 
@@ -80,6 +81,12 @@ func (session *SubSession) StreamName() string {
 	return session.urlCtx.LastItemOfPath
 }
 
+// SetStreamName 修改流名称，用于业务方重写拉流的流名称
+//
+func (session *SubSession) SetStreamName(streamName string) {
+	session.urlCtx.LastItemOfPath = streamName
+}
+
 func (session *SubSession) RawQuery() string {
 	return session.urlCtx.RawQuery
 }
@@ -12,6 +12,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
@@ -39,18 +40,22 @@ func Pack(vps, sps, pps, asc []byte) (ctx LogicContext, err error) {
 		return
 	}
 
-	// 判断AAC的采样率
+	// 判断AAC的采样率和声道数，注意，HE-AAC、ELD使用SBR时，使用解码输出的采样率
 	var samplingFrequency int
+	channels := 2
 	if asc != nil {
 		var ascCtx *aac.AscContext
 		ascCtx, err = aac.NewAscContext(asc)
 		if err != nil {
 			return
 		}
-		samplingFrequency, err = ascCtx.GetSamplingFrequency()
+		samplingFrequency, err = ascCtx.GetOutputSamplingFrequency()
 		if err != nil {
 			return
 		}
+		if n := ascCtx.GetOutputChannels(); n != 0 {
+			channels = n
+		}
 	}
 
 	sdpStr := fmt.Sprintf(`v=0
@@ -86,14 +91,48 @@ a=control:streamid=%d
 	if hasAudio {
 		tmpl := `m=audio 0 RTP/AVP 97
 b=AS:128
-a=rtpmap:97 MPEG4-GENERIC/%d/2
+a=rtpmap:97 MPEG4-GENERIC/%d/%d
 a=fmtp:97 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3; config=%s
 a=control:streamid=%d
 `
-		sdpStr += fmt.Sprintf(tmpl, samplingFrequency, hex.EncodeToString(asc), streamid)
+		sdpStr += fmt.Sprintf(tmpl, samplingFrequency, channels, hex.EncodeToString(asc), streamid)
 	}
 
 	raw := []byte(strings.ReplaceAll(sdpStr, "\n", "\r\n"))
 	ctx, err = ParseSdp2LogicContext(raw)
 	return
 }
+
+// RewriteConnectionAddr 将sdp中`o=`和`c=`行中的地址类型和地址替换为`host`
+//
+// 用于lalserver作为rtsp服务端时，使得返回给客户端的sdp中的地址与信令连接的地址族（IPv4或IPv6）保持一致
+//
+// @param host 不带端口的IP地址，IPv6地址不带中括号
+//
+func RewriteConnectionAddr(rawSdp []byte, host string) []byte {
+	connInfo := FormatConnectionInfo(host)
+
+	lines := strings.Split(string(rawSdp), "\r\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "c=IN "):
+			lines[i] = "c=" + connInfo
+		case strings.HasPrefix(line, "o="):
+			// o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+			items := strings.Split(line, " ")
+			if len(items) == 6 {
+				lines[i] = strings.Join(append(items[:3], connInfo), " ")
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+// FormatConnectionInfo 生成sdp中`<nettype> <addrtype> <connection-address>`格式的字符串，比如`IN IP4 127.0.0.1`，`IN IP6 ::1`
+//
+func FormatConnectionInfo(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return fmt.Sprintf("IN IP6 %s", host)
+	}
+	return fmt.Sprintf("IN IP4 %s", host)
+}
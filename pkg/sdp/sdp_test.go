@@ -12,8 +12,20 @@ import (
 	"testing"
 
 	"github.com/q191201771/lal/pkg/innertest"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestSdp(t *testing.T) {
 	innertest.Entry(t)
 }
+
+func TestRewriteConnectionAddr(t *testing.T) {
+	raw := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=No Name\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n"
+	assert.Equal(t, "v=0\r\no=- 0 0 IN IP6 ::1\r\ns=No Name\r\nc=IN IP6 ::1\r\nt=0 0\r\n",
+		string(sdp.RewriteConnectionAddr([]byte(raw), "::1")))
+	assert.Equal(t, raw, string(sdp.RewriteConnectionAddr([]byte(raw), "127.0.0.1")))
+
+	assert.Equal(t, "IN IP4 192.168.0.1", sdp.FormatConnectionInfo("192.168.0.1"))
+	assert.Equal(t, "IN IP6 fe80::1", sdp.FormatConnectionInfo("fe80::1"))
+}
@@ -0,0 +1,203 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package streamedit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// FlvEditor 编辑flv流，输出到`w`
+//
+type FlvEditor struct {
+	w  io.Writer
+	tl *timeline
+
+	fileNum         int
+	headerWritten   bool
+	metadataWritten bool
+	frameWritten    bool
+	seqHeaders      [2][]byte // 每一路最新的seq header
+	writtenSeq      [2][]byte // 每一路最近一次输出的seq header
+}
+
+func NewFlvEditor(w io.Writer, option Option) *FlvEditor {
+	return &FlvEditor{
+		w:  w,
+		tl: newTimeline(option, 1),
+	}
+}
+
+// Feed 输入一个完整的flv文件（包含flv header），多次调用时按顺序拼接
+//
+// 注意，文件尾部不完整的tag会被忽略，比如录制异常中断时
+//
+func (e *FlvEditor) Feed(r io.Reader) error {
+	if e.fileNum > 0 {
+		e.tl.nextFile()
+	}
+	e.fileNum++
+	if err := e.writeHeaderIfNeeded(); err != nil {
+		return err
+	}
+
+	header := make([]byte, len(httpflv.FlvHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	for {
+		tag, err := httpflv.ReadTag(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.FeedTag(tag); err != nil {
+			return err
+		}
+	}
+}
+
+// FeedTag 输入一个tag，用于输入不是来自flv文件的场景，此时不支持拼接
+//
+func (e *FlvEditor) FeedTag(tag httpflv.Tag) error {
+	if err := e.writeHeaderIfNeeded(); err != nil {
+		return err
+	}
+
+	payload := tag.Payload()
+	switch tag.Header.Type {
+	case httpflv.TagTypeMetadata:
+		// 只保留第一个，并且必须在音视频帧之前
+		if e.metadataWritten || e.frameWritten {
+			return nil
+		}
+		e.metadataWritten = true
+		return e.write(httpflv.TagTypeMetadata, 0, payload)
+	case httpflv.TagTypeVideo:
+		if len(payload) < 5 {
+			return nil
+		}
+		if tag.IsVideoKeySeqHeader() {
+			e.seqHeaders[trackVideo] = append([]byte(nil), payload...)
+			return nil
+		}
+		dts := int64(tag.Header.Timestamp)
+		pts := dts + int64(bele.BeUint24(payload[2:]))
+		outDts, _, ok := e.tl.feed(trackVideo, dts, pts, tag.IsVideoKeyNalu())
+		if !ok {
+			return nil
+		}
+		return e.writeFrame(trackVideo, httpflv.TagTypeVideo, outDts, payload)
+	case httpflv.TagTypeAudio:
+		if len(payload) < 2 {
+			return nil
+		}
+		if tag.IsAacSeqHeader() {
+			e.seqHeaders[trackAudio] = append([]byte(nil), payload...)
+			return nil
+		}
+		dts := int64(tag.Header.Timestamp)
+		outDts, _, ok := e.tl.feed(trackAudio, dts, dts, false)
+		if !ok {
+			return nil
+		}
+		return e.writeFrame(trackAudio, httpflv.TagTypeAudio, outDts, payload)
+	}
+	return nil
+}
+
+func (e *FlvEditor) Stat() Stat {
+	return e.tl.result()
+}
+
+func (e *FlvEditor) writeHeaderIfNeeded() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	header := append([]byte(nil), httpflv.FlvHeader...)
+	// TypeFlagsAudio 0x04, TypeFlagsVideo 0x01
+	if e.tl.option.StripVideo {
+		header[4] &^= 0x01
+	}
+	if e.tl.option.StripAudio {
+		header[4] &^= 0x04
+	}
+	_, err := e.w.Write(header)
+	return err
+}
+
+// writeFrame seq header发生变化时，先输出seq header
+//
+func (e *FlvEditor) writeFrame(track int, t uint8, ts int64, payload []byte) error {
+	if seq := e.seqHeaders[track]; seq != nil && !bytes.Equal(seq, e.writtenSeq[track]) {
+		if err := e.write(t, ts, seq); err != nil {
+			return err
+		}
+		e.writtenSeq[track] = seq
+	}
+	e.frameWritten = true
+	return e.write(t, ts, payload)
+}
+
+func (e *FlvEditor) write(t uint8, ts int64, payload []byte) error {
+	_, err := e.w.Write(httpflv.PackHttpflvTag(t, uint32(ts), payload))
+	return err
+}
+
+// EditFlvFile 编辑flv文件，多个输入时拼接
+//
+func EditFlvFile(inFilenames []string, outFilename string, option Option) (Stat, error) {
+	return editFile(inFilenames, outFilename, func(w io.Writer) fileEditor {
+		return NewFlvEditor(w, option)
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type fileEditor interface {
+	Feed(r io.Reader) error
+	Stat() Stat
+}
+
+func editFile(inFilenames []string, outFilename string, newEditor func(w io.Writer) fileEditor) (Stat, error) {
+	fp, err := os.Create(outFilename)
+	if err != nil {
+		return Stat{}, err
+	}
+	defer fp.Close()
+	w := bufio.NewWriter(fp)
+	e := newEditor(w)
+
+	for _, in := range inFilenames {
+		if err := feedFile(e, in); err != nil {
+			return e.Stat(), err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return e.Stat(), err
+	}
+	return e.Stat(), fp.Close()
+}
+
+func feedFile(e fileEditor, filename string) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return e.Feed(bufio.NewReader(fp))
+}
@@ -0,0 +1,270 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package streamedit
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// streamedit 离线编辑flv、ts录制文件
+//
+// 支持：
+//   - 按时间范围裁剪，起始位置对齐到视频关键帧
+//   - 修复时间戳，每一路（音频、视频）的时间戳回退或者大的跳变，修正为上一帧加上正常的帧间隔
+//   - 去掉音频或者视频
+//   - 拼接多个文件，后一个文件的时间戳接在前一个文件之后
+//
+// 都是流式处理，不会将整个文件读入内存。
+// flv的metadata只保留第一个，seq header在每一路第一个输出帧之前、以及发生变化时写入；ts输出时统一使用lal的PID和PAT、PMT
+//
+
+type Option struct {
+	// StartMs, EndMs 裁剪的时间范围[StartMs, EndMs)，相对于第一个输入文件的第一帧，拼接时为拼接后的时间
+	//
+	// 视频从StartMs之后的第一个关键帧开始。EndMs为0表示到结尾
+	//
+	StartMs int64
+	EndMs   int64
+
+	StripVideo bool
+	StripAudio bool
+
+	// FixTimestamp 修复时间戳，相邻两帧的时间戳回退或者差值大于 GapThresholdMs 时，使用上一个正常的帧间隔
+	//
+	FixTimestamp   bool
+	GapThresholdMs int64 // 为0时使用 DefaultGapThresholdMs
+}
+
+var DefaultGapThresholdMs = int64(1000)
+
+// Stat 编辑结果的统计
+//
+type Stat struct {
+	InVideoFrames  int
+	InAudioFrames  int
+	OutVideoFrames int
+	OutAudioFrames int
+	FixedFrames    int   // 时间戳被修复的帧数
+	DurationMs     int64 // 输出的时长
+}
+
+// EditFile 按`option`编辑`inFilenames`，输出到`outFilename`，多个输入时拼接
+//
+// 按文件后缀（.flv、.ts）判断格式，输入输出必须为同一种格式
+//
+func EditFile(inFilenames []string, outFilename string, option Option) (Stat, error) {
+	format := FileFormat(outFilename)
+	if format == "" || len(inFilenames) == 0 {
+		return Stat{}, base.ErrStreamEditFormat
+	}
+	for _, in := range inFilenames {
+		if FileFormat(in) != format {
+			return Stat{}, base.ErrStreamEditFormat
+		}
+	}
+	if format == FormatTs {
+		return EditTsFile(inFilenames, outFilename, option)
+	}
+	return EditFlvFile(inFilenames, outFilename, option)
+}
+
+const (
+	FormatFlv = "flv"
+	FormatTs  = "ts"
+)
+
+// FileFormat 根据文件后缀返回 FormatFlv 或 FormatTs ，不支持时返回空字符串
+//
+func FileFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".flv":
+		return FormatFlv
+	case ".ts":
+		return FormatTs
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+const (
+	trackVideo = 0
+	trackAudio = 1
+)
+
+// 还没有正常的帧间隔时，修复时间戳使用的默认值
+var defaultFrameIntervalMs = [2]int64{40, 23}
+
+type trackState struct {
+	has      bool  // 是否已经有帧
+	prevOut  int64 // 上一帧修正后的时间戳（拼接后的时间线上）
+	lastDiff int64 // 上一个正常的帧间隔
+	corr     int64 // 当前的修正值
+}
+
+// timeline 时间戳的计算，和具体的文件格式无关
+//
+// 时间戳的单位为tick，flv为毫秒，ts为1/90000秒
+//
+type timeline struct {
+	option     Option
+	ticksPerMs int64
+	rebase     bool // 输出的时间戳是否从0开始
+
+	fileIndex   int
+	inFirstTs   int64 // 当前输入文件的第一帧，-1表示还没有
+	fileBase    int64 // 当前输入文件在拼接后时间线上的起始位置
+	origin      int64 // 拼接后时间线的起始位置
+	maxTs       int64 // 拼接后时间线上已经出现的最大时间戳
+	videoKeyOk  bool  // 当前输入文件的视频是否已经从关键帧开始
+	tracks      [2]trackState
+	outFirstSet bool
+	outFirstTs  int64
+	outLastTs   int64
+
+	stat Stat
+}
+
+func newTimeline(option Option, ticksPerMs int64) *timeline {
+	if option.GapThresholdMs <= 0 {
+		option.GapThresholdMs = DefaultGapThresholdMs
+	}
+	return &timeline{
+		option:     option,
+		ticksPerMs: ticksPerMs,
+		rebase:     option.StartMs > 0 || option.FixTimestamp,
+		inFirstTs:  -1,
+	}
+}
+
+// nextFile 开始处理下一个输入文件
+//
+func (tl *timeline) nextFile() {
+	tl.fileIndex++
+	tl.inFirstTs = -1
+	tl.videoKeyOk = false
+	for i := range tl.tracks {
+		tl.tracks[i].corr = 0
+	}
+}
+
+// feed 计算一帧的输出时间戳
+//
+// @return ok 为false表示这一帧不输出
+//
+func (tl *timeline) feed(track int, dts int64, pts int64, key bool) (outDts int64, outPts int64, ok bool) {
+	if track == trackVideo {
+		tl.stat.InVideoFrames++
+		if tl.option.StripVideo {
+			return 0, 0, false
+		}
+	} else {
+		tl.stat.InAudioFrames++
+		if tl.option.StripAudio {
+			return 0, 0, false
+		}
+	}
+
+	// 映射到拼接后的时间线上
+	if tl.inFirstTs == -1 {
+		tl.inFirstTs = dts
+		if tl.fileIndex == 0 {
+			if tl.rebase {
+				tl.fileBase = 0
+			} else {
+				tl.fileBase = dts
+			}
+			tl.origin = tl.fileBase
+		} else {
+			tl.fileBase = tl.maxTs + tl.frameGap()
+		}
+	}
+	t := dts - tl.inFirstTs + tl.fileBase
+
+	ts := &tl.tracks[track]
+	if tl.option.FixTimestamp && ts.has {
+		diff := t + ts.corr - ts.prevOut
+		if diff < 0 || diff > tl.option.GapThresholdMs*tl.ticksPerMs {
+			interval := ts.lastDiff
+			if interval == 0 {
+				interval = defaultFrameIntervalMs[track] * tl.ticksPerMs
+			}
+			ts.corr = ts.prevOut + interval - t
+			tl.stat.FixedFrames++
+		} else if diff > 0 {
+			ts.lastDiff = diff
+		}
+	} else if ts.has && t > ts.prevOut {
+		ts.lastDiff = t - ts.prevOut
+	}
+	t += ts.corr
+	ts.has = true
+	ts.prevOut = t
+	if t > tl.maxTs {
+		tl.maxTs = t
+	}
+
+	// 裁剪
+	rel := t - tl.origin
+	if tl.option.StartMs > 0 && rel < tl.option.StartMs*tl.ticksPerMs {
+		return 0, 0, false
+	}
+	if tl.option.EndMs > 0 && rel >= tl.option.EndMs*tl.ticksPerMs {
+		return 0, 0, false
+	}
+	if track == trackVideo && !tl.videoKeyOk {
+		if !key {
+			return 0, 0, false
+		}
+		tl.videoKeyOk = true
+	}
+
+	outDts = t - tl.option.StartMs*tl.ticksPerMs
+	// 第一个文件中比第一帧更早的另一路的帧
+	if tl.rebase && outDts < 0 {
+		outDts = 0
+	}
+	outPts = outDts + (pts - dts)
+	if !tl.outFirstSet {
+		tl.outFirstSet = true
+		tl.outFirstTs = outDts
+	}
+	if outDts > tl.outLastTs {
+		tl.outLastTs = outDts
+	}
+	if track == trackVideo {
+		tl.stat.OutVideoFrames++
+	} else {
+		tl.stat.OutAudioFrames++
+	}
+	return outDts, outPts, true
+}
+
+// frameGap 拼接时，两个文件之间的间隔，使用已知的帧间隔，都不知道时为1毫秒
+//
+func (tl *timeline) frameGap() int64 {
+	gap := tl.ticksPerMs
+	for _, ts := range tl.tracks {
+		if ts.lastDiff > gap {
+			gap = ts.lastDiff
+		}
+	}
+	return gap
+}
+
+func (tl *timeline) result() Stat {
+	stat := tl.stat
+	if tl.outFirstSet {
+		stat.DurationMs = (tl.outLastTs - tl.outFirstTs) / tl.ticksPerMs
+	}
+	return stat
+}
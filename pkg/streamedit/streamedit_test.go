@@ -0,0 +1,168 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package streamedit_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/integration"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/streamedit"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestEditFlv(t *testing.T) {
+	tags := integration.GenerateTags(integration.DefaultStreamOption)
+	stat := integration.StatTags(tags)
+
+	// 不做任何修改，seq header移到了每一路的第一帧之前
+	out, s := editFlv(t, [][]httpflv.Tag{tags}, streamedit.Option{})
+	integration.AssertTimestampMonotonic(t, out)
+	outStat := integration.StatTags(out)
+	assert.Equal(t, stat, outStat)
+	assert.Equal(t, stat.Video, s.OutVideoFrames)
+	assert.Equal(t, stat.Audio, s.OutAudioFrames)
+
+	// 裁剪
+	out, s = editFlv(t, [][]httpflv.Tag{tags}, streamedit.Option{StartMs: 1000, EndMs: 2000})
+	integration.AssertTimestampMonotonic(t, out)
+	assert.Equal(t, 25, s.OutVideoFrames)
+	assert.Equal(t, true, out[0].IsMetadata())
+	assert.Equal(t, true, out[1].IsVideoKeySeqHeader())
+	assert.Equal(t, true, out[2].IsVideoKeyNalu())
+	assert.Equal(t, uint32(0), out[2].Header.Timestamp)
+	assert.Equal(t, true, s.DurationMs < 1000)
+
+	// 去掉音频
+	out, s = editFlv(t, [][]httpflv.Tag{tags}, streamedit.Option{StripAudio: true})
+	integration.AssertFrameCount(t, out, stat.Video, 0)
+	assert.Equal(t, stat.Audio, s.InAudioFrames)
+
+	// 拼接
+	out, s = editFlv(t, [][]httpflv.Tag{tags, tags}, streamedit.Option{})
+	integration.AssertTimestampMonotonic(t, out)
+	integration.AssertFrameCount(t, out, stat.Video*2, stat.Audio*2)
+	assert.Equal(t, true, s.DurationMs > 5900 && s.DurationMs < 6100)
+
+	// 修复时间戳，中间的帧向后跳变10秒
+	var jumped []httpflv.Tag
+	for _, tag := range tags {
+		tag.Raw = append([]byte(nil), tag.Raw...)
+		if tag.Header.Timestamp > 1500 {
+			tag.ModTagTimestamp(tag.Header.Timestamp + 10000)
+		}
+		jumped = append(jumped, tag)
+	}
+	_, s = editFlv(t, [][]httpflv.Tag{jumped}, streamedit.Option{})
+	assert.Equal(t, true, s.DurationMs > 10000)
+	out, s = editFlv(t, [][]httpflv.Tag{jumped}, streamedit.Option{FixTimestamp: true})
+	integration.AssertTimestampMonotonic(t, out)
+	assert.Equal(t, 2, s.FixedFrames)
+	assert.Equal(t, true, s.DurationMs < 3100)
+}
+
+func TestEditTs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_streamedit")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.ts")
+	assert.Equal(t, nil, ioutil.WriteFile(in, tags2Ts(integration.GenerateTags(integration.DefaultStreamOption)), 0644))
+	inVideo, inAudio := countTsFrames(t, in)
+
+	outFilename := filepath.Join(dir, "out.ts")
+	s, err := streamedit.EditFile([]string{in, in}, outFilename, streamedit.Option{})
+	assert.Equal(t, nil, err)
+	video, audio := countTsFrames(t, outFilename)
+	assert.Equal(t, inVideo*2, video)
+	assert.Equal(t, inAudio*2, audio)
+	assert.Equal(t, video, s.OutVideoFrames)
+	assert.Equal(t, true, s.DurationMs > 5900 && s.DurationMs < 6100)
+
+	s, err = streamedit.EditFile([]string{in}, outFilename, streamedit.Option{StartMs: 1000, EndMs: 2000, StripAudio: true})
+	assert.Equal(t, nil, err)
+	video, audio = countTsFrames(t, outFilename)
+	assert.Equal(t, 25, video)
+	assert.Equal(t, 0, audio)
+	assert.Equal(t, 25, s.OutVideoFrames)
+
+	_, err = streamedit.EditFile([]string{in}, filepath.Join(dir, "out.flv"), streamedit.Option{})
+	assert.Equal(t, base.ErrStreamEditFormat, err)
+}
+
+func editFlv(t *testing.T, inputs [][]httpflv.Tag, option streamedit.Option) ([]httpflv.Tag, streamedit.Stat) {
+	var out bytes.Buffer
+	e := streamedit.NewFlvEditor(&out, option)
+	for _, tags := range inputs {
+		var in bytes.Buffer
+		in.Write(httpflv.FlvHeader)
+		for _, tag := range tags {
+			in.Write(tag.Raw)
+		}
+		assert.Equal(t, nil, e.Feed(&in))
+	}
+
+	_, err := io.ReadFull(&out, make([]byte, len(httpflv.FlvHeader)))
+	assert.Equal(t, nil, err)
+	var tags []httpflv.Tag
+	for {
+		tag, err := httpflv.ReadTag(&out)
+		if err == io.EOF {
+			break
+		}
+		assert.Equal(t, nil, err)
+		tags = append(tags, tag)
+	}
+	return tags, e.Stat()
+}
+
+type tsCollector struct {
+	buf bytes.Buffer
+}
+
+func (c *tsCollector) OnPatPmt(b []byte) {
+	c.buf.Write(b)
+}
+
+func (c *tsCollector) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	c.buf.Write(tsPackets)
+}
+
+func tags2Ts(tags []httpflv.Tag) []byte {
+	var c tsCollector
+	r := remux.NewRtmp2MpegtsRemuxer(&c)
+	for _, tag := range tags {
+		r.FeedRtmpMessage(remux.FlvTag2RtmpMsg(tag))
+	}
+	r.Dispose()
+	return c.buf.Bytes()
+}
+
+func countTsFrames(t *testing.T, filename string) (video int, audio int) {
+	content, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	d := mpegts.NewDemuxer(func(pt base.AvPacketPt, frame *mpegts.Frame) {
+		if pt == base.AvPacketPtAac {
+			audio++
+		} else {
+			video++
+		}
+	})
+	d.Feed(content)
+	d.Flush()
+	return
+}
@@ -0,0 +1,171 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package streamedit
+
+import (
+	"io"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// 每次从输入读取的ts包数量
+const tsReadPacketNum = 1024
+
+// ts的时间戳为33位
+const tsTimestampWrap = int64(1) << 33
+
+// TsEditor 编辑ts流，输出到`w`
+//
+// 输出的视频、音频分别使用PID mpegts.PidVideo 、 mpegts.PidAudio ，文件开始处写入一次PAT、PMT
+//
+type TsEditor struct {
+	w  io.Writer
+	tl *timeline
+
+	fileNum       int
+	headerWritten bool
+	videoPt       base.AvPacketPt // 第一个视频帧的编码类型，用于选择PMT
+	cc            [2]uint8
+	prevTs        [2]int64 // 用于处理时间戳回绕，-1表示还没有
+	wrapOffset    [2]int64
+	err           error
+}
+
+func NewTsEditor(w io.Writer, option Option) *TsEditor {
+	return &TsEditor{
+		w:       w,
+		tl:      newTimeline(option, 90),
+		videoPt: base.AvPacketPtUnknown,
+	}
+}
+
+// Feed 输入一个完整的ts文件，多次调用时按顺序拼接
+//
+func (e *TsEditor) Feed(r io.Reader) error {
+	if e.fileNum > 0 {
+		e.tl.nextFile()
+	}
+	e.fileNum++
+	e.prevTs = [2]int64{-1, -1}
+	e.wrapOffset = [2]int64{}
+
+	d := mpegts.NewDemuxer(e.onFrame)
+	buf := make([]byte, tsReadPacketNum*188)
+	for {
+		n, err := io.ReadFull(r, buf)
+		// 尾部不完整的ts包被丢弃
+		d.Feed(buf[:n-n%188])
+		if e.err != nil {
+			return e.err
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.Flush()
+	return e.err
+}
+
+func (e *TsEditor) Stat() Stat {
+	return e.tl.result()
+}
+
+func (e *TsEditor) onFrame(pt base.AvPacketPt, frame *mpegts.Frame) {
+	if e.err != nil {
+		return
+	}
+
+	var track int
+	var key bool
+	switch pt {
+	case base.AvPacketPtAvc, base.AvPacketPtHevc:
+		track = trackVideo
+		if e.videoPt == base.AvPacketPtUnknown {
+			e.videoPt = pt
+		}
+		_ = avc.IterateNaluAnnexb(frame.Raw, func(nal []byte) {
+			if len(nal) == 0 {
+				return
+			}
+			if pt == base.AvPacketPtAvc {
+				key = key || avc.ParseNaluType(nal[0]) == avc.NaluTypeIdrSlice
+			} else {
+				key = key || hevc.IsIrapNalu(hevc.ParseNaluType(nal[0]))
+			}
+		})
+	case base.AvPacketPtAac:
+		track = trackAudio
+	default:
+		return
+	}
+
+	dts := e.unwrap(track, frame.Dts)
+	pts := dts + int64(frame.Pts) - int64(frame.Dts)
+	outDts, outPts, ok := e.tl.feed(track, dts, pts, key)
+	if !ok {
+		return
+	}
+	if outPts < 0 {
+		outPts = outDts
+	}
+
+	if !e.headerWritten {
+		e.headerWritten = true
+		header := mpegts.FixedFragmentHeader
+		if e.videoPt == base.AvPacketPtHevc {
+			header = mpegts.FixedFragmentHeaderHevc
+		}
+		if _, e.err = e.w.Write(header); e.err != nil {
+			return
+		}
+	}
+
+	out := mpegts.Frame{
+		Pts: uint64(outPts),
+		Dts: uint64(outDts),
+		Cc:  e.cc[track],
+		Key: key,
+		Raw: frame.Raw,
+	}
+	if track == trackVideo {
+		out.Pid = mpegts.PidVideo
+		out.Sid = mpegts.StreamIdVideo
+	} else {
+		out.Pid = mpegts.PidAudio
+		out.Sid = mpegts.StreamIdAudio
+	}
+	packets := out.Pack()
+	e.cc[track] = out.Cc
+	_, e.err = e.w.Write(packets)
+}
+
+// unwrap 时间戳回绕时加上2^33，使得时间戳连续
+//
+func (e *TsEditor) unwrap(track int, ts uint64) int64 {
+	v := int64(ts)
+	if prev := e.prevTs[track]; prev != -1 && prev-v > tsTimestampWrap/2 {
+		e.wrapOffset[track] += tsTimestampWrap
+	}
+	e.prevTs[track] = v
+	return v + e.wrapOffset[track]
+}
+
+// EditTsFile 编辑ts文件，多个输入时拼接
+//
+func EditTsFile(inFilenames []string, outFilename string, option Option) (Stat, error) {
+	return editFile(inFilenames, outFilename, func(w io.Writer) fileEditor {
+		return NewTsEditor(w, option)
+	})
+}